@@ -0,0 +1,129 @@
+// Package cdc implements content-defined chunking based on FastCDC.
+// Chunk boundaries depend only on the content,
+// so the same regions of different streams are split into the same chunks.
+package cdc
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+const (
+	MinAvgSize     = 256
+	MaxAvgSize     = 64 * 1024 * 1024
+	DefaultAvgSize = 1024 * 1024
+)
+
+var ErrInvalidAvgSize = errors.New("invalid average chunk size")
+
+// gear is the random table of the rolling hash.
+// It's generated by a fixed-seed splitmix64, so boundaries are stable across versions.
+var gear [256]uint64
+
+func init() {
+	seed := uint64(0x676f2d6472697665)
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// highBitsMask returns a mask with the n highest bits set
+func highBitsMask(n int) uint64 {
+	return ((uint64(1) << uint(n)) - 1) << uint(64-n)
+}
+
+// Chunker splits a stream into variable-size chunks,
+// the sizes are between avg/4 and avg*8, and are normally distributed around avg.
+type Chunker struct {
+	r   io.Reader
+	min int
+	avg int
+	max int
+
+	// maskS is used before avg, maskL after avg, see FastCDC's normalized chunking
+	maskS uint64
+	maskL uint64
+
+	buf []byte
+	n   int
+	eof bool
+}
+
+// NewChunker creates a Chunker, avgSize will be rounded down to a power of 2
+func NewChunker(r io.Reader, avgSize int) (*Chunker, error) {
+	if avgSize < MinAvgSize || avgSize > MaxAvgSize {
+		return nil, ErrInvalidAvgSize
+	}
+	b := bits.Len(uint(avgSize)) - 1
+	avg := 1 << uint(b)
+	c := &Chunker{
+		r:     r,
+		min:   avg / 4,
+		avg:   avg,
+		max:   avg * 8,
+		maskS: highBitsMask(b + 1),
+		maskL: highBitsMask(b - 1),
+	}
+	c.buf = make([]byte, c.max)
+	return c, nil
+}
+
+func (c *Chunker) fill() error {
+	for c.n < c.max && !c.eof {
+		m, e := c.r.Read(c.buf[c.n:])
+		c.n += m
+		if e == io.EOF {
+			c.eof = true
+		} else if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.min {
+		return n
+	}
+	normal := c.avg
+	if n < normal {
+		normal = n
+	}
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// Next returns the next chunk, io.EOF is returned when there are no more chunks
+func (c *Chunker) Next() ([]byte, error) {
+	if e := c.fill(); e != nil {
+		return nil, e
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+	size := c.cut(c.buf[:c.n])
+	chunk := make([]byte, size)
+	copy(chunk, c.buf[:size])
+	copy(c.buf, c.buf[size:c.n])
+	c.n -= size
+	return chunk, nil
+}
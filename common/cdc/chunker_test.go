@@ -0,0 +1,79 @@
+package cdc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func split(t *testing.T, data []byte, avg int) [][]byte {
+	c, e := NewChunker(bytes.NewReader(data), avg)
+	if e != nil {
+		t.Fatal(e)
+	}
+	chunks := make([][]byte, 0)
+	for {
+		chunk, e := c.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			t.Fatal(e)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func randomBytes(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func TestChunkerReassemble(t *testing.T) {
+	data := randomBytes(1, 1024*1024)
+	chunks := split(t, data, 4096)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Errorf("reassembled content mismatch")
+	}
+	for i, c := range chunks {
+		if len(c) > 4096*8 {
+			t.Errorf("chunk %d: size %d exceeds max", i, len(c))
+		}
+		if i < len(chunks)-1 && len(c) < 4096/4 {
+			t.Errorf("chunk %d: size %d below min", i, len(c))
+		}
+	}
+	if len(split(t, nil, 4096)) != 0 {
+		t.Errorf("expect no chunks for empty input")
+	}
+}
+
+func TestChunkerSharedRegions(t *testing.T) {
+	common := randomBytes(2, 512*1024)
+	a := append(randomBytes(3, 10000), common...)
+	b := append(randomBytes(4, 777), common...)
+
+	hashes := make(map[[32]byte]bool)
+	for _, c := range split(t, a, 4096) {
+		hashes[sha256.Sum256(c)] = true
+	}
+	shared := 0
+	for _, c := range split(t, b, 4096) {
+		if hashes[sha256.Sum256(c)] {
+			shared += len(c)
+		}
+	}
+	if shared < len(common)*9/10 {
+		t.Errorf("expect most of the common region to be shared, but only %d bytes", shared)
+	}
+}
+
+func TestChunkerInvalidSize(t *testing.T) {
+	if _, e := NewChunker(bytes.NewReader(nil), 1); e != ErrInvalidAvgSize {
+		t.Errorf("expect ErrInvalidAvgSize, but is %v", e)
+	}
+}
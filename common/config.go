@@ -42,13 +42,57 @@ func InitConfig(ch *registry.ComponentsHolder) (Config, error) {
 
 	flag.Int64Var(&config.ProxyMaxSize, "proxy-max-size", 1*1024*1024, "maximum file size that can be proxied")
 
+	flag.Int64Var(&config.DownloadRateLimit, "download-rate-limit", 0, "maximum bytes per second of a download served by this server, 0 means unlimited")
+	flag.Int64Var(&config.DownloadSharedRateLimit, "download-shared-rate-limit", 0, "maximum total bytes per second of all downloads served by this server, shared fairly among the active downloads, 0 means unlimited")
+	flag.Int64Var(&config.UploadRateLimit, "upload-rate-limit", 0, "maximum bytes per second of an upload to this server, 0 means unlimited")
+
+	flag.Int64Var(&config.TransferMemoryLimit, "transfer-memory-limit", 256*1024*1024, "maximum total bytes of the buffers used by concurrent transfers, 0 means unlimited")
+
 	flag.Int64Var(&config.ThumbnailMaxSize, "thumbnail-max-size", 16*1024*1024, "maximum file size to create thumbnail")
 	flag.IntVar(&config.ThumbnailMaxPixels, "thumbnail-max-pixels", 22369621, "maximum pixels(W*H) of original image to thumbnails")
 	flag.IntVar(&config.ThumbnailConcurrent, "thumbnail-concurrent", 16, "maximum number of concurrent creation of thumbnails")
 	flag.DurationVar(&config.ThumbnailCacheTTl, "thumbnail-cache-ttl", 48*time.Hour, "thumbnail cache validity")
 
+	flag.StringVar(&config.FFmpegPath, "ffmpeg", "ffmpeg", "path to the ffmpeg executable used to transcode uploaded videos, empty to disable")
+	flag.StringVar(&config.FFprobePath, "ffprobe", "ffprobe", "path to the ffprobe executable used to detect the codecs of the videos for HLS")
+	flag.StringVar(&config.HLSHWAccel, "hls-hwaccel", "", "hardware acceleration of the HLS transcoding, one of 'vaapi', 'cuda', 'qsv', 'videotoolbox', empty to encode by CPU")
+	flag.StringVar(&config.HLSHWAccelDevice, "hls-hwaccel-device", "", "device of the hardware acceleration, e.g. '/dev/dri/renderD128' for vaapi")
+	flag.Int64Var(&config.HLSMaxBitrate, "hls-max-bitrate", 8*1000*1000, "videos above this bitrate(bits per second) are re-encoded to it for HLS, 0 means unlimited")
+	flag.IntVar(&config.HLSConcurrent, "hls-concurrent", 2, "maximum number of concurrent HLS transcoding")
+	flag.DurationVar(&config.HLSCacheTTL, "hls-cache-ttl", 24*time.Hour, "HLS segments cache validity")
+	flag.DurationVar(&config.MediaInfoCacheTTL, "media-info-cache-ttl", 30*24*time.Hour, "media info cache validity")
+	flag.StringVar(&config.SearchPaths, "search", "", "comma separated paths indexed for the search, e.g. 'docs,photos/2020', '/' for all, empty to disable")
+	flag.Int64Var(&config.SearchContentMaxSize, "search-content-max-size", 1*1024*1024, "text files up to this size are indexed by the contents as well as the names, 0 to index the names only")
+	flag.DurationVar(&config.SearchReindexInterval, "search-reindex-interval", 6*time.Hour, "interval of reindexing the search paths entirely, which catches the changes made outside of this server, 0 to reindex at startup only")
+	flag.StringVar(&config.GitPath, "git", "git", "path to the git executable used by the git drives, empty to disable")
+
 	flag.IntVar(&config.MaxConcurrentTask, "max-concurrent-task", 100, "maximum concurrent task(copy, move, upload, delete files)")
 
+	flag.StringVar(&config.Locker, "locker", "memory", "path locker: 'memory', or 'db' to coordinate instances sharing the data dir, which should not be on a network file system")
+	flag.DurationVar(&config.LockTTL, "lock-ttl", 30*time.Second, "time to live of a 'db' lock, locks are renewed while being held")
+
+	flag.DurationVar(&config.IdempotencyKeyTTL, "idempotency-key-ttl", 24*time.Hour, "how long the result of a request with an Idempotency-Key is kept for replaying")
+
+	flag.StringVar(&config.AuditLog, "audit-log", "", "path to the audit log file of all mutations, empty to disable")
+
+	flag.StringVar(&config.WebDAVPrefix, "webdav", "", "path prefix of the WebDAV endpoint serving the drives, e.g. '/dav', empty to disable")
+
+	flag.StringVar(&config.FTPListen, "ftp", "", "address of the FTP server serving the drives, e.g. ':2121', empty to disable")
+	flag.StringVar(&config.FTPPassivePorts, "ftp-passive-ports", "", "port range of the FTP passive data connections, e.g. '30000-30100', empty for any free port")
+	flag.StringVar(&config.FTPPublicIP, "ftp-public-ip", "", "IPv4 address told to the FTP clients for passive data connections, defaults to the address the client connected to")
+	flag.StringVar(&config.FTPTLSCert, "ftp-tls-cert", "", "certificate file enabling explicit FTPS(AUTH TLS)")
+	flag.StringVar(&config.FTPTLSKey, "ftp-tls-key", "", "private key file of the FTPS certificate")
+
+	flag.StringVar(&config.SFTPListen, "sftp", "", "address of the SFTP server serving the drives, e.g. ':2022', empty to disable. "+
+		"The host key and the authorized keys of the users(authorized_keys/<username>) are in the 'sftp' dir of the data dir. "+
+		"Only the SFTP subsystem is served, rsync or scp over SSH is not supported")
+
+	flag.StringVar(&config.OnlyOfficeURL, "onlyoffice", "", "URL of the OnlyOffice Document Server for editing the office documents, e.g. 'https://office.example.com', empty to disable")
+	flag.StringVar(&config.OnlyOfficeSecret, "onlyoffice-secret", "", "JWT secret shared with the OnlyOffice Document Server, empty if JWT is disabled there")
+	flag.StringVar(&config.OnlyOfficeCallbackURL, "onlyoffice-callback-url", "", "URL of the API of this server reached by the Document Server, e.g. 'http://go-drive:8089', defaults to the URL requested by the browser")
+	flag.StringVar(&config.WOPIClientURL, "wopi", "", "URL of the WOPI client like Collabora Online for editing the office documents, e.g. 'https://collabora.example.com', empty to disable")
+	flag.StringVar(&config.WOPIHostURL, "wopi-host-url", "", "URL of the API of this server reached by the WOPI client, e.g. 'http://go-drive:8089', defaults to the URL requested by the browser")
+
 	flag.DurationVar(&config.TokenValidity, "token-validity", 2*time.Hour, "token validity")
 	flag.BoolVar(&config.TokenRefresh, "token-refresh", true, "enable auto refresh token")
 
@@ -95,14 +139,87 @@ type Config struct {
 	// The size is unlimited when maxProxySize is <= 0
 	ProxyMaxSize int64
 
+	// DownloadRateLimit and UploadRateLimit are the bandwidth limits(bytes per second)
+	// of the transfers through this server, unlimited when <= 0
+	DownloadRateLimit int64
+	UploadRateLimit   int64
+
+	// DownloadSharedRateLimit is the total bandwidth(bytes per second) of the downloads served by this server,
+	// which is shared fairly among the active downloads, unlimited when <= 0
+	DownloadSharedRateLimit int64
+
+	// TransferMemoryLimit is the maximum total size of the transfer buffers, unlimited when <= 0
+	TransferMemoryLimit int64
+
 	// ThumbnailMaxSize is the maximum file size(MB) to create thumbnail
 	ThumbnailMaxSize    int64
 	ThumbnailCacheTTl   time.Duration
 	ThumbnailConcurrent int
 	ThumbnailMaxPixels  int
 
+	// FFmpegPath is the ffmpeg executable for transcoding videos
+	FFmpegPath string
+
+	// FFprobePath is the ffprobe executable for detecting the codecs of the videos.
+	// HLSHWAccel and HLSHWAccelDevice are the ffmpeg hardware acceleration of the HLS transcoding,
+	// HLSMaxBitrate is the maximum bitrate(bits per second) of the HLS videos, unlimited when <= 0
+	FFprobePath      string
+	HLSHWAccel       string
+	HLSHWAccelDevice string
+	HLSMaxBitrate    int64
+	HLSConcurrent    int
+	HLSCacheTTL      time.Duration
+
+	// MediaInfoCacheTTL is the validity of the cached metadata of the media files
+	MediaInfoCacheTTL time.Duration
+
+	// SearchPaths are the comma separated paths indexed for the search, the search is disabled if it's empty.
+	// The contents of the text files up to SearchContentMaxSize are indexed, and the paths are reindexed every SearchReindexInterval
+	SearchPaths           string
+	SearchContentMaxSize  int64
+	SearchReindexInterval time.Duration
+
+	// GitPath is the git executable for the git drives
+	GitPath string
+
+	// WebDAVPrefix is the path prefix of the WebDAV endpoint, WebDAV is disabled if it's empty
+	WebDAVPrefix string
+
+	// FTPListen is the address of the FTP server, FTP is disabled if it's empty.
+	// FTPPassivePorts is the port range like '30000-30100' of the passive data connections,
+	// and FTPPublicIP is the address told to the clients behind NAT.
+	// Explicit FTPS is enabled if FTPTLSCert and FTPTLSKey are set
+	FTPListen       string
+	FTPPassivePorts string
+	FTPPublicIP     string
+	FTPTLSCert      string
+	FTPTLSKey       string
+
+	// SFTPListen is the address of the SFTP server, SFTP is disabled if it's empty
+	SFTPListen string
+
+	// OnlyOfficeURL is the URL of the OnlyOffice Document Server, the integration is disabled if it's empty.
+	// OnlyOfficeSecret is the JWT secret, and OnlyOfficeCallbackURL is the URL of this server for the Document Server
+	OnlyOfficeURL         string
+	OnlyOfficeSecret      string
+	OnlyOfficeCallbackURL string
+	// WOPIClientURL is the URL of the WOPI client, the WOPI host is disabled if it's empty.
+	// WOPIHostURL is the URL of this server for the WOPI client
+	WOPIClientURL string
+	WOPIHostURL   string
+
+	// AuditLog is the file where the audit records are appended to, auditing is disabled if it's empty
+	AuditLog string
+
 	MaxConcurrentTask int
 
+	// Locker is the type of the path locker, 'memory' or 'db'
+	Locker  string
+	LockTTL time.Duration
+
+	// IdempotencyKeyTTL is how long the results of the idempotent requests are kept
+	IdempotencyKeyTTL time.Duration
+
 	TokenValidity time.Duration
 	TokenRefresh  bool
 }
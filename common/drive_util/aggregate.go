@@ -0,0 +1,69 @@
+package drive_util
+
+import (
+	"context"
+	"go-drive/common/errors"
+	"go-drive/common/types"
+	"strings"
+	"sync"
+)
+
+// AggregateCache caches the values computed from directory subtrees, such as total size.
+// A cached value is reused only if the IDirSignature of the directory is unchanged,
+// the value is always recomputed if the drive does not implement IDirSignature.
+type AggregateCache struct {
+	mux   sync.Mutex
+	items map[string]aggregateItem
+}
+
+type aggregateItem struct {
+	signature string
+	value     interface{}
+}
+
+func NewAggregateCache() *AggregateCache {
+	return &AggregateCache{items: make(map[string]aggregateItem)}
+}
+
+// Get returns the cached value of the path, or computes it if the subtree changed.
+// key distinguishes different aggregates of the same path.
+func (a *AggregateCache) Get(ctx context.Context, drive types.IDrive, path, key string,
+	compute func() (interface{}, error)) (interface{}, error) {
+	ds, ok := drive.(types.IDirSignature)
+	if !ok {
+		return compute()
+	}
+	signature, e := ds.DirSignature(ctx, path)
+	if e != nil {
+		if err.IsUnsupportedError(e) {
+			return compute()
+		}
+		return nil, e
+	}
+	itemKey := key + "\x00" + path
+	a.mux.Lock()
+	item, ok := a.items[itemKey]
+	a.mux.Unlock()
+	if ok && item.signature == signature {
+		return item.value, nil
+	}
+	value, e := compute()
+	if e != nil {
+		return nil, e
+	}
+	a.mux.Lock()
+	a.items[itemKey] = aggregateItem{signature: signature, value: value}
+	a.mux.Unlock()
+	return value, nil
+}
+
+// Evict removes the cached values of the path
+func (a *AggregateCache) Evict(path string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	for k := range a.items {
+		if strings.HasSuffix(k, "\x00"+path) {
+			delete(a.items, k)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package drive_util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/sevenzip"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// GetArchive returns the archive view of the content, the archive type is detected by the name.
+// Zip and 7z archives are read by random access, via range requests if the content supports,
+// otherwise the content is downloaded to tempDir if it's not a local file.
+// Tar archives(optionally gzipped) are scanned sequentially.
+func GetArchive(content types.IContent, tempDir string) (types.IArchive, error) {
+	if a, ok := content.(types.IArchive); ok {
+		return a, nil
+	}
+	name := strings.ToLower(content.Name())
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return &zipArchive{content: content, tempDir: tempDir}, nil
+	case strings.HasSuffix(name, ".7z"):
+		return &sevenZipArchive{content: content, tempDir: tempDir}, nil
+	case strings.HasSuffix(name, ".tar"):
+		return &tarArchive{content: content}, nil
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		return &tarArchive{content: content, gzipped: true}, nil
+	}
+	return nil, err.NewNotAllowedMessageError(i18n.T("drive.archive.unsupported"))
+}
+
+func archiveCorruptError(e error) error {
+	return err.NewBadRequestError(i18n.T("drive.archive.corrupt", e.Error()))
+}
+
+func cleanMemberName(name string) string {
+	return utils.CleanPath(strings.ReplaceAll(name, "\\", "/"))
+}
+
+type zipArchive struct {
+	content types.IContent
+	tempDir string
+}
+
+// openReaderAt opens the content for random access, the returned func must be called to release the resources
+func openReaderAt(ctx context.Context, content types.IContent, tempDir string) (io.ReaderAt, int64, func(), error) {
+	reader, e := content.GetReader(ctx)
+	if e != nil && !err.IsUnsupportedError(e) {
+		return nil, 0, nil, e
+	}
+	if file, ok := reader.(*os.File); ok {
+		stat, e := file.Stat()
+		if e != nil {
+			_ = file.Close()
+			return nil, 0, nil, e
+		}
+		return file, stat.Size(), func() { _ = file.Close() }, nil
+	}
+	if rr := getRangeReader(content); rr != nil && content.Size() >= 0 {
+		r := &rangeReaderAt{rs: NewRangeReadSeeker(ctx, rr, content.Size(), reader)}
+		return r, content.Size(), func() { _ = r.rs.Close() }, nil
+	}
+	if reader == nil {
+		reader, e = GetIContentReader(ctx, content)
+		if e != nil {
+			return nil, 0, nil, e
+		}
+	}
+	file, e := CopyReaderToTempFile(task.DummyContext(), reader, tempDir)
+	_ = reader.Close()
+	if e != nil {
+		return nil, 0, nil, e
+	}
+	release := func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}
+	stat, e := file.Stat()
+	if e != nil {
+		release()
+		return nil, 0, nil, e
+	}
+	return file, stat.Size(), release, nil
+}
+
+// getRangeReader returns the IRangeReader of the content or the entry wrapped by it
+func getRangeReader(content types.IContent) types.IRangeReader {
+	if rr, ok := content.(types.IRangeReader); ok {
+		return rr
+	}
+	entry, ok := content.(types.IEntry)
+	if !ok {
+		return nil
+	}
+	if e := GetIEntry(entry, func(e types.IEntry) bool {
+		_, ok := e.(types.IRangeReader)
+		return ok
+	}); e != nil {
+		return e.(types.IRangeReader)
+	}
+	return nil
+}
+
+// rangeReaderAt is an io.ReaderAt over RangeReadSeeker,
+// sequential reads at the nearby positions reuse the opened reader.
+type rangeReaderAt struct {
+	mu sync.Mutex
+	rs *RangeReadSeeker
+}
+
+func (r *rangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, e := r.rs.Seek(off, io.SeekStart); e != nil {
+		return 0, e
+	}
+	n, e := io.ReadFull(r.rs, p)
+	if e == io.ErrUnexpectedEOF {
+		e = io.EOF
+	}
+	return n, e
+}
+
+// open opens the zip reader, the returned func must be called to release the resources
+func (z *zipArchive) open(ctx context.Context) (*zip.Reader, func(), error) {
+	readerAt, size, release, e := openReaderAt(ctx, z.content, z.tempDir)
+	if e != nil {
+		return nil, nil, e
+	}
+	r, e := zip.NewReader(readerAt, size)
+	if e != nil {
+		release()
+		return nil, nil, archiveCorruptError(e)
+	}
+	return r, release, nil
+}
+
+func (z *zipArchive) ListMembers(ctx context.Context) ([]types.ArchiveMember, error) {
+	r, release, e := z.open(ctx)
+	if e != nil {
+		return nil, e
+	}
+	defer release()
+	members := make([]types.ArchiveMember, 0, len(r.File))
+	for _, f := range r.File {
+		isDir := f.FileInfo().IsDir()
+		size := int64(f.UncompressedSize64)
+		if isDir {
+			size = -1
+		}
+		members = append(members, types.ArchiveMember{
+			Name:    cleanMemberName(f.Name),
+			Size:    size,
+			ModTime: utils.Millisecond(f.Modified),
+			IsDir:   isDir,
+		})
+	}
+	return members, nil
+}
+
+func (z *zipArchive) OpenMember(ctx context.Context, name string) (io.ReadCloser, error) {
+	r, release, e := z.open(ctx)
+	if e != nil {
+		return nil, e
+	}
+	name = cleanMemberName(name)
+	for _, f := range r.File {
+		if cleanMemberName(f.Name) != name || f.FileInfo().IsDir() {
+			continue
+		}
+		reader, e := f.Open()
+		if e != nil {
+			release()
+			return nil, archiveCorruptError(e)
+		}
+		return &releaseReadCloser{ReadCloser: reader, release: release}, nil
+	}
+	release()
+	return nil, err.NewNotFoundMessageError(i18n.T("drive.archive.member_not_found", name))
+}
+
+type sevenZipArchive struct {
+	content types.IContent
+	tempDir string
+}
+
+// open opens the 7z reader, the returned func must be called to release the resources
+func (z *sevenZipArchive) open(ctx context.Context) (*sevenzip.Reader, func(), error) {
+	readerAt, size, release, e := openReaderAt(ctx, z.content, z.tempDir)
+	if e != nil {
+		return nil, nil, e
+	}
+	r, e := sevenzip.NewReader(readerAt, size)
+	if e != nil {
+		release()
+		return nil, nil, archiveCorruptError(e)
+	}
+	return r, release, nil
+}
+
+func (z *sevenZipArchive) ListMembers(ctx context.Context) ([]types.ArchiveMember, error) {
+	r, release, e := z.open(ctx)
+	if e != nil {
+		return nil, e
+	}
+	defer release()
+	members := make([]types.ArchiveMember, 0, len(r.File))
+	for _, f := range r.File {
+		size := f.Size
+		if f.IsDir {
+			size = -1
+		}
+		members = append(members, types.ArchiveMember{
+			Name:    cleanMemberName(f.Name),
+			Size:    size,
+			ModTime: utils.Millisecond(f.ModTime),
+			IsDir:   f.IsDir,
+		})
+	}
+	return members, nil
+}
+
+func (z *sevenZipArchive) OpenMember(ctx context.Context, name string) (io.ReadCloser, error) {
+	r, release, e := z.open(ctx)
+	if e != nil {
+		return nil, e
+	}
+	name = cleanMemberName(name)
+	for _, f := range r.File {
+		if cleanMemberName(f.Name) != name || f.IsDir {
+			continue
+		}
+		reader, e := f.Open()
+		if e != nil {
+			release()
+			return nil, archiveCorruptError(e)
+		}
+		return &releaseReadCloser{ReadCloser: reader, release: release}, nil
+	}
+	release()
+	return nil, err.NewNotFoundMessageError(i18n.T("drive.archive.member_not_found", name))
+}
+
+type tarArchive struct {
+	content types.IContent
+	gzipped bool
+}
+
+// scan iterates the members, stops when fn returns false
+func (t *tarArchive) scan(ctx context.Context, fn func(*tar.Header, *tar.Reader) (bool, error)) (func(), error) {
+	reader, e := GetIContentReader(ctx, t.content)
+	if e != nil {
+		return nil, e
+	}
+	release := func() { _ = reader.Close() }
+	var r io.Reader = reader
+	if t.gzipped {
+		gr, e := gzip.NewReader(reader)
+		if e != nil {
+			release()
+			return nil, archiveCorruptError(e)
+		}
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for {
+		if ctx.Err() != nil {
+			release()
+			return nil, ctx.Err()
+		}
+		h, e := tr.Next()
+		if e == io.EOF {
+			return release, nil
+		}
+		if e != nil {
+			release()
+			return nil, archiveCorruptError(e)
+		}
+		goOn, e := fn(h, tr)
+		if e != nil {
+			release()
+			return nil, e
+		}
+		if !goOn {
+			return release, nil
+		}
+	}
+}
+
+func (t *tarArchive) ListMembers(ctx context.Context) ([]types.ArchiveMember, error) {
+	members := make([]types.ArchiveMember, 0)
+	release, e := t.scan(ctx, func(h *tar.Header, _ *tar.Reader) (bool, error) {
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeDir {
+			return true, nil
+		}
+		isDir := h.Typeflag == tar.TypeDir
+		size := h.Size
+		if isDir {
+			size = -1
+		}
+		members = append(members, types.ArchiveMember{
+			Name:    cleanMemberName(h.Name),
+			Size:    size,
+			ModTime: utils.Millisecond(h.ModTime),
+			IsDir:   isDir,
+		})
+		return true, nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	release()
+	return members, nil
+}
+
+func (t *tarArchive) OpenMember(ctx context.Context, name string) (io.ReadCloser, error) {
+	name = cleanMemberName(name)
+	var found io.Reader
+	release, e := t.scan(ctx, func(h *tar.Header, r *tar.Reader) (bool, error) {
+		if h.Typeflag == tar.TypeReg && cleanMemberName(h.Name) == name {
+			found = r
+			return false, nil
+		}
+		return true, nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	if found == nil {
+		release()
+		return nil, err.NewNotFoundMessageError(i18n.T("drive.archive.member_not_found", name))
+	}
+	return &releaseReadCloser{ReadCloser: ioutil.NopCloser(found), release: release}, nil
+}
+
+type releaseReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseReadCloser) Close() error {
+	e := r.ReadCloser.Close()
+	r.release()
+	return e
+}
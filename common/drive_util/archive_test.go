@@ -0,0 +1,84 @@
+package drive_util
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"go-drive/common/errors"
+	"go-drive/common/types"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// rangeContent is a content which can only be read by ranges
+type rangeContent struct {
+	name   string
+	data   []byte
+	ranges int
+}
+
+func (r *rangeContent) Name() string   { return r.name }
+func (r *rangeContent) Size() int64    { return int64(len(r.data)) }
+func (r *rangeContent) ModTime() int64 { return 0 }
+
+func (r *rangeContent) GetReader(context.Context) (io.ReadCloser, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (r *rangeContent) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (r *rangeContent) GetRangeReader(_ context.Context, offset, length int64) (io.ReadCloser, error) {
+	r.ranges++
+	end := int64(len(r.data))
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+	return ioutil.NopCloser(bytes.NewReader(r.data[offset:end])), nil
+}
+
+func TestZipArchiveByRanges(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, name := range []string{"dir/", "dir/a.txt", "b.txt"} {
+		f, e := w.Create(name)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if name != "dir/" {
+			_, _ = f.Write(bytes.Repeat([]byte(name), 1000))
+		}
+	}
+	if e := w.Close(); e != nil {
+		t.Fatal(e)
+	}
+	content := &rangeContent{name: "test.zip", data: buf.Bytes()}
+	a, e := GetArchive(content, "")
+	if e != nil {
+		t.Fatal(e)
+	}
+	members, e := a.ListMembers(context.Background())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(members) != 3 || !members[0].IsDir || members[1].Name != "dir/a.txt" || members[1].Size != 9000 {
+		t.Fatalf("unexpected members: %+v", members)
+	}
+	reader, e := a.OpenMember(context.Background(), "dir/a.txt")
+	if e != nil {
+		t.Fatal(e)
+	}
+	b, e := ioutil.ReadAll(reader)
+	_ = reader.Close()
+	if e != nil || !bytes.Equal(b, bytes.Repeat([]byte("dir/a.txt"), 1000)) {
+		t.Errorf("unexpected content: %v", e)
+	}
+	if content.ranges == 0 {
+		t.Error("expect the content read by ranges")
+	}
+	if _, e := a.OpenMember(context.Background(), "not-exists"); !err.IsNotFoundError(e) {
+		t.Errorf("expect not found error, got %v", e)
+	}
+}
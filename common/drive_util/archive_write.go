@@ -0,0 +1,161 @@
+package drive_util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"os"
+	"path"
+)
+
+// BuildSelectionTree builds the trees of entries as the children of a root without entry,
+// so the selected entries are at the top of the archive written by WriteZip or WriteTarGz.
+func BuildSelectionTree(ctx types.TaskCtx, entries []types.IEntry, bytesProgress bool) (EntryNode, error) {
+	root := EntryNode{children: make([]EntryNode, 0, len(entries))}
+	for _, entry := range entries {
+		node, e := BuildEntriesTree(ctx, entry, bytesProgress)
+		if e != nil {
+			return root, e
+		}
+		root.children = append(root.children, node)
+	}
+	return root, nil
+}
+
+// walkArchiveNodes calls write with the readable descendants of root in depth-first order,
+// name is the path relative to root. The entries which can't be read are skipped with their descendants.
+func walkArchiveNodes(ctx types.TaskCtx, root EntryNode, write func(node EntryNode, name string) error) error {
+	var walk func(node EntryNode, name string) error
+	walk = func(node EntryNode, name string) error {
+		if ctx.Canceled() {
+			return task.ErrorCanceled
+		}
+		if !node.Meta().CanRead {
+			return nil
+		}
+		if e := write(node, name); e != nil {
+			return e
+		}
+		for _, child := range node.children {
+			if e := walk(child, path.Join(name, utils.PathBase(child.Path()))); e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+	for _, child := range root.children {
+		if e := walk(child, utils.PathBase(child.Path())); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// WriteZip writes the tree built by BuildEntriesTree to w as a zip archive, the names are relative to the root.
+// If deflate is true, the compressible files are deflated, and the others are stored.
+// The written bytes are reported to ctx as the progress.
+func WriteZip(ctx types.TaskCtx, w io.Writer, root EntryNode, deflate bool) error {
+	zw := zip.NewWriter(w)
+	e := walkArchiveNodes(ctx, root, func(node EntryNode, name string) error {
+		header := &zip.FileHeader{Name: name, Method: zip.Store}
+		if node.ModTime() > 0 {
+			header.Modified = utils.Time(node.ModTime())
+		}
+		if node.Type().IsDir() {
+			header.Name += "/"
+			_, e := zw.CreateHeader(header)
+			return e
+		}
+		content, ok := node.IEntry.(types.IContent)
+		if !ok {
+			return nil
+		}
+		if deflate && IsCompressible(name) {
+			header.Method = zip.Deflate
+		}
+		if node.Size() >= 0 {
+			header.UncompressedSize64 = uint64(node.Size())
+		}
+		fw, e := zw.CreateHeader(header)
+		if e != nil {
+			return e
+		}
+		reader, e := GetIContentReader(ctx, content)
+		if e != nil {
+			return e
+		}
+		defer func() { _ = reader.Close() }()
+		_, e = Copy(ctx, fw, reader)
+		return e
+	})
+	if e != nil {
+		return e
+	}
+	return zw.Close()
+}
+
+// WriteTarGz writes the tree built by BuildEntriesTree to w as a gzipped tar archive, like WriteZip.
+// The files of unknown size are copied to tempDir first, as tar needs the size before the content.
+func WriteTarGz(ctx types.TaskCtx, w io.Writer, root EntryNode, tempDir string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	e := walkArchiveNodes(ctx, root, func(node EntryNode, name string) error {
+		header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}
+		if node.ModTime() > 0 {
+			header.ModTime = utils.Time(node.ModTime())
+		}
+		if node.Type().IsDir() {
+			header.Name += "/"
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+			return tw.WriteHeader(header)
+		}
+		content, ok := node.IEntry.(types.IContent)
+		if !ok {
+			return nil
+		}
+		reader, e := GetIContentReader(ctx, content)
+		if e != nil {
+			return e
+		}
+		defer func() { _ = reader.Close() }()
+		if node.Size() >= 0 {
+			header.Size = node.Size()
+			if e := tw.WriteHeader(header); e != nil {
+				return e
+			}
+			_, e = Copy(ctx, tw, reader)
+			return e
+		}
+		file, e := CopyReaderToTempFile(ctx, reader, tempDir)
+		if e != nil {
+			return e
+		}
+		defer func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}()
+		stat, e := file.Stat()
+		if e != nil {
+			return e
+		}
+		header.Size = stat.Size()
+		if e := tw.WriteHeader(header); e != nil {
+			return e
+		}
+		// the progress has been reported when copying to the temp file
+		_, e = io.Copy(tw, file)
+		return e
+	})
+	if e != nil {
+		return e
+	}
+	if e := tw.Close(); e != nil {
+		return e
+	}
+	return gw.Close()
+}
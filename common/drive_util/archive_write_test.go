@@ -0,0 +1,153 @@
+package drive_util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type zipTestEntry struct {
+	types.IEntry
+	path    string
+	content string
+	dir     bool
+	denied  bool
+	// unsized is true if the size is unknown
+	unsized bool
+}
+
+func (e zipTestEntry) Path() string { return e.path }
+
+func (e zipTestEntry) Name() string { return e.path }
+
+func (e zipTestEntry) Type() types.EntryType {
+	if e.dir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e zipTestEntry) Size() int64 {
+	if e.dir || e.unsized {
+		return -1
+	}
+	return int64(len(e.content))
+}
+
+func (e zipTestEntry) ModTime() int64 { return 1600000000000 }
+
+func (e zipTestEntry) Meta() types.EntryMeta { return types.EntryMeta{CanRead: !e.denied} }
+
+func (e zipTestEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, errors.New("no url")
+}
+
+func (e zipTestEntry) GetReader(context.Context) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(e.content)), nil
+}
+
+func zipTestNode(e zipTestEntry, children ...EntryNode) EntryNode {
+	return EntryNode{IEntry: e, children: children}
+}
+
+func zipTestTree(text string) EntryNode {
+	return zipTestNode(zipTestEntry{path: "a", dir: true},
+		zipTestNode(zipTestEntry{path: "a/b", dir: true},
+			zipTestNode(zipTestEntry{path: "a/b/1.txt", content: text}),
+			zipTestNode(zipTestEntry{path: "a/b/2.jpg", content: "jpg", unsized: true}),
+		),
+		zipTestNode(zipTestEntry{path: "a/secret", dir: true, denied: true},
+			zipTestNode(zipTestEntry{path: "a/secret/3.txt", content: "3"}),
+		),
+		zipTestNode(zipTestEntry{path: "a/4.txt", content: "4", denied: true}),
+	)
+}
+
+func TestWriteZip(t *testing.T) {
+	text := strings.Repeat("hello zip ", 100)
+	root := zipTestTree(text)
+
+	buf := &bytes.Buffer{}
+	if e := WriteZip(task.DummyContext(), buf, root, true); e != nil {
+		t.Fatal(e)
+	}
+	zr, e := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if e != nil {
+		t.Fatal(e)
+	}
+	names := make([]string, 0)
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "b/,b/1.txt,b/2.jpg" {
+		t.Fatalf("unexpected entries: %v", names)
+	}
+	if zr.File[1].Method != zip.Deflate || zr.File[2].Method != zip.Store {
+		t.Errorf("unexpected methods: %d, %d", zr.File[1].Method, zr.File[2].Method)
+	}
+	if zr.File[1].Modified.UnixNano()/1e6 != 1600000000000 {
+		t.Errorf("unexpected modified time: %v", zr.File[1].Modified)
+	}
+	r, e := zr.File[1].Open()
+	if e != nil {
+		t.Fatal(e)
+	}
+	b, _ := ioutil.ReadAll(r)
+	_ = r.Close()
+	if string(b) != text {
+		t.Errorf("unexpected content of 1.txt: %s", b)
+	}
+
+	buf.Reset()
+	if e := WriteZip(task.DummyContext(), buf, root, false); e != nil {
+		t.Fatal(e)
+	}
+	zr, _ = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	for _, f := range zr.File {
+		if f.Method != zip.Store {
+			t.Errorf("expect %s to be stored", f.Name)
+		}
+	}
+}
+
+func TestWriteTarGz(t *testing.T) {
+	text := strings.Repeat("hello tar ", 100)
+	buf := &bytes.Buffer{}
+	if e := WriteTarGz(task.DummyContext(), buf, zipTestTree(text), ""); e != nil {
+		t.Fatal(e)
+	}
+	gr, e := gzip.NewReader(buf)
+	if e != nil {
+		t.Fatal(e)
+	}
+	tr := tar.NewReader(gr)
+	files := make(map[string]string)
+	names := make([]string, 0)
+	for {
+		h, e := tr.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			t.Fatal(e)
+		}
+		names = append(names, h.Name)
+		b, _ := ioutil.ReadAll(tr)
+		files[h.Name] = string(b)
+	}
+	if strings.Join(names, ",") != "b/,b/1.txt,b/2.jpg" {
+		t.Fatalf("unexpected entries: %v", names)
+	}
+	if files["b/1.txt"] != text || files["b/2.jpg"] != "jpg" {
+		t.Errorf("unexpected contents: %v", files)
+	}
+}
@@ -0,0 +1,66 @@
+package drive_util
+
+import (
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"time"
+)
+
+const (
+	asyncOpMinInterval = 500 * time.Millisecond
+	asyncOpMaxInterval = 5 * time.Second
+)
+
+// NativeCopy copies from to the path to of drive by the drive itself.
+// If the drive implements types.IAsyncCopier, the copy is started asynchronously
+// and polled until it completes, otherwise IDrive.Copy is called.
+func NativeCopy(ctx types.TaskCtx, drive types.IDrive, from types.IEntry, to string,
+	override bool) (types.IEntry, error) {
+	copier, ok := drive.(types.IAsyncCopier)
+	if !ok {
+		return drive.Copy(ctx, from, to, override)
+	}
+	op, e := copier.CopyAsync(ctx, from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	total := int64(0)
+	if from.Type().IsFile() {
+		total = from.Size()
+	}
+	ctx.Total(total, false)
+	return WaitAsyncOp(ctx, op, total)
+}
+
+// WaitAsyncOp polls op until it's done, the progress of op is reported as the part of total bytes.
+// The wait is interrupted when ctx is canceled, but the operation on the backend may still run.
+func WaitAsyncOp(ctx types.TaskCtx, op types.IAsyncOp, total int64) (types.IEntry, error) {
+	interval := asyncOpMinInterval
+	reported := int64(0)
+	for {
+		done, progress, e := op.Poll(ctx)
+		if e != nil {
+			return nil, e
+		}
+		if done {
+			progress = 1
+		}
+		if p := int64(progress * float64(total)); p > reported {
+			ctx.Progress(p-reported, false)
+			reported = p
+		}
+		if done {
+			return op.Result(ctx)
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, task.ErrorCanceled
+		case <-timer.C:
+		}
+		if interval *= 2; interval > asyncOpMaxInterval {
+			interval = asyncOpMaxInterval
+		}
+	}
+}
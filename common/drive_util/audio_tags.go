@@ -0,0 +1,326 @@
+package drive_util
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"go-drive/common/types"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// audioTagsMaxSize is the maximum bytes read from the beginning of a file to extract the tags,
+	// the tags are usually small, except the embedded pictures, which are skipped
+	audioTagsMaxSize = 16 * 1024 * 1024
+	// audioTagsMaxFieldSize is the maximum size of a text frame or a comment block
+	audioTagsMaxFieldSize = 64 * 1024
+	id3v1Size             = 128
+
+	flacBlockStreamInfo    = 0
+	flacBlockVorbisComment = 4
+)
+
+var errNoAudioTags = errors.New("no audio tags")
+
+// AudioTags are the tags of an audio file, fields are empty if not present
+type AudioTags struct {
+	Title  string
+	Artist string
+	Album  string
+	// Track is the track number, optionally with the total like '3/12'
+	Track string
+	Year  string
+	// Duration is the duration in seconds, 0 if unknown
+	Duration float64
+}
+
+// TrackNumber returns the track number without the total, 0 if unknown
+func (t AudioTags) TrackNumber() int {
+	n, _ := strconv.Atoi(strings.TrimSpace(strings.SplitN(t.Track, "/", 2)[0]))
+	return n
+}
+
+// ReadIContentAudioTags reads the ID3v2 tags of the MP3 files, or the Vorbis comments of the FLAC files.
+// The ID3v1 tag at the end of the file is read if there's no tags at the beginning.
+func ReadIContentAudioTags(ctx context.Context, content types.IContent) (AudioTags, error) {
+	reader, e := GetIContentRangeReader(ctx, content, 0, audioTagsMaxSize)
+	if e != nil {
+		return AudioTags{}, e
+	}
+	tags, e := ReadAudioTags(reader)
+	_ = reader.Close()
+	if e != errNoAudioTags || content.Size() < id3v1Size {
+		return tags, e
+	}
+	reader, e = GetIContentRangeReader(ctx, content, content.Size()-id3v1Size, id3v1Size)
+	if e != nil {
+		return AudioTags{}, e
+	}
+	defer func() { _ = reader.Close() }()
+	dat, e := ioutil.ReadAll(reader)
+	if e != nil {
+		return AudioTags{}, e
+	}
+	return readID3v1(dat)
+}
+
+// ReadAudioTags reads the ID3v2 tag or the FLAC metadata blocks at the beginning of r
+func ReadAudioTags(r io.Reader) (AudioTags, error) {
+	magic := make([]byte, 4)
+	if _, e := io.ReadFull(r, magic); e != nil {
+		return AudioTags{}, errNoAudioTags
+	}
+	if string(magic) == "fLaC" {
+		return readFLACTags(r)
+	}
+	if string(magic[:3]) == "ID3" {
+		return readID3v2(io.MultiReader(bytes.NewReader(magic[3:]), r))
+	}
+	return AudioTags{}, errNoAudioTags
+}
+
+// readID3v2 reads the ID3v2.2/2.3/2.4 tag after the 'ID3' magic
+func readID3v2(r io.Reader) (AudioTags, error) {
+	header := make([]byte, 7)
+	if _, e := io.ReadFull(r, header); e != nil {
+		return AudioTags{}, errNoAudioTags
+	}
+	version, flags := header[0], header[2]
+	if version < 2 || version > 4 {
+		return AudioTags{}, errNoAudioTags
+	}
+	r = io.LimitReader(r, int64(syncSafe(header[3:7])))
+	if flags&0x40 != 0 && version > 2 {
+		// skip the extended header
+		size := make([]byte, 4)
+		if _, e := io.ReadFull(r, size); e != nil {
+			return AudioTags{}, e
+		}
+		n := int64(binary.BigEndian.Uint32(size))
+		if version == 4 {
+			// the size includes itself in v2.4
+			n = int64(syncSafe(size)) - 4
+		}
+		if _, e := io.CopyN(ioutil.Discard, r, n); e != nil {
+			return AudioTags{}, e
+		}
+	}
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+	frames := make(map[string]string)
+	frameHeader := make([]byte, headerLen)
+	for {
+		if _, e := io.ReadFull(r, frameHeader); e != nil || frameHeader[0] == 0 {
+			// the end of the tag, or the padding
+			break
+		}
+		id := string(frameHeader[:idLen])
+		var size int
+		switch version {
+		case 2:
+			size = int(frameHeader[3])<<16 | int(frameHeader[4])<<8 | int(frameHeader[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(frameHeader[4:8]))
+		default:
+			size = syncSafe(frameHeader[4:8])
+		}
+		// only the text frames are wanted, the pictures are skipped
+		if id[0] != 'T' || size > audioTagsMaxFieldSize {
+			if _, e := io.CopyN(ioutil.Discard, r, int64(size)); e != nil {
+				break
+			}
+			continue
+		}
+		dat := make([]byte, size)
+		if _, e := io.ReadFull(r, dat); e != nil {
+			break
+		}
+		frames[id] = decodeID3Text(dat)
+	}
+	tags := AudioTags{
+		Title:  firstNonEmpty(frames["TIT2"], frames["TT2"]),
+		Artist: firstNonEmpty(frames["TPE1"], frames["TP1"]),
+		Album:  firstNonEmpty(frames["TALB"], frames["TAL"]),
+		Track:  firstNonEmpty(frames["TRCK"], frames["TRK"]),
+		Year:   firstNonEmpty(frames["TDRC"], frames["TYER"], frames["TYE"]),
+	}
+	// TLEN is the length in milliseconds
+	if ms, e := strconv.ParseFloat(firstNonEmpty(frames["TLEN"], frames["TLE"]), 64); e == nil && ms > 0 {
+		tags.Duration = ms / 1000
+	}
+	return tags, nil
+}
+
+func syncSafe(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<7 | int(c&0x7f)
+	}
+	return n
+}
+
+// decodeID3Text decodes the text frame by its encoding byte, only the first of the multiple values is returned
+func decodeID3Text(dat []byte) string {
+	if len(dat) == 0 {
+		return ""
+	}
+	enc, dat := dat[0], dat[1:]
+	var s string
+	switch enc {
+	case 0:
+		// ISO-8859-1
+		runes := make([]rune, len(dat))
+		for i, c := range dat {
+			runes[i] = rune(c)
+		}
+		s = string(runes)
+	case 1, 2:
+		// UTF-16 with BOM, or UTF-16BE without BOM
+		bigEndian := enc == 2
+		if len(dat) >= 2 && (dat[0] == 0xfe && dat[1] == 0xff || dat[0] == 0xff && dat[1] == 0xfe) {
+			bigEndian = dat[0] == 0xfe
+			dat = dat[2:]
+		}
+		u := make([]uint16, len(dat)/2)
+		for i := range u {
+			if bigEndian {
+				u[i] = binary.BigEndian.Uint16(dat[2*i:])
+			} else {
+				u[i] = binary.LittleEndian.Uint16(dat[2*i:])
+			}
+		}
+		s = string(utf16.Decode(u))
+	default:
+		s = string(dat)
+	}
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// readID3v1 reads the 128 bytes ID3v1 tag at the end of the file
+func readID3v1(dat []byte) (AudioTags, error) {
+	if len(dat) != id3v1Size || string(dat[:3]) != "TAG" {
+		return AudioTags{}, errNoAudioTags
+	}
+	// the fields are ISO-8859-1, padded by NUL or spaces
+	field := func(b []byte) string { return decodeID3Text(append([]byte{0}, b...)) }
+	tags := AudioTags{
+		Title:  field(dat[3:33]),
+		Artist: field(dat[33:63]),
+		Album:  field(dat[63:93]),
+		Year:   field(dat[93:97]),
+	}
+	// ID3v1.1 stores the track in the last byte of the comment
+	if dat[125] == 0 && dat[126] != 0 {
+		tags.Track = strconv.Itoa(int(dat[126]))
+	}
+	return tags, nil
+}
+
+// readFLACTags reads the STREAMINFO and VORBIS_COMMENT metadata blocks after the 'fLaC' magic
+func readFLACTags(r io.Reader) (AudioTags, error) {
+	tags := AudioTags{}
+	comments := make(map[string]string)
+	header := make([]byte, 4)
+	for {
+		if _, e := io.ReadFull(r, header); e != nil {
+			break
+		}
+		last, blockType := header[0]&0x80 != 0, header[0]&0x7f
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		if (blockType != flacBlockStreamInfo && blockType != flacBlockVorbisComment) || size > audioTagsMaxFieldSize {
+			if _, e := io.CopyN(ioutil.Discard, r, int64(size)); e != nil {
+				break
+			}
+		} else {
+			dat := make([]byte, size)
+			if _, e := io.ReadFull(r, dat); e != nil {
+				break
+			}
+			if blockType == flacBlockStreamInfo {
+				tags.Duration = flacDuration(dat)
+			} else {
+				readVorbisComments(dat, comments)
+			}
+		}
+		if last {
+			break
+		}
+	}
+	tags.Title = comments["TITLE"]
+	tags.Artist = comments["ARTIST"]
+	tags.Album = comments["ALBUM"]
+	tags.Track = comments["TRACKNUMBER"]
+	if tags.Track != "" && comments["TRACKTOTAL"] != "" && !strings.Contains(tags.Track, "/") {
+		tags.Track += "/" + comments["TRACKTOTAL"]
+	}
+	tags.Year = comments["DATE"]
+	return tags, nil
+}
+
+// flacDuration returns the duration by the sample rate and the total samples of STREAMINFO
+func flacDuration(dat []byte) float64 {
+	if len(dat) < 18 {
+		return 0
+	}
+	sampleRate := int64(dat[10])<<12 | int64(dat[11])<<4 | int64(dat[12])>>4
+	totalSamples := int64(dat[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(dat[14:18]))
+	if sampleRate == 0 {
+		return 0
+	}
+	return float64(totalSamples) / float64(sampleRate)
+}
+
+// readVorbisComments reads the 'KEY=value' comments to m, the keys are upper cased, the first value is kept
+func readVorbisComments(dat []byte, m map[string]string) {
+	next := func() ([]byte, bool) {
+		if len(dat) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(dat)
+		if uint64(n) > uint64(len(dat)-4) {
+			return nil, false
+		}
+		s := dat[4 : 4+n]
+		dat = dat[4+n:]
+		return s, true
+	}
+	// the vendor string
+	if _, ok := next(); !ok || len(dat) < 4 {
+		return
+	}
+	count := binary.LittleEndian.Uint32(dat)
+	dat = dat[4:]
+	for i := uint32(0); i < count; i++ {
+		c, ok := next()
+		if !ok {
+			return
+		}
+		kv := strings.SplitN(string(c), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.ToUpper(kv[0])
+		if _, exists := m[key]; !exists {
+			m[key] = strings.TrimSpace(kv[1])
+		}
+	}
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,123 @@
+package drive_util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func id3v2Frame(version byte, id string, dat []byte) []byte {
+	b := []byte(id)
+	size := make([]byte, 4)
+	if version == 4 {
+		n := len(dat)
+		size = []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+	} else {
+		binary.BigEndian.PutUint32(size, uint32(len(dat)))
+	}
+	return append(append(append(b, size...), 0, 0), dat...)
+}
+
+func id3v2Tag(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	// the padding
+	body = append(body, make([]byte, 16)...)
+	n := len(body)
+	header := []byte{'I', 'D', '3', version, 0, 0,
+		byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+	return append(append(header, body...), "audio data"...)
+}
+
+func TestReadAudioTagsID3v2(t *testing.T) {
+	tag := id3v2Tag(3,
+		id3v2Frame(3, "APIC", bytes.Repeat([]byte{0xff}, 1000)),
+		id3v2Frame(3, "TIT2", append([]byte{0}, "Caf\xe9"...)),
+		// UTF-16 with BOM, little endian
+		id3v2Frame(3, "TPE1", []byte{1, 0xff, 0xfe, 'A', 0, 'B', 0, 0, 0}),
+		id3v2Frame(3, "TRCK", append([]byte{0}, "3/12"...)),
+		id3v2Frame(3, "TLEN", append([]byte{0}, "215500"...)),
+	)
+	tags, e := ReadAudioTags(bytes.NewReader(tag))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if tags.Title != "Café" || tags.Artist != "AB" || tags.Track != "3/12" || tags.Duration != 215.5 {
+		t.Errorf("unexpected tags %+v", tags)
+	}
+	if tags.TrackNumber() != 3 {
+		t.Errorf("expected track 3, got %d", tags.TrackNumber())
+	}
+
+	tag = id3v2Tag(4,
+		id3v2Frame(4, "TALB", append([]byte{3}, "专辑\x00second"...)),
+		id3v2Frame(4, "TDRC", append([]byte{3}, "2020"...)),
+	)
+	tags, e = ReadAudioTags(bytes.NewReader(tag))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if tags.Album != "专辑" || tags.Year != "2020" {
+		t.Errorf("unexpected tags %+v", tags)
+	}
+}
+
+func flacBlock(blockType byte, last bool, dat []byte) []byte {
+	if last {
+		blockType |= 0x80
+	}
+	n := len(dat)
+	return append([]byte{blockType, byte(n >> 16), byte(n >> 8), byte(n)}, dat...)
+}
+
+func TestReadAudioTagsFLAC(t *testing.T) {
+	streamInfo := make([]byte, 34)
+	// 44100Hz, 2 channels, 16 bits, 441000 samples
+	sampleRate := 44100
+	streamInfo[10] = byte(sampleRate >> 12)
+	streamInfo[11] = byte(sampleRate >> 4)
+	streamInfo[12] = byte(sampleRate<<4) | 1<<1
+	streamInfo[13] = 15 << 4
+	binary.BigEndian.PutUint32(streamInfo[14:18], 441000)
+
+	var comments bytes.Buffer
+	writeString := func(s string) {
+		_ = binary.Write(&comments, binary.LittleEndian, uint32(len(s)))
+		comments.WriteString(s)
+	}
+	writeString("reference libFLAC")
+	_ = binary.Write(&comments, binary.LittleEndian, uint32(4))
+	writeString("title=Song")
+	writeString("ARTIST=Someone")
+	writeString("TRACKNUMBER=2")
+	writeString("TRACKTOTAL=9")
+
+	dat := append([]byte("fLaC"), flacBlock(0, false, streamInfo)...)
+	dat = append(dat, flacBlock(6, false, make([]byte, 100))...)
+	dat = append(dat, flacBlock(4, true, comments.Bytes())...)
+	tags, e := ReadAudioTags(bytes.NewReader(dat))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if tags.Title != "Song" || tags.Artist != "Someone" || tags.Track != "2/9" || tags.Duration != 10 {
+		t.Errorf("unexpected tags %+v", tags)
+	}
+}
+
+func TestReadID3v1(t *testing.T) {
+	dat := make([]byte, id3v1Size)
+	copy(dat, "TAG")
+	copy(dat[3:], "Title   ")
+	copy(dat[33:], "Artist")
+	copy(dat[93:], "1999")
+	dat[126] = 7
+	tags, e := readID3v1(dat)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if tags.Title != "Title" || tags.Artist != "Artist" || tags.Year != "1999" || tags.Track != "7" {
+		t.Errorf("unexpected tags %+v", tags)
+	}
+	if _, e := ReadAudioTags(bytes.NewReader([]byte("RIFF....WAVE"))); e != errNoAudioTags {
+		t.Errorf("expected errNoAudioTags, got %v", e)
+	}
+}
@@ -0,0 +1,155 @@
+package drive_util
+
+import (
+	"context"
+	"fmt"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"sync"
+)
+
+const (
+	// DefaultBufferSize is the buffer size requested by the copy paths
+	DefaultBufferSize = 32 * 1024
+	// minBufferSize is the smallest buffer handed out when the budget is nearly exhausted
+	minBufferSize = 4 * 1024
+)
+
+// TransferBuffers is the shared allocator of the transfer buffers,
+// all copy paths request their buffers from it, so that the memory of the buffers is bounded.
+var TransferBuffers = NewBufferPool(0)
+
+// BufferPool hands out buffers while the total size of the buffers in use is within the limit.
+// When the limit is nearly reached, smaller buffers are handed out,
+// and when even the smallest one is not available, the request waits until some buffers are released.
+type BufferPool struct {
+	mux   *sync.Mutex
+	limit int64
+	used  int64
+	peak  int64
+
+	waiting  int
+	released chan struct{}
+
+	pools map[int]*sync.Pool
+}
+
+// NewBufferPool creates a BufferPool, limit <= 0 means unlimited
+func NewBufferPool(limit int64) *BufferPool {
+	return &BufferPool{
+		mux:      &sync.Mutex{},
+		limit:    limit,
+		released: make(chan struct{}),
+		pools:    make(map[int]*sync.Pool),
+	}
+}
+
+// SetLimit changes the limit of the total size of the buffers, limit <= 0 means unlimited
+func (p *BufferPool) SetLimit(limit int64) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.limit = limit
+	p.notifyReleased()
+}
+
+// Acquire returns a buffer of size, or a smaller one if the budget is nearly exhausted.
+// It blocks until a buffer is available or ctx is done.
+// The buffer must be given back by Release.
+func (p *BufferPool) Acquire(ctx context.Context, size int) ([]byte, error) {
+	for {
+		p.mux.Lock()
+		if n := p.grant(size); n > 0 {
+			p.used += int64(n)
+			if p.used > p.peak {
+				p.peak = p.used
+			}
+			pool := p.pool(n)
+			p.mux.Unlock()
+			return pool.Get().([]byte), nil
+		}
+		released := p.released
+		p.waiting++
+		p.mux.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+		}
+
+		p.mux.Lock()
+		p.waiting--
+		p.mux.Unlock()
+		if e := ctx.Err(); e != nil {
+			return nil, e
+		}
+	}
+}
+
+// Release gives back the buffer returned by Acquire
+func (p *BufferPool) Release(buf []byte) {
+	if buf == nil {
+		return
+	}
+	buf = buf[:cap(buf)]
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.used -= int64(len(buf))
+	p.pool(len(buf)).Put(buf)
+	p.notifyReleased()
+}
+
+// grant returns the size of the buffer can be handed out, 0 if none
+func (p *BufferPool) grant(size int) int {
+	if p.limit <= 0 {
+		return size
+	}
+	available := p.limit - p.used
+	n := size
+	for n > minBufferSize && int64(n) > available {
+		n /= 2
+	}
+	if int64(n) > available {
+		return 0
+	}
+	return n
+}
+
+func (p *BufferPool) pool(size int) *sync.Pool {
+	pool, ok := p.pools[size]
+	if !ok {
+		pool = &sync.Pool{New: func() interface{} { return make([]byte, size) }}
+		p.pools[size] = pool
+	}
+	return pool
+}
+
+func (p *BufferPool) notifyReleased() {
+	close(p.released)
+	p.released = make(chan struct{})
+}
+
+func (p *BufferPool) Status() (string, types.SM, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	limit := "unlimited"
+	if p.limit > 0 {
+		limit = utils.FormatBytes(uint64(p.limit), 2)
+	}
+	return "Transfer Buffers", types.SM{
+		"Limit":   limit,
+		"InUse":   utils.FormatBytes(uint64(p.used), 2),
+		"Peak":    utils.FormatBytes(uint64(p.peak), 2),
+		"Waiting": fmt.Sprintf("%d", p.waiting),
+	}, nil
+}
+
+// CopyBuffered copies src to dst with a buffer from TransferBuffers
+func CopyBuffered(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	buf, e := TransferBuffers.Acquire(ctx, DefaultBufferSize)
+	if e != nil {
+		return 0, e
+	}
+	defer TransferBuffers.Release(buf)
+	return io.CopyBuffer(dst, src, buf)
+}
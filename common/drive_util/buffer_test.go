@@ -0,0 +1,50 @@
+package drive_util
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBufferPool(t *testing.T) {
+	p := NewBufferPool(40 * 1024)
+	a, e := p.Acquire(context.Background(), DefaultBufferSize)
+	if e != nil || len(a) != DefaultBufferSize {
+		t.Fatalf("expect a full buffer, but it's %d, %v", len(a), e)
+	}
+	b, e := p.Acquire(context.Background(), DefaultBufferSize)
+	if e != nil || len(b) != 8*1024 {
+		t.Fatalf("expect a smaller buffer, but it's %d, %v", len(b), e)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, e := p.Acquire(ctx, DefaultBufferSize); e == nil {
+		t.Fatal("expect waiting until timeout when the budget is exhausted")
+	}
+
+	got := make(chan int)
+	go func() {
+		c, e := p.Acquire(context.Background(), DefaultBufferSize)
+		if e != nil {
+			got <- -1
+			return
+		}
+		got <- len(c)
+		p.Release(c)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	p.Release(a)
+	select {
+	case n := <-got:
+		if n != DefaultBufferSize {
+			t.Errorf("expect a full buffer after released, but it's %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the waiting request to be woken up")
+	}
+	p.Release(b)
+	if p.used != 0 {
+		t.Errorf("expect no buffer in use, but it's %d", p.used)
+	}
+}
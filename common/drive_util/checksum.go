@@ -0,0 +1,62 @@
+package drive_util
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"hash"
+	"strings"
+)
+
+// Checksum is the expected hash of some content, in the form of 'algorithm:hex'.
+// Supported algorithms are sha256, sha1 and md5.
+type Checksum struct {
+	Algorithm string
+	Sum       string
+}
+
+// ParseChecksum parses the checksum of 'algorithm:hex', nil is returned if s is empty
+func ParseChecksum(s string) (*Checksum, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return nil, err.NewBadRequestError(i18n.T("drive.invalid_checksum", s))
+	}
+	c := &Checksum{Algorithm: strings.ToLower(s[:i]), Sum: strings.ToLower(s[i+1:])}
+	h := c.NewHash()
+	if h == nil {
+		return nil, err.NewBadRequestError(i18n.T("drive.invalid_checksum", s))
+	}
+	if b, e := hex.DecodeString(c.Sum); e != nil || len(b) != h.Size() {
+		return nil, err.NewBadRequestError(i18n.T("drive.invalid_checksum", s))
+	}
+	return c, nil
+}
+
+// NewHash returns a new hash of the algorithm, or nil if the algorithm is not supported
+func (c *Checksum) NewHash() hash.Hash {
+	switch c.Algorithm {
+	case "sha256":
+		return sha256.New()
+	case "sha1":
+		return sha1.New()
+	case "md5":
+		return md5.New()
+	}
+	return nil
+}
+
+// Match tells whether the sum of h is the expected one
+func (c *Checksum) Match(h hash.Hash) bool {
+	return hex.EncodeToString(h.Sum(nil)) == c.Sum
+}
+
+func (c *Checksum) String() string {
+	return c.Algorithm + ":" + c.Sum
+}
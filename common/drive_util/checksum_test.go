@@ -0,0 +1,29 @@
+package drive_util
+
+import (
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+	c, e := ParseChecksum("SHA256:E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855")
+	if e != nil {
+		t.Fatal(e)
+	}
+	h := c.NewHash()
+	if !c.Match(h) {
+		t.Error("checksum of empty content should match")
+	}
+	_, _ = h.Write([]byte("a"))
+	if c.Match(h) {
+		t.Error("checksum of 'a' should not match")
+	}
+
+	if c, e := ParseChecksum(" "); c != nil || e != nil {
+		t.Error("empty checksum should be nil")
+	}
+	for _, s := range []string{"e3b0c442", "crc32:00000000", "md5:xyz", "sha1:e3b0c442"} {
+		if _, e := ParseChecksum(s); e == nil {
+			t.Errorf("'%s' should be invalid", s)
+		}
+	}
+}
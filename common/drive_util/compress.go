@@ -0,0 +1,135 @@
+package drive_util
+
+import (
+	"compress/gzip"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// CompressedSuffix is appended to the names of the files compressed by CompressCopy
+const CompressedSuffix = ".gz"
+
+// incompressibleExts are the extensions of the formats which are already compressed
+var incompressibleExts = map[string]bool{
+	".gz": true, ".tgz": true, ".zip": true, ".7z": true, ".rar": true, ".bz2": true, ".xz": true, ".zst": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp3": true, ".aac": true, ".m4a": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".mkv": true, ".mov": true, ".webm": true, ".avi": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".epub": true, ".jar": true, ".apk": true,
+}
+
+// IsCompressible tells whether compressing the file of name is likely to save space.
+// Already compressed media and archives are not compressible, files of unknown types are.
+func IsCompressible(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	if incompressibleExts[ext] {
+		return false
+	}
+	mimeType := mime.TypeByExtension(ext)
+	if i := strings.IndexByte(mimeType, ';'); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	switch mimeType {
+	case "image/svg+xml", "image/bmp", "image/tiff", "audio/wav", "audio/x-wav":
+		return true
+	}
+	return !strings.HasPrefix(mimeType, "image/") &&
+		!strings.HasPrefix(mimeType, "video/") &&
+		!strings.HasPrefix(mimeType, "audio/")
+}
+
+// CompressCallback is called after a file is compressed and saved to 'to',
+// size is the size of the source file, compressed is the size of the saved file.
+type CompressCallback = func(from types.IEntry, to string, size, compressed int64)
+
+// CompressCopy returns a DoCopy for CopyAll, which gzips the compressible files to the destination
+// with CompressedSuffix appended to their names, the other files are copied by fallback.
+// The original name and modification time are recorded in the gzip header.
+// Existing compressed files are skipped if override is false, the same as CopyAll does to the others.
+func CompressCopy(tempDir string, override bool, fallback DoCopy, after CompressCallback) DoCopy {
+	return func(from types.IEntry, driveTo types.IDrive, to string, ctx types.TaskCtx) error {
+		if !IsCompressible(from.Path()) {
+			return fallback(from, driveTo, to, ctx)
+		}
+		content, ok := from.(types.IContent)
+		if !ok {
+			return err.NewNotAllowedMessageError(i18n.T("drive.file_not_readable", from.Path()))
+		}
+		to += CompressedSuffix
+		if !override {
+			_, e := driveTo.Get(ctx, to)
+			if e == nil {
+				return nil
+			}
+			if !err.IsNotFoundError(e) {
+				return e
+			}
+		}
+		file, e := compressToTempFile(ctx, from, content, tempDir)
+		if e != nil {
+			return e
+		}
+		defer func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}()
+		stat, e := file.Stat()
+		if e != nil {
+			return e
+		}
+		// progress was reported by the size of the source file while compressing
+		_, e = driveTo.Save(task.NewCtxWrapper(ctx, false, false), to, stat.Size(), true, file)
+		if e != nil {
+			return e
+		}
+		if after != nil {
+			after(from, to, from.Size(), stat.Size())
+		}
+		return nil
+	}
+}
+
+func compressToTempFile(ctx types.TaskCtx, from types.IEntry,
+	content types.IContent, tempDir string) (*os.File, error) {
+	reader, e := GetIContentReader(ctx, content)
+	if e != nil {
+		return nil, e
+	}
+	defer func() { _ = reader.Close() }()
+	file, e := ioutil.TempFile(tempDir, "drive-compress")
+	if e != nil {
+		return nil, e
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+	w := gzip.NewWriter(file)
+	w.Name = utils.PathBase(from.Path())
+	if from.ModTime() > 0 {
+		w.ModTime = time.Unix(0, from.ModTime()*int64(time.Millisecond))
+	}
+	if _, e := Copy(ctx, w, reader); e != nil {
+		return nil, e
+	}
+	if e := w.Close(); e != nil {
+		return nil, e
+	}
+	if _, e := file.Seek(0, 0); e != nil {
+		return nil, e
+	}
+	ok = true
+	return file, nil
+}
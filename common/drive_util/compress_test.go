@@ -0,0 +1,26 @@
+package drive_util
+
+import "testing"
+
+func TestIsCompressible(t *testing.T) {
+	cases := map[string]bool{
+		"a.txt":      true,
+		"a.json":     true,
+		"a.svg":      true,
+		"a.bmp":      true,
+		"a.unknown":  true,
+		"noext":      true,
+		"a.jpg":      false,
+		"a.PNG":      false,
+		"a.mp4":      false,
+		"a.tar.gz":   false,
+		"a.zip":      false,
+		"a.docx":     false,
+		"dir/a.flac": false,
+	}
+	for name, want := range cases {
+		if got := IsCompressible(name); got != want {
+			t.Errorf("IsCompressible(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package drive_util
+
+import (
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+)
+
+const (
+	// CompareBySize regards the files of the same size as unchanged
+	CompareBySize = "size"
+	// CompareByModTime regards the files of the same size as unchanged,
+	// if the destination is not older than the source.
+	// Copied files are usually newer than their sources, as the modification time is not preserved by most drives.
+	CompareByModTime = "mod_time"
+	// CompareByHash regards the files of the same content hash as unchanged.
+	// The native hashes are used if both drives provide them in the same algorithm,
+	// otherwise both files are read to compute the hashes.
+	CompareByHash = "hash"
+)
+
+// CopyUpdate makes CopyAllWithOptions treat the destination as a partial mirror of the source,
+// only the files missing or changed in the destination are copied, the files only in the destination are kept.
+type CopyUpdate struct {
+	// CompareBy is one of CompareBySize, CompareByModTime or CompareByHash
+	CompareBy string `json:"compare_by"`
+
+	// Copied is the number of files missing in the destination
+	Copied int `json:"copied"`
+	// Updated is the number of files changed in the destination
+	Updated int `json:"updated"`
+	// Unchanged is the number of files skipped
+	Unchanged int `json:"unchanged"`
+}
+
+// NewCopyUpdate creates a CopyUpdate comparing files by compareBy
+func NewCopyUpdate(compareBy string) (*CopyUpdate, error) {
+	switch compareBy {
+	case CompareBySize, CompareByModTime, CompareByHash:
+	default:
+		return nil, err.NewBadRequestError(i18n.T("drive.invalid_compare_by", compareBy))
+	}
+	return &CopyUpdate{CompareBy: compareBy}, nil
+}
+
+// unchanged tells whether dst is the same as src, files of unknown sizes are always regarded as changed
+func (u *CopyUpdate) unchanged(ctx types.TaskCtx, src, dst types.IEntry) bool {
+	if src.Size() < 0 || src.Size() != dst.Size() {
+		return false
+	}
+	switch u.CompareBy {
+	case CompareBySize:
+		return true
+	case CompareByHash:
+		return sameContentHash(ctx, src, dst)
+	default:
+		return dst.ModTime() >= src.ModTime()
+	}
+}
+
+func sameContentHash(ctx types.TaskCtx, src, dst types.IEntry) bool {
+	srcAlg, srcHash := nativeContentHash(ctx, src)
+	dstAlg, dstHash := nativeContentHash(ctx, dst)
+	if srcHash != "" && srcAlg == dstAlg {
+		return srcHash == dstHash
+	}
+	// the hashes are computed without reporting the progress of the copy
+	ctx = task.NewCtxWrapper(ctx, false, false)
+	hashes := make([]string, 2)
+	for i, entry := range []types.IEntry{src, dst} {
+		content, ok := GetIEntry(entry, func(e types.IEntry) bool {
+			_, ok := e.(types.IContent)
+			return ok
+		}).(types.IContent)
+		if !ok {
+			return false
+		}
+		hash, e := hashContent(ctx, content)
+		if e != nil {
+			return false
+		}
+		hashes[i] = hash
+	}
+	return hashes[0] == hashes[1]
+}
+
+func nativeContentHash(ctx types.TaskCtx, entry types.IEntry) (string, string) {
+	h, ok := GetIEntry(entry, func(e types.IEntry) bool {
+		_, ok := e.(types.IContentHash)
+		return ok
+	}).(types.IContentHash)
+	if !ok {
+		return "", ""
+	}
+	alg, hash, e := h.ContentHash(ctx)
+	if e != nil {
+		return "", ""
+	}
+	return alg, hash
+}
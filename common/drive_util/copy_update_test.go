@@ -0,0 +1,45 @@
+package drive_util
+
+import (
+	"go-drive/common/types"
+	"testing"
+)
+
+type updateTestEntry struct {
+	types.IEntry
+	size    int64
+	modTime int64
+}
+
+func (e updateTestEntry) Size() int64 {
+	return e.size
+}
+
+func (e updateTestEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func TestCopyUpdateUnchanged(t *testing.T) {
+	cases := []struct {
+		compareBy string
+		src, dst  updateTestEntry
+		want      bool
+	}{
+		{CompareBySize, updateTestEntry{size: 1}, updateTestEntry{size: 1}, true},
+		{CompareBySize, updateTestEntry{size: 1}, updateTestEntry{size: 2}, false},
+		{CompareBySize, updateTestEntry{size: -1}, updateTestEntry{size: -1}, false},
+		{CompareByModTime, updateTestEntry{size: 1, modTime: 10}, updateTestEntry{size: 1, modTime: 20}, true},
+		{CompareByModTime, updateTestEntry{size: 1, modTime: 10}, updateTestEntry{size: 1, modTime: 10}, true},
+		{CompareByModTime, updateTestEntry{size: 1, modTime: 20}, updateTestEntry{size: 1, modTime: 10}, false},
+		{CompareByModTime, updateTestEntry{size: 1, modTime: 10}, updateTestEntry{size: 2, modTime: 20}, false},
+	}
+	for _, c := range cases {
+		u, e := NewCopyUpdate(c.compareBy)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if got := u.unchanged(nil, c.src, c.dst); got != c.want {
+			t.Errorf("%s: unchanged(%v, %v) = %v, want %v", c.compareBy, c.src, c.dst, got, c.want)
+		}
+	}
+}
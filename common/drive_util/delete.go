@@ -0,0 +1,115 @@
+package drive_util
+
+import (
+	"go-drive/common/errors"
+	"go-drive/common/types"
+	"strings"
+)
+
+// DeleteCheckpoint is the progress of a resumable deletion
+type DeleteCheckpoint struct {
+	Path string `json:"path"`
+	// Total is the number of entries counted before the first attempt
+	Total int64 `json:"total"`
+	// Removed are the directories fully removed, the ones inside another removed directory are dropped
+	Removed []string `json:"removed"`
+}
+
+// SaveDeleteCheckpoint persists the checkpoint
+type SaveDeleteCheckpoint = func(cp *DeleteCheckpoint) error
+
+// DeleteResumable deletes cp.Path entry by entry, from the leaves up,
+// and saves the checkpoint whenever a directory is fully removed.
+// Retrying with the saved checkpoint skips the removed directories,
+// and reports the progress against the total counted by the first attempt.
+// Deleting an already deleted path succeeds, so the deletion is idempotent.
+func DeleteResumable(ctx types.TaskCtx, drive types.IDrive, cp *DeleteCheckpoint, save SaveDeleteCheckpoint) error {
+	root, e := drive.Get(ctx, cp.Path)
+	if e != nil {
+		if err.IsNotFoundError(e) && cp.Total > 0 {
+			ctx.Total(cp.Total, true)
+			ctx.Progress(cp.Total, true)
+			return nil
+		}
+		return e
+	}
+	removed := make(map[string]bool, len(cp.Removed))
+	for _, p := range cp.Removed {
+		removed[p] = true
+	}
+	tree, remaining, e := buildDeleteTree(ctx, drive, root, removed)
+	if e != nil {
+		return e
+	}
+	if cp.Total <= 0 {
+		cp.Total = remaining
+		if e := save(cp); e != nil {
+			return e
+		}
+	}
+	deleted := cp.Total - remaining
+	if deleted < 0 {
+		deleted = 0
+	}
+	ctx.Total(cp.Total, true)
+	ctx.Progress(deleted, true)
+	return deleteTree(ctx, drive, tree, cp, save)
+}
+
+// buildDeleteTree lists the entries to be deleted, which are also counted
+func buildDeleteTree(ctx types.TaskCtx, drive types.IDrive, entry types.IEntry,
+	removed map[string]bool) (EntryNode, int64, error) {
+	if e := ctx.WaitIfPaused(); e != nil {
+		return EntryNode{}, 0, e
+	}
+	node := EntryNode{IEntry: entry}
+	count := int64(1)
+	if !entry.Type().IsDir() {
+		return node, count, nil
+	}
+	children, e := drive.List(ctx, entry.Path())
+	if e != nil {
+		return node, 0, e
+	}
+	for _, child := range children {
+		// the listing may be cached
+		if removed[child.Path()] {
+			continue
+		}
+		childNode, n, e := buildDeleteTree(ctx, drive, child, removed)
+		if e != nil {
+			return node, 0, e
+		}
+		node.children = append(node.children, childNode)
+		count += n
+	}
+	return node, count, nil
+}
+
+func deleteTree(ctx types.TaskCtx, drive types.IDrive, node EntryNode,
+	cp *DeleteCheckpoint, save SaveDeleteCheckpoint) error {
+	for _, child := range node.children {
+		if e := deleteTree(ctx, drive, child, cp, save); e != nil {
+			return e
+		}
+	}
+	if e := ctx.WaitIfPaused(); e != nil {
+		return e
+	}
+	if e := drive.Delete(ctx, node.Path()); e != nil && !err.IsNotFoundError(e) {
+		return e
+	}
+	ctx.Progress(1, false)
+	if !node.Type().IsDir() {
+		return nil
+	}
+	prefix := node.Path() + "/"
+	kept := cp.Removed[:0]
+	for _, p := range cp.Removed {
+		if !strings.HasPrefix(p, prefix) {
+			kept = append(kept, p)
+		}
+	}
+	cp.Removed = append(kept, node.Path())
+	return save(cp)
+}
@@ -0,0 +1,113 @@
+package drive_util
+
+import (
+	"context"
+	"errors"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type deleteTestEntry struct {
+	types.IEntry
+	path string
+	dir  bool
+}
+
+func (e deleteTestEntry) Path() string {
+	return e.path
+}
+
+func (e deleteTestEntry) Type() types.EntryType {
+	if e.dir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+// deleteTestDrive is a drive of the paths, the deletion fails after failAfter deletes if it's > 0
+type deleteTestDrive struct {
+	types.IDrive
+	entries   map[string]bool
+	failAfter int
+	deleted   int
+	lists     []string
+}
+
+func (d *deleteTestDrive) Get(_ context.Context, path string) (types.IEntry, error) {
+	dir, ok := d.entries[path]
+	if !ok {
+		return nil, err.NewNotFoundError()
+	}
+	return deleteTestEntry{path: path, dir: dir}, nil
+}
+
+func (d *deleteTestDrive) List(_ context.Context, path string) ([]types.IEntry, error) {
+	d.lists = append(d.lists, path)
+	entries := make([]types.IEntry, 0)
+	for p, dir := range d.entries {
+		if strings.HasPrefix(p, path+"/") && !strings.Contains(p[len(path)+1:], "/") {
+			entries = append(entries, deleteTestEntry{path: p, dir: dir})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path() < entries[j].Path() })
+	return entries, nil
+}
+
+func (d *deleteTestDrive) Delete(_ types.TaskCtx, path string) error {
+	if d.failAfter > 0 && d.deleted >= d.failAfter {
+		return errors.New("interrupted")
+	}
+	for p := range d.entries {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(d.entries, p)
+		}
+	}
+	d.deleted++
+	return nil
+}
+
+func TestDeleteResumable(t *testing.T) {
+	d := &deleteTestDrive{entries: map[string]bool{
+		"a": true, "a/b": true, "a/b/1": false, "a/b/2": false, "a/c": true, "a/c/3": false, "a/4": false,
+	}, failAfter: 4}
+	var saved DeleteCheckpoint
+	save := func(cp *DeleteCheckpoint) error {
+		saved = *cp
+		saved.Removed = append([]string(nil), cp.Removed...)
+		return nil
+	}
+
+	cp := &DeleteCheckpoint{Path: "a"}
+	if e := DeleteResumable(task.DummyContext(), d, cp, save); e == nil {
+		t.Fatal("expect the deletion to be interrupted")
+	}
+	if saved.Total != 7 || len(saved.Removed) != 1 || saved.Removed[0] != "a/b" {
+		t.Fatalf("unexpected checkpoint %v", saved)
+	}
+
+	d.failAfter = 0
+	d.lists = nil
+	resumed := saved
+	if e := DeleteResumable(task.DummyContext(), d, &resumed, save); e != nil {
+		t.Fatal(e)
+	}
+	if len(d.entries) != 0 {
+		t.Errorf("expect all deleted, but %v remains", d.entries)
+	}
+	for _, p := range d.lists {
+		if p == "a/b" {
+			t.Error("expect the removed directory not to be walked again")
+		}
+	}
+	if len(saved.Removed) != 1 || saved.Removed[0] != "a" {
+		t.Errorf("expect the removed directories to be merged, but %v", saved.Removed)
+	}
+
+	if e := DeleteResumable(task.DummyContext(), d, &resumed, save); e != nil {
+		t.Errorf("expect deleting again to succeed, but %v", e)
+	}
+}
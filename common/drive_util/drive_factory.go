@@ -4,6 +4,7 @@ import (
 	"context"
 	"go-drive/common"
 	"go-drive/common/types"
+	"io"
 )
 
 type DriveFactoryConfig struct {
@@ -11,7 +12,21 @@ type DriveFactoryConfig struct {
 	DisplayName string           `json:"display_name" i18n:""`
 	README      string           `json:"readme" i18n:""`
 	ConfigForm  []types.FormItem `json:"config_form"`
-	Factory     DriveFactory     `json:"-"`
+	// DynamicConfig indicates that the form should be driven by Factory.ConfigStep, set by RegisterDrive
+	DynamicConfig bool         `json:"dynamic_config"`
+	Factory       DriveFactory `json:"-"`
+}
+
+// DriveConfigStep is a step of the guided configuration
+type DriveConfigStep struct {
+	// Form is the form items to be filled in this step, including the fields filled in previous steps
+	Form []types.FormItem `json:"form"`
+	// README is the help text of this step
+	README string `json:"readme" i18n:""`
+	// Errors are the validation errors keyed by field
+	Errors types.SM `json:"errors"`
+	// Done indicates that the config is complete and can be saved
+	Done bool `json:"done"`
 }
 
 type DriveInitConfig struct {
@@ -39,10 +54,35 @@ type DriveDataStore interface {
 	Load(...string) (types.SM, error)
 }
 
+// DriveFileStore stores the files of a drive in the database, the paths of the parents are not checked.
+type DriveFileStore interface {
+	// Get returns the file of path, or nil if not exists
+	Get(path string) (*types.DriveFile, error)
+	// List returns the children of the directory
+	List(path string) ([]types.DriveFile, error)
+	// MakeDir creates the directory, without creating the parents
+	MakeDir(path string, modTime int64) (*types.DriveFile, error)
+	// Write writes the content of the file, the old content of the file is replaced
+	Write(path string, modTime int64, reader io.Reader) (*types.DriveFile, error)
+	// Read reads the content of the file
+	Read(file types.DriveFile) (io.ReadCloser, error)
+	// Move moves the file or directory with its descendants, the target must not exist
+	Move(from, to string) error
+	// Delete deletes the file or directory with its descendants
+	Delete(path string) error
+}
+
 type DriveUtils struct {
 	Data        DriveDataStore
 	CreateCache DriveCacheFactory
-	Config      common.Config
+	// Files stores the files in the database, for the drives without external storage
+	Files  DriveFileStore
+	Config common.Config
+	// Locker is used to serialize mutations on the same path
+	Locker PathLocker
+	// Root returns the drive of all mounts, for the drives built on the content of other drives.
+	// Those drives call it when they are accessed rather than when creating, as the other mounts may be not ready then
+	Root func() types.IDrive
 }
 
 type DriveFactory struct {
@@ -52,4 +92,7 @@ type DriveFactory struct {
 	Init func(context.Context, types.SM, DriveConfig, DriveUtils) error
 	// Create creates a drive instance by config map
 	Create func(context.Context, DriveConfig, DriveUtils) (types.IDrive, error)
+	// ConfigStep is optional, it returns the next step of configuration by the partial config.
+	// Drives without it use the static ConfigForm.
+	ConfigStep func(context.Context, DriveConfig, DriveUtils) (*DriveConfigStep, error)
 }
@@ -0,0 +1,38 @@
+package drive_util
+
+import (
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"strings"
+)
+
+// DriveOptionsForm is the config form of DriveOptions, which is available to all drives
+var DriveOptionsForm = []types.FormItem{
+	{Field: "root_name", Label: i18n.T("drive.options.form.root_name.label"), Type: "text", Description: i18n.T("drive.options.form.root_name.description")},
+	{Field: "normalize_separators", Label: i18n.T("drive.options.form.normalize_separators.label"), Type: "checkbox", Description: i18n.T("drive.options.form.normalize_separators.description")},
+	{Field: "hls", Label: i18n.T("drive.options.form.hls.label"), Type: "checkbox", Description: i18n.T("drive.options.form.hls.description")},
+}
+
+// DriveOptions are the options handled by the dispatcher for all drives
+type DriveOptions struct {
+	// RootName is the display name of the drive root, the drive name is displayed if it's empty
+	RootName string
+	// NormalizeSeparators treats the backslashes in the paths as separators, see utils.NormalizePath
+	NormalizeSeparators bool
+	// HLS allows the videos to be transcoded to HLS for playback, see server.HLS
+	HLS bool
+}
+
+// NewDriveOptions creates DriveOptions from the drive config
+func NewDriveOptions(config types.SM) DriveOptions {
+	return DriveOptions{
+		RootName:            strings.TrimSpace(config["root_name"]),
+		NormalizeSeparators: config["normalize_separators"] != "",
+		HLS:                 config["hls"] != "",
+	}
+}
+
+// CommonConfigForm returns the config form items available to all drives
+func CommonConfigForm() []types.FormItem {
+	return append(append([]types.FormItem{}, DriveOptionsForm...), UploadTransformForm...)
+}
@@ -0,0 +1,310 @@
+package drive_util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var errNoExif = errors.New("no exif")
+
+// the EXIF tags
+const (
+	exifTagMake             = 0x010f
+	exifTagModel            = 0x0110
+	exifTagOrientation      = 0x0112
+	exifTagSoftware         = 0x0131
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagGPSIFD           = 0x8825
+	exifTagExposureTime     = 0x829a
+	exifTagFNumber          = 0x829d
+	exifTagISO              = 0x8827
+	exifTagDateTimeOriginal = 0x9003
+	exifTagFlash            = 0x9209
+	exifTagFocalLength      = 0x920a
+	exifTagPixelXDimension  = 0xa002
+	exifTagPixelYDimension  = 0xa003
+	exifTagLensModel        = 0xa434
+
+	gpsTagLatitudeRef  = 1
+	gpsTagLatitude     = 2
+	gpsTagLongitudeRef = 3
+	gpsTagLongitude    = 4
+	gpsTagAltitudeRef  = 5
+	gpsTagAltitude     = 6
+)
+
+// exifTypeSizes are the byte sizes of the TIFF field types
+var exifTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// Exif is the commonly used EXIF data of a photo, fields are empty if not present
+type Exif struct {
+	Make             string
+	Model            string
+	LensModel        string
+	Software         string
+	DateTime         string
+	DateTimeOriginal string
+	// Orientation is 1-8, see the EXIF specification
+	Orientation int
+	// ExposureTime is like '1/125'
+	ExposureTime string
+	FNumber      float64
+	ISO          int
+	// FocalLength is in millimeters
+	FocalLength float64
+	Flash       bool
+	Width       int
+	Height      int
+
+	HasGPS bool
+	// Latitude and Longitude are in degrees, negative for south and west
+	Latitude  float64
+	Longitude float64
+	// Altitude is in meters, negative for below the sea level
+	Altitude float64
+}
+
+// ReadExif reads the EXIF data of a JPEG or TIFF image
+func ReadExif(r io.Reader) (*Exif, error) {
+	br := bufio.NewReader(r)
+	magic, e := br.Peek(4)
+	if e != nil {
+		return nil, errNoExif
+	}
+	if string(magic) == "II*\x00" || string(magic) == "MM\x00*" {
+		dat, e := ioutil.ReadAll(br)
+		if e != nil {
+			return nil, e
+		}
+		return parseTIFFExif(dat)
+	}
+	if magic[0] != 0xff || magic[1] != 0xd8 {
+		return nil, errNoExif
+	}
+	_, _ = br.Discard(2)
+	header := make([]byte, 4)
+	for {
+		if _, e := io.ReadFull(br, header); e != nil {
+			return nil, errNoExif
+		}
+		if header[0] != 0xff {
+			return nil, errNoExif
+		}
+		marker := header[1]
+		// the start of the scan, or the end of the image
+		if marker == 0xda || marker == 0xd9 {
+			return nil, errNoExif
+		}
+		size := int(binary.BigEndian.Uint16(header[2:])) - 2
+		if size < 0 {
+			return nil, errNoExif
+		}
+		if marker != 0xe1 {
+			if _, e := br.Discard(size); e != nil {
+				return nil, errNoExif
+			}
+			continue
+		}
+		dat := make([]byte, size)
+		if _, e := io.ReadFull(br, dat); e != nil {
+			return nil, errNoExif
+		}
+		if bytes.HasPrefix(dat, []byte("Exif\x00\x00")) {
+			return parseTIFFExif(dat[6:])
+		}
+	}
+}
+
+type tiffReader struct {
+	dat   []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func parseTIFFExif(dat []byte) (*Exif, error) {
+	if len(dat) < 8 {
+		return nil, errNoExif
+	}
+	t := &tiffReader{dat: dat}
+	switch string(dat[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, errNoExif
+	}
+	ifd0 := t.readIFD(t.order.Uint32(dat[4:8]))
+	if ifd0 == nil {
+		return nil, errNoExif
+	}
+	x := &Exif{
+		Make:        t.str(ifd0, exifTagMake),
+		Model:       t.str(ifd0, exifTagModel),
+		Software:    t.str(ifd0, exifTagSoftware),
+		DateTime:    t.str(ifd0, exifTagDateTime),
+		Orientation: int(t.uint(ifd0, exifTagOrientation)),
+	}
+	if offset, ok := t.uintOk(ifd0, exifTagExifIFD); ok {
+		if sub := t.readIFD(offset); sub != nil {
+			x.DateTimeOriginal = t.str(sub, exifTagDateTimeOriginal)
+			x.LensModel = t.str(sub, exifTagLensModel)
+			x.ISO = int(t.uint(sub, exifTagISO))
+			x.FNumber = t.rational(sub, exifTagFNumber, 0)
+			x.FocalLength = t.rational(sub, exifTagFocalLength, 0)
+			x.Width = int(t.uint(sub, exifTagPixelXDimension))
+			x.Height = int(t.uint(sub, exifTagPixelYDimension))
+			if flash, ok := t.uintOk(sub, exifTagFlash); ok {
+				// the lowest bit tells whether the flash fired
+				x.Flash = flash&1 != 0
+			}
+			if e, ok := sub[exifTagExposureTime]; ok && e.typ == 5 && e.count >= 1 {
+				num, den := t.order.Uint32(e.value[0:4]), t.order.Uint32(e.value[4:8])
+				if num != 0 && den != 0 {
+					// 10/1250 is shown as 1/125
+					if num < den && num != 1 {
+						num, den = 1, uint32(float64(den)/float64(num)+0.5)
+					}
+					x.ExposureTime = formatExposure(num, den)
+				}
+			}
+		}
+	}
+	if offset, ok := t.uintOk(ifd0, exifTagGPSIFD); ok {
+		if gps := t.readIFD(offset); gps != nil {
+			lat, latOk := t.degrees(gps, gpsTagLatitude)
+			lon, lonOk := t.degrees(gps, gpsTagLongitude)
+			if latOk && lonOk {
+				x.HasGPS = true
+				if strings.HasPrefix(t.str(gps, gpsTagLatitudeRef), "S") {
+					lat = -lat
+				}
+				if strings.HasPrefix(t.str(gps, gpsTagLongitudeRef), "W") {
+					lon = -lon
+				}
+				x.Latitude, x.Longitude = lat, lon
+				x.Altitude = t.rational(gps, gpsTagAltitude, 0)
+				if e, ok := gps[gpsTagAltitudeRef]; ok && len(e.value) > 0 && e.value[0] == 1 {
+					x.Altitude = -x.Altitude
+				}
+			}
+		}
+	}
+	return x, nil
+}
+
+func formatExposure(num, den uint32) string {
+	if num >= den {
+		return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+	}
+	return strconv.FormatUint(uint64(num), 10) + "/" + strconv.FormatUint(uint64(den), 10)
+}
+
+// readIFD reads the entries of the IFD at offset, nil if it's out of the bounds
+func (t *tiffReader) readIFD(offset uint32) map[uint16]ifdEntry {
+	if uint64(offset)+2 > uint64(len(t.dat)) {
+		return nil
+	}
+	n := int(t.order.Uint16(t.dat[offset:]))
+	start := int(offset) + 2
+	if start+n*12 > len(t.dat) {
+		return nil
+	}
+	entries := make(map[uint16]ifdEntry, n)
+	for i := 0; i < n; i++ {
+		b := t.dat[start+i*12 : start+i*12+12]
+		typ, count := t.order.Uint16(b[2:4]), t.order.Uint32(b[4:8])
+		size, ok := exifTypeSizes[typ]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(count)
+		var value []byte
+		if total <= 4 {
+			value = b[8 : 8+total]
+		} else {
+			valueOffset := uint64(t.order.Uint32(b[8:12]))
+			if valueOffset+total > uint64(len(t.dat)) {
+				continue
+			}
+			value = t.dat[valueOffset : valueOffset+total]
+		}
+		entries[t.order.Uint16(b[0:2])] = ifdEntry{typ: typ, count: count, value: value}
+	}
+	return entries
+}
+
+func (t *tiffReader) str(ifd map[uint16]ifdEntry, tag uint16) string {
+	e, ok := ifd[tag]
+	if !ok || e.typ != 2 {
+		return ""
+	}
+	s := e.value
+	if i := bytes.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(string(s))
+}
+
+func (t *tiffReader) uintOk(ifd map[uint16]ifdEntry, tag uint16) (uint32, bool) {
+	e, ok := ifd[tag]
+	if !ok || e.count < 1 {
+		return 0, false
+	}
+	switch e.typ {
+	case 1, 7:
+		return uint32(e.value[0]), true
+	case 3:
+		return uint32(t.order.Uint16(e.value)), true
+	case 4:
+		return t.order.Uint32(e.value), true
+	}
+	return 0, false
+}
+
+func (t *tiffReader) uint(ifd map[uint16]ifdEntry, tag uint16) uint32 {
+	v, _ := t.uintOk(ifd, tag)
+	return v
+}
+
+// rational returns the i-th value of the RATIONAL or SRATIONAL tag, 0 if it's not present
+func (t *tiffReader) rational(ifd map[uint16]ifdEntry, tag uint16, i int) float64 {
+	e, ok := ifd[tag]
+	if !ok || (e.typ != 5 && e.typ != 10) || uint32(i) >= e.count {
+		return 0
+	}
+	b := e.value[i*8:]
+	if e.typ == 10 {
+		num, den := int32(t.order.Uint32(b[0:4])), int32(t.order.Uint32(b[4:8]))
+		if den == 0 {
+			return 0
+		}
+		return float64(num) / float64(den)
+	}
+	num, den := t.order.Uint32(b[0:4]), t.order.Uint32(b[4:8])
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+// degrees returns the degrees of the GPS coordinate of degrees, minutes and seconds
+func (t *tiffReader) degrees(ifd map[uint16]ifdEntry, tag uint16) (float64, bool) {
+	e, ok := ifd[tag]
+	if !ok || e.typ != 5 || e.count < 3 {
+		return 0, false
+	}
+	return t.rational(ifd, tag, 0) + t.rational(ifd, tag, 1)/60 + t.rational(ifd, tag, 2)/3600, true
+}
@@ -0,0 +1,116 @@
+package drive_util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+type testIFDEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// buildTIFF lays out the IFDs in order after the header, the values longer than 4 bytes follow each IFD.
+// The entries which value is nil point to the IFD of the next index.
+func buildTIFF(ifds ...[]testIFDEntry) []byte {
+	order := binary.LittleEndian
+	offsets := make([]uint32, len(ifds))
+	offset := uint32(8)
+	for i, ifd := range ifds {
+		offsets[i] = offset
+		offset += 2 + uint32(len(ifd))*12 + 4
+		for _, e := range ifd {
+			if len(e.value) > 4 {
+				offset += uint32(len(e.value))
+			}
+		}
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("II*\x00")
+	_ = binary.Write(buf, order, uint32(8))
+	next := 1
+	for i, ifd := range ifds {
+		_ = binary.Write(buf, order, uint16(len(ifd)))
+		extra := &bytes.Buffer{}
+		extraOffset := offsets[i] + 2 + uint32(len(ifd))*12 + 4
+		for _, e := range ifd {
+			_ = binary.Write(buf, order, e.tag)
+			_ = binary.Write(buf, order, e.typ)
+			_ = binary.Write(buf, order, e.count)
+			value := e.value
+			if value == nil {
+				value = make([]byte, 4)
+				order.PutUint32(value, offsets[next])
+				next++
+			}
+			if len(value) > 4 {
+				_ = binary.Write(buf, order, extraOffset+uint32(extra.Len()))
+				extra.Write(value)
+			} else {
+				buf.Write(append(value, make([]byte, 4-len(value))...))
+			}
+		}
+		_ = binary.Write(buf, order, uint32(0))
+		buf.Write(extra.Bytes())
+	}
+	return buf.Bytes()
+}
+
+func rationals(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, n := range v {
+		binary.LittleEndian.PutUint32(b[i*4:], n)
+	}
+	return b
+}
+
+func TestReadExif(t *testing.T) {
+	tiff := buildTIFF(
+		[]testIFDEntry{
+			{exifTagMake, 2, 6, []byte("Canon\x00")},
+			{exifTagOrientation, 3, 1, []byte{6, 0}},
+			{exifTagExifIFD, 4, 1, nil},
+			{exifTagGPSIFD, 4, 1, nil},
+		},
+		[]testIFDEntry{
+			{exifTagExposureTime, 5, 1, rationals(10, 1250)},
+			{exifTagFNumber, 5, 1, rationals(28, 10)},
+			{exifTagISO, 3, 1, []byte{200, 0}},
+			{exifTagFlash, 3, 1, []byte{0x19, 0}},
+		},
+		[]testIFDEntry{
+			{gpsTagLatitudeRef, 2, 2, []byte("N\x00")},
+			{gpsTagLatitude, 5, 3, rationals(30, 1, 15, 1, 36, 1)},
+			{gpsTagLongitudeRef, 2, 2, []byte("W\x00")},
+			{gpsTagLongitude, 5, 3, rationals(120, 1, 30, 1, 0, 1)},
+		},
+	)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe0, 0, 4, 0, 0, 0xff, 0xe1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}
+	jpeg = append(append(jpeg, app1...), 0xff, 0xda, 0, 2)
+
+	for name, dat := range map[string][]byte{"jpeg": jpeg, "tiff": tiff} {
+		x, e := ReadExif(bytes.NewReader(dat))
+		if e != nil {
+			t.Fatalf("%s: %v", name, e)
+		}
+		if x.Make != "Canon" || x.Orientation != 6 || x.ExposureTime != "1/125" || x.FNumber != 2.8 ||
+			x.ISO != 200 || !x.Flash {
+			t.Errorf("%s: unexpected exif %+v", name, x)
+		}
+		if !x.HasGPS || math.Abs(x.Latitude-30.26) > 1e-9 || x.Longitude != -120.5 {
+			t.Errorf("%s: unexpected GPS %v, %v", name, x.Latitude, x.Longitude)
+		}
+	}
+
+	if _, e := ReadExif(bytes.NewReader([]byte{0xff, 0xd8, 0xff, 0xda, 0, 2})); e != errNoExif {
+		t.Errorf("expected errNoExif, got %v", e)
+	}
+	if _, e := ReadExif(bytes.NewReader([]byte("\x89PNG\r\n"))); e != errNoExif {
+		t.Errorf("expected errNoExif, got %v", e)
+	}
+}
@@ -0,0 +1,211 @@
+package drive_util
+
+import (
+	"context"
+	"fmt"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DownloadScheduler is the shared bandwidth scheduler of the downloads served by this server
+var DownloadScheduler = NewFairScheduler(0)
+
+// FairScheduler shares a total bandwidth among the active streams.
+// The streams read in small chunks, and each chunk reserves the next time slot of the shared bandwidth in turn,
+// so every stream waiting for data gets an equal share, which grows as other streams finish or slow down.
+type FairScheduler struct {
+	mux   *sync.Mutex
+	limit int64
+	// next is when the shared bandwidth is available again
+	next time.Time
+
+	streams map[int64]*FairStream
+	nextID  int64
+}
+
+// NewFairScheduler creates a FairScheduler, limit is the total bytes per second, <= 0 means unlimited
+func NewFairScheduler(limit int64) *FairScheduler {
+	return &FairScheduler{
+		mux:     &sync.Mutex{},
+		limit:   limit,
+		streams: make(map[int64]*FairStream),
+	}
+}
+
+// SetLimit changes the total bytes per second, limit <= 0 means unlimited
+func (s *FairScheduler) SetLimit(limit int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.limit = limit
+}
+
+// Open registers a stream, which must be closed by Close.
+// nil is returned if the bandwidth is unlimited, the methods of a nil FairStream pass through.
+func (s *FairScheduler) Open(name string) *FairStream {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.limit <= 0 {
+		return nil
+	}
+	s.nextID++
+	stream := &FairStream{s: s, id: s.nextID, name: name, opened: time.Now()}
+	s.streams[stream.id] = stream
+	return stream
+}
+
+// chunk returns the maximum bytes a stream can read at once
+func (s *FairScheduler) chunk() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	c := s.limit / 20
+	if c < 1024 {
+		c = 1024
+	}
+	if c > 32*1024 {
+		c = 32 * 1024
+	}
+	return int(c)
+}
+
+// wait blocks until the n bytes read by stream are allowed by the shared bandwidth
+func (s *FairScheduler) wait(ctx context.Context, stream *FairStream, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	s.mux.Lock()
+	stream.allocated += int64(n)
+	if s.limit <= 0 {
+		s.mux.Unlock()
+		return nil
+	}
+	now := time.Now()
+	start := s.next
+	if start.Before(now) {
+		start = now
+	}
+	s.next = start.Add(time.Duration(float64(n) / float64(s.limit) * float64(time.Second)))
+	s.mux.Unlock()
+
+	d := start.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *FairScheduler) Status() (string, types.SM, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	limit := "unlimited"
+	share := "unlimited"
+	if s.limit > 0 {
+		limit = utils.FormatBytes(uint64(s.limit), 2) + "/s"
+		if len(s.streams) > 0 {
+			share = utils.FormatBytes(uint64(s.limit/int64(len(s.streams))), 2) + "/s"
+		}
+	}
+	sm := types.SM{
+		"Limit":     limit,
+		"Active":    strconv.Itoa(len(s.streams)),
+		"FairShare": share,
+	}
+	ids := make([]int64, 0, len(s.streams))
+	for id := range s.streams {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		stream := s.streams[id]
+		elapsed := time.Since(stream.opened).Seconds()
+		rate := uint64(0)
+		if elapsed > 0 {
+			rate = uint64(float64(stream.allocated) / elapsed)
+		}
+		sm[fmt.Sprintf("Stream#%d", id)] = fmt.Sprintf("%s: %s/s, %s",
+			stream.name, utils.FormatBytes(rate, 2), utils.FormatBytes(uint64(stream.allocated), 2))
+	}
+	return "Download Scheduler", sm, nil
+}
+
+// FairStream is a stream sharing the bandwidth of a FairScheduler
+type FairStream struct {
+	s      *FairScheduler
+	id     int64
+	name   string
+	opened time.Time
+	// allocated is the total bytes allowed to be read
+	allocated int64
+}
+
+// Reader wraps reader to read under the shared bandwidth, the result is an io.ReadSeeker if reader is
+func (f *FairStream) Reader(ctx context.Context, reader io.Reader) io.Reader {
+	if f == nil {
+		return reader
+	}
+	r := &fairReader{ctx: ctx, r: reader, f: f}
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		return &fairReadSeeker{fairReader: r, seeker: seeker}
+	}
+	return r
+}
+
+// ReadCloser wraps reader as Reader does, the Close is passed through
+func (f *FairStream) ReadCloser(ctx context.Context, reader io.ReadCloser) io.ReadCloser {
+	if f == nil {
+		return reader
+	}
+	return &fairReadCloser{Reader: f.Reader(ctx, reader), Closer: reader}
+}
+
+// Close unregisters the stream
+func (f *FairStream) Close() {
+	if f == nil {
+		return
+	}
+	f.s.mux.Lock()
+	defer f.s.mux.Unlock()
+	delete(f.s.streams, f.id)
+}
+
+type fairReader struct {
+	ctx context.Context
+	r   io.Reader
+	f   *FairStream
+}
+
+func (r *fairReader) Read(p []byte) (int, error) {
+	if c := r.f.s.chunk(); len(p) > c {
+		p = p[:c]
+	}
+	n, e := r.r.Read(p)
+	if we := r.f.s.wait(r.ctx, r.f, n); we != nil && e == nil {
+		e = we
+	}
+	return n, e
+}
+
+type fairReadSeeker struct {
+	*fairReader
+	seeker io.Seeker
+}
+
+func (r *fairReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
+
+type fairReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,69 @@
+package drive_util
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// readFor reads from the streams concurrently for d, and returns the bytes read by each
+func readFor(s *FairScheduler, streams int, d time.Duration) []int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	read := make([]int64, streams)
+	wg := sync.WaitGroup{}
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stream := s.Open("test")
+			defer stream.Close()
+			read[i], _ = io.Copy(ioutil.Discard, stream.Reader(ctx, zeroReader{}))
+		}(i)
+	}
+	wg.Wait()
+	return read
+}
+
+func TestFairScheduler(t *testing.T) {
+	const limit = 200 * 1024
+	s := NewFairScheduler(limit)
+
+	single := readFor(s, 1, 500*time.Millisecond)
+	if single[0] < limit/4 || single[0] > limit*3/4 {
+		t.Errorf("expect a single stream to take the whole bandwidth, but it read %d bytes", single[0])
+	}
+
+	read := readFor(s, 4, 500*time.Millisecond)
+	total := int64(0)
+	for _, n := range read {
+		total += n
+	}
+	if total > limit*3/4 {
+		t.Errorf("expect the streams to share the bandwidth, but they read %d bytes", total)
+	}
+	for i, n := range read {
+		if n < total/8 {
+			t.Errorf("expect stream %d to get a fair share of %d bytes, but it read %d bytes", i, total, n)
+		}
+	}
+	if _, sm, _ := s.Status(); sm["Active"] != "0" {
+		t.Errorf("expect no active streams, but %s", sm["Active"])
+	}
+
+	if stream := NewFairScheduler(0).Open("test"); stream != nil {
+		t.Error("expect no stream when the bandwidth is unlimited")
+	}
+}
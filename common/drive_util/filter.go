@@ -0,0 +1,142 @@
+package drive_util
+
+import (
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"path"
+	"strings"
+)
+
+// CopyFilter selects the entries to be copied by CopyAllFiltered.
+// Patterns are matched against the path relative to the copy root,
+// '*', '?' and '[...]' match in a path segment as path.Match does, '**' matches any number of segments.
+// A pattern without '/' is matched against the name of the entry.
+type CopyFilter struct {
+	// Include are the patterns of the files to be copied, all files are included if it's empty
+	Include []string
+	// Exclude are the patterns of the files or directories not to be copied, they take precedence over Include
+	Exclude []string
+	// PruneEmptyDirs drops the directories which have no files to be copied
+	PruneEmptyDirs bool
+
+	// MinSize and MaxSize are the limits of the file size, no limit if <= 0.
+	// Directories are always traversed.
+	MinSize int64
+	MaxSize int64
+	// SkipUnknownSize skips the files whose size is unknown when a size limit is set, they are included by default
+	SkipUnknownSize bool
+	// Skipped is called with the files skipped by the size limits
+	Skipped func(entry types.IEntry)
+}
+
+// NewCopyFilter creates a CopyFilter of the patterns
+func NewCopyFilter(include, exclude []string, pruneEmptyDirs bool) (*CopyFilter, error) {
+	f := &CopyFilter{PruneEmptyDirs: pruneEmptyDirs}
+	for _, p := range include {
+		if p = cleanPattern(p); p != "" {
+			f.Include = append(f.Include, p)
+		}
+	}
+	for _, p := range exclude {
+		if p = cleanPattern(p); p != "" {
+			f.Exclude = append(f.Exclude, p)
+		}
+	}
+	for _, p := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, e := path.Match(strings.ReplaceAll(p, "**", "*"), ""); e != nil {
+			return nil, err.NewBadRequestError(i18n.T("drive.invalid_filter_pattern", p))
+		}
+	}
+	return f, nil
+}
+
+// IsEmpty tells whether the filter selects all entries
+func (f *CopyFilter) IsEmpty() bool {
+	return f == nil || (len(f.Include) == 0 && len(f.Exclude) == 0 && !f.PruneEmptyDirs &&
+		f.MinSize <= 0 && f.MaxSize <= 0)
+}
+
+func cleanPattern(p string) string {
+	return strings.Trim(strings.TrimSpace(p), "/")
+}
+
+// Excluded tells whether the entry of the relative path is excluded
+func (f *CopyFilter) Excluded(rel string) bool {
+	if f == nil {
+		return false
+	}
+	for _, p := range f.Exclude {
+		if matchPattern(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchFile tells whether the file of the relative path should be copied
+func (f *CopyFilter) MatchFile(rel string) bool {
+	if f == nil {
+		return true
+	}
+	if f.Excluded(rel) {
+		return false
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, p := range f.Include {
+		if matchPattern(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchSize tells whether the file is within the size limits, Skipped is called if it's not
+func (f *CopyFilter) MatchSize(entry types.IEntry) bool {
+	if f == nil || (f.MinSize <= 0 && f.MaxSize <= 0) {
+		return true
+	}
+	size := entry.Size()
+	ok := true
+	if size < 0 {
+		ok = !f.SkipUnknownSize
+	} else if (f.MinSize > 0 && size < f.MinSize) || (f.MaxSize > 0 && size > f.MaxSize) {
+		ok = false
+	}
+	if !ok && f.Skipped != nil {
+		f.Skipped(entry)
+	}
+	return ok
+}
+
+func matchPattern(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		segments = segments[1:]
+	}
+	return len(segments) == 0
+}
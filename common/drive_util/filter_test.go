@@ -0,0 +1,104 @@
+package drive_util
+
+import (
+	"go-drive/common/types"
+	"testing"
+)
+
+func TestCopyFilter(t *testing.T) {
+	f, e := NewCopyFilter(
+		[]string{"*.go", "docs/**/*.md", "/assets/**"},
+		[]string{"*_test.go", "vendor", "assets/tmp/**", "docs/draft/*.md"},
+		false,
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+	cases := map[string]bool{
+		"main.go":               true,
+		"a/b/c.go":              true,
+		"a/b/c_test.go":         false,
+		"vendor/x/y.go":         true, // directories are excluded by the tree walk, see TestCopyFilterExcludedDir
+		"docs/a.md":             true,
+		"docs/x/y/z.md":         true,
+		"docs/draft/a.md":       false,
+		"docs/draft/sub/a.md":   true,
+		"a/docs/x.md":           false,
+		"readme.md":             false,
+		"assets/logo.png":       true,
+		"assets/tmp/a.png":      false,
+		"assets/tmp/deep/a.png": false,
+	}
+	for rel, want := range cases {
+		if got := f.MatchFile(rel); got != want {
+			t.Errorf("MatchFile(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+func TestCopyFilterExcludedDir(t *testing.T) {
+	f, e := NewCopyFilter(nil, []string{"vendor", "build/*"}, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	cases := map[string]bool{
+		"vendor":     true,
+		"a/vendor":   true,
+		"vendors":    false,
+		"build":      false,
+		"build/out":  true,
+		"a/build/ou": false,
+	}
+	for rel, want := range cases {
+		if got := f.Excluded(rel); got != want {
+			t.Errorf("Excluded(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+func TestNewCopyFilter(t *testing.T) {
+	if f, e := NewCopyFilter([]string{" ", ""}, nil, false); !f.IsEmpty() || e != nil {
+		t.Errorf("expect empty filter, but it's %v, %v", f, e)
+	}
+	if _, e := NewCopyFilter([]string{"[a"}, nil, false); e == nil {
+		t.Error("expect error of the invalid pattern")
+	}
+}
+
+type sizeTestEntry struct {
+	types.IEntry
+	size int64
+}
+
+func (e sizeTestEntry) Size() int64 {
+	return e.size
+}
+
+func TestCopyFilterSize(t *testing.T) {
+	skipped := 0
+	f := &CopyFilter{MinSize: 10, MaxSize: 100, Skipped: func(types.IEntry) { skipped++ }}
+	cases := []struct {
+		size        int64
+		skipUnknown bool
+		want        bool
+	}{
+		{9, false, false},
+		{10, false, true},
+		{100, false, true},
+		{101, false, false},
+		{-1, false, true},
+		{-1, true, false},
+	}
+	for _, c := range cases {
+		f.SkipUnknownSize = c.skipUnknown
+		if got := f.MatchSize(sizeTestEntry{size: c.size}); got != c.want {
+			t.Errorf("MatchSize(%d), SkipUnknownSize: %v, got %v, want %v", c.size, c.skipUnknown, got, c.want)
+		}
+	}
+	if skipped != 3 {
+		t.Errorf("expect 3 skipped, but it's %d", skipped)
+	}
+	if f.IsEmpty() {
+		t.Error("expect the filter with size limits not to be empty")
+	}
+}
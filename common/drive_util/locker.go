@@ -0,0 +1,142 @@
+package drive_util
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PathLocker serializes mutations on the same path.
+// The keys are hierarchical by '/' or '\', the lock of a key excludes the locks of its ancestors and descendants,
+// so that deleting a directory and saving a file in it are serialized.
+// Implementations may coordinate across multiple go-drive instances.
+type PathLocker interface {
+	// Lock blocks until the lock of key is acquired or ctx is done.
+	// The returned function releases the lock, it's safe to be called more than once.
+	Lock(ctx context.Context, key string) (func(), error)
+}
+
+func isLockKeySeparator(c byte) bool {
+	return c == '/' || c == '\\'
+}
+
+// LockKeyAncestors returns the ancestors of the key, the nearest first
+func LockKeyAncestors(key string) []string {
+	ancestors := make([]string, 0)
+	for i := len(key) - 1; i > 0; i-- {
+		if isLockKeySeparator(key[i]) && !isLockKeySeparator(key[i-1]) {
+			ancestors = append(ancestors, key[:i])
+		}
+	}
+	return ancestors
+}
+
+// IsLockKeyDescendant tells whether key is a descendant of ancestor
+func IsLockKeyDescendant(key, ancestor string) bool {
+	return len(key) > len(ancestor) && strings.HasPrefix(key, ancestor) && isLockKeySeparator(key[len(ancestor)])
+}
+
+func lockKeysConflict(a, b string) bool {
+	return a == b || IsLockKeyDescendant(a, b) || IsLockKeyDescendant(b, a)
+}
+
+// lockKeyLess orders the keys like walking the tree, the separators are the smallest,
+// so that the ancestors come before the descendants, and the subtrees are not interleaved
+func lockKeyLess(a, b string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ca, cb := a[i], b[i]
+		if ca == cb || (isLockKeySeparator(ca) && isLockKeySeparator(cb)) {
+			continue
+		}
+		if isLockKeySeparator(ca) {
+			return true
+		}
+		if isLockKeySeparator(cb) {
+			return false
+		}
+		return ca < cb
+	}
+	return len(a) < len(b)
+}
+
+// LockAll acquires locks of all keys in a stable order to avoid deadlocks.
+// The keys covered by their ancestors in keys are not locked again
+func LockAll(ctx context.Context, locker PathLocker, keys ...string) (func(), error) {
+	sorted := append([]string(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool { return lockKeyLess(sorted[i], sorted[j]) })
+	unique := sorted[:0]
+	for _, k := range sorted {
+		if len(unique) > 0 && lockKeysConflict(unique[len(unique)-1], k) {
+			continue
+		}
+		unique = append(unique, k)
+	}
+	unlocks := make([]func(), 0, len(unique))
+	unlockAll := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, k := range unique {
+		unlock, e := locker.Lock(ctx, k)
+		if e != nil {
+			unlockAll()
+			return nil, e
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return unlockAll, nil
+}
+
+// NewMemLocker creates an in-process PathLocker
+func NewMemLocker() PathLocker {
+	return &memLocker{held: make(map[string]bool), released: make(chan struct{}), mux: &sync.Mutex{}}
+}
+
+type memLocker struct {
+	held map[string]bool
+	// released is closed and replaced when a lock is released, to wake up the waiting ones
+	released chan struct{}
+	mux      *sync.Mutex
+}
+
+func (m *memLocker) conflicts(key string) bool {
+	if m.held[key] {
+		return true
+	}
+	for k := range m.held {
+		if lockKeysConflict(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memLocker) Lock(ctx context.Context, key string) (func(), error) {
+	for {
+		m.mux.Lock()
+		if !m.conflicts(key) {
+			m.held[key] = true
+			m.mux.Unlock()
+			break
+		}
+		released := m.released
+		m.mux.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			m.mux.Lock()
+			delete(m.held, key)
+			close(m.released)
+			m.released = make(chan struct{})
+			m.mux.Unlock()
+		})
+	}, nil
+}
@@ -0,0 +1,76 @@
+package drive_util
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMemLockerHierarchy(t *testing.T) {
+	l := NewMemLocker()
+	unlock, e := l.Lock(context.Background(), "fs:/data/a")
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, key := range []string{"fs:/data/a", "fs:/data/a/b", "fs:/data"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		if _, e := l.Lock(ctx, key); e == nil {
+			t.Errorf("'%s' is locked while 'fs:/data/a' is held", key)
+		}
+		cancel()
+	}
+	for _, key := range []string{"fs:/data/ab", "fs:/data/b/a"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		u, e := l.Lock(ctx, key)
+		if e != nil {
+			t.Errorf("'%s' is not locked: %v", key, e)
+		} else {
+			u()
+		}
+		cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		u, e := l.Lock(context.Background(), "fs:/data/a/b")
+		if e == nil {
+			u()
+		}
+		close(done)
+	}()
+	unlock()
+	unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the waiting lock is not acquired after released")
+	}
+}
+
+func TestLockAll(t *testing.T) {
+	l := NewMemLocker()
+	// the descendant is covered by its ancestor, which would deadlock otherwise
+	unlock, e := LockAll(context.Background(), l, "a/b/c", "a-b", "a/b", "a")
+	if e != nil {
+		t.Fatal(e)
+	}
+	unlock()
+
+	keys := []string{"a-b", "a/b", "a", "a!", "b"}
+	sort.Slice(keys, func(i, j int) bool { return lockKeyLess(keys[i], keys[j]) })
+	want := []string{"a", "a/b", "a!", "a-b", "b"}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("unexpected order %v", keys)
+		}
+	}
+
+	if !IsLockKeyDescendant(`fs:C:\data\a`, `fs:C:\data`) {
+		t.Error("'\\' is not a separator")
+	}
+	ancestors := LockKeyAncestors("fs:/data/a/b")
+	if len(ancestors) != 3 || ancestors[0] != "fs:/data/a" || ancestors[2] != "fs:" {
+		t.Errorf("unexpected ancestors %v", ancestors)
+	}
+}
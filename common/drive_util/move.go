@@ -0,0 +1,122 @@
+package drive_util
+
+import (
+	"context"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"log"
+	"path"
+	"strconv"
+	"time"
+)
+
+type moveVerifyHashKey struct{}
+
+// WithMoveVerifyHash makes the moves by copying verify the content hashes of the copies, besides their sizes
+func WithMoveVerifyHash(ctx types.TaskCtx) types.TaskCtx {
+	return task.WithValue(ctx, moveVerifyHashKey{}, true)
+}
+
+// MoveVerifyHash tells whether the moves by copying with ctx verify the content hashes
+func MoveVerifyHash(ctx context.Context) bool {
+	v, _ := ctx.Value(moveVerifyHashKey{}).(bool)
+	return v
+}
+
+// MoveTempPath returns a hidden sibling of to, where the copy of a move is made before it's renamed to to
+func MoveTempPath(to string) string {
+	return path.Join(utils.PathParent(to),
+		"."+utils.PathBase(to)+".move-"+strconv.FormatInt(utils.Millisecond(time.Now()), 10))
+}
+
+// MoveAcross moves from to driveTo in two phases, for the drives can not move the entries natively.
+// driveTo must be able to copy from, the entries are copied to a temp path next to 'to' first, then the copies are verified by VerifyCopy,
+// and renamed to 'to' by driveTo, the source is deleted by deleteFrom only after all these succeeded.
+// The source is kept if any step failed, so a corrupted copy never results in lost data.
+func MoveAcross(ctx types.TaskCtx, from types.IEntry, driveTo types.IDrive, to string, override bool,
+	deleteFrom func(ctx types.TaskCtx) error) (types.IEntry, error) {
+	if !override {
+		if _, e := RequireFileNotExists(ctx, driveTo, to); e != nil {
+			return nil, e
+		}
+	}
+	temp := MoveTempPath(to)
+	cleanTemp := func() {
+		if e := driveTo.Delete(task.NewCtxWrapper(ctx, false, false), temp); e != nil && !err.IsNotFoundError(e) {
+			log.Printf("error when removing the temp copy '%s': %v", temp, e)
+		}
+	}
+	if _, e := driveTo.Copy(ctx, from, temp, true); e != nil {
+		cleanTemp()
+		return nil, e
+	}
+	if e := VerifyCopy(ctx, from, driveTo, temp, MoveVerifyHash(ctx)); e != nil {
+		cleanTemp()
+		return nil, e
+	}
+	tempEntry, e := driveTo.Get(ctx, temp)
+	if e != nil {
+		cleanTemp()
+		return nil, e
+	}
+	moved, e := driveTo.Move(ctx, tempEntry, to, override)
+	if e != nil {
+		cleanTemp()
+		return nil, e
+	}
+	if e := deleteFrom(ctx); e != nil {
+		log.Printf("error when deleting the source '%s' of the move: %v", from.Path(), e)
+		return nil, e
+	}
+	return moved, nil
+}
+
+// VerifyCopy checks that the tree of 'to' in driveTo has all entries of from, of the same sizes.
+// The contents are compared by their hashes as well if hash is true.
+func VerifyCopy(ctx types.TaskCtx, from types.IEntry, driveTo types.IDrive, to string, hash bool) error {
+	// the verification does not report progress
+	ctx = task.NewCtxWrapper(ctx, false, false)
+	tree, e := BuildEntriesTree(ctx, from, false)
+	if e != nil {
+		return e
+	}
+	return verifyCopy(ctx, tree, driveTo, to, hash)
+}
+
+func verifyCopy(ctx types.TaskCtx, node EntryNode, driveTo types.IDrive, to string, hash bool) error {
+	if e := ctx.WaitIfPaused(); e != nil {
+		return e
+	}
+	dst, e := driveTo.Get(ctx, to)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return mismatchedCopy(node)
+		}
+		return e
+	}
+	if node.Type() != dst.Type() {
+		return mismatchedCopy(node)
+	}
+	if node.Type().IsFile() {
+		if node.Size() >= 0 && node.Size() != dst.Size() {
+			return mismatchedCopy(node)
+		}
+		if hash && !sameContentHash(ctx, node.IEntry, dst) {
+			return mismatchedCopy(node)
+		}
+		return nil
+	}
+	for _, child := range node.children {
+		if e := verifyCopy(ctx, child, driveTo, path.Join(to, utils.PathBase(child.Path())), hash); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func mismatchedCopy(entry types.IEntry) error {
+	return err.NewBadRequestError(i18n.T("drive.move_verification_failed", entry.Path()))
+}
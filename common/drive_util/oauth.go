@@ -7,8 +7,10 @@ import (
 	"go-drive/common/types"
 	"go-drive/common/utils"
 	"golang.org/x/oauth2"
+	"log"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -54,7 +56,11 @@ func oauthGet(o OAuthRequest, config DriveConfig, ds DriveDataStore) (*OAuthResp
 	if e != nil {
 		return nil, e
 	}
-	expiresAt := time.Unix(utils.ToInt64(params["expires_at"], -1), 0)
+	// the tokens never expire if the expiry is unknown
+	var expiresAt time.Time
+	if v := utils.ToInt64(params["expires_at"], -1); v > 0 {
+		expiresAt = time.Unix(v, 0)
+	}
 	t := &oauth2.Token{
 		AccessToken:  params["token"],
 		TokenType:    params["token_type"],
@@ -64,7 +70,7 @@ func oauthGet(o OAuthRequest, config DriveConfig, ds DriveDataStore) (*OAuthResp
 	if t.AccessToken == "" {
 		t = nil
 	}
-	if t != nil && t.RefreshToken == "" && expiresAt.Before(time.Now()) {
+	if t != nil && t.RefreshToken == "" && !expiresAt.IsZero() && expiresAt.Before(time.Now()) {
 		t = nil
 	}
 	return &OAuthResponse{Config: getOAuthConfig(o, config), Token: t}, nil
@@ -117,14 +123,57 @@ func OAuthInit(ctx context.Context, o OAuthRequest, data types.SM,
 	if e != nil {
 		return nil, e
 	}
-	return &OAuthResponse{Config: oauthConf, Token: t},
-		ds.Save(types.SM{
-			"state":         "",
-			"token":         t.AccessToken,
-			"token_type":    t.TokenType,
-			"refresh_token": t.RefreshToken,
-			"expires_at":    strconv.FormatInt(t.Expiry.Unix(), 10),
-		})
+	saved := oauthTokenData(t)
+	saved["state"] = ""
+	return &OAuthResponse{Config: oauthConf, Token: t}, ds.Save(saved)
+}
+
+func oauthTokenData(t *oauth2.Token) types.SM {
+	return types.SM{
+		"token":         t.AccessToken,
+		"token_type":    t.TokenType,
+		"refresh_token": t.RefreshToken,
+		"expires_at":    strconv.FormatInt(t.Expiry.Unix(), 10),
+	}
+}
+
+// SaveOAuthToken saves the token to the data store, it's loaded by OAuthGet
+func SaveOAuthToken(ds DriveDataStore, t *oauth2.Token) error {
+	return ds.Save(oauthTokenData(t))
+}
+
+// persistentTokenSource saves the refreshed tokens to the data store
+type persistentTokenSource struct {
+	ts   oauth2.TokenSource
+	ds   DriveDataStore
+	mux  sync.Mutex
+	last string
+}
+
+// PersistentTokenSource returns the TokenSource which saves the refreshed tokens to the data store,
+// it's required by the services which rotate the refresh token on every refresh
+func PersistentTokenSource(ts oauth2.TokenSource, current *oauth2.Token, ds DriveDataStore) oauth2.TokenSource {
+	last := ""
+	if current != nil {
+		last = current.AccessToken
+	}
+	return &persistentTokenSource{ts: ts, ds: ds, last: last}
+}
+
+func (p *persistentTokenSource) Token() (*oauth2.Token, error) {
+	t, e := p.ts.Token()
+	if e != nil {
+		return nil, e
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if t.AccessToken != p.last {
+		p.last = t.AccessToken
+		if e := SaveOAuthToken(p.ds, t); e != nil {
+			log.Printf("error when saving the refreshed token: %v", e)
+		}
+	}
+	return t, nil
 }
 
 func OAuthGet(o OAuthRequest, config DriveConfig, ds DriveDataStore) (*OAuthResponse, error) {
@@ -0,0 +1,88 @@
+package drive_util
+
+import (
+	"context"
+	"go-drive/common/types"
+	"io"
+)
+
+type rangePart struct {
+	data []byte
+	e    error
+}
+
+// parallelRangeReader reads the parts of the content by range requests in parallel, and returns them in order
+type parallelRangeReader struct {
+	cancel context.CancelFunc
+	// parts are the results of the parts in order, its buffer limits the number of the parts being read
+	parts chan chan rangePart
+
+	buf []byte
+	e   error
+}
+
+// ParallelRangeReader reads the content of size by parts of partSize, at most concurrency parts are read at the same time.
+// The parts are buffered in memory, so at most (concurrency + 1) * partSize bytes are in use.
+func ParallelRangeReader(ctx context.Context, rr types.IRangeReader, size, partSize int64,
+	concurrency int) io.ReadCloser {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &parallelRangeReader{cancel: cancel, parts: make(chan chan rangePart, concurrency)}
+	go r.dispatch(ctx, rr, size, partSize)
+	return r
+}
+
+func (r *parallelRangeReader) dispatch(ctx context.Context, rr types.IRangeReader, size, partSize int64) {
+	defer close(r.parts)
+	for offset := int64(0); offset < size; offset += partSize {
+		length := partSize
+		if size-offset < length {
+			length = size - offset
+		}
+		part := make(chan rangePart, 1)
+		select {
+		case r.parts <- part:
+		case <-ctx.Done():
+			return
+		}
+		go func(offset, length int64) {
+			data, e := readRange(ctx, rr, offset, length)
+			part <- rangePart{data: data, e: e}
+		}(offset, length)
+	}
+}
+
+func readRange(ctx context.Context, rr types.IRangeReader, offset, length int64) ([]byte, error) {
+	reader, e := rr.GetRangeReader(ctx, offset, length)
+	if e != nil {
+		return nil, e
+	}
+	defer func() { _ = reader.Close() }()
+	data := make([]byte, length)
+	if _, e := io.ReadFull(reader, data); e != nil {
+		return nil, e
+	}
+	return data, nil
+}
+
+func (r *parallelRangeReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.e != nil {
+			return 0, r.e
+		}
+		part, ok := <-r.parts
+		if !ok {
+			r.e = io.EOF
+			continue
+		}
+		result := <-part
+		r.buf, r.e = result.data, result.e
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *parallelRangeReader) Close() error {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,69 @@
+package drive_util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type bytesRangeReader struct {
+	data    []byte
+	failAt  int64
+	reading int32
+	maxRead int32
+}
+
+func (b *bytesRangeReader) GetRangeReader(_ context.Context, offset, length int64) (io.ReadCloser, error) {
+	if b.failAt >= 0 && offset <= b.failAt && b.failAt < offset+length {
+		return nil, errors.New("failed")
+	}
+	n := atomic.AddInt32(&b.reading, 1)
+	defer atomic.AddInt32(&b.reading, -1)
+	for {
+		m := atomic.LoadInt32(&b.maxRead)
+		if n <= m || atomic.CompareAndSwapInt32(&b.maxRead, m, n) {
+			break
+		}
+	}
+	// the later parts are slower, so they are read at the same time
+	time.Sleep(10 * time.Millisecond)
+	return ioutil.NopCloser(bytes.NewReader(b.data[offset : offset+length])), nil
+}
+
+func TestParallelRangeReader(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	rr := &bytesRangeReader{data: data, failAt: -1}
+	r := ParallelRangeReader(context.Background(), rr, int64(len(data)), 64, 3)
+	read, e := ioutil.ReadAll(r)
+	_ = r.Close()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatal("the content is not the same")
+	}
+	if rr.maxRead < 2 || rr.maxRead > 4 {
+		t.Errorf("unexpected concurrency: %d", rr.maxRead)
+	}
+}
+
+func TestParallelRangeReaderError(t *testing.T) {
+	rr := &bytesRangeReader{data: make([]byte, 1000), failAt: 500}
+	r := ParallelRangeReader(context.Background(), rr, 1000, 64, 3)
+	defer func() { _ = r.Close() }()
+	read, e := ioutil.ReadAll(r)
+	if e == nil {
+		t.Fatal("expect the error of the failed part")
+	}
+	if len(read) != 448 {
+		t.Errorf("expect the parts before the failed one, but got %d bytes", len(read))
+	}
+}
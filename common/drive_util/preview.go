@@ -0,0 +1,194 @@
+package drive_util
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"go-drive/common/types"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// previewHeaderSize is the maximum bytes read from each file to create the preview
+	previewHeaderSize = 64 * 1024
+	previewMaxLineLen = 256
+)
+
+// EntryPreview is the lightweight preview of a file, fields are empty if not supported
+type EntryPreview struct {
+	// FirstLine is the first line of a text file
+	FirstLine string
+	// Width and Height are the dimensions of an image
+	Width  int
+	Height int
+	// Duration is the duration in seconds of an audio/video file
+	Duration float64
+}
+
+type EntryWithPreview struct {
+	Entry   types.IEntry
+	Preview EntryPreview
+}
+
+// ListWithPreviews lists the directory and creates the previews of the files concurrently.
+// At most 'workers' previews are created at the same time, and each of them is bounded by 'timeout',
+// a file failed or timed out has an empty preview.
+func ListWithPreviews(ctx context.Context, drive types.IDrive, path string,
+	workers int, timeout time.Duration) ([]EntryWithPreview, error) {
+	entries, e := drive.List(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	result := make([]EntryWithPreview, len(entries))
+	sem := make(chan struct{}, workers)
+	wg := sync.WaitGroup{}
+	for i, entry := range entries {
+		result[i].Entry = entry
+		content, ok := entry.(types.IContent)
+		if !ok || !entry.Type().IsFile() {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, content types.IContent) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			pCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			result[i].Preview = CreatePreview(pCtx, content)
+		}(i, content)
+	}
+	wg.Wait()
+	return result, nil
+}
+
+// CreatePreview reads the header bytes of the content and creates the preview
+func CreatePreview(ctx context.Context, content types.IContent) EntryPreview {
+	p := EntryPreview{}
+	reader, e := GetIContentRangeReader(ctx, content, 0, previewHeaderSize)
+	if e != nil {
+		return p
+	}
+	header, e := ioutil.ReadAll(io.LimitReader(reader, previewHeaderSize))
+	_ = reader.Close()
+	if e != nil || len(header) == 0 {
+		return p
+	}
+
+	contentType := http.DetectContentType(header)
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		if conf, _, e := image.DecodeConfig(bytes.NewReader(header)); e == nil {
+			p.Width = conf.Width
+			p.Height = conf.Height
+		}
+	case strings.HasPrefix(contentType, "text/"):
+		p.FirstLine = firstLine(header)
+	case contentType == "audio/wave":
+		p.Duration = wavDuration(header)
+	case contentType == "video/mp4" || contentType == "audio/mp4":
+		p.Duration = mp4Duration(header)
+	}
+	return p
+}
+
+func firstLine(dat []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(dat))
+	s.Buffer(make([]byte, len(dat)), len(dat))
+	if !s.Scan() {
+		return ""
+	}
+	line := bytes.TrimPrefix(s.Bytes(), []byte("\xef\xbb\xbf"))
+	if len(line) > previewMaxLineLen {
+		line = line[:previewMaxLineLen]
+		// do not cut in the middle of a character
+		for len(line) > 0 && !utf8.Valid(line) {
+			line = line[:len(line)-1]
+		}
+	}
+	return strings.TrimSpace(string(line))
+}
+
+// wavDuration reads the 'fmt ' and 'data' chunks of the RIFF header
+func wavDuration(dat []byte) float64 {
+	if len(dat) < 12 {
+		return 0
+	}
+	var byteRate, dataSize uint32
+	for i := 12; i+8 <= len(dat); {
+		id := string(dat[i : i+4])
+		size := binary.LittleEndian.Uint32(dat[i+4 : i+8])
+		switch id {
+		case "fmt ":
+			if i+20 <= len(dat) {
+				byteRate = binary.LittleEndian.Uint32(dat[i+16 : i+20])
+			}
+		case "data":
+			dataSize = size
+		}
+		if dataSize > 0 {
+			break
+		}
+		i += 8 + int(size) + int(size%2)
+	}
+	if byteRate == 0 || dataSize == 0 {
+		return 0
+	}
+	return float64(dataSize) / float64(byteRate)
+}
+
+// mp4Duration finds the 'mvhd' box in 'moov', it works only if 'moov' is at the beginning(fast start)
+func mp4Duration(dat []byte) float64 {
+	for i := 0; i+8 <= len(dat); {
+		size := int(binary.BigEndian.Uint32(dat[i : i+4]))
+		box := string(dat[i+4 : i+8])
+		if box == "moov" {
+			// descend into moov
+			i += 8
+			continue
+		}
+		if box == "mvhd" {
+			b := dat[i+8:]
+			if len(b) < 1 {
+				return 0
+			}
+			var timescale uint32
+			var duration uint64
+			if b[0] == 1 {
+				if len(b) < 32 {
+					return 0
+				}
+				timescale = binary.BigEndian.Uint32(b[20:24])
+				duration = binary.BigEndian.Uint64(b[24:32])
+			} else {
+				if len(b) < 20 {
+					return 0
+				}
+				timescale = binary.BigEndian.Uint32(b[12:16])
+				duration = uint64(binary.BigEndian.Uint32(b[16:20]))
+			}
+			if timescale == 0 {
+				return 0
+			}
+			return float64(duration) / float64(timescale)
+		}
+		if size < 8 {
+			return 0
+		}
+		i += size
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package drive_util
+
+import (
+	"context"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"log"
+	"path"
+	"time"
+)
+
+// prewarmInterval is the minimum interval between two List calls when pre-warming
+const prewarmInterval = 200 * time.Millisecond
+
+// Prewarm lists the directories of the top maxDepth levels of the drive in background,
+// so that the caches of the drive are populated before browsing.
+// The drive is usable while warming, the returned func cancels it.
+func Prewarm(drive types.IDrive, maxDepth int) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go prewarm(ctx, drive, maxDepth)
+	return cancel
+}
+
+func prewarm(ctx context.Context, drive types.IDrive, maxDepth int) {
+	type dir struct {
+		path  string
+		depth int
+	}
+	queue := []dir{{path: "", depth: 1}}
+	n := 0
+	for len(queue) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(prewarmInterval):
+		}
+		d := queue[0]
+		queue = queue[1:]
+		entries, e := drive.List(ctx, d.path)
+		if e != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("error when pre-warming '%s': %v", d.path, e)
+			continue
+		}
+		n++
+		if d.depth >= maxDepth {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Type().IsDir() {
+				queue = append(queue, dir{path: path.Join(d.path, utils.PathBase(entry.Path())), depth: d.depth + 1})
+			}
+		}
+	}
+	if utils.IsDebugOn() {
+		log.Printf("pre-warming finished, %d directories listed", n)
+	}
+}
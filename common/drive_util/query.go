@@ -0,0 +1,231 @@
+package drive_util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"io"
+	"sort"
+)
+
+const hashChunkSize = 1024 * 1024
+
+// FindByProp finds the entries under root which prop key matches value.
+// A prop matches when its string form equals to value, or, for list props(tags), it contains value.
+// The drive's native index is used if it implements types.IPropIndex, otherwise the tree is walked.
+// Matched entries are passed to fn as they are found.
+func FindByProp(ctx types.TaskCtx, drive types.IDrive, root, key, value string, fn func(types.IEntry) error) error {
+	if index, ok := drive.(types.IPropIndex); ok {
+		entries, e := index.FindByProp(ctx, root, key, value)
+		if e != nil {
+			return e
+		}
+		ctx.Total(int64(len(entries)), true)
+		for _, entry := range entries {
+			if ctx.Canceled() {
+				return task.ErrorCanceled
+			}
+			if e := fn(entry); e != nil {
+				return e
+			}
+			ctx.Progress(1, false)
+		}
+		return nil
+	}
+	return walkEntries(ctx, drive, root, func(entry types.IEntry) error {
+		if propMatches(entry.Meta().Props[key], value) {
+			return fn(entry)
+		}
+		return nil
+	})
+}
+
+// FindDuplicates finds the files under root which have the same content.
+// Files are grouped by size first, then by the hash of the content.
+// types.IContentHash is used when all files of the same size provide the same algorithm,
+// otherwise the contents are read and hashed.
+// Each group of duplicated files is passed to fn, the progress is the bytes read.
+func FindDuplicates(ctx types.TaskCtx, drive types.IDrive, root string, fn func([]types.IEntry) error) error {
+	bySize := make(map[int64][]types.IEntry)
+	e := walkEntries(task.NewCtxWrapper(ctx, false, false), drive, root, func(entry types.IEntry) error {
+		if entry.Type().IsFile() && entry.Size() > 0 {
+			bySize[entry.Size()] = append(bySize[entry.Size()], entry)
+		}
+		return nil
+	})
+	if e != nil {
+		return e
+	}
+	sizes := make([]int64, 0, len(bySize))
+	for size, entries := range bySize {
+		if len(entries) > 1 {
+			sizes = append(sizes, size)
+			ctx.Total(size*int64(len(entries)), false)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] > sizes[j] })
+
+	for _, size := range sizes {
+		entries := bySize[size]
+		hashes, e := hashEntries(ctx, entries)
+		if e != nil {
+			return e
+		}
+		groups := make(map[string][]types.IEntry)
+		for i, entry := range entries {
+			if hashes[i] != "" {
+				groups[hashes[i]] = append(groups[hashes[i]], entry)
+			}
+		}
+		for _, group := range groups {
+			if len(group) < 2 {
+				continue
+			}
+			sort.Slice(group, func(i, j int) bool { return group[i].Path() < group[j].Path() })
+			if e := fn(group); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// ListChangedSince finds the entries under root modified after since, in milliseconds.
+// Unlike the delta tokens, nothing is kept between the calls, so it works for all drives.
+// A directory's ModTime only changes when its direct children are added, removed or renamed,
+// but not when a file is modified in place or anything deeper changes,
+// so the subtrees can not be pruned by it and the whole tree is walked.
+// A directory is matched by its own ModTime, which tells that its direct children changed.
+// The entries of unknown ModTime are never matched.
+// Matched entries are passed to fn as they are found.
+func ListChangedSince(ctx types.TaskCtx, drive types.IDrive, root string, since int64,
+	fn func(types.IEntry) error) error {
+	return walkEntries(ctx, drive, root, func(entry types.IEntry) error {
+		if entry.ModTime() > since {
+			return fn(entry)
+		}
+		return nil
+	})
+}
+
+func walkEntries(ctx types.TaskCtx, drive types.IDrive, root string, fn func(types.IEntry) error) error {
+	queue := []string{root}
+	for len(queue) > 0 {
+		if ctx.Canceled() {
+			return task.ErrorCanceled
+		}
+		dir := queue[0]
+		queue = queue[1:]
+		entries, e := drive.List(ctx, dir)
+		if e != nil {
+			return e
+		}
+		ctx.Total(int64(len(entries)), false)
+		for _, entry := range entries {
+			if entry.Type().IsDir() {
+				queue = append(queue, entry.Path())
+			}
+			if e := fn(entry); e != nil {
+				return e
+			}
+			ctx.Progress(1, false)
+		}
+	}
+	return nil
+}
+
+func propMatches(prop interface{}, value string) bool {
+	switch v := prop.(type) {
+	case nil:
+		return false
+	case []string:
+		for _, s := range v {
+			if s == value {
+				return true
+			}
+		}
+		return false
+	case []interface{}:
+		for _, s := range v {
+			if fmt.Sprint(s) == value {
+				return true
+			}
+		}
+		return false
+	default:
+		return fmt.Sprint(v) == value
+	}
+}
+
+// hashEntries returns the hashes of the entries, empty for the entries which are not readable
+func hashEntries(ctx types.TaskCtx, entries []types.IEntry) ([]string, error) {
+	hashes := make([]string, len(entries))
+	algorithm := ""
+	native := true
+	for i, entry := range entries {
+		h, ok := GetIEntry(entry, func(e types.IEntry) bool {
+			_, ok := e.(types.IContentHash)
+			return ok
+		}).(types.IContentHash)
+		if !ok {
+			native = false
+			break
+		}
+		alg, hash, e := h.ContentHash(ctx)
+		if e != nil || (algorithm != "" && alg != algorithm) {
+			native = false
+			break
+		}
+		algorithm = alg
+		hashes[i] = hash
+	}
+	if native {
+		for _, entry := range entries {
+			ctx.Progress(entry.Size(), false)
+		}
+		return hashes, nil
+	}
+
+	for i, entry := range entries {
+		content, ok := GetIEntry(entry, func(e types.IEntry) bool {
+			_, ok := e.(types.IContent)
+			return ok
+		}).(types.IContent)
+		if !ok {
+			hashes[i] = ""
+			ctx.Progress(entry.Size(), false)
+			continue
+		}
+		hash, e := hashContent(ctx, content)
+		if e != nil {
+			return nil, e
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
+func hashContent(ctx types.TaskCtx, content types.IContent) (string, error) {
+	reader, e := GetIContentReader(ctx, content)
+	if e != nil {
+		return "", e
+	}
+	defer func() { _ = reader.Close() }()
+	h := sha256.New()
+	for {
+		if ctx.Canceled() {
+			return "", task.ErrorCanceled
+		}
+		n, e := io.CopyN(h, reader, hashChunkSize)
+		ctx.Progress(n, false)
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return "", e
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
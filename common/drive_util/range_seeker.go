@@ -0,0 +1,96 @@
+package drive_util
+
+import (
+	"context"
+	"errors"
+	"go-drive/common/types"
+	"io"
+	"io/ioutil"
+)
+
+// seekDiscardThreshold is the maximum gap of a forward seek that is done by reading and discarding
+const seekDiscardThreshold = 256 * 1024
+
+var errInvalidSeek = errors.New("invalid seek")
+
+// RangeReadSeeker is a virtual io.ReadSeeker over an IRangeReader.
+// Seeking only moves the position, the underlying reader is reopened at the position by a range request
+// when reading, unless the position is just ahead of the current reader.
+type RangeReadSeeker struct {
+	ctx  context.Context
+	rr   types.IRangeReader
+	size int64
+
+	reader    io.ReadCloser
+	readerPos int64
+	pos       int64
+}
+
+// NewRangeReadSeeker creates a RangeReadSeeker of the content with the size.
+// reader is optional, it's the reader at the beginning of the content, and will be closed by RangeReadSeeker.
+func NewRangeReadSeeker(ctx context.Context, rr types.IRangeReader, size int64,
+	reader io.ReadCloser) *RangeReadSeeker {
+	return &RangeReadSeeker{ctx: ctx, rr: rr, size: size, reader: reader}
+}
+
+func (r *RangeReadSeeker) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if e := r.prepare(); e != nil {
+		return 0, e
+	}
+	n, e := r.reader.Read(p)
+	r.pos += int64(n)
+	r.readerPos = r.pos
+	return n, e
+}
+
+// prepare makes the reader at the current position
+func (r *RangeReadSeeker) prepare() error {
+	if r.reader != nil && r.readerPos == r.pos {
+		return nil
+	}
+	if r.reader != nil && r.pos > r.readerPos && r.pos-r.readerPos <= seekDiscardThreshold {
+		n, e := io.CopyN(ioutil.Discard, r.reader, r.pos-r.readerPos)
+		r.readerPos += n
+		if e == nil {
+			return nil
+		}
+	}
+	_ = r.Close()
+	reader, e := r.rr.GetRangeReader(r.ctx, r.pos, -1)
+	if e != nil {
+		return e
+	}
+	r.reader = reader
+	r.readerPos = r.pos
+	return nil
+}
+
+func (r *RangeReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		pos += r.pos
+	case io.SeekEnd:
+		pos += r.size
+	default:
+		return r.pos, errInvalidSeek
+	}
+	if pos < 0 {
+		return r.pos, errInvalidSeek
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+func (r *RangeReadSeeker) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	e := r.reader.Close()
+	r.reader = nil
+	return e
+}
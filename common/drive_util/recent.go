@@ -0,0 +1,70 @@
+package drive_util
+
+import (
+	"container/heap"
+	"context"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"sort"
+)
+
+// RecentFiles walks root up to maxDepth levels(< 0 means unlimited)
+// and returns at most limit files sorted by modTime descending
+func RecentFiles(ctx context.Context, drive types.IDrive, root string, maxDepth, limit int) ([]types.IEntry, error) {
+	if limit <= 0 {
+		return []types.IEntry{}, nil
+	}
+	h := &entriesByModTime{}
+	type dir struct {
+		path  string
+		depth int
+	}
+	queue := []dir{{utils.CleanPath(root), 0}}
+	for len(queue) > 0 {
+		if e := ctx.Err(); e != nil {
+			return nil, e
+		}
+		d := queue[0]
+		queue = queue[1:]
+		entries, e := drive.List(ctx, d.path)
+		if e != nil {
+			return nil, e
+		}
+		for _, entry := range entries {
+			if entry.Type().IsDir() {
+				if maxDepth < 0 || d.depth < maxDepth {
+					queue = append(queue, dir{entry.Path(), d.depth + 1})
+				}
+				continue
+			}
+			if h.Len() < limit {
+				heap.Push(h, entry)
+			} else if entry.ModTime() > (*h)[0].ModTime() {
+				(*h)[0] = entry
+				heap.Fix(h, 0)
+			}
+		}
+	}
+	result := []types.IEntry(*h)
+	sort.Slice(result, func(i, j int) bool { return result[i].ModTime() > result[j].ModTime() })
+	return result, nil
+}
+
+// entriesByModTime is a min-heap of entries by modTime
+type entriesByModTime []types.IEntry
+
+func (h entriesByModTime) Len() int           { return len(h) }
+func (h entriesByModTime) Less(i, j int) bool { return h[i].ModTime() < h[j].ModTime() }
+func (h entriesByModTime) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *entriesByModTime) Push(x interface{}) {
+	*h = append(*h, x.(types.IEntry))
+}
+
+func (h *entriesByModTime) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
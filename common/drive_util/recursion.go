@@ -0,0 +1,57 @@
+package drive_util
+
+import (
+	"context"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+)
+
+// RecursionGuard stops a drive over the root drive, like the union and the crypt drives,
+// being accessed through itself when it's mounted in the directories it accesses.
+// The guards of the drives being accessed are kept in the context.
+type RecursionGuard struct {
+	// message is the error message of the recursions
+	message string
+}
+
+type recursionGuardKey struct{}
+
+type recursionGuards struct {
+	g      *RecursionGuard
+	parent *recursionGuards
+}
+
+// NewRecursionGuard creates the guard of a drive, message is the error message of the recursions
+func NewRecursionGuard(message string) *RecursionGuard {
+	return &RecursionGuard{message: message}
+}
+
+// enter marks ctx as accessed through the drive, it fails if ctx is already accessed through the drive
+func (g *RecursionGuard) enter(ctx context.Context) (*recursionGuards, error) {
+	parent, _ := ctx.Value(recursionGuardKey{}).(*recursionGuards)
+	for r := parent; r != nil; r = r.parent {
+		if r.g == g {
+			return nil, err.NewNotAllowedMessageError(g.message)
+		}
+	}
+	return &recursionGuards{g: g, parent: parent}, nil
+}
+
+// Context returns the ctx to access the root drive with
+func (g *RecursionGuard) Context(ctx context.Context) (context.Context, error) {
+	r, e := g.enter(ctx)
+	if e != nil {
+		return nil, e
+	}
+	return context.WithValue(ctx, recursionGuardKey{}, r), nil
+}
+
+// TaskContext returns the task ctx to access the root drive with
+func (g *RecursionGuard) TaskContext(ctx types.TaskCtx) (types.TaskCtx, error) {
+	r, e := g.enter(ctx)
+	if e != nil {
+		return nil, e
+	}
+	return task.WithValue(ctx, recursionGuardKey{}, r), nil
+}
@@ -0,0 +1,34 @@
+package drive_util
+
+import (
+	"context"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"testing"
+)
+
+func TestRecursionGuard(t *testing.T) {
+	a, b := NewRecursionGuard("a"), NewRecursionGuard("b")
+	ctx, e := a.Context(context.Background())
+	if e != nil {
+		t.Fatal(e)
+	}
+	ctx, e = b.Context(ctx)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := a.Context(ctx); !err.IsNotAllowedError(e) || e.Error() != "a" {
+		t.Errorf("expected the recursion of a, got %v", e)
+	}
+	tc, e := b.TaskContext(task.DummyContext())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := b.TaskContext(tc); !err.IsNotAllowedError(e) || e.Error() != "b" {
+		t.Errorf("expected the recursion of b, got %v", e)
+	}
+	// the siblings are not recursions
+	if _, e := b.Context(context.Background()); e != nil {
+		t.Error(e)
+	}
+}
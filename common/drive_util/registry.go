@@ -1,10 +1,14 @@
 package drive_util
 
+import "go-drive/common/types"
+
 type DrivesRegistry map[string]DriveFactoryConfig
 
 var registry DrivesRegistry = make(map[string]DriveFactoryConfig)
 
 func RegisterDrive(factory DriveFactoryConfig) {
+	factory.DynamicConfig = factory.Factory.ConfigStep != nil
+	factory.ConfigForm = append(append([]types.FormItem{}, factory.ConfigForm...), CommonConfigForm()...)
 	registry[factory.Type] = factory
 }
 
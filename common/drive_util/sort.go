@@ -0,0 +1,129 @@
+package drive_util
+
+import (
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	SortByName    = "name"
+	SortNatural   = "natural"
+	SortByModTime = "mod_time"
+	SortBySize    = "size"
+)
+
+// SortEntries sorts entries by mode, see EntryComparator.
+// Entries keep the order returned by the drive if mode is empty.
+func SortEntries(entries []types.IEntry, mode string) error {
+	if mode == "" {
+		return nil
+	}
+	compare, e := EntryComparator(mode)
+	if e != nil {
+		return e
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return compare(entries[i], entries[j]) < 0 })
+	return nil
+}
+
+// EntryComparator returns the comparator of mode, which is one of the Sort* constants,
+// with an optional '-' prefix for descending order.
+func EntryComparator(mode string) (func(a, b types.IEntry) int, error) {
+	desc := strings.HasPrefix(mode, "-")
+	mode = strings.TrimPrefix(mode, "-")
+	var compare func(a, b types.IEntry) int
+	switch mode {
+	case SortByName:
+		compare = func(a, b types.IEntry) int {
+			return strings.Compare(utils.PathBase(a.Path()), utils.PathBase(b.Path()))
+		}
+	case SortNatural:
+		compare = func(a, b types.IEntry) int {
+			return CompareNatural(utils.PathBase(a.Path()), utils.PathBase(b.Path()))
+		}
+	case SortByModTime:
+		compare = func(a, b types.IEntry) int { return compareInt64(a.ModTime(), b.ModTime()) }
+	case SortBySize:
+		compare = func(a, b types.IEntry) int { return compareInt64(a.Size(), b.Size()) }
+	default:
+		return nil, err.NewBadRequestError(i18n.T("drive.invalid_sort_mode", mode))
+	}
+	if desc {
+		return func(a, b types.IEntry) int { return compare(b, a) }, nil
+	}
+	return compare, nil
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// CompareNatural compares a and b in the natural order:
+// numbers embedded in the strings are compared by their values, letters are compared case-insensitively.
+// For the strings which are equal in the natural order,
+// the one with fewer leading zeros comes first, then they are compared case-sensitively,
+// so that the order is deterministic.
+func CompareNatural(a, b string) int {
+	tie := 0
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		ca, cb := a[i], b[j]
+		if isDigit(ca) && isDigit(cb) {
+			si, sj := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			na, nb := strings.TrimLeft(a[si:i], "0"), strings.TrimLeft(b[sj:j], "0")
+			if len(na) != len(nb) {
+				return compareInt64(int64(len(na)), int64(len(nb)))
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			if tie == 0 {
+				// fewer leading zeros first
+				tie = compareInt64(int64(i-si), int64(j-sj))
+			}
+			continue
+		}
+		ra, wa := utf8.DecodeRuneInString(a[i:])
+		rb, wb := utf8.DecodeRuneInString(b[j:])
+		la, lb := unicode.ToLower(ra), unicode.ToLower(rb)
+		if la != lb {
+			return compareInt64(int64(la), int64(lb))
+		}
+		i += wa
+		j += wb
+	}
+	if c := compareInt64(int64(len(a)-i), int64(len(b)-j)); c != 0 {
+		return c
+	}
+	if tie != 0 {
+		return tie
+	}
+	return strings.Compare(a, b)
+}
+
+// NaturalLess reports whether a is before b in the natural order
+func NaturalLess(a, b string) bool {
+	return CompareNatural(a, b) < 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,58 @@
+package drive_util
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCompareNatural(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"file2", "file10", -1},
+		{"file10", "file2", 1},
+		{"file1", "file1", 0},
+		{"File1", "file2", -1},
+		{"FILE10", "file9", 1},
+		{"file01", "file1", 1},
+		{"file001", "file01", 1},
+		{"file010", "file9", 1},
+		{"a", "a1", -1},
+		{"a1b", "a1", 1},
+		{"1", "a", -1},
+		{"v1.2.10", "v1.2.9", 1},
+		{"v1.10.0", "v1.9.99", 1},
+		{"2020-1-5.log", "2020-01-10.log", -1},
+		{"2020-12-31.log", "2021-01-01.log", -1},
+		{"photo 2.jpg", "Photo 10.jpg", -1},
+		{"x99999999999999999999999", "x100000000000000000000000", -1},
+		{"Ä1", "ä2", -1},
+		{"", "a", -1},
+	}
+	for _, c := range cases {
+		if got := sign(CompareNatural(c.a, c.b)); got != c.want {
+			t.Errorf("CompareNatural(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestNaturalSort(t *testing.T) {
+	names := []string{"file10.txt", "File2.txt", "file1.txt", "file02.txt", "file.txt", "file1a.txt"}
+	sort.Slice(names, func(i, j int) bool { return NaturalLess(names[i], names[j]) })
+	want := "file.txt,file1.txt,file1a.txt,File2.txt,file02.txt,file10.txt"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func sign(v int) int {
+	if v < 0 {
+		return -1
+	}
+	if v > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package drive_util
+
+import (
+	"bytes"
+	"context"
+	"go-drive/common/types"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// textSubtypes are the subtypes of the non 'text/*' types which are text
+var textSubtypes = []string{"json", "xml", "javascript", "x-javascript", "x-sh", "x-yaml", "yaml", "toml", "x-tex"}
+
+// MaybeText tells whether the file may be a text file by the extension of its name,
+// the files of the unknown types may be text files as well
+func MaybeText(name string) bool {
+	t := mime.TypeByExtension(strings.ToLower(path.Ext(name)))
+	if t == "" || strings.HasPrefix(t, "text/") {
+		return true
+	}
+	if i := strings.IndexByte(t, ';'); i >= 0 {
+		t = t[:i]
+	}
+	subtype := strings.TrimSpace(t[strings.IndexByte(t, '/')+1:])
+	if strings.HasSuffix(subtype, "+json") || strings.HasSuffix(subtype, "+xml") {
+		return true
+	}
+	for _, s := range textSubtypes {
+		if subtype == s {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectText returns the text of dat if it's UTF-8 encoded text without NUL.
+// The BOM is removed, and the incomplete character at the end is dropped, which is cut by the size limit
+func DetectText(dat []byte) (string, bool) {
+	dat = bytes.TrimPrefix(dat, []byte("\xef\xbb\xbf"))
+	if bytes.IndexByte(dat, 0) >= 0 {
+		return "", false
+	}
+	for i := 0; i < utf8.UTFMax-1 && len(dat) > 0 && !utf8.Valid(dat); i++ {
+		dat = dat[:len(dat)-1]
+	}
+	if !utf8.Valid(dat) {
+		return "", false
+	}
+	return string(dat), true
+}
+
+// ReadIContentText reads at most maxSize bytes of the content, and returns it if it's text
+func ReadIContentText(ctx context.Context, content types.IContent, maxSize int64) (string, bool) {
+	reader, e := content.GetReader(ctx)
+	if e != nil {
+		return "", false
+	}
+	defer func() { _ = reader.Close() }()
+	dat, e := ioutil.ReadAll(io.LimitReader(reader, maxSize))
+	if e != nil {
+		return "", false
+	}
+	return DetectText(dat)
+}
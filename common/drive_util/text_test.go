@@ -0,0 +1,36 @@
+package drive_util
+
+import "testing"
+
+func TestMaybeText(t *testing.T) {
+	for name, want := range map[string]bool{
+		"README":      true,
+		"a.html":      true,
+		"a.JSON":      true,
+		"a.svg":       true,
+		"a.unknown-x": true,
+		"a.png":       false,
+		"a.pdf":       false,
+		"a.wasm":      false,
+	} {
+		if got := MaybeText(name); got != want {
+			t.Errorf("MaybeText(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestDetectText(t *testing.T) {
+	if s, ok := DetectText([]byte("\xef\xbb\xbfhello")); !ok || s != "hello" {
+		t.Errorf("unexpected %q, %v", s, ok)
+	}
+	// '中' is cut in the middle
+	if s, ok := DetectText([]byte("文\xe4\xb8")); !ok || s != "文" {
+		t.Errorf("unexpected %q, %v", s, ok)
+	}
+	if _, ok := DetectText([]byte("PK\x03\x04\x00\x00")); ok {
+		t.Error("binary is detected as text")
+	}
+	if _, ok := DetectText([]byte("\xc4\xe3\xba\xc3, GBK")); ok {
+		t.Error("non UTF-8 is detected as text")
+	}
+}
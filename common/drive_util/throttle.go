@@ -0,0 +1,108 @@
+package drive_util
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// BandwidthLimitHeader is the response header advertising the effective byte-rate limit(bytes per second)
+const BandwidthLimitHeader = "X-Bandwidth-Limit"
+
+type throttle struct {
+	limit int64
+	start time.Time
+	n     int64
+}
+
+func newThrottle(bytesPerSecond int64) *throttle {
+	return &throttle{limit: bytesPerSecond, start: time.Now()}
+}
+
+// chunk returns the maximum bytes can be transferred at once
+func (t *throttle) chunk() int {
+	c := t.limit / 10
+	if c < 1 {
+		c = 1
+	}
+	if c > 32*1024 {
+		c = 32 * 1024
+	}
+	return int(c)
+}
+
+// wait blocks until n more bytes are allowed to be transferred
+func (t *throttle) wait(n int) {
+	t.n += int64(n)
+	expected := time.Duration(float64(t.n) / float64(t.limit) * float64(time.Second))
+	if d := expected - time.Since(t.start); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+type throttledReader struct {
+	r io.Reader
+	t *throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.t.chunk() {
+		p = p[:r.t.chunk()]
+	}
+	n, e := r.r.Read(p)
+	r.t.wait(n)
+	return n, e
+}
+
+// ThrottledReader limits the reading speed of reader, bytesPerSecond <= 0 means unlimited
+func ThrottledReader(reader io.Reader, bytesPerSecond int64) io.Reader {
+	if bytesPerSecond <= 0 {
+		return reader
+	}
+	return &throttledReader{r: reader, t: newThrottle(bytesPerSecond)}
+}
+
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	t *throttle
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + w.t.chunk()
+		if end > len(p) {
+			end = len(p)
+		}
+		n, e := w.ResponseWriter.Write(p[written:end])
+		written += n
+		if e != nil {
+			return written, e
+		}
+		w.t.wait(n)
+	}
+	return written, nil
+}
+
+func (w *throttledResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ThrottledResponseWriter limits the writing speed of the response body,
+// and advertises the limit by BandwidthLimitHeader. bytesPerSecond <= 0 means unlimited
+func ThrottledResponseWriter(w http.ResponseWriter, bytesPerSecond int64) http.ResponseWriter {
+	if bytesPerSecond <= 0 {
+		return w
+	}
+	SetBandwidthLimitHeader(w.Header(), bytesPerSecond)
+	return &throttledResponseWriter{ResponseWriter: w, t: newThrottle(bytesPerSecond)}
+}
+
+func SetBandwidthLimitHeader(header http.Header, bytesPerSecond int64) {
+	if bytesPerSecond > 0 {
+		header.Set(BandwidthLimitHeader, strconv.FormatInt(bytesPerSecond, 10))
+	}
+}
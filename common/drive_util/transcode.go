@@ -0,0 +1,194 @@
+package drive_util
+
+import (
+	"bytes"
+	"github.com/nfnt/resize"
+	"go-drive/common"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// UploadTransformForm is the config form of the upload transform, which is available to all drives
+var UploadTransformForm = []types.FormItem{
+	{Field: "transcode_video", Label: i18n.T("drive.transcode.form.video.label"), Type: "checkbox", Description: i18n.T("drive.transcode.form.video.description")},
+	{Field: "transcode_image", Label: i18n.T("drive.transcode.form.image.label"), Type: "select", Description: i18n.T("drive.transcode.form.image.description"),
+		Options: []types.FormItemOption{
+			{Name: i18n.T("drive.transcode.form.image.none"), Value: ""},
+			{Name: "JPEG", Value: "jpeg"},
+			{Name: "PNG", Value: "png"},
+		}},
+	{Field: "transcode_image_max_size", Label: i18n.T("drive.transcode.form.image_max_size.label"), Type: "text", Description: i18n.T("drive.transcode.form.image_max_size.description")},
+}
+
+// UploadTransform transcodes the uploaded media files before they are saved to the drive.
+// Videos are re-encoded to H.264/AAC mp4 by ffmpeg,
+// images are re-encoded to ImageFormat, and scaled down to fit in ImageMaxSize.
+// Other files are not transformed.
+type UploadTransform struct {
+	Video        bool
+	ImageFormat  string
+	ImageMaxSize uint
+
+	ffmpeg  string
+	tempDir string
+}
+
+// NewUploadTransform creates UploadTransform from the drive config, returns nil if it's not enabled
+func NewUploadTransform(config types.SM, c common.Config) (*UploadTransform, error) {
+	t := &UploadTransform{
+		Video:       config["transcode_video"] != "" && c.FFmpegPath != "",
+		ImageFormat: config["transcode_image"],
+		ffmpeg:      c.FFmpegPath,
+		tempDir:     c.TempDir,
+	}
+	if t.ImageFormat != "" && t.ImageFormat != "jpeg" && t.ImageFormat != "png" {
+		return nil, err.NewBadRequestError(i18n.T("drive.transcode.invalid_image_format", t.ImageFormat))
+	}
+	if s := config["transcode_image_max_size"]; s != "" {
+		size, e := strconv.ParseUint(s, 10, 32)
+		if e != nil {
+			return nil, err.NewBadRequestError(i18n.T("drive.transcode.invalid_image_max_size", s))
+		}
+		t.ImageMaxSize = uint(size)
+	}
+	if !t.Video && t.ImageFormat == "" {
+		return nil, nil
+	}
+	return t, nil
+}
+
+func (t *UploadTransform) isVideo(name string) bool {
+	return t.Video && strings.HasPrefix(mime.TypeByExtension(path.Ext(name)), "video/")
+}
+
+func (t *UploadTransform) isImage(name string) bool {
+	if t.ImageFormat == "" {
+		return false
+	}
+	switch mime.TypeByExtension(path.Ext(name)) {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Match tells whether the file of name will be transformed
+func (t *UploadTransform) Match(name string) bool {
+	return t.isVideo(name) || t.isImage(name)
+}
+
+// Transform reads the file of name from reader, and returns the transformed file and its new name.
+// The input is staged to a temp file first, as the transformed size can not be known before it's done.
+// The returned file is positioned at the start, caller must close and remove it.
+func (t *UploadTransform) Transform(ctx types.TaskCtx, name string, reader io.Reader) (*os.File, string, error) {
+	input, e := CopyReaderToTempFile(ctx, reader, t.tempDir)
+	if e != nil {
+		return nil, "", e
+	}
+	defer func() {
+		_ = input.Close()
+		_ = os.Remove(input.Name())
+	}()
+	output, e := ioutil.TempFile(t.tempDir, "transcode")
+	if e != nil {
+		return nil, "", e
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			_ = output.Close()
+			_ = os.Remove(output.Name())
+		}
+	}()
+
+	var ext string
+	if t.isVideo(name) {
+		ext = ".mp4"
+		_ = output.Close()
+		e = t.transcodeVideo(ctx, input.Name(), output.Name())
+		if e == nil {
+			var f *os.File
+			if f, e = os.Open(output.Name()); e == nil {
+				output = f
+			}
+		}
+	} else {
+		ext = "." + t.ImageFormat
+		if t.ImageFormat == "jpeg" {
+			ext = ".jpg"
+		}
+		e = t.transcodeImage(input, output)
+	}
+	if e != nil {
+		return nil, "", e
+	}
+	if _, e := output.Seek(0, io.SeekStart); e != nil {
+		return nil, "", e
+	}
+	ok = true
+	return output, strings.TrimSuffix(name, path.Ext(name)) + ext, nil
+}
+
+func (t *UploadTransform) transcodeVideo(ctx types.TaskCtx, input, output string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.ffmpeg, "-y", "-v", "error", "-i", input,
+		"-c:v", "libx264", "-preset", "veryfast", "-c:a", "aac",
+		"-movflags", "+faststart", "-f", "mp4", output)
+	cmd.Stderr = &stderr
+	e := cmd.Run()
+	if ctx.Canceled() {
+		return task.ErrorCanceled
+	}
+	if e != nil {
+		return err.NewBadRequestError(i18n.T("drive.transcode.failed", strings.TrimSpace(stderr.String())))
+	}
+	// verify the result before it's saved
+	stat, e := os.Stat(output)
+	if e != nil {
+		return e
+	}
+	if stat.Size() == 0 {
+		return err.NewBadRequestError(i18n.T("drive.transcode.failed", "empty output"))
+	}
+	return nil
+}
+
+func (t *UploadTransform) transcodeImage(input, output *os.File) error {
+	img, _, e := image.Decode(input)
+	if e != nil {
+		return err.NewBadRequestError(i18n.T("drive.transcode.failed", e.Error()))
+	}
+	if t.ImageMaxSize > 0 {
+		img = resize.Thumbnail(t.ImageMaxSize, t.ImageMaxSize, img, resize.Lanczos3)
+	}
+	if t.ImageFormat == "png" {
+		e = png.Encode(output, img)
+	} else {
+		e = jpeg.Encode(output, img, &jpeg.Options{Quality: 90})
+	}
+	if e != nil {
+		return e
+	}
+	// verify the result before it's saved
+	if _, e := output.Seek(0, io.SeekStart); e != nil {
+		return e
+	}
+	if _, _, e := image.DecodeConfig(output); e != nil {
+		return err.NewBadRequestError(i18n.T("drive.transcode.failed", e.Error()))
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package drive_util
 
 import (
 	"context"
+	"fmt"
 	"go-drive/common/errors"
 	"go-drive/common/i18n"
 	"go-drive/common/task"
@@ -15,6 +16,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 func GetIEntry(entry types.IEntry, test func(iEntry types.IEntry) bool) types.IEntry {
@@ -37,23 +39,37 @@ func GetIEntry(entry types.IEntry, test func(iEntry types.IEntry) bool) types.IE
 	return entry
 }
 
+// Copy copies src to dst, reports the progress to ctx.
+// Cancellation and pausing of ctx are checked between buffer writes.
 func Copy(ctx types.TaskCtx, dst io.Writer, src io.Reader) (written int64, err error) {
-	buf := make([]byte, 32*1024)
+	buf, e := TransferBuffers.Acquire(ctx, DefaultBufferSize)
+	if e != nil {
+		return 0, e
+	}
+	defer TransferBuffers.Release(buf)
 	for {
-		if ctx.Canceled() {
-			return written, task.ErrorCanceled
+		if e := ctx.WaitIfPaused(); e != nil {
+			return written, e
 		}
-		w, ee := io.CopyBuffer(dst, src, buf)
-		if ee != nil {
-			break
+		nr, er := src.Read(buf)
+		if nr > 0 {
+			nw, ew := dst.Write(buf[:nr])
+			written += int64(nw)
+			ctx.Progress(int64(nw), false)
+			if ew != nil {
+				return written, ew
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
 		}
-		if w == 0 {
-			break
+		if er == io.EOF {
+			return written, nil
+		}
+		if er != nil {
+			return written, er
 		}
-		written += w
-		ctx.Progress(w, false)
 	}
-	return
 }
 
 func CopyReaderToTempFile(ctx types.TaskCtx, reader io.Reader, tempDir string) (*os.File, error) {
@@ -92,26 +108,223 @@ func CopyIContentToTempFile(ctx types.TaskCtx, content types.IContent, tempDir s
 	return CopyReaderToTempFile(ctx, reader, tempDir)
 }
 
+// ContentValidators returns the Last-Modified and ETag of content, which are empty if the modification time is unknown
+func ContentValidators(content types.IContent) (string, string) {
+	modTime := content.ModTime()
+	if modTime <= 0 {
+		return "", ""
+	}
+	lastModified := utils.Time(modTime).UTC().Format(http.TimeFormat)
+	etag := `W/"` + strconv.FormatInt(modTime, 16) + "-" + strconv.FormatInt(content.Size(), 16) + `"`
+	return lastModified, etag
+}
+
+// setValidators sets the validators to header if they are not present
+func setValidators(header http.Header, lastModified, etag string) {
+	if lastModified != "" && header.Get("Last-Modified") == "" {
+		header.Set("Last-Modified", lastModified)
+	}
+	if etag != "" && header.Get("ETag") == "" {
+		header.Set("ETag", etag)
+	}
+}
+
+// emulateRange makes the 206 or 416 response of the Range request from the full content,
+// when the upstream ignores the Range. It's done only if the size is known and If-Range matches.
+func emulateRange(resp *http.Response) {
+	if resp.ContentLength < 0 || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+	// the ranges can be served by us
+	resp.Header.Set("Accept-Ranges", "bytes")
+	rangeHeader := resp.Request.Header.Get("Range")
+	if rangeHeader == "" || !ifRangeMatches(resp.Request.Header.Get("If-Range"), resp.Header) {
+		return
+	}
+	size := resp.ContentLength
+	start, length, ok := parseByteRange(rangeHeader, size)
+	if !ok {
+		return
+	}
+	if length < 0 {
+		_ = resp.Body.Close()
+		resp.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		resp.Status = "416 Requested Range Not Satisfiable"
+		resp.Header.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		resp.Header.Set("Content-Length", "0")
+		resp.ContentLength = 0
+		resp.Body = http.NoBody
+		return
+	}
+	if _, e := io.CopyN(ioutil.Discard, resp.Body, start); e != nil {
+		// the remaining body is broken, let the client see the error
+		return
+	}
+	resp.StatusCode = http.StatusPartialContent
+	resp.Status = "206 Partial Content"
+	resp.Header.Set("Content-Range", "bytes "+strconv.FormatInt(start, 10)+"-"+
+		strconv.FormatInt(start+length-1, 10)+"/"+strconv.FormatInt(size, 10))
+	resp.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	resp.ContentLength = length
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, length), resp.Body}
+}
+
+// ifRangeMatches tells whether the If-Range(an entity tag or a date) matches the validators in header,
+// an empty If-Range always matches
+func ifRangeMatches(ifRange string, header http.Header) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return ifRange == header.Get("ETag")
+	}
+	return ifRange == header.Get("Last-Modified")
+}
+
+// parseByteRange parses the Range header of a single byte range against the content of size.
+// ok is false if it's not a valid single byte range, and length is -1 if the range can not be satisfied.
+func parseByteRange(rangeHeader string, size int64) (start, length int64, ok bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(rangeHeader, prefix) || strings.Contains(rangeHeader, ",") {
+		return 0, 0, false
+	}
+	i := strings.IndexByte(rangeHeader, '-')
+	if i < 0 {
+		return 0, 0, false
+	}
+	first, last := strings.TrimSpace(rangeHeader[len(prefix):i]), strings.TrimSpace(rangeHeader[i+1:])
+	if first == "" {
+		// the suffix range, the last n bytes
+		n, e := strconv.ParseInt(last, 10, 64)
+		if e != nil || n < 0 {
+			return 0, 0, false
+		}
+		if n == 0 || size == 0 {
+			return 0, -1, true
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+	start, e := strconv.ParseInt(first, 10, 64)
+	if e != nil || start < 0 {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, e = strconv.ParseInt(last, 10, 64)
+		if e != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, -1, true
+	}
+	return start, end - start + 1, true
+}
+
+// checkNotModified tells whether the conditional GET or HEAD request can be answered with 304.
+// If-None-Match takes precedence over If-Modified-Since.
+func checkNotModified(req *http.Request, lastModified, etag string) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ims := req.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified == "" {
+		return false
+	}
+	since, e := http.ParseTime(ims)
+	if e != nil {
+		return false
+	}
+	modified, _ := http.ParseTime(lastModified)
+	return !modified.After(since)
+}
+
+// DownloadIContent writes the content to the response, or redirects to the content URL.
+// rateLimit is the maximum bytes per second when the content is served by this server, <= 0 means unlimited.
 func DownloadIContent(ctx context.Context, content types.IContent,
-	w http.ResponseWriter, req *http.Request, forceProxy bool) error {
+	w http.ResponseWriter, req *http.Request, forceProxy bool, rateLimit int64) error {
+	lastModified, etag := ContentValidators(content)
 	u, e := content.GetURL(ctx)
 	if e == nil {
-		if u.Proxy || forceProxy || u.Header != nil {
+		if u.Proxy || forceProxy || u.Header != nil || (u.ProxyRange && req.Header.Get("Range") != "") {
+			if checkNotModified(req, lastModified, etag) {
+				setValidators(w.Header(), lastModified, etag)
+				w.WriteHeader(http.StatusNotModified)
+				return nil
+			}
+			w = ThrottledResponseWriter(w, rateLimit)
 			dest, e := url2.Parse(u.URL)
 			if e != nil {
 				return e
 			}
-			proxy := httputil.ReverseProxy{Director: func(r *http.Request) {
-				r.URL = dest
-				r.Host = dest.Host
-				r.Header.Del("Referer")
-				r.Header.Del("Authorization")
-				if u.Header != nil {
-					for k, v := range u.Header {
-						r.Header.Set(k, v)
+			stream := DownloadScheduler.Open(content.Name())
+			defer stream.Close()
+			proxy := httputil.ReverseProxy{
+				Director: func(r *http.Request) {
+					r.URL = dest
+					r.Host = dest.Host
+					r.Header.Del("Referer")
+					r.Header.Del("Authorization")
+					// the ETag was made by us, the upstream can not validate it
+					if etag != "" && r.Header.Get("If-None-Match") != "" {
+						r.Header.Del("If-None-Match")
 					}
-				}
-			}}
+					// so is the If-Range, the range is still valid when it matches
+					if ifRange := r.Header.Get("If-Range"); ifRange != "" &&
+						(ifRange == etag || (lastModified != "" && ifRange == lastModified)) {
+						r.Header.Del("If-Range")
+					}
+					if u.Header != nil {
+						for k, v := range u.Header {
+							r.Header.Set(k, v)
+						}
+					}
+				},
+				ModifyResponse: func(resp *http.Response) error {
+					if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent ||
+						resp.StatusCode == http.StatusNotModified {
+						setValidators(resp.Header, lastModified, etag)
+					}
+					// the upstream's CORS headers are meaningless to the client
+					for k := range resp.Header {
+						if strings.HasPrefix(http.CanonicalHeaderKey(k), "Access-Control-") {
+							resp.Header.Del(k)
+						}
+					}
+					if resp.StatusCode == http.StatusOK && resp.Request.Method == http.MethodGet {
+						emulateRange(resp)
+					}
+					if resp.Header.Get("Accept-Ranges") == "" && resp.StatusCode == http.StatusPartialContent {
+						resp.Header.Set("Accept-Ranges", "bytes")
+					}
+					resp.Body = stream.ReadCloser(ctx, resp.Body)
+					return nil
+				},
+			}
+			if u.Header != nil {
+				proxy.Transport = &redirectTransport{}
+			}
 
 			defer func() {
 				if i := recover(); i != nil && i != http.ErrAbortHandler {
@@ -122,31 +335,44 @@ func DownloadIContent(ctx context.Context, content types.IContent,
 			proxy.ServeHTTP(w, req)
 			return nil
 		} else {
-			w.WriteHeader(http.StatusFound)
 			w.Header().Set("Location", u.URL)
+			w.WriteHeader(http.StatusFound)
 		}
 		return nil
 	}
 	if !err.IsUnsupportedError(e) {
 		return e
 	}
+	// the validators are also checked by http.ServeContent, but the content need not be opened for 304
+	setValidators(w.Header(), lastModified, etag)
+	if checkNotModified(req, lastModified, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
 	reader, e := content.GetReader(ctx)
 	if e != nil {
 		return e
 	}
-	defer func() { _ = reader.Close() }()
 	readSeeker, ok := reader.(io.ReadSeeker)
+	if rr, isRangeReader := content.(types.IRangeReader); !ok && isRangeReader && content.Size() >= 0 {
+		rs := NewRangeReadSeeker(ctx, rr, content.Size(), reader)
+		reader, readSeeker, ok = rs, rs, true
+	}
+	defer func() { _ = reader.Close() }()
+	w = ThrottledResponseWriter(w, rateLimit)
+	stream := DownloadScheduler.Open(content.Name())
+	defer stream.Close()
 	if ok {
 		http.ServeContent(
 			w, req, content.Name(),
 			utils.Time(content.ModTime()),
-			readSeeker)
+			stream.Reader(ctx, readSeeker).(io.ReadSeeker))
 		return nil
 	}
 
 	w.Header().Set("Content-Length", strconv.FormatInt(content.Size(), 10))
 	if req.Method != http.MethodHead {
-		_, e = io.Copy(w, reader)
+		_, e = CopyBuffered(ctx, w, stream.Reader(ctx, reader))
 	}
 	return e
 }
@@ -161,42 +387,69 @@ type EntryNode struct {
 type DoCopy = func(from types.IEntry, driveTo types.IDrive, to string, ctx types.TaskCtx) error
 type CopyCallback = func(entry types.IEntry, allProcessed bool, ctx types.TaskCtx) error
 
-func buildEntriesTree(ctx types.TaskCtx, entry types.IEntry, bytesProgress bool) (EntryNode, error) {
+// buildEntriesTree builds the tree of entry, rel is the path of entry relative to the root.
+// Entries not selected by filter are pruned, and keep is false if entry itself is pruned.
+func buildEntriesTree(ctx types.TaskCtx, entry types.IEntry, rel string,
+	bytesProgress bool, filter *CopyFilter) (r EntryNode, keep bool, e error) {
 	if ctx.Canceled() {
-		return EntryNode{}, task.ErrorCanceled
+		return EntryNode{}, false, task.ErrorCanceled
 	}
-	if bytesProgress {
-		if entry.Type().IsFile() {
-			ctx.Total(entry.Size(), false)
-		}
-	} else {
-		ctx.Total(1, false)
+	r = EntryNode{entry, nil}
+	isRoot := rel == ""
+	if !isRoot && filter.Excluded(rel) {
+		return r, false, nil
 	}
-	r := EntryNode{entry, nil}
 	if entry.Type().IsFile() {
-		return r, nil
+		if !isRoot && (!filter.MatchFile(rel) || !filter.MatchSize(entry)) {
+			return r, false, nil
+		}
+		if bytesProgress {
+			ctx.Total(entry.Size(), false)
+		} else {
+			ctx.Total(1, false)
+		}
+		return r, true, nil
 	}
 	entries, e := entry.Drive().List(ctx, entry.Path())
 	if e != nil {
-		return r, e
+		return r, false, e
 	}
-	children := make([]EntryNode, len(entries))
-	for i, e := range entries {
-		node, ee := buildEntriesTree(ctx, e, bytesProgress)
+	children := make([]EntryNode, 0, len(entries))
+	for _, e := range entries {
+		node, keep, ee := buildEntriesTree(ctx, e, path.Join(rel, utils.PathBase(e.Path())), bytesProgress, filter)
 		if ee != nil {
-			return r, ee
+			return r, false, ee
+		}
+		if keep {
+			children = append(children, node)
 		}
-		children[i] = node
 	}
 	r.children = children
-	return r, nil
+	if !isRoot && len(children) == 0 && filter != nil && filter.PruneEmptyDirs {
+		return r, false, nil
+	}
+	if !bytesProgress {
+		ctx.Total(1, false)
+	}
+	return r, true, nil
 }
 
 func BuildEntriesTree(ctx types.TaskCtx, root types.IEntry, bytesProgress bool) (EntryNode, error) {
+	return BuildFilteredEntriesTree(ctx, root, bytesProgress, nil)
+}
+
+// BuildFilteredEntriesTree builds the tree of root, with the entries not selected by filter pruned.
+// The progress total of ctx only counts the entries in the tree.
+func BuildFilteredEntriesTree(ctx types.TaskCtx, root types.IEntry,
+	bytesProgress bool, filter *CopyFilter) (EntryNode, error) {
 	if ctx == nil {
 		ctx = task.DummyContext()
 	}
-	return buildEntriesTree(ctx, root, bytesProgress)
+	if filter.IsEmpty() {
+		filter = nil
+	}
+	r, _, e := buildEntriesTree(ctx, root, "", bytesProgress, filter)
+	return r, e
 }
 
 func flattenEntriesTree(root EntryNode, result []EntryNode) []EntryNode {
@@ -215,30 +468,28 @@ func FlattenEntriesTree(root EntryNode) []EntryNode {
 }
 
 func copyAll(ctx types.TaskCtx, entry EntryNode, driveTo types.IDrive, to string,
-	override bool, newParent bool, doCopy DoCopy, after CopyCallback) (bool, error) {
-	if ctx.Canceled() {
-		return false, task.ErrorCanceled
+	opts CopyOptions, newParent bool, doCopy DoCopy, after CopyCallback) (bool, error) {
+	if e := ctx.WaitIfPaused(); e != nil {
+		return false, e
 	}
-	var dstType types.EntryType
+	var dst types.IEntry
 	dstExists := false
 	if newParent {
 		dstExists = false
 	} else {
-		dst, e := driveTo.Get(ctx, to)
+		d, e := driveTo.Get(ctx, to)
 		if e != nil && !err.IsNotFoundError(e) {
 			return false, e
 		}
 		dstExists = e == nil
-		if dstExists {
-			dstType = dst.Type()
-		}
+		dst = d
 	}
 
 	allProcessed := true
 	if entry.Type().IsDir() {
 		dirCreate := false
 		if dstExists {
-			if dstType.IsFile() {
+			if dst.Type().IsFile() {
 				return false, err.NewNotAllowedMessageError(
 					i18n.T("drive.copy_type_mismatch1", entry.Path(), to))
 			}
@@ -252,7 +503,7 @@ func copyAll(ctx types.TaskCtx, entry EntryNode, driveTo types.IDrive, to string
 		if entry.children != nil {
 			for _, e := range entry.children {
 				r, ee := copyAll(ctx, e, driveTo, utils.CleanPath(path.Join(to, utils.PathBase(e.Path()))),
-					override, dirCreate, doCopy, after)
+					opts, dirCreate, doCopy, after)
 				if ee != nil {
 					return false, ee
 				}
@@ -265,11 +516,17 @@ func copyAll(ctx types.TaskCtx, entry EntryNode, driveTo types.IDrive, to string
 
 	if entry.Type().IsFile() {
 		if dstExists {
-			if dstType.IsDir() {
+			if dst.Type().IsDir() {
 				return false, err.NewNotAllowedMessageError(
 					i18n.T("drive.copy_type_mismatch2", entry.Path(), to))
 			}
-			if !override {
+			if opts.Update != nil {
+				if opts.Update.unchanged(ctx, entry.IEntry, dst) {
+					opts.Update.Unchanged++
+					ctx.Progress(entry.Size(), false)
+					return false, nil
+				}
+			} else if !opts.Override {
 				// skip
 				return false, nil
 			}
@@ -278,6 +535,13 @@ func copyAll(ctx types.TaskCtx, entry EntryNode, driveTo types.IDrive, to string
 		if e := doCopy(entry.IEntry, driveTo, to, ctx); e != nil {
 			return false, e
 		}
+		if opts.Update != nil {
+			if dstExists {
+				opts.Update.Updated++
+			} else {
+				opts.Update.Copied++
+			}
+		}
 	}
 	if e := after(entry, allProcessed, ctx); e != nil {
 		return false, e
@@ -287,14 +551,30 @@ func copyAll(ctx types.TaskCtx, entry EntryNode, driveTo types.IDrive, to string
 
 func CopyAll(ctx types.TaskCtx, entry types.IEntry, driveTo types.IDrive, to string,
 	override bool, doCopy DoCopy, after CopyCallback) error {
-	tree, e := BuildEntriesTree(ctx, entry, true)
+	return CopyAllWithOptions(ctx, entry, driveTo, to, CopyOptions{Override: override}, doCopy, after)
+}
+
+// CopyOptions are the options of CopyAllWithOptions
+type CopyOptions struct {
+	// Override overrides the existing files, it's ignored if Update is set
+	Override bool
+	// Filter selects the entries to be copied, the directory structure of the copied files is preserved
+	Filter *CopyFilter
+	// Update copies only the files which are missing or changed in the destination
+	Update *CopyUpdate
+}
+
+// CopyAllWithOptions is the same as CopyAll, with the options
+func CopyAllWithOptions(ctx types.TaskCtx, entry types.IEntry, driveTo types.IDrive, to string,
+	opts CopyOptions, doCopy DoCopy, after CopyCallback) error {
+	tree, e := BuildFilteredEntriesTree(ctx, entry, true, opts.Filter)
 	if e != nil {
 		return e
 	}
 	if after == nil {
 		after = func(entry types.IEntry, fullProcessed bool, ctx types.TaskCtx) error { return nil }
 	}
-	_, e = copyAll(ctx, tree, driveTo, to, override, false, doCopy, after)
+	_, e = copyAll(ctx, tree, driveTo, to, opts, false, doCopy, after)
 	return e
 }
 
@@ -335,6 +615,43 @@ func ProgressReader(reader io.Reader, ctx types.TaskCtx) io.Reader {
 	return &progressReader{r: reader, ctx: ctx}
 }
 
+const maxRedirects = 10
+
+// redirectTransport follows the redirects of the upstream with the headers of the request,
+// as the redirected URLs may also require the headers of the ContentURL, like the User-Agent
+type redirectTransport struct{}
+
+func (t *redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	for i := 0; ; i++ {
+		resp, e := http.DefaultTransport.RoundTrip(r)
+		if e != nil {
+			return nil, e
+		}
+		location := resp.Header.Get("Location")
+		if location == "" || i >= maxRedirects || !isRedirect(resp.StatusCode) ||
+			(r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			return resp, nil
+		}
+		dest, e := r.URL.Parse(location)
+		if e != nil {
+			return resp, nil
+		}
+		_ = resp.Body.Close()
+		next := r.Clone(r.Context())
+		next.URL = dest
+		next.Host = dest.Host
+		if dest.Host != r.URL.Host {
+			next.Header.Del("Authorization")
+		}
+		r = next
+	}
+}
+
+func isRedirect(status int) bool {
+	return status == http.StatusMovedPermanently || status == http.StatusFound || status == http.StatusSeeOther ||
+		status == http.StatusTemporaryRedirect || status == http.StatusPermanentRedirect
+}
+
 func GetURL(ctx context.Context, u string, header types.SM) (io.ReadCloser, error) {
 	req, e := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if e != nil {
@@ -357,6 +674,129 @@ func GetURL(ctx context.Context, u string, header types.SM) (io.ReadCloser, erro
 	return resp.Body, nil
 }
 
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// GetIContentRangeReader returns the reader of length bytes of the content from offset, length < 0 means to the end.
+// IRangeReader is used if implemented, then Range request to the content URL,
+// otherwise the content is read from the beginning and the bytes before offset are discarded.
+func GetIContentRangeReader(ctx context.Context, content types.IContent,
+	offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	if rr, ok := content.(types.IRangeReader); ok {
+		return rr.GetRangeReader(ctx, offset, length)
+	}
+	var reader io.ReadCloser
+	u, e := content.GetURL(ctx)
+	if e == nil {
+		reader, e = getURLRange(ctx, u.URL, u.Header, offset, length)
+	} else {
+		reader, e = content.GetReader(ctx)
+		if e == nil && offset > 0 {
+			if _, e = io.CopyN(ioutil.Discard, reader, offset); e == io.EOF {
+				e = nil
+			}
+			if e != nil {
+				_ = reader.Close()
+			}
+		}
+	}
+	if e != nil {
+		return nil, e
+	}
+	if length >= 0 {
+		return &readCloser{Reader: io.LimitReader(reader, length), Closer: reader}, nil
+	}
+	return reader, nil
+}
+
+// getURLRange requests the range of the URL,
+// the bytes before offset are discarded if the server does not support Range requests.
+func getURLRange(ctx context.Context, u string, header types.SM,
+	offset, length int64) (io.ReadCloser, error) {
+	if offset == 0 && length < 0 {
+		return GetURL(ctx, u, header)
+	}
+	req, e := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if e != nil {
+		return nil, e
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if length >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, e := http.DefaultClient.Do(req)
+	if e != nil {
+		return nil, e
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		if _, e := io.CopyN(ioutil.Discard, resp.Body, offset); e != nil && e != io.EOF {
+			_ = resp.Body.Close()
+			return nil, e
+		}
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		_ = resp.Body.Close()
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	_ = resp.Body.Close()
+	return nil, err.NewRemoteApiError(resp.StatusCode,
+		i18n.T("util.request_failed", strconv.Itoa(resp.StatusCode)))
+}
+
+// URLMeta is the metadata of a remote content
+type URLMeta struct {
+	Size    int64
+	ModTime int64
+}
+
+// HeadURL gets the metadata of the remote content by a HEAD request, the content will not be read.
+// It's for the drives listing the URLs without the exact sizes, which must not download the files to stat them.
+// Size is -1 if the server does not send Content-Length, so is ModTime if Last-Modified is absent.
+func HeadURL(ctx context.Context, u string, header types.SM) (URLMeta, error) {
+	req, e := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if e != nil {
+		return URLMeta{}, e
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, e := http.DefaultClient.Do(req)
+	if e != nil {
+		return URLMeta{}, e
+	}
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return URLMeta{}, err.NewNotFoundError()
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return URLMeta{}, err.NewNotAllowedMessageError(
+			i18n.T("util.request_failed", strconv.Itoa(resp.StatusCode)))
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return URLMeta{}, err.NewRemoteApiError(resp.StatusCode,
+			i18n.T("util.request_failed", strconv.Itoa(resp.StatusCode)))
+	}
+	meta := URLMeta{Size: resp.ContentLength, ModTime: -1}
+	if meta.Size < 0 {
+		meta.Size = -1
+	}
+	if lm, e := http.ParseTime(resp.Header.Get("Last-Modified")); e == nil {
+		meta.ModTime = utils.Millisecond(lm)
+	}
+	return meta, nil
+}
+
 func RequireFileNotExists(ctx context.Context, d types.IDrive, p string) (types.IEntry, error) {
 	get, e := d.Get(ctx, p)
 	if e == nil {
@@ -0,0 +1,239 @@
+package drive_util
+
+import (
+	"context"
+	"go-drive/common/errors"
+	"go-drive/common/types"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckNotModified(t *testing.T) {
+	lastModified := "Mon, 02 Jan 2006 15:04:05 GMT"
+	etag := `W/"1-2"`
+	cases := []struct {
+		method, inm, ims string
+		want             bool
+	}{
+		{http.MethodGet, "", "", false},
+		{http.MethodGet, `W/"1-2"`, "", true},
+		{http.MethodGet, `"1-2"`, "", true},
+		{http.MethodGet, `"x", W/"1-2"`, "", true},
+		{http.MethodGet, "*", "", true},
+		{http.MethodGet, `"x"`, lastModified, false},
+		{http.MethodGet, "", lastModified, true},
+		{http.MethodHead, "", "Tue, 03 Jan 2006 15:04:05 GMT", true},
+		{http.MethodGet, "", "Sun, 01 Jan 2006 15:04:05 GMT", false},
+		{http.MethodGet, "", "invalid", false},
+		{http.MethodPost, `W/"1-2"`, "", false},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(c.method, "/", nil)
+		if c.inm != "" {
+			req.Header.Set("If-None-Match", c.inm)
+		}
+		if c.ims != "" {
+			req.Header.Set("If-Modified-Since", c.ims)
+		}
+		if got := checkNotModified(req, lastModified, etag); got != c.want {
+			t.Errorf("%s If-None-Match: %s, If-Modified-Since: %s, got %v, want %v", c.method, c.inm, c.ims, got, c.want)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	if checkNotModified(req, "", "") {
+		t.Error("expect no validators when the modification time is unknown")
+	}
+}
+
+func TestRedirectTransport(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "pan.baidu.com" || r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+"/file", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, origin.URL, nil)
+	req.Header.Set("User-Agent", "pan.baidu.com")
+	req.Header.Set("Authorization", "secret")
+	resp, e := (&redirectTransport{}).RoundTrip(req)
+	if e != nil {
+		t.Fatal(e)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	cases := []struct {
+		header        string
+		start, length int64
+		ok            bool
+	}{
+		{"bytes=0-9", 0, 10, true},
+		{"bytes=10-", 10, 90, true},
+		{"bytes=90-200", 90, 10, true},
+		{"bytes=-10", 90, 10, true},
+		{"bytes=-200", 0, 100, true},
+		{"bytes=100-", 0, -1, true},
+		{"bytes=-0", 0, -1, true},
+		{"bytes=9-0", 0, 0, false},
+		{"bytes=0-1,5-9", 0, 0, false},
+		{"items=0-9", 0, 0, false},
+		{"bytes=x-9", 0, 0, false},
+	}
+	for _, c := range cases {
+		start, length, ok := parseByteRange(c.header, 100)
+		if ok != c.ok || (ok && (start != c.start || length != c.length)) {
+			t.Errorf("%s: got %d, %d, %v", c.header, start, length, ok)
+		}
+	}
+}
+
+func TestDownloadIContentRange(t *testing.T) {
+	const body = "0123456789"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ignores the Range
+		w.Header().Set("Content-Length", "10")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+	content := &urlTestContent{url: upstream.URL}
+	lastModified, etag := ContentValidators(content)
+
+	cases := []struct {
+		rangeHeader, ifRange string
+		status               int
+		body, contentRange   string
+	}{
+		{"", "", http.StatusOK, body, ""},
+		{"bytes=2-4", "", http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{"bytes=-3", etag, http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=2-4", lastModified, http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{"bytes=2-4", `"other"`, http.StatusOK, body, ""},
+		{"bytes=20-", "", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.rangeHeader != "" {
+			req.Header.Set("Range", c.rangeHeader)
+		}
+		if c.ifRange != "" {
+			req.Header.Set("If-Range", c.ifRange)
+		}
+		w := httptest.NewRecorder()
+		if e := DownloadIContent(context.Background(), content, w, req, true, 0); e != nil {
+			t.Fatal(e)
+		}
+		if w.Code != c.status || w.Body.String() != c.body || w.Header().Get("Content-Range") != c.contentRange {
+			t.Errorf("Range: %s, If-Range: %s, got %d %s %s", c.rangeHeader, c.ifRange,
+				w.Code, w.Header().Get("Content-Range"), w.Body.String())
+		}
+		if w.Header().Get("Accept-Ranges") != "bytes" {
+			t.Errorf("expect Accept-Ranges for %s", c.rangeHeader)
+		}
+	}
+}
+
+type urlTestContent struct {
+	types.IContent
+	url string
+}
+
+func (c *urlTestContent) Name() string { return "test.txt" }
+
+func (c *urlTestContent) Size() int64 { return 10 }
+
+func (c *urlTestContent) ModTime() int64 { return 1600000000000 }
+
+func (c *urlTestContent) GetURL(context.Context) (*types.ContentURL, error) {
+	return &types.ContentURL{URL: c.url}, nil
+}
+
+type readerTestContent struct {
+	urlTestContent
+	opened bool
+}
+
+func (c *readerTestContent) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (c *readerTestContent) GetReader(context.Context) (io.ReadCloser, error) {
+	c.opened = true
+	// not seekable
+	return ioutil.NopCloser(strings.NewReader("0123456789")), nil
+}
+
+func TestDownloadIContentNotModified(t *testing.T) {
+	content := &readerTestContent{}
+	lastModified, etag := ContentValidators(content)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	if e := DownloadIContent(context.Background(), content, w, req, false, 0); e != nil {
+		t.Fatal(e)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" ||
+		w.Header().Get("ETag") != etag || w.Header().Get("Last-Modified") != lastModified {
+		t.Errorf("unexpected response: %d %v", w.Code, w.Header())
+	}
+
+	for _, h := range [][2]string{{"If-None-Match", etag}, {"If-Modified-Since", lastModified}} {
+		content.opened = false
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(h[0], h[1])
+		w := httptest.NewRecorder()
+		if e := DownloadIContent(context.Background(), content, w, req, false, 0); e != nil {
+			t.Fatal(e)
+		}
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 || content.opened {
+			t.Errorf("%s: expect 304 without opening the content, got %d", h[0], w.Code)
+		}
+	}
+}
+
+func TestHeadURL(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("unexpected %s request", r.Method)
+		}
+		switch r.URL.Path {
+		case "/big":
+			w.Header().Set("Content-Length", "1099511627776")
+			w.Header().Set("Last-Modified", "Sun, 13 Sep 2020 12:26:40 GMT")
+		case "/private":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	meta, e := HeadURL(context.Background(), s.URL+"/big", nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if meta.Size != 1<<40 || meta.ModTime != 1600000000000 {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+	if _, e := HeadURL(context.Background(), s.URL+"/private", nil); !err.IsNotAllowedError(e) {
+		t.Errorf("expected not allowed, got %v", e)
+	}
+	if _, e := HeadURL(context.Background(), s.URL+"/missing", nil); !err.IsNotFoundError(e) {
+		t.Errorf("expected not found, got %v", e)
+	}
+}
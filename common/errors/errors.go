@@ -122,6 +122,50 @@ func (t TimeoutError) Error() string {
 	return t.msg
 }
 
+// QuotaExceededError 507
+type QuotaExceededError struct {
+	msg string
+}
+
+func (q QuotaExceededError) Code() int {
+	return http.StatusInsufficientStorage
+}
+
+func (q QuotaExceededError) Error() string {
+	return q.msg
+}
+
+// ChecksumMismatchError 422, Data tells what to upload again
+type ChecksumMismatchError struct {
+	msg  string
+	data types.M
+}
+
+func (c ChecksumMismatchError) Code() int {
+	return http.StatusUnprocessableEntity
+}
+
+func (c ChecksumMismatchError) Error() string {
+	return c.msg
+}
+
+func (c ChecksumMismatchError) Data() types.M {
+	return c.data
+}
+
+// ConflictError 409, the resource is being modified by another request
+type ConflictError struct {
+	msg string
+}
+
+func (c ConflictError) Code() int {
+	return http.StatusConflict
+}
+
+func (c ConflictError) Error() string {
+	return c.msg
+}
+
 func IsUnsupportedError(e error) bool {
 	_, ok := e.(UnsupportedError)
 	return ok
@@ -132,6 +176,11 @@ func IsNotFoundError(e error) bool {
 	return ok
 }
 
+func IsQuotaExceededError(e error) bool {
+	_, ok := e.(QuotaExceededError)
+	return ok
+}
+
 func IsNotAllowedError(e error) bool {
 	_, ok := e.(NotAllowedError)
 	return ok
@@ -173,6 +222,14 @@ func NewUnsupportedMessageError(msg string) UnsupportedError {
 	return UnsupportedError{msg}
 }
 
+func NewChecksumMismatchError(msg string, data types.M) ChecksumMismatchError {
+	return ChecksumMismatchError{msg, data}
+}
+
+func NewConflictError(msg string) ConflictError {
+	return ConflictError{msg}
+}
+
 func NewRemoteApiError(code int, msg string) RemoteApiError {
 	return RemoteApiError{code, msg}
 }
@@ -180,3 +237,7 @@ func NewRemoteApiError(code int, msg string) RemoteApiError {
 func NewTimeoutError(msg string) TimeoutError {
 	return TimeoutError{msg}
 }
+
+func NewQuotaExceededError(msg string) QuotaExceededError {
+	return QuotaExceededError{msg}
+}
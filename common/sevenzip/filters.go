@@ -0,0 +1,131 @@
+package sevenzip
+
+import (
+	"io"
+)
+
+// bcjReader reverts the x86 BCJ filter, which converts the relative addresses of CALL and JMP to absolute
+type bcjReader struct {
+	r   io.Reader
+	buf []byte
+	// converted is the number of the converted bytes at the start of buf
+	converted int
+	ip        uint32
+	state     uint32
+	err       error
+}
+
+func newBCJReader(r io.Reader) io.Reader {
+	return &bcjReader{r: r, buf: make([]byte, 0, 64*1024)}
+}
+
+func (b *bcjReader) Read(p []byte) (int, error) {
+	for b.converted == 0 {
+		if b.err != nil {
+			if len(b.buf) == 0 {
+				return 0, b.err
+			}
+			// the tail shorter than an instruction is not converted
+			b.converted = len(b.buf)
+			break
+		}
+		n, e := b.r.Read(b.buf[len(b.buf):cap(b.buf)])
+		b.buf = b.buf[:len(b.buf)+n]
+		b.err = e
+		c := x86Convert(b.buf, b.ip, &b.state)
+		b.ip += uint32(c)
+		b.converted = c
+	}
+	n := copy(p, b.buf[:b.converted])
+	b.converted -= n
+	b.buf = b.buf[:copy(b.buf, b.buf[n:])]
+	return n, nil
+}
+
+func test86MSByte(b byte) bool {
+	return (b+1)&0xFE == 0
+}
+
+// x86Convert decodes the data in place, returns the number of the bytes processed
+func x86Convert(data []byte, ip uint32, state *uint32) int {
+	size := len(data)
+	if size < 5 {
+		return 0
+	}
+	mask := *state & 7
+	size -= 4
+	ip += 5
+	pos := 0
+	for {
+		p := pos
+		for p < size && data[p]&0xFE != 0xE8 {
+			p++
+		}
+		d := p - pos
+		pos = p
+		if p >= size {
+			if d > 2 {
+				*state = 0
+			} else {
+				*state = mask >> uint(d)
+			}
+			return pos
+		}
+		if d > 2 {
+			mask = 0
+		} else {
+			mask >>= uint(d)
+			if mask != 0 && (mask > 4 || mask == 3 || test86MSByte(data[p+int(mask>>1)+1])) {
+				mask = (mask >> 1) | 4
+				pos++
+				continue
+			}
+		}
+		if test86MSByte(data[p+4]) {
+			v := uint32(data[p+4])<<24 | uint32(data[p+3])<<16 | uint32(data[p+2])<<8 | uint32(data[p+1])
+			cur := ip + uint32(pos)
+			pos += 5
+			v -= cur
+			if mask != 0 {
+				sh := (mask & 6) << 2
+				if test86MSByte(byte(v >> sh)) {
+					v ^= (uint32(0x100) << sh) - 1
+					v -= cur
+				}
+				mask = 0
+			}
+			data[p+1] = byte(v)
+			data[p+2] = byte(v >> 8)
+			data[p+3] = byte(v >> 16)
+			data[p+4] = byte(0 - ((v >> 24) & 1))
+		} else {
+			mask = (mask >> 1) | 4
+			pos++
+		}
+	}
+}
+
+// deltaReader reverts the delta filter, which stores the differences of the bytes of the distance
+type deltaReader struct {
+	r       io.Reader
+	dist    int
+	history [256]byte
+	pos     byte
+}
+
+func newDeltaReader(r io.Reader, props []byte) (io.Reader, error) {
+	if len(props) < 1 {
+		return nil, ErrCorrupt
+	}
+	return &deltaReader{r: r, dist: int(props[0]) + 1}, nil
+}
+
+func (d *deltaReader) Read(p []byte) (int, error) {
+	n, e := d.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] += d.history[byte(int(d.pos)-d.dist)]
+		d.history[d.pos] = p[i]
+		d.pos++
+	}
+	return n, e
+}
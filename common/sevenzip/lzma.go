@@ -0,0 +1,535 @@
+package sevenzip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	numStates          = 12
+	posBitsMax         = 4
+	numLenToPosStates  = 4
+	numAlignBits       = 4
+	startPosModelIndex = 4
+	endPosModelIndex   = 14
+	numFullDistances   = 1 << (endPosModelIndex >> 1)
+	matchMinLen        = 2
+	matchMaxLen        = 273
+
+	probBits  = 11
+	probInit  = 1 << (probBits - 1)
+	moveBits  = 5
+	rangeTop  = 1 << 24
+	minWindow = 1 << 12
+)
+
+type prob uint16
+
+func initProbs(probs []prob) {
+	for i := range probs {
+		probs[i] = probInit
+	}
+}
+
+// rangeDecoder is the binary arithmetic decoder of LZMA
+type rangeDecoder struct {
+	r    io.ByteReader
+	rng  uint32
+	code uint32
+	err  error
+	// extra is true if the input is ended before the last normalization
+	extra bool
+}
+
+func (d *rangeDecoder) init(r io.ByteReader) error {
+	d.r = r
+	d.rng = 0xFFFFFFFF
+	d.code = 0
+	d.err = nil
+	d.extra = false
+	b, e := r.ReadByte()
+	if e != nil {
+		return e
+	}
+	if b != 0 {
+		return ErrCorrupt
+	}
+	for i := 0; i < 4; i++ {
+		b, e := r.ReadByte()
+		if e != nil {
+			return e
+		}
+		d.code = d.code<<8 | uint32(b)
+	}
+	if d.code == d.rng {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+func (d *rangeDecoder) normalize() {
+	if d.rng < rangeTop {
+		b, e := d.r.ReadByte()
+		if e == io.EOF && !d.extra {
+			// the lazy decoders may not read the last byte, so the encoders may not write it
+			d.extra = true
+		} else if e != nil && d.err == nil {
+			if e == io.EOF {
+				e = io.ErrUnexpectedEOF
+			}
+			d.err = e
+		}
+		d.rng <<= 8
+		d.code = d.code<<8 | uint32(b)
+	}
+}
+
+func (d *rangeDecoder) bit(p *prob) uint32 {
+	bound := (d.rng >> probBits) * uint32(*p)
+	var b uint32
+	if d.code < bound {
+		d.rng = bound
+		*p += ((1 << probBits) - *p) >> moveBits
+	} else {
+		d.rng -= bound
+		d.code -= bound
+		*p -= *p >> moveBits
+		b = 1
+	}
+	d.normalize()
+	return b
+}
+
+func (d *rangeDecoder) directBits(n uint) uint32 {
+	res := uint32(0)
+	for ; n > 0; n-- {
+		d.rng >>= 1
+		d.code -= d.rng
+		t := 0 - (d.code >> 31)
+		d.code += d.rng & t
+		if d.code == d.rng {
+			d.err = ErrCorrupt
+		}
+		d.normalize()
+		res = res<<1 + t + 1
+	}
+	return res
+}
+
+func (d *rangeDecoder) bitTree(probs []prob, numBits uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < numBits; i++ {
+		m = m<<1 + d.bit(&probs[m])
+	}
+	return m - (1 << numBits)
+}
+
+func (d *rangeDecoder) reverseBitTree(probs []prob, numBits uint) uint32 {
+	m, sym := uint32(1), uint32(0)
+	for i := uint(0); i < numBits; i++ {
+		b := d.bit(&probs[m])
+		m = m<<1 + b
+		sym |= b << i
+	}
+	return sym
+}
+
+// window is the sliding dictionary, the decoded bytes not yet read are pending at the end of it
+type window struct {
+	buf     []byte
+	pos     int
+	full    bool
+	pending int
+	// total is the number of the bytes since the dictionary is reset
+	total int64
+}
+
+func newWindow(dictSize uint32) *window {
+	size := int(dictSize)
+	if size < minWindow {
+		size = minWindow
+	}
+	return &window{buf: make([]byte, size)}
+}
+
+func (w *window) reset() {
+	w.pos = 0
+	w.full = false
+	w.total = 0
+}
+
+func (w *window) available() int {
+	if w.full {
+		return len(w.buf)
+	}
+	return w.pos
+}
+
+// free returns the number of the bytes can be written without overwriting the pending ones
+func (w *window) free() int {
+	return len(w.buf) - w.pending
+}
+
+func (w *window) put(b byte) {
+	w.buf[w.pos] = b
+	w.pos++
+	if w.pos == len(w.buf) {
+		w.pos = 0
+		w.full = true
+	}
+	w.pending++
+	w.total++
+}
+
+// get returns the byte of the distance, the last byte is of distance 0
+func (w *window) get(dist int) byte {
+	i := w.pos - dist - 1
+	if i < 0 {
+		i += len(w.buf)
+	}
+	return w.buf[i]
+}
+
+func (w *window) copyMatch(dist, n int) {
+	for ; n > 0; n-- {
+		w.put(w.get(dist))
+	}
+}
+
+// read reads the pending bytes
+func (w *window) read(p []byte) int {
+	if w.pending == 0 {
+		return 0
+	}
+	start := w.pos - w.pending
+	if start < 0 {
+		start += len(w.buf)
+	}
+	n := 0
+	for n < len(p) && w.pending > 0 {
+		end := len(w.buf)
+		if start < w.pos {
+			end = w.pos
+		}
+		c := copy(p[n:], w.buf[start:end])
+		n += c
+		w.pending -= c
+		start += c
+		if start == len(w.buf) {
+			start = 0
+		}
+	}
+	return n
+}
+
+// lzmaProps is the literal context bits, the literal position bits and the position bits
+type lzmaProps struct {
+	lc, lp, pb uint
+}
+
+func decodeProps(b byte) (lzmaProps, error) {
+	if b >= 9*5*5 {
+		return lzmaProps{}, ErrCorrupt
+	}
+	d := uint(b)
+	p := lzmaProps{lc: d % 9}
+	d /= 9
+	p.lp = d % 5
+	p.pb = d / 5
+	return p, nil
+}
+
+type lenDecoder struct {
+	choice  prob
+	choice2 prob
+	low     [1 << posBitsMax][1 << 3]prob
+	mid     [1 << posBitsMax][1 << 3]prob
+	high    [1 << 8]prob
+}
+
+func (l *lenDecoder) init() {
+	l.choice = probInit
+	l.choice2 = probInit
+	for i := range l.low {
+		initProbs(l.low[i][:])
+		initProbs(l.mid[i][:])
+	}
+	initProbs(l.high[:])
+}
+
+func (l *lenDecoder) decode(rc *rangeDecoder, posState uint32) uint32 {
+	if rc.bit(&l.choice) == 0 {
+		return rc.bitTree(l.low[posState][:], 3)
+	}
+	if rc.bit(&l.choice2) == 0 {
+		return 8 + rc.bitTree(l.mid[posState][:], 3)
+	}
+	return 16 + rc.bitTree(l.high[:], 8)
+}
+
+// lzmaState is the state of the decoder which is kept between the chunks of LZMA2
+type lzmaState struct {
+	props lzmaProps
+
+	literal    []prob
+	isMatch    [numStates << posBitsMax]prob
+	isRep      [numStates]prob
+	isRepG0    [numStates]prob
+	isRepG1    [numStates]prob
+	isRepG2    [numStates]prob
+	isRep0Long [numStates << posBitsMax]prob
+	posSlot    [numLenToPosStates][1 << 6]prob
+	posSpecial [1 + numFullDistances - endPosModelIndex]prob
+	align      [1 << numAlignBits]prob
+	lenDec     lenDecoder
+	repLenDec  lenDecoder
+
+	state uint32
+	reps  [4]uint32
+}
+
+func (s *lzmaState) reset(props lzmaProps) {
+	s.props = props
+	n := 0x300 << (props.lc + props.lp)
+	if cap(s.literal) >= n {
+		s.literal = s.literal[:n]
+	} else {
+		s.literal = make([]prob, n)
+	}
+	initProbs(s.literal)
+	initProbs(s.isMatch[:])
+	initProbs(s.isRep[:])
+	initProbs(s.isRepG0[:])
+	initProbs(s.isRepG1[:])
+	initProbs(s.isRepG2[:])
+	initProbs(s.isRep0Long[:])
+	for i := range s.posSlot {
+		initProbs(s.posSlot[i][:])
+	}
+	initProbs(s.posSpecial[:])
+	initProbs(s.align[:])
+	s.lenDec.init()
+	s.repLenDec.init()
+	s.state = 0
+	s.reps = [4]uint32{}
+}
+
+func (s *lzmaState) decodeLiteral(rc *rangeDecoder, w *window) {
+	prev := uint32(0)
+	if w.available() > 0 {
+		prev = uint32(w.get(0))
+	}
+	lpMask := uint32(1)<<s.props.lp - 1
+	base := 0x300 * ((uint32(w.total)&lpMask)<<s.props.lc + prev>>(8-s.props.lc))
+	probs := s.literal[base : base+0x300]
+	sym := uint32(1)
+	if s.state >= 7 {
+		matchByte := uint32(w.get(int(s.reps[0])))
+		for sym < 0x100 {
+			matchBit := (matchByte >> 7) & 1
+			matchByte <<= 1
+			b := rc.bit(&probs[((1+matchBit)<<8)+sym])
+			sym = sym<<1 | b
+			if matchBit != b {
+				break
+			}
+		}
+	}
+	for sym < 0x100 {
+		sym = sym<<1 | rc.bit(&probs[sym])
+	}
+	w.put(byte(sym))
+}
+
+func (s *lzmaState) decodeDistance(rc *rangeDecoder, l uint32) uint32 {
+	lenState := l
+	if lenState > numLenToPosStates-1 {
+		lenState = numLenToPosStates - 1
+	}
+	slot := rc.bitTree(s.posSlot[lenState][:], 6)
+	if slot < startPosModelIndex {
+		return slot
+	}
+	numDirectBits := uint(slot>>1) - 1
+	dist := (2 | slot&1) << numDirectBits
+	if slot < endPosModelIndex {
+		return dist + rc.reverseBitTree(s.posSpecial[dist-slot:], numDirectBits)
+	}
+	dist += rc.directBits(numDirectBits-numAlignBits) << numAlignBits
+	return dist + rc.reverseBitTree(s.align[:], numAlignBits)
+}
+
+// errEndMarker is returned by step when the end marker is decoded
+var errEndMarker = io.EOF
+
+// step decodes a literal or a match, the length of the match is limited to limit
+func (s *lzmaState) step(rc *rangeDecoder, w *window, limit int) error {
+	posState := uint32(w.total) & (uint32(1)<<s.props.pb - 1)
+	st := s.state
+	if rc.bit(&s.isMatch[st<<posBitsMax+posState]) == 0 {
+		s.decodeLiteral(rc, w)
+		switch {
+		case st < 4:
+			s.state = 0
+		case st < 10:
+			s.state = st - 3
+		default:
+			s.state = st - 6
+		}
+		return rc.err
+	}
+	var l uint32
+	if rc.bit(&s.isRep[st]) != 0 {
+		if w.available() == 0 {
+			return ErrCorrupt
+		}
+		if rc.bit(&s.isRepG0[st]) == 0 {
+			if rc.bit(&s.isRep0Long[st<<posBitsMax+posState]) == 0 {
+				if st < 7 {
+					s.state = 9
+				} else {
+					s.state = 11
+				}
+				w.put(w.get(int(s.reps[0])))
+				return rc.err
+			}
+		} else {
+			var dist uint32
+			if rc.bit(&s.isRepG1[st]) == 0 {
+				dist = s.reps[1]
+			} else {
+				if rc.bit(&s.isRepG2[st]) == 0 {
+					dist = s.reps[2]
+				} else {
+					dist = s.reps[3]
+					s.reps[3] = s.reps[2]
+				}
+				s.reps[2] = s.reps[1]
+			}
+			s.reps[1] = s.reps[0]
+			s.reps[0] = dist
+		}
+		l = s.repLenDec.decode(rc, posState)
+		if st < 7 {
+			s.state = 8
+		} else {
+			s.state = 11
+		}
+	} else {
+		s.reps[3], s.reps[2], s.reps[1] = s.reps[2], s.reps[1], s.reps[0]
+		l = s.lenDec.decode(rc, posState)
+		if st < 7 {
+			s.state = 7
+		} else {
+			s.state = 10
+		}
+		s.reps[0] = s.decodeDistance(rc, l)
+		if s.reps[0] == 0xFFFFFFFF {
+			if rc.err != nil {
+				return rc.err
+			}
+			return errEndMarker
+		}
+	}
+	if rc.err != nil {
+		return rc.err
+	}
+	n := int(l) + matchMinLen
+	if int(s.reps[0]) >= w.available() {
+		return ErrCorrupt
+	}
+	if n > limit {
+		return ErrCorrupt
+	}
+	w.copyMatch(int(s.reps[0]), n)
+	return nil
+}
+
+// lzmaReader decodes the LZMA stream of 7z, which has no header.
+// The stream ends at the unpack size, or the end marker if the size is unknown.
+type lzmaReader struct {
+	rc    rangeDecoder
+	state lzmaState
+	w     *window
+	// remaining is the number of the bytes to decode, -1 if it's unknown
+	remaining int64
+	err       error
+}
+
+// newLZMAReader creates the reader by the 5 bytes of the coder properties
+func newLZMAReader(r io.Reader, props []byte, size int64) (io.Reader, error) {
+	if len(props) < 5 {
+		return nil, ErrCorrupt
+	}
+	p, e := decodeProps(props[0])
+	if e != nil {
+		return nil, e
+	}
+	dictSize := binary.LittleEndian.Uint32(props[1:5])
+	if size >= 0 && int64(dictSize) > size {
+		// the window is not larger than the content
+		dictSize = uint32(size)
+	}
+	lr := &lzmaReader{w: newWindow(dictSize), remaining: size}
+	lr.state.reset(p)
+	if e := lr.rc.init(byteReader(r)); e != nil {
+		return nil, e
+	}
+	return lr, nil
+}
+
+func byteReader(r io.Reader) io.ByteReader {
+	if br, ok := r.(io.ByteReader); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
+
+// limitedByteReader reads at most n bytes
+type limitedByteReader struct {
+	r io.ByteReader
+	n int
+}
+
+func (l *limitedByteReader) ReadByte() (byte, error) {
+	if l.n <= 0 {
+		return 0, io.EOF
+	}
+	l.n--
+	return l.r.ReadByte()
+}
+
+func (l *lzmaReader) Read(p []byte) (int, error) {
+	for l.w.pending == 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+		if l.remaining == 0 {
+			return 0, io.EOF
+		}
+		for l.w.pending < len(p) && l.w.free() >= matchMaxLen && l.remaining != 0 {
+			limit := matchMaxLen
+			if l.remaining >= 0 && l.remaining < int64(limit) {
+				limit = int(l.remaining)
+			}
+			before := l.w.pending
+			e := l.state.step(&l.rc, l.w, limit)
+			if l.remaining > 0 {
+				l.remaining -= int64(l.w.pending - before)
+			}
+			if e == errEndMarker {
+				if l.remaining > 0 {
+					e = io.ErrUnexpectedEOF
+				} else {
+					e = io.EOF
+				}
+			}
+			if e != nil {
+				l.err = e
+				break
+			}
+		}
+	}
+	return l.w.read(p), nil
+}
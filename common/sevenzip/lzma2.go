@@ -0,0 +1,185 @@
+package sevenzip
+
+import (
+	"io"
+)
+
+// lzma2Reader decodes the LZMA2 stream, which is a sequence of LZMA and uncompressed chunks
+type lzma2Reader struct {
+	r     io.ByteReader
+	chunk limitedByteReader
+	rc    rangeDecoder
+	state lzmaState
+	w     *window
+
+	// chunkRemaining is the unpacked size remaining of the current chunk
+	chunkRemaining int
+	uncompressed   bool
+	needProps      bool
+	needDictReset  bool
+	err            error
+}
+
+// lzma2DictSize decodes the dictionary size of the property byte
+func lzma2DictSize(b byte) (uint32, error) {
+	if b > 40 {
+		return 0, ErrCorrupt
+	}
+	if b == 40 {
+		return 0xFFFFFFFF, nil
+	}
+	return (2 | uint32(b)&1) << (b/2 + 11), nil
+}
+
+func newLZMA2Reader(r io.Reader, props []byte, size int64) (io.Reader, error) {
+	if len(props) < 1 {
+		return nil, ErrCorrupt
+	}
+	dictSize, e := lzma2DictSize(props[0])
+	if e != nil {
+		return nil, e
+	}
+	if size >= 0 && int64(dictSize) > size {
+		dictSize = uint32(size)
+	}
+	return &lzma2Reader{r: byteReader(r), w: newWindow(dictSize), needProps: true, needDictReset: true}, nil
+}
+
+func (l *lzma2Reader) readByte() (byte, error) {
+	b, e := l.r.ReadByte()
+	if e == io.EOF {
+		e = io.ErrUnexpectedEOF
+	}
+	return b, e
+}
+
+func (l *lzma2Reader) readUint16() (int, error) {
+	b1, e := l.readByte()
+	if e != nil {
+		return 0, e
+	}
+	b2, e := l.readByte()
+	if e != nil {
+		return 0, e
+	}
+	return int(b1)<<8 | int(b2), nil
+}
+
+// nextChunk reads the header of the next chunk, returns io.EOF at the end of the stream
+func (l *lzma2Reader) nextChunk() error {
+	control, e := l.readByte()
+	if e != nil {
+		return e
+	}
+	if control == 0 {
+		return io.EOF
+	}
+	if control == 1 || control == 2 {
+		if control == 1 {
+			l.w.reset()
+			l.needDictReset = false
+		} else if l.needDictReset {
+			return ErrCorrupt
+		}
+		size, e := l.readUint16()
+		if e != nil {
+			return e
+		}
+		l.chunkRemaining = size + 1
+		l.uncompressed = true
+		return nil
+	}
+	if control < 0x80 {
+		return ErrCorrupt
+	}
+	reset := (control >> 5) & 3
+	if reset == 3 {
+		l.w.reset()
+		l.needDictReset = false
+	} else if l.needDictReset {
+		return ErrCorrupt
+	}
+	unpacked, e := l.readUint16()
+	if e != nil {
+		return e
+	}
+	packed, e := l.readUint16()
+	if e != nil {
+		return e
+	}
+	l.chunkRemaining = int(control&0x1F)<<16 + unpacked + 1
+	l.uncompressed = false
+	if reset >= 2 {
+		b, e := l.readByte()
+		if e != nil {
+			return e
+		}
+		props, e := decodeProps(b)
+		if e != nil {
+			return e
+		}
+		if props.lc+props.lp > 4 {
+			return ErrCorrupt
+		}
+		l.state.reset(props)
+		l.needProps = false
+	} else if l.needProps {
+		return ErrCorrupt
+	} else if reset == 1 {
+		l.state.reset(l.state.props)
+	}
+	l.chunk = limitedByteReader{r: l.r, n: packed + 1}
+	return l.rc.init(&l.chunk)
+}
+
+func (l *lzma2Reader) Read(p []byte) (int, error) {
+	for l.w.pending == 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+		if l.chunkRemaining == 0 {
+			if e := l.nextChunk(); e != nil {
+				l.err = e
+				continue
+			}
+		}
+		if l.uncompressed {
+			for l.chunkRemaining > 0 && l.w.pending < len(p) && l.w.free() > 0 {
+				b, e := l.readByte()
+				if e != nil {
+					l.err = e
+					break
+				}
+				l.w.put(b)
+				l.chunkRemaining--
+			}
+			continue
+		}
+		for l.chunkRemaining > 0 && l.w.pending < len(p) && l.w.free() >= matchMaxLen {
+			limit := matchMaxLen
+			if l.chunkRemaining < limit {
+				limit = l.chunkRemaining
+			}
+			before := l.w.pending
+			e := l.state.step(&l.rc, l.w, limit)
+			l.chunkRemaining -= l.w.pending - before
+			if e == errEndMarker {
+				e = ErrCorrupt
+			}
+			if e != nil {
+				l.err = e
+				break
+			}
+		}
+		if l.chunkRemaining == 0 && l.err == nil {
+			// skip the bytes not read by the range decoder
+			for l.chunk.n > 0 {
+				if _, e := l.chunk.ReadByte(); e != nil {
+					l.err = e
+					break
+				}
+			}
+		}
+	}
+	return l.w.read(p), nil
+}
@@ -0,0 +1,884 @@
+// Package sevenzip reads the 7z archives.
+// The headers are parsed by random access, and the members are decoded on demand,
+// the members of a solid block are decoded from the start of the block.
+// The coders supported are Copy, LZMA, LZMA2, Deflate, BZip2, BCJ(x86) and Delta, encrypted archives are not supported.
+package sevenzip
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+var (
+	ErrFormat      = errors.New("not a valid 7z archive")
+	ErrCorrupt     = errors.New("corrupt 7z archive")
+	ErrUnsupported = errors.New("unsupported 7z coder")
+	ErrChecksum    = errors.New("7z checksum error")
+)
+
+var signature = []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}
+
+const (
+	signatureHeaderSize = 32
+	// maxHeaderSize limits the memory of the headers
+	maxHeaderSize = 64 * 1024 * 1024
+)
+
+const (
+	idEnd                   = 0x00
+	idHeader                = 0x01
+	idArchiveProperties     = 0x02
+	idAdditionalStreamsInfo = 0x03
+	idMainStreamsInfo       = 0x04
+	idFilesInfo             = 0x05
+	idPackInfo              = 0x06
+	idUnpackInfo            = 0x07
+	idSubStreamsInfo        = 0x08
+	idSize                  = 0x09
+	idCRC                   = 0x0A
+	idFolder                = 0x0B
+	idCodersUnpackSize      = 0x0C
+	idNumUnpackStream       = 0x0D
+	idEmptyStream           = 0x0E
+	idEmptyFile             = 0x0F
+	idName                  = 0x11
+	idMTime                 = 0x14
+	idWinAttributes         = 0x15
+	idEncodedHeader         = 0x17
+	idDummy                 = 0x19
+)
+
+const (
+	attributeDirectory = 0x10
+	// windowsEpoch is the FILETIME of the unix epoch
+	windowsEpoch = 116444736000000000
+)
+
+type coder struct {
+	id         []byte
+	numIn      int
+	numOut     int
+	properties []byte
+}
+
+type bindPair struct {
+	in  int
+	out int
+}
+
+type folder struct {
+	coders        []coder
+	bindPairs     []bindPair
+	packedStreams []int
+	unpackSizes   []int64
+	crc           uint32
+	hasCRC        bool
+
+	// firstPackStream is the index of the first pack stream of the folder
+	firstPackStream int
+	// numSubStreams is the number of the files in the folder
+	numSubStreams int
+}
+
+// unpackSize returns the size of the main output stream, which is not bound to any coder
+func (f *folder) unpackSize() int64 {
+	for i := len(f.unpackSizes) - 1; i >= 0; i-- {
+		if f.findBindPairForOut(i) < 0 {
+			return f.unpackSizes[i]
+		}
+	}
+	return 0
+}
+
+func (f *folder) findBindPairForIn(in int) int {
+	for i, bp := range f.bindPairs {
+		if bp.in == in {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *folder) findBindPairForOut(out int) int {
+	for i, bp := range f.bindPairs {
+		if bp.out == out {
+			return i
+		}
+	}
+	return -1
+}
+
+type streamsInfo struct {
+	packPos   int64
+	packSizes []int64
+	folders   []*folder
+	// subStreamSizes and subStreamCRCs are of the files of all folders
+	subStreamSizes  []int64
+	subStreamCRCs   []uint32
+	subStreamHasCRC []bool
+}
+
+// File is a member of the archive
+type File struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+
+	r *Reader
+	// folder is -1 for the empty files and the directories
+	folder int
+	// offset is the offset of the file in the unpacked stream of the folder
+	offset int64
+	crc    uint32
+	hasCRC bool
+}
+
+// Reader is an opened archive
+type Reader struct {
+	r    io.ReaderAt
+	size int64
+
+	streams *streamsInfo
+	File    []*File
+}
+
+// NewReader reads the headers of the archive of size
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	sh := make([]byte, signatureHeaderSize)
+	if _, e := r.ReadAt(sh, 0); e != nil {
+		if e == io.EOF {
+			return nil, ErrFormat
+		}
+		return nil, e
+	}
+	if !bytes.Equal(sh[:6], signature) {
+		return nil, ErrFormat
+	}
+	if crc32.ChecksumIEEE(sh[12:32]) != binary.LittleEndian.Uint32(sh[8:12]) {
+		return nil, ErrChecksum
+	}
+	nextOffset := int64(binary.LittleEndian.Uint64(sh[12:20]))
+	nextSize := int64(binary.LittleEndian.Uint64(sh[20:28]))
+	nextCRC := binary.LittleEndian.Uint32(sh[28:32])
+	zr := &Reader{r: r, size: size}
+	if nextSize == 0 {
+		// the empty archive
+		return zr, nil
+	}
+	if nextOffset < 0 || nextSize < 0 || nextSize > maxHeaderSize ||
+		signatureHeaderSize+nextOffset+nextSize > size {
+		return nil, ErrCorrupt
+	}
+	header := make([]byte, nextSize)
+	if _, e := r.ReadAt(header, signatureHeaderSize+nextOffset); e != nil {
+		return nil, e
+	}
+	if crc32.ChecksumIEEE(header) != nextCRC {
+		return nil, ErrChecksum
+	}
+	for {
+		hr := &headerReader{b: header}
+		id := hr.readByte()
+		if hr.err != nil {
+			return nil, hr.err
+		}
+		if id == idHeader {
+			if e := zr.readHeader(hr); e != nil {
+				return nil, e
+			}
+			return zr, nil
+		}
+		if id != idEncodedHeader {
+			return nil, ErrCorrupt
+		}
+		si, e := readStreamsInfo(hr)
+		if e != nil {
+			return nil, e
+		}
+		if header, e = zr.decodeHeader(si); e != nil {
+			return nil, e
+		}
+	}
+}
+
+// decodeHeader decodes the encoded header, which is stored in the first folder of the streams
+func (z *Reader) decodeHeader(si *streamsInfo) ([]byte, error) {
+	if len(si.folders) == 0 {
+		return nil, ErrCorrupt
+	}
+	f := si.folders[0]
+	size := f.unpackSize()
+	if size < 0 || size > maxHeaderSize {
+		return nil, ErrCorrupt
+	}
+	reader, e := z.folderReader(si, 0)
+	if e != nil {
+		return nil, e
+	}
+	header := make([]byte, size)
+	if _, e := io.ReadFull(reader, header); e != nil {
+		return nil, corruptIfEOF(e)
+	}
+	if f.hasCRC && crc32.ChecksumIEEE(header) != f.crc {
+		return nil, ErrChecksum
+	}
+	return header, nil
+}
+
+func corruptIfEOF(e error) error {
+	if e == io.EOF || e == io.ErrUnexpectedEOF {
+		return ErrCorrupt
+	}
+	return e
+}
+
+func (z *Reader) readHeader(hr *headerReader) error {
+	id := hr.readByte()
+	if id == idArchiveProperties {
+		for hr.err == nil {
+			if t := hr.readNumber(); t == idEnd {
+				break
+			}
+			hr.skip(hr.readNumber())
+		}
+		id = hr.readByte()
+	}
+	if id == idAdditionalStreamsInfo {
+		if _, e := readStreamsInfo(hr); e != nil {
+			return e
+		}
+		id = hr.readByte()
+	}
+	si := &streamsInfo{}
+	if id == idMainStreamsInfo {
+		var e error
+		if si, e = readStreamsInfo(hr); e != nil {
+			return e
+		}
+		id = hr.readByte()
+	}
+	z.streams = si
+	if id == idFilesInfo {
+		if e := z.readFilesInfo(hr, si); e != nil {
+			return e
+		}
+		id = hr.readByte()
+	}
+	if hr.err != nil {
+		return hr.err
+	}
+	if id != idEnd {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+func readStreamsInfo(hr *headerReader) (*streamsInfo, error) {
+	si := &streamsInfo{}
+	id := hr.readByte()
+	if id == idPackInfo {
+		si.packPos = int64(hr.readNumber())
+		numPackStreams := hr.readCount()
+		id = hr.readByte()
+		if id == idSize {
+			si.packSizes = make([]int64, numPackStreams)
+			for i := range si.packSizes {
+				si.packSizes[i] = int64(hr.readNumber())
+			}
+			id = hr.readByte()
+		}
+		if id == idCRC {
+			hr.readDigests(numPackStreams)
+			id = hr.readByte()
+		}
+		if id != idEnd {
+			return nil, hr.errOr(ErrCorrupt)
+		}
+		id = hr.readByte()
+	}
+	if id == idUnpackInfo {
+		if e := readUnpackInfo(hr, si); e != nil {
+			return nil, e
+		}
+		id = hr.readByte()
+	}
+	for _, f := range si.folders {
+		f.numSubStreams = 1
+	}
+	if id == idSubStreamsInfo {
+		if e := readSubStreamsInfo(hr, si); e != nil {
+			return nil, e
+		}
+		id = hr.readByte()
+	} else {
+		for _, f := range si.folders {
+			si.subStreamSizes = append(si.subStreamSizes, f.unpackSize())
+			si.subStreamCRCs = append(si.subStreamCRCs, f.crc)
+			si.subStreamHasCRC = append(si.subStreamHasCRC, f.hasCRC)
+		}
+	}
+	if id != idEnd {
+		return nil, hr.errOr(ErrCorrupt)
+	}
+	packStream := 0
+	for _, f := range si.folders {
+		f.firstPackStream = packStream
+		packStream += len(f.packedStreams)
+	}
+	if packStream > len(si.packSizes) {
+		return nil, ErrCorrupt
+	}
+	return si, hr.err
+}
+
+func readUnpackInfo(hr *headerReader, si *streamsInfo) error {
+	if hr.readByte() != idFolder {
+		return hr.errOr(ErrCorrupt)
+	}
+	numFolders := hr.readCount()
+	if hr.readByte() != 0 {
+		// the external folders are not used by 7-Zip
+		return hr.errOr(ErrUnsupported)
+	}
+	si.folders = make([]*folder, numFolders)
+	for i := range si.folders {
+		f, e := readFolder(hr)
+		if e != nil {
+			return e
+		}
+		si.folders[i] = f
+	}
+	if hr.readByte() != idCodersUnpackSize {
+		return hr.errOr(ErrCorrupt)
+	}
+	for _, f := range si.folders {
+		numOut := 0
+		for _, c := range f.coders {
+			numOut += c.numOut
+		}
+		f.unpackSizes = make([]int64, numOut)
+		for j := range f.unpackSizes {
+			f.unpackSizes[j] = int64(hr.readNumber())
+		}
+	}
+	id := hr.readByte()
+	if id == idCRC {
+		defined, crcs := hr.readDigests(len(si.folders))
+		for i, f := range si.folders {
+			f.hasCRC, f.crc = defined[i], crcs[i]
+		}
+		id = hr.readByte()
+	}
+	if id != idEnd {
+		return hr.errOr(ErrCorrupt)
+	}
+	return hr.err
+}
+
+func readFolder(hr *headerReader) (*folder, error) {
+	f := &folder{}
+	numCoders := hr.readCount()
+	if numCoders == 0 || numCoders > 64 {
+		return nil, hr.errOr(ErrCorrupt)
+	}
+	numIn, numOut := 0, 0
+	for i := 0; i < numCoders; i++ {
+		flags := hr.readByte()
+		if flags&0x80 != 0 {
+			return nil, hr.errOr(ErrUnsupported)
+		}
+		c := coder{id: hr.readBytes(int(flags & 0x0F)), numIn: 1, numOut: 1}
+		if flags&0x10 != 0 {
+			c.numIn = hr.readCount()
+			c.numOut = hr.readCount()
+		}
+		if flags&0x20 != 0 {
+			c.properties = hr.readBytes(hr.readCount())
+		}
+		numIn += c.numIn
+		numOut += c.numOut
+		f.coders = append(f.coders, c)
+	}
+	if numOut == 0 || numIn > 64 || numOut > 64 {
+		return nil, hr.errOr(ErrCorrupt)
+	}
+	for i := 0; i < numOut-1; i++ {
+		f.bindPairs = append(f.bindPairs, bindPair{in: hr.readCount(), out: hr.readCount()})
+	}
+	numPacked := numIn - len(f.bindPairs)
+	if numPacked < 1 {
+		return nil, hr.errOr(ErrCorrupt)
+	}
+	if numPacked == 1 {
+		for i := 0; i < numIn; i++ {
+			if f.findBindPairForIn(i) < 0 {
+				f.packedStreams = append(f.packedStreams, i)
+				break
+			}
+		}
+	} else {
+		for i := 0; i < numPacked; i++ {
+			f.packedStreams = append(f.packedStreams, hr.readCount())
+		}
+	}
+	return f, hr.err
+}
+
+func readSubStreamsInfo(hr *headerReader, si *streamsInfo) error {
+	id := hr.readByte()
+	if id == idNumUnpackStream {
+		for _, f := range si.folders {
+			f.numSubStreams = hr.readCount()
+		}
+		id = hr.readByte()
+	}
+	for _, f := range si.folders {
+		if f.numSubStreams == 0 {
+			continue
+		}
+		sum := int64(0)
+		if id == idSize {
+			for j := 1; j < f.numSubStreams; j++ {
+				size := int64(hr.readNumber())
+				si.subStreamSizes = append(si.subStreamSizes, size)
+				sum += size
+			}
+		} else if f.numSubStreams > 1 {
+			return hr.errOr(ErrCorrupt)
+		}
+		if sum > f.unpackSize() {
+			return hr.errOr(ErrCorrupt)
+		}
+		si.subStreamSizes = append(si.subStreamSizes, f.unpackSize()-sum)
+	}
+	if id == idSize {
+		id = hr.readByte()
+	}
+
+	// the CRCs of the folders of single file are in the folders
+	numDigests := 0
+	for _, f := range si.folders {
+		if f.numSubStreams != 1 || !f.hasCRC {
+			numDigests += f.numSubStreams
+		}
+	}
+	for id != idEnd && hr.err == nil {
+		if id == idCRC {
+			defined, crcs := hr.readDigests(numDigests)
+			i := 0
+			for _, f := range si.folders {
+				if f.numSubStreams == 1 && f.hasCRC {
+					si.subStreamCRCs = append(si.subStreamCRCs, f.crc)
+					si.subStreamHasCRC = append(si.subStreamHasCRC, true)
+					continue
+				}
+				for j := 0; j < f.numSubStreams && i < len(crcs); j++ {
+					si.subStreamCRCs = append(si.subStreamCRCs, crcs[i])
+					si.subStreamHasCRC = append(si.subStreamHasCRC, defined[i])
+					i++
+				}
+			}
+		} else {
+			hr.skip(hr.readNumber())
+		}
+		id = hr.readByte()
+	}
+	if len(si.subStreamCRCs) == 0 {
+		for _, f := range si.folders {
+			for j := 0; j < f.numSubStreams; j++ {
+				hasCRC := f.numSubStreams == 1 && f.hasCRC
+				si.subStreamCRCs = append(si.subStreamCRCs, f.crc)
+				si.subStreamHasCRC = append(si.subStreamHasCRC, hasCRC)
+			}
+		}
+	}
+	return hr.err
+}
+
+func (z *Reader) readFilesInfo(hr *headerReader, si *streamsInfo) error {
+	numFiles := hr.readCount()
+	files := make([]*File, numFiles)
+	for i := range files {
+		files[i] = &File{r: z, folder: -1}
+	}
+	var emptyStream, emptyFile []bool
+	attributes := make([]uint32, numFiles)
+	hasAttributes := make([]bool, numFiles)
+	for hr.err == nil {
+		id := hr.readNumber()
+		if id == idEnd {
+			break
+		}
+		size := hr.readNumber()
+		if size > uint64(len(hr.b)) {
+			return ErrCorrupt
+		}
+		pr := &headerReader{b: hr.readBytes(int(size))}
+		switch id {
+		case idEmptyStream:
+			emptyStream = pr.readBits(numFiles)
+		case idEmptyFile:
+			numEmpty := 0
+			for _, e := range emptyStream {
+				if e {
+					numEmpty++
+				}
+			}
+			emptyFile = pr.readBits(numEmpty)
+		case idName:
+			if pr.readByte() != 0 {
+				return pr.errOr(ErrUnsupported)
+			}
+			names, e := decodeNames(pr.b[pr.pos:], numFiles)
+			if e != nil {
+				return e
+			}
+			for i, name := range names {
+				files[i].Name = name
+			}
+		case idMTime:
+			defined := pr.readOptionalBits(numFiles)
+			if pr.readByte() != 0 {
+				return pr.errOr(ErrUnsupported)
+			}
+			for i, d := range defined {
+				if d {
+					ft := int64(pr.readUint64())
+					files[i].ModTime = time.Unix(0, (ft-windowsEpoch)*100)
+				}
+			}
+		case idWinAttributes:
+			defined := pr.readOptionalBits(numFiles)
+			if pr.readByte() != 0 {
+				return pr.errOr(ErrUnsupported)
+			}
+			for i, d := range defined {
+				if d {
+					attributes[i] = pr.readUint32()
+					hasAttributes[i] = true
+				}
+			}
+		}
+		if pr.err != nil {
+			return pr.err
+		}
+	}
+	if hr.err != nil {
+		return hr.err
+	}
+
+	folderIndex, inFolder, offset := 0, 0, int64(0)
+	subStream, emptyIndex := 0, 0
+	for i, f := range files {
+		if i < len(emptyStream) && emptyStream[i] {
+			f.IsDir = emptyIndex >= len(emptyFile) || !emptyFile[emptyIndex]
+			emptyIndex++
+			if hasAttributes[i] {
+				f.IsDir = attributes[i]&attributeDirectory != 0
+			}
+			f.Size = -1
+			if !f.IsDir {
+				f.Size = 0
+			}
+			continue
+		}
+		for folderIndex < len(si.folders) && inFolder >= si.folders[folderIndex].numSubStreams {
+			folderIndex++
+			inFolder, offset = 0, 0
+		}
+		if folderIndex >= len(si.folders) || subStream >= len(si.subStreamSizes) {
+			return ErrCorrupt
+		}
+		f.folder = folderIndex
+		f.offset = offset
+		f.Size = si.subStreamSizes[subStream]
+		if subStream < len(si.subStreamCRCs) {
+			f.crc, f.hasCRC = si.subStreamCRCs[subStream], si.subStreamHasCRC[subStream]
+		}
+		offset += f.Size
+		inFolder++
+		subStream++
+	}
+	z.File = files
+	return nil
+}
+
+func decodeNames(b []byte, n int) ([]string, error) {
+	names := make([]string, 0, n)
+	u := make([]uint16, 0, 64)
+	for i := 0; i+1 < len(b) && len(names) < n; i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			names = append(names, strings.ReplaceAll(string(utf16.Decode(u)), "\\", "/"))
+			u = u[:0]
+			continue
+		}
+		u = append(u, c)
+	}
+	if len(names) != n {
+		return nil, ErrCorrupt
+	}
+	return names, nil
+}
+
+// folderReader returns the reader of the unpacked stream of the folder
+func (z *Reader) folderReader(si *streamsInfo, index int) (io.Reader, error) {
+	f := si.folders[index]
+	if len(f.packedStreams) != 1 {
+		// BCJ2 of 4 input streams
+		return nil, ErrUnsupported
+	}
+	offset := signatureHeaderSize + si.packPos
+	for i := 0; i < f.firstPackStream; i++ {
+		offset += si.packSizes[i]
+	}
+	packSize := si.packSizes[f.firstPackStream]
+	if offset+packSize > z.size {
+		return nil, ErrCorrupt
+	}
+	packed := io.NewSectionReader(z.r, offset, packSize)
+
+	// the coders are chained from the main coder to the packed stream, by the bind pairs
+	var build func(c int, depth int) (io.Reader, error)
+	build = func(c int, depth int) (io.Reader, error) {
+		if depth > len(f.coders) {
+			return nil, ErrCorrupt
+		}
+		cd := f.coders[c]
+		if cd.numIn != 1 || cd.numOut != 1 {
+			return nil, ErrUnsupported
+		}
+		var input io.Reader
+		if bp := f.findBindPairForIn(c); bp >= 0 {
+			out := f.bindPairs[bp].out
+			if out >= len(f.coders) {
+				return nil, ErrCorrupt
+			}
+			in, e := build(out, depth+1)
+			if e != nil {
+				return nil, e
+			}
+			input = in
+		} else {
+			input = packed
+		}
+		return newDecoder(cd, input, f.unpackSizes[c])
+	}
+	main := -1
+	for i := range f.coders {
+		if f.findBindPairForOut(i) < 0 {
+			main = i
+			break
+		}
+	}
+	if main < 0 {
+		return nil, ErrCorrupt
+	}
+	return build(main, 0)
+}
+
+func newDecoder(c coder, r io.Reader, size int64) (io.Reader, error) {
+	switch string(c.id) {
+	case "\x00":
+		return r, nil
+	case "\x21":
+		return newLZMA2Reader(r, c.properties, size)
+	case "\x03\x01\x01":
+		return newLZMAReader(r, c.properties, size)
+	case "\x03\x03\x01\x03":
+		return newBCJReader(r), nil
+	case "\x03":
+		return newDeltaReader(r, c.properties)
+	case "\x04\x01\x08":
+		return flate.NewReader(r), nil
+	case "\x04\x02\x02":
+		return bzip2.NewReader(r), nil
+	}
+	return nil, ErrUnsupported
+}
+
+// Open returns the reader of the content of the file,
+// the bytes of the files before it in the same solid block are decoded and discarded
+func (f *File) Open() (io.ReadCloser, error) {
+	if f.IsDir {
+		return nil, ErrUnsupported
+	}
+	if f.folder < 0 || f.Size == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	r, e := f.r.folderReader(f.r.streams, f.folder)
+	if e != nil {
+		return nil, e
+	}
+	if f.offset > 0 {
+		if _, e := io.CopyN(ioutil.Discard, r, f.offset); e != nil {
+			return nil, corruptIfEOF(e)
+		}
+	}
+	return &fileReader{r: io.LimitReader(r, f.Size), remaining: f.Size, crc: f.crc, check: f.hasCRC}, nil
+}
+
+// fileReader verifies the CRC at the end of the file
+type fileReader struct {
+	r         io.Reader
+	remaining int64
+	hash      uint32
+	crc       uint32
+	check     bool
+}
+
+func (f *fileReader) Read(p []byte) (int, error) {
+	n, e := f.r.Read(p)
+	f.hash = crc32.Update(f.hash, crc32.IEEETable, p[:n])
+	f.remaining -= int64(n)
+	if e == io.EOF {
+		if f.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		if f.check && f.hash != f.crc {
+			return n, ErrChecksum
+		}
+	}
+	return n, e
+}
+
+func (f *fileReader) Close() error {
+	return nil
+}
+
+// headerReader reads the header, the errors are kept until checked
+type headerReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (h *headerReader) fail() {
+	if h.err == nil {
+		h.err = ErrCorrupt
+	}
+}
+
+func (h *headerReader) errOr(e error) error {
+	if h.err != nil {
+		return h.err
+	}
+	return e
+}
+
+func (h *headerReader) readByte() byte {
+	if h.pos >= len(h.b) {
+		h.fail()
+		return 0
+	}
+	b := h.b[h.pos]
+	h.pos++
+	return b
+}
+
+func (h *headerReader) readBytes(n int) []byte {
+	if n < 0 || h.pos+n > len(h.b) {
+		h.fail()
+		return nil
+	}
+	b := h.b[h.pos : h.pos+n]
+	h.pos += n
+	return b
+}
+
+func (h *headerReader) skip(n uint64) {
+	if n > uint64(len(h.b)-h.pos) {
+		h.fail()
+		return
+	}
+	h.pos += int(n)
+}
+
+// readNumber reads the variable length number, the count of the leading 1 bits of the first byte is the extra bytes
+func (h *headerReader) readNumber() uint64 {
+	first := h.readByte()
+	mask := byte(0x80)
+	value := uint64(0)
+	for i := uint(0); i < 8; i++ {
+		if first&mask == 0 {
+			high := uint64(first & (mask - 1))
+			return value | high<<(8*i)
+		}
+		value |= uint64(h.readByte()) << (8 * i)
+		mask >>= 1
+	}
+	return value
+}
+
+// readCount reads the number of the items, which is limited by the size of the header
+func (h *headerReader) readCount() int {
+	n := h.readNumber()
+	if n > uint64(len(h.b)) {
+		h.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (h *headerReader) readUint32() uint32 {
+	b := h.readBytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (h *headerReader) readUint64() uint64 {
+	b := h.readBytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (h *headerReader) readBits(n int) []bool {
+	r := make([]bool, n)
+	var b, mask byte
+	for i := range r {
+		if mask == 0 {
+			b = h.readByte()
+			mask = 0x80
+		}
+		r[i] = b&mask != 0
+		mask >>= 1
+	}
+	return r
+}
+
+// readOptionalBits reads the bits, or all are defined if the first byte is not 0
+func (h *headerReader) readOptionalBits(n int) []bool {
+	if h.readByte() == 0 {
+		return h.readBits(n)
+	}
+	r := make([]bool, n)
+	for i := range r {
+		r[i] = true
+	}
+	return r
+}
+
+func (h *headerReader) readDigests(n int) ([]bool, []uint32) {
+	defined := h.readOptionalBits(n)
+	crcs := make([]uint32, n)
+	for i, d := range defined {
+		if d {
+			crcs[i] = h.readUint32()
+		}
+	}
+	return defined, crcs
+}
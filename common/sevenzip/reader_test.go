@@ -0,0 +1,151 @@
+package sevenzip
+
+import (
+	"bytes"
+	"encoding/base64"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// the archives are of the same files, the second one has the header compressed by LZMA.
+// dir/a.txt and dir/b.txt are in a solid block of LZMA2, c.bin is filtered by BCJ and compressed by LZMA.
+var (
+	plainHeaderArchive = "" +
+		"N3q8ryccAAQuKPpyRQQAAAAAAADXAAAAAAAAADKzBwXgDRUAQF0ANBlJ7o3pBhLshrLpuih5wSYmBnBpBsCyFYxfvKKkYuC3AHsF" +
+		"Bo9lJ8lFbCv8FqjDGzyB48ORKrjr3GvjDQ6IAAAAdAEIRUv6hbq74ZTiTri4DDfKk6Rsvy5aJsGVMZzeZS/vPS7/QTWsSFPG/QxX" +
+		"T9/Zm+9S6iVO+zFOoCOdhEr/llsZIxHCqoaAX7XUHUPnwWUiQ5YkKYcQ5+ZmUlRUbAKucvJP4LlZzbc+MZIzdawWmFZ61cyZIGNb" +
+		"FkTYEyjTHRp3p4+uUlxGakc5EorWQl1jLA8SkCdJJMMCSFqgVv5lVO/8RW2tRKkJmFF1NKPj7fStszdRlocoL/GzQHwRgnCg8ERV" +
+		"yZE9gtvXqnqEwwdVdbiyTkgVjIC114SD2B4k5Q5mSrWv21kuVR+8uqj6P2M7bz5HJj32Jxal8rTWB+qFjuSIT/xzs591SHr92kEV" +
+		"hfSrrz7Znx9ux3jq4QeiKqOr2AsUHYdKu/Ye2NqSsZ6XQEG50AY+VB4/UcjhV36AiiUZtg3+uJxe+HVKSxEbp03JGepyK/Py052R" +
+		"Zkw7IgtQVFYV2d2Ccy4h9XkJJ1pXjeYMTuxRPARApgsdxOcggE04VF0Wk18KlBZ6RfqCwb3Omch6LOWKWMkyFHRi5DWsyPB96UKt" +
+		"0BplZ7NKS/gK/zu/DdUL6qduKjZ4nwda16UJeyAR7s8JV8akIfgvRa+VAgTEcH2RrWVjivBF79XEdxYnwpQpo312OdjHFhHPs8V5" +
+		"gTT4K2t2YRcLld1N2Yl5VwrtwoEPVki+H2TwePEjjPCiRKU/w9vuPcwc7Pn4bHPLsvZ41xxBWABkKt7TvZZYnu5K78V3NoQL9Asc" +
+		"aIV5/ZoS4kuvKcZEWlSdiWDkOtU1Y1eJEKl4lYFwU2SybnK6lUgkbjgjxZHxbw+6G/hUTlbFDjv31QqxBTpz8vUohk04CB85WQpi" +
+		"ODqik9frtcTEJInD8svUauYSRnIpeAETManjevdxbIR+cOvjbV6fMtR13hQGl3UMcEpTT61DNKKY+ZW4n+tUCW+ydA6kHMnDJCEX" +
+		"jQhS5KBjZI+JRolRFvlKK+tuPx4zmrFDuvLAuZ3BG+HJmqiwmKnlHVH1yO+ejs3QAlysy4V7ejBHZdVQhs+paJM6BsKtJFvhxGPJ" +
+		"h3ruq3ZVnEui9DjAOC/rdkF31rhqmzeehmHCqaF6FyVBofuCGDoooxIfhVc9epLkkC1W1aeoouxb645cxGJ9osAo7d4odz8Gz0yF" +
+		"VyAwbshdq1WtADFWP4rrF1UtA6jOqLiHFEp6HTzyuJWnf2L7S5cB15PWyoYZ3Aobt/4I8M8/gprqnRYgFjTGqkG9KfkH0H3nNR+i" +
+		"B/txnNfJ53Jnj67mJCNUxM3utl9q8aIH/X+yOW2oiODDYZnxDJSXDa8akFOmR3hweUT8glntRETId1TVsOEz9Qg/guUk5//uegAA" +
+		"AQQGAAIJSIP9AAcLAgABISEBCAIEAwMBAyMDAQEFXQAAAQAAAQyNFoQAhAAKAZ/THV7FHVCYAAgNAgEJhwgKARI7ByMP5PHtAAAF" +
+		"BQ4BiA8BQBFRAGQAaQByAAAAZABpAHIAXABhAC4AdAB4AHQAAABkAGkAcgAvAGIALgB0AHgAdAAAAGMALgBiAGkAbgAAAGUAbQBw" +
+		"AHQAeQAuAHQAeAB0AAAAFCoBAACApiHJidYBgBY/IsmJ1gEArdciyYnWAYBDcCPJidYBANoIJMmJ1gEZAgAAAAA="
+	encodedHeaderArchive = "" +
+		"N3q8ryccAAR9VU9U7QQAAAAAAAAjAAAAAAAAANNi4iTgDRUAQF0ANBlJ7o3pBhLshrLpuih5wSYmBnBpBsCyFYxfvKKkYuC3AHsF" +
+		"Bo9lJ8lFbCv8FqjDGzyB48ORKrjr3GvjDQ6IAAAAdAEIRUv6hbq74ZTiTri4DDfKk6Rsvy5aJsGVMZzeZS/vPS7/QTWsSFPG/QxX" +
+		"T9/Zm+9S6iVO+zFOoCOdhEr/llsZIxHCqoaAX7XUHUPnwWUiQ5YkKYcQ5+ZmUlRUbAKucvJP4LlZzbc+MZIzdawWmFZ61cyZIGNb" +
+		"FkTYEyjTHRp3p4+uUlxGakc5EorWQl1jLA8SkCdJJMMCSFqgVv5lVO/8RW2tRKkJmFF1NKPj7fStszdRlocoL/GzQHwRgnCg8ERV" +
+		"yZE9gtvXqnqEwwdVdbiyTkgVjIC114SD2B4k5Q5mSrWv21kuVR+8uqj6P2M7bz5HJj32Jxal8rTWB+qFjuSIT/xzs591SHr92kEV" +
+		"hfSrrz7Znx9ux3jq4QeiKqOr2AsUHYdKu/Ye2NqSsZ6XQEG50AY+VB4/UcjhV36AiiUZtg3+uJxe+HVKSxEbp03JGepyK/Py052R" +
+		"Zkw7IgtQVFYV2d2Ccy4h9XkJJ1pXjeYMTuxRPARApgsdxOcggE04VF0Wk18KlBZ6RfqCwb3Omch6LOWKWMkyFHRi5DWsyPB96UKt" +
+		"0BplZ7NKS/gK/zu/DdUL6qduKjZ4nwda16UJeyAR7s8JV8akIfgvRa+VAgTEcH2RrWVjivBF79XEdxYnwpQpo312OdjHFhHPs8V5" +
+		"gTT4K2t2YRcLld1N2Yl5VwrtwoEPVki+H2TwePEjjPCiRKU/w9vuPcwc7Pn4bHPLsvZ41xxBWABkKt7TvZZYnu5K78V3NoQL9Asc" +
+		"aIV5/ZoS4kuvKcZEWlSdiWDkOtU1Y1eJEKl4lYFwU2SybnK6lUgkbjgjxZHxbw+6G/hUTlbFDjv31QqxBTpz8vUohk04CB85WQpi" +
+		"ODqik9frtcTEJInD8svUauYSRnIpeAETManjevdxbIR+cOvjbV6fMtR13hQGl3UMcEpTT61DNKKY+ZW4n+tUCW+ydA6kHMnDJCEX" +
+		"jQhS5KBjZI+JRolRFvlKK+tuPx4zmrFDuvLAuZ3BG+HJmqiwmKnlHVH1yO+ejs3QAlysy4V7ejBHZdVQhs+paJM6BsKtJFvhxGPJ" +
+		"h3ruq3ZVnEui9DjAOC/rdkF31rhqmzeehmHCqaF6FyVBofuCGDoooxIfhVc9epLkkC1W1aeoouxb645cxGJ9osAo7d4odz8Gz0yF" +
+		"VyAwbshdq1WtADFWP4rrF1UtA6jOqLiHFEp6HTzyuJWnf2L7S5cB15PWyoYZ3Aobt/4I8M8/gprqnRYgFjTGqkG9KfkH0H3nNR+i" +
+		"B/txnNfJ53Jnj67mJCNUxM3utl9q8aIH/X+yOW2oiODDYZnxDJSXDa8akFOmR3hweUT8glntRETId1TVsOEz9Qg/guUk5//uegAA" +
+		"AACBMweuMZxcbwhdjQdkXpcQuPrQBGFRV5+d6QeUscmowe/OjTNSU+NC9JjBpyU6qsPDTcIvpJFUY1aE1UdMrRIs0GNNIJF/MV8l" +
+		"9PItEG5rl50BTAUiYxp4P+DBsuqdZEJuXu8zGJJs4iKnxiuOUXWPG6CWbjU08c+JR7HVUAKnJdkBVvOsQ/6//4kooseWnEO7UDpz" +
+		"z39y9YdcgzQvL+fkqP+h2xAAFwaERQEJgKgABwsBAAEjAwEBBV0AAAEADIDXCgEyswcFAAA="
+)
+
+// testBinary returns the pseudo-random bytes with the CALL opcodes, which are converted by BCJ
+func testBinary(n int) []byte {
+	b := make([]byte, n)
+	x := uint32(12345)
+	for i := range b {
+		x = (x*1103515245 + 12345) & 0x7fffffff
+		b[i] = byte(x >> 16)
+		if i%7 == 0 {
+			b[i] = 0xE8
+		}
+	}
+	return b
+}
+
+func TestSevenZipReader(t *testing.T) {
+	want := map[string][]byte{
+		"dir/a.txt": bytes.Repeat([]byte("hello 7z\n"), 200),
+		"dir/b.txt": bytes.Repeat([]byte("second file of the solid block\n"), 50),
+		"c.bin":     testBinary(1024),
+		"empty.txt": {},
+	}
+	for _, archive := range []string{plainHeaderArchive, encodedHeaderArchive} {
+		data, e := base64.StdEncoding.DecodeString(archive)
+		if e != nil {
+			t.Fatal(e)
+		}
+		r, e := NewReader(bytes.NewReader(data), int64(len(data)))
+		if e != nil {
+			t.Fatal(e)
+		}
+		if len(r.File) != 5 {
+			t.Fatalf("unexpected files: %d", len(r.File))
+		}
+		if f := r.File[0]; f.Name != "dir" || !f.IsDir || f.Size != -1 || !f.ModTime.Equal(time.Unix(1600000000, 0)) {
+			t.Errorf("unexpected directory: %+v", f)
+		}
+		for _, f := range r.File[1:] {
+			reader, e := f.Open()
+			if e != nil {
+				t.Fatal(e)
+			}
+			content, e := ioutil.ReadAll(reader)
+			_ = reader.Close()
+			if e != nil {
+				t.Errorf("failed to read %s: %v", f.Name, e)
+				continue
+			}
+			if f.IsDir || f.Size != int64(len(want[f.Name])) || !bytes.Equal(content, want[f.Name]) {
+				t.Errorf("unexpected content of %s", f.Name)
+			}
+		}
+	}
+}
+
+func TestSevenZipCorrupt(t *testing.T) {
+	data, _ := base64.StdEncoding.DecodeString(plainHeaderArchive)
+	if _, e := NewReader(bytes.NewReader(data[:20]), 20); e == nil {
+		t.Error("expect error of the truncated archive")
+	}
+	if _, e := NewReader(bytes.NewReader([]byte("PK\x03\x04 not a 7z archive....")), 32); e != ErrFormat {
+		t.Errorf("expect format error, got %v", e)
+	}
+
+	// corrupt the content of c.bin in the packed stream, which is checked by CRC
+	corrupt := append([]byte(nil), data...)
+	r, e := NewReader(bytes.NewReader(corrupt), int64(len(corrupt)))
+	if e != nil {
+		t.Fatal(e)
+	}
+	f := r.File[3]
+	offset := signatureHeaderSize + r.streams.packPos + r.streams.packSizes[0] + 100
+	corrupt[offset] ^= 0xFF
+	reader, e := f.Open()
+	if e == nil {
+		content, e := ioutil.ReadAll(reader)
+		if e == nil && crc32.ChecksumIEEE(content) == crc32.ChecksumIEEE(testBinary(1024)) {
+			t.Error("expect error of the corrupt content")
+		}
+	}
+}
+
+func TestSevenZipNumber(t *testing.T) {
+	for _, c := range []struct {
+		b []byte
+		n uint64
+	}{
+		{[]byte{0x7F}, 0x7F},
+		{[]byte{0x80, 0x80}, 0x80},
+		{[]byte{0xC1, 0x02, 0x03}, 0x010302},
+		{[]byte{0xFF, 1, 2, 3, 4, 5, 6, 7, 8}, 0x0807060504030201},
+	} {
+		h := &headerReader{b: c.b}
+		if n := h.readNumber(); n != c.n || h.err != nil || h.pos != len(c.b) {
+			t.Errorf("unexpected number of %x: %x, %v", c.b, n, h.err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package task
 
 import (
+	"context"
 	"errors"
 	"go-drive/common/types"
 	"time"
@@ -9,6 +10,7 @@ import (
 const (
 	Pending  = "pending"
 	Running  = "running"
+	Paused   = "paused"
 	Done     = "done"
 	Error    = "error"
 	Canceled = "canceled"
@@ -17,6 +19,7 @@ const (
 var (
 	ErrorNotFound = errors.New("task not found")
 	ErrorCanceled = errors.New("canceled")
+	ErrorFinished = errors.New("task finished")
 )
 
 type Status = string
@@ -47,10 +50,38 @@ type Runner interface {
 	ExecuteAndWait(runnable Runnable, timeout time.Duration) (Task, error)
 	GetTask(id string) (Task, error)
 	StopTask(id string) (Task, error)
+	// PauseTask pauses the task, a paused task blocks at its next WaitIfPaused check
+	PauseTask(id string) (Task, error)
+	ResumeTask(id string) (Task, error)
 	RemoveTask(id string) error
 	Dispose() error
 }
 
+type taskIDKey struct{}
+
+// ID returns the id of the task running with ctx, or "" if ctx is not of a task
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(taskIDKey{}).(string)
+	return id
+}
+
+// WithValue returns ctx carrying value of key
+func WithValue(ctx types.TaskCtx, key, value interface{}) types.TaskCtx {
+	return &valueCtx{TaskCtx: ctx, key: key, value: value}
+}
+
+type valueCtx struct {
+	types.TaskCtx
+	key, value interface{}
+}
+
+func (v *valueCtx) Value(key interface{}) interface{} {
+	if key == v.key {
+		return v.value
+	}
+	return v.TaskCtx.Value(key)
+}
+
 func DummyContext() types.TaskCtx {
 	return dummyCtx
 }
@@ -70,6 +101,10 @@ func (d *dummyContext) Canceled() bool {
 	return false
 }
 
+func (d *dummyContext) WaitIfPaused() error {
+	return nil
+}
+
 func (d *dummyContext) Deadline() (deadline time.Time, ok bool) {
 	return
 }
@@ -130,6 +165,13 @@ func (c *ctxWrapper) Canceled() bool {
 	return c.ctx.Canceled()
 }
 
+func (c *ctxWrapper) WaitIfPaused() error {
+	if !c.cancelable {
+		return nil
+	}
+	return c.ctx.WaitIfPaused()
+}
+
 func (c *ctxWrapper) Deadline() (deadline time.Time, ok bool) {
 	return c.ctx.Deadline()
 }
@@ -97,6 +97,28 @@ func (t *TunnyRunner) StopTask(id string) (Task, error) {
 	return *w.task, nil
 }
 
+func (t *TunnyRunner) PauseTask(id string) (Task, error) {
+	temp, ok := t.store.Get(id)
+	if !ok {
+		return Task{}, ErrorNotFound
+	}
+	w := temp.(*wrapper)
+	if e := w.pause(); e != nil {
+		return Task{}, e
+	}
+	return *w.task, nil
+}
+
+func (t *TunnyRunner) ResumeTask(id string) (Task, error) {
+	temp, ok := t.store.Get(id)
+	if !ok {
+		return Task{}, ErrorNotFound
+	}
+	w := temp.(*wrapper)
+	w.resume()
+	return *w.task, nil
+}
+
 func (t *TunnyRunner) RemoveTask(id string) error {
 	temp, ok := t.store.Get(id)
 	if !ok {
@@ -137,6 +159,7 @@ func (t *TunnyRunner) Status() (string, types.SM, error) {
 	total := 0
 	pending := 0
 	running := 0
+	paused := 0
 	done := 0
 	err := 0
 	canceled := 0
@@ -147,6 +170,8 @@ func (t *TunnyRunner) Status() (string, types.SM, error) {
 			pending++
 		case Running:
 			running++
+		case Paused:
+			paused++
 		case Done:
 			done++
 		case Error:
@@ -160,6 +185,7 @@ func (t *TunnyRunner) Status() (string, types.SM, error) {
 		i18n.T("stat.task.total"):    fmt.Sprintf("%d", total),
 		i18n.T("stat.task.pending"):  fmt.Sprintf("%d", pending),
 		i18n.T("stat.task.running"):  fmt.Sprintf("%d", running),
+		i18n.T("stat.task.paused"):   fmt.Sprintf("%d", paused),
 		i18n.T("stat.task.done"):     fmt.Sprintf("%d", done),
 		i18n.T("stat.task.error"):    fmt.Sprintf("%d", err),
 		i18n.T("stat.task.canceled"): fmt.Sprintf("%d", canceled),
@@ -172,6 +198,10 @@ type wrapper struct {
 	canceled bool
 	mux      *sync.Mutex
 	done     chan struct{}
+
+	// resumed is not nil while the task is paused, it's closed when resumed
+	resumed chan struct{}
+	started bool
 }
 
 func (w *wrapper) Progress(loaded int64, abs bool) {
@@ -206,6 +236,22 @@ func (w *wrapper) Canceled() bool {
 	return w.canceled
 }
 
+func (w *wrapper) WaitIfPaused() error {
+	w.mux.Lock()
+	resumed := w.resumed
+	w.mux.Unlock()
+	if resumed != nil {
+		select {
+		case <-resumed:
+		case <-w.done:
+		}
+	}
+	if w.canceled {
+		return ErrorCanceled
+	}
+	return nil
+}
+
 func (w *wrapper) Deadline() (deadline time.Time, ok bool) {
 	return
 }
@@ -221,10 +267,44 @@ func (w *wrapper) Err() error {
 	return nil
 }
 
-func (w *wrapper) Value(interface{}) interface{} {
+func (w *wrapper) Value(key interface{}) interface{} {
+	if key == (taskIDKey{}) {
+		return w.task.Id
+	}
+	return nil
+}
+
+func (w *wrapper) pause() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.canceled || w.task.Finished() {
+		return ErrorFinished
+	}
+	if w.resumed == nil {
+		w.resumed = make(chan struct{})
+		w.task.Status = Paused
+		w.task.UpdatedAt = time.Now()
+	}
 	return nil
 }
 
+func (w *wrapper) resume() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.resumed == nil {
+		return
+	}
+	close(w.resumed)
+	w.resumed = nil
+	if !w.canceled {
+		w.task.Status = Pending
+		if w.started {
+			w.task.Status = Running
+		}
+		w.task.UpdatedAt = time.Now()
+	}
+}
+
 func (w *wrapper) cancel() {
 	w.mux.Lock()
 	defer w.mux.Unlock()
@@ -241,8 +321,13 @@ func executor(arg interface{}) interface{} {
 	if w.Canceled() {
 		return nil
 	}
-	w.task.Status = Running
+	w.mux.Lock()
+	w.started = true
+	if w.resumed == nil {
+		w.task.Status = Running
+	}
 	w.task.UpdatedAt = time.Now()
+	w.mux.Unlock()
 	r, e := w.runnable(w)
 	if e != nil {
 		if e == ErrorCanceled || errors.Is(e, context.Canceled) {
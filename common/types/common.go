@@ -10,6 +10,9 @@ type TaskCtx interface {
 	Progress(loaded int64, abs bool)
 	Total(total int64, abs bool)
 	Canceled() bool
+	// WaitIfPaused blocks while the task is paused,
+	// it returns an error if the task is canceled before or while waiting
+	WaitIfPaused() error
 }
 
 type IDisposable interface {
@@ -62,6 +62,105 @@ func (DriveCache) TableName() string {
 	return "drive_cache"
 }
 
+// DriveFile is a file or directory of the drives storing the contents in the database
+type DriveFile struct {
+	Drive   string `gorm:"COLUMN:drive;PRIMARY_KEY;NOT NULL;TYPE:VARCHAR;SIZE:255"`
+	Path    string `gorm:"COLUMN:path;PRIMARY_KEY;NOT NULL;TYPE:VARCHAR;SIZE:4096"`
+	Parent  string `gorm:"COLUMN:parent;NOT NULL;TYPE:VARCHAR;SIZE:4096;INDEX:idx_drive_files_parent"`
+	Dir     bool   `gorm:"COLUMN:dir;NOT NULL;TYPE:INTEGER"`
+	Size    int64  `gorm:"COLUMN:size;NOT NULL;TYPE:INTEGER"`
+	ModTime int64  `gorm:"COLUMN:mod_time;NOT NULL;TYPE:INTEGER"`
+	// BlobId is the id of the chunks of the content, it's changed every time the content is written
+	BlobId string `gorm:"COLUMN:blob_id;NOT NULL;TYPE:VARCHAR;SIZE:36"`
+}
+
+func (DriveFile) TableName() string {
+	return "drive_files"
+}
+
+// DriveFileChunk is a chunk of the content of DriveFile
+type DriveFileChunk struct {
+	Drive  string `gorm:"COLUMN:drive;PRIMARY_KEY;NOT NULL;TYPE:VARCHAR;SIZE:255"`
+	BlobId string `gorm:"COLUMN:blob_id;PRIMARY_KEY;NOT NULL;TYPE:VARCHAR;SIZE:36"`
+	Seq    *int   `gorm:"COLUMN:seq;PRIMARY_KEY;NOT NULL;TYPE:INTEGER"`
+	Data   []byte `gorm:"COLUMN:data;NOT NULL;TYPE:BLOB"`
+}
+
+func (DriveFileChunk) TableName() string {
+	return "drive_file_chunks"
+}
+
+type PathLock struct {
+	Key   string `gorm:"COLUMN:lock_key;PRIMARY_KEY;NOT NULL;TYPE:VARCHAR;SIZE:4096"`
+	Owner string `gorm:"COLUMN:owner;NOT NULL;TYPE:VARCHAR;SIZE:64"`
+	// ExpiresAt is the expiration time in milliseconds
+	ExpiresAt int64 `gorm:"COLUMN:expires_at;NOT NULL;TYPE:INTEGER"`
+}
+
+func (PathLock) TableName() string {
+	return "path_locks"
+}
+
+// Share is a public link to an entry, which is accessed with the permissions of the owner without logging in
+type Share struct {
+	Id    string `gorm:"COLUMN:id;PRIMARY_KEY;NOT NULL;TYPE:VARCHAR;SIZE:36" json:"id"`
+	Path  string `gorm:"COLUMN:path;NOT NULL;TYPE:VARCHAR;SIZE:4096" json:"path"`
+	Owner string `gorm:"COLUMN:owner;NOT NULL;TYPE:VARCHAR;SIZE:32;INDEX:idx_shares_owner" json:"owner"`
+	// Password is the bcrypt hash of the password, empty means no password is required
+	Password string `gorm:"COLUMN:password;NOT NULL;TYPE:VARCHAR;SIZE:64" json:"-"`
+	// ExpiresAt is the expiration time in milliseconds, 0 means never
+	ExpiresAt int64 `gorm:"COLUMN:expires_at;NOT NULL;TYPE:INTEGER" json:"expires_at"`
+	// MaxDownloads is the maximum number of downloads, 0 means unlimited
+	MaxDownloads int64 `gorm:"COLUMN:max_downloads;NOT NULL;TYPE:INTEGER" json:"max_downloads"`
+	Downloads    int64 `gorm:"COLUMN:downloads;NOT NULL;TYPE:INTEGER" json:"downloads"`
+	CreatedAt    int64 `gorm:"COLUMN:created_at;NOT NULL;TYPE:INTEGER" json:"created_at"`
+	// Upload is true for the file requests, whose visitors can only upload files to the shared directory
+	Upload bool `gorm:"COLUMN:upload;NOT NULL;TYPE:INTEGER;DEFAULT:0" json:"upload"`
+	// MaxFileSize is the maximum size of the uploaded files, 0 means unlimited
+	MaxFileSize int64 `gorm:"COLUMN:max_file_size;NOT NULL;TYPE:INTEGER;DEFAULT:0" json:"max_file_size"`
+	// Extensions are the allowed extensions of the uploaded files like 'pdf,docx', empty means any
+	Extensions string `gorm:"COLUMN:extensions;NOT NULL;TYPE:VARCHAR;SIZE:255;DEFAULT:''" json:"extensions"`
+}
+
+func (Share) TableName() string {
+	return "shares"
+}
+
+func (s Share) HasPassword() bool {
+	return s.Password != ""
+}
+
+// AllowsFile tells whether the file of name and size can be uploaded to the file request
+func (s Share) AllowsFile(name string, size int64) bool {
+	if s.MaxFileSize > 0 && size > s.MaxFileSize {
+		return false
+	}
+	if s.Extensions == "" {
+		return true
+	}
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return false
+	}
+	ext := strings.ToLower(name[i+1:])
+	for _, allowed := range strings.Split(s.Extensions, ",") {
+		if strings.ToLower(strings.TrimPrefix(strings.TrimSpace(allowed), ".")) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired tells whether the share is expired at now in milliseconds
+func (s Share) IsExpired(now int64) bool {
+	return s.ExpiresAt > 0 && now >= s.ExpiresAt
+}
+
+// DownloadLimitReached tells whether the share can not be downloaded anymore
+func (s Share) DownloadLimitReached() bool {
+	return s.MaxDownloads > 0 && s.Downloads >= s.MaxDownloads
+}
+
 type Permission uint8
 
 func (p Permission) CanRead() bool {
@@ -123,3 +222,20 @@ func (p PathPermission) IsAccept() bool {
 func (p PathPermission) IsReject() bool {
 	return p.Policy == PolicyReject
 }
+
+// SearchEntry is an entry in the search index, the name and the text content are indexed in the full-text table by its id
+type SearchEntry struct {
+	Id     int64     `gorm:"COLUMN:id;PRIMARY_KEY;AUTO_INCREMENT" json:"-"`
+	Path   string    `gorm:"COLUMN:path;NOT NULL;TYPE:VARCHAR;SIZE:4096;UNIQUE_INDEX:idx_search_entries_path" json:"path"`
+	Parent string    `gorm:"COLUMN:parent;NOT NULL;TYPE:VARCHAR;SIZE:4096;INDEX:idx_search_entries_parent" json:"-"`
+	Name   string    `gorm:"COLUMN:name;NOT NULL;TYPE:VARCHAR;SIZE:255" json:"name"`
+	Type   EntryType `gorm:"COLUMN:type;NOT NULL;TYPE:VARCHAR;SIZE:8" json:"type"`
+	// Ext is the lower case extension of the file without the dot
+	Ext     string `gorm:"COLUMN:ext;NOT NULL;TYPE:VARCHAR;SIZE:32" json:"-"`
+	Size    int64  `gorm:"COLUMN:size;NOT NULL;TYPE:INTEGER" json:"size"`
+	ModTime int64  `gorm:"COLUMN:mod_time;NOT NULL;TYPE:INTEGER" json:"mod_time"`
+}
+
+func (SearchEntry) TableName() string {
+	return "search_entries"
+}
@@ -31,8 +31,14 @@ type ContentURL struct {
 	URL    string
 	Header SM
 	Proxy  bool
+	// ProxyRange indicates that Range requests should be proxied,
+	// while full downloads are still redirected to URL.
+	ProxyRange bool
 }
 
+// IContent is the readable content of a file.
+// Name, Size and ModTime are metadata, they must be available without reading the content.
+// GetReader and GetURL are the only ways to access the data.
 type IContent interface {
 	Name() string
 	Size() int64
@@ -42,6 +48,112 @@ type IContent interface {
 	GetURL(context.Context) (*ContentURL, error)
 }
 
+// IPublisher can be implemented by drives which can replace a directory with a staged one,
+// so that readers never see a half-written state.
+type IPublisher interface {
+	// Publish replaces target with the staging directory, the staging directory is consumed
+	Publish(ctx TaskCtx, staging, target string) (IEntry, error)
+}
+
+// IDirSignature can be implemented by drives which can tell whether a directory subtree changed cheaply
+type IDirSignature interface {
+	// DirSignature returns a string which changes when the directory or its descendants changed
+	DirSignature(ctx context.Context, path string) (string, error)
+}
+
+// ITailer can be implemented by drives which can read the tail of text files, like 'tail -f'
+type ITailer interface {
+	// GetTail returns at most the last n lines of the file, and the offset of the end of the returned content
+	GetTail(ctx context.Context, path string, n int) (data []byte, offset int64, e error)
+	// Follow calls fn with the content appended to the file after offset, until ctx is done or fn returns an error.
+	// If the file is truncated or replaced, fn is called with reset = true, and the file is read from the start.
+	Follow(ctx context.Context, path string, offset int64, fn func(data []byte, reset bool) error) error
+}
+
+// IRangeReader can be implemented by IContent which supports reading a part of the content
+type IRangeReader interface {
+	// GetRangeReader returns the reader of length bytes from offset, length < 0 means to the end
+	GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error)
+}
+
+// IStableID can be implemented by entries which have an identifier that survives renames and moves
+type IStableID interface {
+	// StableID returns the opaque identifier of the entry, empty if it's not available
+	StableID() string
+}
+
+// IIDResolver can be implemented by drives which can find entries by their stable IDs
+type IIDResolver interface {
+	ResolveID(ctx context.Context, id string) (IEntry, error)
+}
+
+// IAsyncOp is the handle of an operation running on the backend
+type IAsyncOp interface {
+	// Poll checks the state of the operation, progress is in [0, 1]
+	Poll(ctx context.Context) (done bool, progress float64, e error)
+	// Result returns the result entry, it's only available after the operation is done
+	Result(ctx context.Context) (IEntry, error)
+}
+
+// IAsyncCopier can be implemented by drives which copy entries on the backend asynchronously,
+// the handle returned by CopyAsync is polled until the copy completes.
+// It's used in preference to IDrive.Copy when copying between entries of the same drive.
+type IAsyncCopier interface {
+	CopyAsync(ctx context.Context, from IEntry, to string, override bool) (IAsyncOp, error)
+}
+
+// IContentHash can be implemented by IContent which knows the hash of its content without reading it
+type IContentHash interface {
+	// ContentHash returns the name of the hash algorithm and the hex encoded hash
+	ContentHash(ctx context.Context) (algorithm string, hash string, e error)
+}
+
+// IPropIndex can be implemented by drives which can find entries by props without walking the tree
+type IPropIndex interface {
+	// FindByProp returns the entries under root which prop key matches value
+	FindByProp(ctx context.Context, root, key, value string) ([]IEntry, error)
+}
+
+// ISharer can be implemented by drives which can create public links of the entries on the backend
+type ISharer interface {
+	// Share creates a public link of the entry
+	Share(ctx context.Context, path string, options ShareOptions) (*ShareLink, error)
+}
+
+// ISpaceReporter can be implemented by drives which know the space of their storage
+type ISpaceReporter interface {
+	// Space returns the free bytes and the total bytes of the storage of the path
+	Space(ctx context.Context, path string) (free int64, total int64, e error)
+}
+
+type ShareOptions struct {
+	// Password protects the link if not empty
+	Password string
+	// ExpiresAt is the expiration time of the link in milliseconds, 0 means never
+	ExpiresAt int64
+}
+
+type ShareLink struct {
+	URL string `json:"url"`
+	// ExpiresAt is the expiration time reported by the backend in milliseconds, 0 means never
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+type ArchiveMember struct {
+	// Name is the path of the member in the archive, separated by '/'
+	Name    string
+	Size    int64
+	ModTime int64
+	IsDir   bool
+}
+
+// IArchive is an archive which members can be read without extracting the whole archive.
+// IContent may implement it natively, otherwise drive_util.GetArchive provides a generic implementation.
+type IArchive interface {
+	ListMembers(ctx context.Context) ([]ArchiveMember, error)
+	OpenMember(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
 type IEntry interface {
 	Path() string
 	Type() EntryType
@@ -63,11 +175,15 @@ type DriveMeta struct {
 
 type IDrive interface {
 	Meta(ctx context.Context) DriveMeta
+	// Get returns the entry of the path.
+	// Size and modTime should be populated by cheap metadata calls(HEAD, PROPFIND, stat...),
+	// the content must not be opened.
 	Get(ctx context.Context, path string) (IEntry, error)
 	Save(ctx TaskCtx, path string, size int64, override bool, reader io.Reader) (IEntry, error)
 	MakeDir(ctx context.Context, path string) (IEntry, error)
 	Copy(ctx TaskCtx, from IEntry, to string, override bool) (IEntry, error)
 	Move(ctx TaskCtx, from IEntry, to string, override bool) (IEntry, error)
+	// List returns the children of the path, the same as Get, the content of children must not be opened.
 	List(ctx context.Context, path string) ([]IEntry, error)
 	Delete(ctx TaskCtx, path string) error
 
@@ -80,6 +196,7 @@ const (
 	LocalChunkProvider = "localChunk"
 	S3Provider         = "s3"
 	OneDriveProvider   = "onedrive"
+	QiniuProvider      = "qiniu"
 )
 
 const (
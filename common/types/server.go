@@ -25,3 +25,49 @@ type TokenStore interface {
 	// Revoke a token, return value is not nil only when an error occurred
 	Revoke(token string) error
 }
+
+// IdempotencyStore records the results of mutating operations by their idempotency keys,
+// so that a retried request returns the prior result instead of being executed again
+type IdempotencyStore interface {
+	// Begin claims the key for the operation identified by fingerprint.
+	// If the operation of this key has completed, its result is returned with done = true
+	Begin(key, fingerprint string) (result interface{}, done bool, e error)
+	// Complete records the result of the operation claimed by key
+	Complete(key string, result interface{}) error
+	// Abort releases the key, the operation can be retried with this key
+	Abort(key string) error
+}
+
+const (
+	AuditBegin = "begin"
+	AuditEnd   = "end"
+
+	AuditOK     = "ok"
+	AuditFailed = "failed"
+)
+
+// AuditRecord is a record of the audit log.
+// Each mutation writes a record of AuditBegin before it's executed, and a record of AuditEnd after it's done,
+// the two records share the same ID.
+type AuditRecord struct {
+	ID    string `json:"id"`
+	Phase string `json:"phase"`
+	// Time is the unix timestamp in milliseconds
+	Time int64 `json:"time"`
+	// Caller is the username of the caller, empty if it's anonymous
+	Caller string `json:"caller"`
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	// To is the destination of copying or moving
+	To string `json:"to,omitempty"`
+	// Result is AuditOK or AuditFailed, only in the records of AuditEnd
+	Result string `json:"result,omitempty"`
+	// Error is the error of the failed operation
+	Error string `json:"error,omitempty"`
+}
+
+// AuditSink is where the audit records are written to
+type AuditSink interface {
+	// Write appends the record durably, the record must be persisted when it returns nil
+	Write(r AuditRecord) error
+}
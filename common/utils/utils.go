@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"go-drive/common/types"
 	"math"
@@ -54,6 +55,12 @@ func CleanPath(path string) string {
 	return path
 }
 
+// NormalizePath converts the backslashes to slashes, then cleans the path as CleanPath does,
+// so that the paths with mixed separators or trailing slashes are handled uniformly
+func NormalizePath(path string) string {
+	return CleanPath(strings.ReplaceAll(path, "\\", "/"))
+}
+
 func PathBase(path string) string {
 	base := path2.Base(path)
 	if base == "/" || base == "." {
@@ -173,6 +180,14 @@ func CopyMap(m types.M) types.M {
 	return newMap
 }
 
+func CopySM(m types.SM) types.SM {
+	newMap := make(types.SM, len(m))
+	for k, v := range m {
+		newMap[k] = v
+	}
+	return newMap
+}
+
 func TimeTick(fn func(), d time.Duration) func() {
 	ticker := time.NewTicker(d)
 	stopped := make(chan bool)
@@ -180,7 +195,7 @@ func TimeTick(fn func(), d time.Duration) func() {
 		for {
 			select {
 			case <-stopped:
-				break
+				return
 			case <-ticker.C:
 				fn()
 			}
@@ -208,6 +223,27 @@ func FormatBytes(bytes uint64, decimals int) string {
 	return fmt.Sprintf("%.2f %s", float64(bytes)/math.Pow(1024, i), bytesSizes[int(i)])
 }
 
+var errInvalidBytes = errors.New("invalid bytes size")
+
+// ParseBytes parses sizes like '1024', '512K', '1.5 GB', units are powers of 1024 as FormatBytes
+func ParseBytes(s string) (uint64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	multiplier := float64(1)
+	for i := len(bytesSizes) - 1; i > 0; i-- {
+		if strings.HasSuffix(s, bytesSizes[i]) {
+			multiplier = math.Pow(1024, float64(i))
+			s = s[:len(s)-len(bytesSizes[i])]
+			break
+		}
+	}
+	v, e := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if e != nil || v < 0 {
+		return 0, errInvalidBytes
+	}
+	return uint64(v * multiplier), nil
+}
+
 func BuildURL(pattern string, variables ...string) string {
 	if len(variables) == 0 {
 		return pattern
@@ -38,6 +38,26 @@ func TestPathParent(t *testing.T) {
 	}
 }
 
+func TestNormalizePath(t *testing.T) {
+	cases := map[string]string{
+		"":             "",
+		"/":            "",
+		"\\":           "",
+		"a/b/":         "a/b",
+		"a\\b\\":       "a/b",
+		"\\a/b\\c":     "a/b/c",
+		"a//b\\\\c":    "a/b/c",
+		"C:\\a\\b.txt": "C:/a/b.txt",
+		"a\\..\\..\\b": "b",
+		"./a/./b\\.\\": "a/b",
+	}
+	for path, want := range cases {
+		if got := NormalizePath(path); got != want {
+			t.Errorf("'%s': expect '%s', but is '%s'", path, want, got)
+		}
+	}
+}
+
 func TestPathParentTree(t *testing.T) {
 	path := "a/b/c"
 
@@ -72,6 +92,26 @@ func TestFormatBytes(t *testing.T) {
 	}
 }
 
+func TestParseBytes(t *testing.T) {
+	cases := map[string]uint64{
+		"1024":   1024,
+		"512K":   512 * 1024,
+		"1.5 GB": 1536 * 1024 * 1024,
+		"2MiB":   2 * 1024 * 1024,
+		"10b":    10,
+	}
+	for s, expected := range cases {
+		if v, e := ParseBytes(s); e != nil || v != expected {
+			t.Errorf("'%s': expect %d, but it's %d, %v", s, expected, v, e)
+		}
+	}
+	for _, s := range []string{"", "G", "-1", "1X"} {
+		if _, e := ParseBytes(s); e == nil {
+			t.Errorf("'%s': expect error", s)
+		}
+	}
+}
+
 func TestBuildURL(t *testing.T) {
 	if v := BuildURL("/a/{}/d/{}", "b/c", "e"); v != "/a/b/c/d/e" {
 		t.Errorf("expect '%s', but it's '%s'", "/a/b/c/d/e", v)
@@ -66,6 +66,74 @@ CREATE TABLE drive_cache
     PRIMARY KEY (drive, path, depth, type)
 );
 
+CREATE TABLE drive_files
+(
+    drive    VARCHAR,
+    path     VARCHAR,
+    parent   VARCHAR NOT NULL,
+    dir      INTEGER NOT NULL,
+    size     INTEGER NOT NULL,
+    mod_time INTEGER NOT NULL,
+    blob_id  VARCHAR NOT NULL,
+    PRIMARY KEY (drive, path)
+);
+
+CREATE INDEX idx_drive_files_parent ON drive_files (parent);
+
+CREATE TABLE drive_file_chunks
+(
+    drive   VARCHAR,
+    blob_id VARCHAR,
+    seq     INTEGER,
+    data    BLOB NOT NULL,
+    PRIMARY KEY (drive, blob_id, seq)
+);
+
+CREATE TABLE path_locks
+(
+    lock_key   VARCHAR
+        PRIMARY KEY,
+    owner      VARCHAR NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+
+CREATE TABLE shares
+(
+    id            VARCHAR
+        PRIMARY KEY,
+    path          VARCHAR NOT NULL,
+    owner         VARCHAR NOT NULL,
+    password      VARCHAR NOT NULL,
+    expires_at    INTEGER NOT NULL,
+    max_downloads INTEGER NOT NULL,
+    downloads     INTEGER NOT NULL,
+    created_at    INTEGER NOT NULL,
+    upload        INTEGER NOT NULL DEFAULT 0,
+    max_file_size INTEGER NOT NULL DEFAULT 0,
+    extensions    VARCHAR NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_shares_owner ON shares (owner);
+
+CREATE TABLE search_entries
+(
+    id       INTEGER
+        PRIMARY KEY AUTOINCREMENT,
+    path     VARCHAR NOT NULL,
+    parent   VARCHAR NOT NULL,
+    name     VARCHAR NOT NULL,
+    type     VARCHAR NOT NULL,
+    ext      VARCHAR NOT NULL,
+    size     INTEGER NOT NULL,
+    mod_time INTEGER NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_search_entries_path ON search_entries (path);
+CREATE INDEX idx_search_entries_parent ON search_entries (parent);
+
+-- the full-text index of search_entries by their ids, it's also created at runtime by NewSearchIndexDAO
+CREATE VIRTUAL TABLE search_fts USING fts4(name, content, tokenize=unicode61);
+
 -- Init data
 
 INSERT INTO users(username, password)
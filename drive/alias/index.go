@@ -0,0 +1,404 @@
+package alias
+
+import (
+	"bufio"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	path2 "path"
+	"sort"
+	"strings"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "alias",
+		DisplayName: i18n.T("drive.alias.name"),
+		README:      i18n.T("drive.alias.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "mappings", Label: i18n.T("drive.alias.form.mappings.label"), Type: "textarea", Required: true, Description: i18n.T("drive.alias.form.mappings.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewAliasDrive},
+	})
+}
+
+// AliasDrive is a virtual tree, whose nodes are mapped to the paths of other mounts.
+// The directories above the nodes are virtual and read-only.
+type AliasDrive struct {
+	root func() types.IDrive
+	// mappings maps the paths of the nodes to the paths in the root drive
+	mappings map[string]string
+	// dirs is the children of the virtual directories
+	dirs map[string][]string
+	// guard stops the drive being accessed through itself
+	guard *drive_util.RecursionGuard
+}
+
+func NewAliasDrive(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	if driveUtils.Root == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	mappings, e := parseMappings(config["mappings"])
+	if e != nil {
+		return nil, e
+	}
+	d := &AliasDrive{root: driveUtils.Root, mappings: mappings, dirs: make(map[string][]string),
+		guard: drive_util.NewRecursionGuard(i18n.T("drive.alias.recursive"))}
+	added := make(map[string]bool)
+	for node := range mappings {
+		for p := node; !utils.IsRootPath(p) && !added[p]; p = utils.PathParent(p) {
+			parent := utils.PathParent(p)
+			d.dirs[parent] = append(d.dirs[parent], p)
+			added[p] = true
+		}
+	}
+	for _, children := range d.dirs {
+		sort.Strings(children)
+	}
+	return d, nil
+}
+
+// parseMappings parses the lines like 'media/movies -> s3/movies',
+// the target can also be like 's3:/movies', which is the path 'movies' in the mount 's3'
+func parseMappings(s string) (map[string]string, error) {
+	mappings := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "->", 2)
+		if len(kv) != 2 {
+			return nil, err.NewBadRequestError(i18n.T("drive.alias.invalid_mapping", line))
+		}
+		node, target := utils.CleanPath(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+		if i := strings.Index(target, ":"); i > 0 && !strings.Contains(target[:i], "/") {
+			target = target[:i] + "/" + target[i+1:]
+		}
+		target = utils.CleanPath(target)
+		if utils.IsRootPath(node) || utils.IsRootPath(target) {
+			return nil, err.NewBadRequestError(i18n.T("drive.alias.invalid_mapping", line))
+		}
+		if _, ok := mappings[node]; ok {
+			return nil, err.NewBadRequestError(i18n.T("drive.alias.duplicate_mapping", node))
+		}
+		mappings[node] = target
+	}
+	if len(mappings) == 0 {
+		return nil, err.NewBadRequestError(i18n.T("drive.alias.no_mappings"))
+	}
+	// the nodes can't be nested, as the entries of the nodes are in the other mounts
+	for node := range mappings {
+		for p := utils.PathParent(node); !utils.IsRootPath(p); p = utils.PathParent(p) {
+			if _, ok := mappings[p]; ok {
+				return nil, err.NewBadRequestError(i18n.T("drive.alias.nested_mapping", node, p))
+			}
+		}
+	}
+	return mappings, nil
+}
+
+// resolve returns the path in the root drive of path, and whether path is a node.
+// false is returned if path is not in any node, it may be a virtual directory.
+func (d *AliasDrive) resolve(path string) (string, bool, bool) {
+	for p := path; !utils.IsRootPath(p); p = utils.PathParent(p) {
+		if target, ok := d.mappings[p]; ok {
+			return path2.Join(target, strings.TrimPrefix(path[len(p):], "/")), p == path, true
+		}
+	}
+	return "", false, false
+}
+
+// resolveWritable returns the path in the root drive of path, the nodes and the virtual directories can't be written
+func (d *AliasDrive) resolveWritable(path string) (string, error) {
+	target, isNode, ok := d.resolve(path)
+	if !ok || isNode {
+		return "", err.NewNotAllowedError()
+	}
+	return target, nil
+}
+
+func (d *AliasDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *AliasDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	target, _, ok := d.resolve(path)
+	if !ok {
+		if _, isDir := d.dirs[path]; isDir {
+			return &virtualDir{d: d, path: path}, nil
+		}
+		return nil, err.NewNotFoundError()
+	}
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Get(ctx, target)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *AliasDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	target, e := d.resolveWritable(path)
+	if e != nil {
+		return nil, e
+	}
+	ctx, e = d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Save(ctx, target, size, override, reader)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *AliasDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	target, e := d.resolveWritable(path)
+	if e != nil {
+		return nil, e
+	}
+	ctx, e = d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().MakeDir(ctx, target)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *AliasDrive) isSelf(e types.IEntry) bool {
+	if ae, ok := e.(*aliasEntry); ok {
+		return ae.d == d
+	}
+	return false
+}
+
+func (d *AliasDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	target, e := d.resolveWritable(to)
+	if e != nil {
+		return nil, e
+	}
+	ctx, e = d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Copy(ctx, from.(*aliasEntry).entry, target, override)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(to, entry), nil
+}
+
+func (d *AliasDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	if _, e := d.resolveWritable(from.Path()); e != nil {
+		return nil, e
+	}
+	target, e := d.resolveWritable(to)
+	if e != nil {
+		return nil, e
+	}
+	ctx, e = d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Move(ctx, from.(*aliasEntry).entry, target, override)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(to, entry), nil
+}
+
+func (d *AliasDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	target, _, ok := d.resolve(path)
+	if !ok {
+		children, isDir := d.dirs[path]
+		if !isDir {
+			return nil, err.NewNotFoundError()
+		}
+		entries := make([]types.IEntry, 0, len(children))
+		for _, child := range children {
+			if _, isNode := d.mappings[child]; isNode {
+				if entry, e := d.Get(ctx, child); e == nil {
+					entries = append(entries, entry)
+				}
+				continue
+			}
+			entries = append(entries, &virtualDir{d: d, path: child})
+		}
+		return entries, nil
+	}
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entries, e := d.root().List(ctx, target)
+	if e != nil {
+		return nil, e
+	}
+	r := make([]types.IEntry, 0, len(entries))
+	for _, entry := range entries {
+		r = append(r, d.newEntry(path2.Join(path, utils.PathBase(entry.Path())), entry))
+	}
+	return r, nil
+}
+
+func (d *AliasDrive) Delete(ctx types.TaskCtx, path string) error {
+	target, e := d.resolveWritable(path)
+	if e != nil {
+		return e
+	}
+	ctx, e = d.guard.TaskContext(ctx)
+	if e != nil {
+		return e
+	}
+	return d.root().Delete(ctx, target)
+}
+
+func (d *AliasDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if _, e := d.resolveWritable(path); e != nil {
+		return nil, e
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *AliasDrive) newEntry(path string, entry types.IEntry) *aliasEntry {
+	return &aliasEntry{d: d, path: path, entry: entry}
+}
+
+// aliasEntry is the entry of the other mount in a node
+type aliasEntry struct {
+	d     *AliasDrive
+	path  string
+	entry types.IEntry
+}
+
+func (e *aliasEntry) Path() string {
+	return e.path
+}
+
+func (e *aliasEntry) Type() types.EntryType {
+	return e.entry.Type()
+}
+
+func (e *aliasEntry) Size() int64 {
+	return e.entry.Size()
+}
+
+func (e *aliasEntry) Meta() types.EntryMeta {
+	m := e.entry.Meta()
+	if _, isNode, _ := e.d.resolve(e.path); isNode {
+		// the nodes can be written in, but can't be renamed or deleted
+		m.CanWrite = m.CanWrite && e.Type().IsDir()
+	}
+	return m
+}
+
+func (e *aliasEntry) ModTime() int64 {
+	return e.entry.ModTime()
+}
+
+func (e *aliasEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *aliasEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *aliasEntry) GetIEntry() types.IEntry {
+	return e.entry
+}
+
+func (e *aliasEntry) content() (types.IContent, error) {
+	c, ok := e.entry.(types.IContent)
+	if !ok || !e.Type().IsFile() {
+		return nil, err.NewNotAllowedError()
+	}
+	return c, nil
+}
+
+func (e *aliasEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	c, ee := e.content()
+	if ee != nil {
+		return nil, ee
+	}
+	return c.GetReader(ctx)
+}
+
+func (e *aliasEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	c, ee := e.content()
+	if ee != nil {
+		return nil, ee
+	}
+	return c.GetURL(ctx)
+}
+
+func (e *aliasEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	c, ee := e.content()
+	if ee != nil {
+		return nil, ee
+	}
+	return drive_util.GetIContentRangeReader(ctx, c, offset, length)
+}
+
+// virtualDir is the directory above the nodes
+type virtualDir struct {
+	d    *AliasDrive
+	path string
+}
+
+func (v *virtualDir) Path() string {
+	return v.path
+}
+
+func (v *virtualDir) Type() types.EntryType {
+	return types.TypeDir
+}
+
+func (v *virtualDir) Size() int64 {
+	return -1
+}
+
+func (v *virtualDir) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: false}
+}
+
+func (v *virtualDir) ModTime() int64 {
+	return -1
+}
+
+func (v *virtualDir) Drive() types.IDrive {
+	return v.d
+}
+
+func (v *virtualDir) Name() string {
+	return utils.PathBase(v.path)
+}
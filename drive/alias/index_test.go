@@ -0,0 +1,93 @@
+package alias
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/drive/memory"
+	"strings"
+	"testing"
+)
+
+func newTestAlias(t *testing.T, mappings string) (*AliasDrive, *memory.MemoryDrive) {
+	root := memory.New(1024)
+	for _, p := range []string{"s3", "s3/movies", "disk", "disk/music"} {
+		if _, e := root.MakeDir(context.Background(), p); e != nil {
+			t.Fatal(e)
+		}
+	}
+	for _, p := range []string{"s3/movies/a.mkv", "disk/readme.txt"} {
+		if _, e := root.Save(task.DummyContext(), p, 0, false, strings.NewReader("")); e != nil {
+			t.Fatal(e)
+		}
+	}
+	d, e := NewAliasDrive(context.Background(), drive_util.DriveConfig{"mappings": mappings},
+		drive_util.DriveUtils{Root: func() types.IDrive { return root }})
+	if e != nil {
+		t.Fatal(e)
+	}
+	return d.(*AliasDrive), root
+}
+
+func paths(entries []types.IEntry) string {
+	r := make([]string, 0, len(entries))
+	for _, e := range entries {
+		r = append(r, e.Path())
+	}
+	return strings.Join(r, ",")
+}
+
+func TestAliasTree(t *testing.T) {
+	d, root := newTestAlias(t, "# the media\n/media/movies -> s3:/movies\nmedia/music -> disk/music\nreadme.txt -> disk/readme.txt\n")
+	ctx := context.Background()
+
+	entries, e := d.List(ctx, "")
+	if e != nil || paths(entries) != "media,readme.txt" {
+		t.Errorf("unexpected root: %s, %v", paths(entries), e)
+	}
+	entries, e = d.List(ctx, "media")
+	if e != nil || paths(entries) != "media/movies,media/music" {
+		t.Errorf("unexpected virtual directory: %s, %v", paths(entries), e)
+	}
+	entries, e = d.List(ctx, "media/movies")
+	if e != nil || paths(entries) != "media/movies/a.mkv" {
+		t.Errorf("unexpected node: %s, %v", paths(entries), e)
+	}
+	if _, e := d.Get(ctx, "other"); !err.IsNotFoundError(e) {
+		t.Errorf("expect NotFoundError, but it's %v", e)
+	}
+
+	if _, e := d.Save(task.DummyContext(), "media/music/b.mp3", 0, false, strings.NewReader("")); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := root.Get(ctx, "disk/music/b.mp3"); e != nil {
+		t.Error("expect the file saved in the target")
+	}
+	for _, p := range []string{"media/x.txt", "media/music", "readme.txt"} {
+		if _, e := d.Save(task.DummyContext(), p, 0, true, strings.NewReader("")); !err.IsNotAllowedError(e) {
+			t.Errorf("expect NotAllowedError of saving %s, but it's %v", p, e)
+		}
+	}
+	if e := d.Delete(task.DummyContext(), "media/movies"); !err.IsNotAllowedError(e) {
+		t.Errorf("expect NotAllowedError of deleting the node, but it's %v", e)
+	}
+}
+
+func TestAliasMappings(t *testing.T) {
+	for _, m := range []string{"", "a", "a -> ", "a -> b\na -> c", "a -> b\na/b -> c"} {
+		if _, e := parseMappings(m); e == nil {
+			t.Errorf("expect error of the mappings %q", m)
+		}
+	}
+	d, _ := newTestAlias(t, "music -> disk/music")
+	// ctx is resolving the mappings through the drive, like the drive mounted in disk/music
+	ctx, e := d.guard.Context(context.Background())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := d.Get(ctx, "music"); !err.IsNotAllowedError(e) {
+		t.Errorf("expect NotAllowedError of the recursive mapping, but it's %v", e)
+	}
+}
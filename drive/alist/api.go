@@ -0,0 +1,126 @@
+package alist
+
+import (
+	"encoding/json"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"net/http"
+	"strings"
+)
+
+// apiResult is the envelope of the responses, Alist responds errors with the status 200
+type apiResult struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type loginResult struct {
+	Token string `json:"token"`
+}
+
+type listRequest struct {
+	Path     string `json:"path"`
+	Password string `json:"password"`
+	Page     int    `json:"page"`
+	PerPage  int    `json:"per_page"`
+	Refresh  bool   `json:"refresh"`
+}
+
+type listResult struct {
+	Content []object `json:"content"`
+	Total   int64    `json:"total"`
+}
+
+type getRequest struct {
+	Path     string `json:"path"`
+	Password string `json:"password"`
+}
+
+// object is a file or a directory
+type object struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	IsDir    bool   `json:"is_dir"`
+	Modified string `json:"modified"`
+	Sign     string `json:"sign"`
+	RawURL   string `json:"raw_url"`
+}
+
+type mkdirRequest struct {
+	Path string `json:"path"`
+}
+
+type renameRequest struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+}
+
+// batchRequest is the request of copying or moving the entries of a directory to another
+type batchRequest struct {
+	SrcDir string   `json:"src_dir"`
+	DstDir string   `json:"dst_dir"`
+	Names  []string `json:"names"`
+}
+
+type removeRequest struct {
+	Dir   string   `json:"dir"`
+	Names []string `json:"names"`
+}
+
+// copyResult has the tasks of copying between the storages, the copying in a storage has no tasks
+type copyResult struct {
+	Tasks []taskInfo `json:"tasks"`
+}
+
+type taskInfo struct {
+	ID       string  `json:"id"`
+	State    int     `json:"state"`
+	Progress float64 `json:"progress"`
+	Error    string  `json:"error"`
+}
+
+// the states of the tasks
+const (
+	taskSucceeded = 2
+	taskCanceled  = 4
+	taskErrored   = 5
+	taskFailed    = 7
+)
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() < 200 || resp.Status() >= 300 {
+		if resp.Status() == http.StatusUnauthorized {
+			return err.NewUnauthorizedError(resp.Response().Status)
+		}
+		return err.NewRemoteApiError(resp.Status(), i18n.T("drive.alist.remote_error", resp.Response().Status))
+	}
+	if !strings.HasPrefix(resp.Response().Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	res := apiResult{}
+	if e := resp.Json(&res); e != nil {
+		return e
+	}
+	switch res.Code {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return err.NewUnauthorizedError(res.Message)
+	case http.StatusForbidden:
+		return err.NewNotAllowedMessageError(res.Message)
+	case http.StatusNotFound:
+		return err.NewNotFoundMessageError(res.Message)
+	}
+	// the missing objects are responded with the code 500
+	if strings.Contains(strings.ToLower(res.Message), "not found") {
+		return err.NewNotFoundMessageError(res.Message)
+	}
+	return err.NewRemoteApiError(res.Code, i18n.T("drive.alist.remote_error", res.Message))
+}
@@ -0,0 +1,495 @@
+package alist
+
+import (
+	"context"
+	"encoding/json"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"net/http"
+	"net/url"
+	path2 "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "alist",
+		DisplayName: i18n.T("drive.alist.name"),
+		README:      i18n.T("drive.alist.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "url", Label: i18n.T("drive.alist.form.url.label"), Type: "text", Required: true, Description: i18n.T("drive.alist.form.url.description")},
+			{Field: "username", Label: i18n.T("drive.alist.form.username.label"), Type: "text", Description: i18n.T("drive.alist.form.username.description")},
+			{Field: "password", Label: i18n.T("drive.alist.form.password.label"), Type: "password"},
+			{Field: "root", Label: i18n.T("drive.alist.form.root.label"), Type: "text", Description: i18n.T("drive.alist.form.root.description")},
+			{Field: "meta_password", Label: i18n.T("drive.alist.form.meta_password.label"), Type: "password", Description: i18n.T("drive.alist.form.meta_password.description")},
+			{Field: "proxy_download", Label: i18n.T("drive.alist.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.alist.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.alist.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.alist.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewAlist},
+	})
+}
+
+// Alist mounts a directory of a remote Alist instance by the fs API.
+// The guest is used if the username is omitted.
+type Alist struct {
+	url          string
+	root         string
+	username     string
+	password     string
+	metaPassword string
+
+	c *req.Client
+
+	tokenMux sync.Mutex
+	token    string
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+
+	downloadProxy bool
+}
+
+func NewAlist(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	a := &Alist{
+		url:           strings.TrimSuffix(strings.TrimSpace(config["url"]), "/"),
+		root:          path2.Join("/", strings.TrimSpace(config["root"])),
+		username:      config["username"],
+		password:      config["password"],
+		metaPassword:  config["meta_password"],
+		cacheTTL:      cacheTtl,
+		downloadProxy: config["proxy_download"] != "",
+	}
+	if u, e := url.Parse(a.url); e != nil || a.url == "" || u.Host == "" {
+		return nil, err.NewBadRequestError(i18n.T("drive.alist.invalid_url"))
+	}
+	if cacheTtl <= 0 {
+		a.cache = drive_util.DummyCache()
+	} else {
+		a.cache = driveUtils.CreateCache(a.deserializeEntry, nil)
+	}
+	if a.c, e = req.NewClient(a.url, a.beforeRequest, ifApiCallError, nil); e != nil {
+		return nil, e
+	}
+	if a.username != "" {
+		if e := a.login(ctx); e != nil {
+			return nil, e
+		}
+	}
+	// checks the root
+	root, e := a.getObject(ctx, "")
+	if e != nil {
+		return nil, e
+	}
+	if !root.IsDir {
+		return nil, err.NewBadRequestError(i18n.T("drive.alist.root_not_dir"))
+	}
+	return a, nil
+}
+
+func (a *Alist) beforeRequest(r *http.Request) error {
+	a.tokenMux.Lock()
+	token := a.token
+	a.tokenMux.Unlock()
+	if token != "" {
+		r.Header.Set("Authorization", token)
+	}
+	return nil
+}
+
+// login requests the token of the username and password, the token expires in hours
+func (a *Alist) login(ctx context.Context) error {
+	a.tokenMux.Lock()
+	a.token = ""
+	a.tokenMux.Unlock()
+	res := loginResult{}
+	if e := a.request(ctx, "/api/auth/login", loginRequest{Username: a.username, Password: a.password}, &res); e != nil {
+		return e
+	}
+	a.tokenMux.Lock()
+	a.token = res.Token
+	a.tokenMux.Unlock()
+	return nil
+}
+
+func (a *Alist) request(ctx context.Context, api string, body interface{}, res interface{}) error {
+	resp, e := a.c.Post(ctx, api, nil, req.NewJsonBody(body))
+	if e != nil {
+		return e
+	}
+	r := apiResult{}
+	if e := resp.Json(&r); e != nil {
+		return e
+	}
+	if res == nil || len(r.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Data, res)
+}
+
+// call calls the API, and decodes the data of the result to res.
+// It logs in again and retries if the token is expired.
+func (a *Alist) call(ctx context.Context, api string, body interface{}, res interface{}) error {
+	e := a.request(ctx, api, body, res)
+	if _, ok := e.(err.UnauthorizedError); ok && a.username != "" {
+		if e := a.login(ctx); e != nil {
+			return e
+		}
+		return a.request(ctx, api, body, res)
+	}
+	return e
+}
+
+// apiPath returns the path in Alist
+func (a *Alist) apiPath(path string) string {
+	return path2.Join(a.root, path)
+}
+
+func (a *Alist) getObject(ctx context.Context, path string) (object, error) {
+	o := object{}
+	e := a.call(ctx, "/api/fs/get", getRequest{Path: a.apiPath(path), Password: a.metaPassword}, &o)
+	return o, e
+}
+
+func (a *Alist) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (a *Alist) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &alistEntry{d: a, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := a.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	o, e := a.getObject(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	entry := a.newEntry(utils.PathParent(path), o)
+	_ = a.cache.PutEntry(entry, a.cacheTTL)
+	return entry, nil
+}
+
+// Save streams the content to Alist, which uploads it to the storage of the path
+func (a *Alist) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, a, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(size, true)
+	resp, e := a.c.Request(ctx, "PUT", "/api/fs/put", types.SM{
+		"File-Path": url.PathEscape(a.apiPath(path)),
+		"As-Task":   "false",
+		"Password":  a.metaPassword,
+	}, req.NewReaderBody(drive_util.ProgressReader(reader, ctx), size))
+	_ = a.cache.Evict(path, false)
+	_ = a.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	return a.Get(ctx, path)
+}
+
+func (a *Alist) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := a.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	if e := a.call(ctx, "/api/fs/mkdir", mkdirRequest{Path: a.apiPath(path)}, nil); e != nil {
+		return nil, e
+	}
+	_ = a.cache.Evict(utils.PathParent(path), false)
+	return a.Get(ctx, path)
+}
+
+func (a *Alist) isSelf(e types.IEntry) bool {
+	if ae, ok := e.(*alistEntry); ok {
+		return ae.d == a
+	}
+	return false
+}
+
+// prepareTarget checks the target of copying or moving. /api/fs/copy and /api/fs/move only take the names
+// and the directories, without telling whether to replace, so the existing target is removed first if override
+func (a *Alist) prepareTarget(ctx context.Context, from types.IEntry, to string, override bool) (*alistEntry, error) {
+	from = drive_util.GetIEntry(from, a.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	if _, e := a.Get(ctx, to); e == nil {
+		if !override {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		if e := a.remove(ctx, to); e != nil {
+			return nil, e
+		}
+	} else if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	return from.(*alistEntry), nil
+}
+
+func (a *Alist) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	return drive_util.NativeCopy(ctx, a, from, to, override)
+}
+
+// CopyAsync copies the entry to the directory of another path with the same name,
+// the entries of other names are not supported, they are copied by reading.
+// Copying between the storages of Alist runs as tasks, which are polled by the returned operation.
+func (a *Alist) CopyAsync(ctx context.Context, from types.IEntry, to string, override bool) (types.IAsyncOp, error) {
+	fromEntry := drive_util.GetIEntry(from, a.isSelf)
+	if fromEntry == nil || utils.PathBase(fromEntry.Path()) != utils.PathBase(to) ||
+		utils.PathParent(fromEntry.Path()) == utils.PathParent(to) {
+		return nil, err.NewUnsupportedError()
+	}
+	ae, e := a.prepareTarget(ctx, fromEntry, to, override)
+	if e != nil {
+		return nil, e
+	}
+	res := copyResult{}
+	if e := a.call(ctx, "/api/fs/copy", batchRequest{
+		SrcDir: a.apiPath(utils.PathParent(ae.path)),
+		DstDir: a.apiPath(utils.PathParent(to)),
+		Names:  []string{ae.Name()},
+	}, &res); e != nil {
+		return nil, e
+	}
+	op := &operation{a: a, to: to, tasks: make(map[string]float64, len(res.Tasks))}
+	for _, t := range res.Tasks {
+		op.tasks[t.ID] = 0
+	}
+	return op, nil
+}
+
+// Move renames the entry if it's moved in the same directory, otherwise it's moved with the same name
+func (a *Alist) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	fromEntry := drive_util.GetIEntry(from, a.isSelf)
+	if fromEntry == nil || (utils.PathParent(fromEntry.Path()) != utils.PathParent(to) &&
+		utils.PathBase(fromEntry.Path()) != utils.PathBase(to)) {
+		return nil, err.NewUnsupportedError()
+	}
+	ae, e := a.prepareTarget(ctx, fromEntry, to, override)
+	if e != nil {
+		return nil, e
+	}
+	ctx.Total(ae.Size(), false)
+	if utils.PathParent(ae.path) == utils.PathParent(to) {
+		e = a.call(ctx, "/api/fs/rename", renameRequest{Path: a.apiPath(ae.path), Name: utils.PathBase(to)}, nil)
+	} else {
+		e = a.call(ctx, "/api/fs/move", batchRequest{
+			SrcDir: a.apiPath(utils.PathParent(ae.path)),
+			DstDir: a.apiPath(utils.PathParent(to)),
+			Names:  []string{ae.Name()},
+		}, nil)
+	}
+	_ = a.cache.Evict(to, true)
+	_ = a.cache.Evict(utils.PathParent(to), false)
+	_ = a.cache.Evict(ae.path, true)
+	_ = a.cache.Evict(utils.PathParent(ae.path), false)
+	if e != nil {
+		return nil, e
+	}
+	ctx.Progress(ae.Size(), false)
+	return a.Get(ctx, to)
+}
+
+func (a *Alist) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := a.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	res := listResult{}
+	// all the objects are listed in a page if per_page is 0
+	if e := a.call(ctx, "/api/fs/list", listRequest{
+		Path: a.apiPath(path), Password: a.metaPassword, Page: 1,
+	}, &res); e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, 0, len(res.Content))
+	for _, o := range res.Content {
+		entries = append(entries, a.newEntry(path, o))
+	}
+	_ = a.cache.PutChildren(path, entries, a.cacheTTL)
+	return entries, nil
+}
+
+func (a *Alist) remove(ctx context.Context, path string) error {
+	e := a.call(ctx, "/api/fs/remove", removeRequest{
+		Dir: a.apiPath(utils.PathParent(path)), Names: []string{utils.PathBase(path)},
+	}, nil)
+	_ = a.cache.Evict(path, true)
+	_ = a.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+func (a *Alist) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	// Alist does not respond errors when deleting the missing objects
+	if _, e := a.Get(ctx, path); e != nil {
+		return e
+	}
+	return a.remove(ctx, path)
+}
+
+func (a *Alist) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, a, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (a *Alist) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	return &alistEntry{
+		d: a, path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+	}, nil
+}
+
+func (a *Alist) newEntry(parent string, o object) *alistEntry {
+	modTime := int64(-1)
+	if t, e := time.Parse(time.RFC3339Nano, o.Modified); e == nil && t.Unix() > 0 {
+		modTime = utils.Millisecond(t)
+	}
+	return &alistEntry{
+		d:       a,
+		path:    path2.Join(parent, o.Name),
+		isDir:   o.IsDir,
+		size:    o.Size,
+		modTime: modTime,
+	}
+}
+
+// operation is the handle of copying, which polls the tasks of it
+type operation struct {
+	a  *Alist
+	to string
+	// tasks maps the ids of the unfinished tasks to the progress
+	tasks map[string]float64
+	total int
+}
+
+func (o *operation) Poll(ctx context.Context) (bool, float64, error) {
+	if o.total == 0 {
+		o.total = len(o.tasks)
+	}
+	if len(o.tasks) == 0 {
+		return true, 1, nil
+	}
+	progress := float64(o.total - len(o.tasks))
+	for id := range o.tasks {
+		t := taskInfo{}
+		if e := o.a.call(ctx, "/api/task/copy/info?"+url.Values{"tid": {id}}.Encode(), nil, &t); e != nil {
+			return false, 0, e
+		}
+		switch t.State {
+		case taskSucceeded:
+			delete(o.tasks, id)
+			progress++
+			continue
+		case taskCanceled, taskErrored, taskFailed:
+			return false, 0, err.NewRemoteApiError(500, i18n.T("drive.alist.task_failed", t.Error))
+		}
+		o.tasks[id] = t.Progress / 100
+		progress += o.tasks[id]
+	}
+	return len(o.tasks) == 0, progress / float64(o.total), nil
+}
+
+func (o *operation) Result(ctx context.Context) (types.IEntry, error) {
+	_ = o.a.cache.Evict(o.to, true)
+	_ = o.a.cache.Evict(utils.PathParent(o.to), false)
+	return o.a.Get(ctx, o.to)
+}
+
+type alistEntry struct {
+	d       *Alist
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+}
+
+func (e *alistEntry) Path() string {
+	return e.path
+}
+
+func (e *alistEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *alistEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *alistEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *alistEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *alistEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *alistEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *alistEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	u, ee := e.GetURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	return drive_util.GetURL(ctx, u.URL, nil)
+}
+
+// GetURL returns the raw URL of the file, or the download link of Alist if the storage is proxied
+func (e *alistEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	o, ee := e.d.getObject(ctx, e.path)
+	if ee != nil {
+		return nil, ee
+	}
+	u := o.RawURL
+	if u == "" {
+		u = e.d.url + "/d" + (&url.URL{Path: e.d.apiPath(e.path)}).EscapedPath()
+		if o.Sign != "" {
+			u += "?sign=" + url.QueryEscape(o.Sign)
+		}
+	}
+	return &types.ContentURL{URL: u, Proxy: e.d.downloadProxy}, nil
+}
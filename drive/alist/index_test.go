@@ -0,0 +1,168 @@
+package alist
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+type testServer struct {
+	t        *testing.T
+	uploaded bytes.Buffer
+	// logins is the number of logging in, the first token is expired
+	logins int
+	polls  int
+	copied bool
+}
+
+func (s *testServer) token() string {
+	if s.logins > 1 {
+		return "token2"
+	}
+	return "token1"
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	write := func(code int, message string, data interface{}) {
+		b, _ := json.Marshal(map[string]interface{}{"code": code, "message": message, "data": data})
+		_, _ = w.Write(b)
+	}
+	if r.URL.Path == "/api/auth/login" {
+		body := loginRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Username != "admin" || body.Password != "pass" {
+			write(400, "password is incorrect", nil)
+			return
+		}
+		s.logins++
+		write(200, "success", loginResult{Token: s.token()})
+		return
+	}
+	if r.Header.Get("Authorization") != "token2" {
+		write(401, "token is expired", nil)
+		return
+	}
+	body := map[string]interface{}{}
+	if r.Method == "POST" {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+	switch r.URL.Path {
+	case "/api/fs/get":
+		switch body["path"] {
+		case "/mnt":
+			write(200, "success", object{Name: "mnt", IsDir: true})
+		case "/mnt/a b/x.txt":
+			if s.uploaded.Len() == 0 {
+				write(500, "failed get objs: object not found", nil)
+				return
+			}
+			write(200, "success", object{Name: "x.txt", Size: 5, Modified: "2020-09-13T12:26:40Z", Sign: "s=1"})
+		case "/mnt/b/x.txt":
+			if !s.copied {
+				write(500, "object not found", nil)
+				return
+			}
+			write(200, "success", object{Name: "x.txt", Size: 5, Modified: "2020-09-13T12:26:40Z"})
+		default:
+			write(500, "object not found", nil)
+		}
+	case "/api/fs/list":
+		if body["path"] != "/mnt/a b" || body["password"] != "meta" {
+			write(403, "password is incorrect", nil)
+			return
+		}
+		write(200, "success", listResult{Content: []object{
+			{Name: "sub", IsDir: true, Modified: "0001-01-01T00:00:00Z"},
+			{Name: "y.txt", Size: 3, Modified: "2020-09-13T12:26:40.5+00:00"},
+		}})
+	case "/api/fs/put":
+		if p, _ := url.PathUnescape(r.Header.Get("File-Path")); p != "/mnt/a b/x.txt" {
+			s.t.Errorf("unexpected upload path: %s", r.Header.Get("File-Path"))
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		s.uploaded.Write(b)
+		write(200, "success", nil)
+	case "/api/fs/copy":
+		if body["src_dir"] != "/mnt/a b" || body["dst_dir"] != "/mnt/b" {
+			s.t.Errorf("unexpected copy: %v", body)
+		}
+		write(200, "success", copyResult{Tasks: []taskInfo{{ID: "t1"}}})
+	case "/api/task/copy/info":
+		if r.URL.Query().Get("tid") != "t1" {
+			s.t.Errorf("unexpected task: %s", r.URL.Query().Get("tid"))
+		}
+		s.polls++
+		if s.polls < 2 {
+			write(200, "success", taskInfo{ID: "t1", State: 1, Progress: 50})
+			return
+		}
+		s.copied = true
+		write(200, "success", taskInfo{ID: "t1", State: taskSucceeded, Progress: 100})
+	default:
+		write(404, "not found", nil)
+	}
+}
+
+func TestAlist(t *testing.T) {
+	s := &testServer{t: t}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	if _, e := NewAlist(context.Background(), drive_util.DriveConfig{
+		"url": server.URL, "username": "admin", "password": "wrong",
+	}, drive_util.DriveUtils{}); e == nil {
+		t.Error("expect error of the wrong password")
+	}
+	d, e := NewAlist(context.Background(), drive_util.DriveConfig{
+		"url": server.URL + "/", "username": "admin", "password": "pass", "root": "mnt", "meta_password": "meta",
+	}, drive_util.DriveUtils{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if s.logins != 2 {
+		t.Errorf("expect logging in again when the token is expired, %d", s.logins)
+	}
+	ctx := task.DummyContext()
+	entries, e := d.List(ctx, "a b")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 2 || entries[0].Path() != "a b/sub" || !entries[0].Type().IsDir() || entries[0].ModTime() != -1 ||
+		entries[1].Size() != 3 || entries[1].ModTime() != 1600000000500 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if _, e := d.Get(ctx, "a b/x.txt"); !err.IsNotFoundError(e) {
+		t.Errorf("expect error of the file not found: %v", e)
+	}
+	entry, e := d.Save(ctx, "a b/x.txt", 5, false, bytes.NewReader([]byte("hello")))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if s.uploaded.String() != "hello" || entry.Size() != 5 || entry.ModTime() != 1600000000000 {
+		t.Errorf("unexpected uploaded file: %s, %d", s.uploaded.String(), entry.Size())
+	}
+	u, e := entry.(*alistEntry).GetURL(ctx)
+	if e != nil || u.URL != server.URL+"/d/mnt/a%20b/x.txt?sign=s%3D1" {
+		t.Errorf("unexpected url: %v, %v", u, e)
+	}
+
+	copied, e := d.Copy(ctx, entry, "b/x.txt", false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if copied.Path() != "b/x.txt" || s.polls != 2 {
+		t.Errorf("unexpected copied file: %s, %d", copied.Path(), s.polls)
+	}
+	if _, e := d.Copy(ctx, entry, "b/y.txt", false); !err.IsUnsupportedError(e) {
+		t.Errorf("expect copying to another name unsupported: %v", e)
+	}
+}
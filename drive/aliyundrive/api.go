@@ -0,0 +1,139 @@
+package aliyundrive
+
+import (
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const apiURL = "https://openapi.alipan.com"
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (a apiError) Error() string {
+	return a.Code + ": " + a.Message
+}
+
+type tokenResult struct {
+	TokenType    string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type driveInfo struct {
+	UserId          string `json:"user_id"`
+	Name            string `json:"name"`
+	DefaultDriveId  string `json:"default_drive_id"`
+	ResourceDriveId string `json:"resource_drive_id"`
+	BackupDriveId   string `json:"backup_drive_id"`
+}
+
+type file struct {
+	FileId       string `json:"file_id"`
+	ParentFileId string `json:"parent_file_id"`
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	// Type is 'file' or 'folder'
+	Type        string `json:"type"`
+	ContentHash string `json:"content_hash"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type listResult struct {
+	Items      []file `json:"items"`
+	NextMarker string `json:"next_marker"`
+}
+
+type partInfo struct {
+	PartNumber int    `json:"part_number"`
+	UploadURL  string `json:"upload_url,omitempty"`
+}
+
+type createResult struct {
+	FileId       string     `json:"file_id"`
+	UploadId     string     `json:"upload_id"`
+	RapidUpload  bool       `json:"rapid_upload"`
+	Exist        bool       `json:"exist"`
+	PartInfoList []partInfo `json:"part_info_list"`
+}
+
+type uploadURLResult struct {
+	PartInfoList []partInfo `json:"part_info_list"`
+}
+
+// operationResult is the result of the copy, move and trash operations
+type operationResult struct {
+	FileId      string `json:"file_id"`
+	Exist       bool   `json:"exist"`
+	AsyncTaskId string `json:"async_task_id"`
+}
+
+type asyncTask struct {
+	// State is 'Succeed', 'Running', 'Failed' or 'PartialSucceed'
+	State string `json:"state"`
+}
+
+type downloadURL struct {
+	URL string `json:"url"`
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	ae := apiError{}
+	if e := resp.Json(&ae); e != nil || ae.Code == "" {
+		ae.Code = resp.Response().Status
+	}
+	switch {
+	case resp.Status() == http.StatusNotFound || strings.HasPrefix(ae.Code, "NotFound."):
+		return err.NewNotFoundError()
+	case strings.HasPrefix(ae.Code, "AlreadyExist."):
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	case ae.Code == "QuotaExhausted.Drive":
+		return err.NewNotAllowedMessageError(i18n.T("drive.aliyundrive.quota_full"))
+	case resp.Status() == http.StatusUnauthorized:
+		return err.NewUnauthorizedError(i18n.T("drive.aliyundrive.unauthorized", ae.Error()))
+	case resp.Status() == http.StatusForbidden:
+		return err.NewNotAllowedMessageError(i18n.T("drive.aliyundrive.remote_error", ae.Error()))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.aliyundrive.remote_error", ae.Error()))
+}
+
+// ifContentError checks the responses of the upload and download URLs, the content must not be read
+func ifContentError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	_ = resp.Dispose()
+	if resp.Status() == http.StatusNotFound {
+		return err.NewNotFoundError()
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.aliyundrive.remote_error", resp.Response().Status))
+}
+
+// partBody is the body of the parts, which is uploaded without the content type,
+// as the upload URLs are signed without it
+type partBody struct {
+	r    io.Reader
+	size int64
+}
+
+func (b *partBody) ContentType() string {
+	return ""
+}
+
+func (b *partBody) Reader() io.Reader {
+	return b.r
+}
+
+func (b *partBody) ContentLength() int64 {
+	return b.size
+}
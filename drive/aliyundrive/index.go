@@ -0,0 +1,587 @@
+package aliyundrive
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"golang.org/x/oauth2"
+	"io"
+	"io/ioutil"
+	"os"
+	path2 "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "aliyundrive",
+		DisplayName: i18n.T("drive.aliyundrive.name"),
+		README:      i18n.T("drive.aliyundrive.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "client_id", Label: i18n.T("drive.aliyundrive.form.client_id.label"), Type: "text", Required: true},
+			{Field: "client_secret", Label: i18n.T("drive.aliyundrive.form.client_secret.label"), Type: "password", Required: true},
+			{Field: "space", Label: i18n.T("drive.aliyundrive.form.space.label"), Type: "select", Required: true,
+				DefaultValue: spaceBackup,
+				Options: []types.FormItemOption{
+					{Name: i18n.T("drive.aliyundrive.form.space.backup"), Value: spaceBackup},
+					{Name: i18n.T("drive.aliyundrive.form.space.resource"), Value: spaceResource},
+				},
+			},
+			{Field: "proxy_download", Label: i18n.T("drive.aliyundrive.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.aliyundrive.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.aliyundrive.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.aliyundrive.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewAliyunDrive, InitConfig: InitConfig, Init: Init},
+	})
+}
+
+// the spaces of Aliyun Drive
+const (
+	spaceBackup   = "backup"
+	spaceResource = "resource"
+)
+
+const (
+	// listLimit is the limit of the file list API, which allows at most 100
+	listLimit = 100
+	// minPartSize is the size of the parts of the uploads, it grows for the huge files
+	minPartSize = 10 * 1024 * 1024
+	maxParts    = 10000
+	// uploadURLTTL is how long the upload URLs of the parts are used, they expire after an hour
+	uploadURLTTL = 50 * time.Minute
+	// downloadURLTTL is how long the download URLs are valid, in seconds
+	downloadURLTTL = 4 * 60 * 60
+)
+
+type AliyunDrive struct {
+	// c calls the API, cc uploads and downloads the content
+	c  *req.Client
+	cc *req.Client
+	ts oauth2.TokenSource
+
+	driveId string
+	tempDir string
+
+	downloadProxy bool
+	cacheTTL      time.Duration
+	cache         drive_util.DriveCache
+}
+
+// NewAliyunDrive creates the drive of the personal or the resource space of Aliyun Drive by the open API
+func NewAliyunDrive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	resp, e := drive_util.OAuthGet(*oauthReq(driveUtils.Config), config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	d := &AliyunDrive{
+		ts:            newTokenSource(resp.Token, config, driveUtils.Data),
+		tempDir:       driveUtils.Config.TempDir,
+		downloadProxy: config["proxy_download"] != "",
+		cacheTTL:      cacheTtl,
+	}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+	if d.c, e = newApiClient(d.ts); e != nil {
+		return nil, e
+	}
+	if d.cc, e = req.NewClient("", nil, ifContentError, nil); e != nil {
+		return nil, e
+	}
+
+	info, e := getDriveInfo(ctx, d.c)
+	if e != nil {
+		return nil, e
+	}
+	d.driveId = info.BackupDriveId
+	if d.driveId == "" {
+		d.driveId = info.DefaultDriveId
+	}
+	if config["space"] == spaceResource {
+		d.driveId = info.ResourceDriveId
+	}
+	if d.driveId == "" {
+		return nil, err.NewNotFoundMessageError(i18n.T("drive.aliyundrive.space_not_available"))
+	}
+	return d, nil
+}
+
+func (d *AliyunDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+// call calls the API of the drive, the result is decoded to res if it's not nil
+func (d *AliyunDrive) call(ctx context.Context, api string, params types.M, res interface{}) error {
+	params["drive_id"] = d.driveId
+	resp, e := d.c.Post(ctx, "/adrive/v1.0/openFile/"+api, nil, req.NewJsonBody(params))
+	if e != nil {
+		return e
+	}
+	if res == nil {
+		return resp.Dispose()
+	}
+	return resp.Json(res)
+}
+
+func (d *AliyunDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &aliyunEntry{d: d, id: "root", path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	f := file{}
+	if e := d.call(ctx, "get_by_path", types.M{"file_path": "/" + path}, &f); e != nil {
+		return nil, e
+	}
+	entry := d.newEntry(utils.PathParent(path), f)
+	_ = d.cache.PutEntry(entry, d.cacheTTL)
+	return entry, nil
+}
+
+// getDir returns the directory, it fails if path is a file
+func (d *AliyunDrive) getDir(ctx context.Context, path string) (*aliyunEntry, error) {
+	dir, e := d.Get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if !dir.Type().IsDir() {
+		return nil, err.NewNotAllowedError()
+	}
+	return dir.(*aliyunEntry), nil
+}
+
+// prepareTarget makes sure path can be written, the existing entry is moved to the recycle bin if override is true
+func (d *AliyunDrive) prepareTarget(ctx context.Context, path string, override bool) error {
+	existing, e := d.Get(ctx, path)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return nil
+		}
+		return e
+	}
+	if !override {
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	_, e = d.trash(ctx, existing.(*aliyunEntry).id)
+	_ = d.cache.Evict(path, true)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+// partSize returns the size of the parts to upload a file of size
+func partSize(size int64) int64 {
+	if size > minPartSize*maxParts {
+		return (size + maxParts - 1) / maxParts
+	}
+	return minPartSize
+}
+
+// Save uploads the file by the rapid upload if Aliyun Drive has the same content, or by parts.
+// The file is buffered in a temp file, as the SHA1 and the proof code are required before uploading
+func (d *AliyunDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if e := d.prepareTarget(ctx, path, override); e != nil {
+		return nil, e
+	}
+	parent, e := d.getDir(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	ctx.Total(size, true)
+	h := sha1.New()
+	tempFile, e := drive_util.CopyReaderToTempFile(task.NewCtxWrapper(ctx, false, false),
+		io.TeeReader(reader, h), d.tempDir)
+	if e != nil {
+		return nil, e
+	}
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+	stat, e := tempFile.Stat()
+	if e != nil {
+		return nil, e
+	}
+	size = stat.Size()
+	ctx.Total(size, true)
+
+	t, e := d.ts.Token()
+	if e != nil {
+		return nil, e
+	}
+	proof, e := proofCode(t.AccessToken, tempFile, size)
+	if e != nil {
+		return nil, e
+	}
+	ps := partSize(size)
+	parts := make([]partInfo, 0)
+	for i := int64(0); i == 0 || i*ps < size; i++ {
+		parts = append(parts, partInfo{PartNumber: int(i) + 1})
+	}
+	cr := createResult{}
+	if e := d.call(ctx, "create", types.M{
+		"parent_file_id": parent.id, "name": utils.PathBase(path), "type": "file",
+		"check_name_mode": "refuse", "size": size, "part_info_list": parts,
+		"content_hash_name": "sha1", "content_hash": strings.ToUpper(hex.EncodeToString(h.Sum(nil))),
+		"proof_version": "v1", "proof_code": proof,
+	}, &cr); e != nil {
+		return nil, e
+	}
+	if cr.Exist {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	if !cr.RapidUpload {
+		if e := d.uploadParts(ctx, tempFile, size, ps, cr); e != nil {
+			return nil, e
+		}
+		if e := d.call(ctx, "complete", types.M{"file_id": cr.FileId, "upload_id": cr.UploadId}, nil); e != nil {
+			return nil, e
+		}
+	}
+	// the parts skipped by the rapid upload are done as well
+	ctx.Progress(size, true)
+	_ = d.cache.Evict(path, false)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return d.Get(ctx, path)
+}
+
+// uploadParts uploads the parts to the upload URLs, the URLs are renewed before they expire
+func (d *AliyunDrive) uploadParts(ctx types.TaskCtx, file *os.File, size, partSize int64, cr createResult) error {
+	parts := cr.PartInfoList
+	renewedAt := time.Now()
+	for i := range parts {
+		if e := ctx.WaitIfPaused(); e != nil {
+			return e
+		}
+		if time.Since(renewedAt) > uploadURLTTL {
+			numbers := make([]partInfo, len(parts))
+			for j, p := range parts {
+				numbers[j] = partInfo{PartNumber: p.PartNumber}
+			}
+			r := uploadURLResult{}
+			if e := d.call(ctx, "getUploadUrl", types.M{
+				"file_id": cr.FileId, "upload_id": cr.UploadId, "part_info_list": numbers,
+			}, &r); e != nil {
+				return e
+			}
+			parts, renewedAt = r.PartInfoList, time.Now()
+		}
+		offset := int64(parts[i].PartNumber-1) * partSize
+		length := partSize
+		if offset+length > size {
+			length = size - offset
+		}
+		resp, e := d.cc.Request(ctx, "PUT", parts[i].UploadURL, nil, &partBody{
+			r:    drive_util.ProgressReader(io.NewSectionReader(file, offset, length), ctx),
+			size: length,
+		})
+		if e != nil {
+			return e
+		}
+		_ = resp.Dispose()
+	}
+	return nil
+}
+
+func (d *AliyunDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := d.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	parent, e := d.getDir(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	if e := d.call(ctx, "create", types.M{
+		"parent_file_id": parent.id, "name": utils.PathBase(path), "type": "folder", "check_name_mode": "refuse",
+	}, nil); e != nil {
+		return nil, e
+	}
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return d.Get(ctx, path)
+}
+
+func (d *AliyunDrive) isSelf(e types.IEntry) bool {
+	if ae, ok := e.(*aliyunEntry); ok {
+		return ae.d == d
+	}
+	return false
+}
+
+func (d *AliyunDrive) rename(ctx context.Context, fileId, name string) error {
+	return d.call(ctx, "update", types.M{"file_id": fileId, "name": name, "check_name_mode": "refuse"}, nil)
+}
+
+func (d *AliyunDrive) trash(ctx context.Context, fileId string) (*operation, error) {
+	r := operationResult{}
+	if e := d.call(ctx, "recyclebin/trash", types.M{"file_id": fileId}, &r); e != nil {
+		return nil, e
+	}
+	return &operation{d: d, id: r.AsyncTaskId}, nil
+}
+
+func (d *AliyunDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	return drive_util.NativeCopy(ctx, d, from, to, override)
+}
+
+// CopyAsync copies the file or the folder into the parent of to, and renames it after the copy completes,
+// as Aliyun Drive can not copy to another name
+func (d *AliyunDrive) CopyAsync(ctx context.Context, from types.IEntry, to string, override bool) (types.IAsyncOp, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	if e := d.prepareTarget(ctx, to, override); e != nil {
+		return nil, e
+	}
+	parent, e := d.getDir(ctx, utils.PathParent(to))
+	if e != nil {
+		return nil, e
+	}
+	r := operationResult{}
+	if e := d.call(ctx, "copy", types.M{
+		"file_id": from.(*aliyunEntry).id, "to_parent_file_id": parent.id, "auto_rename": true,
+	}, &r); e != nil {
+		return nil, e
+	}
+	// the copy in the same directory is auto renamed
+	rename := utils.PathBase(from.Path()) != utils.PathBase(to) || utils.PathParent(from.Path()) == utils.PathParent(to)
+	return &operation{d: d, id: r.AsyncTaskId, to: to, fileId: r.FileId, rename: rename}, nil
+}
+
+func (d *AliyunDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	if e := d.prepareTarget(ctx, to, override); e != nil {
+		return nil, e
+	}
+	fileId := from.(*aliyunEntry).id
+	var e error
+	if utils.PathParent(from.Path()) == utils.PathParent(to) {
+		e = d.rename(ctx, fileId, utils.PathBase(to))
+	} else {
+		var parent *aliyunEntry
+		if parent, e = d.getDir(ctx, utils.PathParent(to)); e != nil {
+			return nil, e
+		}
+		r := operationResult{}
+		e = d.call(ctx, "move", types.M{
+			"file_id": fileId, "to_parent_file_id": parent.id,
+			"new_name": utils.PathBase(to), "check_name_mode": "refuse",
+		}, &r)
+		if e == nil && r.Exist {
+			e = err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+	}
+	_ = d.cache.Evict(from.Path(), true)
+	_ = d.cache.Evict(utils.PathParent(from.Path()), false)
+	_ = d.cache.Evict(to, true)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	return d.Get(ctx, to)
+}
+
+// List lists the directory page by page
+func (d *AliyunDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	dir, e := d.getDir(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, 0)
+	marker := ""
+	for {
+		r := listResult{}
+		if e := d.call(ctx, "list", types.M{
+			"parent_file_id": dir.id, "limit": listLimit, "marker": marker,
+		}, &r); e != nil {
+			return nil, e
+		}
+		for _, f := range r.Items {
+			entries = append(entries, d.newEntry(path, f))
+		}
+		if r.NextMarker == "" {
+			break
+		}
+		marker = r.NextMarker
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+// Delete moves the file or the directory to the recycle bin of Aliyun Drive
+func (d *AliyunDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := d.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	op, e := d.trash(ctx, entry.(*aliyunEntry).id)
+	if e == nil {
+		_, e = drive_util.WaitAsyncOp(ctx, op, 0)
+	}
+	_ = d.cache.Evict(path, true)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+func (d *AliyunDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *AliyunDrive) newEntry(parent string, f file) *aliyunEntry {
+	modTime := int64(-1)
+	if t, e := time.Parse(time.RFC3339, f.UpdatedAt); e == nil {
+		modTime = utils.Millisecond(t)
+	}
+	return &aliyunEntry{
+		d:       d,
+		id:      f.FileId,
+		path:    path2.Join(parent, f.Name),
+		isDir:   f.Type == "folder",
+		size:    f.Size,
+		modTime: modTime,
+		sha1:    strings.ToLower(f.ContentHash),
+	}
+}
+
+type aliyunEntry struct {
+	d       *AliyunDrive
+	id      string
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+	sha1    string
+}
+
+func (e *aliyunEntry) Path() string {
+	return e.path
+}
+
+func (e *aliyunEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *aliyunEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *aliyunEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *aliyunEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *aliyunEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *aliyunEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *aliyunEntry) downloadURL(ctx context.Context) (string, error) {
+	r := downloadURL{}
+	if ee := e.d.call(ctx, "getDownloadUrl", types.M{"file_id": e.id, "expire_sec": downloadURLTTL}, &r); ee != nil {
+		return "", ee
+	}
+	return r.URL, nil
+}
+
+func (e *aliyunEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+func (e *aliyunEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	u, ee := e.downloadURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	var header types.SM
+	if offset > 0 || length >= 0 {
+		rangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if length >= 0 {
+			if length == 0 {
+				return ioutil.NopCloser(strings.NewReader("")), nil
+			}
+			rangeHeader += strconv.FormatInt(offset+length-1, 10)
+		}
+		header = types.SM{"Range": rangeHeader}
+	}
+	resp, ee := e.d.cc.Get(ctx, u, header)
+	if ee != nil {
+		return nil, ee
+	}
+	return resp.Response().Body, nil
+}
+
+// GetURL returns the download URL of the file, so the downloads are redirected to Aliyun Drive
+func (e *aliyunEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	u, ee := e.downloadURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	return &types.ContentURL{URL: u, Proxy: e.d.downloadProxy}, nil
+}
+
+func (e *aliyunEntry) EntryData() types.SM {
+	return types.SM{"id": e.id, "sha1": e.sha1}
+}
+
+func (e *aliyunEntry) StableID() string {
+	return e.id
+}
+
+func (e *aliyunEntry) ContentHash(context.Context) (string, string, error) {
+	if e.sha1 == "" {
+		return "", "", err.NewUnsupportedError()
+	}
+	return "sha1", e.sha1, nil
+}
@@ -0,0 +1,232 @@
+package aliyundrive
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func oauthReq(c common.Config) *drive_util.OAuthRequest {
+	return &drive_util.OAuthRequest{
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  apiURL + "/oauth/authorize",
+			TokenURL: apiURL + "/oauth/access_token",
+		},
+		RedirectURL: c.OAuthRedirectURI,
+		// the scopes are separated by commas
+		Scopes: []string{"user:base,file:all:read,file:all:write"},
+		Text:   i18n.T("drive.aliyundrive.oauth_text"),
+	}
+}
+
+// requestToken requests the token by the code or the refresh token,
+// the token API accepts JSON only, so it's not requested by oauth2
+func requestToken(ctx context.Context, config drive_util.DriveConfig, params types.M) (*oauth2.Token, error) {
+	c, e := req.NewClient(apiURL, nil, ifApiCallError, nil)
+	if e != nil {
+		return nil, e
+	}
+	params["client_id"] = config["client_id"]
+	params["client_secret"] = config["client_secret"]
+	resp, e := c.Post(ctx, "/oauth/access_token", nil, req.NewJsonBody(params))
+	if e != nil {
+		return nil, e
+	}
+	r := tokenResult{}
+	if e := resp.Json(&r); e != nil {
+		return nil, e
+	}
+	return &oauth2.Token{
+		AccessToken:  r.AccessToken,
+		TokenType:    r.TokenType,
+		RefreshToken: r.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(r.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// tokenRefresher refreshes the token, the refresh token is rotated on every refresh
+type tokenRefresher struct {
+	config       drive_util.DriveConfig
+	refreshToken string
+}
+
+func (r *tokenRefresher) Token() (*oauth2.Token, error) {
+	t, e := requestToken(context.Background(), r.config, types.M{
+		"grant_type": "refresh_token", "refresh_token": r.refreshToken,
+	})
+	if e != nil {
+		return nil, e
+	}
+	r.refreshToken = t.RefreshToken
+	return t, nil
+}
+
+// newTokenSource returns the TokenSource which renews the token before it expires,
+// the renewed tokens are saved, as the refresh tokens can be used only once
+func newTokenSource(t *oauth2.Token, config drive_util.DriveConfig, ds drive_util.DriveDataStore) oauth2.TokenSource {
+	ts := oauth2.ReuseTokenSource(t, &tokenRefresher{config: config, refreshToken: t.RefreshToken})
+	return drive_util.PersistentTokenSource(ts, t, ds)
+}
+
+func newApiClient(ts oauth2.TokenSource) (*req.Client, error) {
+	return req.NewClient(apiURL, func(r *http.Request) error {
+		t, e := ts.Token()
+		if e != nil {
+			return e
+		}
+		r.Header.Set("Authorization", "Bearer "+t.AccessToken)
+		return nil
+	}, ifApiCallError, nil)
+}
+
+func getDriveInfo(ctx context.Context, c *req.Client) (*driveInfo, error) {
+	resp, e := c.Post(ctx, "/adrive/v1.0/user/getDriveInfo", nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	info := &driveInfo{}
+	return info, resp.Json(info)
+}
+
+func InitConfig(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (*drive_util.DriveInitConfig, error) {
+	initConfig, resp, e := drive_util.OAuthInitConfig(*oauthReq(driveUtils.Config), config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	if resp == nil || resp.Token == nil {
+		return initConfig, nil
+	}
+	c, e := newApiClient(newTokenSource(resp.Token, config, driveUtils.Data))
+	if e != nil {
+		return nil, e
+	}
+	info, e := getDriveInfo(ctx, c)
+	initConfig.Configured = e == nil
+	if e == nil {
+		initConfig.OAuth.Principal = info.Name
+	}
+	return initConfig, nil
+}
+
+// Init exchanges the code for the token
+func Init(ctx context.Context, data types.SM, config drive_util.DriveConfig, driveUtils drive_util.DriveUtils) error {
+	code := data["code"]
+	if code == "" {
+		return nil
+	}
+	params, e := driveUtils.Data.Load("state")
+	if e != nil {
+		return e
+	}
+	if data["state"] != params["state"] {
+		return err.NewNotAllowedMessageError(i18n.T("oauth.state_mismatch"))
+	}
+	t, e := requestToken(ctx, config, types.M{"grant_type": "authorization_code", "code": code})
+	if e != nil {
+		return e
+	}
+	if e := drive_util.SaveOAuthToken(driveUtils.Data, t); e != nil {
+		return e
+	}
+	return driveUtils.Data.Save(types.SM{"state": ""})
+}
+
+// proofCode returns the proof of the content of the rapid upload,
+// which is the base64 of at most 8 bytes at the offset derived from the MD5 of the access token
+func proofCode(accessToken string, r io.ReaderAt, size int64) (string, error) {
+	if size <= 0 {
+		return "", nil
+	}
+	sum := md5.Sum([]byte(accessToken))
+	n, e := strconv.ParseUint(hex.EncodeToString(sum[:])[:16], 16, 64)
+	if e != nil {
+		return "", e
+	}
+	offset := int64(n % uint64(size))
+	end := offset + 8
+	if end > size {
+		end = size
+	}
+	buf := make([]byte, end-offset)
+	if _, e := r.ReadAt(buf, offset); e != nil && e != io.EOF {
+		return "", e
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+func (d *AliyunDrive) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	ed := ec.Data
+	if ed == nil || (ed["id"] == "" && !utils.IsRootPath(ec.Path)) {
+		return nil, errors.New("invalid cache")
+	}
+	return &aliyunEntry{
+		d: d, id: ed["id"], sha1: ed["sha1"],
+		path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+	}, nil
+}
+
+// operation is the async task of copying or deleting folders
+type operation struct {
+	d  *AliyunDrive
+	id string
+	// to is the path of the copy, it's renamed to the name of to after the copy completes if rename is true
+	to     string
+	fileId string
+	rename bool
+}
+
+func (o *operation) Poll(ctx context.Context) (bool, float64, error) {
+	if o.id == "" {
+		return true, 1, nil
+	}
+	resp, e := o.d.c.Post(ctx, "/adrive/v1.0/openFile/async_task/get", nil,
+		req.NewJsonBody(types.M{"async_task_id": o.id}))
+	if e != nil {
+		return false, 0, e
+	}
+	t := asyncTask{}
+	if e := resp.Json(&t); e != nil {
+		return false, 0, e
+	}
+	switch t.State {
+	case "Succeed":
+		o.id = ""
+		return true, 1, nil
+	case "Failed", "PartialSucceed":
+		return false, 0, err.NewRemoteApiError(500, i18n.T("drive.aliyundrive.operation_failed"))
+	}
+	return false, 0, nil
+}
+
+func (o *operation) Result(ctx context.Context) (types.IEntry, error) {
+	if o.to == "" {
+		return nil, nil
+	}
+	if o.rename {
+		if e := o.d.rename(ctx, o.fileId, utils.PathBase(o.to)); e != nil {
+			return nil, e
+		}
+	}
+	_ = o.d.cache.Evict(o.to, true)
+	_ = o.d.cache.Evict(utils.PathParent(o.to), false)
+	return o.d.Get(ctx, o.to)
+}
@@ -0,0 +1,44 @@
+package aliyundrive
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"testing"
+)
+
+func TestAliyunProofCode(t *testing.T) {
+	data := []byte("go-drive proof code test content")
+	token := "access-token"
+	sum := md5.Sum([]byte(token))
+	n, _ := strconv.ParseUint(hex.EncodeToString(sum[:])[:16], 16, 64)
+	offset := n % uint64(len(data))
+	end := offset + 8
+	if end > uint64(len(data)) {
+		end = uint64(len(data))
+	}
+
+	code, e := proofCode(token, bytes.NewReader(data), int64(len(data)))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if expected := base64.StdEncoding.EncodeToString(data[offset:end]); code != expected {
+		t.Errorf("unexpected proof code: %s, expected %s", code, expected)
+	}
+
+	if code, _ := proofCode(token, bytes.NewReader(nil), 0); code != "" {
+		t.Errorf("unexpected proof code of the empty file: %s", code)
+	}
+}
+
+func TestAliyunPartSize(t *testing.T) {
+	if s := partSize(1); s != minPartSize {
+		t.Errorf("unexpected part size: %d", s)
+	}
+	size := int64(minPartSize)*maxParts + 1
+	if s := partSize(size); (size+s-1)/s > maxParts {
+		t.Errorf("too many parts of size %d", s)
+	}
+}
@@ -0,0 +1,242 @@
+package archive
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"sync"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "archive",
+		DisplayName: i18n.T("drive.archive.name"),
+		README:      i18n.T("drive.archive.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "path", Label: i18n.T("drive.archive.form.path.label"), Type: "text", Required: true, Description: i18n.T("drive.archive.form.path.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewArchiveDrive},
+	})
+}
+
+// ArchiveDrive mounts an archive file of another mount as a read-only file tree.
+type ArchiveDrive struct {
+	root    func() types.IDrive
+	path    string
+	tempDir string
+
+	loadMux sync.Mutex
+	mux     sync.RWMutex
+	tree    *tree
+}
+
+// resolvingKey is the context key of the archive drives resolving their archive files,
+// to stop the archive file being resolved through the drive itself
+type resolvingKey struct{}
+
+type resolving struct {
+	d      *ArchiveDrive
+	parent *resolving
+}
+
+func NewArchiveDrive(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	if driveUtils.Root == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	return &ArchiveDrive{
+		root:    driveUtils.Root,
+		path:    utils.CleanPath(config["path"]),
+		tempDir: driveUtils.Config.TempDir,
+	}, nil
+}
+
+// source gets the archive file
+func (d *ArchiveDrive) source(ctx context.Context) (types.IContent, error) {
+	parent, _ := ctx.Value(resolvingKey{}).(*resolving)
+	for r := parent; r != nil; r = r.parent {
+		if r.d == d {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.archive.recursive"))
+		}
+	}
+	ctx = context.WithValue(ctx, resolvingKey{}, &resolving{d: d, parent: parent})
+	entry, e := d.root().Get(ctx, d.path)
+	if e != nil {
+		return nil, e
+	}
+	content, ok := entry.(types.IContent)
+	if !entry.Type().IsFile() || !ok {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.archive.not_file", d.path))
+	}
+	return content, nil
+}
+
+// load gets the archive file and its tree, the tree is reloaded if the archive file changed
+func (d *ArchiveDrive) load(ctx context.Context) (types.IContent, *tree, error) {
+	entry, e := d.source(ctx)
+	if e != nil {
+		return nil, nil, e
+	}
+	if t := d.current(); t != nil && t.size == entry.Size() && t.modTime == entry.ModTime() {
+		return entry, t, nil
+	}
+	d.loadMux.Lock()
+	defer d.loadMux.Unlock()
+	if t := d.current(); t != nil && t.size == entry.Size() && t.modTime == entry.ModTime() {
+		return entry, t, nil
+	}
+	a, e := drive_util.GetArchive(entry, d.tempDir)
+	if e != nil {
+		return nil, nil, e
+	}
+	members, e := a.ListMembers(ctx)
+	if e != nil {
+		return nil, nil, e
+	}
+	t := buildTree(entry.Size(), entry.ModTime(), members)
+	d.mux.Lock()
+	d.tree = t
+	d.mux.Unlock()
+	return entry, t, nil
+}
+
+func (d *ArchiveDrive) current() *tree {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	return d.tree
+}
+
+func (d *ArchiveDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: false}
+}
+
+func (d *ArchiveDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	_, t, e := d.load(ctx)
+	if e != nil {
+		return nil, e
+	}
+	if utils.IsRootPath(path) {
+		return &archiveEntry{d: d, m: &types.ArchiveMember{Size: -1, ModTime: t.modTime, IsDir: true}}, nil
+	}
+	m, ok := t.nodes[path]
+	if !ok {
+		return nil, err.NewNotFoundError()
+	}
+	return &archiveEntry{d: d, m: m}, nil
+}
+
+func (d *ArchiveDrive) Save(types.TaskCtx, string, int64, bool, io.Reader) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *ArchiveDrive) MakeDir(context.Context, string) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *ArchiveDrive) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *ArchiveDrive) Move(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *ArchiveDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	_, t, e := d.load(ctx)
+	if e != nil {
+		return nil, e
+	}
+	if !utils.IsRootPath(path) {
+		m, ok := t.nodes[path]
+		if !ok {
+			return nil, err.NewNotFoundError()
+		}
+		if !m.IsDir {
+			return nil, err.NewNotAllowedError()
+		}
+	}
+	children := t.children[path]
+	entries := make([]types.IEntry, 0, len(children))
+	for _, p := range children {
+		entries = append(entries, &archiveEntry{d: d, m: t.nodes[p]})
+	}
+	return entries, nil
+}
+
+func (d *ArchiveDrive) Delete(types.TaskCtx, string) error {
+	return err.NewNotAllowedError()
+}
+
+func (d *ArchiveDrive) Upload(context.Context, string, int64, bool, types.SM) (*types.DriveUploadConfig, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+// openMember opens the member of the current archive file
+func (d *ArchiveDrive) openMember(ctx context.Context, name string) (io.ReadCloser, error) {
+	entry, t, e := d.load(ctx)
+	if e != nil {
+		return nil, e
+	}
+	if m, ok := t.nodes[name]; !ok || m.IsDir {
+		return nil, err.NewNotFoundError()
+	}
+	a, e := drive_util.GetArchive(entry, d.tempDir)
+	if e != nil {
+		return nil, e
+	}
+	return a.OpenMember(ctx, name)
+}
+
+type archiveEntry struct {
+	d *ArchiveDrive
+	m *types.ArchiveMember
+}
+
+func (e *archiveEntry) Path() string {
+	return e.m.Name
+}
+
+func (e *archiveEntry) Type() types.EntryType {
+	if e.m.IsDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *archiveEntry) Size() int64 {
+	if e.m.IsDir {
+		return -1
+	}
+	return e.m.Size
+}
+
+func (e *archiveEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: false}
+}
+
+func (e *archiveEntry) ModTime() int64 {
+	return e.m.ModTime
+}
+
+func (e *archiveEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *archiveEntry) Name() string {
+	return utils.PathBase(e.m.Name)
+}
+
+func (e *archiveEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	if e.m.IsDir {
+		return nil, err.NewNotAllowedError()
+	}
+	return e.d.openMember(ctx, e.m.Name)
+}
+
+func (e *archiveEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"sort"
+)
+
+// tree is the file tree of the archive, identified by the size and the modification time of the archive file
+type tree struct {
+	size    int64
+	modTime int64
+
+	nodes    map[string]*types.ArchiveMember
+	children map[string][]string
+}
+
+// buildTree builds the tree of the members, the parent directories missing in the archive are added
+func buildTree(size, modTime int64, members []types.ArchiveMember) *tree {
+	t := &tree{
+		size:     size,
+		modTime:  modTime,
+		nodes:    make(map[string]*types.ArchiveMember, len(members)),
+		children: make(map[string][]string),
+	}
+	for i := range members {
+		m := &members[i]
+		if utils.IsRootPath(m.Name) {
+			continue
+		}
+		if old, ok := t.nodes[m.Name]; ok && old.IsDir == m.IsDir {
+			// the later one overrides, as extracting the archive does
+			*old = *m
+			continue
+		} else if ok {
+			// a file and a directory of the same name, the directory wins
+			if m.IsDir {
+				*old = *m
+			}
+			continue
+		}
+		t.add(m)
+		for p := utils.PathParent(m.Name); !utils.IsRootPath(p); p = utils.PathParent(p) {
+			parent, ok := t.nodes[p]
+			if ok {
+				if !parent.IsDir {
+					*parent = types.ArchiveMember{Name: p, Size: -1, ModTime: m.ModTime, IsDir: true}
+				}
+				break
+			}
+			t.add(&types.ArchiveMember{Name: p, Size: -1, ModTime: m.ModTime, IsDir: true})
+		}
+	}
+	for _, c := range t.children {
+		sort.Strings(c)
+	}
+	return t
+}
+
+func (t *tree) add(m *types.ArchiveMember) {
+	t.nodes[m.Name] = m
+	parent := utils.PathParent(m.Name)
+	t.children[parent] = append(t.children[parent], m.Name)
+}
@@ -0,0 +1,36 @@
+package archive
+
+import (
+	"go-drive/common/types"
+	"testing"
+)
+
+func TestArchiveBuildTree(t *testing.T) {
+	tr := buildTree(100, 1, []types.ArchiveMember{
+		{Name: "a/b/c.txt", Size: 3, ModTime: 10},
+		{Name: "a", Size: -1, ModTime: 20, IsDir: true},
+		{Name: "d.txt", Size: 1, ModTime: 30},
+		{Name: "d.txt", Size: 2, ModTime: 40},
+		{Name: "", Size: -1, IsDir: true},
+		{Name: "e", Size: 1},
+		{Name: "e/f.txt", Size: 1},
+	})
+	if n := tr.nodes["a/b"]; n == nil || !n.IsDir || n.Size != -1 || n.ModTime != 10 {
+		t.Errorf("expect the implicit directory: %+v", n)
+	}
+	if n := tr.nodes["a"]; n == nil || n.ModTime != 20 {
+		t.Errorf("unexpected directory: %+v", n)
+	}
+	if n := tr.nodes["d.txt"]; n == nil || n.Size != 2 {
+		t.Errorf("expect the later member: %+v", n)
+	}
+	if n := tr.nodes["e"]; n == nil || !n.IsDir {
+		t.Errorf("expect the directory: %+v", n)
+	}
+	if c := tr.children[""]; len(c) != 3 || c[0] != "a" || c[1] != "d.txt" || c[2] != "e" {
+		t.Errorf("unexpected children: %v", c)
+	}
+	if c := tr.children["a/b"]; len(c) != 1 || c[0] != "a/b/c.txt" {
+		t.Errorf("unexpected children: %v", c)
+	}
+}
@@ -0,0 +1,177 @@
+package drive
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"go-drive/common/errors"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"log"
+	"time"
+)
+
+// AuditDrive writes the audit records of the mutations on the wrapped drive to sink.
+// The record of AuditBegin is written before the mutation, the mutation is refused if it can not be written.
+// The record of AuditEnd is written after the mutation, whether it succeeded or not,
+// the error of writing it is returned if the mutation succeeded.
+type AuditDrive struct {
+	drive  types.IDrive
+	sink   types.AuditSink
+	caller string
+}
+
+// NewAuditDrive creates an AuditDrive, caller is the identity recorded in the records
+func NewAuditDrive(drive types.IDrive, sink types.AuditSink, caller string) *AuditDrive {
+	return &AuditDrive{drive: drive, sink: sink, caller: caller}
+}
+
+// audit writes the records around fn
+func (a *AuditDrive) audit(op, path, to string, fn func() error) error {
+	r := types.AuditRecord{
+		ID:     uuid.New().String(),
+		Phase:  types.AuditBegin,
+		Time:   utils.Millisecond(time.Now()),
+		Caller: a.caller,
+		Op:     op,
+		Path:   path,
+		To:     to,
+	}
+	if e := a.sink.Write(r); e != nil {
+		log.Println("failed to write audit record", e)
+		return e
+	}
+	opErr := fn()
+	r.Phase = types.AuditEnd
+	r.Time = utils.Millisecond(time.Now())
+	r.Result = types.AuditOK
+	if opErr != nil {
+		r.Result = types.AuditFailed
+		r.Error = opErr.Error()
+	}
+	if e := a.sink.Write(r); e != nil {
+		log.Println("failed to write audit record", e)
+		if opErr == nil {
+			return e
+		}
+	}
+	return opErr
+}
+
+func (a *AuditDrive) Meta(ctx context.Context) types.DriveMeta {
+	return a.drive.Meta(ctx)
+}
+
+func (a *AuditDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	return a.drive.Get(ctx, path)
+}
+
+func (a *AuditDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (entry types.IEntry, e error) {
+	e = a.audit("save", path, "", func() error {
+		entry, e = a.drive.Save(ctx, path, size, override, reader)
+		return e
+	})
+	return entry, e
+}
+
+func (a *AuditDrive) MakeDir(ctx context.Context, path string) (entry types.IEntry, e error) {
+	e = a.audit("mkdir", path, "", func() error {
+		entry, e = a.drive.MakeDir(ctx, path)
+		return e
+	})
+	return entry, e
+}
+
+func (a *AuditDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (entry types.IEntry, e error) {
+	e = a.audit("copy", from.Path(), to, func() error {
+		entry, e = a.drive.Copy(ctx, from, to, override)
+		return e
+	})
+	return entry, e
+}
+
+func (a *AuditDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (entry types.IEntry, e error) {
+	e = a.audit("move", from.Path(), to, func() error {
+		entry, e = a.drive.Move(ctx, from, to, override)
+		return e
+	})
+	return entry, e
+}
+
+func (a *AuditDrive) Publish(ctx types.TaskCtx, staging, target string) (entry types.IEntry, e error) {
+	publisher, ok := a.drive.(types.IPublisher)
+	if !ok {
+		return nil, err.NewUnsupportedError()
+	}
+	e = a.audit("publish", staging, target, func() error {
+		entry, e = publisher.Publish(ctx, staging, target)
+		return e
+	})
+	return entry, e
+}
+
+func (a *AuditDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	return a.drive.List(ctx, path)
+}
+
+func (a *AuditDrive) Delete(ctx types.TaskCtx, path string) error {
+	return a.audit("delete", path, "", func() error {
+		return a.drive.Delete(ctx, path)
+	})
+}
+
+// Upload is audited as the content may be uploaded to the backend directly with the returned config
+func (a *AuditDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, config types.SM) (c *types.DriveUploadConfig, e error) {
+	e = a.audit("upload", path, "", func() error {
+		c, e = a.drive.Upload(ctx, path, size, override, config)
+		return e
+	})
+	return c, e
+}
+
+func (a *AuditDrive) ResolveID(ctx context.Context, id string) (types.IEntry, error) {
+	resolver, ok := a.drive.(types.IIDResolver)
+	if !ok {
+		return nil, err.NewNotFoundError()
+	}
+	return resolver.ResolveID(ctx, id)
+}
+
+// Share is audited as the entry is exposed to the public
+func (a *AuditDrive) Share(ctx context.Context, path string, options types.ShareOptions) (link *types.ShareLink, e error) {
+	sharer, ok := a.drive.(types.ISharer)
+	if !ok {
+		return nil, err.NewUnsupportedError()
+	}
+	e = a.audit("share", path, "", func() error {
+		link, e = sharer.Share(ctx, path, options)
+		return e
+	})
+	return link, e
+}
+
+func (a *AuditDrive) GetTail(ctx context.Context, path string, n int) ([]byte, int64, error) {
+	tailer, ok := a.drive.(types.ITailer)
+	if !ok {
+		return nil, 0, err.NewUnsupportedError()
+	}
+	return tailer.GetTail(ctx, path, n)
+}
+
+func (a *AuditDrive) Follow(ctx context.Context, path string, offset int64, fn func([]byte, bool) error) error {
+	tailer, ok := a.drive.(types.ITailer)
+	if !ok {
+		return err.NewUnsupportedError()
+	}
+	return tailer.Follow(ctx, path, offset, fn)
+}
+
+func (a *AuditDrive) Space(ctx context.Context, path string) (int64, int64, error) {
+	reporter, ok := a.drive.(types.ISpaceReporter)
+	if !ok {
+		return 0, 0, err.NewUnsupportedError()
+	}
+	return reporter.Space(ctx, path)
+}
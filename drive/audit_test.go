@@ -0,0 +1,70 @@
+package drive
+
+import (
+	"errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"testing"
+)
+
+type auditTestSink struct {
+	records []types.AuditRecord
+	fail    bool
+}
+
+func (s *auditTestSink) Write(r types.AuditRecord) error {
+	if s.fail {
+		return errors.New("sink failed")
+	}
+	s.records = append(s.records, r)
+	return nil
+}
+
+type auditTestDrive struct {
+	types.IDrive
+	deleted []string
+}
+
+func (d *auditTestDrive) Delete(_ types.TaskCtx, path string) error {
+	if path == "missing" {
+		return errors.New("not found")
+	}
+	d.deleted = append(d.deleted, path)
+	return nil
+}
+
+func TestAuditDrive(t *testing.T) {
+	sink := &auditTestSink{}
+	inner := &auditTestDrive{}
+	d := NewAuditDrive(inner, sink, "admin")
+
+	if e := d.Delete(task.DummyContext(), "a"); e != nil {
+		t.Fatal(e)
+	}
+	if e := d.Delete(task.DummyContext(), "missing"); e == nil {
+		t.Fatal("expect the error of the operation")
+	}
+	if len(sink.records) != 4 {
+		t.Fatalf("expect 4 records, but it's %d", len(sink.records))
+	}
+	begin, end := sink.records[2], sink.records[3]
+	if begin.Phase != types.AuditBegin || end.Phase != types.AuditEnd || begin.ID != end.ID {
+		t.Errorf("unexpected records %v, %v", begin, end)
+	}
+	if end.Caller != "admin" || end.Op != "delete" || end.Path != "missing" ||
+		end.Result != types.AuditFailed || end.Error != "not found" {
+		t.Errorf("unexpected record %v", end)
+	}
+	if sink.records[1].Result != types.AuditOK {
+		t.Errorf("unexpected record %v", sink.records[1])
+	}
+
+	// the operation is refused if it can not be audited
+	sink.fail = true
+	if e := d.Delete(task.DummyContext(), "b"); e == nil {
+		t.Error("expect the error of the sink")
+	}
+	if len(inner.deleted) != 1 {
+		t.Errorf("expect 'b' not to be deleted, but it's %v", inner.deleted)
+	}
+}
@@ -0,0 +1,165 @@
+package b2
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"net/http"
+	"sync"
+)
+
+const authorizeURL = "https://api.backblazeb2.com/b2api/v2/b2_authorize_account"
+
+type authorization struct {
+	AccountId               string `json:"accountId"`
+	AuthorizationToken      string `json:"authorizationToken"`
+	ApiUrl                  string `json:"apiUrl"`
+	DownloadUrl             string `json:"downloadUrl"`
+	RecommendedPartSize     int64  `json:"recommendedPartSize"`
+	AbsoluteMinimumPartSize int64  `json:"absoluteMinimumPartSize"`
+}
+
+type bucket struct {
+	BucketId   string `json:"bucketId"`
+	BucketName string `json:"bucketName"`
+}
+
+type listBucketsResult struct {
+	Buckets []bucket `json:"buckets"`
+}
+
+type file struct {
+	FileId          string            `json:"fileId"`
+	FileName        string            `json:"fileName"`
+	ContentLength   int64             `json:"contentLength"`
+	ContentSha1     string            `json:"contentSha1"`
+	UploadTimestamp int64             `json:"uploadTimestamp"`
+	Action          string            `json:"action"`
+	FileInfo        map[string]string `json:"fileInfo"`
+}
+
+type listFilesResult struct {
+	Files        []file  `json:"files"`
+	NextFileName *string `json:"nextFileName"`
+	NextFileId   *string `json:"nextFileId"`
+}
+
+type uploadURL struct {
+	UploadUrl          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (b b2Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", b.Status, b.Code, b.Message)
+}
+
+// client calls the B2 API, the account authorization is cached,
+// and it's refreshed when the API responds 401 for the expired token
+type client struct {
+	keyId          string
+	applicationKey string
+
+	c *req.Client
+
+	mux  sync.Mutex
+	auth *authorization
+}
+
+func newClient(keyId, applicationKey string) (*client, error) {
+	c := &client{keyId: keyId, applicationKey: applicationKey}
+	rc, e := req.NewClient("", nil, ifApiCallError, nil)
+	if e != nil {
+		return nil, e
+	}
+	c.c = rc
+	return c, nil
+}
+
+// authorization returns the cached authorization, or authorizes the account if refresh is true or nothing cached
+func (c *client) authorization(ctx context.Context, refresh bool) (*authorization, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.auth != nil && !refresh {
+		return c.auth, nil
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(c.keyId + ":" + c.applicationKey))
+	resp, e := c.c.Get(ctx, authorizeURL, types.SM{"Authorization": "Basic " + credentials})
+	if e != nil {
+		if be, ok := e.(b2Error); ok && be.Status == http.StatusUnauthorized {
+			return nil, err.NewUnauthorizedError(i18n.T("drive.b2.invalid_key"))
+		}
+		return nil, e
+	}
+	auth := &authorization{}
+	if e := resp.Json(auth); e != nil {
+		return nil, e
+	}
+	c.auth = auth
+	return auth, nil
+}
+
+// call calls the API by name, and decodes the result to res if it's not nil.
+// The account is authorized again if the token expired, and the call is retried once.
+func (c *client) call(ctx context.Context, name string, body interface{}, res interface{}) error {
+	for retried := false; ; retried = true {
+		auth, e := c.authorization(ctx, false)
+		if e != nil {
+			return e
+		}
+		resp, e := c.c.Post(ctx, auth.ApiUrl+"/b2api/v2/"+name,
+			types.SM{"Authorization": auth.AuthorizationToken}, req.NewJsonBody(body))
+		if e != nil {
+			if !retried && isTokenExpired(e) {
+				if _, e := c.authorization(ctx, true); e != nil {
+					return e
+				}
+				continue
+			}
+			return toDriveError(e)
+		}
+		if res == nil {
+			return resp.Dispose()
+		}
+		return resp.Json(res)
+	}
+}
+
+func isTokenExpired(e error) bool {
+	be, ok := e.(b2Error)
+	return ok && be.Status == http.StatusUnauthorized &&
+		(be.Code == "expired_auth_token" || be.Code == "bad_auth_token")
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	be := b2Error{}
+	if e := resp.Json(&be); e != nil {
+		return err.NewRemoteApiError(500, i18n.T("drive.b2.remote_error", resp.Response().Status))
+	}
+	be.Status = resp.Status()
+	return be
+}
+
+// toDriveError converts the errors responded by B2 to the errors of the drive
+func toDriveError(e error) error {
+	be, ok := e.(b2Error)
+	if !ok {
+		return e
+	}
+	if be.Status == http.StatusNotFound || be.Code == "not_found" || be.Code == "file_not_present" {
+		return err.NewNotFoundError()
+	}
+	return err.NewRemoteApiError(500, i18n.T("drive.b2.remote_error", be.Error()))
+}
@@ -0,0 +1,638 @@
+package b2
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "b2",
+		DisplayName: i18n.T("drive.b2.name"),
+		README:      i18n.T("drive.b2.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "key_id", Label: i18n.T("drive.b2.form.key_id.label"), Type: "text", Required: true},
+			{Field: "application_key", Label: i18n.T("drive.b2.form.application_key.label"), Type: "password", Required: true},
+			{Field: "bucket", Label: i18n.T("drive.b2.form.bucket.label"), Type: "text", Required: true},
+			{Field: "cache_ttl", Label: i18n.T("drive.b2.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.b2.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewB2Drive},
+	})
+}
+
+const (
+	// dirPlaceholder is the empty file marking a directory, as B2 does
+	dirPlaceholder = ".bzEmpty"
+	listPageSize   = 1000
+	// sha1AtEnd makes B2 read the SHA1 of the content from the last 40 bytes of the body,
+	// so the content is verified without being read twice
+	sha1AtEnd = "hex_digits_at_end"
+)
+
+// B2Drive is the drive of a Backblaze B2 bucket, by the B2 native API
+type B2Drive struct {
+	c          *client
+	bucketId   string
+	bucketName string
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+
+	tempDir string
+}
+
+func NewB2Drive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	c, e := newClient(config["key_id"], config["application_key"])
+	if e != nil {
+		return nil, e
+	}
+	auth, e := c.authorization(ctx, false)
+	if e != nil {
+		return nil, e
+	}
+	buckets := listBucketsResult{}
+	if e := c.call(ctx, "b2_list_buckets",
+		types.M{"accountId": auth.AccountId, "bucketName": config["bucket"]}, &buckets); e != nil {
+		return nil, e
+	}
+	if len(buckets.Buckets) == 0 {
+		return nil, err.NewNotFoundMessageError(i18n.T("drive.b2.bucket_not_exists", config["bucket"]))
+	}
+
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	b := &B2Drive{
+		c:          c,
+		bucketId:   buckets.Buckets[0].BucketId,
+		bucketName: buckets.Buckets[0].BucketName,
+		cacheTTL:   cacheTtl,
+		tempDir:    driveUtils.Config.TempDir,
+	}
+	if cacheTtl <= 0 {
+		b.cache = drive_util.DummyCache()
+	} else {
+		b.cache = driveUtils.CreateCache(b.deserializeEntry, nil)
+	}
+	return b, nil
+}
+
+func (b *B2Drive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (b *B2Drive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &b2Entry{d: b, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := b.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	entry, e := b.get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	_ = b.cache.PutEntry(entry, b.cacheTTL)
+	return entry, nil
+}
+
+// get finds the file named path, or the directory if any file is prefixed with path + '/'
+func (b *B2Drive) get(ctx context.Context, path string) (*b2Entry, error) {
+	res := listFilesResult{}
+	if e := b.c.call(ctx, "b2_list_file_names", types.M{
+		"bucketId": b.bucketId, "startFileName": path, "prefix": path, "maxFileCount": 1,
+	}, &res); e != nil {
+		return nil, e
+	}
+	if len(res.Files) > 0 && res.Files[0].FileName == path {
+		return b.newEntry(res.Files[0]), nil
+	}
+	res = listFilesResult{}
+	if e := b.c.call(ctx, "b2_list_file_names", types.M{
+		"bucketId": b.bucketId, "prefix": path + "/", "maxFileCount": 1,
+	}, &res); e != nil {
+		return nil, e
+	}
+	if len(res.Files) > 0 {
+		return &b2Entry{d: b, path: path, isDir: true, modTime: -1}, nil
+	}
+	return nil, err.NewNotFoundError()
+}
+
+func (b *B2Drive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, b, path); e != nil {
+			return nil, e
+		}
+	}
+	if size < 0 {
+		// the sizes of the parts must be known
+		file, e := drive_util.CopyReaderToTempFile(task.NewCtxWrapper(ctx, false, false), reader, b.tempDir)
+		if e != nil {
+			return nil, e
+		}
+		defer func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}()
+		stat, e := file.Stat()
+		if e != nil {
+			return nil, e
+		}
+		size = stat.Size()
+		reader = file
+	}
+	ctx.Total(size, true)
+	auth, e := b.c.authorization(ctx, false)
+	if e != nil {
+		return nil, e
+	}
+	var f *file
+	if size > auth.RecommendedPartSize {
+		f, e = b.uploadLargeFile(ctx, path, size, auth.RecommendedPartSize, reader)
+	} else {
+		f, e = b.uploadFile(ctx, path, size, drive_util.ProgressReader(reader, ctx))
+	}
+	_ = b.cache.Evict(path, false)
+	_ = b.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	if override {
+		// the replaced versions are kept by B2
+		if e := b.deleteVersions(ctx, path, false, f.FileId); e != nil {
+			return nil, e
+		}
+	}
+	return b.newEntry(*f), nil
+}
+
+func (b *B2Drive) uploadFile(ctx context.Context, name string, size int64, reader io.Reader) (*file, error) {
+	u := uploadURL{}
+	if e := b.c.call(ctx, "b2_get_upload_url", types.M{"bucketId": b.bucketId}, &u); e != nil {
+		return nil, e
+	}
+	resp, e := b.c.c.Post(ctx, u.UploadUrl, types.SM{
+		"Authorization":                      u.AuthorizationToken,
+		"X-Bz-File-Name":                     encodeFileName(name),
+		"X-Bz-Content-Sha1":                  sha1AtEnd,
+		"X-Bz-Info-src_last_modified_millis": strconv.FormatInt(utils.Millisecond(time.Now()), 10),
+	}, autoTypeBody{withSha1AtEnd(reader), size + sha1HexSize})
+	if e != nil {
+		return nil, toDriveError(e)
+	}
+	f := &file{}
+	if e := resp.Json(f); e != nil {
+		return nil, e
+	}
+	return f, nil
+}
+
+// uploadLargeFile uploads the file by parts, the parts are verified by their SHA1 separately
+func (b *B2Drive) uploadLargeFile(ctx types.TaskCtx, name string, size, partSize int64, reader io.Reader) (*file, error) {
+	large := file{}
+	if e := b.c.call(ctx, "b2_start_large_file", types.M{
+		"bucketId": b.bucketId, "fileName": name, "contentType": "b2/x-auto",
+		"fileInfo": types.SM{"src_last_modified_millis": strconv.FormatInt(utils.Millisecond(time.Now()), 10)},
+	}, &large); e != nil {
+		return nil, e
+	}
+	f, e := b.uploadParts(ctx, large.FileId, size, partSize, reader)
+	if e != nil {
+		_ = b.c.call(task.NewCtxWrapper(ctx, false, false), "b2_cancel_large_file",
+			types.M{"fileId": large.FileId}, nil)
+		return nil, e
+	}
+	return f, nil
+}
+
+func (b *B2Drive) uploadParts(ctx types.TaskCtx, fileId string, size, partSize int64, reader io.Reader) (*file, error) {
+	u := uploadURL{}
+	if e := b.c.call(ctx, "b2_get_upload_part_url", types.M{"fileId": fileId}, &u); e != nil {
+		return nil, e
+	}
+	sha1s := make([]string, 0, size/partSize+1)
+	for offset := int64(0); offset < size; offset += partSize {
+		if e := ctx.WaitIfPaused(); e != nil {
+			return nil, e
+		}
+		n := partSize
+		if size-offset < n {
+			n = size - offset
+		}
+		part := withSha1AtEnd(drive_util.ProgressReader(io.LimitReader(reader, n), ctx))
+		resp, e := b.c.c.Post(ctx, u.UploadUrl, types.SM{
+			"Authorization":     u.AuthorizationToken,
+			"X-Bz-Part-Number":  strconv.Itoa(len(sha1s) + 1),
+			"X-Bz-Content-Sha1": sha1AtEnd,
+		}, req.NewReaderBody(part, n+sha1HexSize))
+		if e != nil {
+			return nil, toDriveError(e)
+		}
+		_ = resp.Dispose()
+		sha1s = append(sha1s, part.sum())
+	}
+	f := &file{}
+	if e := b.c.call(ctx, "b2_finish_large_file",
+		types.M{"fileId": fileId, "partSha1Array": sha1s}, f); e != nil {
+		return nil, e
+	}
+	return f, nil
+}
+
+func (b *B2Drive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := b.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	if _, e := b.uploadFile(ctx, path+"/"+dirPlaceholder, 0, strings.NewReader("")); e != nil {
+		return nil, e
+	}
+	_ = b.cache.Evict(utils.PathParent(path), false)
+	return &b2Entry{d: b, path: path, isDir: true, modTime: -1}, nil
+}
+
+func (b *B2Drive) isSelf(e types.IEntry) bool {
+	if be, ok := e.(*b2Entry); ok {
+		return be.d == b
+	}
+	return false
+}
+
+// Copy copies files on B2, directories are not supported
+func (b *B2Drive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, b.isSelf)
+	if from == nil || from.Type().IsDir() {
+		return nil, err.NewUnsupportedError()
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, b, to); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(from.Size(), false)
+	f := file{}
+	e := b.c.call(ctx, "b2_copy_file", types.M{
+		"sourceFileId": from.(*b2Entry).id, "fileName": to, "metadataDirective": "COPY",
+	}, &f)
+	_ = b.cache.Evict(to, false)
+	_ = b.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	if override {
+		if e := b.deleteVersions(ctx, to, false, f.FileId); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Progress(from.Size(), false)
+	return b.newEntry(f), nil
+}
+
+// Move copies the file, and deletes the source, as B2 can not rename files
+func (b *B2Drive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	entry, e := b.Copy(ctx, from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	if e := b.deleteVersions(ctx, from.Path(), false, ""); e != nil {
+		return nil, e
+	}
+	_ = b.cache.Evict(from.Path(), false)
+	_ = b.cache.Evict(utils.PathParent(from.Path()), false)
+	return entry, nil
+}
+
+func (b *B2Drive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := b.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	prefix := ""
+	if !utils.IsRootPath(path) {
+		prefix = path + "/"
+	}
+	entries := make([]types.IEntry, 0)
+	var next *string
+	for {
+		params := types.M{"bucketId": b.bucketId, "prefix": prefix, "delimiter": "/", "maxFileCount": listPageSize}
+		if next != nil {
+			params["startFileName"] = *next
+		}
+		res := listFilesResult{}
+		if e := b.c.call(ctx, "b2_list_file_names", params, &res); e != nil {
+			return nil, e
+		}
+		for _, f := range res.Files {
+			if f.Action == "folder" {
+				entries = append(entries, &b2Entry{
+					d: b, path: strings.TrimSuffix(f.FileName, "/"), isDir: true, modTime: -1,
+				})
+				continue
+			}
+			if f.Action == "upload" && utils.PathBase(f.FileName) != dirPlaceholder {
+				entries = append(entries, b.newEntry(f))
+			}
+		}
+		if res.NextFileName == nil {
+			break
+		}
+		next = res.NextFileName
+	}
+	_ = b.cache.PutChildren(path, entries, b.cacheTTL)
+	return entries, nil
+}
+
+func (b *B2Drive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := b.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	if entry.Type().IsDir() {
+		e = b.deleteVersions(ctx, path+"/", true, "")
+	} else {
+		e = b.deleteVersions(ctx, path, false, "")
+	}
+	_ = b.cache.Evict(path, true)
+	_ = b.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+// deleteVersions deletes all versions of the file name, or of all files prefixed with name if isPrefix,
+// except the version of keepId
+func (b *B2Drive) deleteVersions(ctx types.TaskCtx, name string, isPrefix bool, keepId string) error {
+	var nextName, nextId *string
+	for {
+		params := types.M{"bucketId": b.bucketId, "prefix": name, "maxFileCount": listPageSize}
+		if nextName != nil {
+			params["startFileName"] = *nextName
+		}
+		if nextId != nil {
+			params["startFileId"] = *nextId
+		}
+		res := listFilesResult{}
+		if e := b.c.call(ctx, "b2_list_file_versions", params, &res); e != nil {
+			return e
+		}
+		for _, f := range res.Files {
+			if (!isPrefix && f.FileName != name) || f.FileId == keepId {
+				continue
+			}
+			if ctx.Canceled() {
+				return task.ErrorCanceled
+			}
+			if e := b.c.call(ctx, "b2_delete_file_version",
+				types.M{"fileName": f.FileName, "fileId": f.FileId}, nil); e != nil {
+				return e
+			}
+			ctx.Progress(1, false)
+		}
+		if res.NextFileName == nil || (!isPrefix && *res.NextFileName != name) {
+			return nil
+		}
+		nextName, nextId = res.NextFileName, res.NextFileId
+	}
+}
+
+func (b *B2Drive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, b, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (b *B2Drive) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	if ec.Data == nil {
+		return nil, errors.New("invalid cache")
+	}
+	return &b2Entry{
+		d: b, path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+		id: ec.Data["id"], sha1: ec.Data["sha1"],
+	}, nil
+}
+
+func (b *B2Drive) newEntry(f file) *b2Entry {
+	modTime := f.UploadTimestamp
+	if m, e := strconv.ParseInt(f.FileInfo["src_last_modified_millis"], 10, 64); e == nil {
+		modTime = m
+	}
+	sha1 := f.ContentSha1
+	if sha1 == "none" || strings.HasPrefix(sha1, "unverified:") {
+		sha1 = ""
+	}
+	return &b2Entry{
+		d: b, id: f.FileId, path: f.FileName,
+		size: f.ContentLength, modTime: modTime, sha1: sha1,
+	}
+}
+
+// encodeFileName encodes the file name for the headers, the '/' is kept
+func encodeFileName(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+type b2Entry struct {
+	d       *B2Drive
+	id      string
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+	sha1    string
+}
+
+func (e *b2Entry) Path() string {
+	return e.path
+}
+
+func (e *b2Entry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *b2Entry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *b2Entry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *b2Entry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *b2Entry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *b2Entry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *b2Entry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.download(ctx, nil)
+}
+
+func (e *b2Entry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	rangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+	if length >= 0 {
+		if length == 0 {
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		}
+		rangeHeader += strconv.FormatInt(offset+length-1, 10)
+	}
+	return e.download(ctx, types.SM{"Range": rangeHeader})
+}
+
+func (e *b2Entry) download(ctx context.Context, header types.SM) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	for retried := false; ; retried = true {
+		u, ee := e.GetURL(ctx)
+		if ee != nil {
+			return nil, ee
+		}
+		for k, v := range header {
+			u.Header[k] = v
+		}
+		resp, ee := e.d.c.c.Get(ctx, u.URL, u.Header)
+		if ee != nil {
+			if !retried && isTokenExpired(ee) {
+				if _, ee := e.d.c.authorization(ctx, true); ee != nil {
+					return nil, ee
+				}
+				continue
+			}
+			return nil, toDriveError(ee)
+		}
+		return resp.Response().Body, nil
+	}
+}
+
+func (e *b2Entry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	auth, ee := e.d.c.authorization(ctx, false)
+	if ee != nil {
+		return nil, ee
+	}
+	return &types.ContentURL{
+		URL:    auth.DownloadUrl + "/file/" + url.PathEscape(e.d.bucketName) + "/" + encodeFileName(e.path),
+		Header: types.SM{"Authorization": auth.AuthorizationToken},
+		Proxy:  true,
+	}, nil
+}
+
+func (e *b2Entry) EntryData() types.SM {
+	return types.SM{"id": e.id, "sha1": e.sha1}
+}
+
+func (e *b2Entry) StableID() string {
+	return e.id
+}
+
+func (e *b2Entry) ContentHash(context.Context) (string, string, error) {
+	if e.sha1 == "" {
+		return "", "", err.NewUnsupportedError()
+	}
+	return "sha1", e.sha1, nil
+}
+
+// autoTypeBody is the body of the uploads, B2 sets the content type by the file name
+type autoTypeBody struct {
+	r    io.Reader
+	size int64
+}
+
+func (a autoTypeBody) ContentType() string {
+	return "b2/x-auto"
+}
+
+func (a autoTypeBody) Reader() io.Reader {
+	return a.r
+}
+
+func (a autoTypeBody) ContentLength() int64 {
+	return a.size
+}
+
+const sha1HexSize = sha1.Size * 2
+
+// sha1Reader reads the content, then the hex SHA1 of the content
+type sha1Reader struct {
+	r      io.Reader
+	h      hash.Hash
+	suffix []byte
+}
+
+func withSha1AtEnd(r io.Reader) *sha1Reader {
+	h := sha1.New()
+	return &sha1Reader{r: io.TeeReader(r, h), h: h}
+}
+
+func (s *sha1Reader) Read(p []byte) (int, error) {
+	if s.suffix == nil {
+		n, e := s.r.Read(p)
+		if e != io.EOF {
+			return n, e
+		}
+		s.suffix = []byte(s.sum())
+		if n > 0 {
+			return n, nil
+		}
+	}
+	if len(s.suffix) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.suffix)
+	s.suffix = s.suffix[n:]
+	return n, nil
+}
+
+// sum returns the hex SHA1 of the content read
+func (s *sha1Reader) sum() string {
+	return hex.EncodeToString(s.h.Sum(nil))
+}
@@ -0,0 +1,175 @@
+package baidu
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	apiURL    = "https://pan.baidu.com/rest/2.0/xpan"
+	uploadURL = "https://d.pcs.baidu.com/rest/2.0/pcs/superfile2"
+	// userAgent is required by the APIs and the download links
+	userAgent = "pan.baidu.com"
+)
+
+// the error numbers of the API
+const (
+	errnoAuthFailed   = -6
+	errnoFileExists   = -8
+	errnoNotFound     = -9
+	errnoQuotaFull    = -10
+	errnoTokenExpired = 111
+	// errnoBatchFailed is returned if some files of the file manager failed, the errors are in the info
+	errnoBatchFailed  = 12
+	errnoTokenInvalid = 31045
+	errnoMetaNotFound = 31066
+)
+
+// apiError is the error of the xpan APIs or the PCS APIs
+type apiError struct {
+	Errno     int    `json:"errno"`
+	ErrorCode int    `json:"error_code"`
+	ErrorMsg  string `json:"error_msg"`
+	Errmsg    string `json:"errmsg"`
+}
+
+func (a apiError) code() int {
+	if a.Errno != 0 {
+		return a.Errno
+	}
+	return a.ErrorCode
+}
+
+func (a apiError) Error() string {
+	msg := a.Errmsg
+	if msg == "" {
+		msg = a.ErrorMsg
+	}
+	if msg == "" {
+		return "errno " + strconv.Itoa(a.code())
+	}
+	return msg + " (" + strconv.Itoa(a.code()) + ")"
+}
+
+type userInfo struct {
+	BaiduName   string `json:"baidu_name"`
+	NetdiskName string `json:"netdisk_name"`
+}
+
+type fileInfo struct {
+	FsId       int64  `json:"fs_id"`
+	Path       string `json:"path"`
+	Name       string `json:"server_filename"`
+	Size       int64  `json:"size"`
+	IsDir      int    `json:"isdir"`
+	ServerTime int64  `json:"server_mtime"`
+	Dlink      string `json:"dlink"`
+}
+
+type listResult struct {
+	List []fileInfo `json:"list"`
+}
+
+// fileManagerResult is the result of the copy, move, rename and delete operations
+type fileManagerResult struct {
+	Info []struct {
+		Errno int    `json:"errno"`
+		Path  string `json:"path"`
+	} `json:"info"`
+}
+
+type precreateResult struct {
+	// ReturnType is 2 if the file is uploaded by the rapid upload
+	ReturnType int    `json:"return_type"`
+	UploadId   string `json:"uploadid"`
+	// BlockList is the indexes of the blocks to be uploaded
+	BlockList []int    `json:"block_list"`
+	Info      fileInfo `json:"info"`
+}
+
+type deviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	QrcodeURL       string `json:"qrcode_url"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Interval        int64  `json:"interval"`
+}
+
+type deviceToken struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// ifApiCallError checks the error numbers in the JSON responses, the APIs may respond errors with the status 200
+func ifApiCallError(resp req.Response) error {
+	isJson := strings.Contains(resp.Response().Header.Get("Content-Type"), "json")
+	if resp.Status() >= 200 && resp.Status() < 300 && !isJson {
+		return nil
+	}
+	ae := apiError{}
+	if isJson {
+		if e := resp.Json(&ae); e != nil {
+			ae.Errmsg = resp.Response().Status
+		}
+	}
+	if resp.Status() >= 200 && resp.Status() < 300 && (ae.code() == 0 || ae.code() == errnoBatchFailed) {
+		// the errors of the file manager are checked by the caller
+		return nil
+	}
+	if resp.Status() == http.StatusNotFound {
+		return err.NewNotFoundError()
+	}
+	if ae.code() == 0 {
+		ae.Errmsg = resp.Response().Status
+	}
+	return toDriveError(ae)
+}
+
+func toDriveError(ae apiError) error {
+	switch ae.code() {
+	case errnoNotFound, errnoMetaNotFound:
+		return err.NewNotFoundError()
+	case errnoFileExists:
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	case errnoQuotaFull:
+		return err.NewNotAllowedMessageError(i18n.T("drive.baidu.quota_full"))
+	case errnoAuthFailed, errnoTokenExpired, errnoTokenInvalid:
+		return err.NewUnauthorizedError(i18n.T("drive.baidu.unauthorized", ae.Error()))
+	}
+	return err.NewRemoteApiError(500, i18n.T("drive.baidu.remote_error", ae.Error()))
+}
+
+// typedBody is the request body with the content type, like the forms and the multipart forms
+type typedBody struct {
+	b []byte
+	t string
+}
+
+func (b *typedBody) ContentType() string {
+	return b.t
+}
+
+func (b *typedBody) Reader() io.Reader {
+	return bytes.NewReader(b.b)
+}
+
+func (b *typedBody) ContentLength() int64 {
+	return int64(len(b.b))
+}
+
+// marshal returns the JSON string of v, used in the form parameters
+func marshal(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,72 @@
+package baidu
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"hash"
+)
+
+const (
+	// blockSize is the size of the blocks of the uploads
+	blockSize = 4 * 1024 * 1024
+	// sliceSize is the size of the head of the file hashed by slice-md5
+	sliceSize = 256 * 1024
+)
+
+// blockHasher computes the MD5s required by the rapid upload: the MD5s of the blocks,
+// the MD5 of the content, and the MD5 of the first 256KB
+type blockHasher struct {
+	content hash.Hash
+	slice   hash.Hash
+	block   hash.Hash
+
+	written   int64
+	blockSize int64
+	blocks    []string
+}
+
+func newBlockHasher() *blockHasher {
+	return &blockHasher{content: md5.New(), slice: md5.New(), block: md5.New()}
+}
+
+func (h *blockHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	_, _ = h.content.Write(p)
+	if h.written < sliceSize {
+		s := p
+		if int64(len(s)) > sliceSize-h.written {
+			s = s[:sliceSize-h.written]
+		}
+		_, _ = h.slice.Write(s)
+	}
+	h.written += int64(n)
+	for len(p) > 0 {
+		b := p
+		if int64(len(b)) > blockSize-h.blockSize {
+			b = b[:blockSize-h.blockSize]
+		}
+		_, _ = h.block.Write(b)
+		h.blockSize += int64(len(b))
+		p = p[len(b):]
+		if h.blockSize == blockSize {
+			h.endBlock()
+		}
+	}
+	return n, nil
+}
+
+func (h *blockHasher) endBlock() {
+	h.blocks = append(h.blocks, hex.EncodeToString(h.block.Sum(nil)))
+	h.block.Reset()
+	h.blockSize = 0
+}
+
+// Sum returns the MD5s of the blocks, the MD5 of the content, and the MD5 of the first 256KB
+func (h *blockHasher) Sum() ([]string, string, string) {
+	blocks := append([]string{}, h.blocks...)
+	// the empty file has one empty block
+	if h.blockSize > 0 || len(blocks) == 0 {
+		blocks = append(blocks, hex.EncodeToString(h.block.Sum(nil)))
+	}
+	return blocks, hex.EncodeToString(h.content.Sum(nil)), hex.EncodeToString(h.slice.Sum(nil))
+}
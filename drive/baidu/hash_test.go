@@ -0,0 +1,48 @@
+package baidu
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+)
+
+func md5Hex(b []byte) string {
+	s := md5.Sum(b)
+	return hex.EncodeToString(s[:])
+}
+
+func TestBaiduBlockHasher(t *testing.T) {
+	data := bytes.Repeat([]byte("go-drive"), (2*blockSize+100)/8)
+	h := newBlockHasher()
+	for i := 0; i < len(data); i += 1000 {
+		end := i + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		_, _ = h.Write(data[i:end])
+	}
+	blocks, content, slice := h.Sum()
+	expected := []string{md5Hex(data[:blockSize]), md5Hex(data[blockSize : 2*blockSize]), md5Hex(data[2*blockSize:])}
+	if len(blocks) != len(expected) {
+		t.Fatalf("unexpected blocks: %v", blocks)
+	}
+	for i := range blocks {
+		if blocks[i] != expected[i] {
+			t.Errorf("unexpected block %d: %s", i, blocks[i])
+		}
+	}
+	if content != md5Hex(data) {
+		t.Errorf("unexpected content-md5: %s", content)
+	}
+	if slice != md5Hex(data[:sliceSize]) {
+		t.Errorf("unexpected slice-md5: %s", slice)
+	}
+}
+
+func TestBaiduBlockHasherEmpty(t *testing.T) {
+	blocks, content, _ := newBlockHasher().Sum()
+	if len(blocks) != 1 || blocks[0] != md5Hex(nil) || content != md5Hex(nil) {
+		t.Errorf("unexpected sums of the empty file: %v %s", blocks, content)
+	}
+}
@@ -0,0 +1,495 @@
+package baidu
+
+import (
+	"bytes"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"golang.org/x/oauth2"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/url"
+	"os"
+	path2 "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "baidu",
+		DisplayName: i18n.T("drive.baidu.name"),
+		README:      i18n.T("drive.baidu.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "client_id", Label: i18n.T("drive.baidu.form.client_id.label"), Type: "text", Required: true},
+			{Field: "client_secret", Label: i18n.T("drive.baidu.form.client_secret.label"), Type: "password", Required: true},
+			{Field: "root", Label: i18n.T("drive.baidu.form.root.label"), Type: "text", Description: i18n.T("drive.baidu.form.root.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.baidu.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.baidu.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewBaiduDrive, InitConfig: InitConfig, Init: Init},
+	})
+}
+
+// listLimit is the limit of the file list API, which suggests at most 1000 files a request
+const listLimit = 1000
+
+type BaiduDrive struct {
+	// c calls the APIs, dc downloads the files
+	c  *req.Client
+	dc *req.Client
+	ts oauth2.TokenSource
+
+	// root is the path of the root of the drive in Baidu Netdisk, it's empty or starts with '/'
+	root    string
+	tempDir string
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+}
+
+// NewBaiduDrive creates the drive of Baidu Netdisk by the open platform API
+func NewBaiduDrive(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	resp, e := drive_util.OAuthGet(*oauthReq(), config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	root := utils.CleanPath(config["root"])
+	if root != "" {
+		root = "/" + root
+	}
+	d := &BaiduDrive{
+		ts:       newTokenSource(resp, driveUtils.Data),
+		root:     root,
+		tempDir:  driveUtils.Config.TempDir,
+		cacheTTL: cacheTtl,
+	}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+	if d.c, e = newApiClient(d.ts, ifApiCallError); e != nil {
+		return nil, e
+	}
+	if d.dc, e = newApiClient(d.ts, ifDownloadError); e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+// remotePath returns the path in Baidu Netdisk
+func (d *BaiduDrive) remotePath(path string) string {
+	if utils.IsRootPath(path) {
+		if d.root == "" {
+			return "/"
+		}
+		return d.root
+	}
+	return d.root + "/" + path
+}
+
+// entryPath returns the path in the drive of the path in Baidu Netdisk
+func (d *BaiduDrive) entryPath(remotePath string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(remotePath, d.root), "/")
+}
+
+func (d *BaiduDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+// Get finds the entry in the listing of the parent, as the metas of Baidu are only got by the fs_ids
+func (d *BaiduDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &baiduEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	children, e := d.List(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	for _, c := range children {
+		if c.Path() == path {
+			_ = d.cache.PutEntry(c, d.cacheTTL)
+			return c, nil
+		}
+	}
+	return nil, err.NewNotFoundError()
+}
+
+// Save uploads the file by the rapid upload if Baidu has the same content, or by blocks,
+// the file is buffered in a temp file, as the MD5s of the blocks are required before uploading
+func (d *BaiduDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(size, true)
+	hasher := newBlockHasher()
+	file, e := drive_util.CopyReaderToTempFile(task.NewCtxWrapper(ctx, false, false),
+		io.TeeReader(reader, hasher), d.tempDir)
+	if e != nil {
+		return nil, e
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	size = hasher.written
+	ctx.Total(size, true)
+	blocks, contentMd5, sliceMd5 := hasher.Sum()
+
+	rtype := "0"
+	if override {
+		rtype = "3"
+	}
+	remotePath := d.remotePath(path)
+	resp, e := d.c.Post(ctx, apiURL+"/file?method=precreate", nil, req.NewURLEncodedBody(types.SM{
+		"path": remotePath, "size": strconv.FormatInt(size, 10), "isdir": "0", "autoinit": "1", "rtype": rtype,
+		"block_list": marshal(blocks), "content-md5": contentMd5, "slice-md5": sliceMd5,
+	}))
+	if e != nil {
+		return nil, e
+	}
+	pre := precreateResult{}
+	if e := resp.Json(&pre); e != nil {
+		return nil, e
+	}
+	if pre.ReturnType != 2 {
+		if e := d.uploadBlocks(ctx, file, remotePath, pre); e != nil {
+			return nil, e
+		}
+		resp, e = d.c.Post(ctx, apiURL+"/file?method=create", nil, req.NewURLEncodedBody(types.SM{
+			"path": remotePath, "size": strconv.FormatInt(size, 10), "isdir": "0", "rtype": rtype,
+			"uploadid": pre.UploadId, "block_list": marshal(blocks),
+		}))
+		if e != nil {
+			return nil, e
+		}
+		_ = resp.Dispose()
+	}
+	// the blocks skipped by the rapid upload are done as well
+	ctx.Progress(size, true)
+	_ = d.cache.Evict(path, false)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return d.Get(ctx, path)
+}
+
+// uploadBlocks uploads the blocks which Baidu does not have
+func (d *BaiduDrive) uploadBlocks(ctx types.TaskCtx, file *os.File, remotePath string, pre precreateResult) error {
+	buf := make([]byte, blockSize)
+	for _, seq := range pre.BlockList {
+		if e := ctx.WaitIfPaused(); e != nil {
+			return e
+		}
+		n, e := file.ReadAt(buf, int64(seq)*blockSize)
+		if e != nil && e != io.EOF {
+			return e
+		}
+		body := &bytes.Buffer{}
+		w := multipart.NewWriter(body)
+		fw, e := w.CreateFormFile("file", "blob")
+		if e != nil {
+			return e
+		}
+		if _, e := io.Copy(fw, drive_util.ProgressReader(bytes.NewReader(buf[:n]), ctx)); e != nil {
+			return e
+		}
+		if e := w.Close(); e != nil {
+			return e
+		}
+		resp, e := d.c.Post(ctx, uploadURL+"?"+url.Values{
+			"method": {"upload"}, "type": {"tmpfile"}, "path": {remotePath},
+			"uploadid": {pre.UploadId}, "partseq": {strconv.Itoa(seq)},
+		}.Encode(), nil, &typedBody{b: body.Bytes(), t: w.FormDataContentType()})
+		if e != nil {
+			return e
+		}
+		_ = resp.Dispose()
+	}
+	return nil
+}
+
+func (d *BaiduDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := d.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	resp, e := d.c.Post(ctx, apiURL+"/file?method=create", nil, req.NewURLEncodedBody(types.SM{
+		"path": d.remotePath(path), "isdir": "1", "rtype": "0",
+	}))
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return d.Get(ctx, path)
+}
+
+func (d *BaiduDrive) isSelf(e types.IEntry) bool {
+	if be, ok := e.(*baiduEntry); ok {
+		return be.d == d
+	}
+	return false
+}
+
+// fileManager copies, moves or deletes the files synchronously
+func (d *BaiduDrive) fileManager(ctx context.Context, opera string, fileList interface{}) error {
+	resp, e := d.c.Post(ctx, apiURL+"/file?method=filemanager&opera="+opera, nil, req.NewURLEncodedBody(types.SM{
+		"async": "0", "filelist": marshal(fileList),
+	}))
+	if e != nil {
+		return e
+	}
+	r := fileManagerResult{}
+	if e := resp.Json(&r); e != nil {
+		return e
+	}
+	for _, i := range r.Info {
+		if i.Errno != 0 {
+			return toDriveError(apiError{Errno: i.Errno})
+		}
+	}
+	return nil
+}
+
+// copyOrMove copies or moves the file or the directory natively
+func (d *BaiduDrive) copyOrMove(ctx types.TaskCtx, opera string, from types.IEntry,
+	to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	ondup := "fail"
+	if override {
+		ondup = "overwrite"
+	}
+	ctx.Total(from.Size(), false)
+	e := d.fileManager(ctx, opera, []types.SM{{
+		"path":    d.remotePath(from.Path()),
+		"dest":    d.remotePath(utils.PathParent(to)),
+		"newname": utils.PathBase(to),
+		"ondup":   ondup,
+	}})
+	_ = d.cache.Evict(to, true)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	ctx.Progress(from.Size(), false)
+	return d.Get(ctx, to)
+}
+
+func (d *BaiduDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	return d.copyOrMove(ctx, "copy", from, to, override)
+}
+
+func (d *BaiduDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	entry, e := d.copyOrMove(ctx, "move", from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	_ = d.cache.Evict(from.Path(), true)
+	_ = d.cache.Evict(utils.PathParent(from.Path()), false)
+	return entry, nil
+}
+
+// List lists the directory page by page
+func (d *BaiduDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	entries := make([]types.IEntry, 0)
+	for start := 0; ; start += listLimit {
+		resp, e := d.c.Get(ctx, apiURL+"/file?"+url.Values{
+			"method": {"list"},
+			"dir":    {d.remotePath(path)},
+			"start":  {strconv.Itoa(start)},
+			"limit":  {strconv.Itoa(listLimit)},
+		}.Encode(), nil)
+		if e != nil {
+			return nil, e
+		}
+		r := listResult{}
+		if e := resp.Json(&r); e != nil {
+			return nil, e
+		}
+		for _, f := range r.List {
+			entries = append(entries, d.newEntry(f))
+		}
+		if len(r.List) < listLimit {
+			break
+		}
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+// Delete deletes the file or the directory, which is moved to the recycle bin of Baidu Netdisk
+func (d *BaiduDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	e := d.fileManager(ctx, "delete", []string{d.remotePath(path)})
+	_ = d.cache.Evict(path, true)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+func (d *BaiduDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *BaiduDrive) newEntry(f fileInfo) *baiduEntry {
+	return &baiduEntry{
+		d:       d,
+		fsId:    f.FsId,
+		path:    d.entryPath(path2.Clean(f.Path)),
+		isDir:   f.IsDir == 1,
+		size:    f.Size,
+		modTime: f.ServerTime * 1000,
+	}
+}
+
+type baiduEntry struct {
+	d       *BaiduDrive
+	fsId    int64
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+}
+
+func (e *baiduEntry) Path() string {
+	return e.path
+}
+
+func (e *baiduEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *baiduEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *baiduEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *baiduEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *baiduEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *baiduEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+// dlink returns the download link of the file, which requires the token and the User-Agent of Baidu
+func (e *baiduEntry) dlink(ctx context.Context) (string, error) {
+	resp, ee := e.d.c.Get(ctx, apiURL+"/multimedia?"+url.Values{
+		"method": {"filemetas"}, "fsids": {"[" + strconv.FormatInt(e.fsId, 10) + "]"}, "dlink": {"1"},
+	}.Encode(), nil)
+	if ee != nil {
+		return "", ee
+	}
+	r := listResult{}
+	if ee := resp.Json(&r); ee != nil {
+		return "", ee
+	}
+	if len(r.List) == 0 || r.List[0].Dlink == "" {
+		return "", err.NewNotFoundError()
+	}
+	return r.List[0].Dlink, nil
+}
+
+func (e *baiduEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+func (e *baiduEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	dlink, ee := e.dlink(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	var header types.SM
+	if offset > 0 || length >= 0 {
+		rangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if length >= 0 {
+			if length == 0 {
+				return ioutil.NopCloser(strings.NewReader("")), nil
+			}
+			rangeHeader += strconv.FormatInt(offset+length-1, 10)
+		}
+		header = types.SM{"Range": rangeHeader}
+	}
+	resp, ee := e.d.dc.Get(ctx, dlink, header)
+	if ee != nil {
+		return nil, ee
+	}
+	return resp.Response().Body, nil
+}
+
+// GetURL returns the download link with the token, the downloads are always proxied,
+// as the link and the redirected URL require the User-Agent of Baidu
+func (e *baiduEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	dlink, ee := e.dlink(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	t, ee := e.d.ts.Token()
+	if ee != nil {
+		return nil, ee
+	}
+	return &types.ContentURL{
+		URL:    dlink + "&access_token=" + url.QueryEscape(t.AccessToken),
+		Header: types.SM{"User-Agent": userAgent},
+		Proxy:  true,
+	}, nil
+}
+
+func (e *baiduEntry) EntryData() types.SM {
+	return types.SM{"id": strconv.FormatInt(e.fsId, 10)}
+}
+
+func (e *baiduEntry) StableID() string {
+	return strconv.FormatInt(e.fsId, 10)
+}
@@ -0,0 +1,207 @@
+package baidu
+
+import (
+	"context"
+	"errors"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const oauthURL = "https://openapi.baidu.com/oauth/2.0"
+
+func oauthReq() *drive_util.OAuthRequest {
+	return &drive_util.OAuthRequest{
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   oauthURL + "/authorize",
+			TokenURL:  oauthURL + "/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+func newTokenSource(resp *drive_util.OAuthResponse, ds drive_util.DriveDataStore) oauth2.TokenSource {
+	// the refresh tokens of Baidu can be used only once
+	return drive_util.PersistentTokenSource(resp.TokenSource(nil), resp.Token, ds)
+}
+
+// newApiClient returns the client of the APIs, the token is sent in the query,
+// and the User-Agent required by Baidu is set
+func newApiClient(ts oauth2.TokenSource, after func(req.Response) error) (*req.Client, error) {
+	return req.NewClient("", func(r *http.Request) error {
+		t, e := ts.Token()
+		if e != nil {
+			return e
+		}
+		q := r.URL.Query()
+		q.Set("access_token", t.AccessToken)
+		r.URL.RawQuery = q.Encode()
+		r.Header.Set("User-Agent", userAgent)
+		return nil
+	}, after, nil)
+}
+
+// ifDownloadError checks the status only, the content of the files must not be read
+func ifDownloadError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	_ = resp.Dispose()
+	if resp.Status() == http.StatusNotFound {
+		return err.NewNotFoundError()
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.baidu.remote_error", resp.Response().Status))
+}
+
+// InitConfig shows the user code of the device code flow,
+// the user enters it on the verification page of Baidu, and saves the form to finish the authorization
+func InitConfig(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (*drive_util.DriveInitConfig, error) {
+	initConfig := &drive_util.DriveInitConfig{}
+	description := ""
+	if resp, e := drive_util.OAuthGet(*oauthReq(), config, driveUtils.Data); e == nil {
+		c, e := newApiClient(newTokenSource(resp, driveUtils.Data), ifApiCallError)
+		if e != nil {
+			return nil, e
+		}
+		user := userInfo{}
+		r, e := c.Get(ctx, apiURL+"/nas?method=uinfo", nil)
+		if e == nil {
+			e = r.Json(&user)
+		}
+		initConfig.Configured = e == nil
+		if e == nil {
+			description = i18n.T("drive.baidu.connected", user.BaiduName) + " "
+		}
+	}
+	dc, e := loadDeviceCode(ctx, config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	initConfig.Form = []types.FormItem{
+		{
+			Field: "user_code", Label: i18n.T("drive.baidu.form.user_code.label"), Type: "text",
+			Description: description + i18n.T("drive.baidu.form.user_code.description", dc.VerificationURL),
+		},
+	}
+	initConfig.Value = types.SM{"user_code": dc.UserCode}
+	return initConfig, nil
+}
+
+// loadDeviceCode loads the device code, a new one is requested if it's missing or expired
+func loadDeviceCode(ctx context.Context, config drive_util.DriveConfig, ds drive_util.DriveDataStore) (*deviceCode, error) {
+	params, e := ds.Load("device_code", "user_code", "verification_url", "device_expires_at")
+	if e != nil {
+		return nil, e
+	}
+	// the code is renewed a minute before it expires, leaving time for the user to enter it
+	if params["device_code"] != "" &&
+		time.Now().Add(time.Minute).Before(time.Unix(utils.ToInt64(params["device_expires_at"], 0), 0)) {
+		return &deviceCode{
+			DeviceCode:      params["device_code"],
+			UserCode:        params["user_code"],
+			VerificationURL: params["verification_url"],
+		}, nil
+	}
+	resp, e := oauthCall(ctx, "/device/code?"+url.Values{
+		"response_type": {"device_code"},
+		"client_id":     {config["client_id"]},
+		"scope":         {"basic,netdisk"},
+	}.Encode())
+	if e != nil {
+		return nil, e
+	}
+	dc := &deviceCode{}
+	if e := resp.Json(dc); e != nil {
+		return nil, e
+	}
+	if dc.DeviceCode == "" {
+		return nil, err.NewRemoteApiError(500, i18n.T("drive.baidu.remote_error", "invalid device code"))
+	}
+	return dc, ds.Save(types.SM{
+		"device_code":       dc.DeviceCode,
+		"user_code":         dc.UserCode,
+		"verification_url":  dc.VerificationURL,
+		"device_expires_at": strconv.FormatInt(time.Now().Unix()+dc.ExpiresIn, 10),
+	})
+}
+
+// Init polls the token of the device code, it fails if the user has not authorized yet
+func Init(ctx context.Context, _ types.SM, config drive_util.DriveConfig, driveUtils drive_util.DriveUtils) error {
+	params, e := driveUtils.Data.Load("device_code")
+	if e != nil {
+		return e
+	}
+	if params["device_code"] == "" {
+		return err.NewNotAllowedMessageError(i18n.T("drive.baidu.device_code_expired"))
+	}
+	resp, e := oauthCall(ctx, "/token?"+url.Values{
+		"grant_type":    {"device_token"},
+		"code":          {params["device_code"]},
+		"client_id":     {config["client_id"]},
+		"client_secret": {config["client_secret"]},
+	}.Encode())
+	if e != nil {
+		return e
+	}
+	t := deviceToken{}
+	if e := resp.Json(&t); e != nil {
+		return e
+	}
+	switch t.Error {
+	case "":
+	case "authorization_pending", "slow_down":
+		return err.NewNotAllowedMessageError(i18n.T("drive.baidu.authorization_pending"))
+	case "expired_token":
+		_ = driveUtils.Data.Save(types.SM{"device_code": ""})
+		return err.NewNotAllowedMessageError(i18n.T("drive.baidu.device_code_expired"))
+	default:
+		return err.NewRemoteApiError(500, i18n.T("drive.baidu.remote_error", t.ErrorDescription))
+	}
+	if e := drive_util.SaveOAuthToken(driveUtils.Data, &oauth2.Token{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(t.ExpiresIn) * time.Second),
+	}); e != nil {
+		return e
+	}
+	// the device code can be used only once
+	return driveUtils.Data.Save(types.SM{"device_code": ""})
+}
+
+// oauthCall requests the OAuth API, which responds errors with the status 400
+func oauthCall(ctx context.Context, requestUrl string) (req.Response, error) {
+	c, e := req.NewClient(oauthURL, nil, func(resp req.Response) error {
+		if resp.Status() == http.StatusOK || resp.Status() == http.StatusBadRequest {
+			return nil
+		}
+		return err.NewRemoteApiError(resp.Status(), i18n.T("drive.baidu.remote_error", resp.Response().Status))
+	}, nil)
+	if e != nil {
+		return nil, e
+	}
+	return c.Get(ctx, requestUrl, nil)
+}
+
+func (d *BaiduDrive) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	ed := ec.Data
+	if ed == nil || (ed["id"] == "" && !utils.IsRootPath(ec.Path)) {
+		return nil, errors.New("invalid cache")
+	}
+	return &baiduEntry{
+		d: d, fsId: utils.ToInt64(ed["id"], 0),
+		path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+	}, nil
+}
@@ -0,0 +1,426 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"os"
+	path2 "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultMaxSize = "1G"
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "cache",
+		DisplayName: i18n.T("drive.cache.name"),
+		README:      i18n.T("drive.cache.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "path", Label: i18n.T("drive.cache.form.path.label"), Type: "text", Required: true, Description: i18n.T("drive.cache.form.path.description")},
+			{Field: "max_size", Label: i18n.T("drive.cache.form.max_size.label"), Type: "text", Description: i18n.T("drive.cache.form.max_size.description"), DefaultValue: defaultMaxSize},
+			{Field: "cache_ttl", Label: i18n.T("drive.cache.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.cache.form.cache_ttl.description"), DefaultValue: "10m"},
+		},
+		Factory: drive_util.DriveFactory{Create: NewCacheDrive},
+	})
+}
+
+// CacheDrive caches a directory of another mount on the local disk.
+// The files read wholly are kept by their paths, modification times and sizes, so the changed ones are read again,
+// and the listings are cached in the drive cache. The writes pass through to the directory.
+type CacheDrive struct {
+	root  func() types.IDrive
+	path  string
+	files *diskLRU
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+	// guard stops the drive being accessed through itself
+	guard *drive_util.RecursionGuard
+}
+
+func NewCacheDrive(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	if driveUtils.Root == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	maxSizeStr := config["max_size"]
+	if maxSizeStr == "" {
+		maxSizeStr = defaultMaxSize
+	}
+	maxSize, e := utils.ParseBytes(maxSizeStr)
+	if e != nil || maxSize == 0 {
+		return nil, err.NewBadRequestError(i18n.T("drive.cache.invalid_max_size", maxSizeStr))
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+
+	// the files are kept in the directory of the drive, which is named by the id saved in the drive data
+	data, e := driveUtils.Data.Load("cache_id")
+	if e != nil {
+		return nil, e
+	}
+	id := data["cache_id"]
+	if id == "" {
+		id = uuid.New().String()
+		if e := driveUtils.Data.Save(types.SM{"cache_id": id}); e != nil {
+			return nil, e
+		}
+	}
+	dir, e := driveUtils.Config.GetDir("cache", true)
+	if e != nil {
+		return nil, e
+	}
+	files, e := newDiskLRU(filepath.Join(dir, id), int64(maxSize))
+	if e != nil {
+		return nil, e
+	}
+
+	d := &CacheDrive{
+		root:     driveUtils.Root,
+		path:     utils.CleanPath(config["path"]),
+		files:    files,
+		cacheTTL: cacheTtl,
+		guard:    drive_util.NewRecursionGuard(i18n.T("drive.cache.recursive")),
+	}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+	return d, nil
+}
+
+// realPath returns the path of the entry in the root drive
+func (d *CacheDrive) realPath(path string) string {
+	return path2.Join(d.path, path)
+}
+
+func (d *CacheDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *CacheDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	entry, e := d.get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	_ = d.cache.PutEntry(entry, d.cacheTTL)
+	return entry, nil
+}
+
+// get gets the entry from the directory, without the drive cache
+func (d *CacheDrive) get(ctx context.Context, path string) (*cacheEntry, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Get(ctx, d.realPath(path))
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *CacheDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return nil, err.NewNotAllowedError()
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Save(ctx, d.realPath(path), size, override, reader)
+	_ = d.cache.Evict(path, false)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *CacheDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().MakeDir(ctx, d.realPath(path))
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *CacheDrive) isSelf(e types.IEntry) bool {
+	if ce, ok := e.(*cacheEntry); ok {
+		return ce.d == d
+	}
+	return false
+}
+
+// source returns the entry in the directory of from, which may be loaded from the drive cache
+func (d *CacheDrive) source(ctx context.Context, from types.IEntry) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	ce := from.(*cacheEntry)
+	if ce.entry != nil {
+		return ce.entry, nil
+	}
+	fresh, e := d.get(ctx, ce.path)
+	if e != nil {
+		return nil, e
+	}
+	return fresh.entry, nil
+}
+
+func (d *CacheDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	src, e := d.source(ctx, from)
+	if e != nil {
+		return nil, e
+	}
+	ctx, e = d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Copy(ctx, src, d.realPath(to), override)
+	_ = d.cache.Evict(to, true)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(to, entry), nil
+}
+
+func (d *CacheDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	src, e := d.source(ctx, from)
+	if e != nil {
+		return nil, e
+	}
+	fromPath := drive_util.GetIEntry(from, d.isSelf).Path()
+	ctx, e = d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Move(ctx, src, d.realPath(to), override)
+	_ = d.cache.Evict(to, true)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	_ = d.cache.Evict(fromPath, true)
+	_ = d.cache.Evict(utils.PathParent(fromPath), false)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(to, entry), nil
+}
+
+func (d *CacheDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entries, e := d.root().List(ctx, d.realPath(path))
+	if e != nil {
+		return nil, e
+	}
+	r := make([]types.IEntry, 0, len(entries))
+	for _, entry := range entries {
+		r = append(r, d.newEntry(path2.Join(path, utils.PathBase(entry.Path())), entry))
+	}
+	_ = d.cache.PutChildren(path, r, d.cacheTTL)
+	return r, nil
+}
+
+func (d *CacheDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return e
+	}
+	e = d.root().Delete(ctx, d.realPath(path))
+	_ = d.cache.Evict(path, true)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+func (d *CacheDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *CacheDrive) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	return &cacheEntry{
+		d: d, path: ec.Path, typ: ec.Type, size: ec.Size, modTime: ec.ModTime,
+		canWrite: ec.Data["w"] == "true",
+	}, nil
+}
+
+func (d *CacheDrive) newEntry(path string, entry types.IEntry) *cacheEntry {
+	return &cacheEntry{
+		d:        d,
+		path:     strings.TrimPrefix(path, "/"),
+		typ:      entry.Type(),
+		size:     entry.Size(),
+		modTime:  entry.ModTime(),
+		canWrite: entry.Meta().CanWrite,
+		entry:    entry,
+	}
+}
+
+type cacheEntry struct {
+	d        *CacheDrive
+	path     string
+	typ      types.EntryType
+	size     int64
+	modTime  int64
+	canWrite bool
+	// entry is the entry in the directory, it's nil if the entry is loaded from the drive cache
+	entry types.IEntry
+}
+
+func (e *cacheEntry) Path() string {
+	return e.path
+}
+
+func (e *cacheEntry) Type() types.EntryType {
+	return e.typ
+}
+
+func (e *cacheEntry) Size() int64 {
+	return e.size
+}
+
+func (e *cacheEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: e.canWrite}
+}
+
+func (e *cacheEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *cacheEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *cacheEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *cacheEntry) EntryData() types.SM {
+	return types.SM{"w": strconv.FormatBool(e.canWrite)}
+}
+
+// key returns the key of the content in the local cache, false if the content can't be cached,
+// as the changes of the files without modification times or sizes can't be known
+func (e *cacheEntry) key() (string, bool) {
+	if !e.typ.IsFile() || e.modTime <= 0 || e.size < 0 {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(e.path + "\n" + strconv.FormatInt(e.modTime, 10) + "\n" + strconv.FormatInt(e.size, 10)))
+	return hex.EncodeToString(sum[:]), true
+}
+
+// content returns the content in the directory
+func (e *cacheEntry) content(ctx context.Context) (types.IContent, error) {
+	if !e.typ.IsFile() {
+		return nil, err.NewNotAllowedError()
+	}
+	entry := e.entry
+	if entry == nil {
+		fresh, ee := e.d.get(ctx, e.path)
+		if ee != nil {
+			return nil, ee
+		}
+		entry = fresh.entry
+	}
+	c, ok := entry.(types.IContent)
+	if !ok {
+		return nil, err.NewNotAllowedError()
+	}
+	return c, nil
+}
+
+func (e *cacheEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+// GetRangeReader reads the content from the local cache if it's cached,
+// otherwise from the directory, and the content read wholly is cached
+func (e *cacheEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	key, cacheable := e.key()
+	if cacheable {
+		if f, ok := e.d.files.open(key); ok {
+			return fileRange(f, offset, length)
+		}
+	}
+	c, ee := e.content(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	ctx, ee = e.d.guard.Context(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	r, ee := drive_util.GetIContentRangeReader(ctx, c, offset, length)
+	if ee != nil {
+		return nil, ee
+	}
+	if cacheable && offset == 0 && length < 0 {
+		return e.d.files.cachingReader(r, key, e.size), nil
+	}
+	return r, nil
+}
+
+// GetURL is not supported, so that the content is read through the drive, which is served from the local cache
+func (e *cacheEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func fileRange(f *os.File, offset, length int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		if _, e := f.Seek(offset, io.SeekStart); e != nil {
+			_ = f.Close()
+			return nil, e
+		}
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return &readCloser{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
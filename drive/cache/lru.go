@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"container/list"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tempSuffix = ".tmp"
+
+// diskLRU keeps the files in a local directory, the least recently used ones are removed
+// when the total size exceeds maxSize. The order is kept by the modification time of the files,
+// so that it survives restarting.
+type diskLRU struct {
+	mux     *sync.Mutex
+	dir     string
+	maxSize int64
+	size    int64
+	// ll is the list of the files, the most recently used one is at the front
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key  string
+	size int64
+}
+
+func newDiskLRU(dir string, maxSize int64) (*diskLRU, error) {
+	if e := os.MkdirAll(dir, 0755); e != nil {
+		return nil, e
+	}
+	files, e := ioutil.ReadDir(dir)
+	if e != nil {
+		return nil, e
+	}
+	l := &diskLRU{
+		mux:     &sync.Mutex{},
+		dir:     dir,
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		// the files not completed before stopping
+		if strings.HasSuffix(f.Name(), tempSuffix) {
+			_ = os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		l.items[f.Name()] = l.ll.PushFront(&lruItem{key: f.Name(), size: f.Size()})
+		l.size += f.Size()
+	}
+	l.mux.Lock()
+	l.evict()
+	l.mux.Unlock()
+	return l, nil
+}
+
+func (l *diskLRU) path(key string) string {
+	return filepath.Join(l.dir, key)
+}
+
+// open opens the file of key, and marks it as the most recently used
+func (l *diskLRU) open(key string) (*os.File, bool) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	f, e := os.Open(l.path(key))
+	if e != nil {
+		l.removeElement(el)
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	now := time.Now()
+	_ = os.Chtimes(l.path(key), now, now)
+	return f, true
+}
+
+// tempFile creates the file to be put by put
+func (l *diskLRU) tempFile() (*os.File, error) {
+	return ioutil.TempFile(l.dir, "*"+tempSuffix)
+}
+
+// put moves the temp file to the file of key
+func (l *diskLRU) put(key string, tempFile string, size int64) error {
+	if size > l.maxSize {
+		_ = os.Remove(tempFile)
+		return nil
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if e := os.Rename(tempFile, l.path(key)); e != nil {
+		_ = os.Remove(tempFile)
+		return e
+	}
+	if el, ok := l.items[key]; ok {
+		l.size -= el.Value.(*lruItem).size
+		l.ll.Remove(el)
+	}
+	l.items[key] = l.ll.PushFront(&lruItem{key: key, size: size})
+	l.size += size
+	l.evict()
+	return nil
+}
+
+// evict removes the least recently used files until the total size fits
+func (l *diskLRU) evict() {
+	for l.size > l.maxSize {
+		el := l.ll.Back()
+		if el == nil {
+			return
+		}
+		l.removeElement(el)
+		_ = os.Remove(l.path(el.Value.(*lruItem).key))
+	}
+}
+
+func (l *diskLRU) removeElement(el *list.Element) {
+	item := el.Value.(*lruItem)
+	l.ll.Remove(el)
+	delete(l.items, item.key)
+	l.size -= item.size
+}
+
+// total returns the total size of the files
+func (l *diskLRU) total() int64 {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.size
+}
+
+// cachingReader copies the content read to the temp file,
+// which is put to the cache when closing if the whole content is read
+type cachingReader struct {
+	r    io.ReadCloser
+	l    *diskLRU
+	f    *os.File
+	key  string
+	size int64
+	n    int64
+	// failed is whether writing the temp file failed
+	failed bool
+}
+
+func (l *diskLRU) cachingReader(r io.ReadCloser, key string, size int64) io.ReadCloser {
+	if size > l.maxSize {
+		return r
+	}
+	f, e := l.tempFile()
+	if e != nil {
+		return r
+	}
+	return &cachingReader{r: r, l: l, f: f, key: key, size: size}
+}
+
+func (c *cachingReader) Read(p []byte) (int, error) {
+	n, e := c.r.Read(p)
+	if n > 0 && !c.failed {
+		if _, we := c.f.Write(p[:n]); we != nil {
+			c.failed = true
+		}
+	}
+	c.n += int64(n)
+	return n, e
+}
+
+func (c *cachingReader) Close() error {
+	e := c.r.Close()
+	if ce := c.f.Close(); ce != nil {
+		c.failed = true
+	}
+	if c.failed || c.n != c.size {
+		_ = os.Remove(c.f.Name())
+		return e
+	}
+	_ = c.l.put(c.key, c.f.Name(), c.size)
+	return e
+}
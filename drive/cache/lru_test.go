@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive/memory"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func putFile(t *testing.T, l *diskLRU, key, content string) {
+	f, e := l.tempFile()
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, _ = f.WriteString(content)
+	_ = f.Close()
+	if e := l.put(key, f.Name(), int64(len(content))); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestDiskLRU(t *testing.T) {
+	dir, e := ioutil.TempDir("", "go-drive-cache")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	l, e := newDiskLRU(dir, 10)
+	if e != nil {
+		t.Fatal(e)
+	}
+	putFile(t, l, "a", "aaaa")
+	putFile(t, l, "b", "bbbb")
+	if f, ok := l.open("a"); !ok {
+		t.Fatal("expect a cached")
+	} else {
+		_ = f.Close()
+	}
+	putFile(t, l, "c", "cccc")
+	if _, ok := l.open("b"); ok {
+		t.Error("expect the least recently used b evicted")
+	}
+	if l.total() != 8 {
+		t.Errorf("unexpected total size %d", l.total())
+	}
+	putFile(t, l, "large", "01234567890")
+	if _, ok := l.open("large"); ok {
+		t.Error("expect the file larger than the max size not cached")
+	}
+
+	// the files are loaded after restarting, and the temp files are removed
+	_ = ioutil.WriteFile(filepath.Join(dir, "x"+tempSuffix), []byte("x"), 0644)
+	l, e = newDiskLRU(dir, 10)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if l.total() != 8 {
+		t.Errorf("unexpected total size %d after restarting", l.total())
+	}
+	if _, e := os.Stat(filepath.Join(dir, "x"+tempSuffix)); !os.IsNotExist(e) {
+		t.Error("expect the temp file removed")
+	}
+	f, ok := l.open("c")
+	if !ok {
+		t.Fatal("expect c cached after restarting")
+	}
+	data, _ := ioutil.ReadAll(f)
+	_ = f.Close()
+	if string(data) != "cccc" {
+		t.Errorf("unexpected content %s", data)
+	}
+}
+
+// countingDrive is the root drive of the tests over the memory drive, which counts the reads of the files
+type countingDrive struct {
+	*memory.MemoryDrive
+	reads int
+}
+
+func (m *countingDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	entry, e := m.MemoryDrive.Get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	return &countingEntry{IEntry: entry, m: m}, nil
+}
+
+func (m *countingDrive) save(t *testing.T, path, content string) {
+	if _, e := m.Save(task.DummyContext(), path, int64(len(content)), true, strings.NewReader(content)); e != nil {
+		t.Fatal(e)
+	}
+}
+
+type countingEntry struct {
+	types.IEntry
+	m *countingDrive
+}
+
+func (e *countingEntry) Name() string {
+	return utils.PathBase(e.Path())
+}
+
+func (e *countingEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (e *countingEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	e.m.reads++
+	return e.IEntry.(types.IContent).GetReader(ctx)
+}
+
+func read(t *testing.T, d *CacheDrive, path string, offset, length int64) string {
+	entry, e := d.Get(context.Background(), path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	r, e := entry.(*cacheEntry).GetRangeReader(context.Background(), offset, length)
+	if e != nil {
+		t.Fatal(e)
+	}
+	data, e := ioutil.ReadAll(r)
+	if e != nil {
+		t.Fatal(e)
+	}
+	_ = r.Close()
+	return string(data)
+}
+
+func TestCacheRead(t *testing.T) {
+	dir, e := ioutil.TempDir("", "go-drive-cache")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	l, e := newDiskLRU(dir, 1024)
+	if e != nil {
+		t.Fatal(e)
+	}
+	root := &countingDrive{MemoryDrive: memory.New(1024)}
+	if _, e := root.MakeDir(context.Background(), "remote"); e != nil {
+		t.Fatal(e)
+	}
+	root.save(t, "remote/a.txt", "hello world")
+	d := &CacheDrive{root: func() types.IDrive { return root }, path: "remote", files: l, cache: drive_util.DummyCache(),
+		guard: drive_util.NewRecursionGuard("")}
+
+	if s := read(t, d, "a.txt", 6, 5); s != "world" || root.reads != 1 {
+		t.Errorf("unexpected range read: %s, %d", s, root.reads)
+	}
+	if l.total() != 0 {
+		t.Error("expect the range read not cached")
+	}
+	if s := read(t, d, "a.txt", 0, -1); s != "hello world" {
+		t.Errorf("unexpected content: %s", s)
+	}
+	if s := read(t, d, "a.txt", 6, 5); s != "world" || root.reads != 2 {
+		t.Errorf("expect the content read from the local cache: %s, %d", s, root.reads)
+	}
+
+	// the changed file is read again
+	root.save(t, "remote/a.txt", "hello go-drive")
+	if s := read(t, d, "a.txt", 0, -1); s != "hello go-drive" || root.reads != 3 {
+		t.Errorf("expect the changed content read again: %s, %d", s, root.reads)
+	}
+	if !bytes.Equal([]byte(read(t, d, "a.txt", 6, -1)), []byte("go-drive")) || root.reads != 3 {
+		t.Error("expect the changed content cached")
+	}
+}
@@ -0,0 +1,413 @@
+package cas
+
+import (
+	"context"
+	"go-drive/common/cdc"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "cas",
+		DisplayName: i18n.T("drive.cas.name"),
+		README:      i18n.T("drive.cas.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "path", Label: i18n.T("drive.cas.form.path.label"), Type: "text", Required: true, Description: i18n.T("drive.cas.form.path.description")},
+			{Field: "avg_chunk_size", Label: i18n.T("drive.cas.form.avg_chunk_size.label"), Type: "text", Description: i18n.T("drive.cas.form.avg_chunk_size.description"), DefaultValue: strconv.Itoa(cdc.DefaultAvgSize)},
+		},
+		Factory: drive_util.DriveFactory{Create: NewCasDrive},
+	})
+}
+
+const gcInterval = 1 * time.Hour
+
+// CasDrive stores the file content as content-defined chunks,
+// identical chunks of all files are stored only once.
+// Files are stored as manifests of chunk hashes under 'files', chunks are stored under 'chunks'.
+type CasDrive struct {
+	path         string
+	avgChunkSize int
+	locker       drive_util.PathLocker
+
+	// gcMux is held for reading when chunks are being referenced, and for writing when collecting garbage
+	gcMux  sync.RWMutex
+	stopGC func()
+}
+
+type casEntry struct {
+	drive *CasDrive
+	path  string
+
+	size    int64
+	isDir   bool
+	modTime int64
+}
+
+func NewCasDrive(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	localRoot, e := driveUtils.Config.GetLocalFsDir()
+	if e != nil {
+		return nil, e
+	}
+	if utils.CleanPath(config["path"]) == "" {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.cas.invalid_root_path"))
+	}
+	path, e := filepath.Abs(filepath.Join(localRoot, config["path"]))
+	if e != nil {
+		return nil, e
+	}
+	avgChunkSize := utils.ToInt(config["avg_chunk_size"], cdc.DefaultAvgSize)
+	if avgChunkSize < cdc.MinAvgSize || avgChunkSize > cdc.MaxAvgSize {
+		return nil, err.NewBadRequestError(i18n.T("drive.cas.invalid_avg_chunk_size", strconv.Itoa(cdc.MinAvgSize), strconv.Itoa(cdc.MaxAvgSize)))
+	}
+	locker := driveUtils.Locker
+	if locker == nil {
+		locker = drive_util.NewMemLocker()
+	}
+	c := &CasDrive{path: path, avgChunkSize: avgChunkSize, locker: locker}
+	if e := os.MkdirAll(c.filesDir(), 0755); e != nil {
+		return nil, e
+	}
+	if e := os.MkdirAll(c.chunksDir(), 0755); e != nil {
+		return nil, e
+	}
+	c.stopGC = utils.TimeTick(c.gc, gcInterval)
+	return c, nil
+}
+
+func (c *CasDrive) filesDir() string {
+	return filepath.Join(c.path, "files")
+}
+
+func (c *CasDrive) chunksDir() string {
+	return filepath.Join(c.path, "chunks")
+}
+
+func (c *CasDrive) getPath(path string) string {
+	path = filepath.Clean(string(filepath.Separator) + path)
+	return filepath.Join(c.filesDir(), path)
+}
+
+func (c *CasDrive) lock(ctx context.Context, paths ...string) (func(), error) {
+	keys := make([]string, len(paths))
+	for i, p := range paths {
+		keys[i] = "cas:" + p
+	}
+	return drive_util.LockAll(ctx, c.locker, keys...)
+}
+
+func (c *CasDrive) newEntry(path string, info os.FileInfo) (*casEntry, error) {
+	entry := &casEntry{
+		drive:   c,
+		path:    utils.CleanPath(path),
+		size:    -1,
+		isDir:   info.IsDir(),
+		modTime: utils.Millisecond(info.ModTime()),
+	}
+	if !entry.isDir {
+		m, e := readManifest(c.getPath(path))
+		if e != nil {
+			return nil, e
+		}
+		entry.size = m.Size
+	}
+	return entry, nil
+}
+
+func (c *CasDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (c *CasDrive) Get(_ context.Context, path string) (types.IEntry, error) {
+	stat, e := os.Stat(c.getPath(path))
+	if os.IsNotExist(e) {
+		return nil, err.NewNotFoundError()
+	}
+	if e != nil {
+		return nil, e
+	}
+	return c.newEntry(path, stat)
+}
+
+func (c *CasDrive) Save(ctx types.TaskCtx, path string, _ int64, override bool, reader io.Reader) (types.IEntry, error) {
+	realPath := c.getPath(path)
+	unlock, e := c.lock(ctx, realPath)
+	if e != nil {
+		return nil, e
+	}
+	defer unlock()
+	if !override {
+		if e := requireFile(realPath, false); e != nil {
+			return nil, e
+		}
+	}
+	if isDir, _ := utils.IsDir(realPath); isDir {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+
+	c.gcMux.RLock()
+	defer c.gcMux.RUnlock()
+	m, e := c.storeContent(task.NewProgressCtxWrapper(ctx), reader)
+	if e != nil {
+		return nil, e
+	}
+	if e := writeManifest(realPath, m); e != nil {
+		return nil, e
+	}
+	return c.Get(ctx, path)
+}
+
+func (c *CasDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	realPath := c.getPath(path)
+	unlock, e := c.lock(ctx, realPath)
+	if e != nil {
+		return nil, e
+	}
+	defer unlock()
+	if e := os.Mkdir(realPath, 0755); e != nil && !os.IsExist(e) {
+		return nil, e
+	}
+	return c.Get(ctx, path)
+}
+
+func (c *CasDrive) isSelf(entry types.IEntry) bool {
+	if ce, ok := entry.(*casEntry); ok {
+		return ce.drive == c
+	}
+	return false
+}
+
+// Copy copies the manifests only, the chunks are shared by the copies
+func (c *CasDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, c.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	fromPath := c.getPath(from.Path())
+	toPath := c.getPath(to)
+	if toPath == fromPath || strings.HasPrefix(toPath, fromPath+string(filepath.Separator)) {
+		return nil, err.NewNotAllowedError()
+	}
+	unlock, e := c.lock(ctx, fromPath, toPath)
+	if e != nil {
+		return nil, e
+	}
+	defer unlock()
+	if e := requireFile(fromPath, true); e != nil {
+		return nil, e
+	}
+	if !override {
+		if e := requireFile(toPath, false); e != nil {
+			return nil, e
+		}
+	}
+
+	c.gcMux.RLock()
+	defer c.gcMux.RUnlock()
+	e = filepath.Walk(fromPath, func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		if ctx.Canceled() {
+			return task.ErrorCanceled
+		}
+		dest := filepath.Join(toPath, path[len(fromPath):])
+		if info.IsDir() {
+			if e := os.Mkdir(dest, 0755); e != nil && !os.IsExist(e) {
+				return e
+			}
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".tmp-") {
+			return nil
+		}
+		if isDir, _ := utils.IsDir(dest); isDir {
+			if e := os.RemoveAll(dest); e != nil {
+				return e
+			}
+		}
+		dat, e := ioutil.ReadFile(path)
+		if e != nil {
+			return e
+		}
+		return writeFileAtomic(dest, dat)
+	})
+	if e != nil {
+		return nil, e
+	}
+	return c.Get(ctx, to)
+}
+
+func (c *CasDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, c.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	fromPath := c.getPath(from.Path())
+	toPath := c.getPath(to)
+	if fromPath == c.filesDir() || toPath == c.filesDir() {
+		return nil, err.NewNotAllowedError()
+	}
+	unlock, e := c.lock(ctx, fromPath, toPath)
+	if e != nil {
+		return nil, e
+	}
+	defer unlock()
+	if e := requireFile(fromPath, true); e != nil {
+		return nil, e
+	}
+	exists, e := utils.FileExists(toPath)
+	if e != nil {
+		return nil, e
+	}
+	if exists {
+		if !override {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		if e := os.RemoveAll(toPath); e != nil {
+			return nil, e
+		}
+	}
+	if e := os.Rename(fromPath, toPath); e != nil {
+		return nil, e
+	}
+	return c.Get(ctx, to)
+}
+
+func (c *CasDrive) List(_ context.Context, path string) ([]types.IEntry, error) {
+	realPath := c.getPath(path)
+	isDir, e := utils.IsDir(realPath)
+	if os.IsNotExist(e) {
+		return nil, err.NewNotFoundError()
+	}
+	if e != nil {
+		return nil, e
+	}
+	if !isDir {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.cas.cannot_list_file"))
+	}
+	files, e := ioutil.ReadDir(realPath)
+	if e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, 0, len(files))
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".tmp-") {
+			continue
+		}
+		entry, e := c.newEntry(path+"/"+file.Name(), file)
+		if e != nil {
+			return nil, e
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Delete removes the manifests, the unreferenced chunks are removed by the periodical gc
+func (c *CasDrive) Delete(ctx types.TaskCtx, path string) error {
+	realPath := c.getPath(path)
+	if realPath == c.filesDir() {
+		return err.NewNotAllowedMessageError(i18n.T("drive.cas.cannot_delete_root"))
+	}
+	unlock, e := c.lock(ctx, realPath)
+	if e != nil {
+		return e
+	}
+	defer unlock()
+	if e := requireFile(realPath, true); e != nil {
+		return e
+	}
+	return os.RemoveAll(realPath)
+}
+
+func (c *CasDrive) Upload(_ context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if e := requireFile(c.getPath(path), false); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (c *CasDrive) Dispose() error {
+	c.stopGC()
+	return nil
+}
+
+func requireFile(path string, requireExists bool) error {
+	exists, e := utils.FileExists(path)
+	if e != nil {
+		return e
+	}
+	if requireExists && !exists {
+		return err.NewNotFoundMessageError(i18n.T("drive.file_not_exists"))
+	}
+	if !requireExists && exists {
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	return nil
+}
+
+func (e *casEntry) Path() string {
+	return e.path
+}
+
+func (e *casEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *casEntry) Size() int64 {
+	return e.size
+}
+
+func (e *casEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *casEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *casEntry) Drive() types.IDrive {
+	return e.drive
+}
+
+func (e *casEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *casEntry) GetReader(context.Context) (io.ReadCloser, error) {
+	if !e.Type().IsFile() {
+		return nil, err.NewNotAllowedError()
+	}
+	m, ee := readManifest(e.drive.getPath(e.path))
+	if os.IsNotExist(ee) {
+		return nil, err.NewNotFoundMessageError(i18n.T("drive.file_not_exists"))
+	}
+	if ee != nil {
+		return nil, ee
+	}
+	return &chunksReader{c: e.drive, chunks: m.Chunks}, nil
+}
+
+func (e *casEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
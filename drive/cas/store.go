@@ -0,0 +1,203 @@
+package cas
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"go-drive/common/cdc"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifest is the content of a file in the drive, the file content is the concatenation of the chunks
+type manifest struct {
+	Size   int64    `json:"size"`
+	Chunks []string `json:"chunks"`
+}
+
+func readManifest(path string) (manifest, error) {
+	m := manifest{}
+	dat, e := ioutil.ReadFile(path)
+	if e != nil {
+		return m, e
+	}
+	e = json.Unmarshal(dat, &m)
+	return m, e
+}
+
+func writeManifest(path string, m manifest) error {
+	dat, e := json.Marshal(m)
+	if e != nil {
+		return e
+	}
+	return writeFileAtomic(path, dat)
+}
+
+// writeFileAtomic writes to a temp file in the same directory and renames it to path
+func writeFileAtomic(path string, dat []byte) error {
+	file, e := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if e != nil {
+		return e
+	}
+	_, e = file.Write(dat)
+	if ee := file.Close(); e == nil {
+		e = ee
+	}
+	if e == nil {
+		e = os.Rename(file.Name(), path)
+	}
+	if e != nil {
+		_ = os.Remove(file.Name())
+	}
+	return e
+}
+
+func (c *CasDrive) chunkPath(hash string) string {
+	return filepath.Join(c.chunksDir(), hash[:2], hash)
+}
+
+// putChunk stores the chunk if it does not exist, and returns its hash
+func (c *CasDrive) putChunk(chunk []byte) (string, error) {
+	sum := sha256.Sum256(chunk)
+	hash := hex.EncodeToString(sum[:])
+	path := c.chunkPath(hash)
+	exists, e := utils.FileExists(path)
+	if e != nil {
+		return "", e
+	}
+	if exists {
+		return hash, nil
+	}
+	if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+		return "", e
+	}
+	return hash, writeFileAtomic(path, chunk)
+}
+
+// storeContent splits the content into chunks, and stores the new chunks
+func (c *CasDrive) storeContent(ctx types.TaskCtx, reader io.Reader) (manifest, error) {
+	m := manifest{Chunks: make([]string, 0)}
+	chunker, e := cdc.NewChunker(reader, c.avgChunkSize)
+	if e != nil {
+		return m, e
+	}
+	for {
+		if ctx.Err() != nil {
+			return m, ctx.Err()
+		}
+		chunk, e := chunker.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return m, e
+		}
+		hash, e := c.putChunk(chunk)
+		if e != nil {
+			return m, e
+		}
+		m.Chunks = append(m.Chunks, hash)
+		m.Size += int64(len(chunk))
+		ctx.Progress(int64(len(chunk)), false)
+	}
+	return m, nil
+}
+
+// chunksReader reads the chunks one by one
+type chunksReader struct {
+	c      *CasDrive
+	chunks []string
+	file   *os.File
+}
+
+func (r *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if r.file == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			file, e := os.Open(r.c.chunkPath(r.chunks[0]))
+			if e != nil {
+				return 0, e
+			}
+			r.file = file
+			r.chunks = r.chunks[1:]
+		}
+		n, e := r.file.Read(p)
+		if e == io.EOF {
+			_ = r.file.Close()
+			r.file = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, e
+	}
+}
+
+func (r *chunksReader) Close() error {
+	if r.file != nil {
+		e := r.file.Close()
+		r.file = nil
+		return e
+	}
+	return nil
+}
+
+// gc removes the chunks not referenced by any file
+func (c *CasDrive) gc() {
+	c.gcMux.Lock()
+	defer c.gcMux.Unlock()
+
+	referenced := make(map[string]bool)
+	e := filepath.Walk(c.filesDir(), func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp-") {
+			return nil
+		}
+		m, e := readManifest(path)
+		if e != nil {
+			return e
+		}
+		for _, h := range m.Chunks {
+			referenced[h] = true
+		}
+		return nil
+	})
+	if e != nil {
+		// never sweep with an incomplete reference set
+		log.Printf("error when collecting chunk references of cas drive '%s': %v", c.path, e)
+		return
+	}
+
+	n := 0
+	e = filepath.Walk(c.chunksDir(), func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		if info.IsDir() || referenced[info.Name()] {
+			return nil
+		}
+		if e := os.Remove(path); e != nil {
+			log.Printf("error when removing chunk '%s': %v", info.Name(), e)
+			return nil
+		}
+		n++
+		return nil
+	})
+	if e != nil {
+		log.Printf("error when removing unreferenced chunks of cas drive '%s': %v", c.path, e)
+	}
+	if n > 0 && utils.IsDebugOn() {
+		log.Printf("%d unreferenced chunks of cas drive '%s' removed", n, c.path)
+	}
+}
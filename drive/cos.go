@@ -0,0 +1,66 @@
+package drive
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "cos",
+		DisplayName: i18n.T("drive.cos.name"),
+		README:      i18n.T("drive.cos.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "secret_id", Label: i18n.T("drive.cos.form.secret_id.label"), Type: "text", Required: true},
+			{Field: "secret_key", Label: i18n.T("drive.cos.form.secret_key.label"), Type: "password", Required: true},
+			{Field: "region", Label: i18n.T("drive.cos.form.region.label"), Type: "text", Required: true, Description: i18n.T("drive.cos.form.region.description")},
+			{Field: "bucket", Label: i18n.T("drive.cos.form.bucket.label"), Type: "text", Required: true, Description: i18n.T("drive.cos.form.bucket.description")},
+			{Field: "app_id", Label: i18n.T("drive.cos.form.app_id.label"), Type: "text", Description: i18n.T("drive.cos.form.app_id.description")},
+			{Field: "proxy_upload", Label: i18n.T("drive.s3.form.proxy_in.label"), Type: "checkbox", Description: i18n.T("drive.s3.form.proxy_in.description")},
+			{Field: "proxy_download", Label: i18n.T("drive.s3.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.s3.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.s3.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.s3.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewCOSDrive},
+	})
+}
+
+var cosAppIdSuffix = regexp.MustCompile(`-\d+$`)
+
+// NewCOSDrive creates a Tencent Cloud COS drive by the S3 compatible API of COS
+func NewCOSDrive(ctx context.Context, config drive_util.DriveConfig,
+	utils drive_util.DriveUtils) (types.IDrive, error) {
+	bucket, e := cosBucket(config["bucket"], config["app_id"])
+	if e != nil {
+		return nil, e
+	}
+	region := strings.TrimSpace(config["region"])
+	return NewS3Drive(ctx, drive_util.DriveConfig{
+		"id":             config["secret_id"],
+		"secret":         config["secret_key"],
+		"bucket":         bucket,
+		"region":         region,
+		"endpoint":       "https://cos." + region + ".myqcloud.com",
+		"proxy_upload":   config["proxy_upload"],
+		"proxy_download": config["proxy_download"],
+		"cache_ttl":      config["cache_ttl"],
+	}, utils)
+}
+
+// cosBucket returns the full name of the bucket, which is in the form of 'name-appid'.
+// The APPID is appended if the bucket is the short name.
+func cosBucket(bucket, appId string) (string, error) {
+	bucket = strings.TrimSpace(bucket)
+	appId = strings.TrimSpace(appId)
+	if appId != "" && !strings.HasSuffix(bucket, "-"+appId) {
+		bucket = bucket + "-" + appId
+	}
+	if !cosAppIdSuffix.MatchString(bucket) {
+		return "", err.NewBadRequestError(i18n.T("drive.cos.invalid_bucket", bucket))
+	}
+	return bucket, nil
+}
@@ -0,0 +1,26 @@
+package drive
+
+import "testing"
+
+func TestCOSBucket(t *testing.T) {
+	cases := []struct {
+		bucket, appId, expected string
+	}{
+		{"examplebucket-1250000000", "", "examplebucket-1250000000"},
+		{"examplebucket-1250000000", "1250000000", "examplebucket-1250000000"},
+		{"examplebucket", "1250000000", "examplebucket-1250000000"},
+		{"my-bucket", " 1250000000 ", "my-bucket-1250000000"},
+	}
+	for _, c := range cases {
+		b, e := cosBucket(c.bucket, c.appId)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if b != c.expected {
+			t.Errorf("cosBucket(%s, %s): expected %s, got %s", c.bucket, c.appId, c.expected, b)
+		}
+	}
+	if _, e := cosBucket("examplebucket", ""); e == nil {
+		t.Errorf("expected error for the bucket without APPID")
+	}
+}
@@ -0,0 +1,355 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"strings"
+)
+
+// The encrypted file starts with the header, which is the magic, the cipher and the nonce of the file.
+// The content is split into chunks, each chunk is sealed with the nonce of the file XOR the index of the chunk,
+// and the index and whether it's the last chunk are authenticated, so that the chunks can't be reordered or truncated.
+const (
+	magic = "GDCRYPT1"
+	// nonceSize is the size of the nonce field of the header, the ciphers of shorter nonces use the prefix of it
+	nonceSize  = chacha20poly1305.NonceSizeX
+	headerSize = len(magic) + 1 + nonceSize
+	chunkSize  = 64 * 1024
+	// overhead is the size of the authentication tag of each chunk
+	overhead = 16
+)
+
+// the ciphers of the contents, the values are stored in the header
+const (
+	cipherAESGCM    byte = 1
+	cipherXChaCha20 byte = 2
+)
+
+var cipherNames = map[string]byte{
+	"aes-gcm":   cipherAESGCM,
+	"xchacha20": cipherXChaCha20,
+}
+
+// defaultSalt is used to derive the keys if the salt is not configured
+const defaultSalt = "go-drive crypt"
+
+var (
+	ErrCorrupt     = errors.New("crypt: corrupt or tampered content")
+	ErrInvalidName = errors.New("crypt: invalid encrypted name")
+)
+
+var nameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// cryptor encrypts the contents and the names by the keys derived from the password
+type cryptor struct {
+	cipher  byte
+	ciphers map[byte]cipher.AEAD
+
+	// encryptNames is whether the names are encrypted, the names are encrypted deterministically,
+	// the nonce is the HMAC of the name, so the same name is always encrypted to the same one.
+	encryptNames bool
+	nameAEAD     cipher.AEAD
+	nameKey      []byte
+}
+
+func newCryptor(password, salt string, cipherName string, encryptNames bool) (*cryptor, error) {
+	if salt == "" {
+		salt = defaultSalt
+	}
+	id, ok := cipherNames[cipherName]
+	if !ok {
+		return nil, errors.New("crypt: unknown cipher " + cipherName)
+	}
+	key, e := scrypt.Key([]byte(password), []byte(salt), 1<<15, 8, 1, 96)
+	if e != nil {
+		return nil, e
+	}
+	contentKey, nameKey, nameMacKey := key[:32], key[32:64], key[64:]
+	block, e := aes.NewCipher(contentKey)
+	if e != nil {
+		return nil, e
+	}
+	gcm, e := cipher.NewGCM(block)
+	if e != nil {
+		return nil, e
+	}
+	xchacha, e := chacha20poly1305.NewX(contentKey)
+	if e != nil {
+		return nil, e
+	}
+	nameBlock, e := aes.NewCipher(nameKey)
+	if e != nil {
+		return nil, e
+	}
+	nameAEAD, e := cipher.NewGCM(nameBlock)
+	if e != nil {
+		return nil, e
+	}
+	return &cryptor{
+		cipher:       id,
+		ciphers:      map[byte]cipher.AEAD{cipherAESGCM: gcm, cipherXChaCha20: xchacha},
+		encryptNames: encryptNames,
+		nameAEAD:     nameAEAD,
+		nameKey:      nameMacKey,
+	}, nil
+}
+
+// encryptName encrypts a segment of the path
+func (c *cryptor) encryptName(name string) string {
+	if !c.encryptNames || name == "" {
+		return name
+	}
+	mac := hmac.New(sha256.New, c.nameKey)
+	_, _ = mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:c.nameAEAD.NonceSize()]
+	return strings.ToLower(nameEncoding.EncodeToString(c.nameAEAD.Seal(nonce, nonce, []byte(name), nil)))
+}
+
+func (c *cryptor) decryptName(name string) (string, error) {
+	if !c.encryptNames {
+		return name, nil
+	}
+	b, e := nameEncoding.DecodeString(strings.ToUpper(name))
+	if e != nil || len(b) < c.nameAEAD.NonceSize() {
+		return "", ErrInvalidName
+	}
+	nonceSize := c.nameAEAD.NonceSize()
+	plain, e := c.nameAEAD.Open(nil, b[:nonceSize], b[nonceSize:], nil)
+	if e != nil {
+		return "", ErrInvalidName
+	}
+	return string(plain), nil
+}
+
+// encryptPath encrypts each segment of the path
+func (c *cryptor) encryptPath(path string) string {
+	if !c.encryptNames || path == "" {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = c.encryptName(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// encryptedSize returns the size of the encrypted content, -1 if the size is unknown
+func encryptedSize(size int64) int64 {
+	if size < 0 {
+		return -1
+	}
+	chunks := (size + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return int64(headerSize) + size + chunks*overhead
+}
+
+// plainSize returns the size of the content of the encrypted size, false if the size is invalid
+func plainSize(size int64) (int64, bool) {
+	body := size - int64(headerSize)
+	if body < overhead {
+		return 0, false
+	}
+	full, rem := body/(chunkSize+overhead), body%(chunkSize+overhead)
+	if rem == 0 {
+		return full * chunkSize, true
+	}
+	if rem < overhead {
+		return 0, false
+	}
+	return full*chunkSize + rem - overhead, true
+}
+
+// chunkNonce returns the nonce of the chunk, and the additional data authenticating the chunk
+func chunkNonce(aead cipher.AEAD, nonce []byte, index uint64, last bool) ([]byte, []byte) {
+	n := make([]byte, aead.NonceSize())
+	copy(n, nonce)
+	tail := n[len(n)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^index)
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint64(ad, index)
+	if last {
+		ad[8] = 1
+	}
+	return n, ad
+}
+
+// encryptReader encrypts the content read from r
+type encryptReader struct {
+	aead  cipher.AEAD
+	nonce []byte
+	r     io.Reader
+	// buf is the plain content of the next chunk, with a byte ahead to know whether it's the last chunk
+	buf []byte
+	// out is the pending output in outBuf
+	out    []byte
+	outBuf []byte
+	index  uint64
+	done   bool
+}
+
+func (c *cryptor) newEncryptReader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = c.cipher
+	nonce := header[len(magic)+1:]
+	if _, e := rand.Read(nonce); e != nil {
+		return nil, e
+	}
+	return &encryptReader{
+		aead:   c.ciphers[c.cipher],
+		nonce:  append([]byte(nil), nonce...),
+		r:      r,
+		buf:    make([]byte, 0, chunkSize+1),
+		out:    header,
+		outBuf: make([]byte, 0, chunkSize+overhead),
+	}, nil
+}
+
+func (w *encryptReader) Read(p []byte) (int, error) {
+	for len(w.out) == 0 {
+		if w.done {
+			return 0, io.EOF
+		}
+		n, e := io.ReadFull(w.r, w.buf[len(w.buf):chunkSize+1])
+		w.buf = w.buf[:len(w.buf)+n]
+		last := e == io.EOF || e == io.ErrUnexpectedEOF
+		if e != nil && !last {
+			return 0, e
+		}
+		chunk := w.buf
+		if !last {
+			chunk = w.buf[:chunkSize]
+		}
+		nonce, ad := chunkNonce(w.aead, w.nonce, w.index, last)
+		w.out = w.aead.Seal(w.outBuf[:0], nonce, chunk, ad)
+		w.buf = w.buf[:copy(w.buf, w.buf[len(chunk):])]
+		w.index++
+		w.done = last
+	}
+	n := copy(p, w.out)
+	w.out = w.out[n:]
+	return n, nil
+}
+
+// readHeader reads the header, returns the cipher and the nonce of the file
+func (c *cryptor) readHeader(r io.Reader) (cipher.AEAD, []byte, error) {
+	header := make([]byte, headerSize)
+	if _, e := io.ReadFull(r, header); e != nil {
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			e = ErrCorrupt
+		}
+		return nil, nil, e
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, nil, ErrCorrupt
+	}
+	aead, ok := c.ciphers[header[len(magic)]]
+	if !ok {
+		return nil, nil, ErrCorrupt
+	}
+	return aead, header[len(magic)+1:], nil
+}
+
+// chunks returns the number of the chunks of the encrypted size, 0 if the size is unknown or invalid
+func chunks(size int64) int64 {
+	plain, ok := plainSize(size)
+	if size < 0 || !ok {
+		return 0
+	}
+	if n := (plain + chunkSize - 1) / chunkSize; n > 0 {
+		return n
+	}
+	return 1
+}
+
+// decryptReader decrypts the chunks read from r
+type decryptReader struct {
+	aead  cipher.AEAD
+	nonce []byte
+	r     io.Reader
+	// chunks is the number of the chunks, if it's unknown(0), a byte is read ahead to know the last chunk
+	chunks int64
+	// buf is the encrypted chunk
+	buf    []byte
+	out    []byte
+	outBuf []byte
+	index  int64
+	done   bool
+	err    error
+}
+
+// newDecryptReader returns the reader decrypting the chunks from index, r is the content of the chunks
+func newDecryptReader(aead cipher.AEAD, nonce []byte, r io.Reader, index, chunks int64) *decryptReader {
+	return &decryptReader{
+		aead:   aead,
+		nonce:  nonce,
+		r:      r,
+		chunks: chunks,
+		buf:    make([]byte, 0, chunkSize+overhead+1),
+		outBuf: make([]byte, 0, chunkSize),
+		index:  index,
+	}
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		if e := d.next(); e != nil {
+			d.err = e
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next chunk
+func (d *decryptReader) next() error {
+	size := chunkSize + overhead
+	if d.chunks == 0 {
+		size++
+	}
+	n, e := io.ReadFull(d.r, d.buf[len(d.buf):size])
+	d.buf = d.buf[:len(d.buf)+n]
+	eof := e == io.EOF || e == io.ErrUnexpectedEOF
+	if e != nil && !eof {
+		return e
+	}
+	last := eof
+	if d.chunks > 0 {
+		last = d.index == d.chunks-1
+		if eof && !last {
+			return ErrCorrupt
+		}
+	}
+	chunk := d.buf
+	if !last {
+		chunk = d.buf[:chunkSize+overhead]
+	}
+	nonce, ad := chunkNonce(d.aead, d.nonce, uint64(d.index), last)
+	out, e := d.aead.Open(d.outBuf[:0], nonce, chunk, ad)
+	if e != nil {
+		return ErrCorrupt
+	}
+	d.out = out
+	d.buf = d.buf[:copy(d.buf, d.buf[len(chunk):])]
+	d.index++
+	d.done = last
+	return nil
+}
@@ -0,0 +1,165 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"go-drive/common/errors"
+	"go-drive/common/types"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func newTestCryptor(t *testing.T, cipherName string, encryptNames bool) *cryptor {
+	c, e := newCryptor("password", "", cipherName, encryptNames)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return c
+}
+
+func encrypt(t *testing.T, c *cryptor, plain []byte) []byte {
+	r, e := c.newEncryptReader(bytes.NewReader(plain))
+	if e != nil {
+		t.Fatal(e)
+	}
+	data, e := ioutil.ReadAll(r)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return data
+}
+
+func decrypt(c *cryptor, data []byte, size int64) ([]byte, error) {
+	r := bytes.NewReader(data)
+	aead, nonce, e := c.readHeader(r)
+	if e != nil {
+		return nil, e
+	}
+	return ioutil.ReadAll(newDecryptReader(aead, nonce, r, 0, chunks(size)))
+}
+
+func TestCryptRoundTrip(t *testing.T) {
+	for _, cipherName := range []string{"aes-gcm", "xchacha20"} {
+		c := newTestCryptor(t, cipherName, false)
+		for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 100} {
+			plain := make([]byte, size)
+			rand.Read(plain)
+			data := encrypt(t, c, plain)
+			if int64(len(data)) != encryptedSize(int64(size)) {
+				t.Errorf("%s %d: expect encrypted size %d, but it's %d", cipherName, size, encryptedSize(int64(size)), len(data))
+			}
+			if s, ok := plainSize(int64(len(data))); !ok || s != int64(size) {
+				t.Errorf("%s %d: unexpected plain size %d", cipherName, size, s)
+			}
+			// the size is known or unknown
+			for _, encSize := range []int64{int64(len(data)), -1} {
+				got, e := decrypt(c, data, encSize)
+				if e != nil {
+					t.Fatalf("%s %d: %v", cipherName, size, e)
+				}
+				if !bytes.Equal(got, plain) {
+					t.Errorf("%s %d: decrypted content mismatch", cipherName, size)
+				}
+			}
+		}
+	}
+}
+
+func TestCryptTamper(t *testing.T) {
+	c := newTestCryptor(t, "aes-gcm", false)
+	plain := make([]byte, 2*chunkSize+10)
+	data := encrypt(t, c, plain)
+
+	tampered := append([]byte(nil), data...)
+	tampered[headerSize+chunkSize] ^= 1
+	if _, e := decrypt(c, tampered, int64(len(tampered))); e != ErrCorrupt {
+		t.Errorf("expect ErrCorrupt of the tampered content, but it's %v", e)
+	}
+	// truncated at the boundary of the chunks
+	truncated := data[:headerSize+2*(chunkSize+overhead)]
+	for _, size := range []int64{int64(len(data)), -1} {
+		if _, e := decrypt(c, truncated, size); e != ErrCorrupt {
+			t.Errorf("expect ErrCorrupt of the truncated content, but it's %v", e)
+		}
+	}
+	other, e := newCryptor("other", "", "aes-gcm", false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := decrypt(other, data, int64(len(data))); e != ErrCorrupt {
+		t.Errorf("expect ErrCorrupt of the content decrypted by the other key, but it's %v", e)
+	}
+	if _, _, e := c.readHeader(bytes.NewReader([]byte("plain"))); e != ErrCorrupt {
+		t.Errorf("expect ErrCorrupt of the invalid header, but it's %v", e)
+	}
+}
+
+func TestCryptRange(t *testing.T) {
+	c := newTestCryptor(t, "xchacha20", false)
+	plain := make([]byte, 3*chunkSize+100)
+	rand.Read(plain)
+	data := encrypt(t, c, plain)
+
+	d := &CryptDrive{c: c}
+	entry := d.newEntry("a", &bytesEntry{data: data})
+	if entry.Size() != int64(len(plain)) {
+		t.Errorf("expect size %d, but it's %d", len(plain), entry.Size())
+	}
+	for _, r := range [][2]int64{{0, -1}, {0, 10}, {10, chunkSize}, {chunkSize, chunkSize}, {2*chunkSize + 5, -1}, {3*chunkSize + 99, 1}} {
+		reader, e := entry.GetRangeReader(context.Background(), r[0], r[1])
+		if e != nil {
+			t.Fatal(e)
+		}
+		got, e := ioutil.ReadAll(reader)
+		_ = reader.Close()
+		if e != nil {
+			t.Fatalf("range %v: %v", r, e)
+		}
+		end := int64(len(plain))
+		if r[1] >= 0 {
+			end = r[0] + r[1]
+		}
+		if !bytes.Equal(got, plain[r[0]:end]) {
+			t.Errorf("range %v: content mismatch", r)
+		}
+	}
+}
+
+func TestCryptNames(t *testing.T) {
+	c := newTestCryptor(t, "aes-gcm", true)
+	enc := c.encryptPath("dir/file.txt")
+	if enc == "dir/file.txt" || enc != c.encryptPath("dir/file.txt") {
+		t.Errorf("expect the names encrypted deterministically: %s", enc)
+	}
+	name, e := c.decryptName(enc[len(c.encryptName("dir"))+1:])
+	if e != nil || name != "file.txt" {
+		t.Errorf("unexpected decrypted name: %s, %v", name, e)
+	}
+	if _, e := c.decryptName("file.txt"); e != ErrInvalidName {
+		t.Errorf("expect ErrInvalidName, but it's %v", e)
+	}
+	if plain := newTestCryptor(t, "aes-gcm", false); plain.encryptPath("a/b") != "a/b" {
+		t.Error("expect the names not encrypted")
+	}
+}
+
+// bytesEntry is the encrypted file in memory, without range reading
+type bytesEntry struct {
+	data []byte
+}
+
+func (e *bytesEntry) Path() string          { return "a" }
+func (e *bytesEntry) Type() types.EntryType { return types.TypeFile }
+func (e *bytesEntry) Size() int64           { return int64(len(e.data)) }
+func (e *bytesEntry) Meta() types.EntryMeta { return types.EntryMeta{CanRead: true} }
+func (e *bytesEntry) ModTime() int64        { return 0 }
+func (e *bytesEntry) Drive() types.IDrive   { return nil }
+func (e *bytesEntry) Name() string          { return "a" }
+func (e *bytesEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+func (e *bytesEntry) GetReader(context.Context) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(e.data)), nil
+}
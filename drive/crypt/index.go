@@ -0,0 +1,339 @@
+package crypt
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	path2 "path"
+	"strings"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "crypt",
+		DisplayName: i18n.T("drive.crypt.name"),
+		README:      i18n.T("drive.crypt.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "path", Label: i18n.T("drive.crypt.form.path.label"), Type: "text", Required: true, Description: i18n.T("drive.crypt.form.path.description")},
+			{Field: "password", Label: i18n.T("drive.crypt.form.password.label"), Type: "password", Required: true, Description: i18n.T("drive.crypt.form.password.description")},
+			{Field: "salt", Label: i18n.T("drive.crypt.form.salt.label"), Type: "password", Description: i18n.T("drive.crypt.form.salt.description")},
+			{Field: "cipher", Label: i18n.T("drive.crypt.form.cipher.label"), Type: "select", Description: i18n.T("drive.crypt.form.cipher.description"),
+				Options: []types.FormItemOption{
+					{Name: "AES-256-GCM", Value: "aes-gcm"},
+					{Name: "XChaCha20-Poly1305", Value: "xchacha20"},
+				}, DefaultValue: "aes-gcm"},
+			{Field: "encrypt_names", Label: i18n.T("drive.crypt.form.encrypt_names.label"), Type: "checkbox", Description: i18n.T("drive.crypt.form.encrypt_names.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewCryptDrive},
+	})
+}
+
+// CryptDrive encrypts the contents and optionally the names of the files stored in a directory of another mount.
+type CryptDrive struct {
+	root func() types.IDrive
+	path string
+	c    *cryptor
+	// guard stops the drive being accessed through itself
+	guard *drive_util.RecursionGuard
+}
+
+func NewCryptDrive(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	if driveUtils.Root == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	if config["password"] == "" {
+		return nil, err.NewBadRequestError(i18n.T("drive.crypt.password_required"))
+	}
+	cipherName := config["cipher"]
+	if cipherName == "" {
+		cipherName = "aes-gcm"
+	}
+	if _, ok := cipherNames[cipherName]; !ok {
+		return nil, err.NewBadRequestError(i18n.T("drive.crypt.invalid_cipher", cipherName))
+	}
+	c, e := newCryptor(config["password"], config["salt"], cipherName, config["encrypt_names"] != "")
+	if e != nil {
+		return nil, e
+	}
+	return &CryptDrive{root: driveUtils.Root, path: utils.CleanPath(config["path"]), c: c,
+		guard: drive_util.NewRecursionGuard(i18n.T("drive.crypt.recursive"))}, nil
+}
+
+// realPath returns the path of the encrypted entry in the root drive
+func (d *CryptDrive) realPath(path string) string {
+	return path2.Join(d.path, d.c.encryptPath(path))
+}
+
+func (d *CryptDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *CryptDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Get(ctx, d.realPath(path))
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *CryptDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return nil, err.NewNotAllowedError()
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	r, e := d.c.newEncryptReader(reader)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Save(ctx, d.realPath(path), encryptedSize(size), override, r)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *CryptDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().MakeDir(ctx, d.realPath(path))
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *CryptDrive) isSelf(e types.IEntry) bool {
+	if ce, ok := e.(*cryptEntry); ok {
+		return ce.d == d
+	}
+	return false
+}
+
+// Copy copies the encrypted entry in the root drive, as the contents are not bound to the paths
+func (d *CryptDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Copy(ctx, from.(*cryptEntry).entry, d.realPath(to), override)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(to, entry), nil
+}
+
+func (d *CryptDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Move(ctx, from.(*cryptEntry).entry, d.realPath(to), override)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(to, entry), nil
+}
+
+// List lists the directory, the entries of the names not encrypted by the drive are skipped
+func (d *CryptDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entries, e := d.root().List(ctx, d.realPath(path))
+	if e != nil {
+		return nil, e
+	}
+	r := make([]types.IEntry, 0, len(entries))
+	for _, entry := range entries {
+		name, e := d.c.decryptName(utils.PathBase(entry.Path()))
+		if e != nil {
+			continue
+		}
+		r = append(r, d.newEntry(path2.Join(path, name), entry))
+	}
+	return r, nil
+}
+
+func (d *CryptDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return e
+	}
+	return d.root().Delete(ctx, d.realPath(path))
+}
+
+func (d *CryptDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *CryptDrive) newEntry(path string, entry types.IEntry) *cryptEntry {
+	return &cryptEntry{d: d, path: strings.TrimPrefix(path, "/"), entry: entry}
+}
+
+// cryptEntry is the decrypted entry of the encrypted one in the root drive.
+// It does not unwrap to the encrypted entry, so that the encrypted content is never copied as the plain one.
+type cryptEntry struct {
+	d     *CryptDrive
+	path  string
+	entry types.IEntry
+}
+
+func (e *cryptEntry) Path() string {
+	return e.path
+}
+
+func (e *cryptEntry) Type() types.EntryType {
+	return e.entry.Type()
+}
+
+// Size returns the size of the plain content, 0 if the encrypted content is invalid
+func (e *cryptEntry) Size() int64 {
+	if e.entry.Type().IsDir() {
+		return -1
+	}
+	size, _ := plainSize(e.entry.Size())
+	return size
+}
+
+func (e *cryptEntry) Meta() types.EntryMeta {
+	m := e.entry.Meta()
+	return types.EntryMeta{CanRead: m.CanRead, CanWrite: m.CanWrite}
+}
+
+func (e *cryptEntry) ModTime() int64 {
+	return e.entry.ModTime()
+}
+
+func (e *cryptEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *cryptEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *cryptEntry) content() (types.IContent, error) {
+	c, ok := e.entry.(types.IContent)
+	if !ok || !e.Type().IsFile() {
+		return nil, err.NewNotAllowedError()
+	}
+	return c, nil
+}
+
+func (e *cryptEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+// GetRangeReader reads the chunks of the range, and decrypts them
+func (e *cryptEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	c, ee := e.content()
+	if ee != nil {
+		return nil, ee
+	}
+	if length == 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	chunks := chunks(c.Size())
+	index := offset / chunkSize
+	start := int64(headerSize) + index*(chunkSize+overhead)
+	if offset == 0 {
+		start = 0
+	}
+	encLength := int64(-1)
+	if length > 0 && c.Size() >= 0 {
+		end := int64(headerSize) + ((offset+length-1)/chunkSize+1)*(chunkSize+overhead)
+		if end > c.Size() {
+			end = c.Size()
+		}
+		encLength = end - start
+	}
+	var r io.ReadCloser
+	if start > 0 {
+		hr, ee := drive_util.GetIContentRangeReader(ctx, c, 0, int64(headerSize))
+		if ee != nil {
+			return nil, ee
+		}
+		aead, nonce, ee := e.d.c.readHeader(hr)
+		_ = hr.Close()
+		if ee != nil {
+			return nil, ee
+		}
+		if r, ee = drive_util.GetIContentRangeReader(ctx, c, start, encLength); ee != nil {
+			return nil, ee
+		}
+		return e.plainRange(newDecryptReader(aead, nonce, r, index, chunks), r, offset-index*chunkSize, length)
+	}
+	if r, ee = drive_util.GetIContentRangeReader(ctx, c, 0, encLength); ee != nil {
+		return nil, ee
+	}
+	aead, nonce, ee := e.d.c.readHeader(r)
+	if ee != nil {
+		_ = r.Close()
+		return nil, ee
+	}
+	return e.plainRange(newDecryptReader(aead, nonce, r, 0, chunks), r, offset, length)
+}
+
+// plainRange skips the bytes before the range of the decrypted content, and limits the length
+func (e *cryptEntry) plainRange(dr *decryptReader, closer io.Closer, skip, length int64) (io.ReadCloser, error) {
+	if skip > 0 {
+		if _, ee := io.CopyN(ioutil.Discard, dr, skip); ee != nil && ee != io.EOF {
+			_ = closer.Close()
+			return nil, ee
+		}
+	}
+	var reader io.Reader = dr
+	if length >= 0 {
+		reader = io.LimitReader(dr, length)
+	}
+	return &readCloser{Reader: reader, Closer: closer}, nil
+}
+
+// GetURL is not supported, as the content must be decrypted
+func (e *cryptEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,271 @@
+package db
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "db",
+		DisplayName: i18n.T("drive.db.name"),
+		README:      i18n.T("drive.db.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "max_file_size", Label: i18n.T("drive.db.form.max_file_size.label"), Type: "text", Description: i18n.T("drive.db.form.max_file_size.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewDBDrive},
+	})
+}
+
+// DBDrive stores the contents of the files in chunks and the paths in the database,
+// so that the database is the only thing to back up
+type DBDrive struct {
+	files drive_util.DriveFileStore
+	// maxFileSize is the max size of a file, 0 means no limit
+	maxFileSize int64
+}
+
+func NewDBDrive(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	if driveUtils.Files == nil {
+		return nil, err.NewNotAllowedError()
+	}
+	d := &DBDrive{files: driveUtils.Files}
+	if s := strings.TrimSpace(config["max_file_size"]); s != "" {
+		size, e := utils.ParseBytes(s)
+		if e != nil {
+			return nil, err.NewBadRequestError(i18n.T("drive.db.invalid_max_file_size", s))
+		}
+		d.maxFileSize = int64(size)
+	}
+	return d, nil
+}
+
+func (d *DBDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *DBDrive) Get(_ context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &dbEntry{d: d, file: types.DriveFile{Dir: true, Size: -1, ModTime: -1}}, nil
+	}
+	f, e := d.files.Get(path)
+	if e != nil {
+		return nil, e
+	}
+	if f == nil {
+		return nil, err.NewNotFoundError()
+	}
+	return d.newEntry(*f), nil
+}
+
+// requireDir returns error if the directory does not exist
+func (d *DBDrive) requireDir(ctx context.Context, path string) error {
+	dir, e := d.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	if !dir.Type().IsDir() {
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	return nil
+}
+
+func (d *DBDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return nil, err.NewNotAllowedError()
+	}
+	if d.maxFileSize > 0 && size > d.maxFileSize {
+		return nil, err.NewQuotaExceededError(i18n.T("drive.db.file_too_large", utils.FormatBytes(uint64(d.maxFileSize), 1)))
+	}
+	if e := d.requireDir(ctx, utils.PathParent(path)); e != nil {
+		return nil, e
+	}
+	if old, _ := d.Get(ctx, path); old != nil {
+		if !override || old.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+	}
+	ctx.Total(size, true)
+	if d.maxFileSize > 0 {
+		// the size may be unknown, the content is limited to fail when it's exceeded
+		reader = &limitedReader{r: reader, max: d.maxFileSize}
+	}
+	f, e := d.files.Write(path, utils.Millisecond(time.Now()), drive_util.ProgressReader(reader, ctx))
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(*f), nil
+}
+
+func (d *DBDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := d.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	if e := d.requireDir(ctx, utils.PathParent(path)); e != nil {
+		return nil, e
+	}
+	f, e := d.files.MakeDir(path, utils.Millisecond(time.Now()))
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(*f), nil
+}
+
+func (d *DBDrive) isSelf(entry types.IEntry) bool {
+	if de, ok := entry.(*dbEntry); ok {
+		return de.d == d
+	}
+	return false
+}
+
+// Copy is done by the generic copy, as the chunks need to be written anyway
+func (d *DBDrive) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (d *DBDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	fromPath := from.Path()
+	if utils.IsRootPath(fromPath) || utils.IsRootPath(to) ||
+		to == fromPath || strings.HasPrefix(to, fromPath+"/") {
+		return nil, err.NewNotAllowedError()
+	}
+	if e := d.requireDir(ctx, utils.PathParent(to)); e != nil {
+		return nil, e
+	}
+	exists, e := drive_util.RequireFileNotExists(ctx, d, to)
+	if exists != nil {
+		if !override {
+			return nil, e
+		}
+		if e := d.files.Delete(to); e != nil {
+			return nil, e
+		}
+	} else if e != nil {
+		return nil, e
+	}
+	if e := d.files.Move(fromPath, to); e != nil {
+		return nil, e
+	}
+	return d.Get(ctx, to)
+}
+
+func (d *DBDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if e := d.requireDir(ctx, path); e != nil {
+		return nil, e
+	}
+	files, e := d.files.List(path)
+	if e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, len(files))
+	for i, f := range files {
+		entries[i] = d.newEntry(f)
+	}
+	return entries, nil
+}
+
+func (d *DBDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	if _, e := d.Get(ctx, path); e != nil {
+		return e
+	}
+	return d.files.Delete(path)
+}
+
+func (d *DBDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *DBDrive) newEntry(f types.DriveFile) *dbEntry {
+	return &dbEntry{d: d, file: f}
+}
+
+// limitedReader fails if more than max bytes are read
+type limitedReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, e := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.max {
+		return n, err.NewQuotaExceededError(i18n.T("drive.db.file_too_large", utils.FormatBytes(uint64(l.max), 1)))
+	}
+	return n, e
+}
+
+type dbEntry struct {
+	d    *DBDrive
+	file types.DriveFile
+}
+
+func (e *dbEntry) Path() string {
+	return e.file.Path
+}
+
+func (e *dbEntry) Type() types.EntryType {
+	if e.file.Dir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *dbEntry) Size() int64 {
+	if e.file.Dir {
+		return -1
+	}
+	return e.file.Size
+}
+
+func (e *dbEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *dbEntry) ModTime() int64 {
+	return e.file.ModTime
+}
+
+func (e *dbEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *dbEntry) Name() string {
+	return utils.PathBase(e.file.Path)
+}
+
+func (e *dbEntry) GetReader(context.Context) (io.ReadCloser, error) {
+	if e.file.Dir {
+		return nil, err.NewNotAllowedError()
+	}
+	return e.d.files.Read(e.file)
+}
+
+func (e *dbEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
@@ -11,6 +11,7 @@ import (
 	"go-drive/common/utils"
 	"go-drive/storage"
 	"io"
+	"os"
 	path2 "path"
 	"regexp"
 	"strings"
@@ -25,12 +26,21 @@ type DispatcherDrive struct {
 	drives map[string]types.IDrive
 	mounts map[string]map[string]types.PathMount
 
+	// transforms are the upload transforms of the drives
+	transforms map[string]*drive_util.UploadTransform
+	options    map[string]drive_util.DriveOptions
+
 	tempDir string
 
 	mountStorage *storage.PathMountDAO
 	mux          *sync.Mutex
+
+	listeners []ChangeListener
 }
 
+// ChangeListener is called after the entry at path was overwritten, moved or deleted
+type ChangeListener func(path string)
+
 func NewDispatcherDrive(mountStorage *storage.PathMountDAO, config common.Config) *DispatcherDrive {
 	return &DispatcherDrive{
 		drives:       make(map[string]types.IDrive),
@@ -40,7 +50,8 @@ func NewDispatcherDrive(mountStorage *storage.PathMountDAO, config common.Config
 	}
 }
 
-func (d *DispatcherDrive) setDrives(drives map[string]types.IDrive) {
+func (d *DispatcherDrive) setDrives(drives map[string]types.IDrive,
+	transforms map[string]*drive_util.UploadTransform, options map[string]drive_util.DriveOptions) {
 	d.mux.Lock()
 	defer d.mux.Unlock()
 	for _, d := range d.drives {
@@ -53,6 +64,21 @@ func (d *DispatcherDrive) setDrives(drives map[string]types.IDrive) {
 		newDrives[k] = v
 	}
 	d.drives = newDrives
+	d.transforms = transforms
+	d.options = options
+}
+
+// AddChangeListener registers a listener, it must be called before the drive is being used
+func (d *DispatcherDrive) AddChangeListener(l ChangeListener) {
+	d.listeners = append(d.listeners, l)
+}
+
+func (d *DispatcherDrive) notifyChange(paths ...string) {
+	for _, p := range paths {
+		for _, l := range d.listeners {
+			l(p)
+		}
+	}
 }
 
 func (d *DispatcherDrive) reloadMounts() error {
@@ -95,9 +121,40 @@ func (d *DispatcherDrive) resolve(path string) (types.IDrive, string, error) {
 	if !ok {
 		return nil, "", err.NewNotFoundError()
 	}
+	if d.options[driveName].NormalizeSeparators {
+		entryPath = utils.NormalizePath(entryPath)
+	}
 	return drive, entryPath, nil
 }
 
+// uploadTransform returns the upload transform of the drive which path is in, if the file of path should be transformed
+func (d *DispatcherDrive) uploadTransform(path string) *drive_util.UploadTransform {
+	if targetPath := d.resolveMount(path); targetPath != "" {
+		path = targetPath
+	}
+	paths := pathRegexp.FindStringSubmatch(path)
+	if paths == nil {
+		return nil
+	}
+	t := d.transforms[paths[1]]
+	if t == nil || !t.Match(path) {
+		return nil
+	}
+	return t
+}
+
+// driveOptions returns the options of the drive which path is in
+func (d *DispatcherDrive) driveOptions(path string) drive_util.DriveOptions {
+	if targetPath := d.resolveMount(path); targetPath != "" {
+		path = targetPath
+	}
+	paths := pathRegexp.FindStringSubmatch(path)
+	if paths == nil {
+		return drive_util.DriveOptions{}
+	}
+	return d.options[paths[1]]
+}
+
 func (d *DispatcherDrive) resolveMount(path string) string {
 	tree := utils.PathParentTree(path)
 	var mountAt, prefix string
@@ -158,6 +215,21 @@ func (d *DispatcherDrive) Get(ctx context.Context, path string) (types.IEntry, e
 
 func (d *DispatcherDrive) Save(ctx types.TaskCtx, path string, size int64,
 	override bool, reader io.Reader) (types.IEntry, error) {
+	if t := d.uploadTransform(path); t != nil {
+		file, newPath, e := t.Transform(ctx, path, reader)
+		if e != nil {
+			return nil, e
+		}
+		defer func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}()
+		stat, e := file.Stat()
+		if e != nil {
+			return nil, e
+		}
+		path, size, reader = newPath, stat.Size(), file
+	}
 	drive, realPath, e := d.resolve(path)
 	if e != nil {
 		return nil, e
@@ -166,6 +238,7 @@ func (d *DispatcherDrive) Save(ctx types.TaskCtx, path string, size int64,
 	if e != nil {
 		return nil, e
 	}
+	d.notifyChange(path)
 	return d.mapDriveEntry(path, save), nil
 }
 
@@ -190,8 +263,9 @@ func (d *DispatcherDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string,
 	mounts, _ := d.resolveMountedChildren(from.Path())
 	if len(mounts) == 0 {
 		// if `from` has no mounted children, then copy
-		entry, e := driveTo.Copy(ctx, from, pathTo, override)
+		entry, e := drive_util.NativeCopy(ctx, driveTo, from, pathTo, override)
 		if e == nil {
+			d.notifyChange(to)
 			return entry, nil
 		}
 		if !err.IsUnsupportedError(e) {
@@ -206,7 +280,7 @@ func (d *DispatcherDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string,
 			if e != nil {
 				return e
 			}
-			_, e = driveTo.Copy(ctxWrapper, from, pathTo, true)
+			_, e = drive_util.NativeCopy(ctxWrapper, driveTo, from, pathTo, true)
 			if e == nil {
 				return nil
 			}
@@ -220,6 +294,7 @@ func (d *DispatcherDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string,
 	if e != nil {
 		return nil, e
 	}
+	d.notifyChange(to)
 	copied, e := driveTo.Get(ctx, pathTo)
 	if e != nil {
 		return nil, e
@@ -252,6 +327,7 @@ func (d *DispatcherDrive) Move(ctx types.TaskCtx, from types.IEntry, to string,
 		}
 		_ = d.reloadMounts()
 		if isSelf {
+			d.notifyChange(fromPath, to)
 			return d.Get(ctx, to)
 		}
 	} else {
@@ -263,22 +339,67 @@ func (d *DispatcherDrive) Move(ctx types.TaskCtx, from types.IEntry, to string,
 	if driveTo != nil {
 		move, e := driveTo.Move(ctx, from, pathTo, override)
 		if e != nil {
-			if err.IsUnsupportedError(e) {
+			if !err.IsUnsupportedError(e) {
+				return nil, e
+			}
+			driveFrom, _, re := d.resolve(fromPath)
+			if re != nil || driveFrom == driveTo {
 				return nil, err.NewNotAllowedMessageError(i18n.T("drive.dispatcher.move_across_not_supported"))
 			}
-			return nil, e
+			// moving across drives, by copying and verifying before deleting the source
+			return drive_util.MoveAcross(ctx, from, d, to, override, func(ctx types.TaskCtx) error {
+				return d.Delete(ctx, fromPath)
+			})
 		}
+		d.notifyChange(fromPath, to)
 		return d.mapDriveEntry(to, move), nil
 	}
 	return d.Get(ctx, to)
 }
 
+func (d *DispatcherDrive) Publish(ctx types.TaskCtx, staging, target string) (types.IEntry, error) {
+	stagingDrive, stagingPath, e := d.resolve(staging)
+	if e != nil {
+		return nil, e
+	}
+	targetDrive, targetPath, e := d.resolve(target)
+	if e != nil {
+		return nil, e
+	}
+	publisher, ok := targetDrive.(types.IPublisher)
+	if !ok || stagingDrive != targetDrive {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.dispatcher.publish_not_supported"))
+	}
+	entry, e := publisher.Publish(ctx, stagingPath, targetPath)
+	if e != nil {
+		if err.IsUnsupportedError(e) {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.dispatcher.publish_not_supported"))
+		}
+		return nil, e
+	}
+	d.notifyChange(staging, target)
+	return d.mapDriveEntry(target, entry), nil
+}
+
+// DirSignature is supported only if the drive of the path supports it, mounts are not considered
+func (d *DispatcherDrive) DirSignature(ctx context.Context, path string) (string, error) {
+	drive, realPath, e := d.resolve(path)
+	if e != nil {
+		return "", e
+	}
+	if ds, ok := drive.(types.IDirSignature); ok {
+		return ds.DirSignature(ctx, realPath)
+	}
+	return "", err.NewUnsupportedError()
+}
+
 func (d *DispatcherDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
 	var entries []types.IEntry
 	if utils.IsRootPath(path) {
 		drives := make([]types.IEntry, 0, len(d.drives))
 		for k, v := range d.drives {
-			drives = append(drives, &driveEntry{d: d, path: k, name: k, meta: v.Meta(ctx)})
+			drives = append(drives, &driveEntry{d: d, path: k, name: k,
+				displayName: d.options[k].RootName, meta: v.Meta(ctx)})
 		}
 		entries = drives
 	} else {
@@ -334,21 +455,33 @@ func (d *DispatcherDrive) Delete(ctx types.TaskCtx, path string) error {
 		}
 		_ = d.reloadMounts()
 		if isSelf {
+			d.notifyChange(path)
 			return nil
 		}
 	}
-	drive, path, e := d.resolve(path)
+	drive, realPath, e := d.resolve(path)
 	if e != nil {
 		return e
 	}
-	if utils.IsRootPath(path) {
+	if utils.IsRootPath(realPath) {
 		return err.NewNotAllowedError()
 	}
-	return drive.Delete(ctx, path)
+	if e := drive.Delete(ctx, realPath); e != nil {
+		return e
+	}
+	d.notifyChange(path)
+	return nil
 }
 
 func (d *DispatcherDrive) Upload(ctx context.Context, path string, size int64,
 	override bool, config types.SM) (*types.DriveUploadConfig, error) {
+	if d.uploadTransform(path) != nil {
+		// transformed files must be uploaded through this server
+		if _, _, e := d.resolve(path); e != nil {
+			return nil, e
+		}
+		return types.UseLocalProvider(size), nil
+	}
 	drive, path, e := d.resolve(path)
 	if e != nil {
 		return nil, e
@@ -356,6 +489,79 @@ func (d *DispatcherDrive) Upload(ctx context.Context, path string, size int64,
 	return drive.Upload(ctx, path, size, override, config)
 }
 
+func (d *DispatcherDrive) Share(ctx context.Context, path string, options types.ShareOptions) (*types.ShareLink, error) {
+	drive, realPath, e := d.resolve(path)
+	if e != nil {
+		return nil, e
+	}
+	sharer, ok := drive.(types.ISharer)
+	if !ok {
+		return nil, err.NewUnsupportedError()
+	}
+	return sharer.Share(ctx, realPath, options)
+}
+
+func (d *DispatcherDrive) Space(ctx context.Context, path string) (int64, int64, error) {
+	drive, realPath, e := d.resolve(path)
+	if e != nil {
+		return 0, 0, e
+	}
+	reporter, ok := drive.(types.ISpaceReporter)
+	if !ok {
+		return 0, 0, err.NewUnsupportedError()
+	}
+	return reporter.Space(ctx, realPath)
+}
+
+func (d *DispatcherDrive) GetTail(ctx context.Context, path string, n int) ([]byte, int64, error) {
+	drive, realPath, e := d.resolve(path)
+	if e != nil {
+		return nil, 0, e
+	}
+	tailer, ok := drive.(types.ITailer)
+	if !ok {
+		return nil, 0, err.NewUnsupportedError()
+	}
+	return tailer.GetTail(ctx, realPath, n)
+}
+
+func (d *DispatcherDrive) Follow(ctx context.Context, path string, offset int64, fn func([]byte, bool) error) error {
+	drive, realPath, e := d.resolve(path)
+	if e != nil {
+		return e
+	}
+	tailer, ok := drive.(types.ITailer)
+	if !ok {
+		return err.NewUnsupportedError()
+	}
+	return tailer.Follow(ctx, realPath, offset, fn)
+}
+
+// ResolveID finds the entry by the id returned by entryWrapper.StableID, which is prefixed by the drive name.
+// The entry is returned at its path in the drive, not the path of a mount.
+func (d *DispatcherDrive) ResolveID(ctx context.Context, id string) (types.IEntry, error) {
+	i := strings.IndexByte(id, ':')
+	if i <= 0 {
+		return nil, err.NewNotFoundError()
+	}
+	driveName := id[:i]
+	d.mux.Lock()
+	drive, ok := d.drives[driveName]
+	d.mux.Unlock()
+	if !ok {
+		return nil, err.NewNotFoundError()
+	}
+	resolver, ok := drive.(types.IIDResolver)
+	if !ok {
+		return nil, err.NewNotFoundError()
+	}
+	entry, e := resolver.ResolveID(ctx, id[i+1:])
+	if e != nil {
+		return nil, e
+	}
+	return d.mapDriveEntry(path2.Join(driveName, entry.Path()), entry), nil
+}
+
 func (d *DispatcherDrive) mapDriveEntry(path string, entry types.IEntry) types.IEntry {
 	return &entryWrapper{d: d, path: path, entry: entry}
 }
@@ -422,6 +628,26 @@ func (d *entryWrapper) GetURL(ctx context.Context) (*types.ContentURL, error) {
 	return nil, err.NewNotAllowedError()
 }
 
+func (d *entryWrapper) StableID() string {
+	s, ok := d.entry.(types.IStableID)
+	if !ok {
+		return ""
+	}
+	id := s.StableID()
+	if id == "" {
+		return ""
+	}
+	path := d.path
+	if targetPath := d.d.resolveMount(path); targetPath != "" {
+		path = targetPath
+	}
+	paths := pathRegexp.FindStringSubmatch(path)
+	if paths == nil {
+		return ""
+	}
+	return paths[1] + ":" + id
+}
+
 func (d *entryWrapper) Drive() types.IDrive {
 	return d.d
 }
@@ -434,7 +660,9 @@ type driveEntry struct {
 	d    *DispatcherDrive
 	path string
 	name string
-	meta types.DriveMeta
+	// displayName is the configured name of the drive root
+	displayName string
+	meta        types.DriveMeta
 }
 
 func (d *driveEntry) Path() string {
@@ -450,7 +678,12 @@ func (d *driveEntry) Size() int64 {
 }
 
 func (d *driveEntry) Meta() types.EntryMeta {
-	return types.EntryMeta{CanRead: true, CanWrite: true, Props: d.meta.Props}
+	props := d.meta.Props
+	if d.displayName != "" {
+		props = utils.CopyMap(props)
+		props["display_name"] = d.displayName
+	}
+	return types.EntryMeta{CanRead: true, CanWrite: true, Props: props}
 }
 
 func (d *driveEntry) ModTime() int64 {
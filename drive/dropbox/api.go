@@ -0,0 +1,87 @@
+package dropbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	apiURL     = "https://api.dropboxapi.com/2"
+	contentURL = "https://content.dropboxapi.com/2"
+)
+
+// metadata is the metadata of a file or a folder
+type metadata struct {
+	Tag            string `json:".tag"`
+	Id             string `json:"id"`
+	Name           string `json:"name"`
+	PathDisplay    string `json:"path_display"`
+	Size           int64  `json:"size"`
+	ServerModified string `json:"server_modified"`
+	ContentHash    string `json:"content_hash"`
+}
+
+type listFolderResult struct {
+	Entries []metadata `json:"entries"`
+	Cursor  string     `json:"cursor"`
+	HasMore bool       `json:"has_more"`
+}
+
+type metadataResult struct {
+	Metadata metadata `json:"metadata"`
+}
+
+type temporaryLinkResult struct {
+	Link string `json:"link"`
+}
+
+type uploadSessionStartResult struct {
+	SessionId string `json:"session_id"`
+}
+
+type uploadSessionCursor struct {
+	SessionId string `json:"session_id"`
+	Offset    int64  `json:"offset"`
+}
+
+type commitInfo struct {
+	Path       string `json:"path"`
+	Mode       string `json:"mode"`
+	Autorename bool   `json:"autorename"`
+	Mute       bool   `json:"mute"`
+}
+
+type fullAccount struct {
+	Name struct {
+		DisplayName string `json:"display_name"`
+	} `json:"name"`
+	Email string `json:"email"`
+}
+
+type apiError struct {
+	Summary string `json:"error_summary"`
+	status  int
+}
+
+func (a apiError) Error() string {
+	return fmt.Sprintf("%d: %s", a.status, a.Summary)
+}
+
+// apiArg encodes v as the value of the 'Dropbox-API-Arg' header,
+// the characters out of ASCII must be escaped to be HTTP header safe
+func apiArg(v interface{}) string {
+	b, _ := json.Marshal(v)
+	sb := strings.Builder{}
+	for _, r := range string(b) {
+		if r < 0x80 {
+			sb.WriteRune(r)
+			continue
+		}
+		for _, c := range utf16.Encode([]rune{r}) {
+			sb.WriteString(fmt.Sprintf("\\u%04x", c))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,443 @@
+package dropbox
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	path2 "path"
+	"strconv"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "dropbox",
+		DisplayName: i18n.T("drive.dropbox.name"),
+		README:      i18n.T("drive.dropbox.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "client_id", Label: i18n.T("drive.dropbox.form.client_id.label"), Type: "text", Required: true},
+			{Field: "client_secret", Label: i18n.T("drive.dropbox.form.client_secret.label"), Type: "password", Required: true},
+			{Field: "proxy_download", Label: i18n.T("drive.dropbox.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.dropbox.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.dropbox.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.dropbox.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewDropbox, InitConfig: InitConfig, Init: Init},
+	})
+}
+
+const (
+	// uploadMaxSize is the max size of the files uploaded by a single request
+	uploadMaxSize = 150 * 1024 * 1024
+	// uploadChunkSize is the size of the chunks appended to the upload sessions
+	uploadChunkSize = 32 * 1024 * 1024
+	// linkTTL is how long a temporary link is used, the links expire after 4 hours
+	linkTTL = 3 * time.Hour
+	// listLimit is the max number of entries of a page of listing
+	listLimit = 2000
+)
+
+type Dropbox struct {
+	// c calls the RPC endpoints, content calls the content endpoints
+	c       *req.Client
+	content *req.Client
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+
+	downloadProxy bool
+}
+
+func NewDropbox(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	resp, e := drive_util.OAuthGet(*oauthReq(driveUtils.Config), config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	d := &Dropbox{
+		cacheTTL:      cacheTtl,
+		downloadProxy: config["proxy_download"] != "",
+	}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+
+	httpClient := resp.Client(nil)
+	if d.c, e = req.NewClient(apiURL, nil, ifApiCallError, httpClient); e != nil {
+		return nil, e
+	}
+	if d.content, e = req.NewClient(contentURL, nil, ifApiCallError, httpClient); e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+func (d *Dropbox) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *Dropbox) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &dropboxEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	resp, e := d.c.Post(ctx, "/files/get_metadata", nil, req.NewJsonBody(types.M{"path": apiPath(path)}))
+	if e != nil {
+		return nil, e
+	}
+	m := metadata{}
+	if e := resp.Json(&m); e != nil {
+		return nil, e
+	}
+	entry := d.newEntry(utils.PathParent(path), m)
+	_ = d.cache.PutEntry(entry, d.cacheTTL)
+	return entry, nil
+}
+
+func (d *Dropbox) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	commit := commitInfo{Path: apiPath(path), Mode: "add", Mute: true}
+	if override {
+		commit.Mode = "overwrite"
+	}
+	ctx.Total(size, true)
+	var resp req.Response
+	var e error
+	if size >= 0 && size <= uploadMaxSize {
+		resp, e = d.content.Post(ctx, "/files/upload", types.SM{"Dropbox-API-Arg": apiArg(commit)},
+			req.NewReaderBody(drive_util.ProgressReader(reader, ctx), size))
+	} else {
+		resp, e = d.uploadLargeFile(ctx, commit, size, reader)
+	}
+	_ = d.cache.Evict(path, false)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	m := metadata{}
+	if e := resp.Json(&m); e != nil {
+		return nil, e
+	}
+	return d.newEntry(utils.PathParent(path), m), nil
+}
+
+// uploadLargeFile uploads the file by an upload session, chunk by chunk
+func (d *Dropbox) uploadLargeFile(ctx types.TaskCtx, commit commitInfo,
+	size int64, reader io.Reader) (req.Response, error) {
+	resp, e := d.content.Post(ctx, "/files/upload_session/start",
+		types.SM{"Dropbox-API-Arg": apiArg(types.M{"close": false})}, req.NewReaderBody(nil, 0))
+	if e != nil {
+		return nil, e
+	}
+	started := uploadSessionStartResult{}
+	if e := resp.Json(&started); e != nil {
+		return nil, e
+	}
+	cursor := uploadSessionCursor{SessionId: started.SessionId}
+	for {
+		if e := ctx.WaitIfPaused(); e != nil {
+			return nil, e
+		}
+		chunk := int64(uploadChunkSize)
+		if size >= 0 && size-cursor.Offset < chunk {
+			chunk = size - cursor.Offset
+		}
+		if chunk == 0 {
+			break
+		}
+		counter := &countingReader{r: io.LimitReader(reader, chunk)}
+		body := req.NewReaderBody(drive_util.ProgressReader(counter, ctx), chunk)
+		if size < 0 {
+			// the size is unknown, the chunk ends at where the reader ends
+			body = req.NewReaderBody(drive_util.ProgressReader(counter, ctx), -1)
+		}
+		resp, e := d.content.Post(ctx, "/files/upload_session/append_v2",
+			types.SM{"Dropbox-API-Arg": apiArg(types.M{"cursor": cursor, "close": false})}, body)
+		if e != nil {
+			return nil, e
+		}
+		_ = resp.Dispose()
+		cursor.Offset += counter.n
+		if counter.n < chunk {
+			if size >= 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			break
+		}
+	}
+	return d.content.Post(ctx, "/files/upload_session/finish",
+		types.SM{"Dropbox-API-Arg": apiArg(types.M{"cursor": cursor, "commit": commit})}, req.NewReaderBody(nil, 0))
+}
+
+func (d *Dropbox) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := d.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	resp, e := d.c.Post(ctx, "/files/create_folder_v2", nil,
+		req.NewJsonBody(types.M{"path": apiPath(path), "autorename": false}))
+	if e != nil {
+		return nil, e
+	}
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return d.toEntry(utils.PathParent(path), resp)
+}
+
+func (d *Dropbox) isSelf(e types.IEntry) bool {
+	if de, ok := e.(*dropboxEntry); ok {
+		return de.d == d
+	}
+	return false
+}
+
+// copyOrMove copies or moves files and folders on Dropbox, the existing target is deleted first if override
+func (d *Dropbox) copyOrMove(ctx types.TaskCtx, endpoint string, from types.IEntry,
+	to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	if _, e := d.Get(ctx, to); e == nil {
+		if !override {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		if e := d.Delete(ctx, to); e != nil {
+			return nil, e
+		}
+	} else if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	ctx.Total(from.Size(), false)
+	resp, e := d.c.Post(ctx, endpoint, nil, req.NewJsonBody(types.M{
+		"from_path": apiPath(from.Path()), "to_path": apiPath(to), "autorename": false,
+	}))
+	_ = d.cache.Evict(to, true)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	ctx.Progress(from.Size(), false)
+	return d.toEntry(utils.PathParent(to), resp)
+}
+
+func (d *Dropbox) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	return d.copyOrMove(ctx, "/files/copy_v2", from, to, override)
+}
+
+func (d *Dropbox) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	entry, e := d.copyOrMove(ctx, "/files/move_v2", from, to, override)
+	if e == nil {
+		_ = d.cache.Evict(from.Path(), true)
+		_ = d.cache.Evict(utils.PathParent(from.Path()), false)
+	}
+	return entry, e
+}
+
+// List lists the folder page by page, by the cursor of the previous page
+func (d *Dropbox) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	resp, e := d.c.Post(ctx, "/files/list_folder", nil,
+		req.NewJsonBody(types.M{"path": apiPath(path), "limit": listLimit}))
+	entries := make([]types.IEntry, 0)
+	for {
+		if e != nil {
+			return nil, e
+		}
+		res := listFolderResult{}
+		if e := resp.Json(&res); e != nil {
+			return nil, e
+		}
+		for _, m := range res.Entries {
+			if m.Tag == "deleted" {
+				continue
+			}
+			entries = append(entries, d.newEntry(path, m))
+		}
+		if !res.HasMore {
+			break
+		}
+		resp, e = d.c.Post(ctx, "/files/list_folder/continue", nil,
+			req.NewJsonBody(types.M{"cursor": res.Cursor}))
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+func (d *Dropbox) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	resp, e := d.c.Post(ctx, "/files/delete_v2", nil, req.NewJsonBody(types.M{"path": apiPath(path)}))
+	if e != nil {
+		return e
+	}
+	_ = resp.Dispose()
+	_ = d.cache.Evict(path, true)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return nil
+}
+
+func (d *Dropbox) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *Dropbox) toEntry(parent string, resp req.Response) (*dropboxEntry, error) {
+	res := metadataResult{}
+	if e := resp.Json(&res); e != nil {
+		return nil, e
+	}
+	return d.newEntry(parent, res.Metadata), nil
+}
+
+func (d *Dropbox) newEntry(parent string, m metadata) *dropboxEntry {
+	modTime := int64(-1)
+	if t, e := time.Parse(time.RFC3339, m.ServerModified); e == nil {
+		modTime = utils.Millisecond(t)
+	}
+	return &dropboxEntry{
+		d:       d,
+		id:      m.Id,
+		path:    path2.Join(parent, m.Name),
+		isDir:   m.Tag == "folder",
+		size:    m.Size,
+		modTime: modTime,
+		hash:    m.ContentHash,
+	}
+}
+
+type dropboxEntry struct {
+	d       *Dropbox
+	id      string
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+
+	// hash is the Dropbox content hash
+	hash string
+
+	link          string
+	linkExpiresAt int64
+}
+
+func (e *dropboxEntry) Path() string {
+	return e.path
+}
+
+func (e *dropboxEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *dropboxEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *dropboxEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *dropboxEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *dropboxEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *dropboxEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *dropboxEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	u, ee := e.GetURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	return drive_util.GetURL(ctx, u.URL, nil)
+}
+
+// GetURL returns the temporary link of the file, so the downloads are redirected to Dropbox
+func (e *dropboxEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	if e.linkExpiresAt <= time.Now().Unix() {
+		resp, ee := e.d.c.Post(ctx, "/files/get_temporary_link", nil,
+			req.NewJsonBody(types.M{"path": apiPath(e.path)}))
+		if ee != nil {
+			return nil, ee
+		}
+		res := temporaryLinkResult{}
+		if ee := resp.Json(&res); ee != nil {
+			return nil, ee
+		}
+		e.link = res.Link
+		e.linkExpiresAt = time.Now().Add(linkTTL).Unix()
+		_ = e.d.cache.PutEntry(e, e.d.cacheTTL)
+	}
+	return &types.ContentURL{URL: e.link, Proxy: e.d.downloadProxy}, nil
+}
+
+func (e *dropboxEntry) EntryData() types.SM {
+	return types.SM{
+		"id": e.id,
+		"h":  e.hash,
+		"l":  e.link,
+		"le": strconv.FormatInt(e.linkExpiresAt, 10),
+	}
+}
+
+func (e *dropboxEntry) StableID() string {
+	return e.id
+}
+
+func (e *dropboxEntry) ContentHash(context.Context) (string, string, error) {
+	if e.hash == "" {
+		return "", "", err.NewUnsupportedError()
+	}
+	return "dropbox", e.hash, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, e := c.r.Read(p)
+	c.n += int64(n)
+	return n, e
+}
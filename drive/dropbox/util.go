@@ -0,0 +1,117 @@
+package dropbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"golang.org/x/oauth2"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+func oauthReq(c common.Config) *drive_util.OAuthRequest {
+	return &drive_util.OAuthRequest{
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   "https://www.dropbox.com/oauth2/authorize",
+			TokenURL:  "https://api.dropboxapi.com/oauth2/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+		RedirectURL: c.OAuthRedirectURI,
+		Text:        i18n.T("drive.dropbox.oauth_text"),
+		// the access tokens are short-lived, a refresh token is issued only for the offline access
+		AutoCodeOption: []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("token_access_type", "offline")},
+	}
+}
+
+func InitConfig(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (*drive_util.DriveInitConfig, error) {
+	initConfig, resp, e := drive_util.OAuthInitConfig(*oauthReq(driveUtils.Config), config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	if resp == nil {
+		return initConfig, nil
+	}
+	c, e := req.NewClient(apiURL, nil, ifApiCallError, resp.Client(nil))
+	if e != nil {
+		return nil, e
+	}
+
+	// get user
+	account := fullAccount{}
+	r, e := c.Post(ctx, "/users/get_current_account", nil, nil)
+	if e == nil {
+		e = r.Json(&account)
+	}
+	initConfig.Configured = e == nil
+	if e == nil {
+		initConfig.OAuth.Principal = fmt.Sprintf("%s <%s>", account.Name.DisplayName, account.Email)
+	}
+	return initConfig, nil
+}
+
+func Init(ctx context.Context, data types.SM, config drive_util.DriveConfig, utils drive_util.DriveUtils) error {
+	_, e := drive_util.OAuthInit(ctx, *oauthReq(utils.Config), data, config, utils.Data)
+	return e
+}
+
+// apiPath returns the path in the Dropbox API, the root is the empty string
+func apiPath(path string) string {
+	if utils.IsRootPath(path) {
+		return ""
+	}
+	return "/" + path
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	defer func() { _ = resp.Dispose() }()
+	body, e := ioutil.ReadAll(io.LimitReader(resp.Response().Body, 64*1024))
+	if e != nil {
+		return e
+	}
+	ae := apiError{status: resp.Status()}
+	// the errors of the endpoints are in json, the others are in plain text
+	if json.Unmarshal(body, &ae) != nil || ae.Summary == "" {
+		ae.Summary = strings.TrimSpace(string(body))
+	}
+	switch {
+	case resp.Status() == http.StatusConflict && strings.Contains(ae.Summary, "not_found"):
+		return err.NewNotFoundError()
+	case resp.Status() == http.StatusConflict && strings.Contains(ae.Summary, "/conflict"):
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	case resp.Status() == http.StatusUnauthorized:
+		return err.NewUnauthorizedError(ae.Summary)
+	}
+	return err.NewRemoteApiError(500, i18n.T("drive.dropbox.remote_error", ae.Error()))
+}
+
+func (d *Dropbox) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	ed := ec.Data
+	if ed == nil || (ed["id"] == "" && !utils.IsRootPath(ec.Path)) {
+		return nil, errors.New("invalid cache")
+	}
+	return &dropboxEntry{
+		d: d, id: ed["id"],
+		path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+		hash:          ed["h"],
+		link:          ed["l"],
+		linkExpiresAt: utils.ToInt64(ed["le"], -1),
+	}, nil
+}
@@ -2,6 +2,8 @@ package drive
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"go-drive/common/drive_util"
 	"go-drive/common/errors"
 	"go-drive/common/i18n"
@@ -10,9 +12,12 @@ import (
 	"go-drive/common/utils"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func init() {
@@ -21,47 +26,178 @@ func init() {
 		DisplayName: i18n.T("drive.fs.name"),
 		README:      i18n.T("drive.fs.readme"),
 		ConfigForm: []types.FormItem{
-			{Field: "path", Label: i18n.T("drive.fs.form.path.label"), Type: "text", Required: true, Description: i18n.T("drive.fs.form.path.description")},
+			{Field: "path", Label: i18n.T("drive.fs.form.path.label"), Type: "text", Description: i18n.T("drive.fs.form.path.description")},
+			{Field: "roots", Label: i18n.T("drive.fs.form.roots.label"), Type: "textarea", Description: i18n.T("drive.fs.form.roots.description")},
+			{Field: "soft_delete", Label: i18n.T("drive.fs.form.soft_delete.label"), Type: "text", Description: i18n.T("drive.fs.form.soft_delete.description")},
+			{Field: "min_free_space", Label: i18n.T("drive.fs.form.min_free_space.label"), Type: "text", Description: i18n.T("drive.fs.form.min_free_space.description")},
+			{Field: "file_lock", Label: i18n.T("drive.fs.form.file_lock.label"), Type: "checkbox", Description: i18n.T("drive.fs.form.file_lock.description")},
 		},
 		Factory: drive_util.DriveFactory{Create: NewFsDrive},
 	})
 }
 
+// fileLockRetryInterval is the interval of retrying to acquire a file lock held by others
+const fileLockRetryInterval = 50 * time.Millisecond
+
+// fsTrashDir is where the soft deleted files are kept, relative to the drive root
+const fsTrashDir = ".deleted"
+
 type FsDrive struct {
-	path string
+	path   string
+	locker drive_util.PathLocker
+
+	// softDelete is the window in which deleted files can be restored, soft delete is disabled if <= 0
+	softDelete  time.Duration
+	stopSweeper func()
+
+	signatures *fsDirSignatures
+	ids        *fsIDHints
+
+	// writes are refused if the free space would drop below minFreeBytes or minFreePercent of the disk
+	minFreeBytes   uint64
+	minFreePercent float64
+	diskSpace      func(path string) (free uint64, total uint64, e error)
+
+	// fileLock enables the OS level file locks, readers hold shared locks and writers hold exclusive locks
+	fileLock bool
+}
+
+type fsOptions struct {
+	locker         drive_util.PathLocker
+	softDelete     time.Duration
+	minFreeBytes   uint64
+	minFreePercent float64
+	fileLock       bool
 }
 
 type fsFile struct {
 	drive *FsDrive
 	path  string
 
-	size  int64
-	isDir bool
+	size     int64
+	isDir    bool
+	writable bool
 
 	modTime int64
+
+	// id is the stable id of the file, see fileID
+	id string
 }
 
-// NewFsDrive creates a file system drive
+// NewFsDrive creates a file system drive.
+// If 'roots' is configured, a MultiFsDrive presenting each root as a top-level directory is created.
 func NewFsDrive(_ context.Context, config drive_util.DriveConfig,
 	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
-	path := config["path"]
-	if utils.CleanPath(path) == "" {
-		return nil, err.NewNotAllowedMessageError(i18n.T("drive.fs.invalid_root_path"))
-	}
-
 	localRoot, e := driveUtils.Config.GetLocalFsDir()
 	if e != nil {
 		return nil, e
 	}
+	opts := fsOptions{locker: driveUtils.Locker, fileLock: config["file_lock"] != ""}
+	if opts.locker == nil {
+		opts.locker = drive_util.NewMemLocker()
+	}
+	opts.softDelete, e = time.ParseDuration(config["soft_delete"])
+	if e != nil {
+		opts.softDelete = -1
+	}
+	if s := strings.TrimSpace(config["min_free_space"]); s != "" {
+		opts.minFreeBytes, opts.minFreePercent, e = parseFsMinFreeSpace(s)
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	if strings.TrimSpace(config["roots"]) != "" {
+		roots, e := parseFsRoots(config["roots"])
+		if e != nil {
+			return nil, e
+		}
+		drives := make(map[string]*FsDrive, len(roots))
+		for name, path := range roots {
+			f, e := newFsDrive(localRoot, path, opts)
+			if e != nil {
+				for _, d := range drives {
+					_ = d.Dispose()
+				}
+				return nil, e
+			}
+			drives[name] = f
+		}
+		return newMultiFsDrive(drives), nil
+	}
 
-	path, e = filepath.Abs(filepath.Join(localRoot, path))
+	return newFsDrive(localRoot, config["path"], opts)
+}
+
+func newFsDrive(localRoot, path string, opts fsOptions) (*FsDrive, error) {
+	if utils.CleanPath(path) == "" {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.fs.invalid_root_path"))
+	}
+	path, e := filepath.Abs(filepath.Join(localRoot, path))
 	if e != nil {
 		return nil, e
 	}
 	if exists, _ := utils.FileExists(path); !exists {
 		return nil, err.NewNotFoundMessageError(i18n.T("drive.fs.root_path_not_exists"))
 	}
-	return &FsDrive{path}, nil
+	f := &FsDrive{
+		path:           path,
+		locker:         opts.locker,
+		softDelete:     opts.softDelete,
+		signatures:     newFsDirSignatures(),
+		ids:            newFsIDHints(),
+		minFreeBytes:   opts.minFreeBytes,
+		minFreePercent: opts.minFreePercent,
+		diskSpace:      diskSpace,
+		fileLock:       opts.fileLock,
+	}
+	if f.softDelete > 0 {
+		interval := f.softDelete / 4
+		if interval > 10*time.Minute {
+			interval = 10 * time.Minute
+		}
+		f.stopSweeper = utils.TimeTick(f.sweepTrash, interval)
+	}
+	return f, nil
+}
+
+// parseFsMinFreeSpace parses the threshold in bytes like '10G', or in percent of the disk like '5%'
+func parseFsMinFreeSpace(s string) (uint64, float64, error) {
+	if strings.HasSuffix(s, "%") {
+		percent, e := strconv.ParseFloat(strings.TrimSpace(s[:len(s)-1]), 64)
+		if e != nil || percent < 0 || percent >= 100 {
+			return 0, 0, err.NewBadRequestError(i18n.T("drive.fs.invalid_min_free_space", s))
+		}
+		return 0, percent, nil
+	}
+	bytes, e := utils.ParseBytes(s)
+	if e != nil {
+		return 0, 0, err.NewBadRequestError(i18n.T("drive.fs.invalid_min_free_space", s))
+	}
+	return bytes, 0, nil
+}
+
+// checkFreeSpace checks whether writing size bytes keeps the free space above the threshold.
+// If size is unknown(< 0), only the current free space is checked.
+func (f *FsDrive) checkFreeSpace(size int64) error {
+	if f.minFreeBytes == 0 && f.minFreePercent <= 0 {
+		return nil
+	}
+	free, total, e := f.diskSpace(f.path)
+	if e != nil {
+		return e
+	}
+	if size > 0 {
+		if uint64(size) > free {
+			free = 0
+		} else {
+			free -= uint64(size)
+		}
+	}
+	if free < f.minFreeBytes || (total > 0 && float64(free)*100/float64(total) < f.minFreePercent) {
+		return err.NewQuotaExceededError(i18n.T("drive.fs.insufficient_space"))
+	}
+	return nil
 }
 
 func (f *FsDrive) newFsFile(path string, file os.FileInfo) (types.IEntry, error) {
@@ -69,25 +205,27 @@ func (f *FsDrive) newFsFile(path string, file os.FileInfo) (types.IEntry, error)
 	if e != nil {
 		return nil, err.NewNotFoundMessageError(i18n.T("drive.invalid_path"))
 	}
+	absPath := path
 	if !strings.HasPrefix(path, f.path) {
 		panic("invalid file key")
 	}
-	path = strings.ReplaceAll(path, "\\", "/")
-	path = path[len(f.path):]
-	for strings.HasPrefix(path, "/") {
-		path = path[1:]
-	}
+	path = utils.NormalizePath(path[len(f.path):])
+	id := fileID(file)
+	f.ids.set(id, path)
 	return &fsFile{
-		drive:   f,
-		path:    path,
-		size:    file.Size(),
-		isDir:   file.IsDir(),
-		modTime: utils.Millisecond(file.ModTime()),
+		drive:    f,
+		path:     path,
+		size:     file.Size(),
+		isDir:    file.IsDir(),
+		writable: isWritable(absPath, file),
+		modTime:  utils.Millisecond(file.ModTime()),
+		id:       id,
 	}, nil
 }
 
 func (f *FsDrive) getPath(path string) string {
-	path = filepath.Clean(path)
+	// cleaning it as an absolute path keeps it inside the root
+	path = filepath.Clean(string(filepath.Separator) + path)
 	return filepath.Join(f.path, path)
 }
 
@@ -95,6 +233,25 @@ func (f *FsDrive) isRootPath(path string) bool {
 	return filepath.Clean(path) == f.path
 }
 
+// lock locks the real paths, paths are keyed by the absolute path
+// so that drives sharing the same directory are coordinated.
+// The directory signatures of the paths are invalidated when unlocked.
+func (f *FsDrive) lock(ctx context.Context, paths ...string) (func(), error) {
+	keys := make([]string, len(paths))
+	for i, p := range paths {
+		keys[i] = "fs:" + p
+	}
+	unlock, e := drive_util.LockAll(ctx, f.locker, keys...)
+	if e != nil {
+		return nil, e
+	}
+	return func() {
+		// all modifications lock the paths, so the signatures are invalidated here
+		f.touch(paths...)
+		unlock()
+	}, nil
+}
+
 func (f *FsDrive) Get(_ context.Context, path string) (types.IEntry, error) {
 	path = f.getPath(path)
 	stat, e := os.Stat(path)
@@ -107,14 +264,25 @@ func (f *FsDrive) Get(_ context.Context, path string) (types.IEntry, error) {
 	return f.newFsFile(path, stat)
 }
 
-func (f *FsDrive) Save(ctx types.TaskCtx, path string, _ int64, override bool, reader io.Reader) (types.IEntry, error) {
+func (f *FsDrive) Save(ctx types.TaskCtx, path string, size int64, override bool, reader io.Reader) (types.IEntry, error) {
+	if e := f.checkFreeSpace(size); e != nil {
+		return nil, e
+	}
 	path = f.getPath(path)
+	unlock, e := f.lock(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	defer unlock()
 	if !override {
 		if e := requireFile(path, false); e != nil {
 			return nil, e
 		}
 	}
-	file, e := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if e := requireWritable(path, false); e != nil {
+		return nil, e
+	}
+	file, e := f.openForWrite(ctx, path)
 	if e != nil {
 		return nil, e
 	}
@@ -130,11 +298,72 @@ func (f *FsDrive) Save(ctx types.TaskCtx, path string, _ int64, override bool, r
 	return f.newFsFile(path, stat)
 }
 
+// openForWrite opens the file at the real path for writing, and truncates it.
+// If file lock is enabled, the file is truncated after the exclusive lock is acquired,
+// so that the readers holding shared locks will not see a partial file.
+func (f *FsDrive) openForWrite(ctx context.Context, path string) (*os.File, error) {
+	if !f.fileLock {
+		return os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	}
+	file, e := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if e != nil {
+		return nil, e
+	}
+	if e := lockFile(ctx, file, true); e != nil {
+		_ = file.Close()
+		return nil, e
+	}
+	if e := file.Truncate(0); e != nil {
+		_ = file.Close()
+		return nil, e
+	}
+	return file, nil
+}
+
+// openForRead opens the file at the real path for reading, holding a shared lock if file lock is enabled.
+// The lock is released when the file is closed.
+func (f *FsDrive) openForRead(ctx context.Context, path string) (*os.File, error) {
+	file, e := os.Open(path)
+	if e != nil || !f.fileLock {
+		return file, e
+	}
+	if e := lockFile(ctx, file, false); e != nil {
+		_ = file.Close()
+		return nil, e
+	}
+	return file, nil
+}
+
+// retryLock calls tryLock until it acquires the lock, or returns an error, or ctx is done
+func retryLock(ctx context.Context, tryLock func() (bool, error)) error {
+	for {
+		ok, e := tryLock()
+		if e != nil || ok {
+			return e
+		}
+		timer := time.NewTimer(fileLockRetryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
 func (f *FsDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
 	path = f.getPath(path)
+	unlock, e := f.lock(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	defer unlock()
 	if exists, _ := utils.FileExists(path); exists {
 		return f.Get(ctx, path)
 	}
+	if e := f.checkFreeSpace(0); e != nil {
+		return nil, e
+	}
 	if e := os.Mkdir(path, 0755); e != nil {
 		return nil, e
 	}
@@ -156,7 +385,7 @@ func (f *FsDrive) isSelf(entry types.IEntry) bool {
 	return false
 }
 
-func (f *FsDrive) Move(_ types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+func (f *FsDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
 	from = drive_util.GetIEntry(from, f.isSelf)
 	if from == nil {
 		return nil, err.NewUnsupportedError()
@@ -166,24 +395,67 @@ func (f *FsDrive) Move(_ types.TaskCtx, from types.IEntry, to string, override b
 	if f.isRootPath(fromPath) || f.isRootPath(toPath) {
 		return nil, err.NewNotAllowedError()
 	}
+	unlock, e := f.lock(ctx, fromPath, toPath)
+	if e != nil {
+		return nil, e
+	}
+	defer unlock()
 	if e := requireFile(fromPath, true); e != nil {
 		return nil, e
 	}
+	if e := requireWritable(fromPath, true); e != nil {
+		return nil, e
+	}
+	if e := requireWritable(toPath, false); e != nil {
+		return nil, e
+	}
 	exists, e := utils.FileExists(toPath)
 	if e != nil {
 		return nil, e
 	}
-	if exists {
-		if !override {
-			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	if exists && !override {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	// the source is renamed next to the target first, so the target is replaced only if it's ready
+	tempPath := moveTempPath(toPath, utils.Millisecond(time.Now()))
+	crossDevice := false
+	if e := os.Rename(fromPath, tempPath); e != nil {
+		if !isCrossDevice(e) {
+			return nil, e
+		}
+		log.Printf("[fs] moving '%s' across devices, it will be copied and verified before it's removed", from.Path())
+		if e := copyDir(ctx, fromPath, tempPath); e != nil {
+			_ = os.RemoveAll(tempPath)
+			return nil, e
 		}
-		if e := f.Delete(task.DummyContext(), to); e != nil {
+		if e := verifyCopiedDir(ctx, fromPath, tempPath, drive_util.MoveVerifyHash(ctx)); e != nil {
+			_ = os.RemoveAll(tempPath)
 			return nil, e
 		}
+		crossDevice = true
+	}
+	rollback := func() {
+		if crossDevice {
+			_ = os.RemoveAll(tempPath)
+		} else {
+			_ = os.Rename(tempPath, fromPath)
+		}
 	}
-	if e := os.Rename(fromPath, toPath); e != nil {
+	if exists {
+		if e := f.delete(toPath); e != nil {
+			rollback()
+			return nil, e
+		}
+	}
+	if e := os.Rename(tempPath, toPath); e != nil {
+		rollback()
 		return nil, e
 	}
+	if crossDevice {
+		if e := os.RemoveAll(fromPath); e != nil {
+			log.Printf("[fs] error when removing the source '%s' of the move: %v", fromPath, e)
+		}
+	}
 	stat, e := os.Stat(toPath)
 	if e != nil {
 		return nil, e
@@ -191,6 +463,202 @@ func (f *FsDrive) Move(_ types.TaskCtx, from types.IEntry, to string, override b
 	return f.newFsFile(toPath, stat)
 }
 
+// Publish replaces target with the staging directory.
+// The staging directory is renamed next to target first, then the old target is moved aside
+// and the new one is renamed into place, the old target is removed on success.
+// On the same device, only the two renames of the swap are visible to readers.
+// If staging is on another device, it's copied next to target before the swap,
+// and removed after the swap, which is not atomic.
+func (f *FsDrive) Publish(ctx types.TaskCtx, staging, target string) (types.IEntry, error) {
+	stagingPath := f.getPath(staging)
+	targetPath := f.getPath(target)
+	if f.isRootPath(stagingPath) || f.isRootPath(targetPath) || stagingPath == targetPath ||
+		strings.HasPrefix(targetPath, stagingPath+string(filepath.Separator)) ||
+		strings.HasPrefix(stagingPath, targetPath+string(filepath.Separator)) {
+		return nil, err.NewNotAllowedError()
+	}
+	unlock, e := f.lock(ctx, stagingPath, targetPath)
+	if e != nil {
+		return nil, e
+	}
+	defer unlock()
+	isDir, e := utils.IsDir(stagingPath)
+	if os.IsNotExist(e) {
+		return nil, err.NewNotFoundMessageError(i18n.T("drive.file_not_exists"))
+	}
+	if e != nil {
+		return nil, e
+	}
+	if !isDir {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.fs.publish_not_dir"))
+	}
+	if e := requireWritable(stagingPath, true); e != nil {
+		return nil, e
+	}
+	if e := requireWritable(targetPath, true); e != nil {
+		return nil, e
+	}
+
+	now := utils.Millisecond(time.Now())
+	newPath := publishTempPath(targetPath, "new", now)
+	oldPath := publishTempPath(targetPath, "old", now)
+
+	crossDevice := false
+	if e := os.Rename(stagingPath, newPath); e != nil {
+		if !isCrossDevice(e) {
+			return nil, e
+		}
+		log.Printf("[fs] publishing '%s' across devices, it will be copied and the staging directory is removed after", staging)
+		if e := copyDir(ctx, stagingPath, newPath); e != nil {
+			_ = os.RemoveAll(newPath)
+			return nil, e
+		}
+		crossDevice = true
+	}
+	rollback := func() {
+		if crossDevice {
+			_ = os.RemoveAll(newPath)
+		} else {
+			_ = os.Rename(newPath, stagingPath)
+		}
+	}
+
+	targetExists, e := utils.FileExists(targetPath)
+	if e != nil {
+		rollback()
+		return nil, e
+	}
+	if targetExists {
+		if e := os.Rename(targetPath, oldPath); e != nil {
+			rollback()
+			return nil, e
+		}
+	}
+	if e := os.Rename(newPath, targetPath); e != nil {
+		if targetExists {
+			_ = os.Rename(oldPath, targetPath)
+		}
+		rollback()
+		return nil, e
+	}
+
+	if targetExists {
+		if e := os.RemoveAll(oldPath); e != nil {
+			log.Printf("[fs] error when removing the old published directory '%s': %v", oldPath, e)
+		}
+	}
+	if crossDevice {
+		if e := os.RemoveAll(stagingPath); e != nil {
+			log.Printf("[fs] error when removing the staging directory '%s': %v", stagingPath, e)
+		}
+	}
+	stat, e := os.Stat(targetPath)
+	if e != nil {
+		return nil, e
+	}
+	return f.newFsFile(targetPath, stat)
+}
+
+// publishTempPath returns a hidden sibling of target used during publishing
+func publishTempPath(target, kind string, now int64) string {
+	return filepath.Join(filepath.Dir(target),
+		"."+filepath.Base(target)+".publish-"+kind+"-"+strconv.FormatInt(now, 10))
+}
+
+// moveTempPath returns a hidden sibling of target used during moving
+func moveTempPath(target string, now int64) string {
+	return filepath.Join(filepath.Dir(target),
+		"."+filepath.Base(target)+".move-"+strconv.FormatInt(now, 10))
+}
+
+// verifyCopiedDir checks that to has all files of from, of the same sizes, and contents if hash is true
+func verifyCopiedDir(ctx types.TaskCtx, from, to string, hash bool) error {
+	return filepath.Walk(from, func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		if e := ctx.WaitIfPaused(); e != nil {
+			return e
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		rel := path[len(from):]
+		mismatched := err.NewBadRequestError(i18n.T("drive.move_verification_failed",
+			filepath.ToSlash(filepath.Join(filepath.Base(from), rel))))
+		dest, e := os.Stat(filepath.Join(to, rel))
+		if e != nil {
+			if os.IsNotExist(e) {
+				return mismatched
+			}
+			return e
+		}
+		if info.IsDir() != dest.IsDir() || (!info.IsDir() && info.Size() != dest.Size()) {
+			return mismatched
+		}
+		if info.IsDir() || !hash {
+			return nil
+		}
+		srcSum, e := fileSha256(path)
+		if e != nil {
+			return e
+		}
+		destSum, e := fileSha256(filepath.Join(to, rel))
+		if e != nil {
+			return e
+		}
+		if srcSum != destSum {
+			return mismatched
+		}
+		return nil
+	})
+}
+
+func fileSha256(path string) (string, error) {
+	file, e := os.Open(path)
+	if e != nil {
+		return "", e
+	}
+	defer func() { _ = file.Close() }()
+	h := sha256.New()
+	if _, e := io.Copy(h, file); e != nil {
+		return "", e
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyDir(ctx types.TaskCtx, from, to string) error {
+	return filepath.Walk(from, func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		if e := ctx.WaitIfPaused(); e != nil {
+			return e
+		}
+		dest := filepath.Join(to, path[len(from):])
+		if info.IsDir() {
+			return os.Mkdir(dest, info.Mode().Perm())
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		src, e := os.Open(path)
+		if e != nil {
+			return e
+		}
+		defer func() { _ = src.Close() }()
+		dst, e := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_EXCL, info.Mode().Perm())
+		if e != nil {
+			return e
+		}
+		_, e = drive_util.Copy(task.NewProgressCtxWrapper(ctx), dst, src)
+		if ee := dst.Close(); e == nil {
+			e = ee
+		}
+		return e
+	})
+}
+
 func (f *FsDrive) List(_ context.Context, path string) ([]types.IEntry, error) {
 	path = f.getPath(path)
 	isDir, e := utils.IsDir(path)
@@ -204,6 +672,7 @@ func (f *FsDrive) List(_ context.Context, path string) ([]types.IEntry, error) {
 	if ee != nil {
 		return nil, ee
 	}
+	f.signatures.observe(f.relPath(path), directSignature(files))
 	entries := make([]types.IEntry, len(files))
 	for i, file := range files {
 		entry, e := f.newFsFile(filepath.Join(path, file.Name()), file)
@@ -215,19 +684,118 @@ func (f *FsDrive) List(_ context.Context, path string) ([]types.IEntry, error) {
 	return entries, nil
 }
 
-func (f *FsDrive) Delete(_ types.TaskCtx, path string) error {
+// DirSignature returns the signature of the directory subtree, see fsDirSignatures
+func (f *FsDrive) DirSignature(_ context.Context, path string) (string, error) {
+	path = f.getPath(path)
+	files, e := ioutil.ReadDir(path)
+	if os.IsNotExist(e) {
+		return "", err.NewNotFoundError()
+	}
+	if e != nil {
+		return "", e
+	}
+	return f.signatures.observe(f.relPath(path), directSignature(files)), nil
+}
+
+func (f *FsDrive) Delete(ctx types.TaskCtx, path string) error {
 	path = f.getPath(path)
 	if f.isRootPath(path) {
 		return err.NewNotAllowedMessageError(i18n.T("drive.fs.cannot_delete_root"))
 	}
+	unlock, e := f.lock(ctx, path)
+	if e != nil {
+		return e
+	}
+	defer unlock()
+	return f.delete(path)
+}
+
+func (f *FsDrive) delete(path string) error {
 	if e := requireFile(path, true); e != nil {
 		return e
 	}
+	if e := requireWritable(path, true); e != nil {
+		return e
+	}
+	if f.softDelete > 0 && !f.isInTrash(path) {
+		return f.moveToTrash(path)
+	}
 	return os.RemoveAll(path)
 }
 
+func (f *FsDrive) trashPath() string {
+	return filepath.Join(f.path, fsTrashDir)
+}
+
+func (f *FsDrive) isInTrash(path string) bool {
+	trash := f.trashPath()
+	return path == trash || strings.HasPrefix(path, trash+string(filepath.Separator))
+}
+
+// moveToTrash renames the file to .deleted/<timestamp>-<name>
+func (f *FsDrive) moveToTrash(path string) error {
+	trash := f.trashPath()
+	if e := os.MkdirAll(trash, 0755); e != nil {
+		return e
+	}
+	name := filepath.Base(path)
+	now := utils.Millisecond(time.Now())
+	for {
+		dest := filepath.Join(trash, strconv.FormatInt(now, 10)+"-"+name)
+		exists, e := utils.FileExists(dest)
+		if e != nil {
+			return e
+		}
+		if !exists {
+			return os.Rename(path, dest)
+		}
+		now++
+	}
+}
+
+// sweepTrash permanently removes the files deleted before the soft delete window
+func (f *FsDrive) sweepTrash() {
+	files, e := ioutil.ReadDir(f.trashPath())
+	if e != nil {
+		if !os.IsNotExist(e) {
+			log.Printf("error when reading trash of fs drive '%s': %v", f.path, e)
+		}
+		return
+	}
+	notBefore := utils.Millisecond(time.Now().Add(-f.softDelete))
+	n := 0
+	for _, file := range files {
+		i := strings.IndexByte(file.Name(), '-')
+		if i <= 0 {
+			continue
+		}
+		deletedAt, e := strconv.ParseInt(file.Name()[:i], 10, 64)
+		if e != nil || deletedAt >= notBefore {
+			continue
+		}
+		if e := os.RemoveAll(filepath.Join(f.trashPath(), file.Name())); e != nil {
+			log.Printf("error when removing '%s' from trash: %v", file.Name(), e)
+			continue
+		}
+		n++
+	}
+	if n > 0 && utils.IsDebugOn() {
+		log.Printf("%d expired deleted files of fs drive '%s' removed", n, f.path)
+	}
+}
+
+func (f *FsDrive) Dispose() error {
+	if f.stopSweeper != nil {
+		f.stopSweeper()
+	}
+	return nil
+}
+
 func (f *FsDrive) Upload(_ context.Context, path string, size int64,
 	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if e := f.checkFreeSpace(size); e != nil {
+		return nil, e
+	}
 	path = f.getPath(path)
 	if !override {
 		if e := requireFile(path, false); e != nil {
@@ -251,10 +819,47 @@ func requireFile(path string, requireExists bool) error {
 	return nil
 }
 
+// requireWritable checks whether the file at path can be modified by this process.
+// If the file exists, itself must be writable.
+// If parentRequired or the file does not exist, its parent must be writable to create, rename or remove it.
+func requireWritable(path string, parentRequired bool) error {
+	stat, e := os.Stat(path)
+	if e != nil && !os.IsNotExist(e) {
+		return e
+	}
+	if e == nil && !isWritable(path, stat) {
+		return err.NewPermissionDeniedError(i18n.T("drive.fs.file_readonly", filepath.Base(path)))
+	}
+	if e == nil && !parentRequired {
+		return nil
+	}
+	parent := filepath.Dir(path)
+	parentStat, e := os.Stat(parent)
+	if e != nil {
+		if os.IsNotExist(e) {
+			return err.NewNotFoundMessageError(i18n.T("drive.file_not_exists"))
+		}
+		return e
+	}
+	if !isWritable(parent, parentStat) {
+		return err.NewPermissionDeniedError(i18n.T("drive.fs.file_readonly", filepath.Base(parent)))
+	}
+	return nil
+}
+
 func (f *FsDrive) Meta(context.Context) types.DriveMeta {
 	return types.DriveMeta{CanWrite: true}
 }
 
+// Space returns the space of the file system of the root
+func (f *FsDrive) Space(context.Context, string) (int64, int64, error) {
+	free, total, e := f.diskSpace(f.path)
+	if e != nil {
+		return 0, 0, e
+	}
+	return int64(free), int64(total), nil
+}
+
 func (f *fsFile) Path() string {
 	return f.path
 }
@@ -274,7 +879,7 @@ func (f *fsFile) Size() int64 {
 }
 
 func (f *fsFile) Meta() types.EntryMeta {
-	return types.EntryMeta{CanRead: true, CanWrite: true}
+	return types.EntryMeta{CanRead: true, CanWrite: f.writable}
 }
 
 func (f *fsFile) ModTime() int64 {
@@ -289,7 +894,7 @@ func (f *fsFile) Name() string {
 	return utils.PathBase(f.path)
 }
 
-func (f *fsFile) GetReader(context.Context) (io.ReadCloser, error) {
+func (f *fsFile) GetReader(ctx context.Context) (io.ReadCloser, error) {
 	if !f.Type().IsFile() {
 		return nil, err.NewNotAllowedError()
 	}
@@ -301,7 +906,32 @@ func (f *fsFile) GetReader(context.Context) (io.ReadCloser, error) {
 	if !exists {
 		return nil, err.NewNotFoundMessageError(i18n.T("drive.file_not_exists"))
 	}
-	return os.Open(path)
+	return f.drive.openForRead(ctx, path)
+}
+
+func (f *fsFile) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	reader, e := f.GetReader(ctx)
+	if e != nil {
+		return nil, e
+	}
+	file := reader.(*os.File)
+	if _, e := file.Seek(offset, io.SeekStart); e != nil {
+		_ = file.Close()
+		return nil, e
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return &limitedFile{Reader: io.LimitReader(file, length), file: file}, nil
+}
+
+type limitedFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (l *limitedFile) Close() error {
+	return l.file.Close()
 }
 
 func (f *fsFile) GetURL(context.Context) (*types.ContentURL, error) {
@@ -0,0 +1,131 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"go-drive/common/errors"
+	"go-drive/common/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxFsIDHints is the maximum number of hints kept, the hints are dropped when it's exceeded
+const maxFsIDHints = 10000
+
+var errIDFound = errors.New("found")
+
+// fsIDHints maps the stable ids(device and inode) of the files to their last seen paths.
+// Hints are recorded whenever an entry is created from a stat, so they are refreshed on access.
+//
+// Limits of the ids:
+//   - an inode can be reused after the file is deleted, so an id may resolve to another file
+//   - the device number may change after remounting, and may be unstable on network file systems
+//   - files replaced by writing a new file and renaming it(as many editors do) get new ids
+type fsIDHints struct {
+	mux   sync.Mutex
+	paths map[string]string
+}
+
+func newFsIDHints() *fsIDHints {
+	return &fsIDHints{paths: make(map[string]string)}
+}
+
+func (h *fsIDHints) set(id, path string) {
+	if h == nil || id == "" {
+		return
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if len(h.paths) >= maxFsIDHints {
+		h.paths = make(map[string]string)
+	}
+	h.paths[id] = path
+}
+
+func (h *fsIDHints) get(id string) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	p, ok := h.paths[id]
+	return p, ok
+}
+
+// ResolveID finds the entry by its stable id.
+// The last seen path of the id is checked first, then the whole drive is walked.
+func (f *FsDrive) ResolveID(ctx context.Context, id string) (types.IEntry, error) {
+	if id == "" {
+		return nil, err.NewNotFoundError()
+	}
+	if p, ok := f.ids.get(id); ok {
+		realPath := f.getPath(p)
+		if stat, e := os.Stat(realPath); e == nil && fileID(stat) == id {
+			return f.newFsFile(realPath, stat)
+		}
+	}
+	found := ""
+	e := filepath.Walk(f.path, func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return nil
+		}
+		if e := ctx.Err(); e != nil {
+			return e
+		}
+		if info.IsDir() && path == filepath.Join(f.path, fsTrashDir) {
+			return filepath.SkipDir
+		}
+		if fileID(info) == id {
+			found = path
+			return errIDFound
+		}
+		return nil
+	})
+	if e != nil && e != errIDFound {
+		return nil, e
+	}
+	if found == "" {
+		return nil, err.NewNotFoundError()
+	}
+	stat, e := os.Stat(found)
+	if e != nil {
+		return nil, err.NewNotFoundError()
+	}
+	return f.newFsFile(found, stat)
+}
+
+func (f *fsFile) StableID() string {
+	return f.id
+}
+
+// ResolveID finds the entry by the id returned by multiFsEntry.StableID, which is prefixed by the root name
+func (m *MultiFsDrive) ResolveID(ctx context.Context, id string) (types.IEntry, error) {
+	i := strings.IndexByte(id, ':')
+	if i <= 0 {
+		return nil, err.NewNotFoundError()
+	}
+	name := id[:i]
+	root, ok := m.roots[name]
+	if !ok {
+		return nil, err.NewNotFoundError()
+	}
+	entry, e := root.ResolveID(ctx, id[i+1:])
+	if e != nil {
+		return nil, e
+	}
+	return m.wrap(name, entry), nil
+}
+
+func (e *multiFsEntry) StableID() string {
+	s, ok := e.entry.(types.IStableID)
+	if !ok {
+		return ""
+	}
+	id := s.StableID()
+	if id == "" {
+		return ""
+	}
+	return strings.SplitN(e.path, "/", 2)[0] + ":" + id
+}
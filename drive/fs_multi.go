@@ -0,0 +1,331 @@
+package drive
+
+import (
+	"bufio"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// MultiFsDrive presents several FsDrives as one drive,
+// each root is a top-level virtual directory, requests are routed by the first path segment.
+// The virtual root itself is read-only.
+type MultiFsDrive struct {
+	roots map[string]*FsDrive
+	names []string
+}
+
+// parseFsRoots parses roots config, one root per line in the form of 'name=path'
+func parseFsRoots(s string) (map[string]string, error) {
+	roots := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return nil, err.NewBadRequestError(i18n.T("drive.fs.invalid_roots", line))
+		}
+		name := strings.TrimSpace(line[:i])
+		rootPath := strings.TrimSpace(line[i+1:])
+		if strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+			return nil, err.NewBadRequestError(i18n.T("drive.fs.invalid_roots", line))
+		}
+		if _, ok := roots[name]; ok {
+			return nil, err.NewBadRequestError(i18n.T("drive.fs.invalid_roots", line))
+		}
+		roots[name] = rootPath
+	}
+	if len(roots) == 0 {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.fs.invalid_root_path"))
+	}
+	return roots, nil
+}
+
+func newMultiFsDrive(roots map[string]*FsDrive) *MultiFsDrive {
+	names := make([]string, 0, len(roots))
+	for name := range roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &MultiFsDrive{roots: roots, names: names}
+}
+
+// resolve returns the root drive and the path in that root
+func (m *MultiFsDrive) resolve(p string) (string, *FsDrive, string, error) {
+	p = utils.CleanPath(p)
+	name := p
+	rest := ""
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		name = p[:i]
+		rest = p[i+1:]
+	}
+	root, ok := m.roots[name]
+	if !ok {
+		return "", nil, "", err.NewNotFoundError()
+	}
+	return name, root, rest, nil
+}
+
+// resolveWritable is the same as resolve, but the virtual root and top-level directories are not writable
+func (m *MultiFsDrive) resolveWritable(p string) (string, *FsDrive, string, error) {
+	if utils.PathDepth(p) <= 1 {
+		return "", nil, "", err.NewNotAllowedMessageError(i18n.T("drive.fs.multi_root_readonly"))
+	}
+	return m.resolve(p)
+}
+
+func (m *MultiFsDrive) wrap(name string, entry types.IEntry) types.IEntry {
+	return &multiFsEntry{m: m, path: path.Join(name, entry.Path()), entry: entry}
+}
+
+func (m *MultiFsDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (m *MultiFsDrive) Get(ctx context.Context, p string) (types.IEntry, error) {
+	if utils.IsRootPath(p) {
+		return &multiFsRootEntry{m: m, path: ""}, nil
+	}
+	name, root, rest, e := m.resolve(p)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := root.Get(ctx, rest)
+	if e != nil {
+		return nil, e
+	}
+	return m.wrap(name, entry), nil
+}
+
+func (m *MultiFsDrive) Save(ctx types.TaskCtx, p string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	name, root, rest, e := m.resolveWritable(p)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := root.Save(ctx, rest, size, override, reader)
+	if e != nil {
+		return nil, e
+	}
+	return m.wrap(name, entry), nil
+}
+
+func (m *MultiFsDrive) MakeDir(ctx context.Context, p string) (types.IEntry, error) {
+	name, root, rest, e := m.resolveWritable(p)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := root.MakeDir(ctx, rest)
+	if e != nil {
+		return nil, e
+	}
+	return m.wrap(name, entry), nil
+}
+
+func (m *MultiFsDrive) Publish(ctx types.TaskCtx, staging, target string) (types.IEntry, error) {
+	name, root, stagingRest, e := m.resolveWritable(staging)
+	if e != nil {
+		return nil, e
+	}
+	targetName, _, targetRest, e := m.resolveWritable(target)
+	if e != nil {
+		return nil, e
+	}
+	if name != targetName {
+		return nil, err.NewUnsupportedError()
+	}
+	entry, e := root.Publish(ctx, stagingRest, targetRest)
+	if e != nil {
+		return nil, e
+	}
+	return m.wrap(name, entry), nil
+}
+
+// DirSignature of the virtual root is the combination of the signatures of all roots
+func (m *MultiFsDrive) DirSignature(ctx context.Context, p string) (string, error) {
+	if utils.IsRootPath(p) {
+		sb := strings.Builder{}
+		for _, name := range m.names {
+			sig, e := m.roots[name].DirSignature(ctx, "")
+			if e != nil {
+				return "", e
+			}
+			sb.WriteString(name + "=" + sig + ";")
+		}
+		return sb.String(), nil
+	}
+	_, root, rest, e := m.resolve(p)
+	if e != nil {
+		return "", e
+	}
+	return root.DirSignature(ctx, rest)
+}
+
+func (m *MultiFsDrive) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (m *MultiFsDrive) isSelf(entry types.IEntry) bool {
+	if me, ok := entry.(*multiFsEntry); ok {
+		return me.m == m
+	}
+	return false
+}
+
+func (m *MultiFsDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	fromEntry := drive_util.GetIEntry(from, m.isSelf)
+	if fromEntry == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	if _, _, _, e := m.resolveWritable(fromEntry.Path()); e != nil {
+		return nil, e
+	}
+	name, root, rest, e := m.resolveWritable(to)
+	if e != nil {
+		return nil, e
+	}
+	// moving across roots is unsupported by the FsDrive of the destination
+	entry, e := root.Move(ctx, from, rest, override)
+	if e != nil {
+		return nil, e
+	}
+	return m.wrap(name, entry), nil
+}
+
+func (m *MultiFsDrive) List(ctx context.Context, p string) ([]types.IEntry, error) {
+	if utils.IsRootPath(p) {
+		entries := make([]types.IEntry, len(m.names))
+		for i, name := range m.names {
+			entries[i] = &multiFsRootEntry{m: m, path: name}
+		}
+		return entries, nil
+	}
+	name, root, rest, e := m.resolve(p)
+	if e != nil {
+		return nil, e
+	}
+	entries, e := root.List(ctx, rest)
+	if e != nil {
+		return nil, e
+	}
+	for i, entry := range entries {
+		entries[i] = m.wrap(name, entry)
+	}
+	return entries, nil
+}
+
+func (m *MultiFsDrive) Delete(ctx types.TaskCtx, p string) error {
+	_, root, rest, e := m.resolveWritable(p)
+	if e != nil {
+		return e
+	}
+	return root.Delete(ctx, rest)
+}
+
+func (m *MultiFsDrive) Upload(ctx context.Context, p string, size int64,
+	override bool, config types.SM) (*types.DriveUploadConfig, error) {
+	_, root, rest, e := m.resolveWritable(p)
+	if e != nil {
+		return nil, e
+	}
+	return root.Upload(ctx, rest, size, override, config)
+}
+
+func (m *MultiFsDrive) Dispose() error {
+	for _, d := range m.roots {
+		_ = d.Dispose()
+	}
+	return nil
+}
+
+// multiFsRootEntry is the virtual root or a top-level directory of MultiFsDrive
+type multiFsRootEntry struct {
+	m    *MultiFsDrive
+	path string
+}
+
+func (r *multiFsRootEntry) Path() string {
+	return r.path
+}
+
+func (r *multiFsRootEntry) Type() types.EntryType {
+	return types.TypeDir
+}
+
+func (r *multiFsRootEntry) Size() int64 {
+	return -1
+}
+
+func (r *multiFsRootEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: false}
+}
+
+func (r *multiFsRootEntry) ModTime() int64 {
+	return -1
+}
+
+func (r *multiFsRootEntry) Drive() types.IDrive {
+	return r.m
+}
+
+type multiFsEntry struct {
+	m     *MultiFsDrive
+	path  string
+	entry types.IEntry
+}
+
+func (e *multiFsEntry) Path() string {
+	return e.path
+}
+
+func (e *multiFsEntry) Type() types.EntryType {
+	return e.entry.Type()
+}
+
+func (e *multiFsEntry) Size() int64 {
+	return e.entry.Size()
+}
+
+func (e *multiFsEntry) Meta() types.EntryMeta {
+	return e.entry.Meta()
+}
+
+func (e *multiFsEntry) ModTime() int64 {
+	return e.entry.ModTime()
+}
+
+func (e *multiFsEntry) Drive() types.IDrive {
+	return e.m
+}
+
+func (e *multiFsEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *multiFsEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	if content, ok := e.entry.(types.IContent); ok {
+		return content.GetReader(ctx)
+	}
+	return nil, err.NewNotAllowedError()
+}
+
+func (e *multiFsEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if content, ok := e.entry.(types.IContent); ok {
+		return content.GetURL(ctx)
+	}
+	return nil, err.NewNotAllowedError()
+}
+
+func (e *multiFsEntry) GetIEntry() types.IEntry {
+	return e.entry
+}
@@ -0,0 +1,91 @@
+package drive
+
+import (
+	"go-drive/common/utils"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fsDirSignatures tracks the changes of directory subtrees.
+//
+// The signature of a directory is its direct signature(children count and max mtime of the children)
+// plus a generation, the generation of a directory and all its ancestors is bumped when:
+//   - the directory or its descendants are modified through the drive
+//   - the direct signature observed by List or DirSignature differs from the last observed one
+//
+// So changes made outside the drive are detected only after the changed directory is listed.
+type fsDirSignatures struct {
+	mux    sync.Mutex
+	gen    map[string]uint64
+	direct map[string]string
+}
+
+func newFsDirSignatures() *fsDirSignatures {
+	return &fsDirSignatures{gen: make(map[string]uint64), direct: make(map[string]string)}
+}
+
+func (s *fsDirSignatures) bump(path string) {
+	for {
+		s.gen[path]++
+		if path == "" {
+			return
+		}
+		path = utils.PathParent(path)
+	}
+}
+
+// touch is called after the paths were modified
+func (s *fsDirSignatures) touch(paths ...string) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, p := range paths {
+		s.bump(utils.PathParent(p))
+		// the path itself may be a directory that was replaced or removed
+		delete(s.direct, p)
+		s.gen[p]++
+	}
+}
+
+// observe records the direct signature of the directory, and returns the full signature
+func (s *fsDirSignatures) observe(path, direct string) string {
+	if s == nil {
+		return direct
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if last, ok := s.direct[path]; ok && last != direct {
+		s.bump(path)
+	}
+	s.direct[path] = direct
+	return direct + ":" + strconv.FormatUint(s.gen[path], 10)
+}
+
+// relPath converts the real path to the path relative to the drive root
+func (f *FsDrive) relPath(path string) string {
+	path = strings.ReplaceAll(strings.TrimPrefix(path, f.path), "\\", "/")
+	return utils.CleanPath(path)
+}
+
+func directSignature(files []os.FileInfo) string {
+	var maxModTime int64
+	for _, file := range files {
+		if t := file.ModTime().UnixNano(); t > maxModTime {
+			maxModTime = t
+		}
+	}
+	return strconv.Itoa(len(files)) + "-" + strconv.FormatInt(maxModTime, 36)
+}
+
+func (f *FsDrive) touch(paths ...string) {
+	rel := make([]string, len(paths))
+	for i, p := range paths {
+		rel[i] = f.relPath(filepath.Clean(p))
+	}
+	f.signatures.touch(rel...)
+}
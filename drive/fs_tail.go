@@ -0,0 +1,167 @@
+package drive
+
+import (
+	"context"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// fsTailMaxBytes is the maximum size of the content returned by GetTail
+	fsTailMaxBytes = 1024 * 1024
+	// fsFollowChunkSize is the maximum size of the content of each call of the Follow callback
+	fsFollowChunkSize = 64 * 1024
+	// fsTailChunkSize is the size of the chunks read backwards to find the line boundaries
+	fsTailChunkSize = 8 * 1024
+	// fsFollowInterval is the interval of checking the followed file
+	fsFollowInterval = 500 * time.Millisecond
+)
+
+func (f *FsDrive) GetTail(ctx context.Context, path string, n int) ([]byte, int64, error) {
+	file, e := f.openTailFile(ctx, path)
+	if e != nil {
+		return nil, 0, e
+	}
+	defer func() { _ = file.Close() }()
+	stat, e := file.Stat()
+	if e != nil {
+		return nil, 0, e
+	}
+	size := stat.Size()
+	start, e := tailStart(file, size, n)
+	if e != nil {
+		return nil, 0, e
+	}
+	data := make([]byte, size-start)
+	if _, e := file.ReadAt(data, start); e != nil && e != io.EOF {
+		return nil, 0, e
+	}
+	return data, size, nil
+}
+
+// tailStart finds the offset of the last n lines, the trailing line break does not start a new line
+func tailStart(file *os.File, size int64, n int) (int64, error) {
+	if n <= 0 {
+		return size, nil
+	}
+	buf := make([]byte, fsTailChunkSize)
+	end := size
+	lines := 0
+	for end > 0 && size-end < fsTailMaxBytes {
+		chunk := buf
+		if end < int64(len(chunk)) {
+			chunk = chunk[:end]
+		}
+		offset := end - int64(len(chunk))
+		if _, e := file.ReadAt(chunk, offset); e != nil && e != io.EOF {
+			return 0, e
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] != '\n' || offset+int64(i) == size-1 {
+				continue
+			}
+			lines++
+			if lines == n {
+				return offset + int64(i) + 1, nil
+			}
+		}
+		end = offset
+	}
+	if size-end >= fsTailMaxBytes {
+		return size - fsTailMaxBytes, nil
+	}
+	return 0, nil
+}
+
+// Follow checks the file every fsFollowInterval.
+// The file is regarded as replaced if its fileID changed, or as truncated if its size shrank.
+func (f *FsDrive) Follow(ctx context.Context, path string, offset int64, fn func([]byte, bool) error) error {
+	realPath := f.getPath(path)
+	id := ""
+	if stat, e := os.Stat(realPath); e == nil {
+		id = fileID(stat)
+	}
+	buf := make([]byte, fsFollowChunkSize)
+	for {
+		stat, e := os.Stat(realPath)
+		if e != nil && !os.IsNotExist(e) {
+			return e
+		}
+		if e == nil {
+			if newID := fileID(stat); newID != id || stat.Size() < offset {
+				id = newID
+				offset = 0
+				if e := fn(nil, true); e != nil {
+					return e
+				}
+			}
+			for stat.Size() > offset {
+				n, e := f.readTail(ctx, realPath, offset, buf)
+				if e != nil {
+					return e
+				}
+				if n == 0 {
+					break
+				}
+				offset += int64(n)
+				if e := fn(buf[:n], false); e != nil {
+					return e
+				}
+			}
+		}
+		timer := time.NewTimer(fsFollowInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+func (f *FsDrive) readTail(ctx context.Context, realPath string, offset int64, buf []byte) (int, error) {
+	file, e := f.openForRead(ctx, realPath)
+	if e != nil {
+		return 0, e
+	}
+	defer func() { _ = file.Close() }()
+	n, e := file.ReadAt(buf, offset)
+	if e != nil && e != io.EOF {
+		return 0, e
+	}
+	return n, nil
+}
+
+func (f *FsDrive) openTailFile(ctx context.Context, path string) (*os.File, error) {
+	realPath := f.getPath(path)
+	stat, e := os.Stat(realPath)
+	if os.IsNotExist(e) {
+		return nil, err.NewNotFoundMessageError(i18n.T("drive.file_not_exists"))
+	}
+	if e != nil {
+		return nil, e
+	}
+	if stat.IsDir() {
+		return nil, err.NewNotAllowedError()
+	}
+	return f.openForRead(ctx, realPath)
+}
+
+func (m *MultiFsDrive) GetTail(ctx context.Context, p string, n int) ([]byte, int64, error) {
+	_, root, rest, e := m.resolve(p)
+	if e != nil {
+		return nil, 0, e
+	}
+	return root.GetTail(ctx, rest, n)
+}
+
+func (m *MultiFsDrive) Follow(ctx context.Context, p string, offset int64, fn func([]byte, bool) error) error {
+	_, root, rest, e := m.resolve(p)
+	if e != nil {
+		return e
+	}
+	return root.Follow(ctx, rest, offset, fn)
+}
@@ -0,0 +1,417 @@
+package drive
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFsDrive(t *testing.T) (*FsDrive, string) {
+	if runtime.GOOS != "windows" && os.Geteuid() == 0 {
+		t.Skip("file permissions are not enforced for root")
+	}
+	dir, e := ioutil.TempDir("", "go-drive-fs")
+	if e != nil {
+		t.Fatal(e)
+	}
+	return &FsDrive{path: dir, locker: drive_util.NewMemLocker()}, dir
+}
+
+func TestFsReadonlyFile(t *testing.T) {
+	d, dir := newTestFsDrive(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if e := ioutil.WriteFile(filepath.Join(dir, "ro.txt"), []byte("ro"), 0444); e != nil {
+		t.Fatal(e)
+	}
+	if e := ioutil.WriteFile(filepath.Join(dir, "rw.txt"), []byte("rw"), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	ro, e := d.Get(context.Background(), "ro.txt")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if ro.Meta().CanWrite {
+		t.Errorf("read-only file should not be writable")
+	}
+	rw, e := d.Get(context.Background(), "rw.txt")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !rw.Meta().CanWrite {
+		t.Errorf("file should be writable")
+	}
+
+	_, e = d.Save(task.DummyContext(), "ro.txt", 2, true, strings.NewReader("ww"))
+	if _, ok := e.(err.PermissionDeniedError); !ok {
+		t.Errorf("expect PermissionDeniedError when saving, but it's %v", e)
+	}
+	_, e = d.Move(task.DummyContext(), ro, "moved.txt", false)
+	if _, ok := e.(err.PermissionDeniedError); !ok {
+		t.Errorf("expect PermissionDeniedError when moving, but it's %v", e)
+	}
+	e = d.Delete(task.DummyContext(), "ro.txt")
+	if _, ok := e.(err.PermissionDeniedError); !ok {
+		t.Errorf("expect PermissionDeniedError when deleting, but it's %v", e)
+	}
+	if e := d.Delete(task.DummyContext(), "rw.txt"); e != nil {
+		t.Errorf("failed to delete writable file: %v", e)
+	}
+}
+
+func TestFsReadonlyDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("read-only attribute of directories is not enforced on windows")
+	}
+	d, dir := newTestFsDrive(t)
+	defer func() {
+		_ = os.Chmod(filepath.Join(dir, "ro"), 0755)
+		_ = os.RemoveAll(dir)
+	}()
+
+	roDir := filepath.Join(dir, "ro")
+	if e := os.Mkdir(roDir, 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := ioutil.WriteFile(filepath.Join(roDir, "a.txt"), []byte("a"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Chmod(roDir, 0555); e != nil {
+		t.Fatal(e)
+	}
+
+	entry, e := d.Get(context.Background(), "ro")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if entry.Meta().CanWrite {
+		t.Errorf("read-only dir should not be writable")
+	}
+	_, e = d.Save(task.DummyContext(), "ro/b.txt", 1, false, strings.NewReader("b"))
+	if _, ok := e.(err.PermissionDeniedError); !ok {
+		t.Errorf("expect PermissionDeniedError when creating file, but it's %v", e)
+	}
+	e = d.Delete(task.DummyContext(), "ro/a.txt")
+	if _, ok := e.(err.PermissionDeniedError); !ok {
+		t.Errorf("expect PermissionDeniedError when deleting child, but it's %v", e)
+	}
+}
+
+func TestFsPublish(t *testing.T) {
+	dir, e := ioutil.TempDir("", "go-drive-fs")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	d := &FsDrive{path: dir, locker: drive_util.NewMemLocker()}
+
+	for _, f := range []string{"site/index.html", "staging/index.html", "staging/new.html"} {
+		if e := os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0755); e != nil {
+			t.Fatal(e)
+		}
+		if e := ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	if _, e := d.Publish(task.DummyContext(), "staging", "site"); e != nil {
+		t.Fatal(e)
+	}
+	dat, e := ioutil.ReadFile(filepath.Join(dir, "site", "index.html"))
+	if e != nil || string(dat) != "staging/index.html" {
+		t.Errorf("expect the staged content to be published, but it's '%s', %v", dat, e)
+	}
+	files, e := ioutil.ReadDir(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(files) != 1 || files[0].Name() != "site" {
+		t.Errorf("expect the staging and old directories to be removed, but there are %d files", len(files))
+	}
+
+	if _, e := d.Publish(task.DummyContext(), "not-exists", "site"); !err.IsNotFoundError(e) {
+		t.Errorf("expect NotFoundError when staging does not exist, but it's %v", e)
+	}
+}
+
+func TestFsDirSignature(t *testing.T) {
+	dir, e := ioutil.TempDir("", "go-drive-fs")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	d := &FsDrive{path: dir, locker: drive_util.NewMemLocker(), signatures: newFsDirSignatures()}
+	if _, e := d.MakeDir(context.Background(), "a"); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := d.MakeDir(context.Background(), "a/b"); e != nil {
+		t.Fatal(e)
+	}
+
+	sig1, e := d.DirSignature(context.Background(), "")
+	if e != nil {
+		t.Fatal(e)
+	}
+	sig2, _ := d.DirSignature(context.Background(), "")
+	if sig1 != sig2 {
+		t.Errorf("signature changed without modification: '%s' -> '%s'", sig1, sig2)
+	}
+
+	if _, e := d.Save(task.DummyContext(), "a/b/c.txt", 1, false, strings.NewReader("c")); e != nil {
+		t.Fatal(e)
+	}
+	sig3, _ := d.DirSignature(context.Background(), "")
+	if sig3 == sig2 {
+		t.Errorf("signature of root should change after a descendant is modified")
+	}
+
+	// changes made outside the drive are detected when the directory is listed
+	if _, e := d.List(context.Background(), "a/b"); e != nil {
+		t.Fatal(e)
+	}
+	if e := ioutil.WriteFile(filepath.Join(dir, "a", "b", "d.txt"), []byte("d"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := d.List(context.Background(), "a/b"); e != nil {
+		t.Fatal(e)
+	}
+	sig4, _ := d.DirSignature(context.Background(), "")
+	if sig4 == sig3 {
+		t.Errorf("signature of root should change after an outside change is listed")
+	}
+}
+
+func TestFsMinFreeSpace(t *testing.T) {
+	dir, e := ioutil.TempDir("", "go-drive-fs")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	free := uint64(1000)
+	d := &FsDrive{
+		path: dir, locker: drive_util.NewMemLocker(),
+		minFreeBytes: 100,
+		diskSpace: func(string) (uint64, uint64, error) {
+			return free, 10000, nil
+		},
+	}
+
+	if _, e := d.Save(task.DummyContext(), "a.txt", 900, false, strings.NewReader("a")); e != nil {
+		t.Errorf("expect success when the free space is enough, but it's %v", e)
+	}
+	if _, e := d.Save(task.DummyContext(), "b.txt", 901, false, strings.NewReader("b")); !err.IsQuotaExceededError(e) {
+		t.Errorf("expect QuotaExceededError when the declared size is too large, but it's %v", e)
+	}
+	free = 99
+	if _, e := d.Save(task.DummyContext(), "c.txt", -1, false, strings.NewReader("c")); !err.IsQuotaExceededError(e) {
+		t.Errorf("expect QuotaExceededError for unknown size when the free space is low, but it's %v", e)
+	}
+	if _, e := d.MakeDir(context.Background(), "d"); !err.IsQuotaExceededError(e) {
+		t.Errorf("expect QuotaExceededError when making dir, but it's %v", e)
+	}
+
+	d.minFreeBytes = 0
+	d.minFreePercent = 5
+	free = 600
+	if _, e := d.Upload(context.Background(), "e.txt", 50, false, nil); e != nil {
+		t.Errorf("expect success above the percent threshold, but it's %v", e)
+	}
+	if _, e := d.Upload(context.Background(), "e.txt", 200, false, nil); !err.IsQuotaExceededError(e) {
+		t.Errorf("expect QuotaExceededError below the percent threshold, but it's %v", e)
+	}
+	if free, total, e := d.Space(context.Background(), ""); e != nil || free != 600 || total != 10000 {
+		t.Errorf("unexpected space: %d, %d, %v", free, total, e)
+	}
+}
+
+func TestFsFileLock(t *testing.T) {
+	dir, e := ioutil.TempDir("", "go-drive-fs")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	d := &FsDrive{path: dir, locker: drive_util.NewMemLocker(), fileLock: true}
+	path := filepath.Join(dir, "a.txt")
+	if e := ioutil.WriteFile(path, []byte("old"), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	w, e := d.openForWrite(context.Background(), path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, e := d.openForRead(ctx, path); e == nil {
+		t.Error("expect reading to be blocked while the file is being written")
+	}
+	if _, e := w.WriteString("new"); e != nil {
+		t.Fatal(e)
+	}
+	_ = w.Close()
+
+	r, e := d.openForRead(context.Background(), path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// shared locks do not block each other
+	r2, e := d.openForRead(context.Background(), path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	_ = r2.Close()
+	dat, e := ioutil.ReadAll(r)
+	_ = r.Close()
+	if e != nil || string(dat) != "new" {
+		t.Errorf("expect 'new', but it's '%s', %v", dat, e)
+	}
+}
+
+func TestFsStableID(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stable ids are not supported on windows")
+	}
+	dir, e := ioutil.TempDir("", "go-drive-fs")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	d := &FsDrive{path: dir, locker: drive_util.NewMemLocker(), ids: newFsIDHints()}
+	if e := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	entry, e := d.Get(context.Background(), "a.txt")
+	if e != nil {
+		t.Fatal(e)
+	}
+	id := entry.(*fsFile).StableID()
+	if id == "" {
+		t.Fatal("expect a stable id")
+	}
+
+	if e := os.Mkdir(filepath.Join(dir, "b"), 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Rename(filepath.Join(dir, "a.txt"), filepath.Join(dir, "b", "c.txt")); e != nil {
+		t.Fatal(e)
+	}
+	// the hint is stale, so the drive is walked
+	entry, e = d.ResolveID(context.Background(), id)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if entry.Path() != "b/c.txt" || entry.(*fsFile).StableID() != id {
+		t.Errorf("expect 'b/c.txt' with the same id, but it's '%s'", entry.Path())
+	}
+
+	if _, e := d.ResolveID(context.Background(), "0-0"); !err.IsNotFoundError(e) {
+		t.Errorf("expect not found error, but it's %v", e)
+	}
+}
+
+func TestFsTail(t *testing.T) {
+	dir, e := ioutil.TempDir("", "go-drive-fs")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	d := &FsDrive{path: dir, locker: drive_util.NewMemLocker()}
+	path := filepath.Join(dir, "a.log")
+	if e := ioutil.WriteFile(path, []byte("1\n2\n3\n4\n"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	data, offset, e := d.GetTail(context.Background(), "a.log", 2)
+	if e != nil || string(data) != "3\n4\n" || offset != 8 {
+		t.Errorf("expect '3\\n4\\n' at 8, but it's '%s' at %d, %v", data, offset, e)
+	}
+	data, _, _ = d.GetTail(context.Background(), "a.log", 10)
+	if string(data) != "1\n2\n3\n4\n" {
+		t.Errorf("expect all the lines, but it's '%s'", data)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan string, 10)
+	go func() {
+		_ = d.Follow(ctx, "a.log", offset, func(data []byte, reset bool) error {
+			if reset {
+				received <- "<reset>"
+			}
+			if len(data) > 0 {
+				received <- string(data)
+			}
+			return nil
+		})
+	}()
+	expect := func(want string) {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("expect '%s', but it's '%s'", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for '%s'", want)
+		}
+	}
+
+	f, e := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, _ = f.WriteString("5\n")
+	_ = f.Close()
+	expect("5\n")
+
+	// truncated
+	if e := ioutil.WriteFile(path, []byte("x\n"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	expect("<reset>")
+	expect("x\n")
+}
+
+func TestFsVerifyCopiedDir(t *testing.T) {
+	dir, e := ioutil.TempDir("", "go-drive-fs")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	from, to := filepath.Join(dir, "from"), filepath.Join(dir, "to")
+	for _, d := range []string{from, to} {
+		if e := os.MkdirAll(filepath.Join(d, "sub"), 0755); e != nil {
+			t.Fatal(e)
+		}
+	}
+	write := func(path, content string) {
+		if e := ioutil.WriteFile(path, []byte(content), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+	write(filepath.Join(from, "sub", "a.txt"), "hello")
+	write(filepath.Join(to, "sub", "a.txt"), "hello")
+	if e := verifyCopiedDir(task.DummyContext(), from, to, true); e != nil {
+		t.Fatalf("expect the copy to be verified, but %v", e)
+	}
+
+	write(filepath.Join(to, "sub", "a.txt"), "hallo")
+	if e := verifyCopiedDir(task.DummyContext(), from, to, false); e != nil {
+		t.Errorf("expect the sizes only to be compared, but %v", e)
+	}
+	if e := verifyCopiedDir(task.DummyContext(), from, to, true); e == nil {
+		t.Error("expect the different contents to fail the verification")
+	}
+
+	write(filepath.Join(from, "b.txt"), "missing")
+	if e := verifyCopiedDir(task.DummyContext(), from, to, false); e == nil {
+		t.Error("expect the missing file to fail the verification")
+	}
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+// +build !windows
+
+package drive
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// accessWriteOK is W_OK of access(2)
+const accessWriteOK = 0x2
+
+// isWritable checks whether the file can be modified by the running process,
+// according to the file mode and ownership.
+func isWritable(path string, _ os.FileInfo) bool {
+	return syscall.Access(path, accessWriteOK) == nil
+}
+
+// isCrossDevice checks whether the rename failed because the paths are on different devices
+func isCrossDevice(e error) bool {
+	le, ok := e.(*os.LinkError)
+	return ok && le.Err == syscall.EXDEV
+}
+
+// diskSpace returns the space available to unprivileged users and the total space of the file system
+func diskSpace(path string) (uint64, uint64, error) {
+	stat := syscall.Statfs_t{}
+	if e := syscall.Statfs(path, &stat); e != nil {
+		return 0, 0, e
+	}
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize), nil
+}
+
+// lockFile acquires an advisory lock(flock) of the whole file, shared or exclusive.
+// It retries until the lock is acquired or ctx is done. The lock is released when the file is closed.
+func lockFile(ctx context.Context, file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return retryLock(ctx, func() (bool, error) {
+		e := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		if e == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return e == nil, e
+	})
+}
+
+// fileID returns the device and inode of the file as its stable id
+func fileID(info os.FileInfo) string {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatUint(uint64(st.Dev), 16) + "-" + strconv.FormatUint(uint64(st.Ino), 16)
+}
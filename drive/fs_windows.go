@@ -0,0 +1,77 @@
+package drive
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procGetDiskFreeSpaceEx = kernel32.NewProc("GetDiskFreeSpaceExW")
+	procLockFileEx         = kernel32.NewProc("LockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	// errorLockViolation is ERROR_LOCK_VIOLATION
+	errorLockViolation = syscall.Errno(33)
+)
+
+// errorNotSameDevice is ERROR_NOT_SAME_DEVICE
+const errorNotSameDevice = syscall.Errno(17)
+
+// isWritable checks the read-only attribute of the file
+func isWritable(_ string, info os.FileInfo) bool {
+	return info.Mode().Perm()&0200 != 0
+}
+
+// isCrossDevice checks whether the rename failed because the paths are on different volumes
+func isCrossDevice(e error) bool {
+	le, ok := e.(*os.LinkError)
+	return ok && le.Err == errorNotSameDevice
+}
+
+// diskSpace returns the space available to the user and the total space of the volume
+func diskSpace(path string) (uint64, uint64, error) {
+	p, e := syscall.UTF16PtrFromString(path)
+	if e != nil {
+		return 0, 0, e
+	}
+	var free, total, totalFree uint64
+	r, _, e := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&free)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&totalFree)))
+	if r == 0 {
+		return 0, 0, e
+	}
+	return free, total, nil
+}
+
+// lockFile acquires a lock(LockFileEx) of the whole file, shared or exclusive.
+// It retries until the lock is acquired or ctx is done. The lock is released when the file is closed.
+// Unlike flock, locks on Windows are mandatory.
+func lockFile(ctx context.Context, file *os.File, exclusive bool) error {
+	flags := uint32(lockfileFailImmediately)
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+	return retryLock(ctx, func() (bool, error) {
+		ol := syscall.Overlapped{}
+		r, _, e := procLockFileEx.Call(file.Fd(), uintptr(flags), 0,
+			0xffffffff, 0xffffffff, uintptr(unsafe.Pointer(&ol)))
+		if r != 0 {
+			return true, nil
+		}
+		if e == errorLockViolation {
+			return false, nil
+		}
+		return false, e
+	})
+}
+
+// fileID is not supported on Windows, as the file index is not available from os.FileInfo
+func fileID(os.FileInfo) string {
+	return ""
+}
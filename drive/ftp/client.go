@@ -0,0 +1,402 @@
+package ftp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"golang.org/x/text/encoding"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dialTimeout   = 30 * time.Second
+	acceptTimeout = 30 * time.Second
+	// replyTimeout is the time to wait for a reply on the control connection
+	replyTimeout = 60 * time.Second
+)
+
+const (
+	tlsNone     = ""
+	tlsExplicit = "explicit"
+	tlsImplicit = "implicit"
+)
+
+type dialOptions struct {
+	host     string
+	port     string
+	username string
+	password string
+	tls      string
+	active   bool
+	// charset is the encoding of the paths, nil for UTF-8
+	charset   encoding.Encoding
+	tlsConfig *tls.Config
+}
+
+// conn is a control connection of the FTP protocol, it's not safe for concurrent use
+type conn struct {
+	opts     *dialOptions
+	netConn  net.Conn
+	tp       *textproto.Conn
+	features map[string]string
+	// epsvFailed is true when the server does not understand EPSV, PASV is used then
+	epsvFailed bool
+	lastUsed   time.Time
+}
+
+var errUnexpectedResponse = errors.New("unexpected ftp response")
+
+func dial(ctx context.Context, opts *dialOptions) (*conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	netConn, e := dialer.DialContext(ctx, "tcp", net.JoinHostPort(opts.host, opts.port))
+	if e != nil {
+		return nil, e
+	}
+	if opts.tls == tlsImplicit {
+		netConn = tls.Client(netConn, opts.tlsConfig)
+	}
+	c := &conn{opts: opts, netConn: netConn, tp: textproto.NewConn(netConn), lastUsed: time.Now()}
+	if e := c.init(); e != nil {
+		_ = c.tp.Close()
+		return nil, e
+	}
+	return c, nil
+}
+
+func (c *conn) init() error {
+	_ = c.netConn.SetDeadline(time.Now().Add(replyTimeout))
+	if _, _, e := c.tp.ReadResponse(2); e != nil {
+		return mapError(e)
+	}
+	if c.opts.tls == tlsExplicit {
+		if _, _, e := c.cmd(2, "AUTH TLS"); e != nil {
+			return e
+		}
+		c.netConn = tls.Client(c.netConn, c.opts.tlsConfig)
+		c.tp = textproto.NewConn(c.netConn)
+	}
+	code, _, e := c.cmd(0, "USER %s", c.opts.username)
+	if e != nil {
+		return e
+	}
+	if code == 331 {
+		code, _, e = c.cmd(0, "PASS %s", c.opts.password)
+		if e != nil {
+			return e
+		}
+	}
+	if code != 230 && code != 202 {
+		return err.NewUnauthorizedError(i18n.T("drive.ftp.wrong_user_or_password"))
+	}
+	if c.opts.tls != tlsNone {
+		if _, _, e := c.cmd(2, "PBSZ 0"); e != nil {
+			return e
+		}
+		if _, _, e := c.cmd(2, "PROT P"); e != nil {
+			return e
+		}
+	}
+	c.features = make(map[string]string)
+	if _, msg, e := c.cmd(2, "FEAT"); e == nil {
+		// the first and the last lines are the status
+		lines := strings.Split(msg, "\n")
+		for i := 1; i < len(lines)-1; i++ {
+			line := strings.TrimSpace(lines[i])
+			if line == "" {
+				continue
+			}
+			kv := strings.SplitN(line, " ", 2)
+			c.features[strings.ToUpper(kv[0])] = ""
+			if len(kv) == 2 {
+				c.features[strings.ToUpper(kv[0])] = kv[1]
+			}
+		}
+	}
+	if _, ok := c.features["UTF8"]; ok && c.opts.charset == nil {
+		_, _, _ = c.cmd(2, "OPTS UTF8 ON")
+	}
+	if _, _, e := c.cmd(2, "TYPE I"); e != nil {
+		return e
+	}
+	return nil
+}
+
+// cmd sends the command, and reads the response, expectCode is checked as textproto.Reader.ReadResponse does
+func (c *conn) cmd(expectCode int, format string, args ...interface{}) (int, string, error) {
+	_ = c.netConn.SetDeadline(time.Now().Add(replyTimeout))
+	if _, e := c.tp.Cmd(format, args...); e != nil {
+		return 0, "", e
+	}
+	code, msg, e := c.tp.ReadResponse(expectCode)
+	if e != nil {
+		return code, msg, mapError(e)
+	}
+	return code, msg, nil
+}
+
+func (c *conn) hasFeature(name string) bool {
+	_, ok := c.features[name]
+	return ok
+}
+
+// encodePath encodes path to the charset of the server
+func (c *conn) encodePath(path string) (string, error) {
+	if path == "" {
+		path = "/"
+	} else if path[0] != '/' {
+		path = "/" + path
+	}
+	if c.opts.charset == nil {
+		return path, nil
+	}
+	return c.opts.charset.NewEncoder().String(path)
+}
+
+// decodeName decodes the name sent by the server
+func (c *conn) decodeName(name string) string {
+	if c.opts.charset == nil {
+		return name
+	}
+	decoded, e := c.opts.charset.NewDecoder().String(name)
+	if e != nil {
+		return name
+	}
+	return decoded
+}
+
+// pathCmd sends the command with the encoded path as the argument
+func (c *conn) pathCmd(expectCode int, cmd, path string) (int, string, error) {
+	p, e := c.encodePath(path)
+	if e != nil {
+		return 0, "", e
+	}
+	return c.cmd(expectCode, "%s %s", cmd, p)
+}
+
+// openData sends the transfer command, and returns the data connection.
+// The final response of the transfer is read when the returned connection is closed.
+func (c *conn) openData(cmd, path string, offset int64) (*dataConn, error) {
+	p, e := c.encodePath(path)
+	if e != nil {
+		return nil, e
+	}
+	if offset > 0 {
+		if _, _, e := c.cmd(3, "REST %d", offset); e != nil {
+			return nil, e
+		}
+	}
+	var data net.Conn
+	var listener net.Listener
+	if c.opts.active {
+		listener, e = c.listenActive()
+	} else {
+		data, e = c.dialPassive()
+	}
+	if e != nil {
+		return nil, e
+	}
+	if _, _, e := c.cmd(1, "%s %s", cmd, p); e != nil {
+		if listener != nil {
+			_ = listener.Close()
+		}
+		if data != nil {
+			_ = data.Close()
+		}
+		return nil, e
+	}
+	if listener != nil {
+		_ = listener.(*net.TCPListener).SetDeadline(time.Now().Add(acceptTimeout))
+		data, e = listener.Accept()
+		_ = listener.Close()
+		if e != nil {
+			return nil, e
+		}
+	}
+	if c.opts.tls != tlsNone {
+		data = tls.Client(data, c.opts.tlsConfig)
+	}
+	return &dataConn{Conn: data, c: c}, nil
+}
+
+func (c *conn) dialPassive() (net.Conn, error) {
+	host, _, e := net.SplitHostPort(c.netConn.RemoteAddr().String())
+	if e != nil {
+		return nil, e
+	}
+	port := ""
+	if !c.epsvFailed {
+		// 229 Entering Extended Passive Mode (|||port|)
+		_, msg, e := c.cmd(2, "EPSV")
+		if e == nil {
+			start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+			if start < 0 || end < start+4 {
+				return nil, errUnexpectedResponse
+			}
+			port = msg[start+4 : end]
+		} else {
+			c.epsvFailed = true
+		}
+	}
+	if port == "" {
+		// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2),
+		// the address is ignored, as it's often a private address of the server behind NAT
+		_, msg, e := c.cmd(2, "PASV")
+		if e != nil {
+			return nil, e
+		}
+		start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		if start < 0 || end < start {
+			return nil, errUnexpectedResponse
+		}
+		parts := strings.Split(msg[start+1:end], ",")
+		if len(parts) != 6 {
+			return nil, errUnexpectedResponse
+		}
+		p1, e1 := strconv.Atoi(parts[4])
+		p2, e2 := strconv.Atoi(parts[5])
+		if e1 != nil || e2 != nil {
+			return nil, errUnexpectedResponse
+		}
+		port = strconv.Itoa(p1<<8 | p2)
+	}
+	return net.DialTimeout("tcp", net.JoinHostPort(host, port), dialTimeout)
+}
+
+func (c *conn) listenActive() (net.Listener, error) {
+	local := c.netConn.LocalAddr().(*net.TCPAddr)
+	listener, e := net.Listen("tcp", net.JoinHostPort(local.IP.String(), "0"))
+	if e != nil {
+		return nil, e
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	if ip4 := local.IP.To4(); ip4 != nil {
+		_, _, e = c.cmd(2, "PORT %d,%d,%d,%d,%d,%d", ip4[0], ip4[1], ip4[2], ip4[3], port>>8, port&0xff)
+	} else {
+		_, _, e = c.cmd(2, "EPRT |2|%s|%d|", local.IP.String(), port)
+	}
+	if e != nil {
+		_ = listener.Close()
+		return nil, e
+	}
+	return listener, nil
+}
+
+func (c *conn) list(path string) ([]ftpFile, error) {
+	cmd := "LIST"
+	if c.hasFeature("MLST") {
+		cmd = "MLSD"
+	}
+	data, e := c.openData(cmd, path, 0)
+	if e != nil {
+		return nil, e
+	}
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(data)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if e := scanner.Err(); e != nil {
+		_ = data.Close()
+		return nil, e
+	}
+	if e := data.Close(); e != nil {
+		return nil, e
+	}
+	files := make([]ftpFile, 0, len(lines))
+	for _, line := range lines {
+		var f *ftpFile
+		if cmd == "MLSD" {
+			f = parseMLSxLine(line)
+		} else {
+			f = parseListLine(line, time.Now())
+		}
+		if f == nil {
+			continue
+		}
+		f.name = c.decodeName(f.name)
+		files = append(files, *f)
+	}
+	return files, nil
+}
+
+// stat returns the file of path, it's only supported by the servers supporting MLST
+func (c *conn) stat(path string) (*ftpFile, error) {
+	_, msg, e := c.pathCmd(2, "MLST", path)
+	if e != nil {
+		return nil, e
+	}
+	lines := strings.Split(msg, "\n")
+	if len(lines) < 2 {
+		return nil, errUnexpectedResponse
+	}
+	f := parseMLSxLine(strings.TrimPrefix(lines[1], " "))
+	if f == nil {
+		return nil, errUnexpectedResponse
+	}
+	return f, nil
+}
+
+func (c *conn) quit() {
+	_, _ = c.tp.Cmd("QUIT")
+	_ = c.tp.Close()
+}
+
+// dataConn is the data connection of a transfer
+type dataConn struct {
+	net.Conn
+	c *conn
+}
+
+// Close closes the data connection, and reads the result of the transfer
+func (d *dataConn) Close() error {
+	if e := d.Conn.Close(); e != nil {
+		return e
+	}
+	_ = d.c.netConn.SetDeadline(time.Now().Add(replyTimeout))
+	_, _, e := d.c.tp.ReadResponse(2)
+	return mapError(e)
+}
+
+// ftpError is the negative reply of the server
+type ftpError struct {
+	code int
+	msg  string
+}
+
+func (f ftpError) Error() string {
+	return fmt.Sprintf("%d %s", f.code, f.msg)
+}
+
+func mapError(e error) error {
+	if e == nil {
+		return nil
+	}
+	if te, ok := e.(*textproto.Error); ok {
+		return ftpError{code: te.Code, msg: te.Msg}
+	}
+	return e
+}
+
+func isNotFound(e error) bool {
+	fe, ok := e.(ftpError)
+	return ok && (fe.code == 550 || fe.code == 450)
+}
+
+// toDriveError converts the negative replies to the errors of the drive
+func toDriveError(e error) error {
+	if fe, ok := e.(ftpError); ok {
+		if fe.code == 530 {
+			return err.NewUnauthorizedError(i18n.T("drive.ftp.wrong_user_or_password"))
+		}
+		return err.NewRemoteApiError(500, i18n.T("drive.ftp.remote_error", fe.Error()))
+	}
+	return e
+}
@@ -0,0 +1,463 @@
+package ftp
+
+import (
+	"context"
+	"crypto/tls"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"golang.org/x/text/encoding/htmlindex"
+	"io"
+	"path"
+	"strconv"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "ftp",
+		DisplayName: i18n.T("drive.ftp.name"),
+		README:      i18n.T("drive.ftp.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "host", Label: i18n.T("drive.ftp.form.host.label"), Type: "text", Required: true, Description: i18n.T("drive.ftp.form.host.description")},
+			{Field: "port", Label: i18n.T("drive.ftp.form.port.label"), Type: "text", Description: i18n.T("drive.ftp.form.port.description")},
+			{Field: "username", Label: i18n.T("drive.ftp.form.username.label"), Type: "text", Description: i18n.T("drive.ftp.form.username.description")},
+			{Field: "password", Label: i18n.T("drive.ftp.form.password.label"), Type: "password"},
+			{Field: "tls", Label: i18n.T("drive.ftp.form.tls.label"), Type: "select", Description: i18n.T("drive.ftp.form.tls.description"),
+				Options: []types.FormItemOption{
+					{Name: i18n.T("drive.ftp.form.tls.none"), Value: tlsNone},
+					{Name: i18n.T("drive.ftp.form.tls.explicit"), Value: tlsExplicit},
+					{Name: i18n.T("drive.ftp.form.tls.implicit"), Value: tlsImplicit},
+				}},
+			{Field: "tls_skip_verify", Label: i18n.T("drive.ftp.form.tls_skip_verify.label"), Type: "checkbox", Description: i18n.T("drive.ftp.form.tls_skip_verify.description")},
+			{Field: "active", Label: i18n.T("drive.ftp.form.active.label"), Type: "checkbox", Description: i18n.T("drive.ftp.form.active.description")},
+			{Field: "charset", Label: i18n.T("drive.ftp.form.charset.label"), Type: "text", Description: i18n.T("drive.ftp.form.charset.description")},
+			{Field: "max_connections", Label: i18n.T("drive.ftp.form.max_connections.label"), Type: "text", Description: i18n.T("drive.ftp.form.max_connections.description"), DefaultValue: strconv.Itoa(defaultMaxConnections)},
+			{Field: "cache_ttl", Label: i18n.T("drive.ftp.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.ftp.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewFtpDrive},
+	})
+}
+
+const defaultMaxConnections = 4
+
+// FtpDrive is the drive of an FTP server, the connections are pooled
+type FtpDrive struct {
+	pool *connPool
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+}
+
+// NewFtpDrive creates a ftp drive
+func NewFtpDrive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	opts := &dialOptions{
+		host:     config["host"],
+		port:     config["port"],
+		username: config["username"],
+		password: config["password"],
+		tls:      config["tls"],
+		active:   config["active"] != "",
+	}
+	if opts.username == "" {
+		opts.username = "anonymous"
+	}
+	switch opts.tls {
+	case tlsNone, tlsExplicit:
+		if opts.port == "" {
+			opts.port = "21"
+		}
+	case tlsImplicit:
+		if opts.port == "" {
+			opts.port = "990"
+		}
+	default:
+		return nil, err.NewBadRequestError(i18n.T("drive.ftp.invalid_tls", opts.tls))
+	}
+	if opts.tls != tlsNone {
+		opts.tlsConfig = &tls.Config{
+			ServerName:         opts.host,
+			InsecureSkipVerify: config["tls_skip_verify"] != "",
+			// many servers require the data connections to reuse the TLS session of the control connection
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		}
+	}
+	if charset := config["charset"]; charset != "" {
+		enc, e := htmlindex.Get(charset)
+		if e != nil {
+			return nil, err.NewBadRequestError(i18n.T("drive.ftp.invalid_charset", charset))
+		}
+		if name, _ := htmlindex.Name(enc); name != "utf-8" {
+			opts.charset = enc
+		}
+	}
+	maxConnections := utils.ToInt(config["max_connections"], defaultMaxConnections)
+	if maxConnections <= 0 {
+		maxConnections = defaultMaxConnections
+	}
+
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	f := &FtpDrive{pool: newConnPool(opts, maxConnections), cacheTTL: cacheTtl}
+	if cacheTtl <= 0 {
+		f.cache = drive_util.DummyCache()
+	} else {
+		f.cache = driveUtils.CreateCache(f.deserializeEntry, nil)
+	}
+
+	// check
+	if e := f.pool.do(ctx, func(*conn) error { return nil }); e != nil {
+		return nil, toDriveError(e)
+	}
+	return f, nil
+}
+
+func (f *FtpDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (f *FtpDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &ftpEntry{d: f, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := f.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	var entry *ftpEntry
+	e := f.pool.do(ctx, func(c *conn) error {
+		if !c.hasFeature("MLST") {
+			return nil
+		}
+		file, e := c.stat(path)
+		if e != nil {
+			return e
+		}
+		entry = f.newEntry(utils.PathParent(path), *file)
+		return nil
+	})
+	if e != nil {
+		if isNotFound(e) {
+			return nil, err.NewNotFoundError()
+		}
+		return nil, toDriveError(e)
+	}
+	if entry == nil {
+		// find it in the parent, for the servers not supporting MLST
+		entries, e := f.List(ctx, utils.PathParent(path))
+		if e != nil {
+			return nil, e
+		}
+		name := utils.PathBase(path)
+		for _, child := range entries {
+			if utils.PathBase(child.Path()) == name {
+				entry = child.(*ftpEntry)
+				break
+			}
+		}
+		if entry == nil {
+			return nil, err.NewNotFoundError()
+		}
+	}
+	_ = f.cache.PutEntry(entry, f.cacheTTL)
+	return entry, nil
+}
+
+func (f *FtpDrive) Save(ctx types.TaskCtx, path string, _ int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, f, path); e != nil {
+			return nil, e
+		}
+	}
+	e := f.pool.do(ctx, func(c *conn) error {
+		data, e := c.openData("STOR", path, 0)
+		if e != nil {
+			return e
+		}
+		if _, e := io.Copy(data, drive_util.ProgressReader(reader, ctx)); e != nil {
+			_ = data.Conn.Close()
+			return e
+		}
+		return data.Close()
+	})
+	f.evict(path)
+	if e != nil {
+		return nil, toDriveError(e)
+	}
+	return f.Get(ctx, path)
+}
+
+func (f *FtpDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := f.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	e := f.pool.do(ctx, func(c *conn) error {
+		_, _, e := c.pathCmd(2, "MKD", path)
+		return e
+	})
+	f.evict(path)
+	if e != nil {
+		return nil, toDriveError(e)
+	}
+	return f.Get(ctx, path)
+}
+
+func (f *FtpDrive) isSelf(e types.IEntry) bool {
+	if fe, ok := e.(*ftpEntry); ok {
+		return fe.d == f
+	}
+	return false
+}
+
+func (f *FtpDrive) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (f *FtpDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, f.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	if _, e := f.Get(ctx, to); e == nil {
+		if !override {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		if e := f.Delete(ctx, to); e != nil {
+			return nil, e
+		}
+	} else if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	e := f.pool.do(ctx, func(c *conn) error {
+		if _, _, e := c.pathCmd(3, "RNFR", from.Path()); e != nil {
+			return e
+		}
+		_, _, e := c.pathCmd(2, "RNTO", to)
+		return e
+	})
+	f.evict(from.Path())
+	f.evict(to)
+	if e != nil {
+		return nil, toDriveError(e)
+	}
+	return f.Get(ctx, to)
+}
+
+func (f *FtpDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := f.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	var files []ftpFile
+	e := f.pool.do(ctx, func(c *conn) error {
+		var e error
+		files, e = c.list(path)
+		return e
+	})
+	if e != nil {
+		if isNotFound(e) {
+			return nil, err.NewNotFoundError()
+		}
+		return nil, toDriveError(e)
+	}
+	entries := make([]types.IEntry, 0, len(files))
+	for _, file := range files {
+		entries = append(entries, f.newEntry(path, file))
+	}
+	_ = f.cache.PutChildren(path, entries, f.cacheTTL)
+	return entries, nil
+}
+
+// Delete deletes the directories recursively, as RMD removes only the empty ones
+func (f *FtpDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := f.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	if ctx.Canceled() {
+		return task.ErrorCanceled
+	}
+	cmd := "DELE"
+	if entry.Type().IsDir() {
+		children, e := f.List(ctx, path)
+		if e != nil {
+			return e
+		}
+		for _, child := range children {
+			if e := f.Delete(ctx, child.Path()); e != nil {
+				return e
+			}
+		}
+		cmd = "RMD"
+	}
+	e = f.pool.do(ctx, func(c *conn) error {
+		_, _, e := c.pathCmd(2, cmd, path)
+		return e
+	})
+	f.evict(path)
+	if e != nil {
+		return toDriveError(e)
+	}
+	ctx.Progress(1, false)
+	return nil
+}
+
+func (f *FtpDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, f, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (f *FtpDrive) Dispose() error {
+	f.pool.close()
+	return nil
+}
+
+func (f *FtpDrive) evict(path string) {
+	_ = f.cache.Evict(path, true)
+	_ = f.cache.Evict(utils.PathParent(path), false)
+}
+
+func (f *FtpDrive) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	return &ftpEntry{
+		d: f, path: ec.Path, modTime: ec.ModTime,
+		size: ec.Size, isDir: ec.Type.IsDir(),
+	}, nil
+}
+
+func (f *FtpDrive) newEntry(parent string, file ftpFile) *ftpEntry {
+	modTime := int64(-1)
+	if !file.modTime.IsZero() {
+		modTime = utils.Millisecond(file.modTime)
+	}
+	return &ftpEntry{
+		d:       f,
+		path:    path.Join(parent, file.name),
+		size:    file.size,
+		isDir:   file.isDir,
+		modTime: modTime,
+	}
+}
+
+type ftpEntry struct {
+	d       *FtpDrive
+	path    string
+	size    int64
+	isDir   bool
+	modTime int64
+}
+
+func (f *ftpEntry) Path() string {
+	return f.path
+}
+
+func (f *ftpEntry) Type() types.EntryType {
+	if f.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (f *ftpEntry) Size() int64 {
+	if f.isDir {
+		return -1
+	}
+	return f.size
+}
+
+func (f *ftpEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (f *ftpEntry) ModTime() int64 {
+	return f.modTime
+}
+
+func (f *ftpEntry) Drive() types.IDrive {
+	return f.d
+}
+
+func (f *ftpEntry) Name() string {
+	return utils.PathBase(f.path)
+}
+
+func (f *ftpEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return f.GetRangeReader(ctx, 0, -1)
+}
+
+func (f *ftpEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if f.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	c, e := f.d.pool.get(ctx)
+	if e != nil {
+		return nil, e
+	}
+	data, e := c.openData("RETR", f.path, offset)
+	if e != nil {
+		_, negative := e.(ftpError)
+		f.d.pool.put(c, !negative)
+		if isNotFound(e) {
+			return nil, err.NewNotFoundError()
+		}
+		return nil, toDriveError(e)
+	}
+	r := &retrReader{data: data, c: c, pool: f.d.pool, r: data}
+	if length >= 0 {
+		r.r = io.LimitReader(data, length)
+	}
+	return r, nil
+}
+
+func (f *ftpEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+// retrReader reads the content of a file, the connection is returned to the pool when it's closed
+type retrReader struct {
+	data *dataConn
+	c    *conn
+	pool *connPool
+	r    io.Reader
+	eof  bool
+}
+
+func (r *retrReader) Read(p []byte) (int, error) {
+	n, e := r.r.Read(p)
+	if e == io.EOF {
+		r.eof = true
+	}
+	return n, e
+}
+
+// Close closes the data connection, the control connection is dropped if the transfer was aborted,
+// as the servers reply differently to the aborted transfers
+func (r *retrReader) Close() error {
+	if !r.eof {
+		_ = r.data.Conn.Close()
+		r.pool.put(r.c, true)
+		return nil
+	}
+	e := r.data.Close()
+	r.pool.put(r.c, e != nil)
+	return nil
+}
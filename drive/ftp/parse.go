@@ -0,0 +1,155 @@
+package ftp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ftpFile struct {
+	name    string
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+// parseMLSxLine parses the line of MLSD or MLST, like 'type=file;size=12;modify=20201010123456; name'.
+// It returns nil for the current or the parent directory.
+func parseMLSxLine(line string) *ftpFile {
+	i := strings.Index(line, " ")
+	if i < 0 {
+		return nil
+	}
+	f := &ftpFile{name: line[i+1:], size: -1}
+	if j := strings.LastIndex(f.name, "/"); j >= 0 {
+		// MLST returns the full path
+		f.name = f.name[j+1:]
+	}
+	for _, fact := range strings.Split(line[:i], ";") {
+		kv := strings.SplitN(fact, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.ToLower(kv[0]) {
+		case "type":
+			switch strings.ToLower(kv[1]) {
+			case "cdir", "pdir":
+				return nil
+			case "dir":
+				f.isDir = true
+			}
+		case "size":
+			if size, e := strconv.ParseInt(kv[1], 10, 64); e == nil {
+				f.size = size
+			}
+		case "modify":
+			// the fraction of seconds is optional
+			if t, e := time.Parse("20060102150405", strings.SplitN(kv[1], ".", 2)[0]); e == nil {
+				f.modTime = t
+			}
+		}
+	}
+	if f.name == "" {
+		return nil
+	}
+	return f
+}
+
+// parseListLine parses the line of LIST, in the format of 'ls -l' or of IIS.
+// now is used to guess the year, which is omitted by 'ls -l' for recent files.
+// It returns nil for the lines not recognized, and the current or the parent directory.
+func parseListLine(line string, now time.Time) *ftpFile {
+	var f *ftpFile
+	if len(line) > 0 && line[0] >= '0' && line[0] <= '9' {
+		f = parseIISLine(line)
+	} else {
+		f = parseUnixLine(line, now)
+	}
+	if f == nil || f.name == "." || f.name == ".." || f.name == "" {
+		return nil
+	}
+	return f
+}
+
+// parseUnixLine parses 'drwxr-xr-x 1 owner group 4096 Jan 02 15:04 name'
+func parseUnixLine(line string, now time.Time) *ftpFile {
+	fields, name := splitFields(line, 8)
+	if fields == nil || len(fields[0]) < 10 {
+		return nil
+	}
+	f := &ftpFile{name: name, size: -1}
+	switch fields[0][0] {
+	case 'd':
+		f.isDir = true
+	case 'l':
+		// the link is treated as a file, named without the target
+		if i := strings.Index(name, " -> "); i >= 0 {
+			f.name = name[:i]
+		}
+	case '-':
+	default:
+		return nil
+	}
+	if !f.isDir {
+		size, e := strconv.ParseInt(fields[4], 10, 64)
+		if e != nil {
+			return nil
+		}
+		f.size = size
+	}
+	date := fields[5] + " " + fields[6] + " " + fields[7]
+	if strings.Contains(fields[7], ":") {
+		t, e := time.Parse("Jan 2 15:04", date)
+		if e == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			// the year is omitted for the files modified in the past half year
+			if t.After(now.AddDate(0, 0, 1)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			f.modTime = t
+		}
+	} else if t, e := time.Parse("Jan 2 2006", date); e == nil {
+		f.modTime = t
+	}
+	return f
+}
+
+// parseIISLine parses '10-23-20  03:04PM  <DIR>  name' or '10-23-20  03:04PM  1024  name'
+func parseIISLine(line string) *ftpFile {
+	fields, name := splitFields(line, 3)
+	if fields == nil {
+		return nil
+	}
+	f := &ftpFile{name: name, size: -1}
+	if fields[2] == "<DIR>" {
+		f.isDir = true
+	} else {
+		size, e := strconv.ParseInt(fields[2], 10, 64)
+		if e != nil {
+			return nil
+		}
+		f.size = size
+	}
+	if t, e := time.Parse("01-02-06 03:04PM", fields[0]+" "+fields[1]); e == nil {
+		f.modTime = t
+	} else if t, e := time.Parse("01-02-2006 15:04", fields[0]+" "+fields[1]); e == nil {
+		f.modTime = t
+	}
+	return f
+}
+
+// splitFields splits the first n fields separated by spaces, the rest is kept as is, for the names containing spaces
+func splitFields(line string, n int) ([]string, string) {
+	fields := make([]string, 0, n)
+	rest := line
+	for len(fields) < n {
+		rest = strings.TrimLeft(rest, " ")
+		i := strings.Index(rest, " ")
+		if i < 0 {
+			return nil, ""
+		}
+		fields = append(fields, rest[:i])
+		rest = rest[i:]
+	}
+	return fields, strings.TrimLeft(rest, " ")
+}
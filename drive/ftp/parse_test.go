@@ -0,0 +1,63 @@
+package ftp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseListLine(t *testing.T) {
+	now := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		line    string
+		name    string
+		isDir   bool
+		size    int64
+		modTime time.Time
+	}{
+		{"drwxr-xr-x    2 ftp      ftp          4096 Jan 02 15:04 photos", "photos", true, -1,
+			time.Date(2020, 1, 2, 15, 4, 0, 0, time.UTC)},
+		{"-rw-r--r--    1 ftp      ftp          1024 Dec 31 08:00 a file.txt", "a file.txt", false, 1024,
+			time.Date(2019, 12, 31, 8, 0, 0, 0, time.UTC)},
+		{"-rw-r--r--    1 ftp      ftp            12 Jun  5  2018 old.txt", "old.txt", false, 12,
+			time.Date(2018, 6, 5, 0, 0, 0, 0, time.UTC)},
+		{"lrwxrwxrwx    1 ftp      ftp             7 Jan 02  2019 link -> target", "link", false, 7,
+			time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"10-23-20  03:04PM       <DIR>          docs", "docs", true, -1,
+			time.Date(2020, 10, 23, 15, 4, 0, 0, time.UTC)},
+		{"10-23-20  03:04AM                 2048 report.pdf", "report.pdf", false, 2048,
+			time.Date(2020, 10, 23, 3, 4, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		f := parseListLine(c.line, now)
+		if f == nil {
+			t.Errorf("expect '%s' to be parsed", c.line)
+			continue
+		}
+		if f.name != c.name || f.isDir != c.isDir || f.size != c.size || !f.modTime.Equal(c.modTime) {
+			t.Errorf("unexpected %v of '%s'", *f, c.line)
+		}
+	}
+	for _, line := range []string{
+		"total 8",
+		"drwxr-xr-x    2 ftp      ftp          4096 Jan 02 15:04 .",
+		"drwxr-xr-x    2 ftp      ftp          4096 Jan 02 15:04 ..",
+	} {
+		if f := parseListLine(line, now); f != nil {
+			t.Errorf("expect '%s' to be skipped, but %v", line, *f)
+		}
+	}
+}
+
+func TestParseMLSxLine(t *testing.T) {
+	f := parseMLSxLine("type=file;size=12;modify=20201010123456.123;UNIX.mode=0644; a b.txt")
+	if f == nil || f.name != "a b.txt" || f.isDir || f.size != 12 ||
+		!f.modTime.Equal(time.Date(2020, 10, 10, 12, 34, 56, 0, time.UTC)) {
+		t.Errorf("unexpected %v", f)
+	}
+	if f := parseMLSxLine("Type=dir;Modify=20201010123456; /data/photos"); f == nil || f.name != "photos" || !f.isDir {
+		t.Errorf("unexpected %v", f)
+	}
+	if f := parseMLSxLine("type=cdir;modify=20201010123456; ."); f != nil {
+		t.Errorf("expect the current directory to be skipped, but %v", *f)
+	}
+}
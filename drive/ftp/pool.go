@@ -0,0 +1,94 @@
+package ftp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// idleCheckAfter is the idle time after which a pooled connection is checked by NOOP before it's reused
+const idleCheckAfter = 30 * time.Second
+
+// connPool keeps the idle control connections, and limits the number of connections in use,
+// as most servers limit the connections per user
+type connPool struct {
+	opts *dialOptions
+	sem  chan struct{}
+
+	mux    sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+func newConnPool(opts *dialOptions, maxConnections int) *connPool {
+	return &connPool{opts: opts, sem: make(chan struct{}, maxConnections)}
+}
+
+// get returns an idle connection or dials a new one, it waits when there are maxConnections connections in use
+func (p *connPool) get(ctx context.Context) (*conn, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	for {
+		p.mux.Lock()
+		if len(p.idle) == 0 {
+			p.mux.Unlock()
+			break
+		}
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mux.Unlock()
+		if time.Since(c.lastUsed) > idleCheckAfter {
+			if _, _, e := c.cmd(2, "NOOP"); e != nil {
+				c.quit()
+				continue
+			}
+		}
+		return c, nil
+	}
+	c, e := dial(ctx, p.opts)
+	if e != nil {
+		<-p.sem
+		return nil, e
+	}
+	return c, nil
+}
+
+// put returns the connection to the pool, the broken connections are closed
+func (p *connPool) put(c *conn, broken bool) {
+	defer func() { <-p.sem }()
+	p.mux.Lock()
+	if broken || p.closed || len(p.idle) >= cap(p.sem) {
+		p.mux.Unlock()
+		c.quit()
+		return
+	}
+	c.lastUsed = time.Now()
+	p.idle = append(p.idle, c)
+	p.mux.Unlock()
+}
+
+// do runs fn with a connection, the connection is dropped if fn failed for other reasons than a negative reply
+func (p *connPool) do(ctx context.Context, fn func(c *conn) error) error {
+	c, e := p.get(ctx)
+	if e != nil {
+		return e
+	}
+	e = fn(c)
+	_, negative := e.(ftpError)
+	p.put(c, e != nil && !negative)
+	return e
+}
+
+func (p *connPool) close() {
+	p.mux.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mux.Unlock()
+	for _, c := range idle {
+		c.quit()
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"io"
 	url2 "net/url"
 	path2 "path"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -27,6 +28,7 @@ func init() {
 			{Field: "client_id", Label: i18n.T("drive.gdrive.form.client_id.label"), Type: "text", Required: true},
 			{Field: "client_secret", Label: i18n.T("drive.gdrive.form.client_secret.label"), Type: "password", Required: true},
 			{Field: "cache_ttl", Label: i18n.T("drive.gdrive.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.gdrive.form.cache_ttl.description"), DefaultValue: "4h"},
+			{Field: "prewarm_depth", Label: i18n.T("drive.gdrive.form.prewarm_depth.label"), Type: "text", Description: i18n.T("drive.gdrive.form.prewarm_depth.description")},
 		},
 		Factory: drive_util.DriveFactory{Create: NewGDrive, InitConfig: InitConfig, Init: Init},
 	})
@@ -47,28 +49,55 @@ func NewGDrive(ctx context.Context, config types.SM, utils drive_util.DriveUtils
 		cacheTtl = -1
 	}
 
+	params, e := utils.Data.Load("shared_drive_id")
+	if e != nil {
+		return nil, e
+	}
+
 	g := &GDrive{
-		s:        service,
-		cacheTTL: cacheTtl,
-		ts:       resp.TokenSource(nil),
+		s:             service,
+		sharedDriveId: params["shared_drive_id"],
+		cacheTTL:      cacheTtl,
+		ts:            resp.TokenSource(nil),
 	}
 	if cacheTtl <= 0 {
 		g.cache = drive_util.DummyCache()
 	} else {
 		g.cache = utils.CreateCache(g.deserializeEntry, nil)
+		if depth, _ := strconv.Atoi(config["prewarm_depth"]); depth > 0 {
+			g.stopPrewarm = drive_util.Prewarm(g, depth)
+		}
 	}
 	return g, nil
 }
 
 type GDrive struct {
 	s *drive.Service
+	// sharedDriveId is the id of the shared drive to use, or empty for 'My Drive'
+	sharedDriveId string
 
-	cacheTTL time.Duration
-	cache    drive_util.DriveCache
+	cacheTTL    time.Duration
+	cache       drive_util.DriveCache
+	stopPrewarm func()
 
 	ts oauth2.TokenSource
 }
 
+func (g *GDrive) Dispose() error {
+	if g.stopPrewarm != nil {
+		g.stopPrewarm()
+	}
+	return nil
+}
+
+// rootId returns the file id of the root folder
+func (g *GDrive) rootId() string {
+	if g.sharedDriveId != "" {
+		return g.sharedDriveId
+	}
+	return "root"
+}
+
 func (g *GDrive) Meta(context.Context) types.DriveMeta {
 	return types.DriveMeta{CanWrite: true}
 }
@@ -93,7 +122,7 @@ func (g *GDrive) Save(ctx types.TaskCtx, path string, size int64,
 
 	var lastCurrent int64 = 0
 	resp, e := g.s.Files.Create(&drive.File{Name: filename, Parents: []string{parent.fileId()}}).
-		Media(reader).Context(ctx).ProgressUpdater(
+		SupportsAllDrives(true).Media(reader).Context(ctx).ProgressUpdater(
 		func(current, total int64) {
 			ctx.Progress(current-lastCurrent, false)
 			lastCurrent = current
@@ -123,7 +152,7 @@ func (g *GDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error)
 	resp, e := g.s.Files.Create(&drive.File{
 		Name: dirName, Parents: []string{parent.fileId()},
 		MimeType: typeFolder,
-	}).Context(ctx).Do()
+	}).SupportsAllDrives(true).Context(ctx).Do()
 	if e != nil {
 		return nil, e
 	}
@@ -155,7 +184,7 @@ func (g *GDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override
 		return nil, e
 	}
 	resp, e := g.s.Files.Copy(from.(*gdriveEntry).id,
-		&drive.File{Name: filename, Parents: []string{parent.fileId()}}).Do()
+		&drive.File{Name: filename, Parents: []string{parent.fileId()}}).SupportsAllDrives(true).Context(ctx).Do()
 	if e != nil {
 		return nil, e
 	}
@@ -187,7 +216,7 @@ func (g *GDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override
 		return nil, e
 	}
 	resp, e := g.s.Files.Update(from.(*gdriveEntry).id, &drive.File{Name: filename}).Context(ctx).
-		AddParents(parent.fileId()).RemoveParents(fromParent.fileId()).Do()
+		AddParents(parent.fileId()).RemoveParents(fromParent.fileId()).SupportsAllDrives(true).Do()
 	if e != nil {
 		return nil, e
 	}
@@ -214,7 +243,7 @@ func (g *GDrive) getParentTarget(path string, ctx context.Context) (*gdriveEntry
 
 func (g *GDrive) getByPath(path string, ctx context.Context) (*gdriveEntry, error) {
 	if utils.IsRootPath(path) {
-		return &gdriveEntry{id: "root", isDir: true, modTime: -1, d: g}, nil
+		return &gdriveEntry{id: g.rootId(), isDir: true, modTime: -1, d: g}, nil
 	}
 	if cached, _ := g.cache.GetEntry(path); cached != nil {
 		return cached.(*gdriveEntry), nil
@@ -242,7 +271,7 @@ func (g *GDrive) List(ctx context.Context, path string) ([]types.IEntry, error)
 	if cached, _ := g.cache.GetChildren(path); cached != nil {
 		return cached, nil
 	}
-	id := "root"
+	id := g.rootId()
 	if !utils.IsRootPath(path) {
 		ge, e := g.getByPath(path, ctx)
 		if e != nil {
@@ -250,15 +279,23 @@ func (g *GDrive) List(ctx context.Context, path string) ([]types.IEntry, error)
 		}
 		id = ge.fileId()
 	}
-	resp, e := g.s.Files.List().Context(ctx).
+	call := g.s.Files.List().Context(ctx).
 		Q(fmt.Sprintf("'%s' in parents and trashed = false", id)).
-		Fields("files(id,name,mimeType,parents,hasThumbnail,thumbnailLink,modifiedTime,driveId,size," +
+		Fields("nextPageToken,files(id,name,mimeType,parents,hasThumbnail,thumbnailLink,modifiedTime,driveId,size," +
 			"shortcutDetails,capabilities(canDownload,canEdit,canDelete,canCopy))").
-		Do()
+		PageSize(1000).SupportsAllDrives(true).IncludeItemsFromAllDrives(true)
+	if g.sharedDriveId != "" {
+		call = call.Corpora("drive").DriveId(g.sharedDriveId)
+	}
+	files := make([]*drive.File, 0)
+	e := call.Pages(ctx, func(list *drive.FileList) error {
+		files = append(files, list.Files...)
+		return nil
+	})
 	if e != nil {
 		return nil, e
 	}
-	entries := g.processEntries(path, resp.Files)
+	entries := g.processEntries(path, files)
 	_ = g.cache.PutChildren(path, entries, g.cacheTTL)
 	return entries, nil
 }
@@ -268,7 +305,7 @@ func (g *GDrive) Delete(ctx types.TaskCtx, path string) error {
 	if e != nil {
 		return e
 	}
-	e = g.s.Files.Delete(ge.id).Context(ctx).Do()
+	e = g.s.Files.Delete(ge.id).SupportsAllDrives(true).Context(ctx).Do()
 	if e == nil {
 		_ = g.cache.Evict(path, true)
 		_ = g.cache.Evict(utils.PathParent(path), false)
@@ -428,14 +465,14 @@ func (g *gdriveEntry) GetURL(context.Context) (*types.ContentURL, error) {
 	exportMime := exportMimeTypeMap[g.mimeType()]
 	if exportMime != "" {
 		downloadUrl = utils.BuildURL(g.d.s.BasePath+"files/{}/export", fileId) +
-			"?alt=media&mimeType=" + url2.QueryEscape(exportMime)
+			"?alt=media&supportsAllDrives=true&mimeType=" + url2.QueryEscape(exportMime)
 	} else {
 		if strings.HasPrefix(g.mimeType(), typeGoogleAppPrefix) {
 			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_not_downloadable"))
 		}
 	}
 	if downloadUrl == "" {
-		downloadUrl = utils.BuildURL(g.d.s.BasePath+"files/{}", fileId) + "?alt=media"
+		downloadUrl = utils.BuildURL(g.d.s.BasePath+"files/{}", fileId) + "?alt=media&supportsAllDrives=true"
 	}
 
 	t, e := g.d.ts.Token()
@@ -10,6 +10,7 @@ import (
 	"go-drive/common/types"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
 	gOauth "google.golang.org/api/oauth2/v1"
 	"google.golang.org/api/option"
 )
@@ -69,12 +70,45 @@ func InitConfig(ctx context.Context, config drive_util.DriveConfig,
 		initConfig.OAuth.Principal = fmt.Sprintf("%s", user.Name)
 	}
 
+	// get the shared drives
+	if initConfig.Configured {
+		params, e := utils.Data.Load("shared_drive_id")
+		if e != nil {
+			return nil, e
+		}
+		driveService, e := drive.NewService(ctx, option.WithHTTPClient(httpClient))
+		if e != nil {
+			return nil, e
+		}
+		opts := []types.FormItemOption{{Name: i18n.T("drive.gdrive.my_drive"), Value: ""}}
+		e = driveService.Drives.List().PageSize(100).Pages(ctx, func(list *drive.DriveList) error {
+			for _, d := range list.Drives {
+				opts = append(opts, types.FormItemOption{Name: d.Name, Value: d.Id})
+			}
+			return nil
+		})
+		initConfig.Configured = e == nil
+		if e == nil {
+			initConfig.Form = []types.FormItem{
+				{Label: i18n.T("drive.gdrive.shared_drive_select"), Type: "select", Field: "shared_drive_id", Options: opts},
+			}
+			initConfig.Value = types.SM{"shared_drive_id": params["shared_drive_id"]}
+		}
+	}
+
 	return initConfig, nil
 }
 
 func Init(ctx context.Context, data types.SM, config drive_util.DriveConfig, utils drive_util.DriveUtils) error {
 	_, e := drive_util.OAuthInit(ctx, *oauthReq(utils.Config), data, config, utils.Data)
-	return e
+	if e != nil {
+		return e
+	}
+	// empty for 'My Drive'
+	if sharedDriveId, ok := data["shared_drive_id"]; ok {
+		return utils.Data.Save(types.SM{"shared_drive_id": sharedDriveId})
+	}
+	return nil
 }
 
 func (g *GDrive) deserializeEntry(dat string) (types.IEntry, error) {
@@ -0,0 +1,265 @@
+package git
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "git",
+		DisplayName: i18n.T("drive.git.name"),
+		README:      i18n.T("drive.git.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "repository", Label: i18n.T("drive.git.form.repository.label"), Type: "text", Required: true, Description: i18n.T("drive.git.form.repository.description")},
+			{Field: "ref", Label: i18n.T("drive.git.form.ref.label"), Type: "text", Description: i18n.T("drive.git.form.ref.description")},
+			{Field: "username", Label: i18n.T("drive.git.form.username.label"), Type: "text", Description: i18n.T("drive.git.form.username.description")},
+			{Field: "password", Label: i18n.T("drive.git.form.password.label"), Type: "password"},
+			{Field: "refresh_interval", Label: i18n.T("drive.git.form.refresh_interval.label"), Type: "text", Description: i18n.T("drive.git.form.refresh_interval.description"), DefaultValue: defaultRefreshInterval.String()},
+		},
+		Factory: drive_util.DriveFactory{Create: NewGitDrive},
+	})
+}
+
+const defaultRefreshInterval = 10 * time.Minute
+
+// remotePattern matches the URLs of the remote repositories, and the scp-like syntax of ssh
+var remotePattern = regexp.MustCompile(`^(?:(?:https?|ssh|git)://|[\w.-]+@[\w.-]+:)`)
+
+// GitDrive serves a branch or a tag of a repository as a read-only file tree.
+// The repository is mirrored to the data dir, and fetched periodically.
+type GitDrive struct {
+	repo *repo
+	ref  string
+
+	mux      sync.RWMutex
+	snapshot *snapshot
+
+	stopRefresh func()
+}
+
+func NewGitDrive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	if driveUtils.Config.GitPath == "" {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.git.disabled"))
+	}
+	source := strings.TrimSpace(config["repository"])
+	if !remotePattern.MatchString(source) {
+		// the local repositories are limited in the local fs dir, as the fs drives
+		localRoot, e := driveUtils.Config.GetLocalFsDir()
+		if e != nil {
+			return nil, e
+		}
+		if localRoot != "" {
+			source = filepath.Join(localRoot, filepath.Clean(string(filepath.Separator)+source))
+		}
+	}
+	refreshInterval := defaultRefreshInterval
+	if v := strings.TrimSpace(config["refresh_interval"]); v != "" {
+		d, e := time.ParseDuration(v)
+		if e != nil {
+			return nil, err.NewBadRequestError(i18n.T("drive.git.invalid_refresh_interval", v))
+		}
+		refreshInterval = d
+	}
+
+	dir, e := driveUtils.Config.GetDir("git", true)
+	if e != nil {
+		return nil, e
+	}
+	sum := sha256.Sum256([]byte(source))
+	r := newRepo(driveUtils.Config.GitPath, filepath.Join(dir, hex.EncodeToString(sum[:])+".git"),
+		config["username"], config["password"])
+	if e := r.clone(ctx, source); e != nil {
+		return nil, e
+	}
+	d := &GitDrive{repo: r, ref: strings.TrimSpace(config["ref"])}
+	if e := d.load(ctx); e != nil {
+		return nil, e
+	}
+	if refreshInterval > 0 {
+		d.stopRefresh = utils.TimeTick(d.refresh, refreshInterval)
+	}
+	return d, nil
+}
+
+// load loads the tree of the ref if its commit is changed
+func (d *GitDrive) load(ctx context.Context) error {
+	c, e := d.repo.resolve(ctx, d.ref)
+	if e != nil {
+		return e
+	}
+	d.mux.RLock()
+	current := d.snapshot
+	d.mux.RUnlock()
+	if current != nil && current.commit.Hash == c.Hash {
+		return nil
+	}
+	s, e := d.repo.loadTree(ctx, c)
+	if e != nil {
+		return e
+	}
+	d.mux.Lock()
+	d.snapshot = s
+	d.mux.Unlock()
+	return nil
+}
+
+func (d *GitDrive) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	e := d.repo.fetch(ctx)
+	if e == nil {
+		e = d.load(ctx)
+	}
+	if e != nil {
+		log.Printf("failed to refresh git repository: %s", e.Error())
+	}
+}
+
+func (d *GitDrive) current() *snapshot {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	return d.snapshot
+}
+
+func (d *GitDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: false}
+}
+
+func (d *GitDrive) newEntry(s *snapshot, path string, n *treeNode) *gitEntry {
+	return &gitEntry{d: d, path: path, node: n, commit: s.commit}
+}
+
+func (d *GitDrive) Get(_ context.Context, path string) (types.IEntry, error) {
+	s := d.current()
+	if utils.IsRootPath(path) {
+		return d.newEntry(s, path, &treeNode{isDir: true, size: -1}), nil
+	}
+	n, ok := s.nodes[path]
+	if !ok {
+		return nil, err.NewNotFoundError()
+	}
+	return d.newEntry(s, path, n), nil
+}
+
+func (d *GitDrive) Save(types.TaskCtx, string, int64, bool, io.Reader) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *GitDrive) MakeDir(context.Context, string) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *GitDrive) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *GitDrive) Move(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *GitDrive) List(_ context.Context, path string) ([]types.IEntry, error) {
+	s := d.current()
+	if !utils.IsRootPath(path) {
+		n, ok := s.nodes[path]
+		if !ok {
+			return nil, err.NewNotFoundError()
+		}
+		if !n.isDir {
+			return nil, err.NewNotAllowedError()
+		}
+	}
+	children := s.children[path]
+	entries := make([]types.IEntry, 0, len(children))
+	for _, p := range children {
+		entries = append(entries, d.newEntry(s, p, s.nodes[p]))
+	}
+	return entries, nil
+}
+
+func (d *GitDrive) Delete(types.TaskCtx, string) error {
+	return err.NewNotAllowedError()
+}
+
+func (d *GitDrive) Upload(context.Context, string, int64, bool, types.SM) (*types.DriveUploadConfig, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *GitDrive) Dispose() error {
+	if d.stopRefresh != nil {
+		d.stopRefresh()
+	}
+	return nil
+}
+
+type gitEntry struct {
+	d      *GitDrive
+	path   string
+	node   *treeNode
+	commit *commit
+}
+
+func (e *gitEntry) Path() string {
+	return e.path
+}
+
+func (e *gitEntry) Type() types.EntryType {
+	if e.node.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *gitEntry) Size() int64 {
+	if e.node.isDir {
+		return -1
+	}
+	return e.node.size
+}
+
+// Meta returns the commit of the ref, and the object id of the entry
+func (e *gitEntry) Meta() types.EntryMeta {
+	props := types.M{"commit": e.commit.Hash, "author": e.commit.Author}
+	if e.node.object != "" {
+		props["object"] = e.node.object
+	}
+	return types.EntryMeta{CanRead: true, CanWrite: false, Props: props}
+}
+
+// ModTime returns the time of the commit of the ref
+func (e *gitEntry) ModTime() int64 {
+	return e.commit.Time
+}
+
+func (e *gitEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *gitEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *gitEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	if e.node.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	return e.d.repo.openBlob(ctx, e.node.object)
+}
+
+func (e *gitEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
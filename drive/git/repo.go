@@ -0,0 +1,221 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// repo is a bare mirror of the repository, the commands are run by the git executable
+type repo struct {
+	git string
+	dir string
+	// env is the extra environment of the commands, which carries the credentials
+	env []string
+}
+
+func newRepo(git, dir, username, password string) *repo {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if username != "" || password != "" {
+		// the credentials are passed by the environment, so they don't show in the command lines
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)),
+		)
+	}
+	return &repo{git: git, dir: dir, env: env}
+}
+
+func (r *repo) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, r.git, append([]string{"-c", "protocol.ext.allow=never"}, args...)...)
+	cmd.Env = append(os.Environ(), r.env...)
+	return cmd
+}
+
+// run runs the command on the repository, and returns the stdout
+func (r *repo) run(ctx context.Context, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := r.command(ctx, append([]string{"--git-dir", r.dir}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if e := cmd.Run(); e != nil {
+		return nil, commandError(e, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+func commandError(e error, stderr string) error {
+	var ee *exec.ExitError
+	if !errors.As(e, &ee) {
+		return e
+	}
+	msg := strings.TrimSpace(stderr)
+	if msg == "" {
+		msg = e.Error()
+	}
+	return err.NewRemoteApiError(500, i18n.T("drive.git.command_failed", msg))
+}
+
+// clone clones the mirror of the source, or fetches it if it's cloned
+func (r *repo) clone(ctx context.Context, source string) error {
+	if _, e := os.Stat(r.dir); e == nil {
+		return r.fetch(ctx)
+	}
+	var stderr bytes.Buffer
+	cmd := r.command(ctx, "clone", "--mirror", "--quiet", "--", source, r.dir)
+	cmd.Stderr = &stderr
+	if e := cmd.Run(); e != nil {
+		_ = os.RemoveAll(r.dir)
+		return commandError(e, stderr.String())
+	}
+	return nil
+}
+
+func (r *repo) fetch(ctx context.Context) error {
+	_, e := r.run(ctx, "fetch", "--prune", "--quiet", "origin")
+	return e
+}
+
+type commit struct {
+	Hash   string
+	Author string
+	// Time is the commit time in milliseconds
+	Time int64
+}
+
+// resolve returns the commit of the branch, tag or commit hash
+func (r *repo) resolve(ctx context.Context, ref string) (*commit, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if strings.HasPrefix(ref, "-") {
+		return nil, err.NewBadRequestError(i18n.T("drive.git.invalid_ref", ref))
+	}
+	out, e := r.run(ctx, "log", "-1", "--format=%H%x00%an <%ae>%x00%ct", ref+"^{commit}", "--")
+	if e != nil {
+		if _, ok := e.(err.RemoteApiError); ok {
+			return nil, err.NewNotFoundMessageError(i18n.T("drive.git.invalid_ref", ref))
+		}
+		return nil, e
+	}
+	return parseCommit(out)
+}
+
+func parseCommit(out []byte) (*commit, error) {
+	fields := strings.Split(strings.TrimSpace(string(out)), "\x00")
+	if len(fields) != 3 {
+		return nil, err.NewRemoteApiError(500, i18n.T("drive.git.command_failed", "unexpected commit: "+string(out)))
+	}
+	t, e := strconv.ParseInt(fields[2], 10, 64)
+	if e != nil {
+		return nil, e
+	}
+	return &commit{Hash: fields[0], Author: fields[1], Time: t * 1000}, nil
+}
+
+// treeNode is a file or a directory in the tree of the commit
+type treeNode struct {
+	isDir  bool
+	size   int64
+	object string
+}
+
+// snapshot is the file tree of a commit
+type snapshot struct {
+	commit   *commit
+	nodes    map[string]*treeNode
+	children map[string][]string
+}
+
+// loadTree loads the whole tree of the commit
+func (r *repo) loadTree(ctx context.Context, c *commit) (*snapshot, error) {
+	out, e := r.run(ctx, "ls-tree", "-r", "-t", "-l", "-z", c.Hash)
+	if e != nil {
+		return nil, e
+	}
+	s, e := parseTree(out)
+	if e != nil {
+		return nil, e
+	}
+	s.commit = c
+	return s, nil
+}
+
+// parseTree parses the output of 'ls-tree -r -t -l -z', the lines are '<mode> <type> <object> <size>\t<path>'
+func parseTree(out []byte) (*snapshot, error) {
+	s := &snapshot{nodes: make(map[string]*treeNode), children: make(map[string][]string)}
+	for _, line := range strings.Split(string(out), "\x00") {
+		if line == "" {
+			continue
+		}
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return nil, err.NewRemoteApiError(500, i18n.T("drive.git.command_failed", "unexpected tree: "+line))
+		}
+		meta, path := strings.Fields(line[:tab]), line[tab+1:]
+		if len(meta) != 4 {
+			return nil, err.NewRemoteApiError(500, i18n.T("drive.git.command_failed", "unexpected tree: "+line))
+		}
+		n := &treeNode{object: meta[2], size: -1}
+		switch meta[1] {
+		case "blob":
+			size, e := strconv.ParseInt(meta[3], 10, 64)
+			if e != nil {
+				return nil, e
+			}
+			n.size = size
+		default:
+			// the submodules are shown as empty directories
+			n.isDir = true
+		}
+		s.nodes[path] = n
+		parent := ""
+		if i := strings.LastIndexByte(path, '/'); i >= 0 {
+			parent = path[:i]
+		}
+		s.children[parent] = append(s.children[parent], path)
+	}
+	for _, c := range s.children {
+		sort.Strings(c)
+	}
+	return s, nil
+}
+
+// blobReader streams the blob from 'cat-file', the process is killed if it's closed before the end
+type blobReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+}
+
+func (r *repo) openBlob(ctx context.Context, object string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := r.command(ctx, "--git-dir", r.dir, "cat-file", "blob", object)
+	stdout, e := cmd.StdoutPipe()
+	if e != nil {
+		cancel()
+		return nil, e
+	}
+	if e := cmd.Start(); e != nil {
+		cancel()
+		return nil, e
+	}
+	return &blobReader{ReadCloser: stdout, cmd: cmd, cancel: cancel}, nil
+}
+
+func (b *blobReader) Close() error {
+	b.cancel()
+	_ = b.ReadCloser.Close()
+	_ = b.cmd.Wait()
+	return nil
+}
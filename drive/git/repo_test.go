@@ -0,0 +1,109 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitParseTree(t *testing.T) {
+	s, e := parseTree([]byte("040000 tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904       -\tdocs\x00" +
+		"100644 blob 8ab686eafeb1f44702738c8b0f24f2567c36da6d      13\tdocs/a b.md\x00" +
+		"160000 commit 2b8f9e1b8e0f5d2a9a4d6e6b3f6a5f1a8b7c9d0e       -\tvendor/lib\x00" +
+		"100644 blob e69de29bb2d1d6434b8b29ae775ad8c2e48c5391       0\tREADME\x00"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if n := s.nodes["docs/a b.md"]; n == nil || n.isDir || n.size != 13 || n.object != "8ab686eafeb1f44702738c8b0f24f2567c36da6d" {
+		t.Errorf("unexpected file: %+v", n)
+	}
+	if n := s.nodes["vendor/lib"]; n == nil || !n.isDir {
+		t.Errorf("expect the submodule as a directory: %+v", n)
+	}
+	if c := s.children[""]; len(c) != 2 || c[0] != "README" || c[1] != "docs" {
+		t.Errorf("unexpected children: %v", c)
+	}
+	if _, e := parseTree([]byte("100644 blob x\tbad\x00")); e == nil {
+		t.Error("expect error of the bad tree")
+	}
+}
+
+func TestGitParseCommit(t *testing.T) {
+	c, e := parseCommit([]byte("abc\x00Alice <alice@example.com>\x001600000000\n"))
+	if e != nil || c.Hash != "abc" || c.Author != "Alice <alice@example.com>" || c.Time != 1600000000000 {
+		t.Errorf("unexpected commit: %+v, %v", c, e)
+	}
+}
+
+func TestGitRepo(t *testing.T) {
+	git, e := exec.LookPath("git")
+	if e != nil {
+		t.Skip("git is not installed")
+	}
+	dir, e := ioutil.TempDir("", "git-drive")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	source := filepath.Join(dir, "source")
+	for _, args := range [][]string{
+		{"init", "--quiet", source},
+		{"-C", source, "checkout", "--quiet", "-b", "main"},
+	} {
+		if out, e := exec.Command(git, args...).CombinedOutput(); e != nil {
+			t.Fatal(string(out))
+		}
+	}
+	if e := os.MkdirAll(filepath.Join(source, "dir"), 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := ioutil.WriteFile(filepath.Join(source, "dir", "hello.txt"), []byte("hello git"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	for _, args := range [][]string{
+		{"-C", source, "add", "."},
+		{"-C", source, "-c", "user.name=Alice", "-c", "user.email=alice@example.com", "commit", "--quiet", "-m", "init"},
+		{"-C", source, "tag", "v1"},
+	} {
+		if out, e := exec.Command(git, args...).CombinedOutput(); e != nil {
+			t.Fatal(string(out))
+		}
+	}
+
+	ctx := context.Background()
+	r := newRepo(git, filepath.Join(dir, "mirror.git"), "", "")
+	if e := r.clone(ctx, source); e != nil {
+		t.Fatal(e)
+	}
+	c, e := r.resolve(ctx, "v1")
+	if e != nil || c.Author != "Alice <alice@example.com>" {
+		t.Fatalf("unexpected commit: %+v, %v", c, e)
+	}
+	if _, e := r.resolve(ctx, "not-exists"); e == nil {
+		t.Error("expect error of the unknown ref")
+	}
+	s, e := r.loadTree(ctx, c)
+	if e != nil {
+		t.Fatal(e)
+	}
+	n := s.nodes["dir/hello.txt"]
+	if n == nil || n.size != 9 || !s.nodes["dir"].isDir {
+		t.Fatalf("unexpected tree: %v", s.nodes)
+	}
+	reader, e := r.openBlob(ctx, n.object)
+	if e != nil {
+		t.Fatal(e)
+	}
+	b, e := ioutil.ReadAll(reader)
+	_ = reader.Close()
+	if e != nil || string(b) != "hello git" {
+		t.Errorf("unexpected content: %s, %v", b, e)
+	}
+	// fetch the cloned mirror
+	if e := r.clone(ctx, source); e != nil {
+		t.Fatal(e)
+	}
+}
@@ -0,0 +1,111 @@
+package gphotos
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	apiURL = "https://photoslibrary.googleapis.com/v1"
+
+	// albumsPageSize and itemsPageSize are the max sizes of the pages of listing
+	albumsPageSize = 50
+	itemsPageSize  = 100
+
+	// baseURLTTL is how long a base URL is used, the base URLs expire after 60 minutes
+	baseURLTTL = 50 * time.Minute
+)
+
+type album struct {
+	Id              string `json:"id"`
+	Title           string `json:"title"`
+	MediaItemsCount string `json:"mediaItemsCount"`
+	IsWriteable     bool   `json:"isWriteable"`
+}
+
+type albumsResult struct {
+	Albums        []album `json:"albums"`
+	NextPageToken string  `json:"nextPageToken"`
+}
+
+type mediaItem struct {
+	Id            string `json:"id"`
+	Filename      string `json:"filename"`
+	MimeType      string `json:"mimeType"`
+	BaseURL       string `json:"baseUrl"`
+	MediaMetadata struct {
+		CreationTime string      `json:"creationTime"`
+		Width        string      `json:"width"`
+		Height       string      `json:"height"`
+		Video        interface{} `json:"video"`
+	} `json:"mediaMetadata"`
+}
+
+func (m mediaItem) isVideo() bool {
+	return m.MediaMetadata.Video != nil || strings.HasPrefix(m.MimeType, "video/")
+}
+
+type searchResult struct {
+	MediaItems    []mediaItem `json:"mediaItems"`
+	NextPageToken string      `json:"nextPageToken"`
+}
+
+type date struct {
+	Year  int `json:"year"`
+	Month int `json:"month"`
+	Day   int `json:"day"`
+}
+
+type dateRange struct {
+	StartDate date `json:"startDate"`
+	EndDate   date `json:"endDate"`
+}
+
+// searchRequest searches the media items of an album, or in the date ranges
+type searchRequest struct {
+	AlbumId   string   `json:"albumId,omitempty"`
+	Filters   *filters `json:"filters,omitempty"`
+	PageSize  int      `json:"pageSize"`
+	PageToken string   `json:"pageToken,omitempty"`
+}
+
+type filters struct {
+	DateFilter struct {
+		Ranges []dateRange `json:"ranges"`
+	} `json:"dateFilter"`
+}
+
+type newMediaItem struct {
+	SimpleMediaItem struct {
+		FileName    string `json:"fileName"`
+		UploadToken string `json:"uploadToken"`
+	} `json:"simpleMediaItem"`
+}
+
+type batchCreateRequest struct {
+	AlbumId       string         `json:"albumId,omitempty"`
+	NewMediaItems []newMediaItem `json:"newMediaItems"`
+}
+
+type batchCreateResult struct {
+	NewMediaItemResults []struct {
+		Status struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+		MediaItem mediaItem `json:"mediaItem"`
+	} `json:"newMediaItemResults"`
+}
+
+type apiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+func (a apiError) String() string {
+	return fmt.Sprintf("%d %s: %s", a.Error.Code, a.Error.Status, a.Error.Message)
+}
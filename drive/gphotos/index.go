@@ -0,0 +1,525 @@
+package gphotos
+
+import (
+	"context"
+	"fmt"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"mime"
+	url2 "net/url"
+	path2 "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "gphotos",
+		DisplayName: i18n.T("drive.gphotos.name"),
+		README:      i18n.T("drive.gphotos.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "client_id", Label: i18n.T("drive.gphotos.form.client_id.label"), Type: "text", Required: true},
+			{Field: "client_secret", Label: i18n.T("drive.gphotos.form.client_secret.label"), Type: "password", Required: true},
+			{Field: "start_year", Label: i18n.T("drive.gphotos.form.start_year.label"), Type: "text", Description: i18n.T("drive.gphotos.form.start_year.description"), DefaultValue: strconv.Itoa(defaultStartYear)},
+			{Field: "cache_ttl", Label: i18n.T("drive.gphotos.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.gphotos.form.cache_ttl.description"), DefaultValue: "1h"},
+		},
+		Factory: drive_util.DriveFactory{Create: NewGPhotos, InitConfig: InitConfig, Init: Init},
+	})
+}
+
+// the top-level directories
+const (
+	albumsDir = "albums"
+	datesDir  = "dates"
+)
+
+// the kinds of the entries
+const (
+	kindRoot   = "root"
+	kindAlbums = "albums"
+	kindAlbum  = "album"
+	kindDates  = "dates"
+	kindYear   = "year"
+	kindMonth  = "month"
+	kindItem   = "item"
+)
+
+const defaultStartYear = 2000
+
+// GPhotos is the drive of Google Photos, the albums are in the directory 'albums',
+// and all the media items are in the directories of the years and the months in the directory 'dates'.
+// The media items can't be changed or deleted by the API, new ones can be uploaded to the albums created by go-drive.
+type GPhotos struct {
+	c         *req.Client
+	startYear int
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+}
+
+func NewGPhotos(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	resp, e := drive_util.OAuthGet(*oauthReq(driveUtils.Config), config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	startYear := defaultStartYear
+	if v := strings.TrimSpace(config["start_year"]); v != "" {
+		startYear, e = strconv.Atoi(v)
+		if e != nil || startYear < 1900 || startYear > time.Now().Year() {
+			return nil, err.NewBadRequestError(i18n.T("drive.gphotos.invalid_start_year", v))
+		}
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	g := &GPhotos{startYear: startYear, cacheTTL: cacheTtl}
+	if cacheTtl <= 0 {
+		g.cache = drive_util.DummyCache()
+	} else {
+		g.cache = driveUtils.CreateCache(g.deserializeEntry, nil)
+	}
+	if g.c, e = req.NewClient(apiURL, nil, ifApiCallError, resp.Client(nil)); e != nil {
+		return nil, e
+	}
+	return g, nil
+}
+
+func (g *GPhotos) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (g *GPhotos) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if dir := g.virtualDir(path); dir != nil {
+		return dir, nil
+	}
+	if cached, _ := g.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	// the albums and the media items are found by listing the parents
+	depth := utils.PathDepth(path)
+	inAlbums := strings.HasPrefix(path, albumsDir+"/") && depth <= 3
+	inDates := strings.HasPrefix(path, datesDir+"/") && depth == 4
+	if !inAlbums && !inDates {
+		return nil, err.NewNotFoundError()
+	}
+	entries, e := g.List(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	for _, entry := range entries {
+		if entry.Path() == path {
+			_ = g.cache.PutEntry(entry, g.cacheTTL)
+			return entry, nil
+		}
+	}
+	return nil, err.NewNotFoundError()
+}
+
+// virtualDir returns the directory of path if it's not an album or a media item
+func (g *GPhotos) virtualDir(path string) *gphotosEntry {
+	switch {
+	case utils.IsRootPath(path):
+		return g.newDir(path, kindRoot)
+	case path == albumsDir:
+		return g.newDir(path, kindAlbums)
+	case path == datesDir:
+		return g.newDir(path, kindDates)
+	case strings.HasPrefix(path, datesDir+"/"):
+		segments := strings.Split(path, "/")
+		if len(segments) > 3 {
+			return nil
+		}
+		year, e := strconv.Atoi(segments[1])
+		if e != nil || year < g.startYear || year > time.Now().Year() || segments[1] != strconv.Itoa(year) {
+			return nil
+		}
+		if len(segments) == 2 {
+			return g.newDir(path, kindYear)
+		}
+		month, e := strconv.Atoi(segments[2])
+		if e != nil || month < 1 || month > 12 || segments[2] != fmt.Sprintf("%02d", month) {
+			return nil
+		}
+		return g.newDir(path, kindMonth)
+	}
+	return nil
+}
+
+// Save uploads the media item to the album, only the albums created by go-drive can be uploaded to
+func (g *GPhotos) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	parent, e := g.writableAlbum(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if _, e := drive_util.RequireFileNotExists(ctx, g, path); e != nil {
+		if override {
+			// the media items can't be replaced, a new one would be added
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.gphotos.cannot_replace"))
+		}
+		return nil, e
+	}
+	name := utils.PathBase(path)
+	contentType := mime.TypeByExtension(path2.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	ctx.Total(size, true)
+	resp, e := g.c.Post(ctx, "/uploads", types.SM{
+		"X-Goog-Upload-Content-Type": contentType,
+		"X-Goog-Upload-Protocol":     "raw",
+	}, req.NewReaderBody(drive_util.ProgressReader(reader, ctx), size))
+	if e != nil {
+		return nil, e
+	}
+	token, e := ioutil.ReadAll(resp.Response().Body)
+	_ = resp.Dispose()
+	if e != nil {
+		return nil, e
+	}
+
+	item := newMediaItem{}
+	item.SimpleMediaItem.FileName = name
+	item.SimpleMediaItem.UploadToken = string(token)
+	resp, e = g.c.Post(ctx, "/mediaItems:batchCreate", nil, req.NewJsonBody(batchCreateRequest{
+		AlbumId: parent.id, NewMediaItems: []newMediaItem{item},
+	}))
+	_ = g.cache.Evict(parent.path, true)
+	if e != nil {
+		return nil, e
+	}
+	result := batchCreateResult{}
+	if e := resp.Json(&result); e != nil {
+		return nil, e
+	}
+	if len(result.NewMediaItemResults) == 0 {
+		return nil, err.NewRemoteApiError(500, i18n.T("drive.gphotos.remote_error", "no media item created"))
+	}
+	created := result.NewMediaItemResults[0]
+	// the status code is the code of google.rpc.Code, 0 is OK
+	if created.Status.Code != 0 {
+		return nil, err.NewRemoteApiError(500, i18n.T("drive.gphotos.remote_error", created.Status.Message))
+	}
+	return g.newItem(parent.path, name, created.MediaItem), nil
+}
+
+// writableAlbum returns the album of the parent of path, which must be created by go-drive
+func (g *GPhotos) writableAlbum(ctx context.Context, path string) (*gphotosEntry, error) {
+	if utils.PathDepth(path) != 3 || !strings.HasPrefix(path, albumsDir+"/") {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.gphotos.save_to_album"))
+	}
+	parent, e := g.Get(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	album := parent.(*gphotosEntry)
+	if album.kind != kindAlbum {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.gphotos.save_to_album"))
+	}
+	if !album.writable {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.gphotos.album_not_writable"))
+	}
+	return album, nil
+}
+
+// MakeDir creates an album in the directory 'albums'
+func (g *GPhotos) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.PathDepth(path) != 2 || !strings.HasPrefix(path, albumsDir+"/") {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.gphotos.make_album"))
+	}
+	if _, e := drive_util.RequireFileNotExists(ctx, g, path); e != nil {
+		return nil, e
+	}
+	resp, e := g.c.Post(ctx, "/albums", nil, req.NewJsonBody(types.M{"album": types.M{"title": utils.PathBase(path)}}))
+	_ = g.cache.Evict(albumsDir, false)
+	if e != nil {
+		return nil, e
+	}
+	a := album{}
+	if e := resp.Json(&a); e != nil {
+		return nil, e
+	}
+	return g.newAlbum(path, a), nil
+}
+
+func (g *GPhotos) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (g *GPhotos) Move(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+// Delete is not allowed, as the API can't delete the media items or the albums
+func (g *GPhotos) Delete(types.TaskCtx, string) error {
+	return err.NewNotAllowedError()
+}
+
+func (g *GPhotos) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := g.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	dir, e := g.Get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	entry := dir.(*gphotosEntry)
+	var entries []types.IEntry
+	switch entry.kind {
+	case kindRoot:
+		entries = []types.IEntry{g.newDir(albumsDir, kindAlbums), g.newDir(datesDir, kindDates)}
+	case kindAlbums:
+		entries, e = g.listAlbums(ctx)
+	case kindAlbum:
+		entries, e = g.listItems(ctx, path, searchRequest{AlbumId: entry.id})
+	case kindDates:
+		for year := time.Now().Year(); year >= g.startYear; year-- {
+			entries = append(entries, g.newDir(path2.Join(path, strconv.Itoa(year)), kindYear))
+		}
+	case kindYear:
+		now := time.Now()
+		year, _ := strconv.Atoi(utils.PathBase(path))
+		for month := 1; month <= 12 && !(year == now.Year() && month > int(now.Month())); month++ {
+			entries = append(entries, g.newDir(path2.Join(path, fmt.Sprintf("%02d", month)), kindMonth))
+		}
+	case kindMonth:
+		year, _ := strconv.Atoi(utils.PathBase(utils.PathParent(path)))
+		month, _ := strconv.Atoi(utils.PathBase(path))
+		last := time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		f := &filters{}
+		f.DateFilter.Ranges = []dateRange{{
+			StartDate: date{Year: year, Month: month, Day: 1},
+			EndDate:   date{Year: year, Month: month, Day: last},
+		}}
+		entries, e = g.listItems(ctx, path, searchRequest{Filters: f})
+	default:
+		return nil, err.NewNotAllowedError()
+	}
+	if e != nil {
+		return nil, e
+	}
+	_ = g.cache.PutChildren(path, entries, g.cacheTTL)
+	return entries, nil
+}
+
+func (g *GPhotos) listAlbums(ctx context.Context) ([]types.IEntry, error) {
+	entries := make([]types.IEntry, 0)
+	names := make(map[string]bool)
+	pageToken := ""
+	for {
+		q := url2.Values{}
+		q.Set("pageSize", strconv.Itoa(albumsPageSize))
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		resp, e := g.c.Get(ctx, "/albums?"+q.Encode(), nil)
+		if e != nil {
+			return nil, e
+		}
+		r := albumsResult{}
+		if e := resp.Json(&r); e != nil {
+			return nil, e
+		}
+		for _, a := range r.Albums {
+			name := uniqueName(names, strings.ReplaceAll(a.Title, "/", "_"), a.Id)
+			entries = append(entries, g.newAlbum(path2.Join(albumsDir, name), a))
+		}
+		if r.NextPageToken == "" {
+			return entries, nil
+		}
+		pageToken = r.NextPageToken
+	}
+}
+
+func (g *GPhotos) listItems(ctx context.Context, path string, search searchRequest) ([]types.IEntry, error) {
+	entries := make([]types.IEntry, 0)
+	names := make(map[string]bool)
+	search.PageSize = itemsPageSize
+	for {
+		resp, e := g.c.Post(ctx, "/mediaItems:search", nil, req.NewJsonBody(search))
+		if e != nil {
+			return nil, e
+		}
+		r := searchResult{}
+		if e := resp.Json(&r); e != nil {
+			return nil, e
+		}
+		for _, m := range r.MediaItems {
+			entries = append(entries, g.newItem(path, uniqueName(names, m.Filename, m.Id), m))
+		}
+		if r.NextPageToken == "" {
+			return entries, nil
+		}
+		search.PageToken = r.NextPageToken
+	}
+}
+
+// uniqueName returns the name not in names, the id is appended to the name if it's used
+func uniqueName(names map[string]bool, name, id string) string {
+	if name == "" {
+		name = id
+	}
+	if names[name] {
+		if len(id) > 8 {
+			id = id[len(id)-8:]
+		}
+		ext := path2.Ext(name)
+		name = fmt.Sprintf("%s (%s)%s", strings.TrimSuffix(name, ext), id, ext)
+	}
+	names[name] = true
+	return name
+}
+
+func (g *GPhotos) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if _, e := g.writableAlbum(ctx, path); e != nil {
+		return nil, e
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, g, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+// baseURL returns the base URL of the media item, which is refreshed if it's expired
+func (g *GPhotos) baseURL(ctx context.Context, entry *gphotosEntry) (string, error) {
+	if entry.baseURL != "" && entry.urlExpiresAt > time.Now().Unix() {
+		return entry.baseURL, nil
+	}
+	resp, e := g.c.Get(ctx, "/mediaItems/"+url2.PathEscape(entry.id), nil)
+	if e != nil {
+		return "", e
+	}
+	m := mediaItem{}
+	if e := resp.Json(&m); e != nil {
+		return "", e
+	}
+	entry.baseURL = m.BaseURL
+	entry.urlExpiresAt = time.Now().Add(baseURLTTL).Unix()
+	_ = g.cache.PutEntry(entry, g.cacheTTL)
+	return entry.baseURL, nil
+}
+
+func (g *GPhotos) newDir(path, kind string) *gphotosEntry {
+	return &gphotosEntry{d: g, path: path, kind: kind, modTime: -1}
+}
+
+func (g *GPhotos) newAlbum(path string, a album) *gphotosEntry {
+	return &gphotosEntry{d: g, path: path, kind: kindAlbum, id: a.Id, writable: a.IsWriteable, modTime: -1}
+}
+
+func (g *GPhotos) newItem(parent, name string, m mediaItem) *gphotosEntry {
+	modTime := int64(-1)
+	if t, e := time.Parse(time.RFC3339Nano, m.MediaMetadata.CreationTime); e == nil {
+		modTime = utils.Millisecond(t)
+	}
+	return &gphotosEntry{
+		d:            g,
+		path:         path2.Join(parent, name),
+		kind:         kindItem,
+		id:           m.Id,
+		mimeType:     m.MimeType,
+		video:        m.isVideo(),
+		baseURL:      m.BaseURL,
+		urlExpiresAt: time.Now().Add(baseURLTTL).Unix(),
+		modTime:      modTime,
+	}
+}
+
+type gphotosEntry struct {
+	d    *GPhotos
+	path string
+	kind string
+	// id is the id of the album or the media item
+	id       string
+	mimeType string
+	// writable is whether the album can be uploaded to
+	writable bool
+	video    bool
+	modTime  int64
+
+	baseURL      string
+	urlExpiresAt int64
+}
+
+func (g *gphotosEntry) Path() string {
+	return g.path
+}
+
+func (g *gphotosEntry) Type() types.EntryType {
+	if g.kind == kindItem {
+		return types.TypeFile
+	}
+	return types.TypeDir
+}
+
+// Size is unknown, as the API doesn't return the sizes of the media items
+func (g *gphotosEntry) Size() int64 {
+	return -1
+}
+
+func (g *gphotosEntry) Meta() types.EntryMeta {
+	m := types.EntryMeta{CanRead: true, CanWrite: g.kind == kindAlbum && g.writable}
+	if g.kind == kindItem && g.baseURL != "" && g.urlExpiresAt > time.Now().Unix() {
+		m.Thumbnail = g.baseURL + "=w256-h256"
+	}
+	return m
+}
+
+func (g *gphotosEntry) ModTime() int64 {
+	return g.modTime
+}
+
+func (g *gphotosEntry) Drive() types.IDrive {
+	return g.d
+}
+
+func (g *gphotosEntry) Name() string {
+	return utils.PathBase(g.path)
+}
+
+func (g *gphotosEntry) EntryData() types.SM {
+	return types.SM{
+		"k":  g.kind,
+		"id": g.id,
+		"mt": g.mimeType,
+		"w":  strconv.FormatBool(g.writable),
+		"v":  strconv.FormatBool(g.video),
+		"u":  g.baseURL,
+		"ue": strconv.FormatInt(g.urlExpiresAt, 10),
+	}
+}
+
+func (g *gphotosEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	u, e := g.GetURL(ctx)
+	if e != nil {
+		return nil, e
+	}
+	return drive_util.GetURL(ctx, u.URL, nil)
+}
+
+// GetURL returns the download URL of the media item, which is the original content with the metadata
+func (g *gphotosEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if g.kind != kindItem {
+		return nil, err.NewNotAllowedError()
+	}
+	u, e := g.d.baseURL(ctx, g)
+	if e != nil {
+		return nil, e
+	}
+	if g.video {
+		return &types.ContentURL{URL: u + "=dv"}, nil
+	}
+	return &types.ContentURL{URL: u + "=d"}, nil
+}
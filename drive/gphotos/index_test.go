@@ -0,0 +1,177 @@
+package gphotos
+
+import (
+	"context"
+	"encoding/json"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	t        *testing.T
+	searches []searchRequest
+	uploaded string
+	created  batchCreateRequest
+	gets     int
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var result interface{}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/albums":
+		if r.URL.Query().Get("pageToken") == "" {
+			result = albumsResult{Albums: []album{{Id: "a1", Title: "Trip"}, {Id: "a2", Title: "Trip"}}, NextPageToken: "p2"}
+		} else {
+			result = albumsResult{Albums: []album{{Id: "a3", Title: "Mine/2021", IsWriteable: true}}}
+		}
+	case r.URL.Path == "/mediaItems:search":
+		search := searchRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&search)
+		s.searches = append(s.searches, search)
+		item := mediaItem{Id: "m1", Filename: "IMG_1.JPG", MimeType: "image/jpeg", BaseURL: "https://photos/m1"}
+		item.MediaMetadata.CreationTime = "2021-05-01T10:00:00Z"
+		dup := item
+		dup.Id = "m123456789"
+		result = searchResult{MediaItems: []mediaItem{item, dup}}
+	case r.Method == http.MethodGet && r.URL.Path == "/mediaItems/m1":
+		s.gets++
+		result = mediaItem{Id: "m1", BaseURL: "https://photos/m1-new"}
+	case r.URL.Path == "/uploads":
+		if r.Header.Get("X-Goog-Upload-Protocol") != "raw" || r.Header.Get("X-Goog-Upload-Content-Type") != "image/png" {
+			s.t.Errorf("unexpected upload headers: %v", r.Header)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		s.uploaded = string(body)
+		_, _ = w.Write([]byte("token1"))
+		return
+	case r.URL.Path == "/mediaItems:batchCreate":
+		_ = json.NewDecoder(r.Body).Decode(&s.created)
+		created := batchCreateResult{}
+		created.NewMediaItemResults = append(created.NewMediaItemResults, struct {
+			Status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"status"`
+			MediaItem mediaItem `json:"mediaItem"`
+		}{MediaItem: mediaItem{Id: "m2", Filename: "new.png"}})
+		result = created
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"code":404,"message":"not found","status":"NOT_FOUND"}}`))
+		return
+	}
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func newTestGPhotos(t *testing.T) (*GPhotos, *testServer, func()) {
+	s := &testServer{t: t}
+	server := httptest.NewServer(s)
+	c, e := req.NewClient(server.URL, nil, ifApiCallError, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return &GPhotos{c: c, startYear: 2020, cache: drive_util.DummyCache()}, s, server.Close
+}
+
+func paths(entries []types.IEntry) string {
+	r := make([]string, 0, len(entries))
+	for _, e := range entries {
+		r = append(r, e.Path())
+	}
+	return strings.Join(r, ",")
+}
+
+func TestGPhotosList(t *testing.T) {
+	g, s, stop := newTestGPhotos(t)
+	defer stop()
+	ctx := context.Background()
+
+	entries, e := g.List(ctx, "albums")
+	if e != nil || paths(entries) != "albums/Trip,albums/Trip (a2),albums/Mine_2021" {
+		t.Errorf("unexpected albums: %s, %v", paths(entries), e)
+	}
+	entries, e = g.List(ctx, "albums/Trip (a2)")
+	if e != nil || paths(entries) != "albums/Trip (a2)/IMG_1.JPG,albums/Trip (a2)/IMG_1 (23456789).JPG" {
+		t.Errorf("unexpected media items: %s, %v", paths(entries), e)
+	}
+	if len(s.searches) != 1 || s.searches[0].AlbumId != "a2" {
+		t.Errorf("unexpected search: %v", s.searches)
+	}
+
+	entries, e = g.List(ctx, "dates")
+	if e != nil || len(entries) != time.Now().Year()-2020+1 || entries[len(entries)-1].Path() != "dates/2020" {
+		t.Errorf("unexpected years: %s, %v", paths(entries), e)
+	}
+	entries, e = g.List(ctx, "dates/2020")
+	if e != nil || len(entries) != 12 || entries[1].Path() != "dates/2020/02" {
+		t.Errorf("unexpected months: %s, %v", paths(entries), e)
+	}
+	if _, e := g.List(ctx, "dates/2020/02"); e != nil {
+		t.Fatal(e)
+	}
+	r := s.searches[1].Filters.DateFilter.Ranges[0]
+	if r.StartDate != (date{2020, 2, 1}) || r.EndDate != (date{2020, 2, 29}) {
+		t.Errorf("unexpected date range: %v", r)
+	}
+	for _, p := range []string{"dates/2019", "dates/2020/13", "dates/2020/2", "other"} {
+		if _, e := g.Get(ctx, p); !err.IsNotFoundError(e) {
+			t.Errorf("expect NotFoundError of %s, but it's %v", p, e)
+		}
+	}
+
+	entry, e := g.Get(ctx, "dates/2020/02/IMG_1.JPG")
+	if e != nil {
+		t.Fatal(e)
+	}
+	item := entry.(*gphotosEntry)
+	if item.ModTime() != time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC).UnixNano()/1e6 {
+		t.Errorf("unexpected mod time %d", item.ModTime())
+	}
+	u, e := item.GetURL(ctx)
+	if e != nil || u.URL != "https://photos/m1=d" || s.gets != 0 {
+		t.Errorf("unexpected URL: %v, %v", u, e)
+	}
+	item.urlExpiresAt = time.Now().Unix() - 1
+	u, e = item.GetURL(ctx)
+	if e != nil || u.URL != "https://photos/m1-new=d" || s.gets != 1 {
+		t.Errorf("expect the expired base URL refreshed: %v, %v", u, e)
+	}
+}
+
+func TestGPhotosSave(t *testing.T) {
+	g, s, stop := newTestGPhotos(t)
+	defer stop()
+	ctx := task.DummyContext()
+
+	if _, e := g.Save(ctx, "new.png", 1, false, strings.NewReader("x")); !err.IsNotAllowedError(e) {
+		t.Errorf("expect NotAllowedError of saving out of the albums, but it's %v", e)
+	}
+	if _, e := g.Save(ctx, "albums/Trip/new.png", 1, false, strings.NewReader("x")); !err.IsNotAllowedError(e) {
+		t.Errorf("expect NotAllowedError of saving to the album not created by go-drive, but it's %v", e)
+	}
+	entry, e := g.Save(ctx, "albums/Mine_2021/new.png", 5, false, strings.NewReader("hello"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if entry.Path() != "albums/Mine_2021/new.png" || s.uploaded != "hello" {
+		t.Errorf("unexpected upload: %s, %s", entry.Path(), s.uploaded)
+	}
+	if s.created.AlbumId != "a3" || s.created.NewMediaItems[0].SimpleMediaItem.UploadToken != "token1" {
+		t.Errorf("unexpected media item created: %v", s.created)
+	}
+	if _, e := g.Save(ctx, "albums/Mine_2021/IMG_1.JPG", 1, true, strings.NewReader("x")); !err.IsNotAllowedError(e) {
+		t.Errorf("expect NotAllowedError of replacing, but it's %v", e)
+	}
+	if e := g.Delete(ctx, "albums/Mine_2021/IMG_1.JPG"); !err.IsNotAllowedError(e) {
+		t.Errorf("expect NotAllowedError of deleting, but it's %v", e)
+	}
+}
@@ -0,0 +1,109 @@
+package gphotos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	gOauth "google.golang.org/api/oauth2/v1"
+	"google.golang.org/api/option"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+func oauthReq(c common.Config) *drive_util.OAuthRequest {
+	return &drive_util.OAuthRequest{
+		Endpoint:    google.Endpoint,
+		RedirectURL: c.OAuthRedirectURI,
+		Scopes: []string{
+			"https://www.googleapis.com/auth/photoslibrary",
+			"https://www.googleapis.com/auth/userinfo.profile",
+		},
+		Text:           i18n.T("drive.gphotos.oauth_text"),
+		AutoCodeOption: []oauth2.AuthCodeOption{oauth2.AccessTypeOffline},
+	}
+}
+
+func InitConfig(ctx context.Context, config drive_util.DriveConfig,
+	utils drive_util.DriveUtils) (*drive_util.DriveInitConfig, error) {
+	initConfig, resp, e := drive_util.OAuthInitConfig(*oauthReq(utils.Config), config, utils.Data)
+	if e != nil {
+		return nil, e
+	}
+	if resp == nil {
+		return initConfig, nil
+	}
+	service, e := gOauth.NewService(ctx, option.WithHTTPClient(resp.Client(nil)))
+	if e != nil {
+		return nil, e
+	}
+
+	// get user info
+	user, e := service.Userinfo.V2.Me.Get().Context(ctx).Do()
+	initConfig.Configured = e == nil
+	if e == nil {
+		initConfig.OAuth.Principal = fmt.Sprintf("%s", user.Name)
+	}
+	return initConfig, nil
+}
+
+func Init(ctx context.Context, data types.SM, config drive_util.DriveConfig, utils drive_util.DriveUtils) error {
+	_, e := drive_util.OAuthInit(ctx, *oauthReq(utils.Config), data, config, utils.Data)
+	return e
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	defer func() { _ = resp.Dispose() }()
+	body, e := ioutil.ReadAll(io.LimitReader(resp.Response().Body, 64*1024))
+	if e != nil {
+		return e
+	}
+	ae := apiError{}
+	msg := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &ae) == nil && ae.Error.Code != 0 {
+		msg = ae.String()
+	}
+	switch resp.Status() {
+	case http.StatusNotFound:
+		return err.NewNotFoundMessageError(msg)
+	case http.StatusUnauthorized:
+		return err.NewUnauthorizedError(msg)
+	case http.StatusForbidden:
+		return err.NewNotAllowedMessageError(msg)
+	}
+	return err.NewRemoteApiError(500, i18n.T("drive.gphotos.remote_error", msg))
+}
+
+func (g *GPhotos) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	ed := ec.Data
+	if ed == nil || ed["k"] == "" {
+		return nil, errors.New("invalid cache")
+	}
+	return &gphotosEntry{
+		d: g, path: ec.Path, modTime: ec.ModTime, kind: ed["k"],
+		id:           ed["id"],
+		mimeType:     ed["mt"],
+		writable:     ed["w"] == "true",
+		video:        ed["v"] == "true",
+		baseURL:      ed["u"],
+		urlExpiresAt: utils.ToInt64(ed["ue"], -1),
+	}, nil
+}
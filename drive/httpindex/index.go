@@ -0,0 +1,284 @@
+package httpindex
+
+import (
+	"context"
+	"errors"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	path2 "path"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "httpindex",
+		DisplayName: i18n.T("drive.httpindex.name"),
+		README:      i18n.T("drive.httpindex.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "url", Label: i18n.T("drive.httpindex.form.url.label"), Type: "text", Required: true, Description: i18n.T("drive.httpindex.form.url.description")},
+			{Field: "proxy_download", Label: i18n.T("drive.httpindex.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.httpindex.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.httpindex.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.httpindex.form.cache_ttl.description"), DefaultValue: "5m"},
+		},
+		Factory: drive_util.DriveFactory{Create: NewHTTPIndex},
+	})
+}
+
+// maxIndexSize is the max size of an index page
+const maxIndexSize = 16 * 1024 * 1024
+
+// HTTPIndex is the read-only drive of the directories listed by the autoindex pages of nginx, Apache or Caddy,
+// or by their JSON index. The entries are found by listing their parents, as the servers tell nothing else.
+type HTTPIndex struct {
+	base *url.URL
+	c    *req.Client
+
+	downloadProxy bool
+	cacheTTL      time.Duration
+	cache         drive_util.DriveCache
+}
+
+func NewHTTPIndex(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	base, e := url.Parse(strings.TrimSpace(config["url"]))
+	if e != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, err.NewBadRequestError(i18n.T("drive.httpindex.invalid_url"))
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+		base.RawPath = ""
+	}
+	base.RawQuery, base.Fragment = "", ""
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	d := &HTTPIndex{base: base, downloadProxy: config["proxy_download"] != "", cacheTTL: cacheTtl}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+	// the redirects of the mirrors are followed
+	if d.c, e = req.NewClient("", nil, ifApiCallError, &http.Client{}); e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	_ = resp.Dispose()
+	switch resp.Status() {
+	case http.StatusNotFound:
+		return err.NewNotFoundError()
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return err.NewNotAllowedMessageError(i18n.T("drive.httpindex.remote_error", resp.Response().Status))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.httpindex.remote_error", resp.Response().Status))
+}
+
+// url returns the URL of path, the URLs of the directories end with '/'
+func (d *HTTPIndex) url(path string, dir bool) *url.URL {
+	if utils.IsRootPath(path) {
+		return d.base
+	}
+	if dir {
+		path += "/"
+	}
+	return d.base.ResolveReference(&url.URL{Path: path})
+}
+
+func (d *HTTPIndex) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: false}
+}
+
+func (d *HTTPIndex) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &indexEntry{d: d, path: path, isDir: true, size: -1, modTime: -1}, nil
+	}
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	entries, e := d.List(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	for _, entry := range entries {
+		if entry.Path() != path {
+			continue
+		}
+		ie := entry.(*indexEntry)
+		if !ie.isDir && !ie.exact {
+			if e := d.stat(ctx, ie); e != nil {
+				return nil, e
+			}
+		}
+		_ = d.cache.PutEntry(ie, d.cacheTTL)
+		return ie, nil
+	}
+	return nil, err.NewNotFoundError()
+}
+
+// stat gets the exact size and the modified time of the file by HEAD,
+// as the sizes are rounded in the index pages of Apache and Caddy
+func (d *HTTPIndex) stat(ctx context.Context, entry *indexEntry) error {
+	meta, e := drive_util.HeadURL(ctx, d.url(entry.path, false).String(), nil)
+	if e != nil {
+		return e
+	}
+	if meta.Size >= 0 {
+		entry.size, entry.exact = meta.Size, true
+	}
+	if meta.ModTime >= 0 {
+		entry.modTime = meta.ModTime
+	}
+	return nil
+}
+
+func (d *HTTPIndex) Save(types.TaskCtx, string, int64, bool, io.Reader) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *HTTPIndex) MakeDir(context.Context, string) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *HTTPIndex) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *HTTPIndex) Move(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+// List requests the index page of the directory, the JSON index is preferred if the server supports it
+func (d *HTTPIndex) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	u := d.url(path, true)
+	resp, e := d.c.Get(ctx, u.String(), types.SM{"Accept": "application/json, text/html;q=0.9, */*;q=0.8"})
+	if e != nil {
+		return nil, e
+	}
+	body, e := ioutil.ReadAll(io.LimitReader(resp.Response().Body, maxIndexSize))
+	_ = resp.Dispose()
+	if e != nil {
+		return nil, e
+	}
+	// the final URL after the redirects
+	if final := resp.Response().Request; final != nil && final.URL != nil {
+		u = final.URL
+	}
+	items := parseIndex(u, body)
+	entries := make([]types.IEntry, 0, len(items))
+	for _, i := range items {
+		entries = append(entries, &indexEntry{
+			d: d, path: path2.Join(path, i.name), isDir: i.isDir,
+			size: i.size, exact: i.exact, modTime: i.modTime,
+		})
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+func (d *HTTPIndex) Delete(types.TaskCtx, string) error {
+	return err.NewNotAllowedError()
+}
+
+func (d *HTTPIndex) Upload(context.Context, string, int64, bool, types.SM) (*types.DriveUploadConfig, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *HTTPIndex) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	if ec.Data == nil {
+		return nil, errors.New("invalid cache")
+	}
+	return &indexEntry{
+		d: d, path: ec.Path, isDir: ec.Type.IsDir(), size: ec.Size, modTime: ec.ModTime,
+		exact: ec.Data["exact"] != "",
+	}, nil
+}
+
+type indexEntry struct {
+	d     *HTTPIndex
+	path  string
+	isDir bool
+	size  int64
+	// exact is false if the size is rounded in the index page
+	exact   bool
+	modTime int64
+}
+
+func (e *indexEntry) Path() string {
+	return e.path
+}
+
+func (e *indexEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *indexEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *indexEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: false}
+}
+
+func (e *indexEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *indexEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *indexEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *indexEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	u, ee := e.GetURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	return drive_util.GetURL(ctx, u.URL, nil)
+}
+
+// GetURL returns the URL of the file on the server, the downloads are redirected to it unless proxied
+func (e *indexEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	return &types.ContentURL{URL: e.d.url(e.path, false).String(), Proxy: e.d.downloadProxy}, nil
+}
+
+func (e *indexEntry) EntryData() types.SM {
+	exact := ""
+	if e.exact {
+		exact = "1"
+	}
+	return types.SM{"exact": exact}
+}
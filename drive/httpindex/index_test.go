@@ -0,0 +1,113 @@
+package httpindex
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const nginxIndex = `<html>
+<head><title>Index of /pub/</title></head>
+<body>
+<h1>Index of /pub/</h1><hr><pre><a href="../">../</a>
+<a href="docs/">docs/</a>                                              13-Sep-2020 12:26                   -
+<a href="a%20b.txt">a b.txt</a>                                           13-Sep-2020 12:26                1234
+<a href="https://example.com/other">other</a>
+</pre><hr></body>
+</html>`
+
+const apacheIndex = `<table>
+<tr><th><a href="?C=N;O=D">Name</a></th><th><a href="?C=M;O=A">Last modified</a></th><th><a href="?C=S;O=A">Size</a></th></tr>
+<tr><td><a href="/pub/">Parent Directory</a></td><td>&nbsp;</td><td align="right">  - </td></tr>
+<tr><td><a href="iso/">iso/</a></td><td align="right">2020-09-13 12:26  </td><td align="right">  - </td></tr>
+<tr><td><a href="big.iso">big.iso</a></td><td align="right">2020-09-13 12:26  </td><td align="right">1.5G</td></tr>
+</table>`
+
+const caddyIndex = `[{"name":"sub/","size":4096,"url":"./sub/","mod_time":"2020-09-13T12:26:40Z","is_dir":true},` +
+	`{"name":"x.txt","size":5,"url":"./x.txt","mod_time":"2020-09-13T12:26:40Z","is_dir":false}]`
+
+func TestParseIndex(t *testing.T) {
+	dir, _ := url.Parse("http://mirror.example.com/pub/")
+	items := parseIndex(dir, []byte(nginxIndex))
+	if len(items) != 2 || items[0].name != "docs" || !items[0].isDir || items[0].modTime != 1600000000000-40000 {
+		t.Fatalf("unexpected nginx items: %v", items)
+	}
+	if items[1].name != "a b.txt" || items[1].isDir || items[1].size != 1234 || !items[1].exact {
+		t.Errorf("unexpected nginx file: %v", items[1])
+	}
+
+	dir, _ = url.Parse("http://mirror.example.com/pub/linux/")
+	items = parseIndex(dir, []byte(apacheIndex))
+	if len(items) != 2 || items[0].name != "iso" || !items[0].isDir {
+		t.Fatalf("unexpected apache items: %v", items)
+	}
+	if items[1].name != "big.iso" || items[1].size != 1536*1024*1024 || items[1].exact ||
+		items[1].modTime != 1600000000000-40000 {
+		t.Errorf("unexpected apache file: %v", items[1])
+	}
+
+	items = parseIndex(dir, []byte(caddyIndex))
+	if len(items) != 2 || items[0].name != "sub" || !items[0].isDir || items[0].size != -1 {
+		t.Fatalf("unexpected caddy items: %v", items)
+	}
+	if items[1].name != "x.txt" || items[1].size != 5 || !items[1].exact || items[1].modTime != 1600000000000 {
+		t.Errorf("unexpected caddy file: %v", items[1])
+	}
+}
+
+func TestHTTPIndex(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pub/":
+			_, _ = w.Write([]byte(nginxIndex))
+		case "/pub/docs":
+			http.Redirect(w, r, "/pub/docs/", http.StatusMovedPermanently)
+		case "/pub/docs/":
+			_, _ = w.Write([]byte(apacheIndex))
+		case "/pub/docs/big.iso":
+			w.Header().Set("Content-Length", "10")
+			w.Header().Set("Last-Modified", "Sun, 13 Sep 2020 12:26:40 GMT")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	if _, e := NewHTTPIndex(context.Background(), drive_util.DriveConfig{"url": "ftp://example.com"},
+		drive_util.DriveUtils{}); e == nil {
+		t.Error("expect error of the invalid url")
+	}
+	d, e := NewHTTPIndex(context.Background(), drive_util.DriveConfig{"url": server.URL + "/pub"}, drive_util.DriveUtils{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	ctx := context.Background()
+	entry, e := d.Get(ctx, "a b.txt")
+	if e != nil {
+		t.Fatal(e)
+	}
+	u, e := entry.(*indexEntry).GetURL(ctx)
+	if e != nil || u.URL != server.URL+"/pub/a%20b.txt" || u.Proxy {
+		t.Errorf("unexpected url: %v, %v", u, e)
+	}
+	entry, e = d.Get(ctx, "docs/big.iso")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if entry.Size() != 10 || entry.ModTime() != 1600000000000 {
+		t.Errorf("expect the exact size and time, got %d, %d", entry.Size(), entry.ModTime())
+	}
+	if _, e := d.Get(ctx, "docs/none"); !err.IsNotFoundError(e) {
+		t.Errorf("expect not found, got %v", e)
+	}
+	if _, e := d.List(ctx, "none"); !err.IsNotFoundError(e) {
+		t.Errorf("expect not found, got %v", e)
+	}
+	if e := d.Delete(nil, "a b.txt"); !err.IsNotAllowedError(e) {
+		t.Errorf("expect not allowed, got %v", e)
+	}
+}
@@ -0,0 +1,174 @@
+package httpindex
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-drive/common/utils"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexItem is an item of the index page
+type indexItem struct {
+	name  string
+	isDir bool
+	// size is -1 if unknown, exact is false if the size is rounded by the server, like '1.2K'
+	size    int64
+	exact   bool
+	modTime int64
+}
+
+var (
+	anchorPattern = regexp.MustCompile(`(?is)<a\s[^>]*?href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))[^>]*>.*?</a>`)
+	tagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+	// datetimePattern matches the time elements of Caddy
+	datetimePattern = regexp.MustCompile(`datetime\s*=\s*"([^"]+)"`)
+	// datePattern matches the dates of nginx like '13-Sep-2020 12:26' and the dates of Apache like '2020-09-13 12:26'
+	datePattern = regexp.MustCompile(`\d{1,2}-[A-Za-z]{3}-\d{4} \d{2}:\d{2}(?::\d{2})?|\d{4}-\d{2}-\d{2} \d{2}:\d{2}(?::\d{2})?`)
+	sizePattern = regexp.MustCompile(`(?i)(?:^|\s)(\d+(?:\.\d+)?)\s?([kmgtp]i?b?|bytes|b)?(?:\s|$)`)
+)
+
+var dateLayouts = []string{
+	"02-Jan-2006 15:04", "02-Jan-2006 15:04:05",
+	"2006-01-02 15:04", "2006-01-02 15:04:05",
+}
+
+// parseIndex parses the index page of the directory at dir,
+// which is the JSON of nginx or Caddy, or the HTML page of which the links to the children are the items
+func parseIndex(dir *url.URL, body []byte) []indexItem {
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		if items, e := parseJSONIndex(trimmed); e == nil {
+			return items
+		}
+	}
+	return parseHTMLIndex(dir, string(body))
+}
+
+// jsonItem is the item of the JSON index of nginx (type, mtime) or Caddy (is_dir, mod_time)
+type jsonItem struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	IsDir   bool   `json:"is_dir"`
+	Size    *int64 `json:"size"`
+	MTime   string `json:"mtime"`
+	ModTime string `json:"mod_time"`
+}
+
+func parseJSONIndex(body []byte) ([]indexItem, error) {
+	items := make([]jsonItem, 0)
+	if e := json.Unmarshal(body, &items); e != nil {
+		return nil, e
+	}
+	result := make([]indexItem, 0, len(items))
+	for _, i := range items {
+		name := strings.TrimSuffix(i.Name, "/")
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			continue
+		}
+		item := indexItem{
+			name:    name,
+			isDir:   i.IsDir || i.Type == "directory" || strings.HasSuffix(i.Name, "/"),
+			size:    -1,
+			modTime: -1,
+		}
+		if i.Size != nil && !item.isDir {
+			item.size, item.exact = *i.Size, true
+		}
+		if t, e := time.Parse(time.RFC1123, i.MTime); e == nil {
+			item.modTime = utils.Millisecond(t)
+		}
+		if t, e := time.Parse(time.RFC3339Nano, i.ModTime); e == nil {
+			item.modTime = utils.Millisecond(t)
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// parseHTMLIndex finds the links to the children of dir, the size and the time are parsed
+// from the text between the link and the next one, which is the line or the row of the item
+func parseHTMLIndex(dir *url.URL, body string) []indexItem {
+	matches := anchorPattern.FindAllStringSubmatchIndex(body, -1)
+	result := make([]indexItem, 0, len(matches))
+	found := make(map[string]bool)
+	for n, m := range matches {
+		href := ""
+		for g := 1; g <= 3; g++ {
+			if m[2*g] >= 0 {
+				href = body[m[2*g]:m[2*g+1]]
+				break
+			}
+		}
+		name, isDir, ok := childName(dir, html.UnescapeString(href))
+		if !ok || found[name] {
+			continue
+		}
+		found[name] = true
+		end := len(body)
+		if n+1 < len(matches) {
+			end = matches[n+1][0]
+		}
+		item := indexItem{name: name, isDir: isDir, size: -1, modTime: -1}
+		parseDetails(&item, body[m[1]:end])
+		result = append(result, item)
+	}
+	return result
+}
+
+// childName returns the name of the child of dir that href links to
+func childName(dir *url.URL, href string) (string, bool, bool) {
+	ref, e := url.Parse(strings.TrimSpace(href))
+	if e != nil {
+		return "", false, false
+	}
+	u := dir.ResolveReference(ref)
+	if u.Host != dir.Host || u.RawQuery != "" || !strings.HasPrefix(u.Path, dir.Path) {
+		return "", false, false
+	}
+	name := u.Path[len(dir.Path):]
+	isDir := strings.HasSuffix(name, "/")
+	name = strings.TrimSuffix(name, "/")
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", false, false
+	}
+	return name, isDir, true
+}
+
+func parseDetails(item *indexItem, raw string) {
+	if m := datetimePattern.FindStringSubmatch(raw); m != nil {
+		if t, e := time.Parse(time.RFC3339Nano, m[1]); e == nil {
+			item.modTime = utils.Millisecond(t)
+		}
+	}
+	text := html.UnescapeString(tagPattern.ReplaceAllString(raw, " "))
+	if loc := datePattern.FindStringIndex(text); loc != nil {
+		for _, layout := range dateLayouts {
+			if t, e := time.Parse(layout, text[loc[0]:loc[1]]); e == nil {
+				item.modTime = utils.Millisecond(t)
+				break
+			}
+		}
+		text = text[:loc[0]] + " " + text[loc[1]:]
+	}
+	if item.isDir {
+		return
+	}
+	m := sizePattern.FindStringSubmatch(text)
+	if m == nil {
+		return
+	}
+	switch unit := strings.ToLower(m[2]); unit {
+	case "", "b", "bytes":
+		if size, e := strconv.ParseInt(m[1], 10, 64); e == nil {
+			item.size, item.exact = size, true
+		}
+	default:
+		if size, e := utils.ParseBytes(m[1] + unit); e == nil {
+			item.size = int64(size)
+		}
+	}
+}
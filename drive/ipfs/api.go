@@ -0,0 +1,109 @@
+package ipfs
+
+import (
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+const (
+	typeFile = "file"
+	typeDir  = "directory"
+
+	// lsTypeDir is the type of the directories in the listing of files/ls
+	lsTypeDir = 1
+)
+
+// statResult is the result of files/stat
+type statResult struct {
+	Hash string `json:"Hash"`
+	Size int64  `json:"Size"`
+	Type string `json:"Type"`
+}
+
+type lsEntry struct {
+	Name string `json:"Name"`
+	Type int    `json:"Type"`
+	Size int64  `json:"Size"`
+	Hash string `json:"Hash"`
+}
+
+// lsResult is the result of files/ls, Entries is null if the directory is empty
+type lsResult struct {
+	Entries []lsEntry `json:"Entries"`
+}
+
+type apiError struct {
+	Message string `json:"Message"`
+	Code    int    `json:"Code"`
+	Type    string `json:"Type"`
+}
+
+// newClient creates the client of the RPC API of Kubo, authorization is the optional value of the Authorization header
+func newClient(apiURL, authorization string) (*req.Client, error) {
+	return req.NewClient(strings.TrimSuffix(apiURL, "/")+"/api/v0", func(r *http.Request) error {
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		return nil
+	}, ifApiCallError, nil)
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	ae := apiError{}
+	if e := resp.Json(&ae); e != nil || ae.Message == "" {
+		ae.Message = resp.Response().Status
+	}
+	if strings.Contains(ae.Message, "does not exist") || strings.Contains(ae.Message, "not found") {
+		return err.NewNotFoundError()
+	}
+	if strings.Contains(ae.Message, "already exists") {
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	if resp.Status() == http.StatusUnauthorized || resp.Status() == http.StatusForbidden {
+		return err.NewUnauthorizedError(i18n.T("drive.ipfs.unauthorized", ae.Message))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.ipfs.remote_error", ae.Message))
+}
+
+// multipartBody streams the content as the file of the multipart form, which is required by files/write
+type multipartBody struct {
+	r           io.Reader
+	contentType string
+}
+
+// newMultipartBody returns the body, the returned reader must be closed after the request to stop the writing
+func newMultipartBody(name string, reader io.Reader) (*multipartBody, io.Closer) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, e := mw.CreateFormFile("file", name)
+		if e == nil {
+			_, e = io.Copy(part, reader)
+		}
+		if e == nil {
+			e = mw.Close()
+		}
+		_ = pw.CloseWithError(e)
+	}()
+	return &multipartBody{r: pr, contentType: mw.FormDataContentType()}, pr
+}
+
+func (m *multipartBody) ContentLength() int64 {
+	return -1
+}
+
+func (m *multipartBody) ContentType() string {
+	return m.contentType
+}
+
+func (m *multipartBody) Reader() io.Reader {
+	return m.r
+}
@@ -0,0 +1,80 @@
+package ipfs
+
+import (
+	"context"
+	"go-drive/common/errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIPFSMultipartWrite(t *testing.T) {
+	var (
+		arg     string
+		content string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/files/write" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"Message":"forbidden","Code":0,"Type":"error"}`))
+			return
+		}
+		arg = r.URL.Query().Get("arg")
+		f, _, e := r.FormFile("file")
+		if e != nil {
+			t.Error(e)
+			return
+		}
+		b, _ := ioutil.ReadAll(f)
+		content = string(b)
+	}))
+	defer server.Close()
+
+	c, e := newClient(server.URL+"/", "Bearer token")
+	if e != nil {
+		t.Fatal(e)
+	}
+	body, closer := newMultipartBody("a b.txt", strings.NewReader("hello ipfs"))
+	resp, e := c.Post(context.Background(), "files/write?arg=%2Fdir%2Fa+b.txt", nil, body)
+	_ = closer.Close()
+	if e != nil {
+		t.Fatal(e)
+	}
+	_ = resp.Dispose()
+	if arg != "/dir/a b.txt" || content != "hello ipfs" {
+		t.Errorf("unexpected write: %s, %s", arg, content)
+	}
+}
+
+func TestIPFSApiError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		switch r.URL.Path {
+		case "/api/v0/files/stat":
+			_, _ = w.Write([]byte(`{"Message":"file does not exist","Code":0,"Type":"error"}`))
+		case "/api/v0/files/mkdir":
+			_, _ = w.Write([]byte(`{"Message":"file already exists","Code":0,"Type":"error"}`))
+		default:
+			_, _ = w.Write([]byte(`{"Message":"boom","Code":0,"Type":"error"}`))
+		}
+	}))
+	defer server.Close()
+
+	c, e := newClient(server.URL, "")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := c.Post(context.Background(), "files/stat", nil, nil); !err.IsNotFoundError(e) {
+		t.Errorf("expect not found error, got %v", e)
+	}
+	if _, e := c.Post(context.Background(), "files/mkdir", nil, nil); e == nil || err.IsNotFoundError(e) {
+		t.Errorf("expect not allowed error, got %v", e)
+	}
+	if _, e := c.Post(context.Background(), "files/rm", nil, nil); e == nil {
+		t.Error("expect remote error")
+	} else if re, ok := e.(err.RemoteApiError); !ok || re.Code() != http.StatusInternalServerError {
+		t.Errorf("unexpected error: %v", e)
+	}
+}
@@ -0,0 +1,368 @@
+package ipfs
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"net/url"
+	path2 "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "ipfs",
+		DisplayName: i18n.T("drive.ipfs.name"),
+		README:      i18n.T("drive.ipfs.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "api_url", Label: i18n.T("drive.ipfs.form.api_url.label"), Type: "text", Required: true, Description: i18n.T("drive.ipfs.form.api_url.description"), DefaultValue: defaultAPIURL},
+			{Field: "authorization", Label: i18n.T("drive.ipfs.form.authorization.label"), Type: "password", Description: i18n.T("drive.ipfs.form.authorization.description")},
+			{Field: "root", Label: i18n.T("drive.ipfs.form.root.label"), Type: "text", Description: i18n.T("drive.ipfs.form.root.description")},
+			{Field: "gateway", Label: i18n.T("drive.ipfs.form.gateway.label"), Type: "text", Description: i18n.T("drive.ipfs.form.gateway.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.ipfs.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.ipfs.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewIPFSDrive},
+	})
+}
+
+const defaultAPIURL = "http://127.0.0.1:5001"
+
+type IPFSDrive struct {
+	c *req.Client
+	// root is the path of the root in MFS, it starts with '/'
+	root string
+	// gateway is the optional gateway to redirect the downloads to
+	gateway string
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+}
+
+// NewIPFSDrive creates the drive of the MFS of a Kubo node, the root is created if not exists
+func NewIPFSDrive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	apiURL := strings.TrimSpace(config["api_url"])
+	if apiURL == "" {
+		apiURL = defaultAPIURL
+	}
+	c, e := newClient(apiURL, strings.TrimSpace(config["authorization"]))
+	if e != nil {
+		return nil, e
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	d := &IPFSDrive{
+		c:        c,
+		root:     "/" + utils.CleanPath(config["root"]),
+		gateway:  strings.TrimSuffix(strings.TrimSpace(config["gateway"]), "/"),
+		cacheTTL: cacheTtl,
+	}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+
+	stat, e := d.stat(ctx, d.root)
+	if err.IsNotFoundError(e) {
+		e = d.call(ctx, "files/mkdir", url.Values{"arg": {d.root}, "parents": {"true"}})
+	} else if e == nil && stat.Type != typeDir {
+		e = err.NewNotAllowedMessageError(i18n.T("drive.ipfs.root_not_dir", d.root))
+	}
+	if e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+// mfsPath returns the path in MFS of path
+func (d *IPFSDrive) mfsPath(path string) string {
+	return path2.Join(d.root, path)
+}
+
+// call calls the API, the response is disposed
+func (d *IPFSDrive) call(ctx context.Context, api string, query url.Values) error {
+	resp, e := d.c.Post(ctx, api+"?"+query.Encode(), nil, nil)
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+func (d *IPFSDrive) stat(ctx context.Context, p string) (*statResult, error) {
+	resp, e := d.c.Post(ctx, "files/stat?"+url.Values{"arg": {p}}.Encode(), nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	res := &statResult{}
+	if e := resp.Json(res); e != nil {
+		return nil, e
+	}
+	return res, nil
+}
+
+func (d *IPFSDrive) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	return &ipfsEntry{d: d, path: ec.Path, size: ec.Size, isDir: ec.Type.IsDir(), cid: ec.Data["cid"]}, nil
+}
+
+func (d *IPFSDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *IPFSDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	stat, e := d.stat(ctx, d.mfsPath(path))
+	if e != nil {
+		return nil, e
+	}
+	entry := &ipfsEntry{d: d, path: path, size: stat.Size, isDir: stat.Type == typeDir, cid: stat.Hash}
+	_ = d.cache.PutEntry(entry, d.cacheTTL)
+	return entry, nil
+}
+
+func (d *IPFSDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(size, true)
+	body, closer := newMultipartBody(utils.PathBase(path), drive_util.ProgressReader(reader, ctx))
+	resp, e := d.c.Post(ctx, "files/write?"+url.Values{
+		"arg": {d.mfsPath(path)}, "create": {"true"}, "truncate": {"true"},
+	}.Encode(), nil, body)
+	_ = closer.Close()
+	_ = d.cache.Evict(path, false)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	return d.Get(ctx, path)
+}
+
+func (d *IPFSDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := d.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	if e := d.call(ctx, "files/mkdir", url.Values{"arg": {d.mfsPath(path)}}); e != nil {
+		return nil, e
+	}
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return d.Get(ctx, path)
+}
+
+func (d *IPFSDrive) isSelf(e types.IEntry) bool {
+	if ie, ok := e.(*ipfsEntry); ok {
+		return ie.d == d
+	}
+	return false
+}
+
+// prepareTarget removes the existing file of the target if override,
+// the existing directory is left to be merged by the caller
+func (d *IPFSDrive) prepareTarget(ctx types.TaskCtx, isDir bool, to string, override bool) error {
+	existing, e := d.Get(ctx, to)
+	if err.IsNotFoundError(e) {
+		return nil
+	}
+	if e != nil {
+		return e
+	}
+	if isDir || existing.Type().IsDir() {
+		return err.NewUnsupportedError()
+	}
+	if !override {
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	e = d.call(ctx, "files/rm", url.Values{"arg": {d.mfsPath(to)}})
+	_ = d.cache.Evict(to, true)
+	return e
+}
+
+// Copy copies the file or the directory by the CID, which links the same blocks without copying the data
+func (d *IPFSDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	cid := from.(*ipfsEntry).cid
+	if cid == "" {
+		stat, e := d.stat(ctx, d.mfsPath(from.Path()))
+		if e != nil {
+			return nil, e
+		}
+		cid = stat.Hash
+	}
+	if e := d.prepareTarget(ctx, from.Type().IsDir(), to, override); e != nil {
+		return nil, e
+	}
+	e := d.call(ctx, "files/cp", url.Values{"arg": {"/ipfs/" + cid, d.mfsPath(to)}})
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	return d.Get(ctx, to)
+}
+
+func (d *IPFSDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	if e := d.prepareTarget(ctx, from.Type().IsDir(), to, override); e != nil {
+		return nil, e
+	}
+	e := d.call(ctx, "files/mv", url.Values{"arg": {d.mfsPath(from.Path()), d.mfsPath(to)}})
+	_ = d.cache.Evict(from.Path(), true)
+	_ = d.cache.Evict(utils.PathParent(from.Path()), false)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	return d.Get(ctx, to)
+}
+
+func (d *IPFSDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	resp, e := d.c.Post(ctx, "files/ls?"+url.Values{"arg": {d.mfsPath(path)}, "long": {"true"}}.Encode(), nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	res := lsResult{}
+	if e := resp.Json(&res); e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, 0, len(res.Entries))
+	for _, f := range res.Entries {
+		entries = append(entries, &ipfsEntry{
+			d: d, path: utils.CleanPath(path2.Join(path, f.Name)), size: f.Size, isDir: f.Type == lsTypeDir, cid: f.Hash,
+		})
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+func (d *IPFSDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	e := d.call(ctx, "files/rm", url.Values{"arg": {d.mfsPath(path)}, "recursive": {"true"}})
+	_ = d.cache.Evict(path, true)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+func (d *IPFSDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+type ipfsEntry struct {
+	d     *IPFSDrive
+	path  string
+	isDir bool
+	size  int64
+	cid   string
+}
+
+func (e *ipfsEntry) Path() string {
+	return e.path
+}
+
+func (e *ipfsEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *ipfsEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *ipfsEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true, Props: types.M{"cid": e.cid}}
+}
+
+// ModTime returns -1, as MFS does not keep the modification time by default
+func (e *ipfsEntry) ModTime() int64 {
+	return -1
+}
+
+func (e *ipfsEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *ipfsEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *ipfsEntry) EntryData() types.SM {
+	return types.SM{"cid": e.cid}
+}
+
+func (e *ipfsEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+func (e *ipfsEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	query := url.Values{"arg": {e.d.mfsPath(e.path)}}
+	if offset > 0 {
+		query.Set("offset", strconv.FormatInt(offset, 10))
+	}
+	if length >= 0 {
+		if length == 0 {
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		}
+		query.Set("count", strconv.FormatInt(length, 10))
+	}
+	resp, ee := e.d.c.Post(ctx, "files/read?"+query.Encode(), nil, nil)
+	if ee != nil {
+		return nil, ee
+	}
+	return resp.Response().Body, nil
+}
+
+// GetURL returns the URL of the CID on the gateway, the RPC API can not be downloaded by GET requests
+func (e *ipfsEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	if e.isDir || e.d.gateway == "" || e.cid == "" {
+		return nil, err.NewUnsupportedError()
+	}
+	return &types.ContentURL{
+		URL: e.d.gateway + "/ipfs/" + url.PathEscape(e.cid) + "?" + url.Values{"filename": {e.Name()}}.Encode(),
+	}, nil
+}
@@ -0,0 +1,164 @@
+package jottacloud
+
+import (
+	"encoding/xml"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/utils"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	jfsURL = "https://jfs.jottacloud.com/jfs"
+	apiURL = "https://api.jottacloud.com"
+
+	// clientId is the client of the command line tool of Jottacloud, which the login tokens are issued for
+	clientId = "jottacli"
+
+	// jfsTimeLayout is the layout of the times in the responses of JFS
+	jfsTimeLayout = "2006-01-02-T15:04:05Z0700"
+
+	stateCompleted = "COMPLETED"
+)
+
+// loginToken is the decoded personal login token generated in the security settings of Jottacloud
+type loginToken struct {
+	Username      string `json:"username"`
+	Realm         string `json:"realm"`
+	WellKnownLink string `json:"well_known_link"`
+	AuthToken     string `json:"auth_token"`
+}
+
+type wellKnownConfig struct {
+	TokenEndpoint string `json:"token_endpoint"`
+}
+
+type customer struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// jfsItem is the user, device, mount point, folder or file responded by JFS, which is told by XMLName
+type jfsItem struct {
+	XMLName xml.Name
+	// Name is the name of the folders and the files
+	Name string `xml:"name,attr"`
+	// ElemName is the name of the devices and the mount points
+	ElemName string `xml:"name"`
+	// Deleted is not empty if the item is in the trash
+	Deleted string `xml:"deleted,attr"`
+
+	Capacity int64  `xml:"capacity"`
+	Usage    int64  `xml:"usage"`
+	Modified string `xml:"modified"`
+
+	Devices         []jfsItem    `xml:"devices>device"`
+	MountPoints     []jfsItem    `xml:"mountPoints>mountPoint"`
+	Folders         []jfsItem    `xml:"folders>folder"`
+	Files           []jfsItem    `xml:"files>file"`
+	CurrentRevision *jfsRevision `xml:"currentRevision"`
+}
+
+// jfsRevision is the revision of a file, a file without the current revision has not been uploaded completely
+type jfsRevision struct {
+	State    string `xml:"state"`
+	Modified string `xml:"modified"`
+	Size     int64  `xml:"size"`
+	MD5      string `xml:"md5"`
+}
+
+func (i jfsItem) isDir() bool {
+	return i.XMLName.Local != "file"
+}
+
+func (i jfsItem) name() string {
+	if i.Name != "" {
+		return i.Name
+	}
+	return i.ElemName
+}
+
+// available returns whether the item is neither in the trash nor incomplete
+func (i jfsItem) available() bool {
+	return i.Deleted == "" && (i.isDir() || (i.CurrentRevision != nil && i.CurrentRevision.State == stateCompleted))
+}
+
+func parseTime(s string) int64 {
+	t, e := time.Parse(jfsTimeLayout, s)
+	if e != nil {
+		return -1
+	}
+	return utils.Millisecond(t)
+}
+
+// allocateRequest allocates an upload, the content is not uploaded if Jottacloud has the same MD5
+type allocateRequest struct {
+	Bytes    int64  `json:"bytes"`
+	Created  string `json:"created"`
+	Modified string `json:"modified"`
+	MD5      string `json:"md5"`
+	// Path is the path with the device and the mount point
+	Path string `json:"path"`
+}
+
+type allocateResult struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	State     string `json:"state"`
+	UploadId  string `json:"upload_id"`
+	UploadURL string `json:"upload_url"`
+	Bytes     int64  `json:"bytes"`
+	ResumePos int64  `json:"resume_pos"`
+}
+
+type jfsError struct {
+	Code    int    `xml:"code"`
+	Message string `xml:"message"`
+	Reason  string `xml:"reason"`
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	message := resp.Response().Status
+	if strings.Contains(resp.Response().Header.Get("Content-Type"), "xml") {
+		je := jfsError{}
+		if e := resp.XML(&je); e == nil && je.Message != "" {
+			message = je.Message
+		}
+	} else {
+		_ = resp.Dispose()
+	}
+	switch resp.Status() {
+	case http.StatusNotFound:
+		return err.NewNotFoundError()
+	case http.StatusUnauthorized:
+		return err.NewUnauthorizedError(i18n.T("drive.jottacloud.remote_error", message))
+	case http.StatusForbidden, http.StatusConflict:
+		return err.NewNotAllowedMessageError(i18n.T("drive.jottacloud.remote_error", message))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.jottacloud.remote_error", message))
+}
+
+// uploadBody is the content uploaded to the upload URL from the resume position
+type uploadBody struct {
+	r    io.Reader
+	size int64
+}
+
+func (b *uploadBody) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (b *uploadBody) Reader() io.Reader {
+	return b.r
+}
+
+func (b *uploadBody) ContentLength() int64 {
+	return b.size
+}
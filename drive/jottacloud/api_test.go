@@ -0,0 +1,97 @@
+package jottacloud
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"testing"
+)
+
+func TestJottaLoginToken(t *testing.T) {
+	raw := `{"username":"u1","realm":"jottacloud","well_known_link":"https://id.jottacloud.com/.well-known","auth_token":"abc"}`
+	for _, s := range []string{
+		base64.URLEncoding.EncodeToString([]byte(raw)),
+		base64.RawStdEncoding.EncodeToString([]byte(raw)),
+		" " + base64.StdEncoding.EncodeToString([]byte(raw)) + "\n",
+	} {
+		lt, e := decodeLoginToken(s)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if lt.Username != "u1" || lt.AuthToken != "abc" || lt.WellKnownLink != "https://id.jottacloud.com/.well-known" {
+			t.Errorf("unexpected login token: %v", lt)
+		}
+	}
+	for _, s := range []string{"", "not a token", base64.StdEncoding.EncodeToString([]byte(`{"username":"u1"}`))} {
+		if _, e := decodeLoginToken(s); e == nil {
+			t.Errorf("expect error of the invalid token '%s'", s)
+		}
+	}
+}
+
+func TestJottaItem(t *testing.T) {
+	dat := `<folder name="a b" time="2020-01-01-T00:00:00Z">
+	<path xml:space="preserve">/u1/Jotta/Archive</path>
+	<folders>
+		<folder name="sub"/>
+		<folder name="old" deleted="2020-01-01-T00:00:00Z"/>
+	</folders>
+	<files>
+		<file name="x.txt" uuid="1">
+			<currentRevision>
+				<number>1</number><state>COMPLETED</state>
+				<modified>2020-09-13-T12:26:40Z</modified>
+				<size>5</size><md5>5d41402abc4b2a76b9719d911017c592</md5>
+			</currentRevision>
+		</file>
+		<file name="y.txt" uuid="2">
+			<latestRevision><number>1</number><state>INCOMPLETE</state></latestRevision>
+		</file>
+	</files>
+</folder>`
+	item := jfsItem{}
+	if e := xml.Unmarshal([]byte(dat), &item); e != nil {
+		t.Fatal(e)
+	}
+	if !item.isDir() || item.name() != "a b" || !item.available() {
+		t.Errorf("unexpected folder: %v", item)
+	}
+	if len(item.Folders) != 2 || !item.Folders[0].available() || item.Folders[1].available() {
+		t.Errorf("unexpected folders: %v", item.Folders)
+	}
+	if len(item.Files) != 2 || !item.Files[0].available() || item.Files[1].available() {
+		t.Fatalf("unexpected files: %v", item.Files)
+	}
+	entry := (&Jottacloud{}).newEntry("Jotta/Archive", item.Files[0])
+	if entry.Path() != "Jotta/Archive/x.txt" || entry.Size() != 5 || entry.ModTime() != 1600000000000 ||
+		entry.md5 != "5d41402abc4b2a76b9719d911017c592" || !entry.Meta().CanWrite {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+
+	dat = `<user><username>u1</username><capacity>-1</capacity><usage>10</usage>
+	<devices><device><name>Jotta</name><modified>2020-09-13-T12:26:40Z</modified></device></devices></user>`
+	user := jfsItem{}
+	if e := xml.Unmarshal([]byte(dat), &user); e != nil {
+		t.Fatal(e)
+	}
+	if user.Capacity != -1 || len(user.Devices) != 1 || user.Devices[0].name() != "Jotta" || !user.Devices[0].isDir() {
+		t.Errorf("unexpected user: %v", user)
+	}
+	if device := (&Jottacloud{}).newEntry("", user.Devices[0]); device.Meta().CanWrite {
+		t.Errorf("expect the device not writable")
+	}
+}
+
+func TestJottaPath(t *testing.T) {
+	if p := escapePath("Jotta/Archive/a b/c?d"); p != "/Jotta/Archive/a%20b/c%3Fd" {
+		t.Errorf("unexpected escaped path: %s", p)
+	}
+	if d := depth(""); d != 0 {
+		t.Errorf("unexpected depth of the root: %d", d)
+	}
+	if e := requireWritable("Jotta/Archive"); e == nil {
+		t.Error("expect the mount point not writable")
+	}
+	if e := requireWritable("Jotta/Archive/a"); e != nil {
+		t.Error(e)
+	}
+}
@@ -0,0 +1,443 @@
+package jottacloud
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	path2 "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "jottacloud",
+		DisplayName: i18n.T("drive.jottacloud.name"),
+		README:      i18n.T("drive.jottacloud.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "cache_ttl", Label: i18n.T("drive.jottacloud.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.jottacloud.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewJottacloud, InitConfig: InitConfig, Init: Init},
+	})
+}
+
+// Jottacloud maps the devices and their mount points to the top two levels of directories,
+// the files and the folders can be written in the mount points only.
+type Jottacloud struct {
+	// jfs calls the file system API, api calls the account and the upload API, uc uploads the content
+	jfs *req.Client
+	api *req.Client
+	uc  *req.Client
+
+	username string
+	tempDir  string
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+}
+
+func NewJottacloud(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	ts, e := loadTokenSource(config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	j := &Jottacloud{tempDir: driveUtils.Config.TempDir, cacheTTL: cacheTtl}
+	if cacheTtl <= 0 {
+		j.cache = drive_util.DummyCache()
+	} else {
+		j.cache = driveUtils.CreateCache(j.deserializeEntry, nil)
+	}
+	if j.api, e = newClient(apiURL, ts); e != nil {
+		return nil, e
+	}
+	if j.uc, e = newClient("", ts); e != nil {
+		return nil, e
+	}
+	cu, e := getCustomer(ctx, j.api)
+	if e != nil {
+		return nil, e
+	}
+	j.username = cu.Username
+	if j.jfs, e = newClient(jfsURL+escapePath(j.username), ts); e != nil {
+		return nil, e
+	}
+	return j, nil
+}
+
+func (j *Jottacloud) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+// jfsPath returns the absolute path in JFS, which is used as the target of copying and moving
+func (j *Jottacloud) jfsPath(path string) string {
+	return "/" + j.username + "/" + path
+}
+
+// requireWritable checks whether the path is in a mount point
+func requireWritable(path string) error {
+	if depth(path) < 3 {
+		return err.NewNotAllowedError()
+	}
+	return nil
+}
+
+// item requests the item of path from JFS, the items in the trash are not found
+func (j *Jottacloud) item(ctx context.Context, method, path string, query url.Values) (*jfsItem, error) {
+	u := escapePath(path)
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	resp, e := j.jfs.Request(ctx, method, u, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	item := &jfsItem{}
+	return item, resp.XML(item)
+}
+
+func (j *Jottacloud) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &jottaEntry{d: j, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := j.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	item, e := j.item(ctx, "GET", path, nil)
+	if e != nil {
+		return nil, e
+	}
+	if !item.available() {
+		return nil, err.NewNotFoundError()
+	}
+	entry := j.newEntry(utils.PathParent(path), *item)
+	_ = j.cache.PutEntry(entry, j.cacheTTL)
+	return entry, nil
+}
+
+// prepareTarget makes sure path can be written, the existing file is deleted if override is true
+func (j *Jottacloud) prepareTarget(ctx types.TaskCtx, path string, override bool, deleteFile bool) error {
+	if e := requireWritable(path); e != nil {
+		return e
+	}
+	existing, e := j.Get(ctx, path)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return nil
+		}
+		return e
+	}
+	if !override || existing.Type().IsDir() {
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	if deleteFile {
+		return j.Delete(ctx, path)
+	}
+	return nil
+}
+
+// Save allocates the upload by the MD5 and the size first, the content is uploaded only if Jottacloud
+// does not have the same content. The file is buffered in a temp file, as the MD5 is required before uploading
+func (j *Jottacloud) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	// the existing file is replaced by a new revision
+	if e := j.prepareTarget(ctx, path, override, false); e != nil {
+		return nil, e
+	}
+	ctx.Total(size, true)
+	h := md5.New()
+	tempFile, e := drive_util.CopyReaderToTempFile(task.NewCtxWrapper(ctx, false, false),
+		io.TeeReader(reader, h), j.tempDir)
+	if e != nil {
+		return nil, e
+	}
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+	stat, e := tempFile.Stat()
+	if e != nil {
+		return nil, e
+	}
+	size = stat.Size()
+	ctx.Total(size, true)
+
+	now := time.Now().Format(time.RFC3339)
+	resp, e := j.api.Post(ctx, "/files/v1/allocate", nil, req.NewJsonBody(allocateRequest{
+		Bytes: size, Created: now, Modified: now,
+		MD5: hex.EncodeToString(h.Sum(nil)), Path: "/" + path,
+	}))
+	if e != nil {
+		return nil, e
+	}
+	ar := allocateResult{}
+	if e := resp.Json(&ar); e != nil {
+		return nil, e
+	}
+	if ar.State != stateCompleted && ar.ResumePos < size {
+		if _, e := tempFile.Seek(ar.ResumePos, io.SeekStart); e != nil {
+			return nil, e
+		}
+		ctx.Progress(ar.ResumePos, true)
+		resp, e := j.uc.Request(ctx, "POST", ar.UploadURL,
+			types.SM{"Range": "bytes=" + strconv.FormatInt(ar.ResumePos, 10) + "-" + strconv.FormatInt(size-1, 10)},
+			&uploadBody{r: drive_util.ProgressReader(tempFile, ctx), size: size - ar.ResumePos})
+		if e != nil {
+			return nil, e
+		}
+		_ = resp.Dispose()
+	}
+	// the content skipped by the deduplication is done as well
+	ctx.Progress(size, true)
+	_ = j.cache.Evict(path, false)
+	_ = j.cache.Evict(utils.PathParent(path), false)
+	return j.Get(ctx, path)
+}
+
+func (j *Jottacloud) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := j.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	if e := requireWritable(path); e != nil {
+		return nil, e
+	}
+	if _, e := j.item(ctx, "POST", path, url.Values{"mkDir": {"true"}}); e != nil {
+		return nil, e
+	}
+	_ = j.cache.Evict(utils.PathParent(path), false)
+	return j.Get(ctx, path)
+}
+
+func (j *Jottacloud) isSelf(e types.IEntry) bool {
+	if je, ok := e.(*jottaEntry); ok {
+		return je.d == j
+	}
+	return false
+}
+
+// transfer copies or moves the entry by JFS, op is 'cp' or 'mv', or 'mvDir' for folders
+func (j *Jottacloud) transfer(ctx types.TaskCtx, op string, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	if e := requireWritable(from.Path()); e != nil {
+		return nil, err.NewUnsupportedError()
+	}
+	if e := j.prepareTarget(ctx, to, override, true); e != nil {
+		return nil, e
+	}
+	ctx.Total(from.Size(), false)
+	_, e := j.item(ctx, "POST", from.Path(), url.Values{op: {j.jfsPath(to)}})
+	_ = j.cache.Evict(to, true)
+	_ = j.cache.Evict(utils.PathParent(to), false)
+	if strings.HasPrefix(op, "mv") {
+		_ = j.cache.Evict(from.Path(), true)
+		_ = j.cache.Evict(utils.PathParent(from.Path()), false)
+	}
+	if e != nil {
+		return nil, e
+	}
+	ctx.Progress(from.Size(), false)
+	return j.Get(ctx, to)
+}
+
+// Copy copies the files by JFS, the folders are copied by the caller
+func (j *Jottacloud) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, j.isSelf)
+	if from == nil || from.Type().IsDir() {
+		return nil, err.NewUnsupportedError()
+	}
+	return j.transfer(ctx, "cp", from, to, override)
+}
+
+func (j *Jottacloud) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, j.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	if from.Type().IsDir() {
+		return j.transfer(ctx, "mvDir", from, to, override)
+	}
+	return j.transfer(ctx, "mv", from, to, override)
+}
+
+// List lists the devices at the root, the mount points in the devices, or the folders and the files
+func (j *Jottacloud) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := j.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	item, e := j.item(ctx, "GET", path, nil)
+	if e != nil {
+		return nil, e
+	}
+	if !item.isDir() {
+		return nil, err.NewNotAllowedError()
+	}
+	entries := make([]types.IEntry, 0)
+	for _, items := range [][]jfsItem{item.Devices, item.MountPoints, item.Folders, item.Files} {
+		for _, i := range items {
+			if i.available() {
+				entries = append(entries, j.newEntry(path, i))
+			}
+		}
+	}
+	_ = j.cache.PutChildren(path, entries, j.cacheTTL)
+	return entries, nil
+}
+
+// Delete moves the file or the folder to the trash of Jottacloud
+func (j *Jottacloud) Delete(ctx types.TaskCtx, path string) error {
+	if e := requireWritable(path); e != nil {
+		return e
+	}
+	entry, e := j.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	op := "dl"
+	if entry.Type().IsDir() {
+		op = "dlDir"
+	}
+	_, e = j.item(ctx, "POST", path, url.Values{op: {"true"}})
+	_ = j.cache.Evict(path, true)
+	_ = j.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+func (j *Jottacloud) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if e := requireWritable(path); e != nil {
+		return nil, e
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, j, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+// Space returns the space of the account, it's unsupported if the capacity is unlimited
+func (j *Jottacloud) Space(ctx context.Context, _ string) (int64, int64, error) {
+	user, e := j.item(ctx, "GET", "", nil)
+	if e != nil {
+		return 0, 0, e
+	}
+	if user.Capacity < 0 {
+		return 0, 0, err.NewUnsupportedError()
+	}
+	return user.Capacity - user.Usage, user.Capacity, nil
+}
+
+func (j *Jottacloud) newEntry(parent string, i jfsItem) *jottaEntry {
+	entry := &jottaEntry{
+		d:       j,
+		path:    path2.Join(parent, i.name()),
+		isDir:   i.isDir(),
+		modTime: parseTime(i.Modified),
+	}
+	if r := i.CurrentRevision; r != nil {
+		entry.size = r.Size
+		entry.modTime = parseTime(r.Modified)
+		entry.md5 = r.MD5
+	}
+	return entry
+}
+
+type jottaEntry struct {
+	d       *Jottacloud
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+	md5     string
+}
+
+func (e *jottaEntry) Path() string {
+	return e.path
+}
+
+func (e *jottaEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *jottaEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+// Meta returns whether the entry can be written, the devices can not be written
+func (e *jottaEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: depth(e.path) >= 2}
+}
+
+func (e *jottaEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *jottaEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *jottaEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *jottaEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+// GetRangeReader downloads the content by JFS, as the content can not be downloaded without the token
+func (e *jottaEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	rangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+	if length >= 0 {
+		if length == 0 {
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		}
+		rangeHeader += strconv.FormatInt(offset+length-1, 10)
+	}
+	resp, ee := e.d.jfs.Get(ctx, escapePath(e.path)+"?mode=bin", types.SM{"Range": rangeHeader})
+	if ee != nil {
+		return nil, ee
+	}
+	return resp.Response().Body, nil
+}
+
+func (e *jottaEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (e *jottaEntry) EntryData() types.SM {
+	return types.SM{"md5": e.md5}
+}
+
+func (e *jottaEntry) ContentHash(context.Context) (string, string, error) {
+	if e.md5 == "" {
+		return "", "", err.NewUnsupportedError()
+	}
+	return "md5", e.md5, nil
+}
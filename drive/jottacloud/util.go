@@ -0,0 +1,179 @@
+package jottacloud
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// decodeLoginToken decodes the personal login token, which is the base64 of the JSON
+func decodeLoginToken(s string) (*loginToken, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	b, e := base64.RawURLEncoding.DecodeString(s)
+	if e != nil {
+		b, e = base64.RawStdEncoding.DecodeString(s)
+	}
+	t := &loginToken{}
+	if e == nil {
+		e = json.Unmarshal(b, t)
+	}
+	if e != nil || t.Username == "" || t.AuthToken == "" || t.WellKnownLink == "" {
+		return nil, err.NewBadRequestError(i18n.T("drive.jottacloud.invalid_login_token"))
+	}
+	return t, nil
+}
+
+func oauthConfig(tokenURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID: clientId,
+		Endpoint: oauth2.Endpoint{TokenURL: tokenURL, AuthStyle: oauth2.AuthStyleInParams},
+		Scopes:   []string{"openid", "offline_access"},
+	}
+}
+
+// login exchanges the login token for the OAuth token,
+// the token endpoint is found by the well-known link in the login token
+func login(ctx context.Context, lt *loginToken) (*oauth2.Token, string, error) {
+	c, e := req.NewClient("", nil, ifApiCallError, nil)
+	if e != nil {
+		return nil, "", e
+	}
+	resp, e := c.Get(ctx, lt.WellKnownLink, nil)
+	if e != nil {
+		return nil, "", e
+	}
+	wk := wellKnownConfig{}
+	if e := resp.Json(&wk); e != nil {
+		return nil, "", e
+	}
+	if wk.TokenEndpoint == "" {
+		return nil, "", err.NewBadRequestError(i18n.T("drive.jottacloud.invalid_login_token"))
+	}
+	t, e := oauthConfig(wk.TokenEndpoint).PasswordCredentialsToken(ctx, lt.Username, lt.AuthToken)
+	if e != nil {
+		return nil, "", err.NewUnauthorizedError(i18n.T("drive.jottacloud.remote_error", e.Error()))
+	}
+	return t, wk.TokenEndpoint, nil
+}
+
+// loadTokenSource loads the saved token, the refreshed tokens are saved as the refresh tokens are rotated
+func loadTokenSource(config drive_util.DriveConfig, ds drive_util.DriveDataStore) (oauth2.TokenSource, error) {
+	resp, e := drive_util.OAuthGet(drive_util.OAuthRequest{}, config, ds)
+	if e != nil {
+		return nil, e
+	}
+	params, e := ds.Load("token_url")
+	if e != nil {
+		return nil, e
+	}
+	ts := oauthConfig(params["token_url"]).TokenSource(context.Background(), resp.Token)
+	return drive_util.PersistentTokenSource(ts, resp.Token, ds), nil
+}
+
+func newClient(baseURL string, ts oauth2.TokenSource) (*req.Client, error) {
+	return req.NewClient(baseURL, func(r *http.Request) error {
+		t, e := ts.Token()
+		if e != nil {
+			return e
+		}
+		t.SetAuthHeader(r)
+		return nil
+	}, ifApiCallError, nil)
+}
+
+func getCustomer(ctx context.Context, c *req.Client) (*customer, error) {
+	resp, e := c.Get(ctx, "/account/v1/customer", nil)
+	if e != nil {
+		return nil, e
+	}
+	cu := &customer{}
+	return cu, resp.Json(cu)
+}
+
+func InitConfig(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (*drive_util.DriveInitConfig, error) {
+	initConfig := &drive_util.DriveInitConfig{}
+	description := ""
+	if ts, e := loadTokenSource(config, driveUtils.Data); e == nil {
+		c, e := newClient(apiURL, ts)
+		if e != nil {
+			return nil, e
+		}
+		cu, e := getCustomer(ctx, c)
+		initConfig.Configured = e == nil
+		if e == nil {
+			description = i18n.T("drive.jottacloud.connected", cu.Email) + " "
+		}
+	}
+	initConfig.Form = []types.FormItem{
+		{
+			Field: "login_token", Label: i18n.T("drive.jottacloud.form.login_token.label"), Type: "password",
+			Description: description + i18n.T("drive.jottacloud.form.login_token.description"),
+		},
+	}
+	return initConfig, nil
+}
+
+// Init logs in by the personal login token, which can be used only once
+func Init(ctx context.Context, data types.SM, _ drive_util.DriveConfig, driveUtils drive_util.DriveUtils) error {
+	if strings.TrimSpace(data["login_token"]) == "" {
+		return nil
+	}
+	lt, e := decodeLoginToken(data["login_token"])
+	if e != nil {
+		return e
+	}
+	t, tokenURL, e := login(ctx, lt)
+	if e != nil {
+		return e
+	}
+	if e := drive_util.SaveOAuthToken(driveUtils.Data, t); e != nil {
+		return e
+	}
+	return driveUtils.Data.Save(types.SM{"token_url": tokenURL})
+}
+
+// escapePath escapes the segments of the path
+func escapePath(path string) string {
+	if utils.IsRootPath(path) {
+		return ""
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// depth returns the depth of the path, the devices are at depth 1 and the mount points are at depth 2
+func depth(path string) int {
+	if utils.IsRootPath(path) {
+		return 0
+	}
+	return strings.Count(path, "/") + 1
+}
+
+func (j *Jottacloud) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	if ec.Data == nil {
+		return nil, errors.New("invalid cache")
+	}
+	return &jottaEntry{
+		d: j, path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+		md5: ec.Data["md5"],
+	}, nil
+}
@@ -0,0 +1,123 @@
+package koofr
+
+import (
+	"bytes"
+	"fmt"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultEndpoint = "https://app.koofr.net"
+
+	typeDir = "dir"
+
+	// the sizes of the spaces of the mounts are in MB
+	spaceUnit = 1024 * 1024
+)
+
+// mount is the storage of Koofr, or the storage of another provider connected to Koofr
+type mount struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	IsPrimary  bool   `json:"isPrimary"`
+	SpaceTotal int64  `json:"spaceTotal"`
+	SpaceUsed  int64  `json:"spaceUsed"`
+}
+
+type mountsResult struct {
+	Mounts []mount `json:"mounts"`
+}
+
+type fileInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Modified    int64  `json:"modified"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType"`
+	Hash        string `json:"hash"`
+}
+
+type filesResult struct {
+	Files []fileInfo `json:"files"`
+}
+
+// pathRequest is the request of copying or moving
+type pathRequest struct {
+	ToMountId string `json:"toMountId"`
+	ToPath    string `json:"toPath"`
+}
+
+type linkResult struct {
+	Link string `json:"link"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	r := errorResponse{}
+	message := resp.Response().Status
+	if e := resp.Json(&r); e == nil && r.Error.Message != "" {
+		message = r.Error.Message
+	}
+	switch resp.Status() {
+	case http.StatusUnauthorized:
+		return err.NewUnauthorizedError(message)
+	case http.StatusForbidden, http.StatusConflict:
+		return err.NewNotAllowedMessageError(message)
+	case http.StatusNotFound:
+		return err.NewNotFoundMessageError(message)
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.koofr.remote_error", message))
+}
+
+// uploadBody is the multipart form of uploading a file, the file is streamed from the reader
+type uploadBody struct {
+	r           io.Reader
+	length      int64
+	contentType string
+}
+
+func newUploadBody(name string, reader io.Reader, size int64) (*uploadBody, error) {
+	head := bytes.Buffer{}
+	mw := multipart.NewWriter(&head)
+	if _, e := mw.CreateFormFile("file", name); e != nil {
+		return nil, e
+	}
+	tail := fmt.Sprintf("\r\n--%s--\r\n", mw.Boundary())
+	length := int64(-1)
+	if size >= 0 {
+		length = int64(head.Len()) + size + int64(len(tail))
+	}
+	return &uploadBody{
+		r:           io.MultiReader(&head, reader, strings.NewReader(tail)),
+		length:      length,
+		contentType: mw.FormDataContentType(),
+	}, nil
+}
+
+func (b *uploadBody) ContentLength() int64 {
+	return b.length
+}
+
+func (b *uploadBody) ContentType() string {
+	return b.contentType
+}
+
+func (b *uploadBody) Reader() io.Reader {
+	return b.r
+}
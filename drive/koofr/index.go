@@ -0,0 +1,392 @@
+package koofr
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"net/http"
+	"net/url"
+	path2 "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "koofr",
+		DisplayName: i18n.T("drive.koofr.name"),
+		README:      i18n.T("drive.koofr.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "endpoint", Label: i18n.T("drive.koofr.form.endpoint.label"), Type: "text", Description: i18n.T("drive.koofr.form.endpoint.description"), DefaultValue: defaultEndpoint},
+			{Field: "username", Label: i18n.T("drive.koofr.form.username.label"), Type: "text", Required: true},
+			{Field: "password", Label: i18n.T("drive.koofr.form.password.label"), Type: "password", Required: true, Description: i18n.T("drive.koofr.form.password.description")},
+			{Field: "mount", Label: i18n.T("drive.koofr.form.mount.label"), Type: "text", Description: i18n.T("drive.koofr.form.mount.description")},
+			{Field: "proxy_download", Label: i18n.T("drive.koofr.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.koofr.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.koofr.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.koofr.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewKoofr},
+	})
+}
+
+// Koofr is the drive of a mount of Koofr, which is the Koofr storage or another provider connected to Koofr.
+// The requests are authorized by the app password, and the files are downloaded by the temporary links.
+type Koofr struct {
+	url      string
+	username string
+	password string
+	mountId  string
+	c        *req.Client
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+
+	downloadProxy bool
+}
+
+func NewKoofr(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	k := &Koofr{
+		url:           strings.TrimSuffix(strings.TrimSpace(config["endpoint"]), "/"),
+		username:      config["username"],
+		password:      config["password"],
+		cacheTTL:      cacheTtl,
+		downloadProxy: config["proxy_download"] != "",
+	}
+	if k.url == "" {
+		k.url = defaultEndpoint
+	}
+	if _, e := url.Parse(k.url); e != nil {
+		return nil, err.NewBadRequestError(i18n.T("drive.koofr.invalid_endpoint"))
+	}
+	if cacheTtl <= 0 {
+		k.cache = drive_util.DummyCache()
+	} else {
+		k.cache = driveUtils.CreateCache(k.deserializeEntry, nil)
+	}
+	if k.c, e = req.NewClient(k.url, k.beforeRequest, ifApiCallError, nil); e != nil {
+		return nil, e
+	}
+	m, e := k.findMount(ctx, strings.TrimSpace(config["mount"]))
+	if e != nil {
+		return nil, e
+	}
+	k.mountId = m.Id
+	return k, nil
+}
+
+func (k *Koofr) beforeRequest(r *http.Request) error {
+	r.SetBasicAuth(k.username, k.password)
+	r.Header.Set("Accept", "application/json")
+	return nil
+}
+
+// findMount finds the mount by name, the primary mount is used if name is empty
+func (k *Koofr) findMount(ctx context.Context, name string) (mount, error) {
+	res := mountsResult{}
+	if e := k.call(ctx, "GET", "/api/v2.1/mounts", nil, nil, &res); e != nil {
+		return mount{}, e
+	}
+	for _, m := range res.Mounts {
+		if (name == "" && m.IsPrimary) || (name != "" && m.Name == name) {
+			return m, nil
+		}
+	}
+	return mount{}, err.NewNotFoundMessageError(i18n.T("drive.koofr.mount_not_found", name))
+}
+
+// call calls the API, and decodes the result to res
+func (k *Koofr) call(ctx context.Context, method, path string, query url.Values,
+	body req.RequestBody, res interface{}) error {
+	if query != nil {
+		path += "?" + query.Encode()
+	}
+	resp, e := k.c.Request(ctx, method, path, nil, body)
+	if e != nil {
+		return e
+	}
+	if res == nil {
+		return resp.Dispose()
+	}
+	return resp.Json(res)
+}
+
+// filesAPI returns the path of the API of the files of the mount
+func (k *Koofr) filesAPI(op string) string {
+	return utils.BuildURL("/api/v2.1/mounts/{}/files/", k.mountId) + op
+}
+
+// remotePath returns the path in the mount, which starts with '/'
+func remotePath(path string) url.Values {
+	return url.Values{"path": {"/" + path}}
+}
+
+func (k *Koofr) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (k *Koofr) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &koofrEntry{d: k, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := k.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	info := fileInfo{}
+	if e := k.call(ctx, "GET", k.filesAPI("info"), remotePath(path), nil, &info); e != nil {
+		return nil, e
+	}
+	entry := k.newEntry(utils.PathParent(path), info)
+	_ = k.cache.PutEntry(entry, k.cacheTTL)
+	return entry, nil
+}
+
+func (k *Koofr) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return nil, err.NewNotAllowedError()
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, k, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(size, true)
+	name := utils.PathBase(path)
+	body, e := newUploadBody(name, drive_util.ProgressReader(reader, ctx), size)
+	if e != nil {
+		return nil, e
+	}
+	query := remotePath(utils.PathParent(path))
+	query.Set("filename", name)
+	query.Set("info", "true")
+	query.Set("autorename", "false")
+	query.Set("overwrite", strconv.FormatBool(override))
+	info := fileInfo{}
+	e = k.call(ctx, "POST", "/content"+k.filesAPI("put"), query, body, &info)
+	_ = k.cache.Evict(path, false)
+	_ = k.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	// the name is changed if the file exists when autorename is not supported
+	if info.Name != name {
+		return k.Get(ctx, path)
+	}
+	return k.newEntry(utils.PathParent(path), info), nil
+}
+
+func (k *Koofr) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := k.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	if e := k.call(ctx, "POST", k.filesAPI("folder"), remotePath(utils.PathParent(path)),
+		req.NewJsonBody(types.SM{"name": utils.PathBase(path)}), nil); e != nil {
+		return nil, e
+	}
+	_ = k.cache.Evict(utils.PathParent(path), false)
+	return k.Get(ctx, path)
+}
+
+func (k *Koofr) isSelf(e types.IEntry) bool {
+	if ke, ok := e.(*koofrEntry); ok {
+		return ke.d == k
+	}
+	return false
+}
+
+// transfer copies or moves the entry on Koofr, the existing target is deleted if override
+func (k *Koofr) transfer(ctx types.TaskCtx, op string, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, k.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) || utils.IsRootPath(to) {
+		return nil, err.NewUnsupportedError()
+	}
+	if _, e := k.Get(ctx, to); e == nil {
+		if !override {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		if e := k.Delete(ctx, to); e != nil {
+			return nil, e
+		}
+	} else if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	ctx.Total(from.Size(), false)
+	e := k.call(ctx, "PUT", k.filesAPI(op), remotePath(from.Path()),
+		req.NewJsonBody(pathRequest{ToMountId: k.mountId, ToPath: "/" + to}), nil)
+	_ = k.cache.Evict(to, true)
+	_ = k.cache.Evict(utils.PathParent(to), false)
+	if op == "move" {
+		_ = k.cache.Evict(from.Path(), true)
+		_ = k.cache.Evict(utils.PathParent(from.Path()), false)
+	}
+	if e != nil {
+		return nil, e
+	}
+	ctx.Progress(from.Size(), false)
+	return k.Get(ctx, to)
+}
+
+func (k *Koofr) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	return k.transfer(ctx, "copy", from, to, override)
+}
+
+func (k *Koofr) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	return k.transfer(ctx, "move", from, to, override)
+}
+
+func (k *Koofr) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := k.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	res := filesResult{}
+	if e := k.call(ctx, "GET", k.filesAPI("list"), remotePath(path), nil, &res); e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, 0, len(res.Files))
+	for _, f := range res.Files {
+		entries = append(entries, k.newEntry(path, f))
+	}
+	_ = k.cache.PutChildren(path, entries, k.cacheTTL)
+	return entries, nil
+}
+
+func (k *Koofr) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	if e := k.call(ctx, "DELETE", k.filesAPI("remove"), remotePath(path), nil, nil); e != nil {
+		return e
+	}
+	_ = k.cache.Evict(path, true)
+	_ = k.cache.Evict(utils.PathParent(path), false)
+	return nil
+}
+
+func (k *Koofr) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, k, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+// Space returns the space of the mount
+func (k *Koofr) Space(ctx context.Context, _ string) (int64, int64, error) {
+	res := mount{}
+	if e := k.call(ctx, "GET", utils.BuildURL("/api/v2.1/mounts/{}", k.mountId), nil, nil, &res); e != nil {
+		return 0, 0, e
+	}
+	return (res.SpaceTotal - res.SpaceUsed) * spaceUnit, res.SpaceTotal * spaceUnit, nil
+}
+
+func (k *Koofr) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	return &koofrEntry{
+		d: k, path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+		hash: ec.Data["hash"],
+	}, nil
+}
+
+func (k *Koofr) newEntry(parent string, f fileInfo) *koofrEntry {
+	return &koofrEntry{
+		d:       k,
+		path:    path2.Join(parent, f.Name),
+		isDir:   f.Type == typeDir,
+		size:    f.Size,
+		modTime: f.Modified,
+		hash:    f.Hash,
+	}
+}
+
+type koofrEntry struct {
+	d       *Koofr
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+	// hash is the MD5 of the content
+	hash string
+}
+
+func (e *koofrEntry) Path() string {
+	return e.path
+}
+
+func (e *koofrEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *koofrEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *koofrEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *koofrEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *koofrEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *koofrEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *koofrEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	u, ee := e.GetURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	return drive_util.GetURL(ctx, u.URL, nil)
+}
+
+// GetURL returns the temporary download link of the file, which is accessible without authorization
+func (e *koofrEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	res := linkResult{}
+	if ee := e.d.call(ctx, "GET", e.d.filesAPI("download"), remotePath(e.path), nil, &res); ee != nil {
+		return nil, ee
+	}
+	return &types.ContentURL{URL: res.Link, Proxy: e.d.downloadProxy}, nil
+}
+
+func (e *koofrEntry) EntryData() types.SM {
+	return types.SM{"hash": e.hash}
+}
+
+func (e *koofrEntry) ContentHash(context.Context) (string, string, error) {
+	if e.hash == "" {
+		return "", "", err.NewUnsupportedError()
+	}
+	return "md5", e.hash, nil
+}
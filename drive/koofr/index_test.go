@@ -0,0 +1,127 @@
+package koofr
+
+import (
+	"bytes"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestServer(t *testing.T, uploaded *bytes.Buffer) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "me@example.com" || p != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":"Unauthorized","message":"Unauthorized"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		path := r.URL.Query().Get("path")
+		switch r.URL.Path {
+		case "/api/v2.1/mounts":
+			_, _ = w.Write([]byte(`{"mounts":[{"id":"m1","name":"Koofr","isPrimary":true,"spaceTotal":10,"spaceUsed":4},` +
+				`{"id":"m2","name":"Dropbox","isPrimary":false}]}`))
+		case "/api/v2.1/mounts/m1":
+			_, _ = w.Write([]byte(`{"id":"m1","name":"Koofr","isPrimary":true,"spaceTotal":10,"spaceUsed":4}`))
+		case "/api/v2.1/mounts/m1/files/list":
+			if path != "/a b" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":{"code":"NotFound","message":"Not found"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"files":[{"name":"sub","type":"dir","modified":1600000001000,"size":0},` +
+				`{"name":"x.txt","type":"file","modified":1600000002000,"size":5,"hash":"5d41402abc4b2a76b9719d911017c592"}]}`))
+		case "/api/v2.1/mounts/m1/files/info":
+			if path != "/a b/x.txt" || uploaded.Len() == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":{"code":"NotFound","message":"Not found"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"name":"x.txt","type":"file","modified":1600000002000,"size":5}`))
+		case "/content/api/v2.1/mounts/m1/files/put":
+			q := r.URL.Query()
+			if path != "/a b" || q.Get("filename") != "x.txt" || q.Get("overwrite") != "false" {
+				t.Errorf("unexpected upload query: %v", q)
+			}
+			_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			form, e := multipart.NewReader(r.Body, params["boundary"]).ReadForm(1024)
+			if e != nil {
+				t.Fatal(e)
+			}
+			f, _ := form.File["file"][0].Open()
+			b, _ := ioutil.ReadAll(f)
+			uploaded.Write(b)
+			_, _ = w.Write([]byte(`{"name":"x.txt","type":"file","modified":1600000002000,"size":5}`))
+		case "/api/v2.1/mounts/m1/files/download":
+			_, _ = w.Write([]byte(`{"link":"https://app.koofr.net/content/links/xyz/x.txt"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestKoofr(t *testing.T) {
+	uploaded := &bytes.Buffer{}
+	server := newTestServer(t, uploaded)
+	defer server.Close()
+
+	config := drive_util.DriveConfig{"endpoint": server.URL + "/", "username": "me@example.com", "password": "wrong"}
+	if _, e := NewKoofr(context.Background(), config, drive_util.DriveUtils{}); e == nil {
+		t.Errorf("expect error of the wrong password, got %v", e)
+	}
+	config["password"] = "secret"
+	config["mount"] = "Box"
+	if _, e := NewKoofr(context.Background(), config, drive_util.DriveUtils{}); !err.IsNotFoundError(e) {
+		t.Errorf("expect error of the mount not found, got %v", e)
+	}
+	config["mount"] = ""
+	d, e := NewKoofr(context.Background(), config, drive_util.DriveUtils{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	ctx := task.DummyContext()
+
+	entries, e := d.List(ctx, "a b")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 2 || entries[0].Path() != "a b/sub" || !entries[0].Type().IsDir() ||
+		entries[1].Size() != 5 || entries[1].ModTime() != 1600000002000 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if alg, hash, _ := entries[1].(*koofrEntry).ContentHash(ctx); alg != "md5" || hash != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("unexpected hash: %s %s", alg, hash)
+	}
+	if _, e := d.List(ctx, "b"); !err.IsNotFoundError(e) {
+		t.Errorf("expect error of the dir not found, got %v", e)
+	}
+
+	entry, e := d.Save(ctx, "a b/x.txt", 5, false, bytes.NewReader([]byte("hello")))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if uploaded.String() != "hello" || entry.Path() != "a b/x.txt" || entry.Size() != 5 {
+		t.Errorf("unexpected uploaded file: %s, %v", uploaded.String(), entry)
+	}
+	if _, e := d.Save(ctx, "a b/x.txt", 5, false, bytes.NewReader([]byte("hello"))); !err.IsNotAllowedError(e) {
+		t.Errorf("expect error of the file exists, got %v", e)
+	}
+	if _, e := d.Move(ctx, entry, "a b/x.txt", false); !err.IsNotAllowedError(e) {
+		t.Errorf("expect error of the file exists, got %v", e)
+	}
+
+	u, e := entry.(*koofrEntry).GetURL(ctx)
+	if e != nil || u.URL != "https://app.koofr.net/content/links/xyz/x.txt" {
+		t.Errorf("unexpected url: %v, %v", u, e)
+	}
+	free, total, e := d.(*Koofr).Space(ctx, "")
+	if e != nil || free != 6*spaceUnit || total != 10*spaceUnit {
+		t.Errorf("unexpected space: %d, %d, %v", free, total, e)
+	}
+}
@@ -0,0 +1,269 @@
+package mega
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const apiURL = "https://g.api.mega.co.nz/cs"
+
+// the error codes of the API
+const (
+	eArgs      = -2
+	eAgain     = -3
+	eRateLimit = -4
+	eNotFound  = -9
+	eAccess    = -11
+	eExist     = -12
+	eSid       = -15
+	eOverQuota = -17
+)
+
+// node is a file or a folder in the response of the command 'f'
+type node struct {
+	Handle string `json:"h"`
+	Parent string `json:"p"`
+	User   string `json:"u"`
+	// Type is 0 for files, 1 for folders, 2 for the root, 3 for the inbox, 4 for the rubbish bin
+	Type      int    `json:"t"`
+	Attrs     string `json:"a"`
+	Key       string `json:"k"`
+	Size      int64  `json:"s"`
+	Timestamp int64  `json:"ts"`
+}
+
+const (
+	nodeFile   = 0
+	nodeFolder = 1
+	nodeRoot   = 2
+)
+
+type preLoginResult struct {
+	Version int    `json:"v"`
+	Salt    string `json:"s"`
+}
+
+type loginResult struct {
+	Key   string `json:"k"`
+	Csid  string `json:"csid"`
+	Privk string `json:"privk"`
+	Tsid  string `json:"tsid"`
+	User  string `json:"u"`
+}
+
+type filesResult struct {
+	Files []node `json:"f"`
+}
+
+type downloadResult struct {
+	URL  string `json:"g"`
+	Size int64  `json:"s"`
+}
+
+type uploadResult struct {
+	URL string `json:"p"`
+}
+
+type apiError int
+
+func (a apiError) Error() string {
+	return "MEGA API error " + strconv.Itoa(int(a))
+}
+
+// client calls the MEGA API, the session is logged in again if it expired
+type client struct {
+	email    string
+	password string
+
+	c *req.Client
+	// seq is the sequence number of the requests
+	seq int64
+
+	mux       sync.Mutex
+	sid       string
+	masterKey []byte
+	userId    string
+}
+
+func newClient(email, password string) (*client, error) {
+	c, e := req.NewClient("", nil, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	return &client{email: email, password: password, c: c, seq: rand.Int63()}, nil
+}
+
+func (c *client) login(ctx context.Context) error {
+	pre := preLoginResult{}
+	if e := c.request(ctx, "", types.M{"a": "us0", "user": c.email}, &pre); e != nil {
+		return e
+	}
+	var pkey []byte
+	var uh string
+	if pre.Version == 2 {
+		salt, e := base64Decode(pre.Salt)
+		if e != nil {
+			return e
+		}
+		pkey, uh = passwordKeyV2(c.password, salt)
+	} else {
+		pkey = passwordKeyV1(c.password)
+		uh = userHashV1(c.email, pkey)
+	}
+	res := loginResult{}
+	if e := c.request(ctx, "", types.M{"a": "us", "user": c.email, "uh": uh}, &res); e != nil {
+		if ae, ok := e.(apiError); ok && (ae == eNotFound || ae == eArgs) {
+			return err.NewUnauthorizedError(i18n.T("drive.mega.invalid_login"))
+		}
+		return e
+	}
+	encKey, e := base64Decode(res.Key)
+	if e != nil {
+		return e
+	}
+	masterKey, e := decryptECB(pkey, encKey)
+	if e != nil {
+		return e
+	}
+	sid := res.Tsid
+	if sid == "" {
+		if sid, e = decryptSid(masterKey, res.Privk, res.Csid); e != nil {
+			return e
+		}
+	}
+	user := res.User
+	if user == "" {
+		ug := loginResult{}
+		if e := c.request(ctx, sid, types.M{"a": "ug"}, &ug); e != nil {
+			return e
+		}
+		user = ug.User
+	}
+	c.sid, c.masterKey, c.userId = sid, masterKey, user
+	return nil
+}
+
+// session returns the session, it's logged in if not yet
+func (c *client) session(ctx context.Context, relogin bool) (string, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.sid == "" || relogin {
+		if e := c.login(ctx); e != nil {
+			return "", e
+		}
+	}
+	return c.sid, nil
+}
+
+// account returns the master key and the user handle of the account
+func (c *client) account(ctx context.Context) ([]byte, string, error) {
+	if _, e := c.session(ctx, false); e != nil {
+		return nil, "", e
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.masterKey, c.userId, nil
+}
+
+// call calls the command in the session, and decodes the result to res if it's not nil
+func (c *client) call(ctx context.Context, command types.M, res interface{}) error {
+	for retried := false; ; retried = true {
+		sid, e := c.session(ctx, false)
+		if e != nil {
+			return e
+		}
+		e = c.request(ctx, sid, command, res)
+		if !retried && e == apiError(eSid) {
+			if _, e := c.session(ctx, true); e != nil {
+				return e
+			}
+			continue
+		}
+		return toDriveError(e)
+	}
+}
+
+// request sends the command, it's retried with backoff if the server is busy
+func (c *client) request(ctx context.Context, sid string, command types.M, res interface{}) error {
+	backoff := 250 * time.Millisecond
+	for i := 0; ; i++ {
+		e := c.requestOnce(ctx, sid, command, res)
+		if (e != apiError(eAgain) && e != apiError(eRateLimit)) || i >= 6 {
+			return e
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *client) requestOnce(ctx context.Context, sid string, command types.M, res interface{}) error {
+	params := url.Values{"id": {strconv.FormatInt(atomic.AddInt64(&c.seq, 1), 10)}}
+	if sid != "" {
+		params.Set("sid", sid)
+	}
+	resp, e := c.c.Post(ctx, apiURL+"?"+params.Encode(), nil, req.NewJsonBody([]types.M{command}))
+	if e != nil {
+		return e
+	}
+	if resp.Status() != 200 {
+		_ = resp.Dispose()
+		return err.NewRemoteApiError(500, i18n.T("drive.mega.remote_error", resp.Response().Status))
+	}
+	// the response is an error code, or the array of the results
+	var results []json.RawMessage
+	if e := resp.Json(&results); e != nil {
+		var code int
+		if resp.Json(&code) == nil {
+			return apiError(code)
+		}
+		return e
+	}
+	if len(results) == 0 {
+		return errors.New("empty response")
+	}
+	var code int
+	if json.Unmarshal(results[0], &code) == nil {
+		if code < 0 {
+			return apiError(code)
+		}
+		return nil
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(results[0], res)
+}
+
+// toDriveError converts the errors of the API to the errors of the drive
+func toDriveError(e error) error {
+	ae, ok := e.(apiError)
+	if !ok {
+		return e
+	}
+	switch ae {
+	case eNotFound:
+		return err.NewNotFoundError()
+	case eExist:
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	case eAccess:
+		return err.NewNotAllowedError()
+	case eOverQuota:
+		return err.NewNotAllowedMessageError(i18n.T("drive.mega.over_quota"))
+	}
+	return err.NewRemoteApiError(500, i18n.T("drive.mega.remote_error", ae.Error()))
+}
@@ -0,0 +1,320 @@
+package mega
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/pbkdf2"
+	"math/big"
+	"strings"
+)
+
+// the crypto of MEGA, see https://mega.nz/doc
+
+var errInvalidKey = errors.New("invalid key")
+
+func base64Decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func base64Encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// padZero pads b with zeros to the multiple of n
+func padZero(b []byte, n int) []byte {
+	if len(b)%n == 0 {
+		return b
+	}
+	return append(b, make([]byte, n-len(b)%n)...)
+}
+
+func encryptECB(key, data []byte) ([]byte, error) {
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, e
+	}
+	if len(data)%aes.BlockSize != 0 {
+		return nil, errInvalidKey
+	}
+	out := make([]byte, len(data))
+	for i := 0; i < len(data); i += aes.BlockSize {
+		block.Encrypt(out[i:], data[i:])
+	}
+	return out, nil
+}
+
+func decryptECB(key, data []byte) ([]byte, error) {
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, e
+	}
+	if len(data)%aes.BlockSize != 0 {
+		return nil, errInvalidKey
+	}
+	out := make([]byte, len(data))
+	for i := 0; i < len(data); i += aes.BlockSize {
+		block.Decrypt(out[i:], data[i:])
+	}
+	return out, nil
+}
+
+// passwordKeyV1 derives the key from the password for the accounts of version 1
+func passwordKeyV1(password string) []byte {
+	pw := padZero([]byte(password), aes.BlockSize)
+	pkey := []byte{0x93, 0xC4, 0x67, 0xE3, 0x7D, 0xB0, 0xC7, 0xA4, 0xD1, 0xBE, 0x3F, 0x81, 0x01, 0x52, 0xCB, 0x56}
+	blocks := make([]cipher.Block, 0, len(pw)/aes.BlockSize)
+	for i := 0; i < len(pw); i += aes.BlockSize {
+		block, _ := aes.NewCipher(pw[i : i+aes.BlockSize])
+		blocks = append(blocks, block)
+	}
+	for r := 0; r < 0x10000; r++ {
+		for _, block := range blocks {
+			block.Encrypt(pkey, pkey)
+		}
+	}
+	return pkey
+}
+
+// userHashV1 returns the hash of the email to login the accounts of version 1
+func userHashV1(email string, pkey []byte) string {
+	s := padZero([]byte(strings.ToLower(email)), 4)
+	h := make([]byte, aes.BlockSize)
+	for i := 0; i < len(s); i++ {
+		h[i%aes.BlockSize] ^= s[i]
+	}
+	block, _ := aes.NewCipher(pkey)
+	for r := 0; r < 0x4000; r++ {
+		block.Encrypt(h, h)
+	}
+	return base64Encode(append(h[0:4:4], h[8:12]...))
+}
+
+// passwordKeyV2 derives the key and the user hash from the password for the accounts of version 2
+func passwordKeyV2(password string, salt []byte) ([]byte, string) {
+	derived := pbkdf2.Key([]byte(password), salt, 100000, 32, sha512.New)
+	return derived[:16], base64Encode(derived[16:])
+}
+
+// readMPI reads a multi-precision integer prefixed by the length in bits, and returns the rest
+func readMPI(b []byte) (*big.Int, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, errInvalidKey
+	}
+	n := (int(b[0])<<8 | int(b[1]) + 7) / 8
+	if len(b) < n+2 {
+		return nil, nil, errInvalidKey
+	}
+	return new(big.Int).SetBytes(b[2 : n+2]), b[n+2:], nil
+}
+
+// decryptSid decrypts the session id by the RSA private key,
+// privk is the key encrypted by the master key, csid is the encrypted session id
+func decryptSid(masterKey []byte, privk, csid string) (string, error) {
+	encKey, e := base64Decode(privk)
+	if e != nil {
+		return "", e
+	}
+	key, e := decryptECB(masterKey, padZero(encKey, aes.BlockSize))
+	if e != nil {
+		return "", e
+	}
+	// p, q, d, u
+	parts := make([]*big.Int, 4)
+	for i := range parts {
+		if parts[i], key, e = readMPI(key); e != nil {
+			return "", e
+		}
+	}
+	encSid, e := base64Decode(csid)
+	if e != nil {
+		return "", e
+	}
+	m, _, e := readMPI(encSid)
+	if e != nil {
+		return "", e
+	}
+	n := new(big.Int).Mul(parts[0], parts[1])
+	sid := new(big.Int).Exp(m, parts[2], n).Bytes()
+	if len(sid) < 43 {
+		return "", errInvalidKey
+	}
+	return base64Encode(sid[:43]), nil
+}
+
+// splitFileKey splits the key of a file to the AES key, the nonce of CTR, and the meta MAC
+func splitFileKey(k []byte) (key, nonce, metaMAC []byte) {
+	key = make([]byte, 16)
+	for i := range key {
+		key[i] = k[i] ^ k[i+16]
+	}
+	return key, k[16:24], k[24:32]
+}
+
+// packFileKey is the reverse of splitFileKey
+func packFileKey(key, nonce, metaMAC []byte) []byte {
+	k := make([]byte, 32)
+	copy(k[16:], nonce)
+	copy(k[24:], metaMAC)
+	for i := 0; i < 16; i++ {
+		k[i] = key[i] ^ k[i+16]
+	}
+	return k
+}
+
+type attrs struct {
+	Name string `json:"n"`
+}
+
+func decryptAttrs(key []byte, s string) (*attrs, error) {
+	enc, e := base64Decode(s)
+	if e != nil {
+		return nil, e
+	}
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, e
+	}
+	if len(enc)%aes.BlockSize != 0 {
+		return nil, errInvalidKey
+	}
+	dat := make([]byte, len(enc))
+	cipher.NewCBCDecrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(dat, enc)
+	if !bytes.HasPrefix(dat, []byte("MEGA{")) {
+		return nil, errInvalidKey
+	}
+	a := &attrs{}
+	if e := json.Unmarshal(bytes.TrimRight(dat[4:], "\x00"), a); e != nil {
+		return nil, e
+	}
+	return a, nil
+}
+
+func encryptAttrs(key []byte, a attrs) (string, error) {
+	j, e := json.Marshal(a)
+	if e != nil {
+		return "", e
+	}
+	dat := padZero(append([]byte("MEGA"), j...), aes.BlockSize)
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return "", e
+	}
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(dat, dat)
+	return base64Encode(dat), nil
+}
+
+// newCTR returns the stream to encrypt or decrypt the content of a file from offset
+func newCTR(key, nonce []byte, offset int64) (cipher.Stream, error) {
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, e
+	}
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, nonce)
+	binary.BigEndian.PutUint64(iv[8:], uint64(offset/aes.BlockSize))
+	s := cipher.NewCTR(block, iv)
+	if skip := offset % aes.BlockSize; skip > 0 {
+		discard := make([]byte, skip)
+		s.XORKeyStream(discard, discard)
+	}
+	return s, nil
+}
+
+// macWriter computes the MAC of the content of a file,
+// the content is split to chunks, the chunks are 128K, 256K, ... 1M, then 1M each
+type macWriter struct {
+	block cipher.Block
+	nonce []byte
+
+	fileMAC   []byte
+	chunkMAC  []byte
+	chunkSize int
+	chunkPos  int
+	chunks    int
+
+	buf []byte
+}
+
+func newMACWriter(key, nonce []byte) (*macWriter, error) {
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, e
+	}
+	m := &macWriter{block: block, nonce: nonce, fileMAC: make([]byte, aes.BlockSize)}
+	m.nextChunk()
+	return m, nil
+}
+
+func (m *macWriter) nextChunk() {
+	m.chunkMAC = append(append(make([]byte, 0, aes.BlockSize), m.nonce...), m.nonce...)
+	m.chunkPos = 0
+	if m.chunks < 8 {
+		m.chunks++
+	}
+	m.chunkSize = m.chunks * 128 * 1024
+}
+
+func (m *macWriter) writeBlock(b []byte) {
+	for i := range m.chunkMAC {
+		m.chunkMAC[i] ^= b[i]
+	}
+	m.block.Encrypt(m.chunkMAC, m.chunkMAC)
+	m.chunkPos += aes.BlockSize
+	if m.chunkPos >= m.chunkSize {
+		m.endChunk()
+		m.nextChunk()
+	}
+}
+
+func (m *macWriter) endChunk() {
+	for i := range m.fileMAC {
+		m.fileMAC[i] ^= m.chunkMAC[i]
+	}
+	m.block.Encrypt(m.fileMAC, m.fileMAC)
+}
+
+func (m *macWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(m.buf) > 0 {
+		c := aes.BlockSize - len(m.buf)
+		if c > len(p) {
+			c = len(p)
+		}
+		m.buf = append(m.buf, p[:c]...)
+		p = p[c:]
+		if len(m.buf) < aes.BlockSize {
+			return n, nil
+		}
+		m.writeBlock(m.buf)
+		m.buf = m.buf[:0]
+	}
+	for ; len(p) >= aes.BlockSize; p = p[aes.BlockSize:] {
+		m.writeBlock(p[:aes.BlockSize])
+	}
+	m.buf = append(m.buf, p...)
+	return n, nil
+}
+
+// MetaMAC returns the condensed MAC of the content written
+func (m *macWriter) MetaMAC() []byte {
+	if len(m.buf) > 0 {
+		m.writeBlock(padZero(m.buf, aes.BlockSize))
+		m.buf = m.buf[:0]
+	}
+	if m.chunkPos > 0 {
+		m.endChunk()
+		m.nextChunk()
+	}
+	mac := make([]byte, 8)
+	for i := 0; i < 4; i++ {
+		mac[i] = m.fileMAC[i] ^ m.fileMAC[i+4]
+		mac[i+4] = m.fileMAC[i+8] ^ m.fileMAC[i+12]
+	}
+	return mac
+}
@@ -0,0 +1,81 @@
+package mega
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMegaAttrs(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	enc, e := encryptAttrs(key, attrs{Name: "文件.txt"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	a, e := decryptAttrs(key, enc)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if a.Name != "文件.txt" {
+		t.Errorf("unexpected name: %s", a.Name)
+	}
+	if _, e := decryptAttrs([]byte("fedcba9876543210"), enc); e == nil {
+		t.Errorf("expected error when decrypting with the wrong key")
+	}
+}
+
+func TestMegaFileKey(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	nonce := []byte("nonce123")
+	mac := []byte("macmac12")
+	k, n, m := splitFileKey(packFileKey(key, nonce, mac))
+	if !bytes.Equal(k, key) || !bytes.Equal(n, nonce) || !bytes.Equal(m, mac) {
+		t.Errorf("unexpected key: %x %x %x", k, n, m)
+	}
+}
+
+func TestMegaCTROffset(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	nonce := []byte("nonce123")
+	data := bytes.Repeat([]byte("go-drive"), 100)
+	s, _ := newCTR(key, nonce, 0)
+	enc := make([]byte, len(data))
+	s.XORKeyStream(enc, data)
+
+	for _, offset := range []int64{0, 1, 15, 16, 17, 333} {
+		s, _ := newCTR(key, nonce, offset)
+		dec := make([]byte, len(enc)-int(offset))
+		s.XORKeyStream(dec, enc[offset:])
+		if !bytes.Equal(dec, data[offset:]) {
+			t.Errorf("unexpected content from offset %d", offset)
+		}
+	}
+}
+
+func TestMegaMAC(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	nonce := []byte("nonce123")
+	// crosses the chunks of 128K and 256K, and ends with a partial block
+	data := bytes.Repeat([]byte("0123456"), 60000)
+
+	whole, _ := newMACWriter(key, nonce)
+	_, _ = whole.Write(data)
+	expected := whole.MetaMAC()
+
+	pieces, _ := newMACWriter(key, nonce)
+	for i := 0; i < len(data); i += 1000 {
+		end := i + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		_, _ = pieces.Write(data[i:end])
+	}
+	if mac := pieces.MetaMAC(); !bytes.Equal(mac, expected) {
+		t.Errorf("unexpected MAC %x, expected %x", mac, expected)
+	}
+
+	other, _ := newMACWriter(key, nonce)
+	_, _ = other.Write(data[1:])
+	if bytes.Equal(other.MetaMAC(), expected) {
+		t.Errorf("expected different MAC for different content")
+	}
+}
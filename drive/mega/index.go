@@ -0,0 +1,641 @@
+package mega
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	path2 "path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "mega",
+		DisplayName: i18n.T("drive.mega.name"),
+		README:      i18n.T("drive.mega.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "email", Label: i18n.T("drive.mega.form.email.label"), Type: "text", Required: true},
+			{Field: "password", Label: i18n.T("drive.mega.form.password.label"), Type: "password", Required: true},
+			{Field: "cache_ttl", Label: i18n.T("drive.mega.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.mega.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewMega},
+	})
+}
+
+// defaultCacheTTL is how long the tree of the nodes is used if cache_ttl is not set
+const defaultCacheTTL = time.Minute
+
+// Mega is the drive of MEGA, the names and the contents of the nodes are encrypted on the client side.
+// The whole tree of the nodes is loaded, and decrypted in memory.
+type Mega struct {
+	c *client
+
+	cacheTTL time.Duration
+	tempDir  string
+
+	mux      sync.Mutex
+	root     *megaNode
+	nodes    map[string]*megaNode
+	loadedAt time.Time
+}
+
+// megaNode is a decrypted node
+type megaNode struct {
+	handle  string
+	parent  *megaNode
+	isDir   bool
+	name    string
+	size    int64
+	modTime int64
+	// key is the decrypted key of the node, 32 bytes for files, 16 bytes for folders
+	key []byte
+
+	children map[string]*megaNode
+}
+
+// attrKey returns the key to encrypt the attributes and the content
+func (n *megaNode) attrKey() []byte {
+	if n.isDir {
+		return n.key
+	}
+	key, _, _ := splitFileKey(n.key)
+	return key
+}
+
+func NewMega(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	c, e := newClient(config["email"], config["password"])
+	if e != nil {
+		return nil, e
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil || cacheTtl <= 0 {
+		cacheTtl = defaultCacheTTL
+	}
+	m := &Mega{c: c, cacheTTL: cacheTtl, tempDir: driveUtils.Config.TempDir}
+	if _, e := m.tree(ctx); e != nil {
+		return nil, e
+	}
+	return m, nil
+}
+
+func (m *Mega) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+// tree returns the root node, the tree is reloaded if it's expired
+func (m *Mega) tree(ctx context.Context) (*megaNode, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.root != nil && time.Since(m.loadedAt) < m.cacheTTL {
+		return m.root, nil
+	}
+	masterKey, userId, e := m.c.account(ctx)
+	if e != nil {
+		return nil, e
+	}
+	res := filesResult{}
+	if e := m.c.call(ctx, types.M{"a": "f", "c": 1}, &res); e != nil {
+		return nil, e
+	}
+	m.root, m.nodes = nil, make(map[string]*megaNode, len(res.Files))
+	parents := make(map[string]string, len(res.Files))
+	for _, f := range res.Files {
+		if f.Type == nodeRoot {
+			m.root = &megaNode{handle: f.Handle, isDir: true, modTime: -1, children: make(map[string]*megaNode)}
+			m.nodes[f.Handle] = m.root
+			continue
+		}
+		// the nodes shared to the account can't be decrypted by the master key, they are skipped
+		if n := decryptNode(f, masterKey, userId); n != nil {
+			m.nodes[f.Handle] = n
+			parents[f.Handle] = f.Parent
+		}
+	}
+	if m.root == nil {
+		return nil, err.NewNotFoundMessageError(i18n.T("drive.mega.root_not_found"))
+	}
+	for h, p := range parents {
+		if parent, ok := m.nodes[p]; ok && parent.isDir {
+			m.link(m.nodes[h], parent)
+		}
+	}
+	m.loadedAt = time.Now()
+	return m.root, nil
+}
+
+func decryptNode(f node, masterKey []byte, userId string) *megaNode {
+	if f.Type != nodeFile && f.Type != nodeFolder {
+		return nil
+	}
+	// the key is 'handle:key', or multiple of them separated by '/'
+	var encKey string
+	for _, k := range strings.Split(f.Key, "/") {
+		if i := strings.Index(k, ":"); i > 0 && k[:i] == userId {
+			encKey = k[i+1:]
+			break
+		}
+	}
+	if encKey == "" {
+		return nil
+	}
+	enc, e := base64Decode(encKey)
+	if e != nil {
+		return nil
+	}
+	key, e := decryptECB(masterKey, enc)
+	if e != nil {
+		return nil
+	}
+	n := &megaNode{
+		handle:  f.Handle,
+		isDir:   f.Type == nodeFolder,
+		size:    f.Size,
+		modTime: f.Timestamp * 1000,
+		key:     key,
+	}
+	if n.isDir {
+		if len(key) != 16 {
+			return nil
+		}
+		n.children = make(map[string]*megaNode)
+	} else if len(key) != 32 {
+		return nil
+	}
+	a, e := decryptAttrs(n.attrKey(), f.Attrs)
+	if e != nil {
+		return nil
+	}
+	n.name = a.Name
+	return n
+}
+
+func (m *Mega) link(n, parent *megaNode) {
+	n.parent = parent
+	parent.children[n.name] = n
+}
+
+func (m *Mega) unlink(n *megaNode) {
+	if n.parent != nil && n.parent.children[n.name] == n {
+		delete(n.parent.children, n.name)
+	}
+	n.parent = nil
+}
+
+// find finds the node of the path
+func (m *Mega) find(ctx context.Context, path string) (*megaNode, error) {
+	root, e := m.tree(ctx)
+	if e != nil {
+		return nil, e
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	n := root
+	if utils.IsRootPath(path) {
+		return n, nil
+	}
+	for _, name := range strings.Split(path, "/") {
+		if !n.isDir {
+			return nil, err.NewNotFoundError()
+		}
+		if n = n.children[name]; n == nil {
+			return nil, err.NewNotFoundError()
+		}
+	}
+	return n, nil
+}
+
+// findParent finds the parent folder of the path, and the existing node of the path
+func (m *Mega) findParent(ctx context.Context, path string) (*megaNode, *megaNode, error) {
+	parent, e := m.find(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, nil, e
+	}
+	if !parent.isDir {
+		return nil, nil, err.NewNotAllowedError()
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return parent, parent.children[utils.PathBase(path)], nil
+}
+
+// putNode creates the node in the parent, and adds it to the tree
+func (m *Mega) putNode(ctx context.Context, parent *megaNode, handle string, isDir bool, name string, key []byte) (*megaNode, error) {
+	masterKey, userId, e := m.c.account(ctx)
+	if e != nil {
+		return nil, e
+	}
+	n := &megaNode{isDir: isDir, name: name, key: key}
+	encAttrs, e := encryptAttrs(n.attrKey(), attrs{Name: name})
+	if e != nil {
+		return nil, e
+	}
+	encKey, e := encryptECB(masterKey, key)
+	if e != nil {
+		return nil, e
+	}
+	t := nodeFile
+	if isDir {
+		t = nodeFolder
+	}
+	res := filesResult{}
+	if e := m.c.call(ctx, types.M{
+		"a": "p", "t": parent.handle,
+		"n": []types.M{{"h": handle, "t": t, "a": encAttrs, "k": base64Encode(encKey)}},
+	}, &res); e != nil {
+		return nil, e
+	}
+	if len(res.Files) == 0 {
+		return nil, err.NewNotFoundError()
+	}
+	n = decryptNode(res.Files[0], masterKey, userId)
+	if n == nil {
+		return nil, err.NewNotFoundError()
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.nodes[n.handle] = n
+	m.link(n, parent)
+	return n, nil
+}
+
+func (m *Mega) deleteNode(ctx context.Context, n *megaNode) error {
+	if e := m.c.call(ctx, types.M{"a": "d", "n": n.handle}, nil); e != nil {
+		return e
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.unlink(n)
+	delete(m.nodes, n.handle)
+	return nil
+}
+
+func (m *Mega) Get(ctx context.Context, path string) (types.IEntry, error) {
+	n, e := m.find(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	return m.newEntry(path, n), nil
+}
+
+func (m *Mega) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	parent, existing, e := m.findParent(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if existing != nil && (!override || existing.isDir) {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	if size < 0 {
+		// the size must be known before uploading
+		file, e := drive_util.CopyReaderToTempFile(task.NewCtxWrapper(ctx, false, false), reader, m.tempDir)
+		if e != nil {
+			return nil, e
+		}
+		defer func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}()
+		stat, e := file.Stat()
+		if e != nil {
+			return nil, e
+		}
+		size = stat.Size()
+		reader = file
+	}
+	ctx.Total(size, true)
+	key, e := m.upload(ctx, size, reader)
+	if e != nil {
+		return nil, e
+	}
+	n, e := m.putNode(ctx, parent, key.handle, false, utils.PathBase(path), key.key)
+	if e != nil {
+		return nil, e
+	}
+	if existing != nil {
+		if e := m.deleteNode(ctx, existing); e != nil {
+			return nil, e
+		}
+	}
+	return m.newEntry(path, n), nil
+}
+
+type uploaded struct {
+	// handle is the completion handle of the upload
+	handle string
+	key    []byte
+}
+
+// upload encrypts and uploads the content, the MAC of the content is packed into the key of the file
+func (m *Mega) upload(ctx types.TaskCtx, size int64, reader io.Reader) (*uploaded, error) {
+	u := uploadResult{}
+	if e := m.c.call(ctx, types.M{"a": "u", "s": size, "ssl": 1}, &u); e != nil {
+		return nil, e
+	}
+	random := make([]byte, 24)
+	if _, e := rand.Read(random); e != nil {
+		return nil, e
+	}
+	key, nonce := random[:16], random[16:]
+	stream, e := newCTR(key, nonce, 0)
+	if e != nil {
+		return nil, e
+	}
+	mac, e := newMACWriter(key, nonce)
+	if e != nil {
+		return nil, e
+	}
+	body := cipher.StreamReader{S: stream, R: io.TeeReader(drive_util.ProgressReader(reader, ctx), mac)}
+	resp, e := m.c.c.Post(ctx, u.URL+"/0", nil, req.NewReaderBody(body, size))
+	if e != nil {
+		return nil, e
+	}
+	defer func() { _ = resp.Dispose() }()
+	dat, e := ioutil.ReadAll(resp.Response().Body)
+	if e != nil {
+		return nil, e
+	}
+	handle := string(dat)
+	if resp.Status() != 200 || handle == "" {
+		return nil, err.NewRemoteApiError(500, i18n.T("drive.mega.remote_error", resp.Response().Status))
+	}
+	if code, e := strconv.Atoi(handle); e == nil {
+		return nil, toDriveError(apiError(code))
+	}
+	return &uploaded{handle: handle, key: packFileKey(key, nonce, mac.MetaMAC())}, nil
+}
+
+func (m *Mega) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	parent, existing, e := m.findParent(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if existing != nil {
+		if !existing.isDir {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return m.newEntry(path, existing), nil
+	}
+	key := make([]byte, 16)
+	if _, e := rand.Read(key); e != nil {
+		return nil, e
+	}
+	n, e := m.putNode(ctx, parent, "xxxxxxxx", true, utils.PathBase(path), key)
+	if e != nil {
+		return nil, e
+	}
+	return m.newEntry(path, n), nil
+}
+
+func (m *Mega) isSelf(e types.IEntry) bool {
+	if me, ok := e.(*megaEntry); ok {
+		return me.d == m
+	}
+	return false
+}
+
+// prepareTarget finds the source node and the parent of the target, the existing target is deleted if override
+func (m *Mega) prepareTarget(ctx types.TaskCtx, from types.IEntry, to string, override bool) (*megaNode, *megaNode, error) {
+	from = drive_util.GetIEntry(from, m.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, nil, err.NewUnsupportedError()
+	}
+	n, e := m.find(ctx, from.Path())
+	if e != nil {
+		return nil, nil, e
+	}
+	parent, existing, e := m.findParent(ctx, to)
+	if e != nil {
+		return nil, nil, e
+	}
+	if existing == n {
+		return nil, nil, err.NewNotAllowedError()
+	}
+	if existing != nil {
+		if !override {
+			return nil, nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		if e := m.deleteNode(ctx, existing); e != nil {
+			return nil, nil, e
+		}
+	}
+	return n, parent, nil
+}
+
+// Copy copies files by creating nodes of the uploaded contents, folders are not supported
+func (m *Mega) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	if from.Type().IsDir() {
+		return nil, err.NewUnsupportedError()
+	}
+	n, parent, e := m.prepareTarget(ctx, from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	ctx.Total(n.size, false)
+	copied, e := m.putNode(ctx, parent, n.handle, false, utils.PathBase(to), n.key)
+	if e != nil {
+		return nil, e
+	}
+	ctx.Progress(n.size, false)
+	return m.newEntry(to, copied), nil
+}
+
+func (m *Mega) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	n, parent, e := m.prepareTarget(ctx, from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	if n.parent != parent {
+		if e := m.c.call(ctx, types.M{"a": "m", "n": n.handle, "t": parent.handle}, nil); e != nil {
+			return nil, e
+		}
+	}
+	name := utils.PathBase(to)
+	if n.name != name {
+		if e := m.rename(ctx, n, name); e != nil {
+			return nil, e
+		}
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.unlink(n)
+	n.name = name
+	m.link(n, parent)
+	return m.newEntry(to, n), nil
+}
+
+// rename sets the attributes of the node with the new name
+func (m *Mega) rename(ctx context.Context, n *megaNode, name string) error {
+	masterKey, _, e := m.c.account(ctx)
+	if e != nil {
+		return e
+	}
+	encAttrs, e := encryptAttrs(n.attrKey(), attrs{Name: name})
+	if e != nil {
+		return e
+	}
+	encKey, e := encryptECB(masterKey, n.key)
+	if e != nil {
+		return e
+	}
+	return m.c.call(ctx, types.M{"a": "a", "n": n.handle, "attr": encAttrs, "key": base64Encode(encKey)}, nil)
+}
+
+func (m *Mega) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	n, e := m.find(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if !n.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	entries := make([]types.IEntry, 0, len(n.children))
+	for name, c := range n.children {
+		entries = append(entries, m.newEntry(path2.Join(path, name), c))
+	}
+	return entries, nil
+}
+
+func (m *Mega) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	n, e := m.find(ctx, path)
+	if e != nil {
+		return e
+	}
+	return m.deleteNode(ctx, n)
+}
+
+func (m *Mega) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, m, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (m *Mega) newEntry(path string, n *megaNode) *megaEntry {
+	return &megaEntry{
+		d: m, path: path, handle: n.handle,
+		isDir: n.isDir, size: n.size, modTime: n.modTime, key: n.key,
+	}
+}
+
+type megaEntry struct {
+	d       *Mega
+	path    string
+	handle  string
+	isDir   bool
+	size    int64
+	modTime int64
+	key     []byte
+}
+
+func (e *megaEntry) Path() string {
+	return e.path
+}
+
+func (e *megaEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *megaEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *megaEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *megaEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *megaEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *megaEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *megaEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+// GetRangeReader downloads the encrypted content, and decrypts it from offset
+func (e *megaEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	if length == 0 || offset >= e.size {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	res := downloadResult{}
+	if ee := e.d.c.call(ctx, types.M{"a": "g", "g": 1, "n": e.handle, "ssl": 1}, &res); ee != nil {
+		return nil, ee
+	}
+	rangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+	if length > 0 {
+		rangeHeader += strconv.FormatInt(offset+length-1, 10)
+	}
+	resp, ee := e.d.c.c.Get(ctx, res.URL, types.SM{"Range": rangeHeader})
+	if ee != nil {
+		return nil, ee
+	}
+	body := resp.Response().Body
+	if resp.Status() != 200 && resp.Status() != 206 {
+		_ = body.Close()
+		return nil, err.NewRemoteApiError(500, i18n.T("drive.mega.remote_error", resp.Response().Status))
+	}
+	key, nonce, _ := splitFileKey(e.key)
+	stream, ee := newCTR(key, nonce, offset)
+	if ee != nil {
+		_ = body.Close()
+		return nil, ee
+	}
+	return &decryptReader{StreamReader: cipher.StreamReader{S: stream, R: body}, c: body}, nil
+}
+
+func (e *megaEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	// the content is encrypted, it can only be read through the server
+	return nil, err.NewUnsupportedError()
+}
+
+func (e *megaEntry) StableID() string {
+	return e.handle
+}
+
+type decryptReader struct {
+	cipher.StreamReader
+	c io.Closer
+}
+
+func (d *decryptReader) Close() error {
+	return d.c.Close()
+}
@@ -0,0 +1,407 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "memory",
+		DisplayName: i18n.T("drive.memory.name"),
+		README:      i18n.T("drive.memory.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "max_size", Label: i18n.T("drive.memory.form.max_size.label"), Type: "text", Required: true, Description: i18n.T("drive.memory.form.max_size.description"), DefaultValue: "64M"},
+		},
+		Factory: drive_util.DriveFactory{Create: NewMemoryDrive},
+	})
+}
+
+// MemoryDrive holds the tree in memory, the content is lost when the drive is reloaded.
+// The contents of the files are never modified in place, so the entries read the snapshots without locking.
+type MemoryDrive struct {
+	mu   sync.RWMutex
+	root *memNode
+	// maxSize is the max total size of the files, used is the current total size
+	maxSize int64
+	used    int64
+}
+
+type memNode struct {
+	dir      bool
+	data     []byte
+	modTime  int64
+	children map[string]*memNode
+}
+
+func NewMemoryDrive(_ context.Context, config drive_util.DriveConfig,
+	_ drive_util.DriveUtils) (types.IDrive, error) {
+	maxSize, e := utils.ParseBytes(config["max_size"])
+	if e != nil || maxSize == 0 {
+		return nil, err.NewBadRequestError(i18n.T("drive.memory.invalid_max_size", config["max_size"]))
+	}
+	return New(int64(maxSize)), nil
+}
+
+// New creates an empty MemoryDrive, the total size of the files is limited to maxSize
+func New(maxSize int64) *MemoryDrive {
+	return &MemoryDrive{root: newDirNode(), maxSize: maxSize}
+}
+
+func newDirNode() *memNode {
+	return &memNode{dir: true, modTime: utils.Millisecond(time.Now()), children: make(map[string]*memNode)}
+}
+
+// size returns the total size of the files of the node
+func (n *memNode) size() int64 {
+	if !n.dir {
+		return int64(len(n.data))
+	}
+	total := int64(0)
+	for _, c := range n.children {
+		total += c.size()
+	}
+	return total
+}
+
+// clone copies the tree of the node, the contents are shared as they are never modified
+func (n *memNode) clone(modTime int64) *memNode {
+	c := &memNode{dir: n.dir, data: n.data, modTime: modTime}
+	if n.dir {
+		c.children = make(map[string]*memNode, len(n.children))
+		for name, child := range n.children {
+			c.children[name] = child.clone(modTime)
+		}
+	}
+	return c
+}
+
+// find returns the node of path, or nil if not exists
+func (d *MemoryDrive) find(path string) *memNode {
+	n := d.root
+	if utils.IsRootPath(path) {
+		return n
+	}
+	for _, name := range strings.Split(path, "/") {
+		if !n.dir {
+			return nil
+		}
+		if n = n.children[name]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// parentDir returns the node of the parent directory of path
+func (d *MemoryDrive) parentDir(path string) (*memNode, error) {
+	if utils.IsRootPath(path) {
+		return nil, err.NewNotAllowedError()
+	}
+	parent := d.find(utils.PathParent(path))
+	if parent == nil {
+		return nil, err.NewNotFoundError()
+	}
+	if !parent.dir {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	return parent, nil
+}
+
+// reserve checks whether the total size is within the limit after size bytes are added
+func (d *MemoryDrive) reserve(size int64) error {
+	if d.used+size > d.maxSize {
+		return err.NewQuotaExceededError(i18n.T("drive.memory.no_space", utils.FormatBytes(uint64(d.maxSize), 1)))
+	}
+	return nil
+}
+
+func (d *MemoryDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *MemoryDrive) Get(_ context.Context, path string) (types.IEntry, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	n := d.find(path)
+	if n == nil {
+		return nil, err.NewNotFoundError()
+	}
+	return d.newEntry(path, n), nil
+}
+
+func (d *MemoryDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if size > d.maxSize {
+		return nil, d.reserve(size)
+	}
+	ctx.Total(size, true)
+	// the content is read without locking, the limit is checked again when it's saved
+	data, e := ioutil.ReadAll(io.LimitReader(drive_util.ProgressReader(reader, ctx), d.maxSize+1))
+	if e != nil {
+		return nil, e
+	}
+	if int64(len(data)) > d.maxSize {
+		return nil, d.reserve(int64(len(data)))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	parent, e := d.parentDir(path)
+	if e != nil {
+		return nil, e
+	}
+	name := utils.PathBase(path)
+	old := parent.children[name]
+	if old != nil && (!override || old.dir) {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	oldSize := int64(0)
+	if old != nil {
+		oldSize = old.size()
+	}
+	if e := d.reserve(int64(len(data)) - oldSize); e != nil {
+		return nil, e
+	}
+	n := &memNode{data: data, modTime: utils.Millisecond(time.Now())}
+	parent.children[name] = n
+	d.used += int64(len(data)) - oldSize
+	return d.newEntry(path, n), nil
+}
+
+func (d *MemoryDrive) MakeDir(_ context.Context, path string) (types.IEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n := d.find(path); n != nil {
+		if !n.dir {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return d.newEntry(path, n), nil
+	}
+	parent, e := d.parentDir(path)
+	if e != nil {
+		return nil, e
+	}
+	n := newDirNode()
+	parent.children[utils.PathBase(path)] = n
+	return d.newEntry(path, n), nil
+}
+
+func (d *MemoryDrive) isSelf(entry types.IEntry) bool {
+	if me, ok := entry.(*memEntry); ok {
+		return me.d == d
+	}
+	return false
+}
+
+// prepareTarget checks the source and the target of copying or moving,
+// the target is removed if it exists and override is true
+func (d *MemoryDrive) prepareTarget(from, to string, override bool, added int64) (*memNode, *memNode, error) {
+	if utils.IsRootPath(from) || to == from ||
+		strings.HasPrefix(to, from+"/") || strings.HasPrefix(from, to+"/") {
+		return nil, nil, err.NewNotAllowedError()
+	}
+	src := d.find(from)
+	if src == nil {
+		return nil, nil, err.NewNotFoundError()
+	}
+	parent, e := d.parentDir(to)
+	if e != nil {
+		return nil, nil, e
+	}
+	old := parent.children[utils.PathBase(to)]
+	removed := int64(0)
+	if old != nil {
+		if !override {
+			return nil, nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		removed = old.size()
+	}
+	if e := d.reserve(added - removed); e != nil {
+		return nil, nil, e
+	}
+	d.used -= removed
+	return src, parent, nil
+}
+
+func (d *MemoryDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := d.find(from.Path())
+	if n == nil {
+		return nil, err.NewNotFoundError()
+	}
+	size := n.size()
+	ctx.Total(size, true)
+	src, parent, e := d.prepareTarget(from.Path(), to, override, size)
+	if e != nil {
+		return nil, e
+	}
+	copied := src.clone(utils.Millisecond(time.Now()))
+	parent.children[utils.PathBase(to)] = copied
+	d.used += size
+	ctx.Progress(size, false)
+	return d.newEntry(to, copied), nil
+}
+
+func (d *MemoryDrive) Move(_ types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	src, parent, e := d.prepareTarget(from.Path(), to, override, 0)
+	if e != nil {
+		return nil, e
+	}
+	delete(d.find(utils.PathParent(from.Path())).children, utils.PathBase(from.Path()))
+	parent.children[utils.PathBase(to)] = src
+	return d.newEntry(to, src), nil
+}
+
+func (d *MemoryDrive) List(_ context.Context, path string) ([]types.IEntry, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	n := d.find(path)
+	if n == nil {
+		return nil, err.NewNotFoundError()
+	}
+	if !n.dir {
+		return nil, err.NewNotAllowedError()
+	}
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]types.IEntry, len(names))
+	for i, name := range names {
+		entries[i] = d.newEntry(utils.CleanPath(path+"/"+name), n.children[name])
+	}
+	return entries, nil
+}
+
+func (d *MemoryDrive) Delete(_ types.TaskCtx, path string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	parent, e := d.parentDir(path)
+	if e != nil {
+		return e
+	}
+	n := parent.children[utils.PathBase(path)]
+	if n == nil {
+		return err.NewNotFoundError()
+	}
+	delete(parent.children, utils.PathBase(path))
+	d.used -= n.size()
+	return nil
+}
+
+func (d *MemoryDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *MemoryDrive) Space(context.Context, string) (int64, int64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.maxSize - d.used, d.maxSize, nil
+}
+
+func (d *MemoryDrive) Dispose() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.root = newDirNode()
+	d.used = 0
+	return nil
+}
+
+func (d *MemoryDrive) newEntry(path string, n *memNode) *memEntry {
+	return &memEntry{d: d, path: path, dir: n.dir, data: n.data, modTime: n.modTime}
+}
+
+type memEntry struct {
+	d       *MemoryDrive
+	path    string
+	dir     bool
+	data    []byte
+	modTime int64
+}
+
+func (e *memEntry) Path() string {
+	return e.path
+}
+
+func (e *memEntry) Type() types.EntryType {
+	if e.dir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *memEntry) Size() int64 {
+	if e.dir {
+		return -1
+	}
+	return int64(len(e.data))
+}
+
+func (e *memEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *memEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *memEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *memEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *memEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+func (e *memEntry) GetRangeReader(_ context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.dir {
+		return nil, err.NewNotAllowedError()
+	}
+	size := int64(len(e.data))
+	if offset > size {
+		offset = size
+	}
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	return ioutil.NopCloser(bytes.NewReader(e.data[offset:end])), nil
+}
+
+func (e *memEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
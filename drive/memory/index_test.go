@@ -0,0 +1,144 @@
+package memory
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func save(t *testing.T, d *MemoryDrive, path, content string) types.IEntry {
+	t.Helper()
+	entry, e := d.Save(task.DummyContext(), path, int64(len(content)), true, strings.NewReader(content))
+	if e != nil {
+		t.Fatal(e)
+	}
+	return entry
+}
+
+func read(t *testing.T, d *MemoryDrive, path string) string {
+	t.Helper()
+	entry, e := d.Get(context.Background(), path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	r, e := entry.(types.IContent).GetReader(context.Background())
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = r.Close() }()
+	b, e := ioutil.ReadAll(r)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return string(b)
+}
+
+func TestMemoryTree(t *testing.T) {
+	ctx := task.DummyContext()
+	d := New(1024)
+	if _, e := d.MakeDir(ctx, "a/b"); !err.IsNotFoundError(e) {
+		t.Errorf("expected not found, got %v", e)
+	}
+	if _, e := d.MakeDir(ctx, "a"); e != nil {
+		t.Fatal(e)
+	}
+	save(t, d, "a/1.txt", "hello")
+	save(t, d, "a/2.txt", "world")
+	if _, e := d.Save(ctx, "a/1.txt", 1, false, strings.NewReader("x")); !err.IsNotAllowedError(e) {
+		t.Errorf("expected not allowed, got %v", e)
+	}
+	if _, e := d.Save(ctx, "a/1.txt/x", 1, true, strings.NewReader("x")); !err.IsNotAllowedError(e) {
+		t.Errorf("expected not allowed, got %v", e)
+	}
+
+	entries, e := d.List(ctx, "a")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 2 || entries[0].Path() != "a/1.txt" || entries[1].Path() != "a/2.txt" {
+		t.Errorf("unexpected entries: %v", entries)
+	}
+
+	a, _ := d.Get(ctx, "a")
+	if _, e := d.Copy(ctx, a, "b", false); e != nil {
+		t.Fatal(e)
+	}
+	save(t, d, "a/1.txt", "changed")
+	if s := read(t, d, "b/1.txt"); s != "hello" {
+		t.Errorf("expected the copy unchanged, got '%s'", s)
+	}
+	if _, e := d.Copy(ctx, a, "a/c", false); !err.IsNotAllowedError(e) {
+		t.Errorf("expected not allowed to copy into itself, got %v", e)
+	}
+
+	b, _ := d.Get(ctx, "b")
+	if _, e := d.Move(ctx, b, "a", false); !err.IsNotAllowedError(e) {
+		t.Errorf("expected not allowed, got %v", e)
+	}
+	if _, e := d.Move(ctx, b, "c", false); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := d.Get(ctx, "b"); !err.IsNotFoundError(e) {
+		t.Errorf("expected b moved, got %v", e)
+	}
+	if s := read(t, d, "c/2.txt"); s != "world" {
+		t.Errorf("unexpected content '%s'", s)
+	}
+
+	// 'changed', 'world' in a and 'hello', 'world' in c
+	if free, _, _ := d.Space(ctx, ""); free != 1024-22 {
+		t.Errorf("unexpected free space %d", free)
+	}
+	if e := d.Delete(ctx, "a"); e != nil {
+		t.Fatal(e)
+	}
+	if free, _, _ := d.Space(ctx, ""); free != 1024-10 {
+		t.Errorf("unexpected free space %d", free)
+	}
+	if e := d.Delete(ctx, ""); !err.IsNotAllowedError(e) {
+		t.Errorf("expected not allowed to delete the root, got %v", e)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	ctx := task.DummyContext()
+	d := New(10)
+	save(t, d, "a", "12345678")
+	if _, e := d.Save(ctx, "b", 3, true, strings.NewReader("123")); !err.IsQuotaExceededError(e) {
+		t.Errorf("expected quota exceeded, got %v", e)
+	}
+	// the size is unknown
+	if _, e := d.Save(ctx, "b", -1, true, strings.NewReader(strings.Repeat("x", 11))); !err.IsQuotaExceededError(e) {
+		t.Errorf("expected quota exceeded, got %v", e)
+	}
+	// replacing the file frees its space
+	save(t, d, "a", "1234567890")
+	a, _ := d.Get(ctx, "a")
+	if _, e := d.Copy(ctx, a, "b", false); !err.IsQuotaExceededError(e) {
+		t.Errorf("expected quota exceeded, got %v", e)
+	}
+	if _, e := d.Move(ctx, a, "b", false); e != nil {
+		t.Fatal(e)
+	}
+	if free, _, _ := d.Space(ctx, ""); free != 0 {
+		t.Errorf("unexpected free space %d", free)
+	}
+}
+
+func TestMemoryRangeReader(t *testing.T) {
+	d := New(1024)
+	entry := save(t, d, "a", "0123456789")
+	r, e := drive_util.GetIContentRangeReader(context.Background(), entry.(types.IContent), 3, 4)
+	if e != nil {
+		t.Fatal(e)
+	}
+	b, _ := ioutil.ReadAll(r)
+	if string(b) != "3456" {
+		t.Errorf("unexpected content '%s'", b)
+	}
+}
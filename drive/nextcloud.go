@@ -0,0 +1,661 @@
+package drive
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/google/uuid"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "nextcloud",
+		DisplayName: i18n.T("drive.nextcloud.name"),
+		README:      i18n.T("drive.nextcloud.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "url", Label: i18n.T("drive.nextcloud.form.url.label"), Type: "text", Required: true, Description: i18n.T("drive.nextcloud.form.url.description")},
+			{Field: "username", Label: i18n.T("drive.nextcloud.form.username.label"), Type: "text", Required: true},
+			{Field: "password", Label: i18n.T("drive.nextcloud.form.password.label"), Type: "password", Required: true, Description: i18n.T("drive.nextcloud.form.password.description")},
+			{Field: "chunk_size", Label: i18n.T("drive.nextcloud.form.chunk_size.label"), Type: "text", Description: i18n.T("drive.nextcloud.form.chunk_size.description"), DefaultValue: "10M"},
+			{Field: "cache_ttl", Label: i18n.T("drive.webdav.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.webdav.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewNextcloudDrive},
+	})
+}
+
+const (
+	nextcloudChunkSize = 10 * 1024 * 1024
+	// the chunks except the last one must be between 5MB and 5GB, and there are at most 10000 chunks
+	nextcloudMinChunkSize = 5 * 1024 * 1024
+	nextcloudMaxChunkSize = 5 * 1024 * 1024 * 1024
+	nextcloudMaxChunks    = 10000
+
+	// nextcloudTrashDir and nextcloudVersionsDir are the virtual directories in the root
+	nextcloudTrashDir    = ".trash"
+	nextcloudVersionsDir = ".versions"
+)
+
+const nextcloudPropfindBody = `<?xml version="1.0" encoding="utf-8"?>` +
+	`<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns" xmlns:nc="http://nextcloud.org/ns"><d:prop>` +
+	`<d:getlastmodified/><d:getcontentlength/><d:resourcetype/><oc:fileid/>` +
+	`<nc:trashbin-filename/><nc:trashbin-original-location/><nc:trashbin-deletion-time/>` +
+	`</d:prop></d:propfind>`
+
+// NextcloudDrive is the WebDAV drive of the files of a Nextcloud user, with the Nextcloud specific capabilities:
+// large files are uploaded by chunks, public links are created by the OCS API,
+// and the trash bin and the versions of files are exposed as the virtual directories '.trash' and '.versions'.
+type NextcloudDrive struct {
+	*WebDAVDrive
+	username  string
+	chunkSize int64
+
+	// dav is the client of the DAV root, for the uploads, the trash bin and the versions
+	dav       *req.Client
+	davPrefix string
+	ocs       *req.Client
+}
+
+// NewNextcloudDrive creates a Nextcloud drive
+func NewNextcloudDrive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	base := strings.TrimSuffix(strings.TrimSpace(config["url"]), "/")
+	username := strings.TrimSpace(config["username"])
+	if username == "" {
+		return nil, err.NewBadRequestError(i18n.T("drive.nextcloud.invalid_username"))
+	}
+	chunkSize := int64(nextcloudChunkSize)
+	if v := strings.TrimSpace(config["chunk_size"]); v != "" {
+		size, e := utils.ParseBytes(v)
+		if e != nil || size < nextcloudMinChunkSize || size > nextcloudMaxChunkSize {
+			return nil, err.NewBadRequestError(i18n.T("drive.nextcloud.invalid_chunk_size", v))
+		}
+		chunkSize = int64(size)
+	}
+	baseURL, e := url.Parse(base)
+	if e != nil {
+		return nil, e
+	}
+	d, e := NewWebDAVDrive(ctx, drive_util.DriveConfig{
+		"url":       base + "/remote.php/dav/files/" + url.PathEscape(username),
+		"username":  username,
+		"password":  config["password"],
+		"cache_ttl": config["cache_ttl"],
+	}, driveUtils)
+	if e != nil {
+		return nil, e
+	}
+	w := d.(*WebDAVDrive)
+	n := &NextcloudDrive{
+		WebDAVDrive: w,
+		username:    username,
+		chunkSize:   chunkSize,
+		davPrefix:   baseURL.Path + "/remote.php/dav",
+	}
+	n.dav, e = req.NewClient(base+"/remote.php/dav", w.beforeRequest, w.afterRequest, nil)
+	if e != nil {
+		return nil, e
+	}
+	n.ocs, e = req.NewClient(base, w.beforeRequest, n.afterOCSRequest, nil)
+	if e != nil {
+		return nil, e
+	}
+	return n, nil
+}
+
+// virtualPath returns the name of the virtual directory and the path in it, root is empty if path is not virtual
+func virtualPath(path string) (root string, rest string) {
+	for _, dir := range []string{nextcloudTrashDir, nextcloudVersionsDir} {
+		if path == dir {
+			return dir, ""
+		}
+		if strings.HasPrefix(path, dir+"/") {
+			return dir, path[len(dir)+1:]
+		}
+	}
+	return "", ""
+}
+
+func (n *NextcloudDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	root, rest := virtualPath(path)
+	switch root {
+	case nextcloudTrashDir:
+		if rest == "" {
+			return n.newVirtualEntry(path), nil
+		}
+		res, e := n.propfind(ctx, n.dav, n.trashPath(rest), "0")
+		if e != nil {
+			return nil, e
+		}
+		if len(res) == 0 {
+			return nil, err.NewNotFoundError()
+		}
+		return n.newTrashEntry(res[0]), nil
+	case nextcloudVersionsDir:
+		return n.getVersionsEntry(ctx, path, rest)
+	}
+	return n.WebDAVDrive.Get(ctx, path)
+}
+
+// getVersionsEntry gets the entry in '.versions', which mirrors the directories,
+// and the files are the directories of their versions.
+func (n *NextcloudDrive) getVersionsEntry(ctx context.Context, path, rest string) (types.IEntry, error) {
+	if rest == "" {
+		return n.newVirtualEntry(path), nil
+	}
+	_, e := n.WebDAVDrive.Get(ctx, rest)
+	if e == nil {
+		return n.newVirtualEntry(path), nil
+	}
+	if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	versions, e := n.listVersions(ctx, utils.PathParent(rest))
+	if e != nil {
+		return nil, e
+	}
+	for _, v := range versions {
+		if v.Path() == path {
+			return v, nil
+		}
+	}
+	return nil, err.NewNotFoundError()
+}
+
+func (n *NextcloudDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if root, _ := virtualPath(path); root != "" {
+		return nil, err.NewNotAllowedError()
+	}
+	if size <= n.chunkSize {
+		return n.WebDAVDrive.Save(ctx, path, size, override, reader)
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, n, path); e != nil {
+			return nil, e
+		}
+	}
+	if e := n.chunkedUpload(ctx, path, size, override, drive_util.ProgressReader(reader, ctx)); e != nil {
+		return nil, e
+	}
+	_ = n.cache.Evict(utils.PathParent(path), false)
+	_ = n.cache.Evict(path, false)
+	return n.Get(ctx, path)
+}
+
+// chunkedUpload uploads the file by the chunked upload v2,
+// the chunks are uploaded to a temporary directory, then assembled to the destination by moving '.file'.
+func (n *NextcloudDrive) chunkedUpload(ctx types.TaskCtx, path string, size int64, override bool, reader io.Reader) error {
+	chunkSize := n.chunkSize
+	if (size+chunkSize-1)/chunkSize > nextcloudMaxChunks {
+		chunkSize = (size + nextcloudMaxChunks - 1) / nextcloudMaxChunks
+	}
+	dest, e := n.c.BuildURL(utils.BuildURL("{}", path))
+	if e != nil {
+		return e
+	}
+	dir := utils.BuildURL("uploads/{}/{}", n.username, uuid.New().String())
+	header := types.SM{"Destination": dest, "OC-Total-Length": strconv.FormatInt(size, 10)}
+	resp, e := n.dav.Request(ctx, "MKCOL", dir, header, nil)
+	if e != nil {
+		return e
+	}
+	_ = resp.Dispose()
+	ok := false
+	defer func() {
+		if ok {
+			return
+		}
+		// the upload directory is removed by the server in 24 hours anyway
+		if resp, e := n.dav.Request(context.Background(), "DELETE", dir, nil, nil); e == nil {
+			_ = resp.Dispose()
+		}
+	}()
+	for i, offset := 1, int64(0); offset < size; i++ {
+		if e := ctx.Err(); e != nil {
+			return e
+		}
+		length := chunkSize
+		if size-offset < length {
+			length = size - offset
+		}
+		resp, e := n.dav.Request(ctx, "PUT", fmt.Sprintf("%s/%05d", dir, i), header,
+			req.NewReaderBody(io.LimitReader(reader, length), length))
+		if e != nil {
+			return e
+		}
+		_ = resp.Dispose()
+		offset += length
+	}
+	header["Overwrite"] = "F"
+	if override {
+		header["Overwrite"] = "T"
+	}
+	resp, e = n.dav.Request(ctx, "MOVE", dir+"/.file", header, nil)
+	if e != nil {
+		if e == errorPreconditionFailed {
+			return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return e
+	}
+	_ = resp.Dispose()
+	ok = true
+	return nil
+}
+
+func (n *NextcloudDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if root, _ := virtualPath(path); root != "" {
+		return nil, err.NewNotAllowedError()
+	}
+	return n.WebDAVDrive.MakeDir(ctx, path)
+}
+
+func (n *NextcloudDrive) isSelf(e types.IEntry) bool {
+	if ne, ok := e.(*nextcloudEntry); ok {
+		return ne.d == n
+	}
+	return false
+}
+
+// Copy restores the version if a version is copied to its file, the other virtual entries are copied by reading
+func (n *NextcloudDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	if root, _ := virtualPath(to); root != "" {
+		return nil, err.NewNotAllowedError()
+	}
+	if ne, ok := drive_util.GetIEntry(from, n.isSelf).(*nextcloudEntry); ok {
+		if ne.kind != nextcloudVersion || ne.target != to || !override {
+			return nil, err.NewUnsupportedError()
+		}
+		if e := n.davMove(ctx, ne.davPath, utils.BuildURL("versions/{}/restore/target", n.username), true); e != nil {
+			return nil, e
+		}
+		_ = n.cache.Evict(to, false)
+		_ = n.cache.Evict(utils.PathParent(to), false)
+		return n.Get(ctx, to)
+	}
+	return n.WebDAVDrive.Copy(ctx, from, to, override)
+}
+
+// Move restores the item in the trash bin to its original location, then moves it to the destination
+func (n *NextcloudDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	if root, _ := virtualPath(to); root != "" {
+		return nil, err.NewNotAllowedError()
+	}
+	ne, ok := drive_util.GetIEntry(from, n.isSelf).(*nextcloudEntry)
+	if !ok {
+		return n.WebDAVDrive.Move(ctx, from, to, override)
+	}
+	// only the top-level items of the trash bin can be restored
+	if ne.kind != nextcloudTrash || utils.PathDepth(ne.path) != 2 {
+		return nil, err.NewNotAllowedError()
+	}
+	if ne.target != to && !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, n, to); e != nil {
+			return nil, e
+		}
+	}
+	if e := n.davMove(ctx, ne.davPath,
+		utils.BuildURL("trashbin/{}/restore/{}", n.username, utils.PathBase(ne.davPath)), false); e != nil {
+		return nil, e
+	}
+	_ = n.cache.Evict(ne.target, true)
+	_ = n.cache.Evict(utils.PathParent(ne.target), false)
+	if ne.target == to {
+		return n.Get(ctx, to)
+	}
+	restored, e := n.WebDAVDrive.Get(ctx, ne.target)
+	if e != nil {
+		return nil, e
+	}
+	return n.WebDAVDrive.Move(ctx, restored, to, override)
+}
+
+func (n *NextcloudDrive) davGet(ctx context.Context, path string) (io.ReadCloser, error) {
+	resp, e := n.dav.Get(ctx, path, nil)
+	if e != nil {
+		return nil, e
+	}
+	return resp.Response().Body, nil
+}
+
+func (n *NextcloudDrive) davMove(ctx context.Context, from, to string, override bool) error {
+	dest, e := n.dav.BuildURL(to)
+	if e != nil {
+		return e
+	}
+	header := types.SM{"Destination": dest, "Overwrite": "F"}
+	if override {
+		header["Overwrite"] = "T"
+	}
+	resp, e := n.dav.Request(ctx, "MOVE", from, header, nil)
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+func (n *NextcloudDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	root, rest := virtualPath(path)
+	switch root {
+	case nextcloudTrashDir:
+		res, e := n.propfind(ctx, n.dav, n.trashPath(rest), "1")
+		if e != nil {
+			return nil, e
+		}
+		entries := make([]types.IEntry, 0, len(res))
+		for _, r := range res {
+			entry := n.newTrashEntry(r)
+			if entry.path != path {
+				entries = append(entries, entry)
+			}
+		}
+		return entries, nil
+	case nextcloudVersionsDir:
+		return n.listVersionsDir(ctx, rest)
+	}
+	entries, e := n.WebDAVDrive.List(ctx, path)
+	if e != nil || !utils.IsRootPath(path) {
+		return entries, e
+	}
+	result := make([]types.IEntry, 0, len(entries)+2)
+	result = append(result, entries...)
+	return append(result, n.newVirtualEntry(nextcloudTrashDir), n.newVirtualEntry(nextcloudVersionsDir)), nil
+}
+
+// listVersionsDir lists the directory in '.versions', the directory of a file contains its versions
+func (n *NextcloudDrive) listVersionsDir(ctx context.Context, rest string) ([]types.IEntry, error) {
+	entry, e := n.WebDAVDrive.Get(ctx, rest)
+	if e != nil {
+		return nil, e
+	}
+	if entry.Type().IsFile() {
+		return n.listVersions(ctx, rest)
+	}
+	children, e := n.WebDAVDrive.List(ctx, rest)
+	if e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, 0, len(children))
+	for _, c := range children {
+		entries = append(entries, n.newVirtualEntry(utils.CleanPath(nextcloudVersionsDir+"/"+c.Path())))
+	}
+	return entries, nil
+}
+
+// listVersions lists the previous versions of the file
+func (n *NextcloudDrive) listVersions(ctx context.Context, path string) ([]types.IEntry, error) {
+	res, e := n.propfind(ctx, n.c, utils.BuildURL("{}", path), "0")
+	if e != nil {
+		return nil, e
+	}
+	if len(res) == 0 || res[0].CollectionMark != nil {
+		return nil, err.NewNotFoundError()
+	}
+	res, e = n.propfind(ctx, n.dav, utils.BuildURL("versions/{}/versions/{}", n.username, res[0].FileID), "1")
+	if e != nil {
+		return nil, e
+	}
+	dir := n.davPrefix + "/versions/" + n.username + "/versions/"
+	entries := make([]types.IEntry, 0, len(res))
+	for _, r := range res {
+		href, _ := url.PathUnescape(r.Href)
+		if !strings.HasPrefix(href, dir) || r.CollectionMark != nil {
+			continue
+		}
+		modTime, _ := time.Parse(time.RFC1123, r.LastModified)
+		entries = append(entries, &nextcloudEntry{
+			d:       n,
+			kind:    nextcloudVersion,
+			path:    nextcloudVersionsDir + "/" + path + "/" + utils.PathBase(href),
+			davPath: href[len(n.davPrefix):],
+			size:    r.Size,
+			modTime: utils.Millisecond(modTime),
+			target:  path,
+		})
+	}
+	return entries, nil
+}
+
+// Delete deletes the item in the trash bin permanently, or empties the trash bin
+func (n *NextcloudDrive) Delete(ctx types.TaskCtx, path string) error {
+	root, rest := virtualPath(path)
+	switch root {
+	case nextcloudTrashDir:
+		resp, e := n.dav.Request(ctx, "DELETE", n.trashPath(rest), nil, nil)
+		if e != nil {
+			return e
+		}
+		return resp.Dispose()
+	case nextcloudVersionsDir:
+		return err.NewNotAllowedError()
+	}
+	return n.WebDAVDrive.Delete(ctx, path)
+}
+
+func (n *NextcloudDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, config types.SM) (*types.DriveUploadConfig, error) {
+	if root, _ := virtualPath(path); root != "" {
+		return nil, err.NewNotAllowedError()
+	}
+	return n.WebDAVDrive.Upload(ctx, path, size, override, config)
+}
+
+// Share creates a public link share by the OCS API
+func (n *NextcloudDrive) Share(ctx context.Context, path string, options types.ShareOptions) (*types.ShareLink, error) {
+	if root, _ := virtualPath(path); root != "" || utils.IsRootPath(path) {
+		return nil, err.NewNotAllowedError()
+	}
+	params := types.SM{"path": "/" + path, "shareType": "3"}
+	if options.Password != "" {
+		params["password"] = options.Password
+	}
+	if options.ExpiresAt > 0 {
+		// the links expire by days
+		params["expireDate"] = utils.Time(options.ExpiresAt).UTC().Format("2006-01-02")
+	}
+	resp, e := n.ocs.Post(ctx, "ocs/v2.php/apps/files_sharing/api/v1/shares?format=json",
+		types.SM{"OCS-APIRequest": "true"}, req.NewURLEncodedBody(params))
+	if e != nil {
+		return nil, e
+	}
+	r := ocsResponse{}
+	if e := resp.Json(&r); e != nil {
+		return nil, e
+	}
+	link := &types.ShareLink{URL: r.Ocs.Data.URL}
+	if r.Ocs.Data.Expiration != "" {
+		if t, e := time.Parse("2006-01-02 15:04:05", r.Ocs.Data.Expiration); e == nil {
+			link.ExpiresAt = utils.Millisecond(t)
+		}
+	}
+	return link, nil
+}
+
+func (n *NextcloudDrive) afterOCSRequest(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	if resp.Status() == http.StatusUnauthorized {
+		return err.NewUnauthorizedError(i18n.T("drive.webdav.wrong_user_or_password"))
+	}
+	message := strconv.Itoa(resp.Status())
+	r := ocsResponse{}
+	if e := resp.Json(&r); e == nil && r.Ocs.Meta.Message != "" {
+		message = r.Ocs.Meta.Message
+	}
+	if resp.Status() == http.StatusNotFound {
+		return err.NewNotFoundMessageError(message)
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.webdav.remote_error", message))
+}
+
+func (n *NextcloudDrive) trashPath(rest string) string {
+	if rest == "" {
+		return utils.BuildURL("trashbin/{}/trash", n.username)
+	}
+	return utils.BuildURL("trashbin/{}/trash/{}", n.username, rest)
+}
+
+func (n *NextcloudDrive) propfind(ctx context.Context, c *req.Client, path, depth string) ([]nextcloudResponse, error) {
+	resp, e := c.Request(ctx, "PROPFIND", path, types.SM{"Depth": depth}, nextcloudXMLBody(nextcloudPropfindBody))
+	if e != nil {
+		return nil, e
+	}
+	res := nextcloudMultiStatus{}
+	if e := resp.XML(&res); e != nil {
+		return nil, e
+	}
+	return res.Response, nil
+}
+
+func (n *NextcloudDrive) newVirtualEntry(path string) *nextcloudEntry {
+	return &nextcloudEntry{d: n, kind: nextcloudVirtual, path: path, size: -1, isDir: true}
+}
+
+func (n *NextcloudDrive) newTrashEntry(r nextcloudResponse) *nextcloudEntry {
+	href, _ := url.PathUnescape(r.Href)
+	trash := n.davPrefix + "/trashbin/" + n.username + "/trash"
+	if strings.HasPrefix(href, trash) {
+		href = href[len(trash):]
+	}
+	rest := utils.CleanPath(href)
+	modTime, _ := time.Parse(time.RFC1123, r.LastModified)
+	e := &nextcloudEntry{
+		d:       n,
+		kind:    nextcloudTrash,
+		path:    utils.CleanPath(nextcloudTrashDir + "/" + rest),
+		davPath: n.trashPath(rest),
+		size:    r.Size,
+		modTime: utils.Millisecond(modTime),
+		isDir:   r.CollectionMark != nil,
+		target:  r.TrashLocation,
+	}
+	if r.TrashDeletionTime > 0 {
+		e.modTime = r.TrashDeletionTime * 1000
+	}
+	return e
+}
+
+type nextcloudEntryKind int
+
+const (
+	// nextcloudVirtual is the virtual directory of '.trash' and '.versions', and the directories in '.versions'
+	nextcloudVirtual nextcloudEntryKind = iota
+	// nextcloudTrash is the item in the trash bin, target is its original location
+	nextcloudTrash
+	// nextcloudVersion is the version of a file, target is the path of the file
+	nextcloudVersion
+)
+
+type nextcloudEntry struct {
+	d       *NextcloudDrive
+	kind    nextcloudEntryKind
+	path    string
+	davPath string
+	size    int64
+	modTime int64
+	isDir   bool
+	target  string
+}
+
+func (e *nextcloudEntry) Path() string {
+	return e.path
+}
+
+func (e *nextcloudEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *nextcloudEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *nextcloudEntry) Meta() types.EntryMeta {
+	switch e.kind {
+	case nextcloudTrash:
+		return types.EntryMeta{CanRead: true, CanWrite: true, Props: types.M{"original_location": e.target}}
+	case nextcloudVersion:
+		return types.EntryMeta{CanRead: true, CanWrite: false, Props: types.M{"file": e.target}}
+	}
+	return types.EntryMeta{CanRead: true, CanWrite: e.path == nextcloudTrashDir}
+}
+
+func (e *nextcloudEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *nextcloudEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *nextcloudEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *nextcloudEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	if e.isDir || e.davPath == "" {
+		return nil, err.NewNotAllowedError()
+	}
+	return e.d.davGet(ctx, e.davPath)
+}
+
+func (e *nextcloudEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+type nextcloudXMLBody string
+
+func (b nextcloudXMLBody) ContentLength() int64 {
+	return int64(len(b))
+}
+
+func (b nextcloudXMLBody) ContentType() string {
+	return "application/xml; charset=utf-8"
+}
+
+func (b nextcloudXMLBody) Reader() io.Reader {
+	return strings.NewReader(string(b))
+}
+
+type nextcloudMultiStatus struct {
+	Response []nextcloudResponse `xml:"response"`
+}
+
+type nextcloudResponse struct {
+	Href              string    `xml:"href"`
+	LastModified      string    `xml:"propstat>prop>getlastmodified"`
+	Size              int64     `xml:"propstat>prop>getcontentlength"`
+	CollectionMark    *xml.Name `xml:"propstat>prop>resourcetype>collection"`
+	FileID            string    `xml:"propstat>prop>fileid"`
+	TrashLocation     string    `xml:"propstat>prop>trashbin-original-location"`
+	TrashDeletionTime int64     `xml:"propstat>prop>trashbin-deletion-time"`
+}
+
+type ocsResponse struct {
+	Ocs struct {
+		Meta struct {
+			Message string `json:"message"`
+		} `json:"meta"`
+		Data struct {
+			URL        string `json:"url"`
+			Expiration string `json:"expiration"`
+		} `json:"data"`
+	} `json:"ocs"`
+}
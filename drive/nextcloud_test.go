@@ -0,0 +1,130 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go-drive/common/drive_util"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testNextcloudServer struct {
+	t       *testing.T
+	mux     sync.Mutex
+	chunks  map[string]int
+	created []byte
+	share   map[string]string
+}
+
+func (s *testNextcloudServer) multiStatus(w http.ResponseWriter, responses ...string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, _ = w.Write([]byte(`<?xml version="1.0"?><d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns" ` +
+		`xmlns:nc="http://nextcloud.org/ns">` + strings.Join(responses, "") + `</d:multistatus>`))
+}
+
+func (s *testNextcloudServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	dir := `<d:response><d:href>%s</d:href><d:propstat><d:prop>` +
+		`<d:resourcetype><d:collection/></d:resourcetype></d:prop></d:propstat></d:response>`
+	file := `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:getcontentlength>%d</d:getcontentlength>` +
+		`<d:getlastmodified>Mon, 12 Oct 2020 08:00:00 GMT</d:getlastmodified><d:resourcetype/>%s</d:prop></d:propstat></d:response>`
+	switch {
+	case r.Method == "PROPFIND" && strings.TrimSuffix(r.URL.Path, "/") == "/remote.php/dav/files/alice":
+		s.multiStatus(w, fmt.Sprintf(dir, "/remote.php/dav/files/alice/"))
+	case r.Method == "PROPFIND" && r.URL.Path == "/remote.php/dav/files/alice/big.bin" && s.created != nil:
+		s.multiStatus(w, fmt.Sprintf(file, r.URL.Path, len(s.created), ""))
+	case r.Method == "MKCOL" && strings.HasPrefix(r.URL.Path, "/remote.php/dav/uploads/alice/"):
+		if r.Header.Get("Destination") != "http://"+r.Host+"/remote.php/dav/files/alice/big.bin" {
+			s.t.Errorf("unexpected destination: %s", r.Header.Get("Destination"))
+		}
+		s.chunks = make(map[string]int)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/remote.php/dav/uploads/alice/"):
+		b, _ := ioutil.ReadAll(r.Body)
+		s.chunks[r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:]] = len(b)
+		s.created = append(s.created, b...)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "MOVE" && strings.HasSuffix(r.URL.Path, "/.file"):
+		if r.Header.Get("OC-Total-Length") != fmt.Sprint(len(s.created)) {
+			s.t.Errorf("unexpected total length: %s", r.Header.Get("OC-Total-Length"))
+		}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPost && r.URL.Path == "/ocs/v2.php/apps/files_sharing/api/v1/shares":
+		_ = r.ParseForm()
+		s.share = map[string]string{"path": r.PostForm.Get("path"), "expireDate": r.PostForm.Get("expireDate"),
+			"shareType": r.PostForm.Get("shareType"), "OCS-APIRequest": r.Header.Get("OCS-APIRequest")}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ocs":{"meta":{"status":"ok","statuscode":200},` +
+			`"data":{"url":"https://cloud.example.com/s/abc","expiration":"2020-10-13 00:00:00"}}}`))
+	case r.Method == "PROPFIND" && r.URL.Path == "/remote.php/dav/trashbin/alice/trash":
+		s.multiStatus(w, fmt.Sprintf(dir, "/remote.php/dav/trashbin/alice/trash/"),
+			fmt.Sprintf(file, "/remote.php/dav/trashbin/alice/trash/a%20b.txt.d1600000000", 5,
+				"<nc:trashbin-original-location>docs/a b.txt</nc:trashbin-original-location>"+
+					"<nc:trashbin-deletion-time>1600000000</nc:trashbin-deletion-time>"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestNextcloud(t *testing.T) {
+	s := &testNextcloudServer{t: t}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	d, e := NewNextcloudDrive(context.Background(), drive_util.DriveConfig{
+		"url": server.URL, "username": "alice", "password": "secret", "chunk_size": "5M",
+	}, drive_util.DriveUtils{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	n := d.(*NextcloudDrive)
+
+	data := bytes.Repeat([]byte("0123456789"), 1200*1024)
+	entry, e := n.Save(task.DummyContext(), "big.bin", int64(len(data)), true, bytes.NewReader(data))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if entry.Size() != int64(len(data)) || !bytes.Equal(s.created, data) {
+		t.Errorf("unexpected uploaded file: %d", entry.Size())
+	}
+	if len(s.chunks) != 3 || s.chunks["00001"] != 5*1024*1024 || s.chunks["00003"] != len(data)-10*1024*1024 {
+		t.Errorf("unexpected chunks: %v", s.chunks)
+	}
+
+	link, e := n.Share(context.Background(), "big.bin", types.ShareOptions{ExpiresAt: 1602547200000})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if link.URL != "https://cloud.example.com/s/abc" || link.ExpiresAt != 1602547200000 {
+		t.Errorf("unexpected link: %+v", link)
+	}
+	if s.share["path"] != "/big.bin" || s.share["shareType"] != "3" ||
+		s.share["expireDate"] != "2020-10-13" || s.share["OCS-APIRequest"] != "true" {
+		t.Errorf("unexpected share request: %v", s.share)
+	}
+	if _, e := n.Share(context.Background(), ".trash", types.ShareOptions{}); e == nil {
+		t.Error("expect error of sharing the virtual directory")
+	}
+
+	entries, e := n.List(context.Background(), ".trash")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("unexpected trash: %d", len(entries))
+	}
+	item := entries[0]
+	if item.Path() != ".trash/a b.txt.d1600000000" || item.Size() != 5 || item.ModTime() != 1600000000000 ||
+		item.Meta().Props["original_location"] != "docs/a b.txt" {
+		t.Errorf("unexpected trash item: %s, %v", item.Path(), item.Meta().Props)
+	}
+}
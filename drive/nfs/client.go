@@ -0,0 +1,379 @@
+package nfs
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// the procedures of NFSv3 (RFC 1813) and MOUNTv3
+const (
+	procNull        = 0
+	procGetattr     = 1
+	procLookup      = 3
+	procRead        = 6
+	procWrite       = 7
+	procCreate      = 8
+	procMkdir       = 9
+	procRemove      = 12
+	procRmdir       = 13
+	procRename      = 14
+	procReaddirplus = 17
+	procFsinfo      = 19
+
+	procMountMnt = 1
+)
+
+const (
+	typeReg = 1
+	typeDir = 2
+
+	createUnchecked = 0
+	createGuarded   = 1
+
+	stableFileSync = 2
+
+	timeSetToServer = 1
+)
+
+const (
+	// readdirMaxCount is the max size of the replies of READDIRPLUS
+	readdirMaxCount = 64 * 1024
+	// defaultTransferSize is used when the server does not tell the max sizes of READ and WRITE
+	defaultTransferSize = 64 * 1024
+	// maxTransferSize limits the sizes of READ and WRITE
+	maxTransferSize = 1024 * 1024
+)
+
+// the errors of NFSv3 and MOUNTv3
+const (
+	errPerm        = 1
+	errNoEnt       = 2
+	errAccess      = 13
+	errExist       = 17
+	errNotDir      = 20
+	errIsDir       = 21
+	errNoSpace     = 28
+	errReadOnlyFs  = 30
+	errNotEmpty    = 66
+	errQuota       = 69
+	errStale       = 70
+	errBadHandle   = 10001
+	errNotSupp     = 10004
+	errServerFault = 10006
+)
+
+var errorNames = map[uint32]string{
+	errPerm:        "NFS3ERR_PERM",
+	errNoEnt:       "NFS3ERR_NOENT",
+	5:              "NFS3ERR_IO",
+	6:              "NFS3ERR_NXIO",
+	errAccess:      "NFS3ERR_ACCES",
+	errExist:       "NFS3ERR_EXIST",
+	18:             "NFS3ERR_XDEV",
+	19:             "NFS3ERR_NODEV",
+	errNotDir:      "NFS3ERR_NOTDIR",
+	errIsDir:       "NFS3ERR_ISDIR",
+	22:             "NFS3ERR_INVAL",
+	27:             "NFS3ERR_FBIG",
+	errNoSpace:     "NFS3ERR_NOSPC",
+	errReadOnlyFs:  "NFS3ERR_ROFS",
+	31:             "NFS3ERR_MLINK",
+	63:             "NFS3ERR_NAMETOOLONG",
+	errNotEmpty:    "NFS3ERR_NOTEMPTY",
+	errQuota:       "NFS3ERR_DQUOT",
+	errStale:       "NFS3ERR_STALE",
+	71:             "NFS3ERR_REMOTE",
+	errBadHandle:   "NFS3ERR_BADHANDLE",
+	errNotSupp:     "NFS3ERR_NOTSUPP",
+	10005:          "NFS3ERR_TOOSMALL",
+	errServerFault: "NFS3ERR_SERVERFAULT",
+	10008:          "NFS3ERR_JUKEBOX",
+}
+
+// nfsError is the error status replied by the server
+type nfsError uint32
+
+func (n nfsError) Error() string {
+	if name, ok := errorNames[uint32(n)]; ok {
+		return name
+	}
+	return "NFS3ERR " + strconv.FormatUint(uint64(n), 10)
+}
+
+// fattr is the attributes of a file
+type fattr struct {
+	ftype uint32
+	size  uint64
+	mtime time.Time
+}
+
+func readFattr(r *xdrReader) *fattr {
+	a := &fattr{}
+	a.ftype = r.uint32()
+	// mode, nlink, uid, gid
+	r.fixed(16)
+	a.size = r.uint64()
+	// used, rdev, fsid, fileid, atime
+	r.fixed(40)
+	a.mtime = time.Unix(int64(r.uint32()), int64(r.uint32()))
+	// ctime
+	r.fixed(8)
+	return a
+}
+
+func readPostOpAttr(r *xdrReader) *fattr {
+	if !r.bool() {
+		return nil
+	}
+	return readFattr(r)
+}
+
+func readPostOpFh(r *xdrReader) []byte {
+	if !r.bool() {
+		return nil
+	}
+	return r.opaque()
+}
+
+func skipWccData(r *xdrReader) {
+	if r.bool() {
+		// size, mtime, ctime
+		r.fixed(24)
+	}
+	readPostOpAttr(r)
+}
+
+// readStatus reads the status of the reply, the attributes following the failed status are not read
+func readStatus(r *xdrReader) error {
+	status := r.uint32()
+	if r.e != nil {
+		return r.e
+	}
+	if status != 0 {
+		return nfsError(status)
+	}
+	return nil
+}
+
+// writeSattr writes the attributes to set, the times are set by the server
+func writeSattr(w *xdrWriter, mode uint32, truncate bool) *xdrWriter {
+	w.bool(true).uint32(mode).bool(false).bool(false).bool(truncate)
+	if truncate {
+		w.uint64(0)
+	}
+	return w.uint32(timeSetToServer).uint32(timeSetToServer)
+}
+
+// mount mounts the export, and returns the file handle of its root
+func mount(ctx context.Context, host, port, export string, cred []byte) ([]byte, error) {
+	c, e := dialRPC(ctx, host, port, progMount, 3, cred)
+	if e != nil {
+		return nil, e
+	}
+	defer c.close()
+	r, e := c.call(procMountMnt, (&xdrWriter{}).string(export).bytes())
+	if e != nil {
+		return nil, e
+	}
+	if e := readStatus(r); e != nil {
+		return nil, e
+	}
+	fh := r.opaque()
+	return fh, r.e
+}
+
+// nfsConn is a connection to the NFS server, it's not safe for concurrent use
+type nfsConn struct {
+	*rpcConn
+}
+
+func (c *nfsConn) null() error {
+	_, e := c.call(procNull, nil)
+	return e
+}
+
+func (c *nfsConn) getattr(fh []byte) (*fattr, error) {
+	r, e := c.call(procGetattr, (&xdrWriter{}).opaque(fh).bytes())
+	if e != nil {
+		return nil, e
+	}
+	if e := readStatus(r); e != nil {
+		return nil, e
+	}
+	a := readFattr(r)
+	return a, r.e
+}
+
+// fsinfo returns the preferred sizes of READ and WRITE
+func (c *nfsConn) fsinfo(fh []byte) (uint32, uint32, error) {
+	r, e := c.call(procFsinfo, (&xdrWriter{}).opaque(fh).bytes())
+	if e != nil {
+		return 0, 0, e
+	}
+	if e := readStatus(r); e != nil {
+		return 0, 0, e
+	}
+	readPostOpAttr(r)
+	// rtmax, rtpref, rtmult, wtmax, wtpref
+	r.uint32()
+	rtpref := r.uint32()
+	r.uint32()
+	r.uint32()
+	wtpref := r.uint32()
+	return rtpref, wtpref, r.e
+}
+
+func (c *nfsConn) lookup(dir []byte, name string) ([]byte, *fattr, error) {
+	r, e := c.call(procLookup, (&xdrWriter{}).opaque(dir).string(name).bytes())
+	if e != nil {
+		return nil, nil, e
+	}
+	if e := readStatus(r); e != nil {
+		return nil, nil, e
+	}
+	fh := r.opaque()
+	a := readPostOpAttr(r)
+	if r.e == nil && a == nil {
+		return fh, nil, nfsError(errServerFault)
+	}
+	return fh, a, r.e
+}
+
+// read reads at most count bytes at offset, and returns the data and whether it's the end of the file
+func (c *nfsConn) read(fh []byte, offset uint64, count uint32) ([]byte, bool, error) {
+	r, e := c.call(procRead, (&xdrWriter{}).opaque(fh).uint64(offset).uint32(count).bytes())
+	if e != nil {
+		return nil, false, e
+	}
+	if e := readStatus(r); e != nil {
+		return nil, false, e
+	}
+	readPostOpAttr(r)
+	r.uint32()
+	eof := r.bool()
+	data := r.opaque()
+	return data, eof, r.e
+}
+
+// write writes the data at offset synchronously, and returns the number of bytes written
+func (c *nfsConn) write(fh []byte, offset uint64, data []byte) (uint32, error) {
+	r, e := c.call(procWrite, (&xdrWriter{}).opaque(fh).uint64(offset).
+		uint32(uint32(len(data))).uint32(stableFileSync).opaque(data).bytes())
+	if e != nil {
+		return 0, e
+	}
+	if e := readStatus(r); e != nil {
+		return 0, e
+	}
+	skipWccData(r)
+	n := r.uint32()
+	return n, r.e
+}
+
+// create creates the file, the existing file is truncated if guarded is false
+func (c *nfsConn) create(dir []byte, name string, mode uint32, guarded bool) ([]byte, error) {
+	how := uint32(createUnchecked)
+	if guarded {
+		how = createGuarded
+	}
+	w := (&xdrWriter{}).opaque(dir).string(name).uint32(how)
+	r, e := c.call(procCreate, writeSattr(w, mode, !guarded).bytes())
+	if e != nil {
+		return nil, e
+	}
+	return c.readCreated(r, dir, name)
+}
+
+func (c *nfsConn) mkdir(dir []byte, name string, mode uint32) ([]byte, error) {
+	w := (&xdrWriter{}).opaque(dir).string(name)
+	r, e := c.call(procMkdir, writeSattr(w, mode, false).bytes())
+	if e != nil {
+		return nil, e
+	}
+	return c.readCreated(r, dir, name)
+}
+
+// readCreated reads the file handle of the created file, it's looked up if the server does not reply it
+func (c *nfsConn) readCreated(r *xdrReader, dir []byte, name string) ([]byte, error) {
+	if e := readStatus(r); e != nil {
+		return nil, e
+	}
+	fh := readPostOpFh(r)
+	if r.e != nil {
+		return nil, r.e
+	}
+	if fh == nil {
+		var e error
+		fh, _, e = c.lookup(dir, name)
+		return fh, e
+	}
+	return fh, nil
+}
+
+func (c *nfsConn) remove(dir []byte, name string, isDir bool) error {
+	proc := uint32(procRemove)
+	if isDir {
+		proc = procRmdir
+	}
+	r, e := c.call(proc, (&xdrWriter{}).opaque(dir).string(name).bytes())
+	if e != nil {
+		return e
+	}
+	return readStatus(r)
+}
+
+func (c *nfsConn) rename(fromDir []byte, fromName string, toDir []byte, toName string) error {
+	r, e := c.call(procRename, (&xdrWriter{}).opaque(fromDir).string(fromName).
+		opaque(toDir).string(toName).bytes())
+	if e != nil {
+		return e
+	}
+	return readStatus(r)
+}
+
+// dirEntry is an entry of READDIRPLUS, fh and attr may be nil if the server omits them
+type dirEntry struct {
+	name string
+	fh   []byte
+	attr *fattr
+}
+
+// readdirplus reads all the entries of the directory, except '.' and '..'
+func (c *nfsConn) readdirplus(dir []byte) ([]dirEntry, error) {
+	entries := make([]dirEntry, 0)
+	cookie := uint64(0)
+	verifier := make([]byte, 8)
+	for {
+		r, e := c.call(procReaddirplus, (&xdrWriter{}).opaque(dir).uint64(cookie).fixed(verifier).
+			uint32(readdirMaxCount).uint32(readdirMaxCount).bytes())
+		if e != nil {
+			return nil, e
+		}
+		if e := readStatus(r); e != nil {
+			return nil, e
+		}
+		readPostOpAttr(r)
+		verifier = r.fixed(8)
+		n := 0
+		for ; r.bool(); n++ {
+			// fileid
+			r.uint64()
+			entry := dirEntry{name: r.string()}
+			cookie = r.uint64()
+			entry.attr = readPostOpAttr(r)
+			entry.fh = readPostOpFh(r)
+			if entry.name != "." && entry.name != ".." {
+				entries = append(entries, entry)
+			}
+		}
+		eof := r.bool()
+		if r.e != nil {
+			return nil, r.e
+		}
+		// stops if the server replies no entries without the end, which would loop forever
+		if eof || n == 0 {
+			return entries, nil
+		}
+	}
+}
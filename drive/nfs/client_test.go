@@ -0,0 +1,108 @@
+package nfs
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// serveOnce reads a call, and replies the results of fn, which gets the procedure and the arguments
+func serveOnce(t *testing.T, conn net.Conn, fn func(proc uint32, args *xdrReader) []byte) {
+	var header [4]byte
+	if _, e := io.ReadFull(conn, header[:]); e != nil {
+		t.Error(e)
+		return
+	}
+	record := make([]byte, binary.BigEndian.Uint32(header[:])&^lastFragment)
+	if _, e := io.ReadFull(conn, record); e != nil {
+		t.Error(e)
+		return
+	}
+	r := &xdrReader{b: record}
+	xid := r.uint32()
+	// msg type, rpc version, prog, vers
+	r.fixed(16)
+	proc := r.uint32()
+	r.uint32()
+	r.opaque()
+	r.uint32()
+	r.opaque()
+	results := fn(proc, r)
+
+	reply := (&xdrWriter{}).uint32(xid).uint32(msgReply).uint32(msgAccepted).
+		uint32(authNone).uint32(0).uint32(acceptSuccess).fixed(results).bytes()
+	// replies in two fragments
+	half := len(reply) / 2
+	w := (&xdrWriter{}).uint32(uint32(half))
+	w.b.Write(reply[:half])
+	w.uint32(lastFragment | uint32(len(reply)-half))
+	w.b.Write(reply[half:])
+	if _, e := conn.Write(w.bytes()); e != nil {
+		t.Error(e)
+	}
+}
+
+func writeFattr(w *xdrWriter, ftype uint32, size uint64, mtime uint32) {
+	w.uint32(ftype).uint32(0644).uint32(1).uint32(0).uint32(0).uint64(size).uint64(size).
+		uint32(0).uint32(0).uint64(1).uint64(2).uint32(0).uint32(0).uint32(mtime).uint32(0).uint32(0).uint32(0)
+}
+
+func TestNfsReaddirplus(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go func() {
+		defer func() { _ = server.Close() }()
+		serveOnce(t, server, func(proc uint32, args *xdrReader) []byte {
+			if proc != procReaddirplus {
+				t.Errorf("unexpected procedure: %d", proc)
+			}
+			if fh := string(args.opaque()); fh != "root" {
+				t.Errorf("unexpected file handle: %s", fh)
+			}
+			w := (&xdrWriter{}).uint32(0).bool(false).fixed(make([]byte, 8))
+			// '.', a directory, and a file without the handle
+			w.bool(true).uint64(1).string(".").uint64(1).bool(false).bool(false)
+			w.bool(true).uint64(2).string("dir").uint64(2).bool(true)
+			writeFattr(w, typeDir, 4096, 1600000000)
+			w.bool(true).opaque([]byte("fh-dir"))
+			w.bool(true).uint64(3).string("file.txt").uint64(3).bool(true)
+			writeFattr(w, typeReg, 123, 1600000001)
+			w.bool(false)
+			return w.bool(false).bool(true).bytes()
+		})
+	}()
+
+	c := &nfsConn{&rpcConn{netConn: client, prog: progNfs, vers: 3, cred: credUnix(0, 0)}}
+	entries, e := c.readdirplus([]byte("root"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	dir, file := entries[0], entries[1]
+	if dir.name != "dir" || string(dir.fh) != "fh-dir" || dir.attr.ftype != typeDir || dir.attr.mtime.Unix() != 1600000000 {
+		t.Errorf("unexpected dir: %+v %+v", dir, dir.attr)
+	}
+	if file.name != "file.txt" || file.fh != nil || file.attr.size != 123 || file.attr.ftype != typeReg {
+		t.Errorf("unexpected file: %+v %+v", file, file.attr)
+	}
+}
+
+func TestNfsErrorStatus(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go func() {
+		defer func() { _ = server.Close() }()
+		serveOnce(t, server, func(uint32, *xdrReader) []byte {
+			// NFS3ERR_NOENT with the attributes of the directory
+			return (&xdrWriter{}).uint32(errNoEnt).bool(false).bytes()
+		})
+	}()
+
+	c := &nfsConn{&rpcConn{netConn: client, prog: progNfs, vers: 3, cred: credNone}}
+	if _, _, e := c.lookup([]byte("root"), "missing"); !isNotFound(e) {
+		t.Errorf("unexpected error: %v", e)
+	}
+}
@@ -0,0 +1,579 @@
+package nfs
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	path2 "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "nfs",
+		DisplayName: i18n.T("drive.nfs.name"),
+		README:      i18n.T("drive.nfs.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "host", Label: i18n.T("drive.nfs.form.host.label"), Type: "text", Required: true, Description: i18n.T("drive.nfs.form.host.description")},
+			{Field: "export", Label: i18n.T("drive.nfs.form.export.label"), Type: "text", Required: true, Description: i18n.T("drive.nfs.form.export.description")},
+			{Field: "port", Label: i18n.T("drive.nfs.form.port.label"), Type: "text", Description: i18n.T("drive.nfs.form.port.description")},
+			{Field: "mount_port", Label: i18n.T("drive.nfs.form.mount_port.label"), Type: "text", Description: i18n.T("drive.nfs.form.mount_port.description")},
+			{Field: "uid", Label: i18n.T("drive.nfs.form.uid.label"), Type: "text", Description: i18n.T("drive.nfs.form.uid.description"), DefaultValue: "0"},
+			{Field: "gid", Label: i18n.T("drive.nfs.form.gid.label"), Type: "text", Description: i18n.T("drive.nfs.form.gid.description"), DefaultValue: "0"},
+			{Field: "max_connections", Label: i18n.T("drive.nfs.form.max_connections.label"), Type: "text", Description: i18n.T("drive.nfs.form.max_connections.description"), DefaultValue: strconv.Itoa(defaultMaxConnections)},
+			{Field: "cache_ttl", Label: i18n.T("drive.nfs.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.nfs.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewNfsDrive},
+	})
+}
+
+const (
+	defaultMaxConnections = 4
+	// defaultPort is used when the portmapper does not know the port of NFS, like the servers of NFSv4
+	defaultPort = "2049"
+
+	fileMode = 0644
+	dirMode  = 0755
+)
+
+// NfsDrive is the drive of an export of an NFSv3 server, it's accessed by the NFS client of go-drive,
+// so the export is not required to be mounted by the OS
+type NfsDrive struct {
+	pool *connPool
+	// root is the file handle of the root of the export
+	root      []byte
+	readSize  uint32
+	writeSize uint32
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+}
+
+// NewNfsDrive mounts the export by MOUNTv3, and checks the root by FSINFO
+func NewNfsDrive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	host := config["host"]
+	export := config["export"]
+	if !strings.HasPrefix(export, "/") {
+		export = "/" + export
+	}
+	cred := credUnix(uint32(utils.ToInt64(config["uid"], 0)), uint32(utils.ToInt64(config["gid"], 0)))
+
+	mountPort := config["mount_port"]
+	if mountPort == "" {
+		port, e := getPort(ctx, host, progMount, 3)
+		if e != nil {
+			return nil, toDriveError(e)
+		}
+		mountPort = port
+	}
+	root, e := mount(ctx, host, mountPort, export, cred)
+	if e != nil {
+		if isNotFound(e) {
+			return nil, err.NewNotFoundMessageError(i18n.T("drive.nfs.export_not_found", export))
+		}
+		return nil, toDriveError(e)
+	}
+	port := config["port"]
+	if port == "" {
+		if port, e = getPort(ctx, host, progNfs, 3); e != nil {
+			port = defaultPort
+		}
+	}
+	maxConnections := utils.ToInt(config["max_connections"], defaultMaxConnections)
+	if maxConnections <= 0 {
+		maxConnections = defaultMaxConnections
+	}
+
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	d := &NfsDrive{
+		pool:     newConnPool(&dialOptions{host: host, port: port, cred: cred}, maxConnections),
+		root:     root,
+		cacheTTL: cacheTtl,
+	}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+
+	// check
+	if e := d.pool.do(ctx, func(c *nfsConn) error {
+		readSize, writeSize, e := c.fsinfo(root)
+		d.readSize, d.writeSize = transferSize(readSize), transferSize(writeSize)
+		return e
+	}); e != nil {
+		d.pool.close()
+		return nil, toDriveError(e)
+	}
+	return d, nil
+}
+
+func transferSize(n uint32) uint32 {
+	if n == 0 {
+		return defaultTransferSize
+	}
+	if n > maxTransferSize {
+		return maxTransferSize
+	}
+	return n
+}
+
+func (d *NfsDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+// Get looks up the names of the path from the parent, or from the root if the parent is not cached
+func (d *NfsDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &nfsEntry{d: d, path: path, fh: d.root, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	dir := d.root
+	names := strings.Split(path, "/")
+	if parent, _ := d.cache.GetEntry(utils.PathParent(path)); parent != nil {
+		dir = parent.(*nfsEntry).fh
+		names = names[len(names)-1:]
+	}
+	var entry *nfsEntry
+	e := d.pool.do(ctx, func(c *nfsConn) error {
+		for i, name := range names {
+			fh, attr, e := c.lookup(dir, name)
+			if e != nil {
+				return e
+			}
+			if i < len(names)-1 && attr.ftype != typeDir {
+				return nfsError(errNoEnt)
+			}
+			dir = fh
+			entry = d.newEntry(path, fh, attr)
+		}
+		return nil
+	})
+	if e != nil {
+		return nil, toDriveError(e)
+	}
+	if entry == nil {
+		return nil, err.NewNotFoundError()
+	}
+	_ = d.cache.PutEntry(entry, d.cacheTTL)
+	return entry, nil
+}
+
+// getDir returns the directory, it fails if path is a file
+func (d *NfsDrive) getDir(ctx context.Context, path string) (*nfsEntry, error) {
+	dir, e := d.Get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if !dir.Type().IsDir() {
+		return nil, err.NewNotAllowedError()
+	}
+	return dir.(*nfsEntry), nil
+}
+
+// Save creates the file, and writes it by WRITE synchronously
+func (d *NfsDrive) Save(ctx types.TaskCtx, path string, _ int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	parent, e := d.getDir(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	var fh []byte
+	e = d.pool.do(ctx, func(c *nfsConn) error {
+		var e error
+		fh, e = c.create(parent.fh, utils.PathBase(path), fileMode, !override)
+		return e
+	})
+	d.evict(path)
+	if e != nil {
+		return nil, toDriveError(e)
+	}
+	reader = drive_util.ProgressReader(reader, ctx)
+	buf := make([]byte, d.writeSize)
+	offset := uint64(0)
+	for {
+		if ctx.Canceled() {
+			return nil, task.ErrorCanceled
+		}
+		n, re := io.ReadFull(reader, buf)
+		if n > 0 {
+			e := d.pool.do(ctx, func(c *nfsConn) error {
+				for written := 0; written < n; {
+					w, e := c.write(fh, offset+uint64(written), buf[written:n])
+					if e != nil {
+						return e
+					}
+					if w == 0 {
+						return io.ErrShortWrite
+					}
+					written += int(w)
+				}
+				return nil
+			})
+			if e != nil {
+				return nil, toDriveError(e)
+			}
+			offset += uint64(n)
+		}
+		if re == io.EOF || re == io.ErrUnexpectedEOF {
+			break
+		}
+		if re != nil {
+			return nil, re
+		}
+	}
+	d.evict(path)
+	return d.Get(ctx, path)
+}
+
+func (d *NfsDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := d.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	parent, e := d.getDir(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	e = d.pool.do(ctx, func(c *nfsConn) error {
+		_, e := c.mkdir(parent.fh, utils.PathBase(path), dirMode)
+		return e
+	})
+	d.evict(path)
+	if e != nil {
+		return nil, toDriveError(e)
+	}
+	return d.Get(ctx, path)
+}
+
+func (d *NfsDrive) isSelf(e types.IEntry) bool {
+	if ne, ok := e.(*nfsEntry); ok {
+		return ne.d == d
+	}
+	return false
+}
+
+// Copy is not supported by NFSv3, the files are copied by reading and writing
+func (d *NfsDrive) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (d *NfsDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	if _, e := d.Get(ctx, to); e == nil {
+		if !override {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		if e := d.Delete(ctx, to); e != nil {
+			return nil, e
+		}
+	} else if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	fromParent, e := d.getDir(ctx, utils.PathParent(from.Path()))
+	if e != nil {
+		return nil, e
+	}
+	toParent, e := d.getDir(ctx, utils.PathParent(to))
+	if e != nil {
+		return nil, e
+	}
+	e = d.pool.do(ctx, func(c *nfsConn) error {
+		return c.rename(fromParent.fh, utils.PathBase(from.Path()), toParent.fh, utils.PathBase(to))
+	})
+	d.evict(from.Path())
+	d.evict(to)
+	if e != nil {
+		return nil, toDriveError(e)
+	}
+	return d.Get(ctx, to)
+}
+
+// List lists the directory by READDIRPLUS, only the regular files and the directories are listed
+func (d *NfsDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	dir, e := d.getDir(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, 0)
+	e = d.pool.do(ctx, func(c *nfsConn) error {
+		children, e := c.readdirplus(dir.fh)
+		if e != nil {
+			return e
+		}
+		for _, child := range children {
+			// the servers may omit the handles and the attributes
+			if child.fh == nil || child.attr == nil {
+				if child.fh, child.attr, e = c.lookup(dir.fh, child.name); e != nil {
+					if isNotFound(e) {
+						continue
+					}
+					return e
+				}
+			}
+			if entry := d.newEntry(path2.Join(path, child.name), child.fh, child.attr); entry != nil {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	if e != nil {
+		return nil, toDriveError(e)
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+// Delete deletes the directories recursively, as RMDIR removes only the empty ones
+func (d *NfsDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := d.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	if ctx.Canceled() {
+		return task.ErrorCanceled
+	}
+	if entry.Type().IsDir() {
+		children, e := d.List(ctx, path)
+		if e != nil {
+			return e
+		}
+		for _, child := range children {
+			if e := d.Delete(ctx, child.Path()); e != nil {
+				return e
+			}
+		}
+	}
+	parent, e := d.getDir(ctx, utils.PathParent(path))
+	if e != nil {
+		return e
+	}
+	e = d.pool.do(ctx, func(c *nfsConn) error {
+		return c.remove(parent.fh, utils.PathBase(path), entry.Type().IsDir())
+	})
+	d.evict(path)
+	if e != nil {
+		return toDriveError(e)
+	}
+	ctx.Progress(1, false)
+	return nil
+}
+
+func (d *NfsDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *NfsDrive) Dispose() error {
+	d.pool.close()
+	return nil
+}
+
+func (d *NfsDrive) evict(path string) {
+	_ = d.cache.Evict(path, true)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+}
+
+func (d *NfsDrive) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	fh, e := hex.DecodeString(ec.Data["fh"])
+	if e != nil || len(fh) == 0 {
+		return nil, errors.New("invalid cache")
+	}
+	return &nfsEntry{
+		d: d, path: ec.Path, fh: fh, modTime: ec.ModTime,
+		size: ec.Size, isDir: ec.Type.IsDir(),
+	}, nil
+}
+
+// newEntry returns the entry of the file, it's nil if the file is neither a regular file nor a directory
+func (d *NfsDrive) newEntry(path string, fh []byte, attr *fattr) *nfsEntry {
+	if attr.ftype != typeReg && attr.ftype != typeDir {
+		return nil
+	}
+	return &nfsEntry{
+		d:       d,
+		path:    path,
+		fh:      fh,
+		size:    int64(attr.size),
+		isDir:   attr.ftype == typeDir,
+		modTime: utils.Millisecond(attr.mtime),
+	}
+}
+
+type nfsEntry struct {
+	d       *NfsDrive
+	path    string
+	fh      []byte
+	size    int64
+	isDir   bool
+	modTime int64
+}
+
+func (n *nfsEntry) Path() string {
+	return n.path
+}
+
+func (n *nfsEntry) Type() types.EntryType {
+	if n.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (n *nfsEntry) Size() int64 {
+	if n.isDir {
+		return -1
+	}
+	return n.size
+}
+
+func (n *nfsEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (n *nfsEntry) ModTime() int64 {
+	return n.modTime
+}
+
+func (n *nfsEntry) Drive() types.IDrive {
+	return n.d
+}
+
+func (n *nfsEntry) Name() string {
+	return utils.PathBase(n.path)
+}
+
+func (n *nfsEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return n.GetRangeReader(ctx, 0, -1)
+}
+
+func (n *nfsEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if n.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	return &readReader{ctx: ctx, d: n.d, fh: n.fh, offset: uint64(offset), remaining: length}, nil
+}
+
+func (n *nfsEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (n *nfsEntry) EntryData() types.SM {
+	return types.SM{"fh": hex.EncodeToString(n.fh)}
+}
+
+// readReader reads the file by READ, the data of a READ is buffered
+type readReader struct {
+	ctx    context.Context
+	d      *NfsDrive
+	fh     []byte
+	offset uint64
+	// remaining is the number of bytes to read, -1 to read to the end
+	remaining int64
+	buf       []byte
+	eof       bool
+}
+
+func (r *readReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.eof || r.remaining == 0 {
+			return 0, io.EOF
+		}
+		count := r.d.readSize
+		if r.remaining > 0 && r.remaining < int64(count) {
+			count = uint32(r.remaining)
+		}
+		var data []byte
+		var eof bool
+		e := r.d.pool.do(r.ctx, func(c *nfsConn) error {
+			var e error
+			data, eof, e = c.read(r.fh, r.offset, count)
+			return e
+		})
+		if e != nil {
+			return 0, toDriveError(e)
+		}
+		// no data without the end is treated as the end, to avoid reading forever
+		r.buf, r.eof = data, eof || len(data) == 0
+		r.offset += uint64(len(data))
+		if r.remaining > 0 {
+			r.remaining -= int64(len(data))
+		}
+		if len(data) == 0 {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *readReader) Close() error {
+	return nil
+}
+
+func isNotFound(e error) bool {
+	ne, ok := e.(nfsError)
+	return ok && (ne == errNoEnt || ne == errStale || ne == errBadHandle)
+}
+
+// toDriveError converts the error status to the errors of the drive
+func toDriveError(e error) error {
+	if ne, ok := e.(nfsError); ok {
+		switch ne {
+		case errNoEnt, errStale, errBadHandle:
+			return err.NewNotFoundError()
+		case errExist:
+			return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		case errPerm, errAccess, errReadOnlyFs:
+			return err.NewNotAllowedMessageError(i18n.T("drive.nfs.access_denied", ne.Error()))
+		case errNoSpace, errQuota:
+			return err.NewNotAllowedMessageError(i18n.T("drive.nfs.no_space"))
+		}
+		return err.NewRemoteApiError(500, i18n.T("drive.nfs.remote_error", ne.Error()))
+	}
+	if re, ok := e.(rpcError); ok {
+		return err.NewRemoteApiError(500, i18n.T("drive.nfs.remote_error", re.Error()))
+	}
+	return e
+}
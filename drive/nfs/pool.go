@@ -0,0 +1,98 @@
+package nfs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// idleCheckAfter is the idle time after which a pooled connection is checked by NULL before it's reused
+const idleCheckAfter = 30 * time.Second
+
+type dialOptions struct {
+	host string
+	port string
+	cred []byte
+}
+
+// connPool keeps the idle connections, and limits the number of connections in use
+type connPool struct {
+	opts *dialOptions
+	sem  chan struct{}
+
+	mux    sync.Mutex
+	idle   []*nfsConn
+	closed bool
+}
+
+func newConnPool(opts *dialOptions, maxConnections int) *connPool {
+	return &connPool{opts: opts, sem: make(chan struct{}, maxConnections)}
+}
+
+// get takes the latest idle RPC connection or dials the NFS port again, at most maxConnections are in use
+func (p *connPool) get(ctx context.Context) (*nfsConn, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	for {
+		p.mux.Lock()
+		if len(p.idle) == 0 {
+			p.mux.Unlock()
+			break
+		}
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mux.Unlock()
+		if time.Since(c.lastUsed) > idleCheckAfter {
+			if e := c.null(); e != nil {
+				c.close()
+				continue
+			}
+		}
+		return c, nil
+	}
+	rc, e := dialRPC(ctx, p.opts.host, p.opts.port, progNfs, 3, p.opts.cred)
+	if e != nil {
+		<-p.sem
+		return nil, e
+	}
+	return &nfsConn{rc}, nil
+}
+
+// put returns the connection to the pool, the broken connections are closed
+func (p *connPool) put(c *nfsConn, broken bool) {
+	defer func() { <-p.sem }()
+	p.mux.Lock()
+	if broken || p.closed || len(p.idle) >= cap(p.sem) {
+		p.mux.Unlock()
+		c.close()
+		return
+	}
+	p.idle = append(p.idle, c)
+	p.mux.Unlock()
+}
+
+// do runs fn with a connection, the connection is dropped if fn failed for other reasons than an error status
+func (p *connPool) do(ctx context.Context, fn func(c *nfsConn) error) error {
+	c, e := p.get(ctx)
+	if e != nil {
+		return e
+	}
+	e = fn(c)
+	_, status := e.(nfsError)
+	p.put(c, e != nil && !status)
+	return e
+}
+
+func (p *connPool) close() {
+	p.mux.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mux.Unlock()
+	for _, c := range idle {
+		c.close()
+	}
+}
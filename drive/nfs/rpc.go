@@ -0,0 +1,237 @@
+package nfs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	dialTimeout = 30 * time.Second
+	// replyTimeout is the time to wait for the reply of a call
+	replyTimeout = 60 * time.Second
+	// maxRecordSize limits the size of the replies, which are at most the max read size and the headers
+	maxRecordSize = 64 * 1024 * 1024
+	// reservedPortTries is the number of the reserved ports tried before falling back to any port
+	reservedPortTries = 10
+)
+
+// the programs of ONC RPC (RFC 5531)
+const (
+	progPortmap = 100000
+	progNfs     = 100003
+	progMount   = 100005
+
+	portmapPort = "111"
+	protoTCP    = 6
+)
+
+const (
+	rpcVersion   = 2
+	msgCall      = 0
+	msgReply     = 1
+	msgAccepted  = 0
+	msgDenied    = 1
+	lastFragment = 1 << 31
+
+	authNone = 0
+	authUnix = 1
+
+	acceptSuccess = 0
+	deniedAuth    = 1
+)
+
+// rpcError is the error replied by the RPC layer, like the rejected credential
+type rpcError struct {
+	msg string
+}
+
+func (r rpcError) Error() string {
+	return "rpc: " + r.msg
+}
+
+var acceptErrors = map[uint32]string{
+	1: "program unavailable",
+	2: "program version mismatch",
+	3: "procedure unavailable",
+	4: "garbage arguments",
+	5: "system error",
+}
+
+var authErrors = map[uint32]string{
+	1: "bad credential",
+	2: "rejected credential",
+	3: "bad verifier",
+	4: "rejected verifier",
+	5: "credential too weak",
+}
+
+// credNone is the AUTH_NONE credential
+var credNone = (&xdrWriter{}).uint32(authNone).uint32(0).bytes()
+
+// credUnix returns the AUTH_UNIX credential of uid and gid
+func credUnix(uid, gid uint32) []byte {
+	hostname, e := os.Hostname()
+	if e != nil || hostname == "" {
+		hostname = "go-drive"
+	}
+	if len(hostname) > 255 {
+		hostname = hostname[:255]
+	}
+	body := (&xdrWriter{}).uint32(uint32(time.Now().Unix())).string(hostname).
+		uint32(uid).uint32(gid).uint32(0)
+	return (&xdrWriter{}).uint32(authUnix).opaque(body.bytes()).bytes()
+}
+
+// rpcConn is a connection of ONC RPC over TCP to a program, it's not safe for concurrent use
+type rpcConn struct {
+	netConn net.Conn
+	prog    uint32
+	vers    uint32
+	cred    []byte
+	xid     uint32
+
+	lastUsed time.Time
+}
+
+func dialRPC(ctx context.Context, host, port string, prog, vers uint32, cred []byte) (*rpcConn, error) {
+	netConn, e := dialReserved(ctx, net.JoinHostPort(host, port))
+	if e != nil {
+		return nil, e
+	}
+	return &rpcConn{
+		netConn:  netConn,
+		prog:     prog,
+		vers:     vers,
+		cred:     cred,
+		xid:      rand.Uint32(),
+		lastUsed: time.Now(),
+	}, nil
+}
+
+// dialReserved dials from a reserved port, which is required by the exports with the 'secure' option,
+// it falls back to any port if binding the reserved ports is not permitted
+func dialReserved(ctx context.Context, addr string) (net.Conn, error) {
+	for i := 0; i < reservedPortTries; i++ {
+		dialer := &net.Dialer{
+			Timeout:   dialTimeout,
+			LocalAddr: &net.TCPAddr{Port: 600 + rand.Intn(1024-600)},
+		}
+		c, e := dialer.DialContext(ctx, "tcp", addr)
+		if e == nil {
+			return c, nil
+		}
+		if errors.Is(e, os.ErrPermission) {
+			break
+		}
+		if !errors.Is(e, syscall.EADDRINUSE) && !errors.Is(e, syscall.EADDRNOTAVAIL) {
+			return nil, e
+		}
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// call calls the procedure, and returns the reader of the results
+func (c *rpcConn) call(proc uint32, args []byte) (*xdrReader, error) {
+	c.xid++
+	xid := c.xid
+	w := (&xdrWriter{}).uint32(0).
+		uint32(xid).uint32(msgCall).uint32(rpcVersion).uint32(c.prog).uint32(c.vers).uint32(proc).
+		fixed(c.cred).fixed(credNone).fixed(args)
+	msg := w.bytes()
+	binary.BigEndian.PutUint32(msg, lastFragment|uint32(len(msg)-4))
+
+	_ = c.netConn.SetDeadline(time.Now().Add(replyTimeout))
+	if _, e := c.netConn.Write(msg); e != nil {
+		return nil, e
+	}
+	for {
+		record, e := c.readRecord()
+		if e != nil {
+			return nil, e
+		}
+		r := &xdrReader{b: record}
+		// skip the replies of the previous calls
+		if r.uint32() != xid {
+			continue
+		}
+		if r.uint32() != msgReply {
+			return nil, rpcError{msg: "unexpected message"}
+		}
+		c.lastUsed = time.Now()
+		switch r.uint32() {
+		case msgAccepted:
+			r.uint32()
+			r.opaque()
+			if stat := r.uint32(); stat != acceptSuccess {
+				return nil, rpcError{msg: acceptErrors[stat]}
+			}
+			return r, r.e
+		case msgDenied:
+			if r.uint32() == deniedAuth {
+				return nil, rpcError{msg: "authentication failed, " + authErrors[r.uint32()]}
+			}
+			return nil, rpcError{msg: "rpc version mismatch"}
+		}
+		return nil, rpcError{msg: "unexpected reply"}
+	}
+}
+
+// readRecord reads the fragments of a record
+func (c *rpcConn) readRecord() ([]byte, error) {
+	record := make([]byte, 0)
+	for {
+		var header [4]byte
+		if _, e := io.ReadFull(c.netConn, header[:]); e != nil {
+			return nil, e
+		}
+		mark := binary.BigEndian.Uint32(header[:])
+		size := int(mark &^ lastFragment)
+		if len(record)+size > maxRecordSize {
+			return nil, rpcError{msg: fmt.Sprintf("record too large: %d", len(record)+size)}
+		}
+		fragment := make([]byte, size)
+		if _, e := io.ReadFull(c.netConn, fragment); e != nil {
+			return nil, e
+		}
+		record = append(record, fragment...)
+		if mark&lastFragment != 0 {
+			return record, nil
+		}
+	}
+}
+
+func (c *rpcConn) close() {
+	_ = c.netConn.Close()
+}
+
+// getPort gets the TCP port of the program from the portmapper
+func getPort(ctx context.Context, host string, prog, vers uint32) (string, error) {
+	c, e := dialRPC(ctx, host, portmapPort, progPortmap, 2, credNone)
+	if e != nil {
+		return "", e
+	}
+	defer c.close()
+	// GETPORT(prog, vers, prot, port)
+	r, e := c.call(3, (&xdrWriter{}).uint32(prog).uint32(vers).uint32(protoTCP).uint32(0).bytes())
+	if e != nil {
+		return "", e
+	}
+	port := r.uint32()
+	if r.e != nil {
+		return "", r.e
+	}
+	if port == 0 {
+		return "", rpcError{msg: fmt.Sprintf("program %d version %d is not registered", prog, vers)}
+	}
+	return strconv.FormatUint(uint64(port), 10), nil
+}
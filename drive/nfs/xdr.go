@@ -0,0 +1,115 @@
+package nfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errShortReply = errors.New("nfs: short reply")
+
+// xdrWriter encodes the values in XDR (RFC 4506)
+type xdrWriter struct {
+	b bytes.Buffer
+}
+
+func (w *xdrWriter) uint32(v uint32) *xdrWriter {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.b.Write(b[:])
+	return w
+}
+
+func (w *xdrWriter) uint64(v uint64) *xdrWriter {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.b.Write(b[:])
+	return w
+}
+
+func (w *xdrWriter) bool(v bool) *xdrWriter {
+	if v {
+		return w.uint32(1)
+	}
+	return w.uint32(0)
+}
+
+// fixed writes the fixed-length opaque data
+func (w *xdrWriter) fixed(v []byte) *xdrWriter {
+	w.b.Write(v)
+	if pad := (4 - len(v)%4) % 4; pad > 0 {
+		w.b.Write(make([]byte, pad))
+	}
+	return w
+}
+
+// opaque writes the variable-length opaque data
+func (w *xdrWriter) opaque(v []byte) *xdrWriter {
+	return w.uint32(uint32(len(v))).fixed(v)
+}
+
+func (w *xdrWriter) string(v string) *xdrWriter {
+	return w.opaque([]byte(v))
+}
+
+func (w *xdrWriter) bytes() []byte {
+	return w.b.Bytes()
+}
+
+// xdrReader decodes the values in XDR, the first error is kept, and the following reads return zero values
+type xdrReader struct {
+	b []byte
+	e error
+}
+
+func (r *xdrReader) next(n int) []byte {
+	if r.e != nil {
+		return nil
+	}
+	if n < 0 || len(r.b) < n {
+		r.e = errShortReply
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *xdrReader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *xdrReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *xdrReader) bool() bool {
+	return r.uint32() != 0
+}
+
+func (r *xdrReader) fixed(n int) []byte {
+	v := r.next(n)
+	r.next((4 - n%4) % 4)
+	return v
+}
+
+func (r *xdrReader) opaque() []byte {
+	n := r.uint32()
+	if r.e == nil && int64(n) > int64(len(r.b)) {
+		r.e = errShortReply
+		return nil
+	}
+	return r.fixed(int(n))
+}
+
+func (r *xdrReader) string() string {
+	return string(r.opaque())
+}
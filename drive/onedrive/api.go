@@ -5,6 +5,7 @@ import (
 	"go-drive/common/utils"
 	"net/url"
 	path2 "path"
+	"strings"
 	"time"
 )
 
@@ -20,6 +21,7 @@ type userProfile struct {
 
 type driveInfo struct {
 	Id        string `json:"id"`
+	Name      string `json:"name"`
 	DriveType string `json:"driveType"`
 	Quota     struct {
 		Total int64 `json:"total"`
@@ -31,6 +33,19 @@ type userDrives struct {
 	Drives []driveInfo `json:"value"`
 }
 
+// https://docs.microsoft.com/en-us/graph/api/resources/site?view=graph-rest-1.0
+type siteInfo struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	WebURL      string `json:"webUrl"`
+}
+
+type sites struct {
+	Sites    []siteInfo `json:"value"`
+	NextLink string     `json:"@odata.nextLink"`
+}
+
 // https://docs.microsoft.com/en-us/graph/api/resources/audio?view=graph-rest-1.0
 type audioInfo struct {
 	Duration int    `json:"duration"`
@@ -106,16 +121,23 @@ type driveItem struct {
 	Thumbnails []thumbnailInfo `json:"thumbnails"`
 
 	Parent struct {
-		Id   string `json:"id"`
-		Path string `json:"path"`
+		Id      string `json:"id"`
+		DriveId string `json:"driveId"`
+		Path    string `json:"path"`
 	} `json:"parentReference"`
+
+	// RemoteItem is the item in another drive, which is shared with the account
+	RemoteItem *driveItem `json:"remoteItem"`
 }
 
 func (d driveItem) Path() string {
-	// Remove parent prefix /drive/root:
-	parentPath, e := url.PathUnescape(d.Parent.Path[12:])
-	if e != nil {
-		parentPath = d.Parent.Path[12:]
+	// Remove parent prefix /drive/root: or /drives/{drive-id}/root:
+	parentPath := d.Parent.Path
+	if i := strings.Index(parentPath, "root:"); i >= 0 {
+		parentPath = parentPath[i+5:]
+	}
+	if p, e := url.PathUnescape(parentPath); e == nil {
+		parentPath = p
 	}
 	return utils.CleanPath(path2.Join(parentPath, d.Name))
 }
@@ -0,0 +1,24 @@
+package onedrive
+
+import "testing"
+
+func TestDriveItemPath(t *testing.T) {
+	cases := []struct {
+		parent string
+		name   string
+		expect string
+	}{
+		{"/drive/root:", "a.txt", "a.txt"},
+		{"/drive/root:/dir/sub%20dir", "a.txt", "dir/sub dir/a.txt"},
+		// the items of the SharePoint document libraries
+		{"/drives/b!AbC-123/root:", "a.txt", "a.txt"},
+		{"/drives/b!AbC-123/root:/Shared%20Documents", "a.txt", "Shared Documents/a.txt"},
+	}
+	for _, c := range cases {
+		item := driveItem{Name: c.name}
+		item.Parent.Path = c.parent
+		if p := item.Path(); p != c.expect {
+			t.Errorf("path of %s/%s: expected '%s', got '%s'", c.parent, c.name, c.expect, p)
+		}
+	}
+}
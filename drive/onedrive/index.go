@@ -13,6 +13,7 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,9 +25,13 @@ func init() {
 		ConfigForm: []types.FormItem{
 			{Field: "client_id", Label: i18n.T("drive.onedrive.form.client_id.label"), Type: "text", Required: true},
 			{Field: "client_secret", Label: i18n.T("drive.onedrive.form.client_secret.label"), Type: "password", Required: true},
+			{Field: "tenant", Label: i18n.T("drive.onedrive.form.tenant.label"), Type: "text", Description: i18n.T("drive.onedrive.form.tenant.description")},
 			{Field: "proxy_upload", Label: i18n.T("drive.onedrive.form.proxy_in.label"), Type: "checkbox", Description: i18n.T("drive.onedrive.form.proxy_in.description")},
 			{Field: "proxy_download", Label: i18n.T("drive.onedrive.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.onedrive.form.proxy_out.description")},
+			{Field: "proxy_range", Label: i18n.T("drive.onedrive.form.proxy_range.label"), Type: "checkbox", Description: i18n.T("drive.onedrive.form.proxy_range.description")},
 			{Field: "cache_ttl", Label: i18n.T("drive.onedrive.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.onedrive.form.cache_ttl.description")},
+			{Field: "prewarm_depth", Label: i18n.T("drive.onedrive.form.prewarm_depth.label"), Type: "text", Description: i18n.T("drive.onedrive.form.prewarm_depth.description")},
+			{Field: "shared_folder", Label: i18n.T("drive.onedrive.form.shared_folder.label"), Type: "text", Description: i18n.T("drive.onedrive.form.shared_folder.description")},
 		},
 		Factory: drive_util.DriveFactory{Create: NewOneDrive, InitConfig: InitConfig, Init: Init},
 	})
@@ -36,23 +41,30 @@ type OneDrive struct {
 	driveId string
 
 	c *req.Client
+	// graph is the client of the Graph API, which is used to access the items shared with the account
+	graph *req.Client
+	// sharedFolder is the name of the top-level virtual folder of the items shared with the account
+	sharedFolder string
 
-	cacheTTL time.Duration
-	cache    drive_util.DriveCache
+	cacheTTL    time.Duration
+	cache       drive_util.DriveCache
+	stopPrewarm func()
 
 	uploadProxy   bool
 	downloadProxy bool
+	rangeProxy    bool
 }
 
 func NewOneDrive(_ context.Context, config drive_util.DriveConfig,
 	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
-	resp, e := drive_util.OAuthGet(*oauthReq(driveUtils.Config), config, driveUtils.Data)
+	resp, e := drive_util.OAuthGet(*oauthReq(driveUtils.Config, config), config, driveUtils.Data)
 	if e != nil {
 		return nil, e
 	}
 
 	proxyUpload := config["proxy_upload"]
 	proxyDownload := config["proxy_download"]
+	proxyRange := config["proxy_range"]
 	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
 	if e != nil {
 		cacheTtl = -1
@@ -64,6 +76,11 @@ func NewOneDrive(_ context.Context, config drive_util.DriveConfig,
 		cacheTTL:      cacheTtl,
 		uploadProxy:   proxyUpload != "",
 		downloadProxy: proxyDownload != "",
+		rangeProxy:    proxyRange != "",
+		sharedFolder:  utils.CleanPath(config["shared_folder"]),
+	}
+	if strings.Contains(od.sharedFolder, "/") {
+		return nil, err.NewBadRequestError(i18n.T("drive.onedrive.invalid_shared_folder", od.sharedFolder))
 	}
 	if cacheTtl <= 0 {
 		od.cache = drive_util.DummyCache()
@@ -75,11 +92,29 @@ func NewOneDrive(_ context.Context, config drive_util.DriveConfig,
 		return nil, err.NewNotAllowedMessageError(i18n.T("drive.onedrive.drive_not_selected"))
 	}
 
+	client := resp.Client(nil)
 	od.c, e = req.NewClient(
 		utils.BuildURL("https://graph.microsoft.com/v1.0/drives/{}", od.driveId),
-		nil, ifApiCallError, resp.Client(nil))
+		nil, ifApiCallError, client)
+	if e != nil {
+		return nil, e
+	}
+	od.graph, e = req.NewClient("https://graph.microsoft.com/v1.0", nil, ifApiCallError, client)
+	if e != nil {
+		return nil, e
+	}
 
-	return od, e
+	if depth := utils.ToInt(config["prewarm_depth"], 0); depth > 0 && cacheTtl > 0 {
+		od.stopPrewarm = drive_util.Prewarm(od, depth)
+	}
+	return od, nil
+}
+
+func (o *OneDrive) Dispose() error {
+	if o.stopPrewarm != nil {
+		o.stopPrewarm()
+	}
+	return nil
 }
 
 func (o *OneDrive) Meta(context.Context) types.DriveMeta {
@@ -88,7 +123,10 @@ func (o *OneDrive) Meta(context.Context) types.DriveMeta {
 
 func (o *OneDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
 	if utils.IsRootPath(path) {
-		return &oneDriveEntry{id: "root", path: path, isDir: true}, nil
+		return &oneDriveEntry{d: o, id: "root", path: path, isDir: true}, nil
+	}
+	if o.isShared(path) {
+		return o.getShared(ctx, path)
 	}
 	if cached, _ := o.cache.GetEntry(path); cached != nil {
 		return cached, nil
@@ -104,8 +142,23 @@ func (o *OneDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
 	return entry, nil
 }
 
+// ResolveID finds the entry by its OneDrive item id
+func (o *OneDrive) ResolveID(ctx context.Context, id string) (types.IEntry, error) {
+	if id == "" {
+		return nil, err.NewNotFoundError()
+	}
+	resp, e := o.c.Get(ctx, idURL(id)+"?expand=thumbnails", nil)
+	if e != nil {
+		return nil, e
+	}
+	return o.toEntry(resp)
+}
+
 func (o *OneDrive) Save(ctx types.TaskCtx, path string, size int64,
 	override bool, reader io.Reader) (types.IEntry, error) {
+	if e := o.requireNotShared(path); e != nil {
+		return nil, e
+	}
 	var entry *oneDriveEntry = nil
 	get, e := o.Get(ctx, path)
 	if e != nil && !err.IsNotFoundError(e) {
@@ -143,6 +196,9 @@ func (o *OneDrive) Save(ctx types.TaskCtx, path string, size int64,
 }
 
 func (o *OneDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if e := o.requireNotShared(path); e != nil {
+		return nil, e
+	}
 	if dir, e := o.Get(ctx, path); e == nil {
 		if !dir.Type().IsDir() {
 			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
@@ -165,43 +221,45 @@ func (o *OneDrive) MakeDir(ctx context.Context, path string) (types.IEntry, erro
 
 func (o *OneDrive) isSelf(e types.IEntry) bool {
 	if fe, ok := e.(*oneDriveEntry); ok {
-		return fe.d == o
+		return fe.d == o && !o.isShared(fe.path)
 	}
 	return false
 }
 
 func (o *OneDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	return drive_util.NativeCopy(ctx, o, from, to, override)
+}
+
+// CopyAsync starts copying on OneDrive, the returned handle polls the monitor URL of the copy
+func (o *OneDrive) CopyAsync(ctx context.Context, from types.IEntry, to string, override bool) (types.IAsyncOp, error) {
 	from = drive_util.GetIEntry(from, o.isSelf)
 	if from == nil {
 		return nil, err.NewUnsupportedError()
 	}
+	if e := o.requireNotShared(to); e != nil {
+		return nil, e
+	}
 	if !override {
 		if _, e := drive_util.RequireFileNotExists(ctx, o, to); e != nil {
 			return nil, e
 		}
 	}
-	ctx.Total(from.Size(), false)
 	toParentPath := utils.PathParent(to)
 	toName := utils.PathBase(to)
 	resp, e := o.c.Post(ctx, idURL(from.(*oneDriveEntry).id)+"/copy", nil, req.NewJsonBody(types.M{
-		"parentReference": types.M{"path": itemPath(toParentPath)},
+		"parentReference": types.M{"path": o.itemPath(toParentPath)},
 		"name":            toName,
 	}))
 	if e != nil {
 		return nil, e
 	}
 	_ = resp.Dispose()
+	op := &copyOp{o: o, to: to, done: true}
 	if resp.Status() == 202 {
-		// we should wait for it to finish
-		waitUrl := resp.Response().Header.Get("Location")
-		if e := waitLongRunningAction(ctx, waitUrl); e != nil {
-			return nil, e
-		}
+		op.monitorUrl = resp.Response().Header.Get("Location")
+		op.done = false
 	}
-	ctx.Progress(from.Size(), false)
-	_ = o.cache.Evict(to, true)
-	_ = o.cache.Evict(utils.PathParent(to), false)
-	return o.Get(ctx, to)
+	return op, nil
 }
 
 func (o *OneDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
@@ -209,6 +267,9 @@ func (o *OneDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, overrid
 	if from == nil {
 		return nil, err.NewUnsupportedError()
 	}
+	if e := o.requireNotShared(to); e != nil {
+		return nil, e
+	}
 	if !override {
 		if _, e := drive_util.RequireFileNotExists(ctx, o, to); e != nil {
 			return nil, e
@@ -219,7 +280,7 @@ func (o *OneDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, overrid
 	toName := utils.PathBase(to)
 	resp, e := o.c.Request(ctx, "PATCH", idURL(from.(*oneDriveEntry).id), nil,
 		req.NewJsonBody(types.M{
-			"parentReference": types.M{"path": itemPath(toParentPath)},
+			"parentReference": types.M{"path": o.itemPath(toParentPath)},
 			"name":            toName,
 		}),
 	)
@@ -234,8 +295,11 @@ func (o *OneDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, overrid
 }
 
 func (o *OneDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if o.isShared(path) {
+		return o.listShared(ctx, path)
+	}
 	if cached, _ := o.cache.GetChildren(path); cached != nil {
-		return cached, nil
+		return o.withSharedRoot(path, cached), nil
 	}
 	reqPath := pathURL(path) + "/children?$expand=thumbnails"
 	res := driveItems{}
@@ -254,10 +318,21 @@ func (o *OneDrive) List(ctx context.Context, path string) ([]types.IEntry, error
 		entries = append(entries, o.newEntry(v))
 	}
 	_ = o.cache.PutChildren(path, entries, o.cacheTTL)
-	return entries, nil
+	return o.withSharedRoot(path, entries), nil
+}
+
+// withSharedRoot appends the shared folder to the entries of the root, which is not cached
+func (o *OneDrive) withSharedRoot(path string, entries []types.IEntry) []types.IEntry {
+	if o.sharedFolder == "" || !utils.IsRootPath(path) {
+		return entries
+	}
+	return append(entries, o.sharedRoot())
 }
 
 func (o *OneDrive) Delete(ctx types.TaskCtx, path string) error {
+	if e := o.requireNotShared(path); e != nil {
+		return e
+	}
 	entry, e := o.Get(ctx, path)
 	if e != nil {
 		return e
@@ -274,6 +349,9 @@ func (o *OneDrive) Delete(ctx types.TaskCtx, path string) error {
 
 func (o *OneDrive) Upload(ctx context.Context, path string, size int64,
 	override bool, config types.SM) (*types.DriveUploadConfig, error) {
+	if e := o.requireNotShared(path); e != nil {
+		return nil, e
+	}
 	if !override {
 		if _, e := drive_util.RequireFileNotExists(ctx, o, path); e != nil {
 			return nil, e
@@ -313,6 +391,14 @@ func (o *OneDrive) newEntry(item driveItem) *oneDriveEntry {
 		item.Thumbnails[0].Large != nil {
 		thumbnailUrl = item.Thumbnails[0].Large.URL
 	}
+	hashAlgorithm, hash := "", ""
+	if item.File != nil {
+		if item.File.Hashes.Sha1Hash != "" {
+			hashAlgorithm, hash = "sha1", strings.ToLower(item.File.Hashes.Sha1Hash)
+		} else if item.File.Hashes.QuickXorHash != "" {
+			hashAlgorithm, hash = "quickxor", item.File.Hashes.QuickXorHash
+		}
+	}
 	return &oneDriveEntry{
 		id:                   item.Id,
 		path:                 item.Path(),
@@ -321,6 +407,8 @@ func (o *OneDrive) newEntry(item driveItem) *oneDriveEntry {
 		modTime:              utils.Millisecond(modTime),
 		d:                    o,
 		thumbnail:            thumbnailUrl,
+		hashAlgorithm:        hashAlgorithm,
+		hash:                 hash,
 		downloadUrl:          item.DownloadURL,
 		downloadUrlExpiresAt: time.Now().Add(downloadUrlTTL).Unix(),
 	}
@@ -333,9 +421,14 @@ type oneDriveEntry struct {
 	size    int64
 	modTime int64
 	d       *OneDrive
+	// remoteDrive is the id of the drive of the item shared with the account
+	remoteDrive string
 
 	thumbnail string
 
+	hashAlgorithm string
+	hash          string
+
 	downloadUrl          string
 	downloadUrlExpiresAt int64
 }
@@ -357,7 +450,7 @@ func (o *oneDriveEntry) Size() int64 {
 
 func (o *oneDriveEntry) Meta() types.EntryMeta {
 	return types.EntryMeta{
-		CanRead: true, CanWrite: true,
+		CanRead: true, CanWrite: !o.d.isShared(o.path),
 		Thumbnail: o.thumbnail,
 	}
 }
@@ -387,8 +480,14 @@ func (o *oneDriveEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
 		return nil, err.NewNotAllowedError()
 	}
 	u := o.downloadUrl
-	if o.downloadUrlExpiresAt <= time.Now().Unix() {
-		resp, e := o.d.c.Get(ctx, pathURL(o.path)+"/content", nil)
+	if o.downloadUrl == "" || o.downloadUrlExpiresAt <= time.Now().Unix() {
+		var resp req.Response
+		var e error
+		if o.remoteDrive != "" {
+			resp, e = o.d.graph.Get(ctx, utils.BuildURL("/drives/{}/items/{}/content", o.remoteDrive, o.id), nil)
+		} else {
+			resp, e = o.d.c.Get(ctx, pathURL(o.path)+"/content", nil)
+		}
 		if e != nil {
 			return nil, e
 		}
@@ -400,7 +499,7 @@ func (o *oneDriveEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
 		o.downloadUrlExpiresAt = time.Now().Add(downloadUrlTTL).Unix()
 		_ = o.d.cache.PutEntry(o, o.d.cacheTTL)
 	}
-	return &types.ContentURL{URL: o.downloadUrl, Proxy: o.d.downloadProxy}, nil
+	return &types.ContentURL{URL: o.downloadUrl, Proxy: o.d.downloadProxy, ProxyRange: o.d.rangeProxy}, nil
 }
 
 func (o *oneDriveEntry) EntryData() types.SM {
@@ -409,5 +508,19 @@ func (o *oneDriveEntry) EntryData() types.SM {
 		"du": o.downloadUrl,
 		"de": strconv.FormatInt(o.downloadUrlExpiresAt, 10),
 		"th": o.thumbnail,
+		"ha": o.hashAlgorithm,
+		"hv": o.hash,
+		"rd": o.remoteDrive,
+	}
+}
+
+func (o *oneDriveEntry) StableID() string {
+	return o.id
+}
+
+func (o *oneDriveEntry) ContentHash(context.Context) (string, string, error) {
+	if o.hash == "" {
+		return "", "", err.NewUnsupportedError()
 	}
+	return o.hashAlgorithm, o.hash, nil
 }
@@ -0,0 +1,142 @@
+package onedrive
+
+import (
+	"context"
+	"fmt"
+	"go-drive/common/errors"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	path2 "path"
+	"strings"
+)
+
+// isShared returns whether path is in the virtual folder of the items shared with the account
+func (o *OneDrive) isShared(path string) bool {
+	return o.sharedFolder != "" && (path == o.sharedFolder || strings.HasPrefix(path, o.sharedFolder+"/"))
+}
+
+func (o *OneDrive) sharedRoot() *oneDriveEntry {
+	return &oneDriveEntry{d: o, path: o.sharedFolder, isDir: true, modTime: -1}
+}
+
+// requireNotShared returns error if path is in the shared folder, which is read-only
+func (o *OneDrive) requireNotShared(path string) error {
+	if o.isShared(path) {
+		return err.NewNotAllowedError()
+	}
+	return nil
+}
+
+// getShared resolves the shared item, or the item inside a shared folder by the path relative to the shared folder
+func (o *OneDrive) getShared(ctx context.Context, path string) (types.IEntry, error) {
+	if path == o.sharedFolder {
+		return o.sharedRoot(), nil
+	}
+	if cached, _ := o.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	segments := strings.SplitN(path[len(o.sharedFolder)+1:], "/", 2)
+	shared, e := o.List(ctx, o.sharedFolder)
+	if e != nil {
+		return nil, e
+	}
+	var top *oneDriveEntry
+	for _, s := range shared {
+		if utils.PathBase(s.Path()) == segments[0] {
+			top = s.(*oneDriveEntry)
+			break
+		}
+	}
+	if top == nil || (len(segments) > 1 && !top.isDir) {
+		return nil, err.NewNotFoundError()
+	}
+	if len(segments) == 1 {
+		return top, nil
+	}
+	resp, e := o.graph.Get(ctx, utils.BuildURL("/drives/{}/items/{}:/{}:",
+		top.remoteDrive, top.id, segments[1])+"?expand=thumbnails", nil)
+	if e != nil {
+		return nil, e
+	}
+	item := driveItem{}
+	if e := resp.Json(&item); e != nil {
+		return nil, e
+	}
+	entry := o.newRemoteEntry(path, top.remoteDrive, item)
+	_ = o.cache.PutEntry(entry, o.cacheTTL)
+	return entry, nil
+}
+
+func (o *OneDrive) listShared(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := o.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	res := driveItems{}
+	var entries []types.IEntry
+	if path == o.sharedFolder {
+		resp, e := o.graph.Get(ctx, "/me/drive/sharedWithMe", nil)
+		if e != nil {
+			return nil, e
+		}
+		if e := resp.Json(&res); e != nil {
+			return nil, e
+		}
+		entries = make([]types.IEntry, 0, len(res.Items))
+		names := make(map[string]bool)
+		for _, v := range res.Items {
+			if v.RemoteItem == nil {
+				continue
+			}
+			name := sharedName(names, v.Name, v.RemoteItem.Id)
+			entries = append(entries, o.newRemoteEntry(
+				path2.Join(path, name), v.RemoteItem.Parent.DriveId, *v.RemoteItem))
+		}
+	} else {
+		dir, e := o.getShared(ctx, path)
+		if e != nil {
+			return nil, e
+		}
+		de := dir.(*oneDriveEntry)
+		if !de.isDir {
+			return nil, err.NewNotAllowedError()
+		}
+		resp, e := o.graph.Get(ctx, utils.BuildURL("/drives/{}/items/{}/children",
+			de.remoteDrive, de.id)+"?$expand=thumbnails", nil)
+		if e != nil {
+			return nil, e
+		}
+		if e := resp.Json(&res); e != nil {
+			return nil, e
+		}
+		entries = make([]types.IEntry, 0, len(res.Items))
+		for _, v := range res.Items {
+			if v.Deleted != nil {
+				continue
+			}
+			entries = append(entries, o.newRemoteEntry(path2.Join(path, v.Name), de.remoteDrive, v))
+		}
+	}
+	_ = o.cache.PutChildren(path, entries, o.cacheTTL)
+	return entries, nil
+}
+
+// newRemoteEntry creates the entry of the item in the drive driveId, which is at path in the shared folder
+func (o *OneDrive) newRemoteEntry(path, driveId string, item driveItem) *oneDriveEntry {
+	entry := o.newEntry(item)
+	entry.path = path
+	entry.remoteDrive = driveId
+	return entry
+}
+
+// sharedName returns the name not in names, as the items shared by different users may have the same name
+func sharedName(names map[string]bool, name, id string) string {
+	if names[name] {
+		if len(id) > 8 {
+			id = id[len(id)-8:]
+		}
+		ext := path2.Ext(name)
+		name = fmt.Sprintf("%s (%s)%s", strings.TrimSuffix(name, ext), id, ext)
+	}
+	names[name] = true
+	return name
+}
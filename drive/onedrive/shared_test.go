@@ -0,0 +1,92 @@
+package onedrive
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/req"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOneDriveShared(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/me/drive/sharedWithMe":
+			_, _ = w.Write([]byte(`{"value":[
+				{"name":"Docs","remoteItem":{"id":"d1","name":"Docs","folder":{},"parentReference":{"driveId":"rd"}}},
+				{"name":"a.txt","remoteItem":{"id":"f1","name":"a.txt","size":3,"file":{},"parentReference":{"driveId":"rd2"}}},
+				{"name":"a.txt","remoteItem":{"id":"f2345678xyz","name":"a.txt","size":4,"file":{},"parentReference":{"driveId":"rd2"}}}
+			]}`))
+		case "/drives/rd/items/d1/children":
+			_, _ = w.Write([]byte(`{"value":[{"id":"s1","name":"sub","folder":{},"parentReference":{"driveId":"rd","path":"/drives/rd/root:/Docs"}}]}`))
+		case "/drives/rd/items/d1:/sub/b.txt:":
+			_, _ = w.Write([]byte(`{"id":"b1","name":"b.txt","size":5,"file":{},"parentReference":{"driveId":"rd","path":"/drives/rd/root:/Docs/sub"}}`))
+		case "/drives/rd/items/b1/content":
+			w.Header().Set("Location", "https://download/b1")
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":"itemNotFound","message":"not found"}}`))
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	graph, e := req.NewClient(server.URL, nil, ifApiCallError, client)
+	if e != nil {
+		t.Fatal(e)
+	}
+	o := &OneDrive{graph: graph, sharedFolder: "Shared", cache: drive_util.DummyCache()}
+	ctx := context.Background()
+
+	entries, e := o.List(ctx, "Shared")
+	if e != nil {
+		t.Fatal(e)
+	}
+	names := make([]string, 0)
+	for _, entry := range entries {
+		names = append(names, entry.Path())
+	}
+	expected := []string{"Shared/Docs", "Shared/a.txt", "Shared/a (45678xyz).txt"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, names)
+		}
+	}
+
+	entries, e = o.List(ctx, "Shared/Docs")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 1 || entries[0].Path() != "Shared/Docs/sub" || !entries[0].Type().IsDir() {
+		t.Errorf("unexpected entries of Shared/Docs: %v", entries)
+	}
+
+	entry, e := o.Get(ctx, "Shared/Docs/sub/b.txt")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if entry.Path() != "Shared/Docs/sub/b.txt" || entry.Size() != 5 || entry.Meta().CanWrite {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+	u, e := entry.(*oneDriveEntry).GetURL(ctx)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if u.URL != "https://download/b1" {
+		t.Errorf("unexpected url: %s", u.URL)
+	}
+
+	if _, e := o.Get(ctx, "Shared/none"); !err.IsNotFoundError(e) {
+		t.Errorf("expected not found, got %v", e)
+	}
+	if _, e := o.MakeDir(ctx, "Shared/Docs/new"); e == nil {
+		t.Error("expected the shared folder to be read-only")
+	}
+}
@@ -9,7 +9,6 @@ import (
 	err "go-drive/common/errors"
 	"go-drive/common/i18n"
 	"go-drive/common/req"
-	"go-drive/common/task"
 	"go-drive/common/types"
 	"go-drive/common/utils"
 	"golang.org/x/oauth2"
@@ -17,18 +16,33 @@ import (
 	path2 "path"
 	"strconv"
 	"strings"
-	"time"
 )
 
-func oauthReq(c common.Config) *drive_util.OAuthRequest {
+// defaultTenant is the tenant of the personal accounts
+const defaultTenant = "consumers"
+
+func tenantOf(config drive_util.DriveConfig) string {
+	if t := strings.TrimSpace(config["tenant"]); t != "" {
+		return t
+	}
+	return defaultTenant
+}
+
+func oauthReq(c common.Config, config drive_util.DriveConfig) *drive_util.OAuthRequest {
+	tenant := tenantOf(config)
+	scopes := []string{"Files.ReadWrite", "offline_access", "User.Read"}
+	if tenant != defaultTenant {
+		// the SharePoint sites are only available to the work or school accounts
+		scopes = append(scopes, "Sites.ReadWrite.All")
+	}
 	return &drive_util.OAuthRequest{
 		Endpoint: oauth2.Endpoint{
-			AuthURL:   "https://login.microsoftonline.com/consumers/oauth2/v2.0/authorize",
-			TokenURL:  "https://login.microsoftonline.com/consumers/oauth2/v2.0/token",
+			AuthURL:   utils.BuildURL("https://login.microsoftonline.com/{}/oauth2/v2.0/authorize", tenant),
+			TokenURL:  utils.BuildURL("https://login.microsoftonline.com/{}/oauth2/v2.0/token", tenant),
 			AuthStyle: oauth2.AuthStyleInParams,
 		},
 		RedirectURL: c.OAuthRedirectURI,
-		Scopes:      []string{"Files.ReadWrite", "offline_access", "User.Read"},
+		Scopes:      scopes,
 		Text:        i18n.T("drive.onedrive.oauth_text"),
 	}
 }
@@ -59,16 +73,16 @@ func idURL(id string) string {
 	return utils.BuildURL("/items/{}", id)
 }
 
-func itemPath(path string) string {
+func (o *OneDrive) itemPath(path string) string {
 	if utils.IsRootPath(path) {
-		return "/drive/root:"
+		return "/drives/" + o.driveId + "/root:"
 	}
-	return "/drive/root:/" + path
+	return "/drives/" + o.driveId + "/root:/" + path
 }
 
 func InitConfig(ctx context.Context, config drive_util.DriveConfig,
 	driveUtils drive_util.DriveUtils) (*drive_util.DriveInitConfig, error) {
-	initConfig, resp, e := drive_util.OAuthInitConfig(*oauthReq(driveUtils.Config), config, driveUtils.Data)
+	initConfig, resp, e := drive_util.OAuthInitConfig(*oauthReq(driveUtils.Config, config), config, driveUtils.Data)
 	if e != nil {
 		return nil, e
 	}
@@ -96,22 +110,19 @@ func InitConfig(ctx context.Context, config drive_util.DriveConfig,
 	if initConfig.Configured {
 		drives, e := getDrives(ctx, reqClient)
 		initConfig.Configured = e == nil
+		var opts []types.FormItemOption
 		if e == nil {
-			opts := make([]types.FormItemOption, len(drives))
 			for i, d := range drives {
-				used := "-"
-				if d.Quota.Total != 0 {
-					used = fmt.Sprintf("%.1f%%", float64(d.Quota.Used)/float64(d.Quota.Total)*100)
-				}
-				opts[i] = types.FormItemOption{
-					Name: fmt.Sprintf("%s %d", d.DriveType, i+1),
-					Title: i18n.T("drive.onedrive.drive_used",
-						utils.FormatBytes(uint64(d.Quota.Used), 1),
-						utils.FormatBytes(uint64(d.Quota.Total), 1),
-						used),
-					Value: d.Id,
-				}
+				opts = append(opts, driveOption(fmt.Sprintf("%s %d", d.DriveType, i+1), d))
 			}
+		}
+		// the document libraries of the SharePoint sites
+		if initConfig.Configured && tenantOf(config) != defaultTenant {
+			siteOpts, e := getSiteDriveOptions(ctx, reqClient)
+			initConfig.Configured = e == nil
+			opts = append(opts, siteOpts...)
+		}
+		if initConfig.Configured {
 			initConfig.Form = []types.FormItem{
 				{Label: i18n.T("drive.onedrive.drive_select"), Type: "select", Field: "drive_id", Required: true, Options: opts},
 			}
@@ -127,7 +138,7 @@ func InitConfig(ctx context.Context, config drive_util.DriveConfig,
 }
 
 func Init(ctx context.Context, data types.SM, config drive_util.DriveConfig, utils drive_util.DriveUtils) error {
-	_, e := drive_util.OAuthInit(ctx, *oauthReq(utils.Config), data, config, utils.Data)
+	_, e := drive_util.OAuthInit(ctx, *oauthReq(utils.Config, config), data, config, utils.Data)
 	if e != nil {
 		return e
 	}
@@ -162,6 +173,66 @@ func getDrives(ctx context.Context, req *req.Client) ([]driveInfo, error) {
 	return o.Drives, nil
 }
 
+func driveOption(name string, d driveInfo) types.FormItemOption {
+	used := "-"
+	if d.Quota.Total != 0 {
+		used = fmt.Sprintf("%.1f%%", float64(d.Quota.Used)/float64(d.Quota.Total)*100)
+	}
+	return types.FormItemOption{
+		Name: name,
+		Title: i18n.T("drive.onedrive.drive_used",
+			utils.FormatBytes(uint64(d.Quota.Used), 1),
+			utils.FormatBytes(uint64(d.Quota.Total), 1),
+			used),
+		Value: d.Id,
+	}
+}
+
+// getSiteDriveOptions returns the options of the document libraries of all the SharePoint sites
+func getSiteDriveOptions(ctx context.Context, req *req.Client) ([]types.FormItemOption, error) {
+	sites, e := getSites(ctx, req)
+	if e != nil {
+		return nil, e
+	}
+	opts := make([]types.FormItemOption, 0)
+	for _, s := range sites {
+		o := userDrives{}
+		resp, e := req.Get(ctx, utils.BuildURL("https://graph.microsoft.com/v1.0/sites/{}/drives", s.Id), nil)
+		if e != nil {
+			return nil, e
+		}
+		if e := resp.Json(&o); e != nil {
+			return nil, e
+		}
+		siteName := s.DisplayName
+		if siteName == "" {
+			siteName = s.Name
+		}
+		for _, d := range o.Drives {
+			opts = append(opts, driveOption(siteName+" / "+d.Name, d))
+		}
+	}
+	return opts, nil
+}
+
+func getSites(ctx context.Context, req *req.Client) ([]siteInfo, error) {
+	result := make([]siteInfo, 0)
+	next := "https://graph.microsoft.com/v1.0/sites?search=*"
+	for next != "" {
+		o := sites{}
+		resp, e := req.Get(ctx, next, nil)
+		if e != nil {
+			return nil, e
+		}
+		if e := resp.Json(&o); e != nil {
+			return nil, e
+		}
+		result = append(result, o.Sites...)
+		next = o.NextLink
+	}
+	return result, nil
+}
+
 // uploadSmallFile uploads a new file that less than 4Mb
 func (o *OneDrive) uploadSmallFile(ctx types.TaskCtx,
 	parentId, filename string, size int64, reader io.Reader) (*oneDriveEntry, error) {
@@ -197,9 +268,9 @@ func (o *OneDrive) uploadLargeFile(ctx types.TaskCtx,
 	chunkSize := int64(uploadChunkSize)
 	var finalResp req.Response = nil
 	for s := int64(0); s < size; s += chunkSize {
-		if ctx.Canceled() {
+		if e := ctx.WaitIfPaused(); e != nil {
 			_ = deleteUploadSession(ctx, sessionUrl)
-			return nil, task.ErrorCanceled
+			return nil, e
 		}
 		end := s + chunkSize
 		if end > size {
@@ -252,24 +323,40 @@ func deleteUploadSession(ctx context.Context, sessionUrl string) error {
 	return e
 }
 
-func waitLongRunningAction(ctx context.Context, waitUrl string) error {
-	for {
-		resp, e := httpApi.Get(ctx, waitUrl, nil)
-		if e != nil {
-			return e
-		}
-		s := actionProgress{}
-		if e := resp.Json(&s); e != nil {
-			return e
-		}
-		if s.Status != "inProgress" && s.Status != "notStarted" {
-			if s.Status != "completed" {
-				return errors.New(i18n.T("drive.onedrive.unknown_action_status", s.Status))
-			}
-			return nil
-		}
-		time.Sleep(2 * time.Second)
+// copyOp is the handle of a copy running on OneDrive
+type copyOp struct {
+	o          *OneDrive
+	to         string
+	monitorUrl string
+	done       bool
+}
+
+func (c *copyOp) Poll(ctx context.Context) (bool, float64, error) {
+	if c.done {
+		return true, 1, nil
+	}
+	resp, e := httpApi.Get(ctx, c.monitorUrl, nil)
+	if e != nil {
+		return false, 0, e
+	}
+	s := actionProgress{}
+	if e := resp.Json(&s); e != nil {
+		return false, 0, e
+	}
+	if s.Status == "inProgress" || s.Status == "notStarted" {
+		return false, float64(s.Percent) / 100, nil
 	}
+	if s.Status != "completed" {
+		return false, 0, errors.New(i18n.T("drive.onedrive.unknown_action_status", s.Status))
+	}
+	c.done = true
+	return true, 1, nil
+}
+
+func (c *copyOp) Result(ctx context.Context) (types.IEntry, error) {
+	_ = c.o.cache.Evict(c.to, true)
+	_ = c.o.cache.Evict(utils.PathParent(c.to), false)
+	return c.o.Get(ctx, c.to)
 }
 
 func (o *OneDrive) toEntry(resp req.Response) (*oneDriveEntry, error) {
@@ -296,6 +383,9 @@ func (o *OneDrive) deserializeEntry(dat string) (types.IEntry, error) {
 		downloadUrl:          ed["du"],
 		downloadUrlExpiresAt: utils.ToInt64(ed["de"], -1),
 		thumbnail:            ed["th"],
+		hashAlgorithm:        ed["ha"],
+		hash:                 ed["hv"],
+		remoteDrive:          ed["rd"],
 	}, nil
 }
 
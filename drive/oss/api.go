@@ -0,0 +1,57 @@
+package oss
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int64  `xml:"Size"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listResult struct {
+	Contents       []object       `xml:"Contents"`
+	CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	NextMarker     string         `xml:"NextMarker"`
+}
+
+type initiateMultipartUploadResult struct {
+	UploadId string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type deleteObject struct {
+	Key string `xml:"Key"`
+}
+
+type deleteRequest struct {
+	XMLName xml.Name       `xml:"Delete"`
+	Quiet   bool           `xml:"Quiet"`
+	Objects []deleteObject `xml:"Object"`
+}
+
+type ossError struct {
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+	RequestId string `xml:"RequestId"`
+}
+
+func (o ossError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", o.Code, o.Message, o.RequestId)
+}
@@ -0,0 +1,600 @@
+package oss
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "oss",
+		DisplayName: i18n.T("drive.oss.name"),
+		README:      i18n.T("drive.oss.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "id", Label: i18n.T("drive.oss.form.ak.label"), Type: "text", Required: true},
+			{Field: "secret", Label: i18n.T("drive.oss.form.sk.label"), Type: "password", Required: true},
+			{Field: "endpoint", Label: i18n.T("drive.oss.form.endpoint.label"), Type: "text", Required: true, Description: i18n.T("drive.oss.form.endpoint.description")},
+			{Field: "bucket", Label: i18n.T("drive.oss.form.bucket.label"), Type: "text", Required: true},
+			{Field: "prefix", Label: i18n.T("drive.oss.form.prefix.label"), Type: "text", Description: i18n.T("drive.oss.form.prefix.description")},
+			{Field: "proxy_download", Label: i18n.T("drive.oss.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.oss.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.oss.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.oss.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewOSSDrive},
+	})
+}
+
+const (
+	// minPartSize is the size of the parts of the multipart uploads, it grows for the huge files
+	minPartSize = 8 * 1024 * 1024
+	maxParts    = 10000
+	// maxCopySize is the max size of the objects copied by CopyObject
+	maxCopySize = 1024 * 1024 * 1024
+	// listLimit is the max-keys of ListObjects, which is also the most keys DeleteMultipleObjects accepts
+	listLimit = 1000
+	// urlTTL is how long the signed download URLs are valid
+	urlTTL = 8 * time.Hour
+)
+
+type OSSDrive struct {
+	c       *req.Client
+	s       *signer
+	baseURL *url.URL
+	// prefix is the prefix of the keys of the root, it's empty or ends with '/'
+	prefix string
+
+	downloadProxy bool
+	cacheTTL      time.Duration
+	cache         drive_util.DriveCache
+}
+
+// NewOSSDrive creates the drive of an Alibaba Cloud OSS bucket
+func NewOSSDrive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	endpoint := strings.TrimSuffix(config["endpoint"], "/")
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, e := url.Parse(endpoint)
+	if e != nil {
+		return nil, e
+	}
+	// the buckets are accessed by the virtual hosted style
+	u.Host = config["bucket"] + "." + u.Host
+	u.Path = ""
+
+	prefix := utils.CleanPath(config["prefix"])
+	if prefix != "" {
+		prefix += "/"
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	d := &OSSDrive{
+		s:             &signer{accessKeyId: config["id"], accessKeySecret: config["secret"], bucket: config["bucket"]},
+		baseURL:       u,
+		prefix:        prefix,
+		downloadProxy: config["proxy_download"] != "",
+		cacheTTL:      cacheTtl,
+	}
+	// the base URL of the client is not used, as it cleans the trailing '/' of the keys
+	if d.c, e = req.NewClient("", d.s.sign, ifApiCallError, nil); e != nil {
+		return nil, e
+	}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+	return d, d.check(ctx)
+}
+
+// check checks the bucket and the credentials by listing a key
+func (d *OSSDrive) check(ctx context.Context) error {
+	_, e := d.list(ctx, d.prefix, "/", "", 1)
+	if err.IsNotFoundError(e) {
+		return err.NewNotFoundMessageError(i18n.T("drive.oss.bucket_not_exists", d.s.bucket))
+	}
+	return e
+}
+
+// key returns the object key of path
+func (d *OSSDrive) key(path string) string {
+	return d.prefix + path
+}
+
+// objectURL returns the URL of the object, the query is optional
+func (d *OSSDrive) objectURL(key string, query url.Values) *url.URL {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	u := *d.baseURL
+	u.RawPath = "/" + strings.Join(segments, "/")
+	u.Path = "/" + key
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	return &u
+}
+
+// subResource returns the query of the sub-resource without value, like '?uploads'
+func subResource(u *url.URL, name string) string {
+	if u.RawQuery == "" {
+		return u.String() + "?" + name
+	}
+	return u.String() + "&" + name
+}
+
+func (d *OSSDrive) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	return &ossEntry{d: d, path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir()}, nil
+}
+
+func (d *OSSDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *OSSDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &ossEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	entry, e := d.get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	_ = d.cache.PutEntry(entry, d.cacheTTL)
+	return entry, nil
+}
+
+// get finds the object of path by HEAD, the directories are only the common prefixes in OSS
+func (d *OSSDrive) get(ctx context.Context, path string) (*ossEntry, error) {
+	resp, e := d.c.Request(ctx, "HEAD", d.objectURL(d.key(path), nil).String(), nil, nil)
+	if e == nil {
+		_ = resp.Dispose()
+		modTime := int64(-1)
+		if t, e := http.ParseTime(resp.Response().Header.Get("Last-Modified")); e == nil {
+			modTime = utils.Millisecond(t)
+		}
+		return &ossEntry{d: d, path: path, size: resp.Response().ContentLength, modTime: modTime}, nil
+	}
+	if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	res, e := d.list(ctx, d.key(path)+"/", "", "", 1)
+	if e != nil {
+		return nil, e
+	}
+	if len(res.Contents) == 0 && len(res.CommonPrefixes) == 0 {
+		return nil, err.NewNotFoundError()
+	}
+	return &ossEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+}
+
+func (d *OSSDrive) list(ctx context.Context, prefix, delimiter, marker string, limit int) (*listResult, error) {
+	query := url.Values{"prefix": {prefix}, "max-keys": {strconv.Itoa(limit)}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	u := *d.baseURL
+	u.Path = "/"
+	u.RawQuery = query.Encode()
+	resp, e := d.c.Get(ctx, u.String(), nil)
+	if e != nil {
+		return nil, e
+	}
+	res := &listResult{}
+	if e := resp.XML(res); e != nil {
+		return nil, e
+	}
+	return res, nil
+}
+
+func (d *OSSDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(size, true)
+	var e error
+	if size >= 0 && size <= minPartSize {
+		e = d.put(ctx, d.key(path), req.NewReaderBody(drive_util.ProgressReader(reader, ctx), size))
+	} else {
+		e = d.multipartUpload(ctx, d.key(path), size, drive_util.ProgressReader(reader, ctx))
+	}
+	_ = d.cache.Evict(path, false)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	return d.Get(ctx, path)
+}
+
+func (d *OSSDrive) put(ctx context.Context, key string, body req.RequestBody) error {
+	resp, e := d.c.Request(ctx, "PUT", d.objectURL(key, nil).String(), nil, body)
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+// partSize returns the size of the parts to upload a file of size, size < 0 means unknown
+func partSize(size int64) int64 {
+	if size > minPartSize*maxParts {
+		return (size + maxParts - 1) / maxParts
+	}
+	return minPartSize
+}
+
+// multipartUpload uploads the file by parts, the parts are buffered in memory,
+// the upload is aborted if any part fails
+func (d *OSSDrive) multipartUpload(ctx types.TaskCtx, key string, size int64, reader io.Reader) error {
+	resp, e := d.c.Post(ctx, subResource(d.objectURL(key, nil), "uploads"), nil, nil)
+	if e != nil {
+		return e
+	}
+	initiated := initiateMultipartUploadResult{}
+	if e := resp.XML(&initiated); e != nil {
+		return e
+	}
+	parts, e := d.uploadParts(ctx, key, initiated.UploadId, partSize(size), reader)
+	if e == nil {
+		e = d.completeMultipartUpload(ctx, key, initiated.UploadId, parts)
+	}
+	if e != nil {
+		resp, ee := d.c.Request(context.Background(), "DELETE",
+			d.objectURL(key, url.Values{"uploadId": {initiated.UploadId}}).String(), nil, nil)
+		if ee == nil {
+			_ = resp.Dispose()
+		}
+	}
+	return e
+}
+
+func (d *OSSDrive) uploadParts(ctx types.TaskCtx, key, uploadId string, partSize int64, reader io.Reader) ([]completedPart, error) {
+	parts := make([]completedPart, 0)
+	buf := make([]byte, partSize)
+	for {
+		if e := ctx.WaitIfPaused(); e != nil {
+			return nil, e
+		}
+		n, e := io.ReadFull(reader, buf)
+		if e != nil && e != io.ErrUnexpectedEOF && e != io.EOF {
+			return nil, e
+		}
+		if n == 0 && len(parts) > 0 {
+			break
+		}
+		number := len(parts) + 1
+		resp, ee := d.c.Request(ctx, "PUT", d.objectURL(key, url.Values{
+			"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadId},
+		}).String(), nil, req.NewReaderBody(bytes.NewReader(buf[:n]), int64(n)))
+		if ee != nil {
+			return nil, ee
+		}
+		_ = resp.Dispose()
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Response().Header.Get("ETag")})
+		if e != nil {
+			break
+		}
+	}
+	return parts, nil
+}
+
+func (d *OSSDrive) completeMultipartUpload(ctx context.Context, key, uploadId string, parts []completedPart) error {
+	body, e := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if e != nil {
+		return e
+	}
+	resp, e := d.c.Post(ctx, d.objectURL(key, url.Values{"uploadId": {uploadId}}).String(), nil,
+		req.NewReaderBody(bytes.NewReader(body), int64(len(body))))
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+func (d *OSSDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := d.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	if e := d.put(ctx, d.key(path)+"/", req.NewReaderBody(bytes.NewReader(nil), 0)); e != nil {
+		return nil, e
+	}
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return &ossEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+}
+
+func (d *OSSDrive) isSelf(e types.IEntry) bool {
+	if oe, ok := e.(*ossEntry); ok {
+		return oe.d == d
+	}
+	return false
+}
+
+// Copy copies the object by CopyObject, the directories and the large objects are not supported
+func (d *OSSDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || from.Type().IsDir() || from.Size() > maxCopySize {
+		return nil, err.NewUnsupportedError()
+	}
+	headers := types.SM{"x-oss-copy-source": "/" + d.s.bucket + d.objectURL(d.key(from.Path()), nil).EscapedPath()}
+	if !override {
+		headers["x-oss-forbid-overwrite"] = "true"
+	}
+	ctx.Total(from.Size(), false)
+	resp, e := d.c.Request(ctx, "PUT", d.objectURL(d.key(to), nil).String(), headers, nil)
+	_ = d.cache.Evict(to, true)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	ctx.Progress(from.Size(), false)
+	return d.Get(ctx, to)
+}
+
+// Move copies the object, and deletes the source, as OSS can not rename objects
+func (d *OSSDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	entry, e := d.Copy(ctx, from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	if e := d.deleteKeys(ctx, []string{d.key(from.Path())}); e != nil {
+		return nil, e
+	}
+	_ = d.cache.Evict(from.Path(), true)
+	_ = d.cache.Evict(utils.PathParent(from.Path()), false)
+	return entry, nil
+}
+
+// List lists the directory page by page, the placeholder object of the directory is skipped
+func (d *OSSDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	prefix := d.prefix
+	if !utils.IsRootPath(path) {
+		prefix = d.key(path) + "/"
+	}
+	entries := make([]types.IEntry, 0)
+	files := make(map[string]bool)
+	marker := ""
+	for {
+		res, e := d.list(ctx, prefix, "/", marker, listLimit)
+		if e != nil {
+			return nil, e
+		}
+		for _, o := range res.Contents {
+			if o.Key == prefix {
+				continue
+			}
+			modTime := int64(-1)
+			if t, e := time.Parse(time.RFC3339, o.LastModified); e == nil {
+				modTime = utils.Millisecond(t)
+			}
+			p := strings.TrimPrefix(o.Key, d.prefix)
+			entries = append(entries, &ossEntry{d: d, path: p, size: o.Size, modTime: modTime})
+			files[p] = true
+		}
+		for _, cp := range res.CommonPrefixes {
+			p := strings.TrimSuffix(strings.TrimPrefix(cp.Prefix, d.prefix), "/")
+			if files[p] {
+				// skip the directory with the same name of a file
+				continue
+			}
+			entries = append(entries, &ossEntry{d: d, path: p, isDir: true, modTime: -1})
+		}
+		if !res.IsTruncated {
+			break
+		}
+		marker = res.NextMarker
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+// Delete deletes the object, or the objects under the directory by DeleteMultipleObjects, a page of the listing at a time
+func (d *OSSDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := d.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	if entry.Type().IsFile() {
+		e = d.deleteKeys(ctx, []string{d.key(path)})
+	} else {
+		e = d.deleteDir(ctx, d.key(path)+"/")
+	}
+	_ = d.cache.Evict(path, true)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+func (d *OSSDrive) deleteDir(ctx types.TaskCtx, prefix string) error {
+	for {
+		if ctx.Canceled() {
+			return task.ErrorCanceled
+		}
+		// the listed keys are deleted, so the listing always starts from the beginning
+		res, e := d.list(ctx, prefix, "", "", listLimit)
+		if e != nil {
+			return e
+		}
+		if len(res.Contents) == 0 {
+			return nil
+		}
+		keys := make([]string, len(res.Contents))
+		for i, o := range res.Contents {
+			keys[i] = o.Key
+		}
+		if e := d.deleteKeys(ctx, keys); e != nil {
+			return e
+		}
+		ctx.Progress(int64(len(keys)), false)
+	}
+}
+
+// deleteKeys deletes at most listLimit keys by DeleteMultipleObjects
+func (d *OSSDrive) deleteKeys(ctx context.Context, keys []string) error {
+	objects := make([]deleteObject, len(keys))
+	for i, k := range keys {
+		objects[i] = deleteObject{Key: k}
+	}
+	body, e := xml.Marshal(deleteRequest{Quiet: true, Objects: objects})
+	if e != nil {
+		return e
+	}
+	sum := md5.Sum(body)
+	u := *d.baseURL
+	u.Path = "/"
+	resp, e := d.c.Post(ctx, u.String()+"?delete", types.SM{"Content-MD5": base64.StdEncoding.EncodeToString(sum[:])},
+		req.NewReaderBody(bytes.NewReader(body), int64(len(body))))
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+// Upload uploads the files through the server, the large files are uploaded by chunks
+func (d *OSSDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	// the responses of HEAD have no body
+	if resp.Status() == http.StatusNotFound {
+		_ = resp.Dispose()
+		return err.NewNotFoundError()
+	}
+	oe := ossError{}
+	if e := resp.XML(&oe); e != nil || oe.Code == "" {
+		oe.Code = resp.Response().Status
+	}
+	if oe.Code == "FileAlreadyExists" {
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	if resp.Status() == http.StatusForbidden {
+		return err.NewNotAllowedMessageError(i18n.T("drive.oss.remote_error", oe.Error()))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.oss.remote_error", oe.Error()))
+}
+
+type ossEntry struct {
+	d       *OSSDrive
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+}
+
+func (e *ossEntry) Path() string {
+	return e.path
+}
+
+func (e *ossEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *ossEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *ossEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *ossEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *ossEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *ossEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *ossEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+func (e *ossEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	var header types.SM
+	if offset > 0 || length >= 0 {
+		rangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if length >= 0 {
+			if length == 0 {
+				return ioutil.NopCloser(strings.NewReader("")), nil
+			}
+			rangeHeader += strconv.FormatInt(offset+length-1, 10)
+		}
+		header = types.SM{"Range": rangeHeader}
+	}
+	resp, ee := e.d.c.Get(ctx, e.d.objectURL(e.d.key(e.path), nil).String(), header)
+	if ee != nil {
+		return nil, ee
+	}
+	return resp.Response().Body, nil
+}
+
+// GetURL returns the signed URL of the object
+func (e *ossEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	u := e.d.s.signURL("GET", e.d.objectURL(e.d.key(e.path), nil), urlTTL)
+	return &types.ContentURL{URL: u, Proxy: e.d.downloadProxy}, nil
+}
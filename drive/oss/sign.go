@@ -0,0 +1,104 @@
+package oss
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signedParams are the sub-resources included in the signature
+var signedParams = map[string]bool{
+	"acl": true, "uploads": true, "uploadId": true, "partNumber": true, "delete": true,
+	"response-content-type": true, "response-content-disposition": true, "response-cache-control": true,
+	"response-content-encoding": true, "response-content-language": true, "response-expires": true,
+}
+
+// signer signs the requests by the signature of version 1,
+// see https://help.aliyun.com/document_detail/31951.html
+type signer struct {
+	accessKeyId     string
+	accessKeySecret string
+	bucket          string
+}
+
+// canonicalResource returns the bucket, the object key, and the sub-resources
+func (s *signer) canonicalResource(u *url.URL) string {
+	res := "/" + s.bucket + u.Path
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if signedParams[k] {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return res
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if i == 0 {
+			res += "?"
+		} else {
+			res += "&"
+		}
+		res += k
+		if v := query.Get(k); v != "" {
+			res += "=" + v
+		}
+	}
+	return res
+}
+
+func canonicalHeaders(header http.Header) string {
+	keys := make([]string, 0)
+	for k := range header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-oss-") {
+			keys = append(keys, lk)
+		}
+	}
+	sort.Strings(keys)
+	sb := strings.Builder{}
+	for _, k := range keys {
+		sb.WriteString(k + ":" + strings.TrimSpace(header.Get(k)) + "\n")
+	}
+	return sb.String()
+}
+
+func (s *signer) signature(stringToSign string) string {
+	h := hmac.New(sha1.New, []byte(s.accessKeySecret))
+	_, _ = h.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// sign sets the date and the authorization of the request
+func (s *signer) sign(r *http.Request) error {
+	date := time.Now().UTC().Format(http.TimeFormat)
+	r.Header.Set("Date", date)
+	stringToSign := r.Method + "\n" +
+		r.Header.Get("Content-MD5") + "\n" +
+		r.Header.Get("Content-Type") + "\n" +
+		date + "\n" +
+		canonicalHeaders(r.Header) +
+		s.canonicalResource(r.URL)
+	r.Header.Set("Authorization", "OSS "+s.accessKeyId+":"+s.signature(stringToSign))
+	return nil
+}
+
+// signURL returns the URL with the signature in the query, which expires after ttl
+func (s *signer) signURL(method string, u *url.URL, ttl time.Duration) string {
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	signature := s.signature(method + "\n\n\n" + expires + "\n" + s.canonicalResource(u))
+	query := u.Query()
+	query.Set("OSSAccessKeyId", s.accessKeyId)
+	query.Set("Expires", expires)
+	query.Set("Signature", signature)
+	signed := *u
+	signed.RawQuery = query.Encode()
+	return signed.String()
+}
@@ -0,0 +1,37 @@
+package oss
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestOSSCanonicalResource(t *testing.T) {
+	s := &signer{bucket: "bucket"}
+	cases := map[string]string{
+		"https://bucket.oss.example.com/":                                 "/bucket/",
+		"https://bucket.oss.example.com/?prefix=a%2F&max-keys=1":          "/bucket/",
+		"https://bucket.oss.example.com/a/b%20c.txt":                      "/bucket/a/b c.txt",
+		"https://bucket.oss.example.com/a/dir/?uploads":                   "/bucket/a/dir/?uploads",
+		"https://bucket.oss.example.com/a?uploadId=ID&partNumber=2":       "/bucket/a?partNumber=2&uploadId=ID",
+		"https://bucket.oss.example.com/?delete":                          "/bucket/?delete",
+		"https://bucket.oss.example.com/a?response-content-type=text%2F1": "/bucket/a?response-content-type=text/1",
+	}
+	for raw, expected := range cases {
+		u, _ := url.Parse(raw)
+		if r := s.canonicalResource(u); r != expected {
+			t.Errorf("canonical resource of %s: expected %s, got %s", raw, expected, r)
+		}
+	}
+}
+
+func TestOSSCanonicalHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Content-Type", "text/plain")
+	h.Set("X-OSS-Meta-Author", " foo ")
+	h.Set("x-oss-copy-source", "/bucket/a")
+	expected := "x-oss-copy-source:/bucket/a\nx-oss-meta-author:foo\n"
+	if r := canonicalHeaders(h); r != expected {
+		t.Errorf("expected %q, got %q", expected, r)
+	}
+}
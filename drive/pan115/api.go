@@ -0,0 +1,164 @@
+package pan115
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"net/http"
+)
+
+const (
+	webApiURL   = "https://webapi.115.com"
+	navURL      = "https://my.115.com/?ct=ajax&ac=nav"
+	qrcodeURL   = "https://qrcodeapi.115.com"
+	passportURL = "https://passportapi.115.com"
+	// userAgent is sent to all the requests, as the download URLs are bound to the User-Agent requesting them
+	userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) " +
+		"Chrome/120.0.0.0 Safari/537.36 115Browser/27.0.0"
+)
+
+// errnoLoginRequired is the errno when the cookie is invalid or expired
+const errnoLoginRequired = "990001"
+
+// the status of the QR code
+const (
+	qrcodeWaiting   = 0
+	qrcodeScanned   = 1
+	qrcodeConfirmed = 2
+	qrcodeExpired   = -1
+	qrcodeCanceled  = -2
+)
+
+// flexString is the field which may be a string or a number in the responses of 115
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(b, []byte("\"")) {
+		var s string
+		if e := json.Unmarshal(b, &s); e != nil {
+			return e
+		}
+		*f = flexString(s)
+		return nil
+	}
+	if bytes.Equal(b, []byte("null")) {
+		*f = ""
+		return nil
+	}
+	*f = flexString(b)
+	return nil
+}
+
+// apiResult is the common part of the responses of the web APIs
+type apiResult struct {
+	State bool   `json:"state"`
+	Error string `json:"error"`
+	// Errno is 'errno' or 'errNo' in the responses, which are both matched
+	Errno flexString `json:"errno"`
+}
+
+type userInfo struct {
+	apiResult
+	Data struct {
+		UserId   flexString `json:"user_id"`
+		UserName string     `json:"user_name"`
+	} `json:"data"`
+}
+
+// file is the file or the directory in the listing, the directories have no fid
+type file struct {
+	Fid      flexString `json:"fid"`
+	Cid      flexString `json:"cid"`
+	Name     string     `json:"n"`
+	Size     flexString `json:"s"`
+	Sha1     string     `json:"sha"`
+	PickCode string     `json:"pc"`
+	// ModTime is the unix time of the last modification
+	ModTime flexString `json:"te"`
+}
+
+func (f file) isDir() bool {
+	return f.Fid == ""
+}
+
+func (f file) id() string {
+	if f.isDir() {
+		return string(f.Cid)
+	}
+	return string(f.Fid)
+}
+
+type listResult struct {
+	apiResult
+	Data  []file `json:"data"`
+	Count int    `json:"count"`
+	// Cid is the directory listed, 115 lists the root if the requested directory does not exist
+	Cid flexString `json:"cid"`
+}
+
+type downloadResult struct {
+	apiResult
+	FileURL string `json:"file_url"`
+}
+
+// qrcodeResult is the common part of the responses of the QR code login APIs
+type qrcodeResult struct {
+	State   int    `json:"state"`
+	Message string `json:"message"`
+}
+
+type qrcodeToken struct {
+	qrcodeResult
+	Data struct {
+		Uid  string     `json:"uid"`
+		Time flexString `json:"time"`
+		Sign string     `json:"sign"`
+	} `json:"data"`
+}
+
+type qrcodeStatus struct {
+	qrcodeResult
+	Data struct {
+		Status int `json:"status"`
+	} `json:"data"`
+}
+
+type qrcodeLogin struct {
+	qrcodeResult
+	Data struct {
+		UserName string            `json:"user_name"`
+		Cookie   map[string]string `json:"cookie"`
+	} `json:"data"`
+}
+
+// ifApiCallError checks the status only, the results of the APIs are checked by checkResult
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	_ = resp.Dispose()
+	switch resp.Status() {
+	case http.StatusNotFound:
+		return err.NewNotFoundError()
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return err.NewUnauthorizedError(i18n.T("drive.pan115.unauthorized", resp.Response().Status))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.pan115.remote_error", resp.Response().Status))
+}
+
+// checkResult returns the error of the result, 115 responds the errors with the status 200
+func checkResult(r apiResult) error {
+	if r.State {
+		return nil
+	}
+	if r.Errno == errnoLoginRequired {
+		return err.NewUnauthorizedError(i18n.T("drive.pan115.unauthorized", r.Error))
+	}
+	msg := r.Error
+	if msg == "" {
+		msg = string(r.Errno)
+	}
+	return err.NewRemoteApiError(500, i18n.T("drive.pan115.remote_error", msg))
+}
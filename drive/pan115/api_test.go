@@ -0,0 +1,32 @@
+package pan115
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPan115DecodeFiles(t *testing.T) {
+	r := listResult{}
+	if e := json.Unmarshal([]byte(`{"state":true,"errNo":0,"count":2,"cid":12,"data":[
+		{"cid":"34","pid":"12","n":"dir","te":"1690000000"},
+		{"fid":"56","cid":12,"n":"file.txt","s":1024,"sha":"ABCD","pc":"pc56","te":1690000001}
+	]}`), &r); e != nil {
+		t.Fatal(e)
+	}
+	if !r.State || r.Errno != "0" || r.Cid != "12" || len(r.Data) != 2 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	dir, f := r.Data[0], r.Data[1]
+	if !dir.isDir() || dir.id() != "34" || dir.ModTime != "1690000000" {
+		t.Errorf("unexpected dir: %+v", dir)
+	}
+	if f.isDir() || f.id() != "56" || f.Size != "1024" || f.PickCode != "pc56" || f.ModTime != "1690000001" {
+		t.Errorf("unexpected file: %+v", f)
+	}
+}
+
+func TestPan115JoinCookies(t *testing.T) {
+	if c := joinCookies(map[string]string{"UID": "1", "SEID": "3", "CID": "2"}); c != "CID=2; SEID=3; UID=1" {
+		t.Errorf("unexpected cookie: %s", c)
+	}
+}
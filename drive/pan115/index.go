@@ -0,0 +1,313 @@
+package pan115
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"net/url"
+	path2 "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "115",
+		DisplayName: i18n.T("drive.pan115.name"),
+		README:      i18n.T("drive.pan115.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "cookie", Label: i18n.T("drive.pan115.form.cookie.label"), Type: "password", Description: i18n.T("drive.pan115.form.cookie.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.pan115.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.pan115.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewPan115, InitConfig: InitConfig, Init: Init},
+	})
+}
+
+// listLimit is the limit of the files API, the files of a directory are paged by offset
+const listLimit = 1000
+
+// Pan115 is the read-only drive of 115
+type Pan115 struct {
+	// c calls the APIs, dc downloads the files with the cookie of the download URLs
+	c      *req.Client
+	dc     *req.Client
+	cookie string
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+}
+
+// NewPan115 creates the drive of 115 by the cookie in the config, or the cookie of the QR code login
+func NewPan115(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	cookie, e := loadCookie(config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	if cookie == "" {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.not_configured"))
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	d := &Pan115{cookie: cookie, cacheTTL: cacheTtl}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+	if d.c, e = newApiClient(cookie); e != nil {
+		return nil, e
+	}
+	if d.dc, e = newApiClient(""); e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+func (d *Pan115) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: false}
+}
+
+// Get finds the entry in the listing of the parent, as 115 addresses the files by the ids of them and their directories
+func (d *Pan115) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &pan115Entry{d: d, id: "0", path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	children, e := d.List(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	for _, c := range children {
+		if c.Path() == path {
+			_ = d.cache.PutEntry(c, d.cacheTTL)
+			return c, nil
+		}
+	}
+	return nil, err.NewNotFoundError()
+}
+
+func (d *Pan115) Save(types.TaskCtx, string, int64, bool, io.Reader) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *Pan115) MakeDir(context.Context, string) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *Pan115) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *Pan115) Move(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+// List lists the directory page by page with the offset
+func (d *Pan115) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	dir, e := d.Get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if !dir.Type().IsDir() {
+		return nil, err.NewNotAllowedError()
+	}
+	cid := dir.(*pan115Entry).id
+	entries := make([]types.IEntry, 0)
+	for offset := 0; ; {
+		resp, e := d.c.Get(ctx, webApiURL+"/files?"+url.Values{
+			"aid":      {"1"},
+			"cid":      {cid},
+			"o":        {"user_ptime"},
+			"asc":      {"0"},
+			"show_dir": {"1"},
+			"natsort":  {"1"},
+			"format":   {"json"},
+			"offset":   {strconv.Itoa(offset)},
+			"limit":    {strconv.Itoa(listLimit)},
+		}.Encode(), nil)
+		if e != nil {
+			return nil, e
+		}
+		r := listResult{}
+		if e := resp.Json(&r); e != nil {
+			return nil, e
+		}
+		if e := checkResult(r.apiResult); e != nil {
+			return nil, e
+		}
+		if string(r.Cid) != cid {
+			return nil, err.NewNotFoundError()
+		}
+		for _, f := range r.Data {
+			entries = append(entries, d.newEntry(path, f))
+		}
+		offset += len(r.Data)
+		if len(r.Data) == 0 || offset >= r.Count {
+			break
+		}
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+func (d *Pan115) Delete(types.TaskCtx, string) error {
+	return err.NewNotAllowedError()
+}
+
+func (d *Pan115) Upload(context.Context, string, int64, bool, types.SM) (*types.DriveUploadConfig, error) {
+	return nil, err.NewNotAllowedError()
+}
+
+func (d *Pan115) newEntry(parent string, f file) *pan115Entry {
+	modTime := utils.ToInt64(string(f.ModTime), -1)
+	if modTime > 0 {
+		modTime *= 1000
+	}
+	return &pan115Entry{
+		d:        d,
+		id:       f.id(),
+		pickCode: f.PickCode,
+		path:     path2.Join(parent, f.Name),
+		isDir:    f.isDir(),
+		size:     utils.ToInt64(string(f.Size), -1),
+		modTime:  modTime,
+		sha1:     strings.ToLower(f.Sha1),
+	}
+}
+
+type pan115Entry struct {
+	d        *Pan115
+	id       string
+	pickCode string
+	path     string
+	isDir    bool
+	size     int64
+	modTime  int64
+	sha1     string
+}
+
+func (e *pan115Entry) Path() string {
+	return e.path
+}
+
+func (e *pan115Entry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *pan115Entry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *pan115Entry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: false}
+}
+
+func (e *pan115Entry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *pan115Entry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *pan115Entry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+// downloadURL returns the download URL and the headers to request it,
+// the URL is bound to the User-Agent, and requires the cookies set by the API
+func (e *pan115Entry) downloadURL(ctx context.Context) (string, types.SM, error) {
+	resp, ee := e.d.c.Get(ctx, webApiURL+"/files/download?"+url.Values{"pickcode": {e.pickCode}}.Encode(), nil)
+	if ee != nil {
+		return "", nil, ee
+	}
+	r := downloadResult{}
+	if ee := resp.Json(&r); ee != nil {
+		return "", nil, ee
+	}
+	if ee := checkResult(r.apiResult); ee != nil {
+		return "", nil, ee
+	}
+	cookies := []string{e.d.cookie}
+	for _, c := range resp.Response().Cookies() {
+		cookies = append(cookies, c.Name+"="+c.Value)
+	}
+	return r.FileURL, types.SM{"User-Agent": userAgent, "Cookie": strings.Join(cookies, "; ")}, nil
+}
+
+func (e *pan115Entry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+func (e *pan115Entry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	u, header, ee := e.downloadURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	if offset > 0 || length >= 0 {
+		rangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if length >= 0 {
+			if length == 0 {
+				return ioutil.NopCloser(strings.NewReader("")), nil
+			}
+			rangeHeader += strconv.FormatInt(offset+length-1, 10)
+		}
+		header["Range"] = rangeHeader
+	}
+	resp, ee := e.d.dc.Get(ctx, u, header)
+	if ee != nil {
+		return nil, ee
+	}
+	return resp.Response().Body, nil
+}
+
+// GetURL returns the download URL with the headers it requires, so the downloads are proxied by the server
+func (e *pan115Entry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	u, header, ee := e.downloadURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	return &types.ContentURL{URL: u, Header: header, Proxy: true}, nil
+}
+
+func (e *pan115Entry) EntryData() types.SM {
+	return types.SM{"id": e.id, "pc": e.pickCode, "sha1": e.sha1}
+}
+
+func (e *pan115Entry) StableID() string {
+	return e.id
+}
+
+func (e *pan115Entry) ContentHash(context.Context) (string, string, error) {
+	if e.isDir || e.sha1 == "" {
+		return "", "", err.NewUnsupportedError()
+	}
+	return "sha1", e.sha1, nil
+}
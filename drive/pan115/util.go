@@ -0,0 +1,221 @@
+package pan115
+
+import (
+	"context"
+	"errors"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// loadCookie returns the cookie in the config, or the cookie saved by the QR code login
+func loadCookie(config drive_util.DriveConfig, ds drive_util.DriveDataStore) (string, error) {
+	if c := strings.TrimSpace(config["cookie"]); c != "" {
+		return c, nil
+	}
+	data, e := ds.Load("cookie")
+	if e != nil {
+		return "", e
+	}
+	return data["cookie"], nil
+}
+
+// joinCookies joins the cookies returned by the QR code login to the Cookie header
+func joinCookies(cookies map[string]string) string {
+	names := make([]string, 0, len(cookies))
+	for k := range cookies {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for i, k := range names {
+		names[i] = k + "=" + cookies[k]
+	}
+	return strings.Join(names, "; ")
+}
+
+// newApiClient returns the client sending the cookie and the User-Agent,
+// if cookie is empty, the cookie is not sent, and the headers of the requests are used
+func newApiClient(cookie string) (*req.Client, error) {
+	return req.NewClient("", func(r *http.Request) error {
+		if cookie != "" {
+			r.Header.Set("Cookie", cookie)
+		}
+		r.Header.Set("User-Agent", userAgent)
+		return nil
+	}, ifApiCallError, nil)
+}
+
+func getUserInfo(ctx context.Context, c *req.Client) (*userInfo, error) {
+	resp, e := c.Get(ctx, navURL, nil)
+	if e != nil {
+		return nil, e
+	}
+	u := &userInfo{}
+	if e := resp.Json(u); e != nil {
+		return nil, e
+	}
+	return u, checkResult(u.apiResult)
+}
+
+// InitConfig shows the QR code to log in if the cookie is not configured,
+// the user scans it with the 115 app, and saves the form to finish the login
+func InitConfig(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (*drive_util.DriveInitConfig, error) {
+	if strings.TrimSpace(config["cookie"]) != "" {
+		return nil, nil
+	}
+	c, e := newApiClient("")
+	if e != nil {
+		return nil, e
+	}
+	initConfig := &drive_util.DriveInitConfig{}
+	description := ""
+	cookie, e := loadCookie(config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	if cookie != "" {
+		uc, e := newApiClient(cookie)
+		if e != nil {
+			return nil, e
+		}
+		user, e := getUserInfo(ctx, uc)
+		initConfig.Configured = e == nil
+		if e == nil {
+			description = i18n.T("drive.pan115.connected", user.Data.UserName) + " "
+		}
+	}
+	qrcode, e := loadQRCode(ctx, c, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	qrcodeImage := qrcodeURL + "/api/1.0/web/1.0/qrcode?uid=" + url.QueryEscape(qrcode["qrcode_uid"])
+	initConfig.Form = []types.FormItem{
+		{
+			Field: "qrcode", Label: i18n.T("drive.pan115.form.qrcode.label"), Type: "text", Disabled: true,
+			Description: description + i18n.T("drive.pan115.form.qrcode.description"),
+		},
+	}
+	initConfig.Value = types.SM{"qrcode": qrcodeImage}
+	return initConfig, nil
+}
+
+// loadQRCode loads the QR code, a new one is requested if it's missing, expired or canceled
+func loadQRCode(ctx context.Context, c *req.Client, ds drive_util.DriveDataStore) (types.SM, error) {
+	params, e := ds.Load("qrcode_uid", "qrcode_time", "qrcode_sign")
+	if e != nil {
+		return nil, e
+	}
+	if params["qrcode_uid"] != "" {
+		status, e := getQRCodeStatus(ctx, c, params)
+		if e != nil {
+			return nil, e
+		}
+		if status != qrcodeExpired && status != qrcodeCanceled {
+			return params, nil
+		}
+	}
+	resp, e := c.Get(ctx, qrcodeURL+"/api/1.0/web/1.0/token/", nil)
+	if e != nil {
+		return nil, e
+	}
+	t := qrcodeToken{}
+	if e := resp.Json(&t); e != nil {
+		return nil, e
+	}
+	if t.State != 1 || t.Data.Uid == "" {
+		return nil, err.NewRemoteApiError(500, i18n.T("drive.pan115.remote_error", t.Message))
+	}
+	params = types.SM{
+		"qrcode_uid":  t.Data.Uid,
+		"qrcode_time": string(t.Data.Time),
+		"qrcode_sign": t.Data.Sign,
+	}
+	return params, ds.Save(params)
+}
+
+func getQRCodeStatus(ctx context.Context, c *req.Client, params types.SM) (int, error) {
+	resp, e := c.Get(ctx, qrcodeURL+"/get/status/?"+url.Values{
+		"uid":  {params["qrcode_uid"]},
+		"time": {params["qrcode_time"]},
+		"sign": {params["qrcode_sign"]},
+	}.Encode(), nil)
+	if e != nil {
+		return 0, e
+	}
+	s := qrcodeStatus{}
+	if e := resp.Json(&s); e != nil {
+		return 0, e
+	}
+	if s.State != 1 {
+		// the expired QR codes are responded without the state
+		return qrcodeExpired, nil
+	}
+	return s.Data.Status, nil
+}
+
+// Init logs in by the QR code, it fails if the user has not confirmed the login yet
+func Init(ctx context.Context, _ types.SM, config drive_util.DriveConfig, driveUtils drive_util.DriveUtils) error {
+	if strings.TrimSpace(config["cookie"]) != "" {
+		return nil
+	}
+	params, e := driveUtils.Data.Load("qrcode_uid", "qrcode_time", "qrcode_sign")
+	if e != nil {
+		return e
+	}
+	if params["qrcode_uid"] == "" {
+		return err.NewNotAllowedMessageError(i18n.T("drive.pan115.qrcode_expired"))
+	}
+	c, e := newApiClient("")
+	if e != nil {
+		return e
+	}
+	status, e := getQRCodeStatus(ctx, c, params)
+	if e != nil {
+		return e
+	}
+	switch status {
+	case qrcodeConfirmed:
+	case qrcodeWaiting, qrcodeScanned:
+		return err.NewNotAllowedMessageError(i18n.T("drive.pan115.qrcode_pending"))
+	default:
+		_ = driveUtils.Data.Save(types.SM{"qrcode_uid": ""})
+		return err.NewNotAllowedMessageError(i18n.T("drive.pan115.qrcode_expired"))
+	}
+	resp, e := c.Post(ctx, passportURL+"/app/1.0/web/1.0/login/qrcode/", nil,
+		req.NewURLEncodedBody(types.SM{"account": params["qrcode_uid"], "app": "web"}))
+	if e != nil {
+		return e
+	}
+	r := qrcodeLogin{}
+	if e := resp.Json(&r); e != nil {
+		return e
+	}
+	if r.State != 1 || len(r.Data.Cookie) == 0 {
+		return err.NewRemoteApiError(500, i18n.T("drive.pan115.remote_error", r.Message))
+	}
+	// the QR code can be used only once
+	return driveUtils.Data.Save(types.SM{"cookie": joinCookies(r.Data.Cookie), "qrcode_uid": ""})
+}
+
+func (d *Pan115) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	ed := ec.Data
+	if ed == nil || (ed["id"] == "" && !utils.IsRootPath(ec.Path)) {
+		return nil, errors.New("invalid cache")
+	}
+	return &pan115Entry{
+		d: d, id: ed["id"], pickCode: ed["pc"], sha1: ed["sha1"],
+		path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+	}, nil
+}
@@ -0,0 +1,52 @@
+package pcloud
+
+import "fmt"
+
+// the API hosts of the regions
+const (
+	regionUS = "us"
+	regionEU = "eu"
+)
+
+var apiHosts = map[string]string{
+	regionUS: "api.pcloud.com",
+	regionEU: "eapi.pcloud.com",
+}
+
+// metadata is the metadata of a file or a folder
+type metadata struct {
+	// Id is 'f' followed by the file id, or 'd' followed by the folder id
+	Id       string     `json:"id"`
+	Name     string     `json:"name"`
+	IsFolder bool       `json:"isfolder"`
+	FolderId int64      `json:"folderid"`
+	Size     int64      `json:"size"`
+	Modified string     `json:"modified"`
+	Contents []metadata `json:"contents"`
+}
+
+type apiResult struct {
+	Result  int    `json:"result"`
+	Message string `json:"error"`
+}
+
+type metadataResult struct {
+	Metadata metadata `json:"metadata"`
+}
+
+type uploadResult struct {
+	Metadata []metadata `json:"metadata"`
+}
+
+type fileLinkResult struct {
+	Hosts []string `json:"hosts"`
+	Path  string   `json:"path"`
+}
+
+type userInfo struct {
+	Email string `json:"email"`
+}
+
+func (a apiResult) Error() string {
+	return fmt.Sprintf("%d: %s", a.Result, a.Message)
+}
@@ -0,0 +1,374 @@
+package pcloud
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"net/url"
+	path2 "path"
+	"strconv"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "pcloud",
+		DisplayName: i18n.T("drive.pcloud.name"),
+		README:      i18n.T("drive.pcloud.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "client_id", Label: i18n.T("drive.pcloud.form.client_id.label"), Type: "text", Required: true},
+			{Field: "client_secret", Label: i18n.T("drive.pcloud.form.client_secret.label"), Type: "password", Required: true},
+			{Field: "region", Label: i18n.T("drive.pcloud.form.region.label"), Type: "select", Required: true,
+				Description: i18n.T("drive.pcloud.form.region.description"), DefaultValue: regionUS,
+				Options: []types.FormItemOption{
+					{Name: i18n.T("drive.pcloud.form.region.us"), Value: regionUS},
+					{Name: i18n.T("drive.pcloud.form.region.eu"), Value: regionEU},
+				},
+			},
+			{Field: "proxy_download", Label: i18n.T("drive.pcloud.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.pcloud.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.pcloud.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.pcloud.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewPCloud, InitConfig: InitConfig, Init: Init},
+	})
+}
+
+type PCloud struct {
+	c *req.Client
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+
+	downloadProxy bool
+}
+
+func NewPCloud(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	resp, e := drive_util.OAuthGet(*oauthReq(driveUtils.Config, config["region"]), config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	p := &PCloud{
+		cacheTTL:      cacheTtl,
+		downloadProxy: config["proxy_download"] != "",
+	}
+	if cacheTtl <= 0 {
+		p.cache = drive_util.DummyCache()
+	} else {
+		p.cache = driveUtils.CreateCache(p.deserializeEntry, nil)
+	}
+
+	if p.c, e = req.NewClient("https://"+apiHost(config["region"]), nil, ifApiCallError, resp.Client(nil)); e != nil {
+		return nil, e
+	}
+	return p, nil
+}
+
+func (p *PCloud) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+// call calls the API method with the query parameters, and decodes the result to res
+func (p *PCloud) call(ctx context.Context, method string, params url.Values, res interface{}) error {
+	resp, e := p.c.Get(ctx, "/"+method+"?"+params.Encode(), nil)
+	if e != nil {
+		return e
+	}
+	if res == nil {
+		return resp.Dispose()
+	}
+	return resp.Json(res)
+}
+
+func (p *PCloud) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &pcloudEntry{d: p, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := p.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	res := metadataResult{}
+	if e := p.call(ctx, "stat", url.Values{"path": {apiPath(path)}}, &res); e != nil {
+		return nil, e
+	}
+	entry := p.newEntry(utils.PathParent(path), res.Metadata)
+	_ = p.cache.PutEntry(entry, p.cacheTTL)
+	return entry, nil
+}
+
+func (p *PCloud) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, p, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(size, true)
+	params := url.Values{
+		"path":     {apiPath(utils.PathParent(path))},
+		"filename": {utils.PathBase(path)},
+		// the file is not kept if the upload is interrupted
+		"nopartial": {"1"},
+	}
+	// the existing file is overwritten if the file is uploaded by PUT
+	resp, e := p.c.Request(ctx, "PUT", "/uploadfile?"+params.Encode(), nil,
+		req.NewReaderBody(drive_util.ProgressReader(reader, ctx), size))
+	_ = p.cache.Evict(path, false)
+	_ = p.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	res := uploadResult{}
+	if e := resp.Json(&res); e != nil {
+		return nil, e
+	}
+	if len(res.Metadata) == 0 {
+		return p.Get(ctx, path)
+	}
+	return p.newEntry(utils.PathParent(path), res.Metadata[0]), nil
+}
+
+func (p *PCloud) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := p.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	res := metadataResult{}
+	if e := p.call(ctx, "createfolder", url.Values{"path": {apiPath(path)}}, &res); e != nil {
+		return nil, e
+	}
+	_ = p.cache.Evict(utils.PathParent(path), false)
+	return p.newEntry(utils.PathParent(path), res.Metadata), nil
+}
+
+func (p *PCloud) isSelf(e types.IEntry) bool {
+	if pe, ok := e.(*pcloudEntry); ok {
+		return pe.d == p
+	}
+	return false
+}
+
+// prepareTarget checks the target of copying or moving. The copies are made with noover and renamefolder can't
+// replace a folder, so the existing target is deleted first if override
+func (p *PCloud) prepareTarget(ctx types.TaskCtx, from types.IEntry, to string, override bool) (*pcloudEntry, error) {
+	from = drive_util.GetIEntry(from, p.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	if _, e := p.Get(ctx, to); e == nil {
+		if !override {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		if e := p.Delete(ctx, to); e != nil {
+			return nil, e
+		}
+	} else if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	return from.(*pcloudEntry), nil
+}
+
+func (p *PCloud) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	fromEntry, e := p.prepareTarget(ctx, from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	ctx.Total(from.Size(), false)
+	defer func() {
+		_ = p.cache.Evict(to, true)
+		_ = p.cache.Evict(utils.PathParent(to), false)
+	}()
+	if !fromEntry.isDir {
+		res := metadataResult{}
+		if e := p.call(ctx, "copyfile", url.Values{
+			"path": {apiPath(fromEntry.path)}, "topath": {apiPath(to)}, "noover": {"1"},
+		}, &res); e != nil {
+			return nil, e
+		}
+		ctx.Progress(from.Size(), false)
+		return p.newEntry(utils.PathParent(to), res.Metadata), nil
+	}
+	// the folders are copied with their names, so the contents are copied into the new folder
+	dir := metadataResult{}
+	if e := p.call(ctx, "createfolder", url.Values{"path": {apiPath(to)}}, &dir); e != nil {
+		return nil, e
+	}
+	if e := p.call(ctx, "copyfolder", url.Values{
+		"path":            {apiPath(fromEntry.path)},
+		"tofolderid":      {strconv.FormatInt(dir.Metadata.FolderId, 10)},
+		"copycontentonly": {"1"},
+		"noover":          {"1"},
+	}, nil); e != nil {
+		return nil, e
+	}
+	ctx.Progress(from.Size(), false)
+	return p.newEntry(utils.PathParent(to), dir.Metadata), nil
+}
+
+func (p *PCloud) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	fromEntry, e := p.prepareTarget(ctx, from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	method := "renamefile"
+	if fromEntry.isDir {
+		method = "renamefolder"
+	}
+	res := metadataResult{}
+	e = p.call(ctx, method, url.Values{"path": {apiPath(fromEntry.path)}, "topath": {apiPath(to)}}, &res)
+	_ = p.cache.Evict(to, true)
+	_ = p.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	_ = p.cache.Evict(from.Path(), true)
+	_ = p.cache.Evict(utils.PathParent(from.Path()), false)
+	return p.newEntry(utils.PathParent(to), res.Metadata), nil
+}
+
+func (p *PCloud) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := p.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	res := metadataResult{}
+	if e := p.call(ctx, "listfolder", url.Values{"path": {apiPath(path)}}, &res); e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, 0, len(res.Metadata.Contents))
+	for _, m := range res.Metadata.Contents {
+		entries = append(entries, p.newEntry(path, m))
+	}
+	_ = p.cache.PutChildren(path, entries, p.cacheTTL)
+	return entries, nil
+}
+
+func (p *PCloud) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := p.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	method := "deletefile"
+	if entry.Type().IsDir() {
+		method = "deletefolderrecursive"
+	}
+	if e := p.call(ctx, method, url.Values{"path": {apiPath(path)}}, nil); e != nil {
+		return e
+	}
+	_ = p.cache.Evict(path, true)
+	_ = p.cache.Evict(utils.PathParent(path), false)
+	return nil
+}
+
+func (p *PCloud) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, p, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (p *PCloud) newEntry(parent string, m metadata) *pcloudEntry {
+	modTime := int64(-1)
+	if t, e := time.Parse(time.RFC1123Z, m.Modified); e == nil {
+		modTime = utils.Millisecond(t)
+	}
+	return &pcloudEntry{
+		d:       p,
+		id:      m.Id,
+		path:    path2.Join(parent, m.Name),
+		isDir:   m.IsFolder,
+		size:    m.Size,
+		modTime: modTime,
+	}
+}
+
+type pcloudEntry struct {
+	d       *PCloud
+	id      string
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+}
+
+func (e *pcloudEntry) Path() string {
+	return e.path
+}
+
+func (e *pcloudEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *pcloudEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *pcloudEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *pcloudEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *pcloudEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *pcloudEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *pcloudEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	u, ee := e.GetURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	return drive_util.GetURL(ctx, u.URL, nil)
+}
+
+// GetURL returns the download link of the file, so the downloads are redirected to pCloud
+func (e *pcloudEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	res := fileLinkResult{}
+	if ee := e.d.call(ctx, "getfilelink",
+		url.Values{"path": {apiPath(e.path)}, "forcedownload": {"1"}}, &res); ee != nil {
+		return nil, ee
+	}
+	if len(res.Hosts) == 0 {
+		return nil, err.NewNotFoundError()
+	}
+	return &types.ContentURL{URL: "https://" + res.Hosts[0] + res.Path, Proxy: e.d.downloadProxy}, nil
+}
+
+func (e *pcloudEntry) EntryData() types.SM {
+	return types.SM{"id": e.id}
+}
+
+func (e *pcloudEntry) StableID() string {
+	return e.id
+}
@@ -0,0 +1,115 @@
+package pcloud
+
+import (
+	"context"
+	"errors"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"golang.org/x/oauth2"
+	"strings"
+)
+
+func oauthReq(c common.Config, region string) *drive_util.OAuthRequest {
+	return &drive_util.OAuthRequest{
+		Endpoint: oauth2.Endpoint{
+			AuthURL: "https://my.pcloud.com/oauth2/authorize",
+			// the token must be requested from the API host of the region of the account
+			TokenURL:  "https://" + apiHost(region) + "/oauth2_token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+		RedirectURL: c.OAuthRedirectURI,
+		Text:        i18n.T("drive.pcloud.oauth_text"),
+	}
+}
+
+func apiHost(region string) string {
+	if h, ok := apiHosts[region]; ok {
+		return h
+	}
+	return apiHosts[regionUS]
+}
+
+func InitConfig(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (*drive_util.DriveInitConfig, error) {
+	initConfig, resp, e := drive_util.OAuthInitConfig(
+		*oauthReq(driveUtils.Config, config["region"]), config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	if resp == nil {
+		return initConfig, nil
+	}
+	c, e := req.NewClient("https://"+apiHost(config["region"]), nil, ifApiCallError, resp.Client(nil))
+	if e != nil {
+		return nil, e
+	}
+
+	// get user
+	user := userInfo{}
+	r, e := c.Get(ctx, "/userinfo", nil)
+	if e == nil {
+		e = r.Json(&user)
+	}
+	initConfig.Configured = e == nil
+	if e == nil {
+		initConfig.OAuth.Principal = user.Email
+	}
+	return initConfig, nil
+}
+
+func Init(ctx context.Context, data types.SM, config drive_util.DriveConfig, utils drive_util.DriveUtils) error {
+	_, e := drive_util.OAuthInit(ctx, *oauthReq(utils.Config, config["region"]), data, config, utils.Data)
+	return e
+}
+
+// apiPath returns the path in the pCloud API, which starts with '/'
+func apiPath(path string) string {
+	return "/" + path
+}
+
+// ifApiCallError checks the result of the responses, pCloud responds errors with the status 200
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() < 200 || resp.Status() >= 300 {
+		return err.NewRemoteApiError(500, i18n.T("drive.pcloud.remote_error", resp.Response().Status))
+	}
+	if !strings.HasPrefix(resp.Response().Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	res := apiResult{}
+	if e := resp.Json(&res); e != nil {
+		return e
+	}
+	switch res.Result {
+	case 0:
+		return nil
+	// directory does not exist, file not found, a component of the parent directory does not exist
+	case 2005, 2009, 2002:
+		return err.NewNotFoundError()
+	case 2004:
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	// log in required, log in failed, invalid or expired access token
+	case 1000, 2000, 2094, 2095:
+		return err.NewUnauthorizedError(res.Message)
+	}
+	return err.NewRemoteApiError(500, i18n.T("drive.pcloud.remote_error", res.Error()))
+}
+
+func (p *PCloud) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	ed := ec.Data
+	if ed == nil || (ed["id"] == "" && !utils.IsRootPath(ec.Path)) {
+		return nil, errors.New("invalid cache")
+	}
+	return &pcloudEntry{
+		d: p, id: ed["id"],
+		path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+	}, nil
+}
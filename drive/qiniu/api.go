@@ -0,0 +1,76 @@
+package qiniu
+
+import (
+	"bytes"
+	"io"
+)
+
+// the status codes of the errors of Kodo
+const (
+	statusNotFound       = 612
+	statusFileExists     = 614
+	statusBucketNotFound = 631
+)
+
+type apiError struct {
+	Message string `json:"error"`
+}
+
+type statResult struct {
+	Size     int64  `json:"fsize"`
+	Hash     string `json:"hash"`
+	MimeType string `json:"mimeType"`
+	// PutTime is in the units of 100 nanoseconds
+	PutTime int64 `json:"putTime"`
+}
+
+type listItem struct {
+	Key string `json:"key"`
+	statResult
+}
+
+type listResult struct {
+	Marker         string     `json:"marker"`
+	CommonPrefixes []string   `json:"commonPrefixes"`
+	Items          []listItem `json:"items"`
+}
+
+type batchResult struct {
+	Code int      `json:"code"`
+	Data apiError `json:"data"`
+}
+
+type initUploadResult struct {
+	UploadId string `json:"uploadId"`
+}
+
+type uploadPartResult struct {
+	Etag string `json:"etag"`
+}
+
+type completedPart struct {
+	PartNumber int    `json:"partNumber"`
+	Etag       string `json:"etag"`
+}
+
+type completeUpload struct {
+	Parts []completedPart `json:"parts"`
+}
+
+// typedBody is the request body with the content type, like the forms and the multipart forms
+type typedBody struct {
+	b []byte
+	t string
+}
+
+func (b *typedBody) ContentType() string {
+	return b.t
+}
+
+func (b *typedBody) Reader() io.Reader {
+	return bytes.NewReader(b.b)
+}
+
+func (b *typedBody) ContentLength() int64 {
+	return int64(len(b.b))
+}
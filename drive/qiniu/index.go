@@ -0,0 +1,656 @@
+package qiniu
+
+import (
+	"bytes"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "qiniu",
+		DisplayName: i18n.T("drive.qiniu.name"),
+		README:      i18n.T("drive.qiniu.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "ak", Label: i18n.T("drive.qiniu.form.ak.label"), Type: "text", Required: true},
+			{Field: "sk", Label: i18n.T("drive.qiniu.form.sk.label"), Type: "password", Required: true},
+			{Field: "bucket", Label: i18n.T("drive.qiniu.form.bucket.label"), Type: "text", Required: true},
+			{Field: "region", Label: i18n.T("drive.qiniu.form.region.label"), Type: "select", Required: true,
+				DefaultValue: "z0",
+				Options: []types.FormItemOption{
+					{Name: i18n.T("drive.qiniu.form.region.z0"), Value: "z0"},
+					{Name: i18n.T("drive.qiniu.form.region.cn_east_2"), Value: "cn-east-2"},
+					{Name: i18n.T("drive.qiniu.form.region.z1"), Value: "z1"},
+					{Name: i18n.T("drive.qiniu.form.region.z2"), Value: "z2"},
+					{Name: i18n.T("drive.qiniu.form.region.na0"), Value: "na0"},
+					{Name: i18n.T("drive.qiniu.form.region.as0"), Value: "as0"},
+				},
+			},
+			{Field: "domain", Label: i18n.T("drive.qiniu.form.domain.label"), Type: "text", Required: true, Description: i18n.T("drive.qiniu.form.domain.description")},
+			{Field: "private", Label: i18n.T("drive.qiniu.form.private.label"), Type: "checkbox", Description: i18n.T("drive.qiniu.form.private.description")},
+			{Field: "prefix", Label: i18n.T("drive.qiniu.form.prefix.label"), Type: "text", Description: i18n.T("drive.qiniu.form.prefix.description")},
+			{Field: "proxy_upload", Label: i18n.T("drive.qiniu.form.proxy_in.label"), Type: "checkbox", Description: i18n.T("drive.qiniu.form.proxy_in.description")},
+			{Field: "proxy_download", Label: i18n.T("drive.qiniu.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.qiniu.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.qiniu.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.qiniu.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewQiniuDrive},
+	})
+}
+
+const (
+	// formUploadLimit is the max size of the files uploaded by the form upload
+	formUploadLimit = 4 * 1024 * 1024
+	// minPartSize is the size of the parts of the resumable uploads, it grows for the huge files
+	minPartSize = 8 * 1024 * 1024
+	maxParts    = 10000
+	// listLimit is the limit of /list, which is also the most operations a /batch request accepts
+	listLimit = 1000
+	// urlTTL is how long the signed download URLs are valid
+	urlTTL = 8 * time.Hour
+	// uploadTokenTTL is how long the upload tokens are valid, the uploads of large files may take hours
+	uploadTokenTTL = 24 * time.Hour
+)
+
+type QiniuDrive struct {
+	// c calls the management API, the requests are signed
+	c *req.Client
+	// uc uploads and downloads the objects, the tokens are in the URLs or the headers
+	uc *req.Client
+	s  *signer
+
+	bucket string
+	// prefix is the prefix of the keys of the root, it's empty or ends with '/'
+	prefix string
+	// the hosts of the region
+	rsHost, rsfHost, upHost string
+	// domain is the download domain of the bucket, with the scheme
+	domain  string
+	private bool
+
+	uploadProxy   bool
+	downloadProxy bool
+	cacheTTL      time.Duration
+	cache         drive_util.DriveCache
+}
+
+// NewQiniuDrive creates the drive of a Qiniu Kodo bucket
+func NewQiniuDrive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	region := config["region"]
+	if region == "" {
+		region = "z0"
+	}
+	domain := strings.TrimSuffix(config["domain"], "/")
+	if !strings.Contains(domain, "://") {
+		// the test domains of Kodo do not support https
+		domain = "http://" + domain
+	}
+	prefix := utils.CleanPath(config["prefix"])
+	if prefix != "" {
+		prefix += "/"
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	d := &QiniuDrive{
+		s:             &signer{accessKey: config["ak"], secretKey: config["sk"]},
+		bucket:        config["bucket"],
+		prefix:        prefix,
+		rsHost:        "https://rs-" + region + ".qiniuapi.com",
+		rsfHost:       "https://rsf-" + region + ".qiniuapi.com",
+		upHost:        "https://up-" + region + ".qiniup.com",
+		domain:        domain,
+		private:       config["private"] != "",
+		uploadProxy:   config["proxy_upload"] != "",
+		downloadProxy: config["proxy_download"] != "",
+		cacheTTL:      cacheTtl,
+	}
+	if d.c, e = req.NewClient("", d.s.sign, ifApiCallError, nil); e != nil {
+		return nil, e
+	}
+	if d.uc, e = req.NewClient("", nil, ifApiCallError, nil); e != nil {
+		return nil, e
+	}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+	return d, d.check(ctx)
+}
+
+// check checks the bucket and the credentials by listing a key
+func (d *QiniuDrive) check(ctx context.Context) error {
+	_, e := d.list(ctx, d.prefix, "/", "", 1)
+	if err.IsNotFoundError(e) {
+		return err.NewNotFoundMessageError(i18n.T("drive.qiniu.bucket_not_exists", d.bucket))
+	}
+	return e
+}
+
+// key returns the object key of path
+func (d *QiniuDrive) key(path string) string {
+	return d.prefix + path
+}
+
+// objectURL returns the download URL of the object, it's signed if the bucket is private
+func (d *QiniuDrive) objectURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	u := d.domain + "/" + strings.Join(segments, "/")
+	if d.private {
+		u = d.s.signURL(u, urlTTL)
+	}
+	return u
+}
+
+// uploadURL returns the URL of the resumable uploads of key
+func (d *QiniuDrive) uploadURL(key string) string {
+	return d.upHost + "/buckets/" + d.bucket + "/objects/" + encodedKey(key) + "/uploads"
+}
+
+func (d *QiniuDrive) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	return &qiniuEntry{d: d, path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir()}, nil
+}
+
+func (d *QiniuDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *QiniuDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &qiniuEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	entry, e := d.get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	_ = d.cache.PutEntry(entry, d.cacheTTL)
+	return entry, nil
+}
+
+// get stats the object of path, or finds the directory by listing a key prefixed with path + '/'
+func (d *QiniuDrive) get(ctx context.Context, path string) (*qiniuEntry, error) {
+	resp, e := d.c.Get(ctx, d.rsHost+"/stat/"+encodedEntry(d.bucket, d.key(path)), nil)
+	if e == nil {
+		res := statResult{}
+		if e := resp.Json(&res); e != nil {
+			return nil, e
+		}
+		return d.newEntry(path, res), nil
+	}
+	if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	res, e := d.list(ctx, d.key(path)+"/", "", "", 1)
+	if e != nil {
+		return nil, e
+	}
+	if len(res.Items) == 0 {
+		return nil, err.NewNotFoundError()
+	}
+	return &qiniuEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+}
+
+func (d *QiniuDrive) newEntry(path string, res statResult) *qiniuEntry {
+	return &qiniuEntry{d: d, path: path, size: res.Size, modTime: res.PutTime / 10000}
+}
+
+func (d *QiniuDrive) list(ctx context.Context, prefix, delimiter, marker string, limit int) (*listResult, error) {
+	query := url.Values{"bucket": {d.bucket}, "prefix": {prefix}, "limit": {strconv.Itoa(limit)}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	resp, e := d.c.Post(ctx, d.rsfHost+"/list?"+query.Encode(), nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	res := &listResult{}
+	if e := resp.Json(res); e != nil {
+		return nil, e
+	}
+	return res, nil
+}
+
+func (d *QiniuDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(size, true)
+	key := d.key(path)
+	token := d.s.uploadToken(d.bucket, key, override, uploadTokenTTL)
+	var e error
+	if size >= 0 && size <= formUploadLimit {
+		e = d.formUpload(ctx, key, token, drive_util.ProgressReader(reader, ctx))
+	} else {
+		e = d.resumableUpload(ctx, key, token, size, drive_util.ProgressReader(reader, ctx))
+	}
+	_ = d.cache.Evict(path, false)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	return d.Get(ctx, path)
+}
+
+// formUpload uploads the small file by the form upload, the content is buffered in memory
+func (d *QiniuDrive) formUpload(ctx context.Context, key, token string, reader io.Reader) error {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	_ = w.WriteField("token", token)
+	_ = w.WriteField("key", key)
+	fw, e := w.CreateFormFile("file", utils.PathBase(key))
+	if e != nil {
+		return e
+	}
+	if _, e := io.Copy(fw, reader); e != nil {
+		return e
+	}
+	if e := w.Close(); e != nil {
+		return e
+	}
+	resp, e := d.uc.Post(ctx, d.upHost, nil, &typedBody{b: buf.Bytes(), t: w.FormDataContentType()})
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+// partSize returns the size of the parts to upload a file of size, size < 0 means unknown
+func partSize(size int64) int64 {
+	if size > minPartSize*maxParts {
+		return (size + maxParts - 1) / maxParts
+	}
+	return minPartSize
+}
+
+// resumableUpload uploads the file by the resumable upload of version 2,
+// the parts are buffered in memory, the upload is aborted if any part fails,
+// see https://developer.qiniu.com/kodo/6364/multipartupload-interface
+func (d *QiniuDrive) resumableUpload(ctx types.TaskCtx, key, token string, size int64, reader io.Reader) error {
+	headers := types.SM{"Authorization": "UpToken " + token}
+	uploadURL := d.uploadURL(key)
+	resp, e := d.uc.Post(ctx, uploadURL, headers, nil)
+	if e != nil {
+		return e
+	}
+	initiated := initUploadResult{}
+	if e := resp.Json(&initiated); e != nil {
+		return e
+	}
+	uploadURL += "/" + initiated.UploadId
+	parts, e := d.uploadParts(ctx, uploadURL, headers, partSize(size), reader)
+	if e == nil {
+		resp, e = d.uc.Post(ctx, uploadURL, headers, req.NewJsonBody(completeUpload{Parts: parts}))
+		if e == nil {
+			_ = resp.Dispose()
+		}
+	}
+	if e != nil {
+		resp, ee := d.uc.Request(context.Background(), "DELETE", uploadURL, headers, nil)
+		if ee == nil {
+			_ = resp.Dispose()
+		}
+	}
+	return e
+}
+
+func (d *QiniuDrive) uploadParts(ctx types.TaskCtx, uploadURL string, headers types.SM,
+	partSize int64, reader io.Reader) ([]completedPart, error) {
+	parts := make([]completedPart, 0)
+	buf := make([]byte, partSize)
+	for {
+		if e := ctx.WaitIfPaused(); e != nil {
+			return nil, e
+		}
+		n, e := io.ReadFull(reader, buf)
+		if e != nil && e != io.ErrUnexpectedEOF && e != io.EOF {
+			return nil, e
+		}
+		if n == 0 && len(parts) > 0 {
+			break
+		}
+		number := len(parts) + 1
+		resp, ee := d.uc.Request(ctx, "PUT", uploadURL+"/"+strconv.Itoa(number), headers,
+			req.NewReaderBody(bytes.NewReader(buf[:n]), int64(n)))
+		if ee != nil {
+			return nil, ee
+		}
+		res := uploadPartResult{}
+		if ee := resp.Json(&res); ee != nil {
+			return nil, ee
+		}
+		parts = append(parts, completedPart{PartNumber: number, Etag: res.Etag})
+		if e != nil {
+			break
+		}
+	}
+	return parts, nil
+}
+
+func (d *QiniuDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := d.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	key := d.key(path) + "/"
+	if e := d.formUpload(ctx, key, d.s.uploadToken(d.bucket, key, true, uploadTokenTTL),
+		bytes.NewReader(nil)); e != nil {
+		return nil, e
+	}
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return &qiniuEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+}
+
+func (d *QiniuDrive) isSelf(e types.IEntry) bool {
+	if qe, ok := e.(*qiniuEntry); ok {
+		return qe.d == d
+	}
+	return false
+}
+
+// copyOrMove copies or moves the object natively, the directories are not supported
+func (d *QiniuDrive) copyOrMove(ctx types.TaskCtx, op string, from types.IEntry,
+	to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || from.Type().IsDir() {
+		return nil, err.NewUnsupportedError()
+	}
+	ctx.Total(from.Size(), false)
+	resp, e := d.c.Post(ctx, d.rsHost+"/"+op+"/"+encodedEntry(d.bucket, d.key(from.Path()))+
+		"/"+encodedEntry(d.bucket, d.key(to))+"/force/"+strconv.FormatBool(override), nil, nil)
+	_ = d.cache.Evict(to, true)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	ctx.Progress(from.Size(), false)
+	return d.Get(ctx, to)
+}
+
+func (d *QiniuDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	return d.copyOrMove(ctx, "copy", from, to, override)
+}
+
+func (d *QiniuDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	entry, e := d.copyOrMove(ctx, "move", from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	_ = d.cache.Evict(from.Path(), true)
+	_ = d.cache.Evict(utils.PathParent(from.Path()), false)
+	return entry, nil
+}
+
+// List lists the directory page by page, the placeholder object of the directory is skipped
+func (d *QiniuDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	prefix := d.prefix
+	if !utils.IsRootPath(path) {
+		prefix = d.key(path) + "/"
+	}
+	entries := make([]types.IEntry, 0)
+	files := make(map[string]bool)
+	marker := ""
+	for {
+		res, e := d.list(ctx, prefix, "/", marker, listLimit)
+		if e != nil {
+			return nil, e
+		}
+		for _, o := range res.Items {
+			if o.Key == prefix {
+				continue
+			}
+			p := strings.TrimPrefix(o.Key, d.prefix)
+			entries = append(entries, d.newEntry(p, o.statResult))
+			files[p] = true
+		}
+		for _, cp := range res.CommonPrefixes {
+			p := strings.TrimSuffix(strings.TrimPrefix(cp, d.prefix), "/")
+			if files[p] {
+				// skip the directory with the same name of a file
+				continue
+			}
+			entries = append(entries, &qiniuEntry{d: d, path: p, isDir: true, modTime: -1})
+		}
+		if res.Marker == "" {
+			break
+		}
+		marker = res.Marker
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+// Delete deletes the object, or the objects under the directory by a /batch request for every page of the listing
+func (d *QiniuDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := d.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	if entry.Type().IsFile() {
+		e = d.deleteKeys(ctx, []string{d.key(path)})
+	} else {
+		e = d.deleteDir(ctx, d.key(path)+"/")
+	}
+	_ = d.cache.Evict(path, true)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+func (d *QiniuDrive) deleteDir(ctx types.TaskCtx, prefix string) error {
+	marker := ""
+	for {
+		if ctx.Canceled() {
+			return task.ErrorCanceled
+		}
+		res, e := d.list(ctx, prefix, "", marker, listLimit)
+		if e != nil {
+			return e
+		}
+		if len(res.Items) > 0 {
+			keys := make([]string, len(res.Items))
+			for i, o := range res.Items {
+				keys[i] = o.Key
+			}
+			if e := d.deleteKeys(ctx, keys); e != nil {
+				return e
+			}
+			ctx.Progress(int64(len(keys)), false)
+		}
+		if res.Marker == "" {
+			return nil
+		}
+		marker = res.Marker
+	}
+}
+
+// deleteKeys deletes at most listLimit keys by the batch operation, the keys not found are ignored
+func (d *QiniuDrive) deleteKeys(ctx context.Context, keys []string) error {
+	form := url.Values{}
+	for _, k := range keys {
+		form.Add("op", "/delete/"+encodedEntry(d.bucket, k))
+	}
+	resp, e := d.c.Post(ctx, d.rsHost+"/batch", nil,
+		&typedBody{b: []byte(form.Encode()), t: "application/x-www-form-urlencoded"})
+	if e != nil {
+		return e
+	}
+	results := make([]batchResult, 0)
+	if e := resp.Json(&results); e != nil {
+		return e
+	}
+	for _, r := range results {
+		if r.Code != http.StatusOK && r.Code != statusNotFound {
+			return err.NewRemoteApiError(r.Code, i18n.T("drive.qiniu.remote_error", r.Data.Message))
+		}
+	}
+	return nil
+}
+
+// Upload returns the upload token and the URL of the resumable upload of version 2,
+// the parts are uploaded to Kodo directly from the browser
+func (d *QiniuDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, config types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	// the resumable upload requires at least one non-empty part
+	if d.uploadProxy || size <= 0 {
+		return types.UseLocalProvider(size), nil
+	}
+	switch config["action"] {
+	case "CompleteUpload":
+		_ = d.cache.Evict(path, false)
+		_ = d.cache.Evict(utils.PathParent(path), false)
+		return nil, nil
+	default:
+		key := d.key(path)
+		return &types.DriveUploadConfig{
+			Provider: types.QiniuProvider,
+			Config: types.SM{
+				"url":      d.uploadURL(key),
+				"token":    "UpToken " + d.s.uploadToken(d.bucket, key, override, uploadTokenTTL),
+				"partSize": strconv.FormatInt(partSize(size), 10),
+			},
+		}, nil
+	}
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	if resp.Status() == http.StatusNotFound || resp.Status() == statusNotFound ||
+		resp.Status() == statusBucketNotFound {
+		_ = resp.Dispose()
+		return err.NewNotFoundError()
+	}
+	ae := apiError{}
+	if e := resp.Json(&ae); e != nil || ae.Message == "" {
+		ae.Message = resp.Response().Status
+	}
+	switch resp.Status() {
+	case statusFileExists:
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return err.NewNotAllowedMessageError(i18n.T("drive.qiniu.remote_error", ae.Message))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.qiniu.remote_error", ae.Message))
+}
+
+type qiniuEntry struct {
+	d       *QiniuDrive
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+}
+
+func (e *qiniuEntry) Path() string {
+	return e.path
+}
+
+func (e *qiniuEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *qiniuEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *qiniuEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *qiniuEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *qiniuEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *qiniuEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *qiniuEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+func (e *qiniuEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	var header types.SM
+	if offset > 0 || length >= 0 {
+		rangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if length >= 0 {
+			if length == 0 {
+				return ioutil.NopCloser(strings.NewReader("")), nil
+			}
+			rangeHeader += strconv.FormatInt(offset+length-1, 10)
+		}
+		header = types.SM{"Range": rangeHeader}
+	}
+	resp, ee := e.d.uc.Get(ctx, e.d.objectURL(e.d.key(e.path)), header)
+	if ee != nil {
+		return nil, ee
+	}
+	return resp.Response().Body, nil
+}
+
+// GetURL returns the URL of the object on the download domain, it's signed if the bucket is private
+func (e *qiniuEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	return &types.ContentURL{URL: e.d.objectURL(e.d.key(e.path)), Proxy: e.d.downloadProxy}, nil
+}
@@ -0,0 +1,100 @@
+package qiniu
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signer signs the requests of the management API, the upload tokens and the private download URLs,
+// see https://developer.qiniu.com/kodo/1201/access-token
+type signer struct {
+	accessKey string
+	secretKey string
+}
+
+func (s *signer) signature(data []byte) string {
+	h := hmac.New(sha1.New, []byte(s.secretKey))
+	_, _ = h.Write(data)
+	return base64.URLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// token returns the access token of data
+func (s *signer) token(data []byte) string {
+	return s.accessKey + ":" + s.signature(data)
+}
+
+// sign sets the QBox authorization of the request,
+// the body is signed only if it's a form
+func (s *signer) sign(r *http.Request) error {
+	data := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		data += "?" + r.URL.RawQuery
+	}
+	data += "\n"
+	if r.GetBody != nil && r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		body, e := r.GetBody()
+		if e != nil {
+			return e
+		}
+		b, e := ioutil.ReadAll(body)
+		_ = body.Close()
+		if e != nil {
+			return e
+		}
+		data += string(b)
+	}
+	r.Header.Set("Authorization", "QBox "+s.token([]byte(data)))
+	return nil
+}
+
+// putPolicy is the policy of the upload token,
+// see https://developer.qiniu.com/kodo/1206/put-policy
+type putPolicy struct {
+	Scope      string `json:"scope"`
+	Deadline   int64  `json:"deadline"`
+	InsertOnly int    `json:"insertOnly,omitempty"`
+	DetectMime int    `json:"detectMime,omitempty"`
+}
+
+// uploadToken returns the token to upload key to the bucket,
+// the existing object is overridden only if override is true
+func (s *signer) uploadToken(bucket, key string, override bool, ttl time.Duration) string {
+	policy := putPolicy{Scope: bucket + ":" + key, Deadline: time.Now().Add(ttl).Unix(), DetectMime: 1}
+	if !override {
+		policy.InsertOnly = 1
+	}
+	b, _ := json.Marshal(policy)
+	encoded := base64.URLEncoding.EncodeToString(b)
+	return s.token([]byte(encoded)) + ":" + encoded
+}
+
+// signURL returns the download URL of the object in a private bucket, which expires after ttl,
+// see https://developer.qiniu.com/kodo/1202/download-token
+func (s *signer) signURL(u string, ttl time.Duration) string {
+	sep := "?"
+	if strings.Contains(u, "?") {
+		sep = "&"
+	}
+	u += sep + "e=" + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return u + "&token=" + s.token([]byte(u))
+}
+
+// encodedEntry returns the encoded 'bucket:key' used in the paths of the management API
+func encodedEntry(bucket, key string) string {
+	return base64.URLEncoding.EncodeToString([]byte(bucket + ":" + key))
+}
+
+// encodedKey returns the encoded key used in the paths of the upload API
+func encodedKey(key string) string {
+	if key == "" {
+		return "~"
+	}
+	return base64.URLEncoding.EncodeToString([]byte(key))
+}
@@ -0,0 +1,74 @@
+package qiniu
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQiniuSign(t *testing.T) {
+	s := &signer{accessKey: "ak", secretKey: "sk"}
+
+	r, _ := http.NewRequest("POST", "https://rs-z0.qiniuapi.com/batch?x=1", strings.NewReader("op=%2Fdelete%2Fa"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if e := s.sign(r); e != nil {
+		t.Fatal(e)
+	}
+	if auth := r.Header.Get("Authorization"); auth != "QBox "+s.token([]byte("/batch?x=1\nop=%2Fdelete%2Fa")) {
+		t.Errorf("unexpected authorization: %s", auth)
+	}
+
+	// the body is not signed if it's not a form
+	r, _ = http.NewRequest("POST", "https://rs-z0.qiniuapi.com/batch", strings.NewReader("{}"))
+	r.Header.Set("Content-Type", "application/json")
+	_ = s.sign(r)
+	if auth := r.Header.Get("Authorization"); auth != "QBox "+s.token([]byte("/batch\n")) {
+		t.Errorf("unexpected authorization: %s", auth)
+	}
+}
+
+func TestQiniuUploadToken(t *testing.T) {
+	s := &signer{accessKey: "ak", secretKey: "sk"}
+	token := s.uploadToken("bucket", "a/b.txt", false, time.Hour)
+	parts := strings.Split(token, ":")
+	if len(parts) != 3 || parts[0] != "ak" || parts[1] != s.signature([]byte(parts[2])) {
+		t.Fatalf("unexpected token: %s", token)
+	}
+	b, e := base64.URLEncoding.DecodeString(parts[2])
+	if e != nil {
+		t.Fatal(e)
+	}
+	policy := putPolicy{}
+	if e := json.Unmarshal(b, &policy); e != nil {
+		t.Fatal(e)
+	}
+	if policy.Scope != "bucket:a/b.txt" || policy.InsertOnly != 1 || policy.Deadline <= time.Now().Unix() {
+		t.Errorf("unexpected policy: %s", b)
+	}
+}
+
+func TestQiniuSignURL(t *testing.T) {
+	s := &signer{accessKey: "ak", secretKey: "sk"}
+	for _, u := range []string{"http://cdn.example.com/a%20b.txt", "http://cdn.example.com/a.txt?imageView2/1"} {
+		signed := s.signURL(u, time.Hour)
+		i := strings.LastIndex(signed, "&token=")
+		if i < 0 || !strings.HasPrefix(signed, u) || !strings.Contains(signed[len(u):i], "e=") {
+			t.Fatalf("unexpected url: %s", signed)
+		}
+		if token := signed[i+len("&token="):]; token != s.token([]byte(signed[:i])) {
+			t.Errorf("unexpected token of %s", signed)
+		}
+	}
+}
+
+func TestQiniuEncodedKey(t *testing.T) {
+	if k := encodedKey(""); k != "~" {
+		t.Errorf("unexpected key: %s", k)
+	}
+	if k := encodedKey("a/b?c.txt"); k != base64.URLEncoding.EncodeToString([]byte("a/b?c.txt")) {
+		t.Errorf("unexpected key: %s", k)
+	}
+}
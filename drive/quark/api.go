@@ -0,0 +1,175 @@
+package quark
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"io"
+	"net/http"
+)
+
+// platform is the API of Quark or UC, they share the same API
+type platform struct {
+	api       string
+	referer   string
+	userAgent string
+}
+
+const (
+	platformQuark = "quark"
+	platformUC    = "uc"
+)
+
+var platforms = map[string]platform{
+	platformQuark: {
+		api:     "https://drive.quark.cn/1/clouddrive?pr=ucpro&fr=pc",
+		referer: "https://pan.quark.cn",
+		userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) " +
+			"quark-cloud-drive/2.5.20 Chrome/100.0.4896.160 Electron/18.3.5.4-b478491100 Safari/537.36 Channel/pckk_other_ch",
+	},
+	platformUC: {
+		api:     "https://pc-api.uc.cn/1/clouddrive?pr=UCBrowser&fr=pc",
+		referer: "https://drive.uc.cn",
+		userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) " +
+			"uc-cloud-drive/2.5.20 Chrome/100.0.4896.160 Electron/18.3.5.4-b478491100 Safari/537.36 Channel/pckk_other_ch",
+	},
+}
+
+// ossUserAgent is signed in the uploads, which are authorized by the API
+const ossUserAgent = "aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit"
+
+// codeLoginRequired is the code when the cookie is invalid or expired
+const codeLoginRequired = 31001
+
+// taskFinished is the status of the finished tasks
+const taskFinished = 2
+
+// apiResult is the envelope of the responses
+type apiResult struct {
+	Status   int             `json:"status"`
+	Code     int             `json:"code"`
+	Message  string          `json:"message"`
+	Data     json.RawMessage `json:"data"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+type file struct {
+	Fid      string `json:"fid"`
+	FileName string `json:"file_name"`
+	Size     int64  `json:"size"`
+	// File is false for the directories
+	File bool `json:"file"`
+	// UpdatedAt is in milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+type listData struct {
+	List []file `json:"list"`
+}
+
+type listMetadata struct {
+	Total int `json:"_total"`
+}
+
+type downloadData struct {
+	Fid         string `json:"fid"`
+	DownloadURL string `json:"download_url"`
+}
+
+// taskData is the result of the operations which may be done by tasks
+type taskData struct {
+	TaskId string `json:"task_id"`
+	Finish bool   `json:"finish"`
+	Status int    `json:"status"`
+}
+
+type preData struct {
+	TaskId    string          `json:"task_id"`
+	Finish    bool            `json:"finish"`
+	UploadId  string          `json:"upload_id"`
+	ObjKey    string          `json:"obj_key"`
+	UploadURL string          `json:"upload_url"`
+	Fid       string          `json:"fid"`
+	Bucket    string          `json:"bucket"`
+	AuthInfo  string          `json:"auth_info"`
+	Callback  json.RawMessage `json:"callback"`
+}
+
+type preMetadata struct {
+	PartSize int64 `json:"part_size"`
+}
+
+type authData struct {
+	AuthKey string `json:"auth_key"`
+}
+
+type completePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeUpload struct {
+	XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+	Parts   []completePart `xml:"Part"`
+}
+
+// ifApiCallError checks the errors responded with the status other than 2xx
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	r := apiResult{}
+	if e := resp.Json(&r); e != nil || r.Message == "" {
+		r.Message = resp.Response().Status
+	}
+	switch {
+	case resp.Status() == http.StatusNotFound:
+		return err.NewNotFoundError()
+	case r.Code == codeLoginRequired || resp.Status() == http.StatusUnauthorized:
+		return err.NewUnauthorizedError(i18n.T("drive.quark.unauthorized", r.Message))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.quark.remote_error", r.Message))
+}
+
+// checkResult returns the error of the result, which is responded with the status 200
+func checkResult(r apiResult) error {
+	if r.Code == 0 {
+		return nil
+	}
+	if r.Code == codeLoginRequired {
+		return err.NewUnauthorizedError(i18n.T("drive.quark.unauthorized", r.Message))
+	}
+	return err.NewRemoteApiError(500, i18n.T("drive.quark.remote_error", r.Message))
+}
+
+type typedBody struct {
+	r    io.Reader
+	t    string
+	size int64
+}
+
+func (b *typedBody) ContentType() string {
+	return b.t
+}
+
+func (b *typedBody) Reader() io.Reader {
+	return b.r
+}
+
+func (b *typedBody) ContentLength() int64 {
+	return b.size
+}
+
+// ifContentError checks the responses of the downloads and OSS, the content must not be read
+func ifContentError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	_ = resp.Dispose()
+	if resp.Status() == http.StatusNotFound {
+		return err.NewNotFoundError()
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.quark.remote_error", resp.Response().Status))
+}
@@ -0,0 +1,605 @@
+package quark
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	path2 "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "quark",
+		DisplayName: i18n.T("drive.quark.name"),
+		README:      i18n.T("drive.quark.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "platform", Label: i18n.T("drive.quark.form.platform.label"), Type: "select", Required: true,
+				DefaultValue: platformQuark,
+				Options: []types.FormItemOption{
+					{Name: i18n.T("drive.quark.form.platform.quark"), Value: platformQuark},
+					{Name: i18n.T("drive.quark.form.platform.uc"), Value: platformUC},
+				},
+			},
+			{Field: "cookie", Label: i18n.T("drive.quark.form.cookie.label"), Type: "password", Required: true, Description: i18n.T("drive.quark.form.cookie.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.quark.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.quark.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewQuark},
+	})
+}
+
+const (
+	// listLimit is the _size of the pages of /file/sort
+	listLimit = 100
+	// defaultPartSize is the size of the parts of the uploads, if it's not specified by the API
+	defaultPartSize = 8 * 1024 * 1024
+)
+
+type Quark struct {
+	p   platform
+	jar *cookieJar
+	// c calls the API, dc downloads the files, uc uploads the parts to OSS
+	c  *req.Client
+	dc *req.Client
+	uc *req.Client
+
+	tempDir string
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+}
+
+// NewQuark creates the drive of Quark or UC by the cookie of the web
+func NewQuark(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	p, ok := platforms[config["platform"]]
+	if !ok {
+		p = platforms[platformQuark]
+	}
+	cookie := strings.TrimSpace(config["cookie"])
+	if cookie == "" {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.not_configured"))
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	d := &Quark{
+		p:        p,
+		jar:      &cookieJar{cookie: cookie},
+		tempDir:  driveUtils.Config.TempDir,
+		cacheTTL: cacheTtl,
+	}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+	if d.c, e = newApiClient(p, d.jar, p.api, ifApiCallError); e != nil {
+		return nil, e
+	}
+	if d.dc, e = newApiClient(p, d.jar, "", ifContentError); e != nil {
+		return nil, e
+	}
+	if d.uc, e = req.NewClient("", nil, ifContentError, nil); e != nil {
+		return nil, e
+	}
+	return d, nil
+}
+
+func (d *Quark) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+// call requests the API, the data and the metadata of the result are decoded to them if they are not nil
+func (d *Quark) call(ctx context.Context, method, path string, body types.M, data, metadata interface{}) error {
+	var b req.RequestBody
+	if body != nil {
+		b = req.NewJsonBody(body)
+	}
+	resp, e := d.c.Request(ctx, method, path, nil, b)
+	if e != nil {
+		return e
+	}
+	r := apiResult{}
+	if e := resp.Json(&r); e != nil {
+		return e
+	}
+	if e := checkResult(r); e != nil {
+		return e
+	}
+	if data != nil && len(r.Data) > 0 {
+		if e := json.Unmarshal(r.Data, data); e != nil {
+			return e
+		}
+	}
+	if metadata != nil && len(r.Metadata) > 0 {
+		if e := json.Unmarshal(r.Metadata, metadata); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Get finds the entry in the listing of the parent by /file/sort, as Quark addresses the files by the fids
+func (d *Quark) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &quarkEntry{d: d, id: "0", path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	children, e := d.List(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	for _, c := range children {
+		if c.Path() == path {
+			_ = d.cache.PutEntry(c, d.cacheTTL)
+			return c, nil
+		}
+	}
+	return nil, err.NewNotFoundError()
+}
+
+// getDir returns the directory, it fails if path is a file
+func (d *Quark) getDir(ctx context.Context, path string) (*quarkEntry, error) {
+	dir, e := d.Get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if !dir.Type().IsDir() {
+		return nil, err.NewNotAllowedError()
+	}
+	return dir.(*quarkEntry), nil
+}
+
+// prepareTarget makes sure path can be written, the existing entry is deleted if override is true,
+// as Quark renames the new files instead of replacing the existing ones
+func (d *Quark) prepareTarget(ctx types.TaskCtx, path string, override bool) error {
+	existing, e := d.Get(ctx, path)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return nil
+		}
+		return e
+	}
+	if !override {
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	return d.delete(ctx, existing.(*quarkEntry))
+}
+
+// Save uploads the file by the rapid upload if Quark has the same content, or by parts to OSS.
+// The file is buffered in a temp file, as the MD5 and the SHA1 are required before uploading
+func (d *Quark) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if e := d.prepareTarget(ctx, path, override); e != nil {
+		return nil, e
+	}
+	parent, e := d.getDir(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	ctx.Total(size, true)
+	md5Hash, sha1Hash := md5.New(), sha1.New()
+	tempFile, e := drive_util.CopyReaderToTempFile(task.NewCtxWrapper(ctx, false, false),
+		io.TeeReader(reader, io.MultiWriter(md5Hash, sha1Hash)), d.tempDir)
+	if e != nil {
+		return nil, e
+	}
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+	stat, e := tempFile.Stat()
+	if e != nil {
+		return nil, e
+	}
+	size = stat.Size()
+	ctx.Total(size, true)
+
+	mimeType := mime.TypeByExtension(path2.Ext(path))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	now := utils.Millisecond(time.Now())
+	pre, meta := preData{}, preMetadata{}
+	if e := d.call(ctx, "POST", "/file/upload/pre", types.M{
+		"ccp_hash_update": true, "dir_name": "", "file_name": utils.PathBase(path), "format_type": mimeType,
+		"l_created_at": now, "l_updated_at": now, "pdir_fid": parent.id, "size": size,
+	}, &pre, &meta); e != nil {
+		return nil, e
+	}
+	if !pre.Finish {
+		hash := taskData{}
+		if e := d.call(ctx, "POST", "/file/update/hash", types.M{
+			"md5":     hex.EncodeToString(md5Hash.Sum(nil)),
+			"sha1":    hex.EncodeToString(sha1Hash.Sum(nil)),
+			"task_id": pre.TaskId,
+		}, &hash, nil); e != nil {
+			return nil, e
+		}
+		// the upload is finished by the rapid upload if Quark has the same content
+		if !hash.Finish {
+			if e := d.uploadParts(ctx, tempFile, size, mimeType, &pre, meta.PartSize); e != nil {
+				return nil, e
+			}
+			if e := d.call(ctx, "POST", "/file/upload/finish", types.M{
+				"obj_key": pre.ObjKey, "task_id": pre.TaskId,
+			}, nil, nil); e != nil {
+				return nil, e
+			}
+		}
+	}
+	ctx.Progress(size, true)
+	_ = d.cache.Evict(path, false)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return d.Get(ctx, path)
+}
+
+// authorize returns the Authorization of the request to OSS, which is signed by the API
+func (d *Quark) authorize(ctx context.Context, pre *preData, authMeta string) (string, error) {
+	a := authData{}
+	e := d.call(ctx, "POST", "/file/upload/auth", types.M{
+		"auth_info": pre.AuthInfo, "auth_meta": authMeta, "task_id": pre.TaskId,
+	}, &a, nil)
+	return a.AuthKey, e
+}
+
+// uploadParts uploads the file to OSS by the multipart upload, and completes it with the callback of Quark
+func (d *Quark) uploadParts(ctx types.TaskCtx, file *os.File, size int64,
+	mimeType string, pre *preData, partSize int64) error {
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	u, e := ossURL(pre)
+	if e != nil {
+		return e
+	}
+	referer := d.p.referer + "/"
+	parts := make([]completePart, 0)
+	for i := 0; i == 0 || int64(i)*partSize < size; i++ {
+		if e := ctx.WaitIfPaused(); e != nil {
+			return e
+		}
+		offset := int64(i) * partSize
+		length := partSize
+		if offset+length > size {
+			length = size - offset
+		}
+		date := time.Now().UTC().Format(http.TimeFormat)
+		auth, e := d.authorize(ctx, pre, partAuthMeta(mimeType, date, pre.Bucket, pre.ObjKey, pre.UploadId, i+1))
+		if e != nil {
+			return e
+		}
+		resp, e := d.uc.Request(ctx, "PUT", u+"?"+url.Values{
+			"partNumber": {strconv.Itoa(i + 1)},
+			"uploadId":   {pre.UploadId},
+		}.Encode(), types.SM{
+			"Authorization": auth, "Referer": referer, "x-oss-date": date, "x-oss-user-agent": ossUserAgent,
+		}, &typedBody{
+			r:    drive_util.ProgressReader(io.NewSectionReader(file, offset, length), ctx),
+			t:    mimeType,
+			size: length,
+		})
+		if e != nil {
+			return e
+		}
+		parts = append(parts, completePart{PartNumber: i + 1, ETag: resp.Response().Header.Get("ETag")})
+		_ = resp.Dispose()
+	}
+
+	body, e := xml.Marshal(completeUpload{Parts: parts})
+	if e != nil {
+		return e
+	}
+	sum := md5.Sum(body)
+	contentMd5 := base64.StdEncoding.EncodeToString(sum[:])
+	callback := bytes.Buffer{}
+	if e := json.Compact(&callback, pre.Callback); e != nil {
+		return e
+	}
+	callbackBase64 := base64.StdEncoding.EncodeToString(callback.Bytes())
+	date := time.Now().UTC().Format(http.TimeFormat)
+	auth, e := d.authorize(ctx, pre,
+		completeAuthMeta(contentMd5, date, callbackBase64, pre.Bucket, pre.ObjKey, pre.UploadId))
+	if e != nil {
+		return e
+	}
+	resp, e := d.uc.Request(ctx, "POST", u+"?"+url.Values{"uploadId": {pre.UploadId}}.Encode(), types.SM{
+		"Authorization": auth, "Content-MD5": contentMd5, "Referer": referer,
+		"x-oss-callback": callbackBase64, "x-oss-date": date, "x-oss-user-agent": ossUserAgent,
+	}, &typedBody{r: bytes.NewReader(body), t: "application/xml", size: int64(len(body))})
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+func (d *Quark) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := d.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	parent, e := d.getDir(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	if e := d.call(ctx, "POST", "/file", types.M{
+		"dir_init_lock": false, "dir_path": "", "file_name": utils.PathBase(path), "pdir_fid": parent.id,
+	}, nil, nil); e != nil {
+		return nil, e
+	}
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return d.Get(ctx, path)
+}
+
+func (d *Quark) isSelf(e types.IEntry) bool {
+	if qe, ok := e.(*quarkEntry); ok {
+		return qe.d == d
+	}
+	return false
+}
+
+// Copy is not supported by the API, the files are copied by downloading and uploading
+func (d *Quark) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+// Move moves the entry to the parent of to, and renames it if the name changes
+func (d *Quark) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	if e := d.prepareTarget(ctx, to, override); e != nil {
+		return nil, e
+	}
+	id := from.(*quarkEntry).id
+	e := d.move(ctx, id, utils.PathParent(from.Path()), utils.PathParent(to))
+	if e == nil && utils.PathBase(from.Path()) != utils.PathBase(to) {
+		e = d.call(ctx, "POST", "/file/rename", types.M{"fid": id, "file_name": utils.PathBase(to)}, nil, nil)
+	}
+	_ = d.cache.Evict(from.Path(), true)
+	_ = d.cache.Evict(utils.PathParent(from.Path()), false)
+	_ = d.cache.Evict(to, true)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	return d.Get(ctx, to)
+}
+
+func (d *Quark) move(ctx types.TaskCtx, id, fromParent, toParent string) error {
+	if fromParent == toParent {
+		return nil
+	}
+	parent, e := d.getDir(ctx, toParent)
+	if e != nil {
+		return e
+	}
+	t := taskData{}
+	if e := d.call(ctx, "POST", "/file/move", types.M{
+		"action_type": 1, "exclude_fids": []string{}, "filelist": []string{id}, "to_pdir_fid": parent.id,
+	}, &t, nil); e != nil {
+		return e
+	}
+	return d.waitTask(ctx, t)
+}
+
+func (d *Quark) waitTask(ctx types.TaskCtx, t taskData) error {
+	if t.Finish {
+		return nil
+	}
+	_, e := drive_util.WaitAsyncOp(ctx, &operation{d: d, taskId: t.TaskId}, 0)
+	return e
+}
+
+// List lists the directory page by page
+func (d *Quark) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	dir, e := d.getDir(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, 0)
+	for page := 1; ; page++ {
+		data, meta := listData{}, listMetadata{}
+		if e := d.call(ctx, "GET", "/file/sort?"+url.Values{
+			"pdir_fid":     {dir.id},
+			"_page":        {strconv.Itoa(page)},
+			"_size":        {strconv.Itoa(listLimit)},
+			"_fetch_total": {"1"},
+			"_sort":        {"file_type:asc,updated_at:desc"},
+		}.Encode(), nil, &data, &meta); e != nil {
+			return nil, e
+		}
+		for _, f := range data.List {
+			entries = append(entries, d.newEntry(path, f))
+		}
+		if len(data.List) == 0 || len(entries) >= meta.Total {
+			break
+		}
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+func (d *Quark) delete(ctx types.TaskCtx, entry *quarkEntry) error {
+	t := taskData{}
+	e := d.call(ctx, "POST", "/file/delete", types.M{
+		"action_type": 1, "exclude_fids": []string{}, "filelist": []string{entry.id},
+	}, &t, nil)
+	if e == nil {
+		e = d.waitTask(ctx, t)
+	}
+	_ = d.cache.Evict(entry.path, true)
+	_ = d.cache.Evict(utils.PathParent(entry.path), false)
+	return e
+}
+
+// Delete moves the file or the directory to the recycle bin of Quark
+func (d *Quark) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := d.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	return d.delete(ctx, entry.(*quarkEntry))
+}
+
+func (d *Quark) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *Quark) newEntry(parent string, f file) *quarkEntry {
+	modTime := f.UpdatedAt
+	if modTime <= 0 {
+		modTime = -1
+	}
+	return &quarkEntry{
+		d:       d,
+		id:      f.Fid,
+		path:    path2.Join(parent, f.FileName),
+		isDir:   !f.File,
+		size:    f.Size,
+		modTime: modTime,
+	}
+}
+
+type quarkEntry struct {
+	d       *Quark
+	id      string
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+}
+
+func (e *quarkEntry) Path() string {
+	return e.path
+}
+
+func (e *quarkEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *quarkEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *quarkEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *quarkEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *quarkEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *quarkEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *quarkEntry) downloadURL(ctx context.Context) (string, error) {
+	r := make([]downloadData, 0)
+	if ee := e.d.call(ctx, "POST", "/file/download", types.M{"fids": []string{e.id}}, &r, nil); ee != nil {
+		return "", ee
+	}
+	if len(r) == 0 || r[0].DownloadURL == "" {
+		return "", err.NewNotFoundError()
+	}
+	return r[0].DownloadURL, nil
+}
+
+func (e *quarkEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+func (e *quarkEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	u, ee := e.downloadURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	var header types.SM
+	if offset > 0 || length >= 0 {
+		rangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if length >= 0 {
+			if length == 0 {
+				return ioutil.NopCloser(strings.NewReader("")), nil
+			}
+			rangeHeader += strconv.FormatInt(offset+length-1, 10)
+		}
+		header = types.SM{"Range": rangeHeader}
+	}
+	resp, ee := e.d.dc.Get(ctx, u, header)
+	if ee != nil {
+		return nil, ee
+	}
+	return resp.Response().Body, nil
+}
+
+// GetURL returns the download URL with the cookie it requires, so the downloads are proxied by the server
+func (e *quarkEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	u, ee := e.downloadURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	return &types.ContentURL{URL: u, Header: e.d.jar.header(e.d.p), Proxy: true}, nil
+}
+
+func (e *quarkEntry) EntryData() types.SM {
+	return types.SM{"id": e.id}
+}
+
+func (e *quarkEntry) StableID() string {
+	return e.id
+}
@@ -0,0 +1,148 @@
+package quark
+
+import (
+	"context"
+	"errors"
+	"go-drive/common/drive_util"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// setCookie replaces the value of the cookie in the Cookie header, or appends it if it's missing
+func setCookie(header, name, value string) string {
+	cookies := make([]string, 0)
+	found := false
+	for _, c := range strings.Split(header, ";") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if strings.SplitN(c, "=", 2)[0] == name {
+			c, found = name+"="+value, true
+		}
+		cookies = append(cookies, c)
+	}
+	if !found {
+		cookies = append(cookies, name+"="+value)
+	}
+	return strings.Join(cookies, "; ")
+}
+
+// cookieJar keeps the cookie, the session of Quark is renewed by the '__puus' cookie set by the API.
+// The renewed cookie is kept in memory, the cookie in the config is used again after the drive is reloaded
+type cookieJar struct {
+	mu     sync.Mutex
+	cookie string
+}
+
+func (j *cookieJar) get() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.cookie
+}
+
+func (j *cookieJar) update(cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		if c.Name == "__puus" && c.Value != "" {
+			j.cookie = setCookie(j.cookie, c.Name, c.Value)
+		}
+	}
+}
+
+// header returns the headers required by the API and the download URLs
+func (j *cookieJar) header(p platform) types.SM {
+	return types.SM{
+		"Cookie":     j.get(),
+		"User-Agent": p.userAgent,
+		"Referer":    p.referer,
+	}
+}
+
+// newApiClient returns the client of the API sending the cookie,
+// if base is empty, it requests the URLs as they are, like the download URLs
+func newApiClient(p platform, jar *cookieJar, base string, after func(req.Response) error) (*req.Client, error) {
+	return req.NewClient(base, func(r *http.Request) error {
+		for k, v := range jar.header(p) {
+			r.Header.Set(k, v)
+		}
+		return nil
+	}, func(resp req.Response) error {
+		jar.update(resp.Response().Cookies())
+		return after(resp)
+	}, nil)
+}
+
+// partAuthMeta returns the string to sign of uploading the part to OSS
+func partAuthMeta(mimeType, date, bucket, objKey, uploadId string, partNumber int) string {
+	return "PUT\n\n" + mimeType + "\n" + date + "\n" +
+		"x-oss-date:" + date + "\n" +
+		"x-oss-user-agent:" + ossUserAgent + "\n" +
+		"/" + bucket + "/" + objKey + "?partNumber=" + strconv.Itoa(partNumber) + "&uploadId=" + uploadId
+}
+
+// completeAuthMeta returns the string to sign of completing the upload of OSS with the callback
+func completeAuthMeta(contentMd5, date, callback, bucket, objKey, uploadId string) string {
+	return "POST\n" + contentMd5 + "\napplication/xml\n" + date + "\n" +
+		"x-oss-callback:" + callback + "\n" +
+		"x-oss-date:" + date + "\n" +
+		"x-oss-user-agent:" + ossUserAgent + "\n" +
+		"/" + bucket + "/" + objKey + "?uploadId=" + uploadId
+}
+
+// ossURL returns the URL of the object to upload, which is in the bucket of the host of the upload URL
+func ossURL(pre *preData) (string, error) {
+	u, e := url.Parse(pre.UploadURL)
+	if e != nil {
+		return "", e
+	}
+	return "https://" + pre.Bucket + "." + u.Host + "/" + pre.ObjKey, nil
+}
+
+func (d *Quark) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	ed := ec.Data
+	if ed == nil || (ed["id"] == "" && !utils.IsRootPath(ec.Path)) {
+		return nil, errors.New("invalid cache")
+	}
+	return &quarkEntry{
+		d: d, id: ed["id"],
+		path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+	}, nil
+}
+
+// operation is the task of deleting files
+type operation struct {
+	d      *Quark
+	taskId string
+	retry  int
+}
+
+func (o *operation) Poll(ctx context.Context) (bool, float64, error) {
+	if o.taskId == "" {
+		return true, 1, nil
+	}
+	t := taskData{}
+	if e := o.d.call(ctx, "GET", "/task?"+url.Values{
+		"task_id":     {o.taskId},
+		"retry_index": {strconv.Itoa(o.retry)},
+	}.Encode(), nil, &t, nil); e != nil {
+		return false, 0, e
+	}
+	o.retry++
+	return t.Status == taskFinished, 0, nil
+}
+
+func (o *operation) Result(context.Context) (types.IEntry, error) {
+	return nil, nil
+}
@@ -0,0 +1,26 @@
+package quark
+
+import "testing"
+
+func TestQuarkSetCookie(t *testing.T) {
+	cases := []struct{ header, expected string }{
+		{"a=1; __puus=old; b=2", "a=1; __puus=new; b=2"},
+		{"a=1;b=2", "a=1; b=2; __puus=new"},
+		{"", "__puus=new"},
+	}
+	for _, c := range cases {
+		if r := setCookie(c.header, "__puus", "new"); r != c.expected {
+			t.Errorf("unexpected cookie of '%s': %s", c.header, r)
+		}
+	}
+}
+
+func TestQuarkOssURL(t *testing.T) {
+	u, e := ossURL(&preData{UploadURL: "http://pds.quark.cn", Bucket: "ul-zb", ObjKey: "abc/def"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if u != "https://ul-zb.pds.quark.cn/abc/def" {
+		t.Errorf("unexpected url: %s", u)
+	}
+}
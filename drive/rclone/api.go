@@ -0,0 +1,106 @@
+package rclone
+
+import (
+	"bytes"
+	"fmt"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// item is a file or a directory in the results of operations/list and operations/stat
+type item struct {
+	// Path is relative to the root of the remote
+	Path    string `json:"Path"`
+	Name    string `json:"Name"`
+	Size    int64  `json:"Size"`
+	ModTime string `json:"ModTime"`
+	IsDir   bool   `json:"IsDir"`
+}
+
+type listResult struct {
+	List []item `json:"list"`
+}
+
+// statResult is the result of operations/stat, Item is null if it's not found
+type statResult struct {
+	Item *item `json:"item"`
+}
+
+type jobResult struct {
+	JobID int64 `json:"jobid"`
+}
+
+type jobStatus struct {
+	Finished bool   `json:"finished"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error"`
+}
+
+type statsResult struct {
+	Bytes      int64 `json:"bytes"`
+	TotalBytes int64 `json:"totalBytes"`
+}
+
+type apiError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	ae := apiError{}
+	if e := resp.Json(&ae); e != nil || ae.Error == "" {
+		ae.Error = resp.Response().Status
+	}
+	if resp.Status() == http.StatusNotFound || strings.Contains(ae.Error, "not found") {
+		return err.NewNotFoundMessageError(ae.Error)
+	}
+	if resp.Status() == http.StatusUnauthorized || resp.Status() == http.StatusForbidden {
+		return err.NewUnauthorizedError(i18n.T("drive.rclone.unauthorized", ae.Error))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.rclone.remote_error", ae.Error))
+}
+
+// uploadBody is the multipart form of operations/uploadfile, the file is streamed from the reader
+type uploadBody struct {
+	r           io.Reader
+	length      int64
+	contentType string
+}
+
+func newUploadBody(name string, reader io.Reader, size int64) (*uploadBody, error) {
+	head := bytes.Buffer{}
+	mw := multipart.NewWriter(&head)
+	if _, e := mw.CreateFormFile("file", name); e != nil {
+		return nil, e
+	}
+	tail := fmt.Sprintf("\r\n--%s--\r\n", mw.Boundary())
+	length := int64(-1)
+	if size >= 0 {
+		length = int64(head.Len()) + size + int64(len(tail))
+	}
+	return &uploadBody{
+		r:           io.MultiReader(&head, reader, strings.NewReader(tail)),
+		length:      length,
+		contentType: mw.FormDataContentType(),
+	}, nil
+}
+
+func (b *uploadBody) ContentLength() int64 {
+	return b.length
+}
+
+func (b *uploadBody) ContentType() string {
+	return b.contentType
+}
+
+func (b *uploadBody) Reader() io.Reader {
+	return b.r
+}
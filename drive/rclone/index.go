@@ -0,0 +1,406 @@
+package rclone
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "rclone",
+		DisplayName: i18n.T("drive.rclone.name"),
+		README:      i18n.T("drive.rclone.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "url", Label: i18n.T("drive.rclone.form.url.label"), Type: "text", Required: true, Description: i18n.T("drive.rclone.form.url.description"), DefaultValue: "http://localhost:5572"},
+			{Field: "username", Label: i18n.T("drive.rclone.form.username.label"), Type: "text"},
+			{Field: "password", Label: i18n.T("drive.rclone.form.password.label"), Type: "password"},
+			{Field: "remote", Label: i18n.T("drive.rclone.form.remote.label"), Type: "text", Required: true, Description: i18n.T("drive.rclone.form.remote.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.rclone.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.rclone.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewRclone},
+	})
+}
+
+// Rclone mounts a remote of rclone by the remote control API of `rclone rcd`.
+// The files are read by the HTTP serving of the remotes, which requires `--rc-serve`.
+type Rclone struct {
+	c        *req.Client
+	username string
+	password string
+	// remote is the remote of rclone with an optional root path, like 'gdrive:' or 'gdrive:backup'
+	remote string
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+}
+
+func NewRclone(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	r := &Rclone{
+		username: config["username"],
+		password: config["password"],
+		remote:   strings.TrimSuffix(strings.TrimSpace(config["remote"]), "/"),
+		cacheTTL: cacheTtl,
+	}
+	if !strings.Contains(r.remote, ":") {
+		return nil, err.NewBadRequestError(i18n.T("drive.rclone.invalid_remote"))
+	}
+	if cacheTtl <= 0 {
+		r.cache = drive_util.DummyCache()
+	} else {
+		r.cache = driveUtils.CreateCache(r.deserializeEntry, nil)
+	}
+	apiURL := strings.TrimSuffix(strings.TrimSpace(config["url"]), "/")
+	if u, e := url.Parse(apiURL); e != nil || u.Host == "" {
+		return nil, err.NewBadRequestError(i18n.T("drive.rclone.invalid_url"))
+	}
+	if r.c, e = req.NewClient(apiURL, r.beforeRequest, ifApiCallError, nil); e != nil {
+		return nil, e
+	}
+	// checks the remote
+	if e := r.call(ctx, "operations/fsinfo", types.M{"fs": r.remote}, nil); e != nil {
+		return nil, e
+	}
+	return r, nil
+}
+
+func (r *Rclone) beforeRequest(hr *http.Request) error {
+	if r.username != "" || r.password != "" {
+		hr.SetBasicAuth(r.username, r.password)
+	}
+	return nil
+}
+
+// call calls the command of the remote control API, and decodes the result to res
+func (r *Rclone) call(ctx context.Context, command string, params types.M, res interface{}) error {
+	resp, e := r.c.Post(ctx, "/"+command, nil, req.NewJsonBody(params))
+	if e != nil {
+		return e
+	}
+	if res == nil {
+		return resp.Dispose()
+	}
+	return resp.Json(res)
+}
+
+// fsPath returns the remote of rclone rooted at the path, which is used by the sync commands
+func (r *Rclone) fsPath(path string) string {
+	if utils.IsRootPath(path) {
+		return r.remote
+	}
+	if strings.HasSuffix(r.remote, ":") {
+		return r.remote + path
+	}
+	return r.remote + "/" + path
+}
+
+func (r *Rclone) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (r *Rclone) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &rcloneEntry{d: r, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := r.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	res := statResult{}
+	if e := r.call(ctx, "operations/stat", types.M{"fs": r.remote, "remote": path}, &res); e != nil {
+		return nil, e
+	}
+	if res.Item == nil {
+		return nil, err.NewNotFoundError()
+	}
+	entry := r.newEntry(*res.Item)
+	_ = r.cache.PutEntry(entry, r.cacheTTL)
+	return entry, nil
+}
+
+// Save uploads the file by operations/uploadfile, the existing file is replaced by rclone
+func (r *Rclone) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, r, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(size, true)
+	body, e := newUploadBody(utils.PathBase(path), drive_util.ProgressReader(reader, ctx), size)
+	if e != nil {
+		return nil, e
+	}
+	resp, e := r.c.Request(ctx, "POST", "/operations/uploadfile?"+url.Values{
+		"fs": {r.remote}, "remote": {utils.PathParent(path)},
+	}.Encode(), nil, body)
+	_ = r.cache.Evict(path, false)
+	_ = r.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	return r.Get(ctx, path)
+}
+
+func (r *Rclone) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := r.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	if e := r.call(ctx, "operations/mkdir", types.M{"fs": r.remote, "remote": path}, nil); e != nil {
+		return nil, e
+	}
+	_ = r.cache.Evict(utils.PathParent(path), false)
+	// the empty directories may be not kept by the remotes of buckets
+	return &rcloneEntry{d: r, path: path, isDir: true, modTime: utils.Millisecond(time.Now())}, nil
+}
+
+func (r *Rclone) isSelf(e types.IEntry) bool {
+	if re, ok := e.(*rcloneEntry); ok {
+		return re.d == r
+	}
+	return false
+}
+
+// startJob starts the command asynchronously, the returned job polls the status of it
+func (r *Rclone) startJob(ctx context.Context, command string, from types.IEntry, to string,
+	override bool) (*job, error) {
+	from = drive_util.GetIEntry(from, r.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, r, to); e != nil {
+			return nil, e
+		}
+	}
+	var params types.M
+	if from.Type().IsDir() {
+		params = types.M{
+			"srcFs": r.fsPath(from.Path()), "dstFs": r.fsPath(to),
+			"createEmptySrcDirs": true,
+		}
+		command = "sync/" + command
+		if command == "sync/move" {
+			params["deleteEmptySrcDirs"] = true
+		}
+	} else {
+		params = types.M{
+			"srcFs": r.remote, "srcRemote": from.Path(),
+			"dstFs": r.remote, "dstRemote": to,
+		}
+		command = "operations/" + command + "file"
+	}
+	params["_async"] = true
+	res := jobResult{}
+	if e := r.call(ctx, command, params, &res); e != nil {
+		return nil, e
+	}
+	return &job{r: r, id: res.JobID, to: to}, nil
+}
+
+func (r *Rclone) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	return drive_util.NativeCopy(ctx, r, from, to, override)
+}
+
+// CopyAsync copies the files by operations/copyfile, and the directories by sync/copy
+func (r *Rclone) CopyAsync(ctx context.Context, from types.IEntry, to string, override bool) (types.IAsyncOp, error) {
+	j, e := r.startJob(ctx, "copy", from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	return j, nil
+}
+
+// Move moves the files by operations/movefile, and the directories by sync/move
+func (r *Rclone) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	j, e := r.startJob(ctx, "move", from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	from = drive_util.GetIEntry(from, r.isSelf)
+	total := int64(0)
+	if from.Type().IsFile() {
+		total = from.Size()
+	}
+	ctx.Total(total, false)
+	entry, e := drive_util.WaitAsyncOp(ctx, j, total)
+	_ = r.cache.Evict(from.Path(), true)
+	_ = r.cache.Evict(utils.PathParent(from.Path()), false)
+	return entry, e
+}
+
+func (r *Rclone) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := r.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	res := listResult{}
+	if e := r.call(ctx, "operations/list", types.M{"fs": r.remote, "remote": path}, &res); e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, 0, len(res.List))
+	for _, i := range res.List {
+		entries = append(entries, r.newEntry(i))
+	}
+	_ = r.cache.PutChildren(path, entries, r.cacheTTL)
+	return entries, nil
+}
+
+// Delete deletes the files by operations/deletefile, and the directories by operations/purge
+func (r *Rclone) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := r.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	command := "operations/deletefile"
+	if entry.Type().IsDir() {
+		command = "operations/purge"
+	}
+	if e := r.call(ctx, command, types.M{"fs": r.remote, "remote": path}, nil); e != nil {
+		return e
+	}
+	_ = r.cache.Evict(path, true)
+	_ = r.cache.Evict(utils.PathParent(path), false)
+	return nil
+}
+
+func (r *Rclone) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, r, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (r *Rclone) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	return &rcloneEntry{d: r, path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir()}, nil
+}
+
+func (r *Rclone) newEntry(i item) *rcloneEntry {
+	modTime := int64(-1)
+	if t, e := time.Parse(time.RFC3339Nano, i.ModTime); e == nil && t.Unix() > 0 {
+		modTime = utils.Millisecond(t)
+	}
+	return &rcloneEntry{d: r, path: i.Path, isDir: i.IsDir, size: i.Size, modTime: modTime}
+}
+
+// job is the handle of an asynchronous job of rclone
+type job struct {
+	r  *Rclone
+	id int64
+	to string
+}
+
+func (j *job) Poll(ctx context.Context) (bool, float64, error) {
+	s := jobStatus{}
+	if e := j.r.call(ctx, "job/status", types.M{"jobid": j.id}, &s); e != nil {
+		return false, 0, e
+	}
+	if s.Finished {
+		if !s.Success {
+			return false, 0, err.NewRemoteApiError(500, i18n.T("drive.rclone.job_failed", s.Error))
+		}
+		return true, 1, nil
+	}
+	// the transfers of the job are counted in the stats group of it
+	stats := statsResult{}
+	if e := j.r.call(ctx, "core/stats", types.M{"group": "job/" + strconv.FormatInt(j.id, 10)}, &stats); e != nil {
+		return false, 0, e
+	}
+	if stats.TotalBytes <= 0 {
+		return false, 0, nil
+	}
+	return false, float64(stats.Bytes) / float64(stats.TotalBytes), nil
+}
+
+func (j *job) Result(ctx context.Context) (types.IEntry, error) {
+	_ = j.r.cache.Evict(j.to, true)
+	_ = j.r.cache.Evict(utils.PathParent(j.to), false)
+	return j.r.Get(ctx, j.to)
+}
+
+type rcloneEntry struct {
+	d       *Rclone
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+}
+
+func (e *rcloneEntry) Path() string {
+	return e.path
+}
+
+func (e *rcloneEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *rcloneEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *rcloneEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *rcloneEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *rcloneEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *rcloneEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+// GetReader reads the file served at /[remote]/path
+func (e *rcloneEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	u := (&url.URL{Path: "/[" + e.d.remote + "]/" + e.path}).EscapedPath()
+	resp, ee := e.d.c.Get(ctx, u, nil)
+	if ee != nil {
+		return nil, ee
+	}
+	return resp.Response().Body, nil
+}
+
+// GetURL is not supported, the files are served with the credentials of the remote control
+func (e *rcloneEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
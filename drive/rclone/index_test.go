@@ -0,0 +1,146 @@
+package rclone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testServer struct {
+	t        *testing.T
+	uploaded bytes.Buffer
+	params   map[string]map[string]interface{}
+	polls    int
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if u, p, _ := r.BasicAuth(); u != "user" || p != "pass" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	write := func(v interface{}) {
+		b, _ := json.Marshal(v)
+		_, _ = w.Write(b)
+	}
+	if r.URL.Path == "/[gdrive:backup]/a b/x.txt" {
+		_, _ = w.Write(s.uploaded.Bytes())
+		return
+	}
+	if r.URL.Path == "/operations/uploadfile" {
+		if r.URL.Query().Get("fs") != "gdrive:backup" || r.URL.Query().Get("remote") != "a b" {
+			s.t.Errorf("unexpected upload: %v", r.URL.Query())
+		}
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		form, e := multipart.NewReader(r.Body, params["boundary"]).ReadForm(1024)
+		if e != nil {
+			s.t.Fatal(e)
+		}
+		f, _ := form.File["file"][0].Open()
+		b, _ := ioutil.ReadAll(f)
+		s.uploaded.Write(b)
+		write(map[string]interface{}{})
+		return
+	}
+	params := map[string]interface{}{}
+	_ = json.NewDecoder(r.Body).Decode(&params)
+	s.params[r.URL.Path] = params
+	switch r.URL.Path {
+	case "/operations/fsinfo":
+		if params["fs"] != "gdrive:backup" {
+			w.WriteHeader(http.StatusInternalServerError)
+			write(apiError{Error: "didn't find section in config file", Status: 500})
+			return
+		}
+		write(map[string]interface{}{"Name": "gdrive"})
+	case "/operations/stat":
+		switch params["remote"] {
+		case "a b/x.txt":
+			if s.uploaded.Len() == 0 {
+				write(statResult{})
+				return
+			}
+			write(statResult{Item: &item{Path: "a b/x.txt", Name: "x.txt", Size: 5, ModTime: "2020-09-13T13:26:40.5+01:00"}})
+		case "b":
+			write(statResult{Item: &item{Path: "b", Name: "b", Size: -1, IsDir: true, ModTime: "2020-09-13T12:26:40Z"}})
+		default:
+			write(statResult{})
+		}
+	case "/operations/list":
+		write(listResult{List: []item{{Path: "a b/sub", Name: "sub", Size: -1, IsDir: true, ModTime: "2000-01-01T00:00:00Z"}}})
+	case "/sync/copy":
+		write(jobResult{JobID: 7})
+	case "/job/status":
+		s.polls++
+		write(jobStatus{Finished: s.polls > 1, Success: true})
+	case "/core/stats":
+		write(statsResult{Bytes: 1, TotalBytes: 2})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		write(apiError{Error: "couldn't find method", Status: 404})
+	}
+}
+
+func TestRclone(t *testing.T) {
+	s := &testServer{t: t, params: make(map[string]map[string]interface{})}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	config := drive_util.DriveConfig{"url": server.URL + "/", "username": "user", "password": "pass", "remote": "gdrive:"}
+	if _, e := NewRclone(context.Background(), config, drive_util.DriveUtils{}); e == nil {
+		t.Error("expect error of the unknown remote")
+	}
+	config["remote"] = "gdrive:backup/"
+	d, e := NewRclone(context.Background(), config, drive_util.DriveUtils{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	ctx := task.DummyContext()
+	entries, e := d.List(ctx, "a b")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 1 || entries[0].Path() != "a b/sub" || !entries[0].Type().IsDir() || entries[0].Size() != -1 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if _, e := d.Get(ctx, "a b/x.txt"); !err.IsNotFoundError(e) {
+		t.Errorf("expect error of the file not found: %v", e)
+	}
+	entry, e := d.Save(ctx, "a b/x.txt", 5, false, bytes.NewReader([]byte("hello")))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if s.uploaded.String() != "hello" || entry.Size() != 5 || entry.ModTime() != 1600000000500 {
+		t.Errorf("unexpected uploaded file: %s, %d, %d", s.uploaded.String(), entry.Size(), entry.ModTime())
+	}
+	r, e := entry.(*rcloneEntry).GetReader(ctx)
+	if e != nil {
+		t.Fatal(e)
+	}
+	b, _ := ioutil.ReadAll(r)
+	_ = r.Close()
+	if string(b) != "hello" {
+		t.Errorf("unexpected content: %s", b)
+	}
+
+	dir := &rcloneEntry{d: d.(*Rclone), path: "a b/sub", isDir: true}
+	copied, e := d.Copy(ctx, dir, "b", true)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if p := s.params["/sync/copy"]; p["srcFs"] != "gdrive:backup/a b/sub" || p["dstFs"] != "gdrive:backup/b" || p["_async"] != true {
+		t.Errorf("unexpected params of copying: %v", p)
+	}
+	if copied.Path() != "b" || s.polls != 2 || s.params["/job/status"]["jobid"] != float64(7) {
+		t.Errorf("unexpected copied entry: %s, %d", copied.Path(), s.polls)
+	}
+}
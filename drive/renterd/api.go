@@ -0,0 +1,169 @@
+package renterd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/utils"
+	"golang.org/x/crypto/blake2b"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// sectorSize is the size of the sectors stored on the hosts, a slab has minShards sectors of data
+	sectorSize = 4 * 1024 * 1024
+
+	defaultMinShards = 10
+)
+
+type bucket struct {
+	Name string `json:"name"`
+}
+
+type redundancySettings struct {
+	MinShards   int `json:"minShards"`
+	TotalShards int `json:"totalShards"`
+}
+
+// objectMetadata is an object or a directory of the listing, the names of the directories end with '/'
+type objectMetadata struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"modTime"`
+}
+
+func (o objectMetadata) modTime() int64 {
+	t, e := time.Parse(time.RFC3339, o.ModTime)
+	if e != nil || t.IsZero() {
+		return -1
+	}
+	return utils.Millisecond(t)
+}
+
+type objectsResponse struct {
+	HasMore bool             `json:"hasMore"`
+	Entries []objectMetadata `json:"entries"`
+	Object  *objectMetadata  `json:"object"`
+}
+
+type renameRequest struct {
+	Bucket string `json:"bucket"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	// Mode is 'single' to rename an object, or 'multi' to rename all objects prefixed with From
+	Mode  string `json:"mode"`
+	Force bool   `json:"force"`
+}
+
+type copyRequest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	SourcePath        string `json:"sourcePath"`
+	DestinationBucket string `json:"destinationBucket"`
+	DestinationPath   string `json:"destinationPath"`
+}
+
+type multipartCreateRequest struct {
+	Bucket      string `json:"bucket"`
+	Path        string `json:"path"`
+	GenerateKey bool   `json:"generateKey"`
+}
+
+type multipartRequest struct {
+	Bucket   string `json:"bucket"`
+	Path     string `json:"path"`
+	UploadID string `json:"uploadID"`
+}
+
+type multipartUpload struct {
+	Path     string `json:"path"`
+	UploadID string `json:"uploadID"`
+}
+
+type listUploadsRequest struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+}
+
+type listUploadsResponse struct {
+	Uploads []multipartUpload `json:"uploads"`
+}
+
+type listPartsRequest struct {
+	multipartRequest
+	PartNumberMarker int `json:"partNumberMarker"`
+	Limit            int `json:"limit"`
+}
+
+type uploadedPart struct {
+	PartNumber int    `json:"partNumber"`
+	ETag       string `json:"eTag"`
+	Size       int64  `json:"size,omitempty"`
+}
+
+type listPartsResponse struct {
+	HasMore    bool           `json:"hasMore"`
+	NextMarker int            `json:"nextMarker"`
+	Parts      []uploadedPart `json:"parts"`
+}
+
+type completeRequest struct {
+	multipartRequest
+	Parts []uploadedPart `json:"parts"`
+}
+
+// partETag returns the ETag of the part computed by the worker, which is the BLAKE2b-256 hash of the data
+func partETag(data []byte) string {
+	sum := blake2b.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// escapePath escapes the segments of the object path, the '/' are kept
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// basicAuth returns the authorization of the API password, the user name is empty
+func basicAuth(password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(":"+password))
+}
+
+// newClient creates the client of the API, the base URL is not used, as it cleans the trailing '/' of the directories
+func newClient(password string) (*req.Client, error) {
+	authorization := basicAuth(password)
+	return req.NewClient("", func(r *http.Request) error {
+		r.Header.Set("Authorization", authorization)
+		return nil
+	}, ifApiCallError, nil)
+}
+
+// ifApiCallError returns the error of the response, the errors of renterd are plain text
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	defer func() { _ = resp.Dispose() }()
+	if resp.Status() == http.StatusNotFound {
+		return err.NewNotFoundError()
+	}
+	msg := resp.Response().Status
+	if resp.Response().Body != nil {
+		if b, e := ioutil.ReadAll(io.LimitReader(resp.Response().Body, 1024)); e == nil && len(b) > 0 {
+			msg = strings.TrimSpace(string(b))
+		}
+	}
+	if resp.Status() == http.StatusUnauthorized {
+		return err.NewUnauthorizedError(i18n.T("drive.renterd.wrong_password"))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.renterd.remote_error", msg))
+}
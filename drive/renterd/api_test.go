@@ -0,0 +1,49 @@
+package renterd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRenterdPartETag(t *testing.T) {
+	if eTag := partETag([]byte("hello")); eTag != "324dcf027dd4a30a932c441f365a25e86b173defa4b8e58948253471b81b72cf" {
+		t.Errorf("unexpected ETag: %s", eTag)
+	}
+}
+
+func TestRenterdParseRedundancy(t *testing.T) {
+	if r, e := parseRedundancy("", " "); r != nil || e != nil {
+		t.Errorf("expect the default redundancy, got %v, %v", r, e)
+	}
+	if r, e := parseRedundancy("10", "30"); e != nil || r.MinShards != 10 || r.TotalShards != 30 {
+		t.Errorf("unexpected redundancy: %v, %v", r, e)
+	}
+	for _, c := range [][2]string{{"10", ""}, {"0", "3"}, {"3", "2"}, {"10", "256"}, {"a", "3"}} {
+		if _, e := parseRedundancy(c[0], c[1]); e == nil {
+			t.Errorf("expect error of the redundancy %v", c)
+		}
+	}
+}
+
+func TestRenterdObjectsResponse(t *testing.T) {
+	res := objectsResponse{}
+	e := json.Unmarshal([]byte(`{"hasMore":false,"entries":[
+		{"name":"/dir/sub/","size":300,"modTime":"2023-06-01T08:00:00Z","health":1},
+		{"name":"/dir/a b.txt","size":12,"modTime":"2023-06-01T08:00:01.5Z","eTag":"x","mimeType":"text/plain"}
+	]}`), &res)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(res.Entries) != 2 || res.Object != nil {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+	if f := res.Entries[1]; f.Name != "/dir/a b.txt" || f.Size != 12 || f.modTime() != 1685606401500 {
+		t.Errorf("unexpected file: %+v", f)
+	}
+	if m := (objectMetadata{ModTime: "0001-01-01T00:00:00Z"}).modTime(); m != -1 {
+		t.Errorf("unexpected mod time: %d", m)
+	}
+	if p := escapePath(dirPath("a b/c#d")); p != "/a%20b/c%23d/" {
+		t.Errorf("unexpected path: %s", p)
+	}
+}
@@ -0,0 +1,707 @@
+package renterd
+
+import (
+	"bytes"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var configForm = []types.FormItem{
+	{Field: "address", Label: i18n.T("drive.renterd.form.address.label"), Type: "text", Required: true, Description: i18n.T("drive.renterd.form.address.description")},
+	{Field: "password", Label: i18n.T("drive.renterd.form.password.label"), Type: "password", Required: true},
+	{Field: "worker_address", Label: i18n.T("drive.renterd.form.worker_address.label"), Type: "text", Description: i18n.T("drive.renterd.form.worker_address.description")},
+	{Field: "bucket", Label: i18n.T("drive.renterd.form.bucket.label"), Type: "text", Required: true, DefaultValue: "default"},
+	{Field: "min_shards", Label: i18n.T("drive.renterd.form.min_shards.label"), Type: "text", Description: i18n.T("drive.renterd.form.min_shards.description")},
+	{Field: "total_shards", Label: i18n.T("drive.renterd.form.total_shards.label"), Type: "text", Description: i18n.T("drive.renterd.form.total_shards.description")},
+	{Field: "cache_ttl", Label: i18n.T("drive.renterd.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.renterd.form.cache_ttl.description")},
+}
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "renterd",
+		DisplayName: i18n.T("drive.renterd.name"),
+		README:      i18n.T("drive.renterd.readme"),
+		ConfigForm:  configForm,
+		Factory:     drive_util.DriveFactory{Create: NewRenterdDrive, ConfigStep: ConfigStep},
+	})
+}
+
+const (
+	// listLimit is the limit of the object listing and of the part listing of the multipart uploads of the bus
+	listLimit = 1000
+	// maxShards is the max number of the shards of a slab
+	maxShards = 255
+)
+
+type RenterdDrive struct {
+	c         *req.Client
+	busURL    string
+	workerURL string
+	bucket    string
+	// authorization is used by the proxied downloads
+	authorization string
+
+	// redundancy is the redundancy of the uploads, the default redundancy of renterd is used if it's nil
+	redundancy *redundancySettings
+	// partSize is the size of the parts of the multipart uploads, which is the size of the data of a slab
+	partSize int64
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+}
+
+// NewRenterdDrive creates the drive of a bucket of renterd, the Sia renter
+func NewRenterdDrive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	redundancy, e := parseRedundancy(config["min_shards"], config["total_shards"])
+	if e != nil {
+		return nil, e
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	d, e := newDrive(config, redundancy)
+	if e != nil {
+		return nil, e
+	}
+	d.cacheTTL = cacheTtl
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+	if e := d.check(ctx); e != nil {
+		return nil, e
+	}
+	minShards := defaultMinShards
+	if redundancy != nil {
+		minShards = redundancy.MinShards
+	} else if settings, e := d.defaultRedundancy(ctx); e == nil && settings.MinShards > 0 {
+		minShards = settings.MinShards
+	}
+	d.partSize = int64(minShards) * sectorSize
+	return d, nil
+}
+
+func newDrive(config drive_util.DriveConfig, redundancy *redundancySettings) (*RenterdDrive, error) {
+	address := strings.TrimSuffix(strings.TrimSpace(config["address"]), "/")
+	workerAddress := strings.TrimSuffix(strings.TrimSpace(config["worker_address"]), "/")
+	if workerAddress == "" {
+		workerAddress = address
+	}
+	c, e := newClient(config["password"])
+	if e != nil {
+		return nil, e
+	}
+	return &RenterdDrive{
+		c:             c,
+		busURL:        address + "/api/bus",
+		workerURL:     workerAddress + "/api/worker",
+		bucket:        config["bucket"],
+		authorization: basicAuth(config["password"]),
+		redundancy:    redundancy,
+	}, nil
+}
+
+// parseRedundancy parses the redundancy, it returns nil if both are omitted
+func parseRedundancy(minShards, totalShards string) (*redundancySettings, error) {
+	minShards, totalShards = strings.TrimSpace(minShards), strings.TrimSpace(totalShards)
+	if minShards == "" && totalShards == "" {
+		return nil, nil
+	}
+	m, e1 := strconv.Atoi(minShards)
+	t, e2 := strconv.Atoi(totalShards)
+	if e1 != nil || e2 != nil || m <= 0 || t < m || t > maxShards {
+		return nil, err.NewBadRequestError(i18n.T("drive.renterd.invalid_redundancy", strconv.Itoa(maxShards)))
+	}
+	return &redundancySettings{MinShards: m, TotalShards: t}, nil
+}
+
+// ConfigStep validates the address and the password first, then lists the buckets to pick from
+func ConfigStep(ctx context.Context, config drive_util.DriveConfig,
+	_ drive_util.DriveUtils) (*drive_util.DriveConfigStep, error) {
+	credentialsForm := make([]types.FormItem, 0, len(configForm))
+	for _, f := range configForm {
+		if f.Field != "bucket" {
+			credentialsForm = append(credentialsForm, f)
+		}
+	}
+	step := &drive_util.DriveConfigStep{Form: credentialsForm, README: i18n.T("drive.renterd.step.credentials"), Errors: types.SM{}}
+	for _, f := range []string{"address", "password"} {
+		if strings.TrimSpace(config[f]) == "" {
+			step.Errors[f] = i18n.T("drive.renterd.step.required")
+		}
+	}
+	if _, e := parseRedundancy(config["min_shards"], config["total_shards"]); e != nil {
+		step.Errors["min_shards"] = e.Error()
+	}
+	if len(step.Errors) > 0 {
+		return step, nil
+	}
+	d, e := newDrive(config, nil)
+	if e != nil {
+		return nil, e
+	}
+	buckets, e := d.buckets(ctx)
+	if e != nil {
+		step.Errors["address"] = i18n.T("drive.renterd.step.connect_failed", e.Error())
+		return step, nil
+	}
+	bucketItem := types.FormItem{Field: "bucket", Label: i18n.T("drive.renterd.form.bucket.label"), Type: "select", Required: true}
+	for _, b := range buckets {
+		bucketItem.Options = append(bucketItem.Options, types.FormItemOption{Name: b.Name, Value: b.Name})
+	}
+	step.Form = append([]types.FormItem{}, credentialsForm[:3]...)
+	step.Form = append(step.Form, bucketItem)
+	step.Form = append(step.Form, credentialsForm[3:]...)
+	step.README = i18n.T("drive.renterd.step.bucket")
+	if config["bucket"] == "" {
+		step.Errors["bucket"] = i18n.T("drive.renterd.step.required")
+		return step, nil
+	}
+	if e := d.check(ctx); e != nil {
+		step.Errors["bucket"] = e.Error()
+		return step, nil
+	}
+	step.Done = true
+	return step, nil
+}
+
+func (d *RenterdDrive) buckets(ctx context.Context) ([]bucket, error) {
+	resp, e := d.c.Get(ctx, d.busURL+"/buckets", nil)
+	if e != nil {
+		return nil, e
+	}
+	buckets := make([]bucket, 0)
+	if e := resp.Json(&buckets); e != nil {
+		return nil, e
+	}
+	return buckets, nil
+}
+
+func (d *RenterdDrive) check(ctx context.Context) error {
+	resp, e := d.c.Get(ctx, d.busURL+"/bucket/"+url.PathEscape(d.bucket), nil)
+	if err.IsNotFoundError(e) {
+		return err.NewNotFoundMessageError(i18n.T("drive.renterd.bucket_not_exists", d.bucket))
+	}
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+func (d *RenterdDrive) defaultRedundancy(ctx context.Context) (*redundancySettings, error) {
+	resp, e := d.c.Get(ctx, d.busURL+"/setting/redundancy", nil)
+	if e != nil {
+		return nil, e
+	}
+	settings := &redundancySettings{}
+	if e := resp.Json(settings); e != nil {
+		return nil, e
+	}
+	return settings, nil
+}
+
+// objectPath returns the path of the object in renterd, which starts with '/'
+func objectPath(path string) string {
+	return "/" + path
+}
+
+// dirPath returns the path of the directory in renterd, which starts and ends with '/'
+func dirPath(path string) string {
+	if utils.IsRootPath(path) {
+		return "/"
+	}
+	return "/" + path + "/"
+}
+
+// objectURL returns the URL of the object of the API, the bucket is added to the query
+func (d *RenterdDrive) objectURL(api, p string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("bucket", d.bucket)
+	return api + "/objects" + escapePath(p) + "?" + query.Encode()
+}
+
+// uploadQuery returns the query of the uploads with the redundancy
+func (d *RenterdDrive) uploadQuery() url.Values {
+	query := url.Values{}
+	if d.redundancy != nil {
+		query.Set("minshards", strconv.Itoa(d.redundancy.MinShards))
+		query.Set("totalshards", strconv.Itoa(d.redundancy.TotalShards))
+	}
+	return query
+}
+
+func (d *RenterdDrive) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	return &renterdEntry{d: d, path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir()}, nil
+}
+
+func (d *RenterdDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *RenterdDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &renterdEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	entry, e := d.get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	_ = d.cache.PutEntry(entry, d.cacheTTL)
+	return entry, nil
+}
+
+// get finds the object of path by its metadata, or the directory by its entries or its empty object
+func (d *RenterdDrive) get(ctx context.Context, path string) (*renterdEntry, error) {
+	object, e := d.metadata(ctx, objectPath(path))
+	if e == nil {
+		return &renterdEntry{d: d, path: path, size: object.Size, modTime: object.modTime()}, nil
+	}
+	if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	res, e := d.list(ctx, dirPath(path), 0, 1)
+	if e != nil && !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	if e == nil && len(res.Entries) > 0 {
+		return &renterdEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+	}
+	// the empty directory made by MakeDir
+	if _, e := d.metadata(ctx, dirPath(path)); e != nil {
+		return nil, e
+	}
+	return &renterdEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+}
+
+func (d *RenterdDrive) metadata(ctx context.Context, p string) (*objectMetadata, error) {
+	resp, e := d.c.Get(ctx, d.objectURL(d.busURL, p, url.Values{"onlymetadata": {"true"}}), nil)
+	if e != nil {
+		return nil, e
+	}
+	res := objectsResponse{}
+	if e := resp.Json(&res); e != nil {
+		return nil, e
+	}
+	if res.Object == nil {
+		return nil, err.NewNotFoundError()
+	}
+	return res.Object, nil
+}
+
+func (d *RenterdDrive) list(ctx context.Context, dir string, offset, limit int) (*objectsResponse, error) {
+	resp, e := d.c.Get(ctx, d.objectURL(d.busURL, dir, url.Values{
+		"offset": {strconv.Itoa(offset)}, "limit": {strconv.Itoa(limit)},
+	}), nil)
+	if e != nil {
+		return nil, e
+	}
+	res := &objectsResponse{}
+	if e := resp.Json(res); e != nil {
+		return nil, e
+	}
+	return res, nil
+}
+
+func (d *RenterdDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(size, true)
+	var e error
+	if size >= 0 && size <= d.partSize {
+		e = d.put(ctx, objectPath(path), req.NewReaderBody(drive_util.ProgressReader(reader, ctx), size))
+	} else {
+		e = d.multipartUpload(ctx, objectPath(path), drive_util.ProgressReader(reader, ctx))
+	}
+	_ = d.cache.Evict(path, false)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	return d.Get(ctx, path)
+}
+
+func (d *RenterdDrive) put(ctx context.Context, p string, body req.RequestBody) error {
+	resp, e := d.c.Request(ctx, "PUT", d.objectURL(d.workerURL, p, d.uploadQuery()), nil, body)
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+// multipartUpload uploads the file by parts of a slab, the parts are buffered in memory.
+// The unfinished upload of the same path is resumed, the parts uploaded with the same data are skipped.
+// The upload is kept for resuming if it fails, unless it's canceled.
+func (d *RenterdDrive) multipartUpload(ctx types.TaskCtx, p string, reader io.Reader) error {
+	uploadID, uploaded := d.resumableUpload(ctx, p)
+	if uploadID == "" {
+		resp, e := d.c.Post(ctx, d.busURL+"/multipart/create", nil,
+			req.NewJsonBody(multipartCreateRequest{Bucket: d.bucket, Path: p, GenerateKey: true}))
+		if e != nil {
+			return e
+		}
+		created := multipartUpload{}
+		if e := resp.Json(&created); e != nil {
+			return e
+		}
+		uploadID = created.UploadID
+	}
+	upload := multipartRequest{Bucket: d.bucket, Path: p, UploadID: uploadID}
+	parts, e := d.uploadParts(ctx, upload, uploaded, reader)
+	if e == nil {
+		var resp req.Response
+		resp, e = d.c.Post(ctx, d.busURL+"/multipart/complete", nil,
+			req.NewJsonBody(completeRequest{multipartRequest: upload, Parts: parts}))
+		if e == nil {
+			return resp.Dispose()
+		}
+	}
+	if ctx.Canceled() {
+		resp, ee := d.c.Post(context.Background(), d.busURL+"/multipart/abort", nil, req.NewJsonBody(upload))
+		if ee == nil {
+			_ = resp.Dispose()
+		}
+	}
+	return e
+}
+
+func (d *RenterdDrive) uploadParts(ctx types.TaskCtx, upload multipartRequest,
+	uploaded map[int]uploadedPart, reader io.Reader) ([]uploadedPart, error) {
+	parts := make([]uploadedPart, 0)
+	buf := make([]byte, d.partSize)
+	for {
+		if e := ctx.WaitIfPaused(); e != nil {
+			return nil, e
+		}
+		n, e := io.ReadFull(reader, buf)
+		if e != nil && e != io.ErrUnexpectedEOF && e != io.EOF {
+			return nil, e
+		}
+		if n == 0 && len(parts) > 0 {
+			break
+		}
+		number := len(parts) + 1
+		eTag := partETag(buf[:n])
+		if part, ok := uploaded[number]; !ok || part.Size != int64(n) || part.ETag != eTag {
+			query := d.uploadQuery()
+			query.Set("bucket", d.bucket)
+			query.Set("uploadid", upload.UploadID)
+			query.Set("partnumber", strconv.Itoa(number))
+			resp, ee := d.c.Request(ctx, "PUT", d.workerURL+"/multipart"+escapePath(upload.Path)+"?"+query.Encode(),
+				nil, req.NewReaderBody(bytes.NewReader(buf[:n]), int64(n)))
+			if ee != nil {
+				return nil, ee
+			}
+			_ = resp.Dispose()
+			eTag = strings.Trim(resp.Response().Header.Get("ETag"), "\"")
+		}
+		parts = append(parts, uploadedPart{PartNumber: number, ETag: eTag})
+		if e != nil {
+			break
+		}
+	}
+	return parts, nil
+}
+
+// resumableUpload finds the unfinished upload of the path, and returns its ID and the uploaded parts,
+// it returns an empty ID if there is no such upload or any error occurs
+func (d *RenterdDrive) resumableUpload(ctx context.Context, p string) (string, map[int]uploadedPart) {
+	resp, e := d.c.Post(ctx, d.busURL+"/multipart/listuploads", nil,
+		req.NewJsonBody(listUploadsRequest{Bucket: d.bucket, Prefix: p}))
+	if e != nil {
+		return "", nil
+	}
+	res := listUploadsResponse{}
+	if e := resp.Json(&res); e != nil {
+		return "", nil
+	}
+	uploadID := ""
+	for _, u := range res.Uploads {
+		if u.Path == p {
+			uploadID = u.UploadID
+		}
+	}
+	if uploadID == "" {
+		return "", nil
+	}
+	parts := make(map[int]uploadedPart)
+	marker := 0
+	for {
+		resp, e := d.c.Post(ctx, d.busURL+"/multipart/listparts", nil, req.NewJsonBody(listPartsRequest{
+			multipartRequest: multipartRequest{Bucket: d.bucket, Path: p, UploadID: uploadID},
+			PartNumberMarker: marker,
+			Limit:            listLimit,
+		}))
+		if e != nil {
+			return "", nil
+		}
+		res := listPartsResponse{}
+		if e := resp.Json(&res); e != nil {
+			return "", nil
+		}
+		for _, part := range res.Parts {
+			parts[part.PartNumber] = part
+		}
+		if !res.HasMore || res.NextMarker <= marker {
+			break
+		}
+		marker = res.NextMarker
+	}
+	return uploadID, parts
+}
+
+func (d *RenterdDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := d.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	if e := d.put(ctx, dirPath(path), req.NewReaderBody(bytes.NewReader(nil), 0)); e != nil {
+		return nil, e
+	}
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return &renterdEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+}
+
+func (d *RenterdDrive) isSelf(e types.IEntry) bool {
+	if re, ok := e.(*renterdEntry); ok {
+		return re.d == d
+	}
+	return false
+}
+
+// Copy copies the object by the bus, which copies the metadata only, the directories are not supported
+func (d *RenterdDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || from.Type().IsDir() {
+		return nil, err.NewUnsupportedError()
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, to); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(from.Size(), false)
+	resp, e := d.c.Post(ctx, d.busURL+"/objects/copy", nil, req.NewJsonBody(copyRequest{
+		SourceBucket:      d.bucket,
+		SourcePath:        objectPath(from.Path()),
+		DestinationBucket: d.bucket,
+		DestinationPath:   objectPath(to),
+	}))
+	_ = d.cache.Evict(to, true)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	ctx.Progress(from.Size(), false)
+	return d.Get(ctx, to)
+}
+
+// Move renames the object, or all objects prefixed with the directory by the bus,
+// the existing directory is left to be merged by the caller
+func (d *RenterdDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	rename := renameRequest{Bucket: d.bucket, From: objectPath(from.Path()), To: objectPath(to), Mode: "single", Force: override}
+	if from.Type().IsDir() {
+		if _, e := d.Get(ctx, to); e == nil {
+			return nil, err.NewUnsupportedError()
+		} else if !err.IsNotFoundError(e) {
+			return nil, e
+		}
+		rename = renameRequest{Bucket: d.bucket, From: dirPath(from.Path()), To: dirPath(to), Mode: "multi"}
+	} else if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, to); e != nil {
+			return nil, e
+		}
+	}
+	resp, e := d.c.Post(ctx, d.busURL+"/objects/rename", nil, req.NewJsonBody(rename))
+	_ = d.cache.Evict(from.Path(), true)
+	_ = d.cache.Evict(utils.PathParent(from.Path()), false)
+	_ = d.cache.Evict(to, true)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	return d.Get(ctx, to)
+}
+
+func (d *RenterdDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	dir := dirPath(path)
+	entries := make([]types.IEntry, 0)
+	for offset := 0; ; offset += listLimit {
+		res, e := d.list(ctx, dir, offset, listLimit)
+		if e != nil {
+			return nil, e
+		}
+		for _, o := range res.Entries {
+			// skip the directory itself made by MakeDir
+			if o.Name == dir {
+				continue
+			}
+			isDir := strings.HasSuffix(o.Name, "/")
+			p := strings.TrimPrefix(strings.TrimSuffix(o.Name, "/"), "/")
+			modTime := o.modTime()
+			if isDir {
+				modTime = -1
+			}
+			entries = append(entries, &renterdEntry{d: d, path: p, size: o.Size, modTime: modTime, isDir: isDir})
+		}
+		if !res.HasMore {
+			break
+		}
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+// Delete deletes the object, or all objects prefixed with the directory by the batch deletion
+func (d *RenterdDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := d.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	u := d.objectURL(d.workerURL, objectPath(path), nil)
+	if entry.Type().IsDir() {
+		u = d.objectURL(d.workerURL, dirPath(path), url.Values{"batch": {"true"}})
+	}
+	if ctx.Canceled() {
+		return task.ErrorCanceled
+	}
+	resp, e := d.c.Request(ctx, "DELETE", u, nil, nil)
+	_ = d.cache.Evict(path, true)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+// Upload uploads the files through the server, the large files are uploaded by parts
+func (d *RenterdDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+type renterdEntry struct {
+	d       *RenterdDrive
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+}
+
+func (e *renterdEntry) Path() string {
+	return e.path
+}
+
+func (e *renterdEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *renterdEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *renterdEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *renterdEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *renterdEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *renterdEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *renterdEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+func (e *renterdEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	var header types.SM
+	if offset > 0 || length >= 0 {
+		rangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if length >= 0 {
+			if length == 0 {
+				return ioutil.NopCloser(strings.NewReader("")), nil
+			}
+			rangeHeader += strconv.FormatInt(offset+length-1, 10)
+		}
+		header = types.SM{"Range": rangeHeader}
+	}
+	resp, ee := e.d.c.Get(ctx, e.d.objectURL(e.d.workerURL, objectPath(e.path), nil), header)
+	if ee != nil {
+		return nil, ee
+	}
+	return resp.Response().Body, nil
+}
+
+// GetURL returns the URL of the worker, which requires the password, so the downloads are proxied
+func (e *renterdEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	return &types.ContentURL{
+		URL:    e.d.objectURL(e.d.workerURL, objectPath(e.path), nil),
+		Header: types.SM{"Authorization": e.d.authorization},
+		Proxy:  true,
+	}, nil
+}
@@ -7,9 +7,44 @@ import (
 	"go-drive/common/drive_util"
 	"go-drive/common/errors"
 	"go-drive/common/i18n"
+	"go-drive/common/registry"
 	"go-drive/common/types"
+	_ "go-drive/drive/alias"
+	_ "go-drive/drive/alist"
+	_ "go-drive/drive/aliyundrive"
+	_ "go-drive/drive/archive"
+	_ "go-drive/drive/b2"
+	_ "go-drive/drive/baidu"
+	_ "go-drive/drive/cache"
+	_ "go-drive/drive/cas"
+	_ "go-drive/drive/crypt"
+	_ "go-drive/drive/db"
+	_ "go-drive/drive/dropbox"
+	_ "go-drive/drive/ftp"
 	_ "go-drive/drive/gdrive"
+	_ "go-drive/drive/git"
+	_ "go-drive/drive/gphotos"
+	_ "go-drive/drive/httpindex"
+	_ "go-drive/drive/ipfs"
+	_ "go-drive/drive/jottacloud"
+	_ "go-drive/drive/koofr"
+	_ "go-drive/drive/mega"
+	_ "go-drive/drive/memory"
+	_ "go-drive/drive/nfs"
 	_ "go-drive/drive/onedrive"
+	_ "go-drive/drive/oss"
+	_ "go-drive/drive/pan115"
+	_ "go-drive/drive/pcloud"
+	_ "go-drive/drive/qiniu"
+	_ "go-drive/drive/quark"
+	_ "go-drive/drive/rclone"
+	_ "go-drive/drive/renterd"
+	_ "go-drive/drive/seafile"
+	_ "go-drive/drive/swift"
+	_ "go-drive/drive/telegram"
+	_ "go-drive/drive/throttle"
+	_ "go-drive/drive/union"
+	_ "go-drive/drive/yandex"
 	"go-drive/storage"
 	"log"
 	"sync"
@@ -21,6 +56,7 @@ type RootDrive struct {
 	mountStorage      *storage.PathMountDAO
 	driveDataStorage  *storage.DriveDataDAO
 	driveCacheStorage *storage.DriveCacheDAO
+	locker            drive_util.PathLocker
 
 	config common.Config
 
@@ -33,7 +69,14 @@ func NewRootDrive(
 	driveStorage *storage.DriveDAO,
 	mountStorage *storage.PathMountDAO,
 	dataStorage *storage.DriveDataDAO,
-	driveCacheStorage *storage.DriveCacheDAO) (*RootDrive, error) {
+	driveCacheStorage *storage.DriveCacheDAO,
+	locker drive_util.PathLocker,
+	ch *registry.ComponentsHolder) (*RootDrive, error) {
+	drive_util.TransferBuffers.SetLimit(config.TransferMemoryLimit)
+	ch.Add("transferBuffers", drive_util.TransferBuffers)
+	drive_util.DownloadScheduler.SetLimit(config.DownloadSharedRateLimit)
+	ch.Add("downloadScheduler", drive_util.DownloadScheduler)
+
 	root := NewDispatcherDrive(mountStorage, config)
 	r := &RootDrive{
 		root:              root,
@@ -41,6 +84,7 @@ func NewRootDrive(
 		mountStorage:      mountStorage,
 		driveDataStorage:  dataStorage,
 		driveCacheStorage: driveCacheStorage,
+		locker:            locker,
 		config:            config,
 		mux:               &sync.Mutex{},
 	}
@@ -50,6 +94,7 @@ func NewRootDrive(
 	if e := r.ReloadDrive(ctx, true); e != nil {
 		return nil, e
 	}
+	ch.Add("rootDrive", r)
 	return r, nil
 }
 
@@ -57,6 +102,23 @@ func (d *RootDrive) Get() types.IDrive {
 	return d.root
 }
 
+// DriveOptions returns the options of the drive which path is in
+func (d *RootDrive) DriveOptions(path string) drive_util.DriveOptions {
+	return d.root.driveOptions(path)
+}
+
+// Dispose disposes all drives, which stops their background jobs
+func (d *RootDrive) Dispose() error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.root.setDrives(nil, nil, nil)
+	return nil
+}
+
+func (d *RootDrive) AddChangeListener(l ChangeListener) {
+	d.root.AddChangeListener(l)
+}
+
 func checkAndParseConfig(dc types.Drive) (*drive_util.DriveFactory, types.SM, error) {
 	f := drive_util.GetDrive(dc.Type)
 	if f == nil {
@@ -79,6 +141,8 @@ func (d *RootDrive) ReloadDrive(ctx context.Context, ignoreFailure bool) error {
 		return e
 	}
 	drives := make(map[string]types.IDrive, len(drivesConfig))
+	transforms := make(map[string]*drive_util.UploadTransform)
+	options := make(map[string]drive_util.DriveOptions)
 	ok := false
 	defer func() {
 		if !ok {
@@ -101,6 +165,14 @@ func (d *RootDrive) ReloadDrive(ctx context.Context, ignoreFailure bool) error {
 			}
 			return e
 		}
+		transform, e := drive_util.NewUploadTransform(config, d.config)
+		if e != nil {
+			if ignoreFailure {
+				log.Printf("[%s]: %v", dc.Name, e)
+				continue
+			}
+			return e
+		}
 		iDrive, e := factory.Create(ctx, config, d.createDriveUtils(dc.Name))
 		if e != nil {
 			if ignoreFailure {
@@ -110,8 +182,12 @@ func (d *RootDrive) ReloadDrive(ctx context.Context, ignoreFailure bool) error {
 			return err.NewBadRequestError(i18n.T("drive.root.error_create_drive", dc.Name, e.Error()))
 		}
 		drives[dc.Name] = iDrive
+		options[dc.Name] = drive_util.NewDriveOptions(config)
+		if transform != nil {
+			transforms[dc.Name] = transform
+		}
 	}
-	d.root.setDrives(drives)
+	d.root.setDrives(drives, transforms, options)
 	ok = true
 	return nil
 }
@@ -151,6 +227,25 @@ func (d *RootDrive) DriveInit(ctx context.Context, name string, data types.SM) e
 	return factory.Init(ctx, data, config, d.createDriveUtils(name))
 }
 
+// DriveConfigStep returns the next configuration step of the drive type by the partial config.
+// name is the drive being configured.
+func (d *RootDrive) DriveConfigStep(ctx context.Context, driveType, name string,
+	config types.SM) (*drive_util.DriveConfigStep, error) {
+	f := drive_util.GetDrive(driveType)
+	if f == nil {
+		return nil, err.NewBadRequestError(i18n.T("drive.root.invalid_drive_type", driveType))
+	}
+	if f.Factory.ConfigStep == nil {
+		return &drive_util.DriveConfigStep{Form: f.ConfigForm, README: f.README}, nil
+	}
+	step, e := f.Factory.ConfigStep(ctx, config, d.createDriveUtils(name))
+	if e != nil || step == nil {
+		return step, e
+	}
+	step.Form = append(step.Form, drive_util.CommonConfigForm()...)
+	return step, nil
+}
+
 func (d *RootDrive) createDriveUtils(name string) drive_util.DriveUtils {
 	return drive_util.DriveUtils{
 		Data: d.driveDataStorage.GetDataStore(name),
@@ -160,6 +255,9 @@ func (d *RootDrive) createDriveUtils(name string) drive_util.DriveUtils {
 			}
 			return d.driveCacheStorage.GetCacheStore(name, s, de)
 		},
+		Files:  d.driveDataStorage.GetFileStore(name),
 		Config: d.config,
+		Locker: d.locker,
+		Root:   func() types.IDrive { return d.root },
 	}
 }
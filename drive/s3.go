@@ -9,6 +9,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"go-drive/common/drive_util"
 	"go-drive/common/errors"
 	"go-drive/common/i18n"
@@ -16,30 +17,33 @@ import (
 	"go-drive/common/types"
 	"go-drive/common/utils"
 	"io"
+	"io/ioutil"
 	"math"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 )
 
+var s3ConfigForm = []types.FormItem{
+	{Field: "id", Label: i18n.T("drive.s3.form.ak.label"), Type: "text", Required: true},
+	{Field: "secret", Label: i18n.T("drive.s3.form.sk.label"), Type: "password", Required: true},
+	{Field: "bucket", Label: i18n.T("drive.s3.form.bucket.label"), Type: "text", Required: true},
+	{Field: "path_style", Label: i18n.T("drive.s3.form.path_style.label"), Type: "checkbox", Description: i18n.T("drive.s3.form.path_style.description")},
+	{Field: "region", Label: i18n.T("drive.s3.form.region.label"), Type: "text", Description: i18n.T("drive.s3.form.region.description")},
+	{Field: "endpoint", Label: i18n.T("drive.s3.form.endpoint.label"), Type: "text", Description: i18n.T("drive.s3.form.endpoint.description")},
+	{Field: "proxy_upload", Label: i18n.T("drive.s3.form.proxy_in.label"), Type: "checkbox", Description: i18n.T("drive.s3.form.proxy_in.description")},
+	{Field: "proxy_download", Label: i18n.T("drive.s3.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.s3.form.proxy_out.description")},
+	{Field: "proxy_range", Label: i18n.T("drive.s3.form.proxy_range.label"), Type: "checkbox", Description: i18n.T("drive.s3.form.proxy_range.description")},
+	{Field: "cache_ttl", Label: i18n.T("drive.s3.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.s3.form.cache_ttl.description")},
+}
+
 func init() {
 	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
 		Type:        "s3",
 		DisplayName: i18n.T("drive.s3.name"),
 		README:      i18n.T("drive.s3.readme"),
-		ConfigForm: []types.FormItem{
-			{Field: "id", Label: i18n.T("drive.s3.form.ak.label"), Type: "text", Required: true},
-			{Field: "secret", Label: i18n.T("drive.s3.form.sk.label"), Type: "password", Required: true},
-			{Field: "bucket", Label: i18n.T("drive.s3.form.bucket.label"), Type: "text", Required: true},
-			{Field: "path_style", Label: i18n.T("drive.s3.form.path_style.label"), Type: "checkbox", Description: i18n.T("drive.s3.form.path_style.description")},
-			{Field: "region", Label: i18n.T("drive.s3.form.region.label"), Type: "text"},
-			{Field: "endpoint", Label: i18n.T("drive.s3.form.endpoint.label"), Type: "text", Description: i18n.T("drive.s3.form.endpoint.description")},
-			{Field: "proxy_upload", Label: i18n.T("drive.s3.form.proxy_in.label"), Type: "checkbox", Description: i18n.T("drive.s3.form.proxy_in.description")},
-			{Field: "proxy_download", Label: i18n.T("drive.s3.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.s3.form.proxy_out.description")},
-			{Field: "cache_ttl", Label: i18n.T("drive.s3.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.s3.form.cache_ttl.description")},
-		},
-		Factory: drive_util.DriveFactory{Create: NewS3Drive},
+		ConfigForm:  s3ConfigForm,
+		Factory:     drive_util.DriveFactory{Create: NewS3Drive, ConfigStep: S3ConfigStep},
 	})
 }
 
@@ -48,45 +52,44 @@ type S3Drive struct {
 	bucket        *string
 	uploadProxy   bool
 	downloadProxy bool
+	rangeProxy    bool
 	cache         drive_util.DriveCache
 	cacheTTL      time.Duration
 
-	tempDir string
+	// downloadConcurrency is the number of the parts of a file downloaded in parallel,
+	// the files are downloaded by a single request if it's not greater than 1
+	downloadConcurrency int
+	// dirMove indicates that the directories are moved by copying and deleting the objects on the server,
+	// which is cheap on the storages copying the metadata only, like Storj
+	dirMove bool
 }
 
+// s3DownloadPartSize is the size of the parts downloaded in parallel
+const s3DownloadPartSize = 16 * 1024 * 1024
+
 // NewS3Drive creates a S3 compatible storage
 func NewS3Drive(ctx context.Context, config drive_util.DriveConfig,
 	utils drive_util.DriveUtils) (types.IDrive, error) {
-	id := config["id"]
-	secret := config["secret"]
 	bucket := config["bucket"]
-	pathStyle := config["path_style"]
-	region := config["region"]
-	endpoint := config["endpoint"]
 	proxyUpload := config["proxy_upload"]
 	proxyDownload := config["proxy_download"]
+	proxyRange := config["proxy_range"]
 	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
 	if e != nil {
 		cacheTtl = -1
 	}
 
-	sess, e := session.NewSession(&aws.Config{
-		Credentials:      credentials.NewStaticCredentials(id, secret, ""),
-		S3ForcePathStyle: aws.Bool(pathStyle != ""),
-		Endpoint:         aws.String(endpoint),
-		Region:           aws.String(region),
-	})
+	client, e := newS3Client(config)
 	if e != nil {
 		return nil, e
 	}
-	client := s3.New(sess)
 	d := &S3Drive{
 		c:             client,
 		bucket:        aws.String(bucket),
 		uploadProxy:   proxyUpload != "",
 		downloadProxy: proxyDownload != "",
+		rangeProxy:    proxyRange != "",
 		cacheTTL:      cacheTtl,
-		tempDir:       utils.Config.TempDir,
 	}
 	if cacheTtl <= 0 {
 		d.cache = drive_util.DummyCache()
@@ -96,6 +99,78 @@ func NewS3Drive(ctx context.Context, config drive_util.DriveConfig,
 	return d, d.check(ctx)
 }
 
+func newS3Client(config drive_util.DriveConfig) (*s3.S3, error) {
+	region := config["region"]
+	if region == "" {
+		// the compatible storages usually ignore the region, but the signature requires one
+		region = "us-east-1"
+	}
+	sess, e := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials(config["id"], config["secret"], ""),
+		S3ForcePathStyle: aws.Bool(config["path_style"] != ""),
+		Endpoint:         aws.String(config["endpoint"]),
+		Region:           aws.String(region),
+	})
+	if e != nil {
+		return nil, e
+	}
+	return s3.New(sess), nil
+}
+
+// S3ConfigStep validates the credentials first, then lists the buckets to pick from.
+// If listing buckets is not permitted, the bucket name is filled manually.
+func S3ConfigStep(ctx context.Context, config drive_util.DriveConfig,
+	_ drive_util.DriveUtils) (*drive_util.DriveConfigStep, error) {
+	credentialsForm := make([]types.FormItem, 0, len(s3ConfigForm))
+	for _, f := range s3ConfigForm {
+		if f.Field != "bucket" {
+			credentialsForm = append(credentialsForm, f)
+		}
+	}
+	step := &drive_util.DriveConfigStep{Form: credentialsForm, README: i18n.T("drive.s3.step.credentials"), Errors: types.SM{}}
+	for _, f := range []string{"id", "secret"} {
+		if config[f] == "" {
+			step.Errors[f] = i18n.T("drive.s3.step.required")
+		}
+	}
+	if len(step.Errors) > 0 {
+		return step, nil
+	}
+	client, e := newS3Client(config)
+	if e != nil {
+		return nil, e
+	}
+	bucketItem := types.FormItem{Field: "bucket", Label: i18n.T("drive.s3.form.bucket.label"), Type: "text", Required: true}
+	buckets, e := client.ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
+	if e != nil {
+		ae, ok := e.(awserr.Error)
+		if !ok || ae.Code() != "AccessDenied" {
+			step.Errors["id"] = i18n.T("drive.s3.step.invalid_credentials", e.Error())
+			return step, nil
+		}
+	} else {
+		bucketItem.Type = "select"
+		for _, b := range buckets.Buckets {
+			bucketItem.Options = append(bucketItem.Options, types.FormItemOption{Name: *b.Name, Value: *b.Name})
+		}
+	}
+	step.Form = append([]types.FormItem{}, credentialsForm[:2]...)
+	step.Form = append(step.Form, bucketItem)
+	step.Form = append(step.Form, credentialsForm[2:]...)
+	step.README = i18n.T("drive.s3.step.bucket")
+	if config["bucket"] == "" {
+		step.Errors["bucket"] = i18n.T("drive.s3.step.required")
+		return step, nil
+	}
+	d := &S3Drive{c: client, bucket: aws.String(config["bucket"])}
+	if e := d.check(ctx); e != nil {
+		step.Errors["bucket"] = e.Error()
+		return step, nil
+	}
+	step.Done = true
+	return step, nil
+}
+
 func (s *S3Drive) check(ctx context.Context) error {
 	_, e := s.c.HeadBucketWithContext(ctx, &s3.HeadBucketInput{
 		Bucket: s.bucket,
@@ -158,43 +233,39 @@ func (s *S3Drive) Get(ctx context.Context, path string) (types.IEntry, error) {
 	return entry, nil
 }
 
-func (s *S3Drive) Save(ctx types.TaskCtx, path string, _ int64,
+func (s *S3Drive) Save(ctx types.TaskCtx, path string, size int64,
 	override bool, reader io.Reader) (types.IEntry, error) {
 	if !override {
 		if _, e := drive_util.RequireFileNotExists(ctx, s, path); e != nil {
 			return nil, e
 		}
 	}
-	var readSeeker io.ReadSeeker
-	if rs, ok := reader.(io.ReadSeeker); ok {
-		readSeeker = rs
-	} else {
-		file, e := drive_util.CopyReaderToTempFile(ctx, reader, s.tempDir)
-		if e != nil {
-			return nil, e
-		}
-		defer func() {
-			_ = file.Close()
-			_ = os.Remove(file.Name())
-		}()
-		readSeeker = file
-	}
-	_, e := s.c.PutObjectWithContext(ctx, &s3.PutObjectInput{
+	// the large files are uploaded by parts, and the parts are buffered in memory instead of a temp file
+	uploader := s3manager.NewUploaderWithClient(s.c, func(u *s3manager.Uploader) {
+		u.PartSize = s3PartSize(size)
+	})
+	_, e := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 		Bucket: s.bucket,
 		Key:    aws.String(path),
-		Body:   readSeeker,
+		Body:   drive_util.ProgressReader(reader, ctx),
 	})
 	if e != nil {
 		return nil, e
 	}
 	_ = s.cache.Evict(path, false)
 	_ = s.cache.Evict(utils.PathParent(path), false)
-	get, e := s.Get(ctx, path)
-	if e != nil {
-		return nil, e
+	return s.Get(ctx, path)
+}
+
+// s3PartSize returns the size of the parts to upload a file of size,
+// it grows for the huge files, as an upload has at most s3manager.MaxUploadParts parts
+func s3PartSize(size int64) int64 {
+	partSize := int64(s3manager.MinUploadPartSize)
+	maxParts := int64(s3manager.MaxUploadParts)
+	if size > partSize*maxParts {
+		partSize = (size + maxParts - 1) / maxParts
 	}
-	ctx.Progress(get.Size(), false)
-	return get, nil
+	return partSize
 }
 
 func (s *S3Drive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
@@ -261,9 +332,15 @@ func (s *S3Drive) copy(from *s3Entry, to string, override bool, ctx types.TaskCt
 
 func (s *S3Drive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
 	from = drive_util.GetIEntry(from, s.isSelf)
-	if from == nil || from.Type().IsDir() {
+	if from == nil {
 		return nil, err.NewUnsupportedError()
 	}
+	if from.Type().IsDir() {
+		if !s.dirMove {
+			return nil, err.NewUnsupportedError()
+		}
+		return s.moveDir(ctx, from.(*s3Entry), to)
+	}
 	fromEntry := from.(*s3Entry)
 	entry, skip, e := s.copy(fromEntry, to, override, ctx)
 	if e != nil {
@@ -277,6 +354,62 @@ func (s *S3Drive) Move(ctx types.TaskCtx, from types.IEntry, to string, override
 	return entry, e
 }
 
+// moveDir moves all objects prefixed with the directory page by page,
+// the existing target is left to be merged by the caller
+func (s *S3Drive) moveDir(ctx types.TaskCtx, from *s3Entry, to string) (types.IEntry, error) {
+	if _, e := s.Get(ctx, to); e == nil {
+		return nil, err.NewUnsupportedError()
+	} else if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	prefix := from.key + "/"
+	var moveErr error
+	e := s.c.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: s.bucket,
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		ctx.Total(int64(len(page.Contents)), false)
+		deletes := make([]*s3.ObjectIdentifier, 0, len(page.Contents))
+		for _, o := range page.Contents {
+			if ctx.Canceled() {
+				moveErr = task.ErrorCanceled
+				break
+			}
+			_, moveErr = s.c.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+				Bucket:     s.bucket,
+				Key:        aws.String(to + "/" + strings.TrimPrefix(*o.Key, prefix)),
+				CopySource: aws.String(url.QueryEscape(*s.bucket + "/" + *o.Key)),
+			})
+			if moveErr != nil {
+				break
+			}
+			deletes = append(deletes, &s3.ObjectIdentifier{Key: o.Key})
+			ctx.Progress(1, false)
+		}
+		if len(deletes) > 0 {
+			_, e := s.c.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+				Bucket: s.bucket,
+				Delete: &s3.Delete{Objects: deletes, Quiet: aws.Bool(true)},
+			})
+			if moveErr == nil {
+				moveErr = e
+			}
+		}
+		return moveErr == nil
+	})
+	_ = s.cache.Evict(from.key, true)
+	_ = s.cache.Evict(utils.PathParent(from.key), false)
+	_ = s.cache.Evict(to, true)
+	_ = s.cache.Evict(utils.PathParent(to), false)
+	if e == nil {
+		e = moveErr
+	}
+	if e != nil {
+		return nil, e
+	}
+	return s.newS3DirEntry(to, nil), nil
+}
+
 func (s *S3Drive) List(ctx context.Context, path string) ([]types.IEntry, error) {
 	if cached, _ := s.cache.GetChildren(path); cached != nil {
 		return cached, nil
@@ -533,9 +666,31 @@ func (s *s3Entry) Name() string {
 }
 
 func (s *s3Entry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	if s.c.downloadConcurrency > 1 && s.size > s3DownloadPartSize {
+		return drive_util.ParallelRangeReader(ctx, s, s.size, s3DownloadPartSize, s.c.downloadConcurrency), nil
+	}
+	obj, e := s.c.c.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: s.c.bucket,
+		Key:    aws.String(s.key),
+	})
+	if e != nil {
+		return nil, e
+	}
+	return obj.Body, nil
+}
+
+func (s *s3Entry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	rangeHeader := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		if length == 0 {
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		}
+		rangeHeader = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
 	obj, e := s.c.c.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: s.c.bucket,
 		Key:    aws.String(s.key),
+		Range:  aws.String(rangeHeader),
 	})
 	if e != nil {
 		return nil, e
@@ -552,7 +707,7 @@ func (s *s3Entry) GetURL(context.Context) (*types.ContentURL, error) {
 	if e != nil {
 		return nil, e
 	}
-	return &types.ContentURL{URL: downloadUrl, Proxy: s.c.downloadProxy}, nil
+	return &types.ContentURL{URL: downloadUrl, Proxy: s.c.downloadProxy, ProxyRange: s.c.rangeProxy}, nil
 }
 
 func errCodeMatches(e error, code string) bool {
@@ -0,0 +1,117 @@
+package seafile
+
+import (
+	"bytes"
+	"fmt"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// repo is a library
+type repo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Mtime     int64  `json:"mtime"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// dirent is a file or a directory in a library
+type dirent struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	Mtime int64  `json:"mtime"`
+}
+
+type tokenResponse struct {
+	Token string `json:"token"`
+}
+
+type errorResponse struct {
+	ErrorMsg string `json:"error_msg"`
+	Detail   string `json:"detail"`
+}
+
+// batchRequest is the request of sync-batch-copy-item and sync-batch-move-item
+type batchRequest struct {
+	SrcRepoID    string   `json:"src_repo_id"`
+	SrcParentDir string   `json:"src_parent_dir"`
+	SrcDirents   []string `json:"src_dirents"`
+	DstRepoID    string   `json:"dst_repo_id"`
+	DstParentDir string   `json:"dst_parent_dir"`
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	r := errorResponse{}
+	message := resp.Response().Status
+	if e := resp.Json(&r); e == nil {
+		if r.ErrorMsg != "" {
+			message = r.ErrorMsg
+		} else if r.Detail != "" {
+			message = r.Detail
+		}
+	}
+	switch resp.Status() {
+	case http.StatusUnauthorized:
+		return err.NewUnauthorizedError(message)
+	case http.StatusForbidden:
+		if strings.Contains(message, "token") || strings.Contains(message, "credentials") {
+			return err.NewUnauthorizedError(message)
+		}
+		return err.NewNotAllowedMessageError(message)
+	case http.StatusNotFound:
+		return err.NewNotFoundMessageError(message)
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.seafile.remote_error", message))
+}
+
+// uploadBody is the multipart form of uploading a file, the file is streamed from the reader
+type uploadBody struct {
+	r           io.Reader
+	length      int64
+	contentType string
+}
+
+func newUploadBody(fields map[string]string, name string, reader io.Reader, size int64) (*uploadBody, error) {
+	head := bytes.Buffer{}
+	mw := multipart.NewWriter(&head)
+	for k, v := range fields {
+		if e := mw.WriteField(k, v); e != nil {
+			return nil, e
+		}
+	}
+	if _, e := mw.CreateFormFile("file", name); e != nil {
+		return nil, e
+	}
+	tail := fmt.Sprintf("\r\n--%s--\r\n", mw.Boundary())
+	length := int64(-1)
+	if size >= 0 {
+		length = int64(head.Len()) + size + int64(len(tail))
+	}
+	return &uploadBody{
+		r:           io.MultiReader(&head, reader, strings.NewReader(tail)),
+		length:      length,
+		contentType: mw.FormDataContentType(),
+	}, nil
+}
+
+func (b *uploadBody) ContentLength() int64 {
+	return b.length
+}
+
+func (b *uploadBody) ContentType() string {
+	return b.contentType
+}
+
+func (b *uploadBody) Reader() io.Reader {
+	return b.r
+}
@@ -0,0 +1,584 @@
+package seafile
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"net/http"
+	"net/url"
+	path2 "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "seafile",
+		DisplayName: i18n.T("drive.seafile.name"),
+		README:      i18n.T("drive.seafile.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "url", Label: i18n.T("drive.seafile.form.url.label"), Type: "text", Required: true, Description: i18n.T("drive.seafile.form.url.description")},
+			{Field: "token", Label: i18n.T("drive.seafile.form.token.label"), Type: "password", Description: i18n.T("drive.seafile.form.token.description")},
+			{Field: "username", Label: i18n.T("drive.seafile.form.username.label"), Type: "text"},
+			{Field: "password", Label: i18n.T("drive.seafile.form.password.label"), Type: "password"},
+			{Field: "proxy_download", Label: i18n.T("drive.seafile.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.seafile.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.seafile.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.seafile.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewSeafile},
+	})
+}
+
+// reposTTL is the time to live of the libraries kept in memory to resolve the paths
+const reposTTL = time.Minute
+
+// Seafile maps the libraries to the top-level directories.
+// Files are uploaded by the upload links, and downloaded by the download links.
+type Seafile struct {
+	url   string
+	token string
+	// c is the client of the web API, the URLs are absolute as the API URLs end with '/'
+	c *req.Client
+	// uploader is the client of the file server, which is authorized by the upload links
+	uploader *req.Client
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+
+	reposMux sync.Mutex
+	repos    map[string]repo
+	reposAt  time.Time
+
+	downloadProxy bool
+}
+
+func NewSeafile(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	s := &Seafile{
+		url:           strings.TrimSuffix(strings.TrimSpace(config["url"]), "/"),
+		token:         strings.TrimSpace(config["token"]),
+		cacheTTL:      cacheTtl,
+		downloadProxy: config["proxy_download"] != "",
+	}
+	if _, e := url.Parse(s.url); e != nil || s.url == "" {
+		return nil, err.NewBadRequestError(i18n.T("drive.seafile.invalid_url"))
+	}
+	if cacheTtl <= 0 {
+		s.cache = drive_util.DummyCache()
+	} else {
+		s.cache = driveUtils.CreateCache(s.deserializeEntry, nil)
+	}
+	if s.c, e = req.NewClient("", s.beforeRequest, ifApiCallError, nil); e != nil {
+		return nil, e
+	}
+	if s.uploader, e = req.NewClient("", nil, ifApiCallError, nil); e != nil {
+		return nil, e
+	}
+	if s.token != "" {
+		return s, s.ping(ctx)
+	}
+
+	// the token of the username and password is kept, so that it's not requested every time
+	if config["username"] == "" {
+		return nil, err.NewBadRequestError(i18n.T("drive.seafile.token_required"))
+	}
+	data, e := driveUtils.Data.Load("token", "username")
+	if e != nil {
+		return nil, e
+	}
+	if data["username"] == config["username"] && data["token"] != "" {
+		s.token = data["token"]
+		if e := s.ping(ctx); e == nil {
+			return s, nil
+		} else if _, ok := e.(err.UnauthorizedError); !ok {
+			return nil, e
+		}
+	}
+	s.token = ""
+	resp, e := s.c.Post(ctx, s.url+"/api2/auth-token/", nil,
+		req.NewURLEncodedBody(types.SM{"username": config["username"], "password": config["password"]}))
+	if e != nil {
+		return nil, e
+	}
+	token := tokenResponse{}
+	if e := resp.Json(&token); e != nil {
+		return nil, e
+	}
+	s.token = token.Token
+	if e := driveUtils.Data.Save(types.SM{"token": token.Token, "username": config["username"]}); e != nil {
+		return nil, e
+	}
+	return s, nil
+}
+
+func (s *Seafile) beforeRequest(r *http.Request) error {
+	if s.token != "" {
+		r.Header.Set("Authorization", "Token "+s.token)
+	}
+	r.Header.Set("Accept", "application/json")
+	return nil
+}
+
+func (s *Seafile) ping(ctx context.Context) error {
+	resp, e := s.c.Get(ctx, s.url+"/api2/auth/ping/", nil)
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+// call calls the API, and decodes the result to res
+func (s *Seafile) call(ctx context.Context, method, path string, query url.Values,
+	body req.RequestBody, res interface{}) error {
+	u := s.url + path
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	resp, e := s.c.Request(ctx, method, u, nil, body)
+	if e != nil {
+		return e
+	}
+	if res == nil {
+		return resp.Dispose()
+	}
+	return resp.Json(res)
+}
+
+func (s *Seafile) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+// splitPath returns the name of the library and the path in the library, which starts with '/'
+func splitPath(path string) (string, string) {
+	i := strings.IndexByte(path, '/')
+	if i < 0 {
+		return path, "/"
+	}
+	return path[:i], path[i:]
+}
+
+// getRepo finds the library by name, the libraries are reloaded if it's not found
+func (s *Seafile) getRepo(ctx context.Context, name string) (repo, error) {
+	s.reposMux.Lock()
+	defer s.reposMux.Unlock()
+	if r, ok := s.repos[name]; ok && time.Since(s.reposAt) < reposTTL {
+		return r, nil
+	}
+	if e := s.loadRepos(ctx); e != nil {
+		return repo{}, e
+	}
+	if r, ok := s.repos[name]; ok {
+		return r, nil
+	}
+	return repo{}, err.NewNotFoundError()
+}
+
+// loadRepos loads the libraries, the first one wins if the names of the libraries are the same
+func (s *Seafile) loadRepos(ctx context.Context) error {
+	var res []repo
+	if e := s.call(ctx, "GET", "/api2/repos/", nil, nil, &res); e != nil {
+		return e
+	}
+	repos := make(map[string]repo, len(res))
+	for _, r := range res {
+		if _, ok := repos[r.Name]; !ok {
+			repos[r.Name] = r
+		}
+	}
+	s.repos = repos
+	s.reposAt = time.Now()
+	return nil
+}
+
+func (s *Seafile) evictRepos() {
+	s.reposMux.Lock()
+	s.repos = nil
+	s.reposMux.Unlock()
+	_ = s.cache.Evict("", false)
+}
+
+func (s *Seafile) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &seafileEntry{d: s, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := s.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	name, inner := splitPath(path)
+	if inner == "/" {
+		r, e := s.getRepo(ctx, name)
+		if e != nil {
+			return nil, e
+		}
+		return s.newRepoEntry(r), nil
+	}
+	// the entry is found in the listing of its parent, which is cached
+	entries, e := s.List(ctx, utils.PathParent(path))
+	if e != nil {
+		return nil, e
+	}
+	for _, entry := range entries {
+		if entry.Path() == path {
+			_ = s.cache.PutEntry(entry, s.cacheTTL)
+			return entry, nil
+		}
+	}
+	return nil, err.NewNotFoundError()
+}
+
+func (s *Seafile) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	name, inner := splitPath(path)
+	if inner == "/" {
+		return nil, err.NewNotAllowedError()
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, s, path); e != nil {
+			return nil, e
+		}
+	}
+	r, e := s.getRepo(ctx, name)
+	if e != nil {
+		return nil, e
+	}
+	parent := path2.Dir(inner)
+	var link string
+	if e := s.call(ctx, "GET", "/api2/repos/"+r.ID+"/upload-link/", url.Values{"p": {parent}}, nil, &link); e != nil {
+		return nil, e
+	}
+	ctx.Total(size, true)
+	body, e := newUploadBody(map[string]string{"parent_dir": parent, "replace": "1"},
+		utils.PathBase(path), drive_util.ProgressReader(reader, ctx), size)
+	if e != nil {
+		return nil, e
+	}
+	resp, e := s.uploader.Request(ctx, "POST", link+"?ret-json=1", nil, body)
+	_ = s.cache.Evict(path, false)
+	_ = s.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	return s.Get(ctx, path)
+}
+
+func (s *Seafile) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := s.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	name, inner := splitPath(path)
+	if inner == "/" {
+		if e := s.call(ctx, "POST", "/api2/repos/", nil,
+			req.NewURLEncodedBody(types.SM{"name": name}), nil); e != nil {
+			return nil, e
+		}
+		s.evictRepos()
+		return s.Get(ctx, path)
+	}
+	r, e := s.getRepo(ctx, name)
+	if e != nil {
+		return nil, e
+	}
+	if e := s.call(ctx, "POST", "/api2/repos/"+r.ID+"/dir/", url.Values{"p": {inner}},
+		req.NewURLEncodedBody(types.SM{"operation": "mkdir"}), nil); e != nil {
+		return nil, e
+	}
+	_ = s.cache.Evict(utils.PathParent(path), false)
+	return s.Get(ctx, path)
+}
+
+func (s *Seafile) isSelf(e types.IEntry) bool {
+	if se, ok := e.(*seafileEntry); ok {
+		return se.d == s
+	}
+	return false
+}
+
+// prepareTarget checks the target of copying or moving. The sync batch APIs rename the item if its name is taken
+// in the destination, so the existing target is deleted first if override
+func (s *Seafile) prepareTarget(ctx types.TaskCtx, from types.IEntry, to string, override bool) (*seafileEntry, error) {
+	from = drive_util.GetIEntry(from, s.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	if _, e := s.Get(ctx, to); e == nil {
+		if !override {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		if e := s.Delete(ctx, to); e != nil {
+			return nil, e
+		}
+	} else if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	return from.(*seafileEntry), nil
+}
+
+// batch copies or moves the entry to the directory of another path with the same name,
+// the entries of other names or in the top level are not supported, they are copied by reading.
+func (s *Seafile) batch(ctx types.TaskCtx, operation string, from *seafileEntry, to string) (types.IEntry, error) {
+	fromName, fromInner := splitPath(from.path)
+	toName, toInner := splitPath(to)
+	if fromInner == "/" || toInner == "/" || utils.PathBase(fromInner) != utils.PathBase(toInner) {
+		return nil, err.NewUnsupportedError()
+	}
+	fromRepo, e := s.getRepo(ctx, fromName)
+	if e != nil {
+		return nil, e
+	}
+	toRepo, e := s.getRepo(ctx, toName)
+	if e != nil {
+		return nil, e
+	}
+	ctx.Total(from.Size(), false)
+	if e := s.call(ctx, "POST", "/api/v2.1/repos/sync-batch-"+operation+"-item/", nil, req.NewJsonBody(batchRequest{
+		SrcRepoID:    fromRepo.ID,
+		SrcParentDir: path2.Dir(fromInner),
+		SrcDirents:   []string{utils.PathBase(fromInner)},
+		DstRepoID:    toRepo.ID,
+		DstParentDir: path2.Dir(toInner),
+	}), nil); e != nil {
+		return nil, e
+	}
+	ctx.Progress(from.Size(), false)
+	_ = s.cache.Evict(to, true)
+	_ = s.cache.Evict(utils.PathParent(to), false)
+	if operation == "move" {
+		_ = s.cache.Evict(from.path, true)
+		_ = s.cache.Evict(utils.PathParent(from.path), false)
+	}
+	return s.Get(ctx, to)
+}
+
+func (s *Seafile) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	fromEntry, e := s.prepareTarget(ctx, from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	return s.batch(ctx, "copy", fromEntry, to)
+}
+
+// Move renames the entry if it's moved in the same directory, otherwise it's moved with the same name
+func (s *Seafile) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	fromEntry, e := s.prepareTarget(ctx, from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	if utils.PathParent(fromEntry.path) != utils.PathParent(to) {
+		return s.batch(ctx, "move", fromEntry, to)
+	}
+	name, inner := splitPath(fromEntry.path)
+	r, e := s.getRepo(ctx, name)
+	if e != nil {
+		return nil, e
+	}
+	if inner == "/" {
+		e = s.call(ctx, "POST", "/api2/repos/"+r.ID+"/", url.Values{"op": {"rename"}},
+			req.NewURLEncodedBody(types.SM{"repo_name": to}), nil)
+		s.evictRepos()
+	} else {
+		api := "/file/"
+		if fromEntry.isDir {
+			api = "/dir/"
+		}
+		e = s.call(ctx, "POST", "/api2/repos/"+r.ID+api, url.Values{"p": {inner}},
+			req.NewURLEncodedBody(types.SM{"operation": "rename", "newname": utils.PathBase(to)}), nil)
+	}
+	_ = s.cache.Evict(to, true)
+	_ = s.cache.Evict(utils.PathParent(to), false)
+	_ = s.cache.Evict(fromEntry.path, true)
+	if e != nil {
+		return nil, e
+	}
+	return s.Get(ctx, to)
+}
+
+func (s *Seafile) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := s.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	var entries []types.IEntry
+	if utils.IsRootPath(path) {
+		s.reposMux.Lock()
+		e := s.loadRepos(ctx)
+		repos := s.repos
+		s.reposMux.Unlock()
+		if e != nil {
+			return nil, e
+		}
+		entries = make([]types.IEntry, 0, len(repos))
+		for _, r := range repos {
+			entries = append(entries, s.newRepoEntry(r))
+		}
+	} else {
+		name, inner := splitPath(path)
+		r, e := s.getRepo(ctx, name)
+		if e != nil {
+			return nil, e
+		}
+		var res []dirent
+		if e := s.call(ctx, "GET", "/api2/repos/"+r.ID+"/dir/", url.Values{"p": {inner}}, nil, &res); e != nil {
+			return nil, e
+		}
+		entries = make([]types.IEntry, 0, len(res))
+		for _, d := range res {
+			entries = append(entries, s.newEntry(r.ID, path, d))
+		}
+	}
+	_ = s.cache.PutChildren(path, entries, s.cacheTTL)
+	return entries, nil
+}
+
+// Delete deletes the entry, the library is deleted if the path is a top-level directory
+func (s *Seafile) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := s.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	name, inner := splitPath(path)
+	r, e := s.getRepo(ctx, name)
+	if e != nil {
+		return e
+	}
+	if inner == "/" {
+		e = s.call(ctx, "DELETE", "/api2/repos/"+r.ID+"/", nil, nil, nil)
+		s.evictRepos()
+	} else {
+		api := "/file/"
+		if entry.Type().IsDir() {
+			api = "/dir/"
+		}
+		e = s.call(ctx, "DELETE", "/api2/repos/"+r.ID+api, url.Values{"p": {inner}}, nil, nil)
+	}
+	if e != nil {
+		return e
+	}
+	_ = s.cache.Evict(path, true)
+	_ = s.cache.Evict(utils.PathParent(path), false)
+	return nil
+}
+
+func (s *Seafile) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if _, inner := splitPath(path); inner == "/" {
+		return nil, err.NewNotAllowedError()
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, s, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (s *Seafile) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	return &seafileEntry{
+		d: s, repo: ec.Data["repo"], id: ec.Data["id"],
+		path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+	}, nil
+}
+
+func (s *Seafile) newRepoEntry(r repo) *seafileEntry {
+	return &seafileEntry{d: s, repo: r.ID, id: r.ID, path: r.Name, isDir: true, modTime: r.Mtime * 1000}
+}
+
+func (s *Seafile) newEntry(repoID, parent string, d dirent) *seafileEntry {
+	return &seafileEntry{
+		d:       s,
+		repo:    repoID,
+		id:      d.ID,
+		path:    path2.Join(parent, d.Name),
+		isDir:   d.Type == "dir",
+		size:    d.Size,
+		modTime: d.Mtime * 1000,
+	}
+}
+
+type seafileEntry struct {
+	d       *Seafile
+	repo    string
+	id      string
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+}
+
+func (e *seafileEntry) Path() string {
+	return e.path
+}
+
+func (e *seafileEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *seafileEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *seafileEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *seafileEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *seafileEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *seafileEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *seafileEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	u, ee := e.GetURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	return drive_util.GetURL(ctx, u.URL, nil)
+}
+
+// GetURL returns the download link of the file, so the downloads are redirected to Seafile
+func (e *seafileEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	_, inner := splitPath(e.path)
+	var link string
+	if ee := e.d.call(ctx, "GET", "/api2/repos/"+e.repo+"/file/",
+		url.Values{"p": {inner}, "reuse": {"1"}}, nil, &link); ee != nil {
+		return nil, ee
+	}
+	return &types.ContentURL{URL: link, Proxy: e.d.downloadProxy}, nil
+}
+
+func (e *seafileEntry) EntryData() types.SM {
+	return types.SM{"repo": e.repo, "id": e.id}
+}
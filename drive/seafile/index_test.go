@@ -0,0 +1,118 @@
+package seafile
+
+import (
+	"bytes"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/task"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestServer(t *testing.T, uploaded *bytes.Buffer) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/seafhttp/upload-api/abc" && r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"detail":"Invalid token"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api2/auth/ping/":
+			_, _ = w.Write([]byte(`"pong"`))
+		case "/api2/repos/":
+			_, _ = w.Write([]byte(`[{"id":"r1","name":"Docs","mtime":1600000000},{"id":"r2","name":"Docs","mtime":1}]`))
+		case "/api2/repos/r1/dir/":
+			if r.URL.Query().Get("p") != "/a b" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error_msg":"Folder not found."}`))
+				return
+			}
+			if uploaded.Len() == 0 {
+				_, _ = w.Write([]byte(`[{"id":"d1","type":"dir","name":"sub","mtime":1600000001}]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[{"id":"d1","type":"dir","name":"sub","mtime":1600000001},` +
+				`{"id":"f1","type":"file","name":"x.txt","size":5,"mtime":1600000002}]`))
+		case "/api2/repos/r1/upload-link/":
+			if r.URL.Query().Get("p") != "/a b" {
+				t.Errorf("unexpected upload dir: %s", r.URL.Query().Get("p"))
+			}
+			_, _ = w.Write([]byte(`"` + server.URL + `/seafhttp/upload-api/abc"`))
+		case "/seafhttp/upload-api/abc":
+			_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			form, e := multipart.NewReader(r.Body, params["boundary"]).ReadForm(1024)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if form.Value["parent_dir"][0] != "/a b" || form.File["file"][0].Filename != "x.txt" {
+				t.Errorf("unexpected form: %v", form.Value)
+			}
+			f, _ := form.File["file"][0].Open()
+			b, _ := ioutil.ReadAll(f)
+			uploaded.Write(b)
+			_, _ = w.Write([]byte(`[{"name":"x.txt","id":"f1","size":5}]`))
+		case "/api2/repos/r1/file/":
+			if r.URL.Query().Get("p") != "/a b/x.txt" {
+				t.Errorf("unexpected file: %s", r.URL.Query().Get("p"))
+			}
+			_, _ = w.Write([]byte(`"https://seafile.example.com/seafhttp/files/xyz/x.txt"`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func TestSeafile(t *testing.T) {
+	uploaded := &bytes.Buffer{}
+	server := newTestServer(t, uploaded)
+	defer server.Close()
+
+	if _, e := NewSeafile(context.Background(), drive_util.DriveConfig{"url": server.URL, "token": "wrong"},
+		drive_util.DriveUtils{}); e == nil {
+		t.Error("expect error of the wrong token")
+	}
+	d, e := NewSeafile(context.Background(), drive_util.DriveConfig{"url": server.URL + "/", "token": "secret"},
+		drive_util.DriveUtils{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	ctx := task.DummyContext()
+	repos, e := d.List(ctx, "")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(repos) != 1 || repos[0].Path() != "Docs" || !repos[0].Type().IsDir() || repos[0].ModTime() != 1600000000000 {
+		t.Fatalf("unexpected libraries: %v", repos)
+	}
+	entries, e := d.List(ctx, "Docs/a b")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 1 || entries[0].Path() != "Docs/a b/sub" {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if _, e := d.Get(ctx, "Docs/a b/x.txt"); e == nil {
+		t.Error("expect error of the file not found")
+	}
+	entry, e := d.Save(ctx, "Docs/a b/x.txt", 5, false, bytes.NewReader([]byte("hello")))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if uploaded.String() != "hello" || entry.Size() != 5 || entry.ModTime() != 1600000002000 {
+		t.Errorf("unexpected uploaded file: %s, %d", uploaded.String(), entry.Size())
+	}
+	u, e := entry.(*seafileEntry).GetURL(ctx)
+	if e != nil || u.URL != "https://seafile.example.com/seafhttp/files/xyz/x.txt" {
+		t.Errorf("unexpected url: %v, %v", u, e)
+	}
+	if _, e := d.Save(ctx, "Docs", 5, true, bytes.NewReader([]byte("hello"))); e == nil {
+		t.Error("expect error of saving a file in the top level")
+	}
+}
@@ -0,0 +1,135 @@
+package drive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "storj",
+		DisplayName: i18n.T("drive.storj.name"),
+		README:      i18n.T("drive.storj.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "access_grant", Label: i18n.T("drive.storj.form.access_grant.label"), Type: "password", Required: true, Description: i18n.T("drive.storj.form.access_grant.description")},
+			{Field: "bucket", Label: i18n.T("drive.storj.form.bucket.label"), Type: "text", Required: true},
+			{Field: "auth_service", Label: i18n.T("drive.storj.form.auth_service.label"), Type: "text", Description: i18n.T("drive.storj.form.auth_service.description"), DefaultValue: storjAuthService},
+			{Field: "download_concurrency", Label: i18n.T("drive.storj.form.download_concurrency.label"), Type: "text", Description: i18n.T("drive.storj.form.download_concurrency.description"), DefaultValue: strconv.Itoa(storjDownloadConcurrency)},
+			{Field: "proxy_download", Label: i18n.T("drive.s3.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.s3.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.s3.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.s3.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewStorjDrive},
+	})
+}
+
+const (
+	storjAuthService         = "https://auth.storjshare.io"
+	storjDownloadConcurrency = 4
+)
+
+type storjCredentials struct {
+	AccessKeyId string `json:"access_key_id"`
+	SecretKey   string `json:"secret_key"`
+	Endpoint    string `json:"endpoint"`
+}
+
+// NewStorjDrive creates a Storj DCS drive by the S3 compatible gateway,
+// the access grant is registered to the auth service for the credentials of the gateway
+func NewStorjDrive(ctx context.Context, config drive_util.DriveConfig,
+	utils drive_util.DriveUtils) (types.IDrive, error) {
+	concurrency := storjDownloadConcurrency
+	if v := strings.TrimSpace(config["download_concurrency"]); v != "" {
+		n, e := strconv.Atoi(v)
+		if e != nil || n <= 0 {
+			return nil, err.NewBadRequestError(i18n.T("drive.storj.invalid_download_concurrency"))
+		}
+		concurrency = n
+	}
+	authService := strings.TrimSuffix(strings.TrimSpace(config["auth_service"]), "/")
+	if authService == "" {
+		authService = storjAuthService
+	}
+	credentials, e := loadStorjCredentials(ctx, authService, strings.TrimSpace(config["access_grant"]), utils.Data)
+	if e != nil {
+		return nil, e
+	}
+	d, e := NewS3Drive(ctx, drive_util.DriveConfig{
+		"id":             credentials.AccessKeyId,
+		"secret":         credentials.SecretKey,
+		"bucket":         config["bucket"],
+		"endpoint":       credentials.Endpoint,
+		"path_style":     "1",
+		"proxy_download": config["proxy_download"],
+		"cache_ttl":      config["cache_ttl"],
+	}, utils)
+	if e != nil {
+		return nil, e
+	}
+	s := d.(*S3Drive)
+	s.downloadConcurrency = concurrency
+	// the objects are copied by the metadata on Storj, so the directories are moved on the server
+	s.dirMove = true
+	return s, nil
+}
+
+// loadStorjCredentials returns the saved credentials of the access grant,
+// or registers the access grant to the auth service and saves the credentials
+func loadStorjCredentials(ctx context.Context, authService, accessGrant string,
+	ds drive_util.DriveDataStore) (*storjCredentials, error) {
+	sum := sha256.Sum256([]byte(authService + "\n" + accessGrant))
+	grantHash := hex.EncodeToString(sum[:])
+	data, e := ds.Load("grant_hash", "access_key_id", "secret_key", "endpoint")
+	if e != nil {
+		return nil, e
+	}
+	if data["grant_hash"] == grantHash && data["access_key_id"] != "" {
+		return &storjCredentials{AccessKeyId: data["access_key_id"], SecretKey: data["secret_key"], Endpoint: data["endpoint"]}, nil
+	}
+
+	c, e := req.NewClient(authService, nil, ifStorjAuthError, nil)
+	if e != nil {
+		return nil, e
+	}
+	resp, e := c.Post(ctx, "/v1/access", nil, req.NewJsonBody(map[string]interface{}{
+		"access_grant": accessGrant,
+		"public":       false,
+	}))
+	if e != nil {
+		return nil, e
+	}
+	credentials := &storjCredentials{}
+	if e := resp.Json(credentials); e != nil {
+		return nil, e
+	}
+	if e := ds.Save(types.SM{
+		"grant_hash":    grantHash,
+		"access_key_id": credentials.AccessKeyId,
+		"secret_key":    credentials.SecretKey,
+		"endpoint":      credentials.Endpoint,
+	}); e != nil {
+		return nil, e
+	}
+	return credentials, nil
+}
+
+func ifStorjAuthError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	res := struct {
+		Error string `json:"error"`
+	}{}
+	msg := resp.Response().Status
+	if e := resp.Json(&res); e == nil && res.Error != "" {
+		msg = res.Error
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.storj.register_failed", msg))
+}
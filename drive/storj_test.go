@@ -0,0 +1,70 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"go-drive/common/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type memDataStore struct {
+	data types.SM
+}
+
+func (m *memDataStore) Save(data types.SM) error {
+	for k, v := range data {
+		m.data[k] = v
+	}
+	return nil
+}
+
+func (m *memDataStore) Load(keys ...string) (types.SM, error) {
+	r := types.SM{}
+	for _, k := range keys {
+		if v, ok := m.data[k]; ok {
+			r[k] = v
+		}
+	}
+	return r, nil
+}
+
+func TestStorjCredentials(t *testing.T) {
+	registered := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := struct {
+			AccessGrant string `json:"access_grant"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/v1/access" || body.AccessGrant == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid access grant"}`))
+			return
+		}
+		registered++
+		_, _ = w.Write([]byte(`{"access_key_id":"key-` + body.AccessGrant + `","secret_key":"secret","endpoint":"https://gateway"}`))
+	}))
+	defer server.Close()
+
+	ds := &memDataStore{data: types.SM{}}
+	for i := 0; i < 2; i++ {
+		c, e := loadStorjCredentials(context.Background(), server.URL, "grant", ds)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if c.AccessKeyId != "key-grant" || c.SecretKey != "secret" || c.Endpoint != "https://gateway" {
+			t.Errorf("unexpected credentials: %+v", c)
+		}
+	}
+	if registered != 1 {
+		t.Errorf("expect the credentials are saved, but registered %d times", registered)
+	}
+	// the access grant is changed
+	if c, e := loadStorjCredentials(context.Background(), server.URL, "another", ds); e != nil || c.AccessKeyId != "key-another" {
+		t.Errorf("unexpected credentials: %+v, %v", c, e)
+	}
+	if _, e := loadStorjCredentials(context.Background(), server.URL, "", ds); e == nil {
+		t.Error("expect the error of the auth service")
+	}
+}
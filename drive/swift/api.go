@@ -0,0 +1,57 @@
+package swift
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"go-drive/common/utils"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dirContentType is the content type of the directory marker objects
+const dirContentType = "application/directory"
+
+// object is an object or a pseudo directory of the container listing
+type object struct {
+	Name         string `json:"name"`
+	Bytes        int64  `json:"bytes"`
+	LastModified string `json:"last_modified"`
+	ContentType  string `json:"content_type"`
+	Subdir       string `json:"subdir"`
+}
+
+// modTime parses the last modified time of the listing, which is UTC without the zone
+func (o object) modTime() int64 {
+	t, e := time.Parse("2006-01-02T15:04:05.999999", o.LastModified)
+	if e != nil {
+		return -1
+	}
+	return utils.Millisecond(t)
+}
+
+// sloSegment is a segment of the manifest of the static large object
+type sloSegment struct {
+	Path      string `json:"path"`
+	Etag      string `json:"etag"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// escapePath escapes the segments of the path, the '/' are kept
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// tempURLSignature signs the path of the object by the temp URL key,
+// see https://docs.openstack.org/swift/latest/api/temporary_url_middleware.html
+func tempURLSignature(key, method string, expires int64, path string) string {
+	h := hmac.New(sha1.New, []byte(key))
+	_, _ = h.Write([]byte(method + "\n" + strconv.FormatInt(expires, 10) + "\n" + path))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,51 @@
+package swift
+
+import (
+	"testing"
+)
+
+func TestSwiftTempURLSignature(t *testing.T) {
+	sig := tempURLSignature("mykey", "GET", 1440619048, "/v1/AUTH_account/container/a b/c.txt")
+	if expected := "7da95abfc9a2e0f4419c9750d5389ae32b4f67cd"; sig != expected {
+		t.Errorf("expected %s, got %s", expected, sig)
+	}
+}
+
+func TestSwiftEscapePath(t *testing.T) {
+	if p := escapePath("a b/c?d/#e"); p != "a%20b/c%3Fd/%23e" {
+		t.Errorf("unexpected escaped path: %s", p)
+	}
+}
+
+func TestSwiftStorageURL(t *testing.T) {
+	token := keystoneToken{}
+	token.Token.Catalog = []keystoneService{
+		{Type: "identity", Endpoints: []keystoneEndpoint{{Interface: "public", Region: "r1", URL: "https://identity"}}},
+		{Type: "object-store", Endpoints: []keystoneEndpoint{
+			{Interface: "internal", Region: "r1", URL: "https://internal-r1/v1/AUTH_a"},
+			{Interface: "public", Region: "r1", URL: "https://public-r1/v1/AUTH_a"},
+			{Interface: "public", RegionId: "r2", URL: "https://public-r2/v1/AUTH_a/"},
+		}},
+	}
+	cases := map[string]string{
+		"":   "https://public-r1/v1/AUTH_a",
+		"r1": "https://public-r1/v1/AUTH_a",
+		"r2": "https://public-r2/v1/AUTH_a",
+		"r3": "",
+	}
+	for region, expected := range cases {
+		if u := token.storageURL(region); u != expected {
+			t.Errorf("storage URL of region '%s': expected %s, got %s", region, expected, u)
+		}
+	}
+}
+
+func TestSwiftObjectModTime(t *testing.T) {
+	o := object{LastModified: "2021-02-03T04:05:06.789000"}
+	if m := o.modTime(); m != 1612325106789 {
+		t.Errorf("unexpected mod time: %d", m)
+	}
+	if m := (object{}).modTime(); m != -1 {
+		t.Errorf("unexpected mod time: %d", m)
+	}
+}
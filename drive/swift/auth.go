@@ -0,0 +1,179 @@
+package swift
+
+import (
+	"context"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenRenewAhead is how long before the expiration the token is renewed
+const tokenRenewAhead = 5 * time.Minute
+
+type keystoneAuthRequest struct {
+	Auth struct {
+		Identity struct {
+			Methods  []string `json:"methods"`
+			Password struct {
+				User struct {
+					Name     string   `json:"name"`
+					Domain   idOrName `json:"domain"`
+					Password string   `json:"password"`
+				} `json:"user"`
+			} `json:"password"`
+		} `json:"identity"`
+		Scope struct {
+			Project struct {
+				Name   string   `json:"name"`
+				Domain idOrName `json:"domain"`
+			} `json:"project"`
+		} `json:"scope"`
+	} `json:"auth"`
+}
+
+type idOrName struct {
+	Name string `json:"name"`
+}
+
+type keystoneEndpoint struct {
+	Interface string `json:"interface"`
+	Region    string `json:"region"`
+	RegionId  string `json:"region_id"`
+	URL       string `json:"url"`
+}
+
+type keystoneService struct {
+	Type      string             `json:"type"`
+	Endpoints []keystoneEndpoint `json:"endpoints"`
+}
+
+type keystoneToken struct {
+	Token struct {
+		ExpiresAt string            `json:"expires_at"`
+		Catalog   []keystoneService `json:"catalog"`
+	} `json:"token"`
+}
+
+type keystoneError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// storageURL finds the public endpoint of the object storage in region, any region if region is empty
+func (k *keystoneToken) storageURL(region string) string {
+	for _, s := range k.Token.Catalog {
+		if s.Type != "object-store" {
+			continue
+		}
+		for _, ep := range s.Endpoints {
+			if ep.Interface != "public" {
+				continue
+			}
+			if region == "" || ep.Region == region || ep.RegionId == region {
+				return strings.TrimSuffix(ep.URL, "/")
+			}
+		}
+	}
+	return ""
+}
+
+// keystoneAuth authenticates to Keystone v3 by the password, and renews the token before it expires
+type keystoneAuth struct {
+	c       *req.Client
+	request keystoneAuthRequest
+	region  string
+
+	mux        sync.Mutex
+	token      string
+	expiresAt  time.Time
+	storageURL string
+}
+
+func newKeystoneAuth(authURL, username, password, userDomain, project, projectDomain,
+	region string) (*keystoneAuth, error) {
+	authURL = strings.TrimSuffix(authURL, "/")
+	if !strings.HasSuffix(authURL, "/v3") {
+		authURL += "/v3"
+	}
+	c, e := req.NewClient(authURL, nil, ifAuthError, nil)
+	if e != nil {
+		return nil, e
+	}
+	a := &keystoneAuth{c: c, region: region}
+	r := &a.request.Auth
+	r.Identity.Methods = []string{"password"}
+	r.Identity.Password.User.Name = username
+	r.Identity.Password.User.Domain.Name = userDomain
+	r.Identity.Password.User.Password = password
+	r.Scope.Project.Name = project
+	r.Scope.Project.Domain.Name = projectDomain
+	return a, nil
+}
+
+// get returns the token and the storage URL, the token is renewed if it's going to expire
+func (a *keystoneAuth) get(ctx context.Context) (string, string, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if a.token != "" && time.Now().Add(tokenRenewAhead).Before(a.expiresAt) {
+		return a.token, a.storageURL, nil
+	}
+	resp, e := a.c.Post(ctx, "/auth/tokens", nil, req.NewJsonBody(a.request))
+	if e != nil {
+		return "", "", e
+	}
+	res := keystoneToken{}
+	if e := resp.Json(&res); e != nil {
+		return "", "", e
+	}
+	storageURL := res.storageURL(a.region)
+	if storageURL == "" {
+		return "", "", err.NewNotFoundMessageError(i18n.T("drive.swift.endpoint_not_found"))
+	}
+	expiresAt, e := time.Parse(time.RFC3339, res.Token.ExpiresAt)
+	if e != nil {
+		// the token is renewed after an hour if the expiration is unknown
+		expiresAt = time.Now().Add(time.Hour)
+	}
+	a.token = resp.Response().Header.Get("X-Subject-Token")
+	a.expiresAt = expiresAt
+	a.storageURL = storageURL
+	return a.token, a.storageURL, nil
+}
+
+// invalidate drops the token, which is rejected by the server
+func (a *keystoneAuth) invalidate() {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.token = ""
+}
+
+// authorize sets the token of the request
+func (a *keystoneAuth) authorize(r *http.Request) error {
+	token, _, e := a.get(r.Context())
+	if e != nil {
+		return e
+	}
+	r.Header.Set("X-Auth-Token", token)
+	return nil
+}
+
+func ifAuthError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	ke := keystoneError{}
+	msg := resp.Response().Status
+	if e := resp.Json(&ke); e == nil && ke.Error.Message != "" {
+		msg = ke.Error.Message
+	}
+	if resp.Status() == http.StatusUnauthorized {
+		return err.NewUnauthorizedError(i18n.T("drive.swift.auth_failed", msg))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.swift.remote_error", msg))
+}
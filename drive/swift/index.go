@@ -0,0 +1,768 @@
+package swift
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "swift",
+		DisplayName: i18n.T("drive.swift.name"),
+		README:      i18n.T("drive.swift.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "auth_url", Label: i18n.T("drive.swift.form.auth_url.label"), Type: "text", Required: true, Description: i18n.T("drive.swift.form.auth_url.description")},
+			{Field: "username", Label: i18n.T("drive.swift.form.username.label"), Type: "text", Required: true},
+			{Field: "password", Label: i18n.T("drive.swift.form.password.label"), Type: "password", Required: true},
+			{Field: "user_domain", Label: i18n.T("drive.swift.form.user_domain.label"), Type: "text", DefaultValue: defaultDomain},
+			{Field: "project", Label: i18n.T("drive.swift.form.project.label"), Type: "text", Required: true},
+			{Field: "project_domain", Label: i18n.T("drive.swift.form.project_domain.label"), Type: "text", DefaultValue: defaultDomain},
+			{Field: "region", Label: i18n.T("drive.swift.form.region.label"), Type: "text", Description: i18n.T("drive.swift.form.region.description")},
+			{Field: "container", Label: i18n.T("drive.swift.form.container.label"), Type: "text", Required: true},
+			{Field: "prefix", Label: i18n.T("drive.swift.form.prefix.label"), Type: "text", Description: i18n.T("drive.swift.form.prefix.description")},
+			{Field: "segment_type", Label: i18n.T("drive.swift.form.segment_type.label"), Type: "select", Description: i18n.T("drive.swift.form.segment_type.description"),
+				Options: []types.FormItemOption{
+					{Name: i18n.T("drive.swift.form.segment_type.slo"), Value: segmentSLO},
+					{Name: i18n.T("drive.swift.form.segment_type.dlo"), Value: segmentDLO},
+				}, DefaultValue: segmentSLO},
+			{Field: "segment_size", Label: i18n.T("drive.swift.form.segment_size.label"), Type: "text", Description: i18n.T("drive.swift.form.segment_size.description"), DefaultValue: defaultSegmentSize},
+			{Field: "temp_url_key", Label: i18n.T("drive.swift.form.temp_url_key.label"), Type: "password", Description: i18n.T("drive.swift.form.temp_url_key.description")},
+			{Field: "proxy_download", Label: i18n.T("drive.swift.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.swift.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.swift.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.swift.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewSwiftDrive},
+	})
+}
+
+const (
+	defaultDomain      = "Default"
+	defaultSegmentSize = "1G"
+
+	segmentSLO = "slo"
+	segmentDLO = "dlo"
+
+	// minSegmentSize is the min size of the segments of the static large objects, except the last one
+	minSegmentSize = 1024 * 1024
+	// maxSegments is the default max number of the segments of a static large object
+	maxSegments = 1000
+	// maxCopySize is the max size of the objects copied by the server
+	maxCopySize = 5 * 1024 * 1024 * 1024
+	// listLimit is the limit of the container listing, 10000 is the default max of the Swift proxies
+	listLimit = 1000
+	// urlTTL is how long the temp URLs are valid
+	urlTTL = 8 * time.Hour
+)
+
+type SwiftDrive struct {
+	c          *req.Client
+	auth       *keystoneAuth
+	storageURL string
+	container  string
+	// segmentContainer is the container of the segments of the large objects
+	segmentContainer string
+	// prefix is the prefix of the names of the root, it's empty or ends with '/'
+	prefix string
+
+	segmentType string
+	segmentSize int64
+	tempURLKey  string
+
+	downloadProxy bool
+	cacheTTL      time.Duration
+	cache         drive_util.DriveCache
+}
+
+// NewSwiftDrive creates the drive of an OpenStack Swift container, authenticated by Keystone v3
+func NewSwiftDrive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	segmentType := config["segment_type"]
+	if segmentType != segmentDLO {
+		segmentType = segmentSLO
+	}
+	segmentSizeStr := config["segment_size"]
+	if segmentSizeStr == "" {
+		segmentSizeStr = defaultSegmentSize
+	}
+	segmentSize, e := utils.ParseBytes(segmentSizeStr)
+	if e != nil || segmentSize < minSegmentSize {
+		return nil, err.NewBadRequestError(i18n.T("drive.swift.invalid_segment_size", utils.FormatBytes(minSegmentSize, 0)))
+	}
+	userDomain, projectDomain := config["user_domain"], config["project_domain"]
+	if userDomain == "" {
+		userDomain = defaultDomain
+	}
+	if projectDomain == "" {
+		projectDomain = defaultDomain
+	}
+	auth, e := newKeystoneAuth(config["auth_url"], config["username"], config["password"],
+		userDomain, config["project"], projectDomain, config["region"])
+	if e != nil {
+		return nil, e
+	}
+	_, storageURL, e := auth.get(ctx)
+	if e != nil {
+		return nil, e
+	}
+
+	prefix := utils.CleanPath(config["prefix"])
+	if prefix != "" {
+		prefix += "/"
+	}
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	d := &SwiftDrive{
+		auth:             auth,
+		storageURL:       storageURL,
+		container:        config["container"],
+		segmentContainer: config["container"] + "_segments",
+		prefix:           prefix,
+		segmentType:      segmentType,
+		segmentSize:      int64(segmentSize),
+		tempURLKey:       config["temp_url_key"],
+		downloadProxy:    config["proxy_download"] != "",
+		cacheTTL:         cacheTtl,
+	}
+	if d.c, e = req.NewClient("", auth.authorize, d.ifApiCallError, nil); e != nil {
+		return nil, e
+	}
+	if cacheTtl <= 0 {
+		d.cache = drive_util.DummyCache()
+	} else {
+		d.cache = driveUtils.CreateCache(d.deserializeEntry, nil)
+	}
+	return d, d.check(ctx)
+}
+
+// check checks the container, and finds the temp URL key of the container or the account if it's not configured
+func (d *SwiftDrive) check(ctx context.Context) error {
+	resp, e := d.c.Request(ctx, "HEAD", d.containerURL(d.container, nil), nil, nil)
+	if err.IsNotFoundError(e) {
+		return err.NewNotFoundMessageError(i18n.T("drive.swift.container_not_exists", d.container))
+	}
+	if e != nil {
+		return e
+	}
+	_ = resp.Dispose()
+	if d.tempURLKey != "" {
+		return nil
+	}
+	if d.tempURLKey = resp.Response().Header.Get("X-Container-Meta-Temp-URL-Key"); d.tempURLKey != "" {
+		return nil
+	}
+	// the account may not be accessible to the user of the project
+	if resp, e := d.c.Request(ctx, "HEAD", d.storageURL, nil, nil); e == nil {
+		_ = resp.Dispose()
+		d.tempURLKey = resp.Response().Header.Get("X-Account-Meta-Temp-URL-Key")
+	}
+	return nil
+}
+
+// key returns the object name of path
+func (d *SwiftDrive) key(path string) string {
+	return d.prefix + path
+}
+
+func (d *SwiftDrive) containerURL(container string, query url.Values) string {
+	u := d.storageURL + "/" + url.PathEscape(container)
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// objectURL returns the URL of the object, the query is optional
+func (d *SwiftDrive) objectURL(container, key string, query url.Values) string {
+	u := d.storageURL + "/" + url.PathEscape(container) + "/" + escapePath(key)
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (d *SwiftDrive) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	return &swiftEntry{d: d, path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir()}, nil
+}
+
+func (d *SwiftDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *SwiftDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &swiftEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := d.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	entry, e := d.get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	_ = d.cache.PutEntry(entry, d.cacheTTL)
+	return entry, nil
+}
+
+// get finds the object of path, which may be a directory marker of dirContentType,
+// or the pseudo directory if any object is prefixed with path + '/'
+func (d *SwiftDrive) get(ctx context.Context, path string) (*swiftEntry, error) {
+	resp, e := d.c.Request(ctx, "HEAD", d.objectURL(d.container, d.key(path), nil), nil, nil)
+	if e == nil {
+		_ = resp.Dispose()
+		header := resp.Response().Header
+		modTime := int64(-1)
+		if t, e := http.ParseTime(header.Get("Last-Modified")); e == nil {
+			modTime = utils.Millisecond(t)
+		}
+		contentType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		return &swiftEntry{d: d, path: path, size: resp.Response().ContentLength, modTime: modTime,
+			isDir: contentType == dirContentType}, nil
+	}
+	if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	objects, e := d.list(ctx, d.container, d.key(path)+"/", "", "", 1)
+	if e != nil {
+		return nil, e
+	}
+	if len(objects) == 0 {
+		return nil, err.NewNotFoundError()
+	}
+	return &swiftEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+}
+
+func (d *SwiftDrive) list(ctx context.Context, container, prefix, delimiter, marker string, limit int) ([]object, error) {
+	query := url.Values{"format": {"json"}, "prefix": {prefix}, "limit": {strconv.Itoa(limit)}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	resp, e := d.c.Get(ctx, d.containerURL(container, query), nil)
+	if e != nil {
+		return nil, e
+	}
+	objects := make([]object, 0)
+	if e := resp.Json(&objects); e != nil {
+		return nil, e
+	}
+	return objects, nil
+}
+
+// listAll lists all the objects prefixed with prefix in the container
+func (d *SwiftDrive) listAll(ctx context.Context, container, prefix string) ([]object, error) {
+	all := make([]object, 0)
+	marker := ""
+	for {
+		objects, e := d.list(ctx, container, prefix, "", marker, listLimit)
+		if e != nil {
+			return nil, e
+		}
+		all = append(all, objects...)
+		if len(objects) < listLimit {
+			return all, nil
+		}
+		marker = objects[len(objects)-1].Name
+	}
+}
+
+func (d *SwiftDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	var oldSegments []string
+	if override {
+		oldSegments = d.segmentsOf(ctx, d.key(path))
+	} else {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(size, true)
+	var e error
+	if size >= 0 && size <= d.segmentSize {
+		e = d.put(ctx, d.key(path), req.NewReaderBody(drive_util.ProgressReader(reader, ctx), size))
+	} else {
+		e = d.segmentedUpload(ctx, d.key(path), size, drive_util.ProgressReader(reader, ctx))
+	}
+	_ = d.cache.Evict(path, false)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	d.deleteSegments(ctx, oldSegments)
+	return d.Get(ctx, path)
+}
+
+func (d *SwiftDrive) put(ctx context.Context, key string, body req.RequestBody) error {
+	resp, e := d.c.Request(ctx, "PUT", d.objectURL(d.container, key, nil), nil, body)
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+// segmentedUpload uploads the file by segments to the segment container, and puts the manifest of them.
+// The segments are streamed, the uploaded segments are deleted if the upload fails
+func (d *SwiftDrive) segmentedUpload(ctx types.TaskCtx, key string, size int64, reader io.Reader) error {
+	resp, e := d.c.Request(ctx, "PUT", d.containerURL(d.segmentContainer, nil), nil, nil)
+	if e != nil {
+		return e
+	}
+	_ = resp.Dispose()
+
+	segmentSize := d.segmentSize
+	if size > segmentSize*maxSegments {
+		segmentSize = (size + maxSegments - 1) / maxSegments
+	}
+	prefix := key + "/" + d.segmentType + "/" + strconv.FormatInt(time.Now().UnixNano(), 10) + "/"
+	segments := make([]sloSegment, 0)
+	br := bufio.NewReader(reader)
+	uploaded := int64(0)
+	for {
+		if e = ctx.WaitIfPaused(); e != nil {
+			break
+		}
+		if size >= 0 && uploaded >= size {
+			break
+		}
+		if _, ee := br.Peek(1); ee != nil {
+			if ee != io.EOF {
+				e = ee
+			}
+			break
+		}
+		n, length := segmentSize, int64(-1)
+		if size >= 0 {
+			if size-uploaded < n {
+				n = size - uploaded
+			}
+			length = n
+		}
+		name := prefix + fmt.Sprintf("%08d", len(segments))
+		lr := &io.LimitedReader{R: br, N: n}
+		resp, ee := d.c.Request(ctx, "PUT", d.objectURL(d.segmentContainer, name, nil), nil, req.NewReaderBody(lr, length))
+		if ee != nil {
+			e = ee
+			break
+		}
+		_ = resp.Dispose()
+		segments = append(segments, sloSegment{
+			Path:      "/" + d.segmentContainer + "/" + name,
+			Etag:      strings.Trim(resp.Response().Header.Get("Etag"), "\""),
+			SizeBytes: n - lr.N,
+		})
+		uploaded += n - lr.N
+	}
+	if e == nil {
+		if len(segments) == 0 {
+			// the manifest of the static large object can not be empty
+			return d.put(ctx, key, req.NewReaderBody(strings.NewReader(""), 0))
+		}
+		e = d.putManifest(ctx, key, prefix, segments)
+	}
+	if e != nil {
+		paths := make([]string, len(segments))
+		for i, s := range segments {
+			paths[i] = s.Path
+		}
+		d.deleteSegments(context.Background(), paths)
+	}
+	return e
+}
+
+func (d *SwiftDrive) putManifest(ctx context.Context, key, prefix string, segments []sloSegment) error {
+	var resp req.Response
+	var e error
+	if d.segmentType == segmentSLO {
+		resp, e = d.c.Request(ctx, "PUT", d.objectURL(d.container, key, url.Values{"multipart-manifest": {"put"}}),
+			nil, req.NewJsonBody(segments))
+	} else {
+		resp, e = d.c.Request(ctx, "PUT", d.objectURL(d.container, key, nil),
+			types.SM{"X-Object-Manifest": url.PathEscape(d.segmentContainer) + "/" + escapePath(prefix)}, nil)
+	}
+	if e != nil {
+		return e
+	}
+	return resp.Dispose()
+}
+
+// segmentsOf returns the paths of the segments of the large object, like '/container/name',
+// it returns nil if the object is not a large object, or any error occurs
+func (d *SwiftDrive) segmentsOf(ctx context.Context, key string) []string {
+	resp, e := d.c.Request(ctx, "HEAD", d.objectURL(d.container, key, nil), nil, nil)
+	if e != nil {
+		return nil
+	}
+	_ = resp.Dispose()
+	header := resp.Response().Header
+	if strings.EqualFold(header.Get("X-Static-Large-Object"), "true") {
+		resp, e := d.c.Get(ctx, d.objectURL(d.container, key,
+			url.Values{"multipart-manifest": {"get"}, "format": {"raw"}}), nil)
+		if e != nil {
+			return nil
+		}
+		segments := make([]sloSegment, 0)
+		if e := resp.Json(&segments); e != nil {
+			return nil
+		}
+		paths := make([]string, len(segments))
+		for i, s := range segments {
+			paths[i] = s.Path
+		}
+		return paths
+	}
+	manifest, e := url.PathUnescape(header.Get("X-Object-Manifest"))
+	if e != nil || manifest == "" {
+		return nil
+	}
+	i := strings.Index(manifest, "/")
+	if i < 0 {
+		return nil
+	}
+	container, prefix := manifest[:i], manifest[i+1:]
+	objects, e := d.listAll(ctx, container, prefix)
+	if e != nil {
+		return nil
+	}
+	paths := make([]string, len(objects))
+	for i, o := range objects {
+		paths[i] = "/" + container + "/" + o.Name
+	}
+	return paths
+}
+
+// deleteSegments deletes the segments, the errors are ignored as the segments are invisible
+func (d *SwiftDrive) deleteSegments(ctx context.Context, paths []string) {
+	for _, p := range paths {
+		resp, e := d.c.Request(ctx, "DELETE", d.storageURL+escapePath(p), nil, nil)
+		if e == nil {
+			_ = resp.Dispose()
+		}
+	}
+}
+
+// deleteObject deletes the object, and its segments if it's a large object
+func (d *SwiftDrive) deleteObject(ctx context.Context, key string) error {
+	segments := d.segmentsOf(ctx, key)
+	resp, e := d.c.Request(ctx, "DELETE", d.objectURL(d.container, key, nil), nil, nil)
+	if e != nil {
+		return e
+	}
+	_ = resp.Dispose()
+	d.deleteSegments(ctx, segments)
+	return nil
+}
+
+func (d *SwiftDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := d.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	resp, e := d.c.Request(ctx, "PUT", d.objectURL(d.container, d.key(path)+"/", nil),
+		types.SM{"Content-Type": dirContentType}, nil)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return &swiftEntry{d: d, path: path, isDir: true, modTime: -1}, nil
+}
+
+func (d *SwiftDrive) isSelf(e types.IEntry) bool {
+	if se, ok := e.(*swiftEntry); ok {
+		return se.d == d
+	}
+	return false
+}
+
+// Copy copies the object by the server, the directories and the large objects are not supported
+func (d *SwiftDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || from.Type().IsDir() {
+		return nil, err.NewUnsupportedError()
+	}
+	// the sizes of the dynamic large objects are zero in the listing
+	source, e := d.get(ctx, from.Path())
+	if e != nil {
+		return nil, e
+	}
+	if source.isDir || source.size > maxCopySize {
+		return nil, err.NewUnsupportedError()
+	}
+	var oldSegments []string
+	if override {
+		oldSegments = d.segmentsOf(ctx, d.key(to))
+	} else {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, to); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(source.size, false)
+	resp, e := d.c.Request(ctx, "PUT", d.objectURL(d.container, d.key(to), nil), types.SM{
+		"X-Copy-From": "/" + url.PathEscape(d.container) + "/" + escapePath(d.key(from.Path())),
+	}, nil)
+	_ = d.cache.Evict(to, true)
+	_ = d.cache.Evict(utils.PathParent(to), false)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	d.deleteSegments(ctx, oldSegments)
+	ctx.Progress(source.size, false)
+	return d.Get(ctx, to)
+}
+
+// Move copies the object, and deletes the source, as Swift can not rename objects
+func (d *SwiftDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	entry, e := d.Copy(ctx, from, to, override)
+	if e != nil {
+		return nil, e
+	}
+	if e := d.deleteObject(ctx, d.key(from.Path())); e != nil {
+		return nil, e
+	}
+	_ = d.cache.Evict(from.Path(), true)
+	_ = d.cache.Evict(utils.PathParent(from.Path()), false)
+	return entry, nil
+}
+
+// List lists the directory page by page, the marker object of the directory is skipped
+func (d *SwiftDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := d.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	prefix := d.prefix
+	if !utils.IsRootPath(path) {
+		prefix = d.key(path) + "/"
+	}
+	entries := make([]types.IEntry, 0)
+	names := make(map[string]bool)
+	marker := ""
+	for {
+		objects, e := d.list(ctx, d.container, prefix, "/", marker, listLimit)
+		if e != nil {
+			return nil, e
+		}
+		for _, o := range objects {
+			if o.Subdir != "" {
+				p := strings.TrimSuffix(strings.TrimPrefix(o.Subdir, d.prefix), "/")
+				// skip the directory with the same name of a file or a directory marker
+				if !names[p] {
+					names[p] = true
+					entries = append(entries, &swiftEntry{d: d, path: p, isDir: true, modTime: -1})
+				}
+				continue
+			}
+			if o.Name == prefix {
+				continue
+			}
+			p := strings.TrimPrefix(o.Name, d.prefix)
+			if names[p] {
+				continue
+			}
+			names[p] = true
+			contentType, _, _ := mime.ParseMediaType(o.ContentType)
+			entries = append(entries, &swiftEntry{d: d, path: p, size: o.Bytes, modTime: o.modTime(),
+				isDir: contentType == dirContentType})
+		}
+		if len(objects) < listLimit {
+			break
+		}
+		last := objects[len(objects)-1]
+		marker = last.Name
+		if last.Subdir != "" {
+			marker = last.Subdir
+		}
+	}
+	_ = d.cache.PutChildren(path, entries, d.cacheTTL)
+	return entries, nil
+}
+
+// Delete deletes the object, or the objects under the directory one by one and then its marker,
+// as the bulk delete is an optional middleware of Swift
+func (d *SwiftDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	entry, e := d.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	if entry.Type().IsFile() {
+		e = d.deleteObject(ctx, d.key(path))
+	} else {
+		e = d.deleteDir(ctx, path)
+	}
+	_ = d.cache.Evict(path, true)
+	_ = d.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+func (d *SwiftDrive) deleteDir(ctx types.TaskCtx, path string) error {
+	// the listing may be stale after deleting, so the objects are listed before deleting
+	objects, e := d.listAll(ctx, d.container, d.key(path)+"/")
+	if e != nil {
+		return e
+	}
+	ctx.Total(int64(len(objects)), false)
+	for _, o := range objects {
+		if ctx.Canceled() {
+			return task.ErrorCanceled
+		}
+		if e := d.deleteObject(ctx, o.Name); e != nil && !err.IsNotFoundError(e) {
+			return e
+		}
+		ctx.Progress(1, false)
+	}
+	// the directory marker without the trailing '/'
+	if e := d.deleteObject(ctx, d.key(path)); e != nil && !err.IsNotFoundError(e) {
+		return e
+	}
+	return nil
+}
+
+// Upload uploads the files through the server, the large files are uploaded by segments
+func (d *SwiftDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *SwiftDrive) ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	// the error bodies are HTML pages, and the responses of HEAD have no body
+	_ = resp.Dispose()
+	switch resp.Status() {
+	case http.StatusNotFound:
+		return err.NewNotFoundError()
+	case http.StatusUnauthorized:
+		// the token may be revoked, it's renewed by the next request
+		d.auth.invalidate()
+		return err.NewUnauthorizedError(i18n.T("drive.swift.auth_failed", resp.Response().Status))
+	case http.StatusForbidden:
+		return err.NewNotAllowedMessageError(i18n.T("drive.swift.remote_error", resp.Response().Status))
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.swift.remote_error", resp.Response().Status))
+}
+
+type swiftEntry struct {
+	d       *SwiftDrive
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+}
+
+func (e *swiftEntry) Path() string {
+	return e.path
+}
+
+func (e *swiftEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *swiftEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *swiftEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *swiftEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *swiftEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *swiftEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *swiftEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+func (e *swiftEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	var header types.SM
+	if offset > 0 || length >= 0 {
+		rangeHeader := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if length >= 0 {
+			if length == 0 {
+				return ioutil.NopCloser(strings.NewReader("")), nil
+			}
+			rangeHeader += strconv.FormatInt(offset+length-1, 10)
+		}
+		header = types.SM{"Range": rangeHeader}
+	}
+	resp, ee := e.d.c.Get(ctx, e.d.objectURL(e.d.container, e.d.key(e.path), nil), header)
+	if ee != nil {
+		return nil, ee
+	}
+	return resp.Response().Body, nil
+}
+
+// GetURL returns the temp URL of the object, or the URL with the token to proxy if there is no temp URL key
+func (e *swiftEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	objectURL := e.d.objectURL(e.d.container, e.d.key(e.path), nil)
+	if e.d.tempURLKey == "" {
+		token, _, ee := e.d.auth.get(ctx)
+		if ee != nil {
+			return nil, ee
+		}
+		return &types.ContentURL{URL: objectURL, Header: types.SM{"X-Auth-Token": token}, Proxy: true}, nil
+	}
+	u, ee := url.Parse(objectURL)
+	if ee != nil {
+		return nil, ee
+	}
+	expires := time.Now().Add(urlTTL).Unix()
+	u.RawQuery = url.Values{
+		"temp_url_sig":     {tempURLSignature(e.d.tempURLKey, "GET", expires, u.Path)},
+		"temp_url_expires": {strconv.FormatInt(expires, 10)},
+	}.Encode()
+	return &types.ContentURL{URL: u.String(), Proxy: e.d.downloadProxy}, nil
+}
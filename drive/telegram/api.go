@@ -0,0 +1,121 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type apiResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  *struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+type document struct {
+	FileID   string `json:"file_id"`
+	FileSize int64  `json:"file_size"`
+}
+
+type message struct {
+	MessageID int64     `json:"message_id"`
+	Document  *document `json:"document"`
+}
+
+type messageResponse struct {
+	Result message `json:"result"`
+}
+
+type fileResponse struct {
+	Result struct {
+		FileID   string `json:"file_id"`
+		FileSize int64  `json:"file_size"`
+		FilePath string `json:"file_path"`
+	} `json:"result"`
+}
+
+type chatResponse struct {
+	Result struct {
+		ID    int64  `json:"id"`
+		Title string `json:"title"`
+	} `json:"result"`
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	r := apiResponse{}
+	if e := resp.Json(&r); e != nil || r.Description == "" {
+		r.Description = resp.Response().Status
+	}
+	switch resp.Status() {
+	case http.StatusUnauthorized:
+		return err.NewUnauthorizedError(i18n.T("drive.telegram.invalid_token"))
+	case http.StatusNotFound:
+		return err.NewNotFoundMessageError(r.Description)
+	case http.StatusTooManyRequests:
+		if r.Parameters != nil {
+			return err.NewRemoteApiError(resp.Status(),
+				i18n.T("drive.telegram.too_many_requests", strconv.Itoa(r.Parameters.RetryAfter)))
+		}
+	}
+	if strings.Contains(r.Description, "not found") {
+		return err.NewNotFoundMessageError(r.Description)
+	}
+	return err.NewRemoteApiError(resp.Status(), i18n.T("drive.telegram.remote_error", r.Description))
+}
+
+// documentBody is the multipart form of sendDocument, the document is streamed from the reader
+type documentBody struct {
+	r           io.Reader
+	length      int64
+	contentType string
+}
+
+// newDocumentBody returns the form of the fields and the document,
+// the content length is known only if size is not negative
+func newDocumentBody(fields map[string]string, name string, reader io.Reader, size int64) (*documentBody, error) {
+	head := bytes.Buffer{}
+	mw := multipart.NewWriter(&head)
+	for k, v := range fields {
+		if e := mw.WriteField(k, v); e != nil {
+			return nil, e
+		}
+	}
+	if _, e := mw.CreateFormFile("document", name); e != nil {
+		return nil, e
+	}
+	tail := fmt.Sprintf("\r\n--%s--\r\n", mw.Boundary())
+	length := int64(-1)
+	if size >= 0 {
+		length = int64(head.Len()) + size + int64(len(tail))
+	}
+	return &documentBody{
+		r:           io.MultiReader(&head, reader, strings.NewReader(tail)),
+		length:      length,
+		contentType: mw.FormDataContentType(),
+	}, nil
+}
+
+func (b *documentBody) ContentLength() int64 {
+	return b.length
+}
+
+func (b *documentBody) ContentType() string {
+	return b.contentType
+}
+
+func (b *documentBody) Reader() io.Reader {
+	return b.r
+}
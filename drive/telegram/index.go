@@ -0,0 +1,608 @@
+package telegram
+
+import (
+	"bufio"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "telegram",
+		DisplayName: i18n.T("drive.telegram.name"),
+		README:      i18n.T("drive.telegram.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "token", Label: i18n.T("drive.telegram.form.token.label"), Type: "password", Required: true, Description: i18n.T("drive.telegram.form.token.description")},
+			{Field: "chat_id", Label: i18n.T("drive.telegram.form.chat_id.label"), Type: "text", Required: true, Description: i18n.T("drive.telegram.form.chat_id.description")},
+			{Field: "api_url", Label: i18n.T("drive.telegram.form.api_url.label"), Type: "text", Description: i18n.T("drive.telegram.form.api_url.description"), DefaultValue: defaultAPIURL},
+			{Field: "part_size", Label: i18n.T("drive.telegram.form.part_size.label"), Type: "text", Description: i18n.T("drive.telegram.form.part_size.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewTelegramDrive},
+	})
+}
+
+const (
+	defaultAPIURL = "https://api.telegram.org"
+
+	// defaultPartSize is the max size of the files downloaded by the cloud Bot API
+	defaultPartSize = 20 * 1024 * 1024
+	// maxPartSize is the max size of the files uploaded to a local Bot API server
+	maxPartSize = 2000 * 1024 * 1024
+)
+
+// TelegramDrive stores the files as the documents in a chat, the files larger than the part size are split into parts.
+// The chat can't be listed by the Bot API, so the paths of the messages are indexed in the drive data store.
+type TelegramDrive struct {
+	c        *req.Client
+	apiURL   string
+	token    string
+	chatID   string
+	partSize int64
+
+	ds   drive_util.DriveDataStore
+	mux  sync.RWMutex
+	tree *tree
+}
+
+func NewTelegramDrive(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	token := strings.TrimSpace(config["token"])
+	chatID := strings.TrimSpace(config["chat_id"])
+	apiURL := strings.TrimSuffix(strings.TrimSpace(config["api_url"]), "/")
+	if apiURL == "" {
+		apiURL = defaultAPIURL
+	}
+	partSize := int64(defaultPartSize)
+	if v := strings.TrimSpace(config["part_size"]); v != "" {
+		ps, e := utils.ParseBytes(v)
+		if e != nil || ps == 0 || ps > maxPartSize {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.telegram.invalid_part_size",
+				utils.FormatBytes(maxPartSize, 0)))
+		}
+		partSize = int64(ps)
+	}
+	c, e := req.NewClient("", nil, ifApiCallError, nil)
+	if e != nil {
+		return nil, e
+	}
+	d := &TelegramDrive{c: c, apiURL: apiURL, token: token, chatID: chatID, partSize: partSize, ds: driveUtils.Data}
+
+	// the chat is resolved to its id, the index is kept if the username of the chat changes
+	resp, e := d.c.Post(ctx, d.methodURL("getChat"), nil, req.NewURLEncodedBody(types.SM{"chat_id": chatID}))
+	if e != nil {
+		return nil, e
+	}
+	chat := chatResponse{}
+	if e := resp.Json(&chat); e != nil {
+		return nil, e
+	}
+	d.chatID = strconv.FormatInt(chat.Result.ID, 10)
+	t, e := loadTree(d.ds, d.chatID)
+	if e != nil {
+		return nil, e
+	}
+	d.tree = t
+	return d, nil
+}
+
+func (d *TelegramDrive) methodURL(method string) string {
+	return d.apiURL + "/bot" + d.token + "/" + method
+}
+
+func (d *TelegramDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *TelegramDrive) newEntry(path string, n *node) *tgEntry {
+	return &tgEntry{d: d, path: path, isDir: n.Dir, size: n.Size, modTime: n.ModTime, parts: n.Parts}
+}
+
+func (d *TelegramDrive) Get(_ context.Context, path string) (types.IEntry, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	n := d.tree.get(path)
+	if n == nil {
+		return nil, err.NewNotFoundError()
+	}
+	return d.newEntry(path, n), nil
+}
+
+// sendDocument sends a part of the file, the caption is the path and the number of the part if the file is split
+func (d *TelegramDrive) sendDocument(ctx context.Context, path string, index int, split bool,
+	reader io.Reader, size int64) (*part, error) {
+	name := utils.PathBase(path)
+	caption := path
+	if split {
+		name += ".part" + strconv.Itoa(index+1)
+		caption += " #" + strconv.Itoa(index+1)
+	}
+	body, e := newDocumentBody(map[string]string{
+		"chat_id":                        d.chatID,
+		"caption":                        caption,
+		"disable_notification":           "true",
+		"disable_content_type_detection": "true",
+	}, name, reader, size)
+	if e != nil {
+		return nil, e
+	}
+	resp, e := d.c.Post(ctx, d.methodURL("sendDocument"), nil, body)
+	if e != nil {
+		return nil, e
+	}
+	return messagePart(resp)
+}
+
+func messagePart(resp req.Response) (*part, error) {
+	r := messageResponse{}
+	if e := resp.Json(&r); e != nil {
+		return nil, e
+	}
+	if r.Result.Document == nil {
+		return nil, err.NewRemoteApiError(http.StatusInternalServerError,
+			i18n.T("drive.telegram.remote_error", "no document in the message"))
+	}
+	return &part{MessageID: r.Result.MessageID, FileID: r.Result.Document.FileID, Size: r.Result.Document.FileSize}, nil
+}
+
+// deleteMessages deletes the messages of the parts,
+// the errors are ignored, as the messages may have been deleted in the chat
+func (d *TelegramDrive) deleteMessages(ctx context.Context, parts []part) {
+	for _, p := range parts {
+		resp, e := d.c.Post(ctx, d.methodURL("deleteMessage"), nil, req.NewURLEncodedBody(types.SM{
+			"chat_id":    d.chatID,
+			"message_id": strconv.FormatInt(p.MessageID, 10),
+		}))
+		if e == nil {
+			_ = resp.Dispose()
+		}
+	}
+}
+
+// putFile puts the file in the index, and deletes the messages of the replaced file
+func (d *TelegramDrive) putFile(ctx context.Context, path string, n *node) error {
+	d.mux.Lock()
+	var replaced []*node
+	if old := d.tree.get(path); old != nil {
+		if old.Dir {
+			d.mux.Unlock()
+			return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		replaced = d.tree.remove(path)
+	}
+	d.tree.mkdirAll(utils.PathParent(path), n.ModTime)
+	d.tree.Nodes[path] = n
+	e := d.tree.save(d.ds)
+	d.mux.Unlock()
+	if e != nil {
+		return e
+	}
+	for _, r := range replaced {
+		d.deleteMessages(ctx, r.Parts)
+	}
+	return nil
+}
+
+func (d *TelegramDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	ctx.Total(size, true)
+	br := bufio.NewReader(drive_util.ProgressReader(reader, ctx))
+	parts := make([]part, 0)
+	uploaded := int64(0)
+	var e error
+	for {
+		if e = ctx.WaitIfPaused(); e != nil {
+			break
+		}
+		if size >= 0 && uploaded >= size {
+			break
+		}
+		if _, ee := br.Peek(1); ee != nil {
+			if ee != io.EOF {
+				e = ee
+			}
+			break
+		}
+		n, length := d.partSize, int64(-1)
+		if size >= 0 {
+			if size-uploaded < n {
+				n = size - uploaded
+			}
+			length = n
+		}
+		lr := &io.LimitedReader{R: br, N: n}
+		p, ee := d.sendDocument(ctx, path, len(parts), size < 0 || size > d.partSize, lr, length)
+		if ee != nil {
+			e = ee
+			break
+		}
+		// the size of the part is counted locally, as file_size is optional in the responses
+		p.Size = n - lr.N
+		parts = append(parts, *p)
+		uploaded += p.Size
+	}
+	if e == nil && ctx.Err() != nil {
+		e = task.ErrorCanceled
+	}
+	if e == nil {
+		e = d.putFile(ctx, path, &node{Size: uploaded, ModTime: utils.Millisecond(time.Now()), Parts: parts})
+	}
+	if e != nil {
+		d.deleteMessages(context.Background(), parts)
+		return nil, e
+	}
+	return d.Get(ctx, path)
+}
+
+func (d *TelegramDrive) MakeDir(_ context.Context, path string) (types.IEntry, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if n := d.tree.get(path); n != nil {
+		if !n.Dir {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return d.newEntry(path, n), nil
+	}
+	d.tree.mkdirAll(path, utils.Millisecond(time.Now()))
+	if e := d.tree.save(d.ds); e != nil {
+		delete(d.tree.Nodes, path)
+		return nil, e
+	}
+	return d.newEntry(path, d.tree.get(path)), nil
+}
+
+func (d *TelegramDrive) isSelf(e types.IEntry) bool {
+	if te, ok := e.(*tgEntry); ok {
+		return te.d == d
+	}
+	return false
+}
+
+// checkTarget checks the target of copying or moving, the existing directories are merged by the caller
+func (d *TelegramDrive) checkTarget(ctx context.Context, from types.IEntry, to string, override bool) error {
+	existing, e := d.Get(ctx, to)
+	if err.IsNotFoundError(e) {
+		return nil
+	}
+	if e != nil {
+		return e
+	}
+	if from.Type().IsDir() || existing.Type().IsDir() {
+		return err.NewUnsupportedError()
+	}
+	if !override {
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	}
+	return nil
+}
+
+// Copy sends the documents again by their file ids, the contents are not uploaded again
+func (d *TelegramDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || from.Type().IsDir() {
+		return nil, err.NewUnsupportedError()
+	}
+	if e := d.checkTarget(ctx, from, to, override); e != nil {
+		return nil, e
+	}
+	src := from.(*tgEntry)
+	ctx.Total(int64(len(src.parts)), true)
+	parts := make([]part, 0, len(src.parts))
+	var e error
+	for i, p := range src.parts {
+		caption := to
+		if len(src.parts) > 1 {
+			caption += " #" + strconv.Itoa(i+1)
+		}
+		resp, ee := d.c.Post(ctx, d.methodURL("sendDocument"), nil, req.NewURLEncodedBody(types.SM{
+			"chat_id":              d.chatID,
+			"document":             p.FileID,
+			"caption":              caption,
+			"disable_notification": "true",
+		}))
+		if ee != nil {
+			e = ee
+			break
+		}
+		np, ee := messagePart(resp)
+		if ee != nil {
+			e = ee
+			break
+		}
+		np.Size = p.Size
+		parts = append(parts, *np)
+		ctx.Progress(1, false)
+	}
+	if e == nil {
+		e = d.putFile(ctx, to, &node{Size: src.size, ModTime: utils.Millisecond(time.Now()), Parts: parts})
+	}
+	if e != nil {
+		d.deleteMessages(context.Background(), parts)
+		return nil, e
+	}
+	return d.Get(ctx, to)
+}
+
+// Move moves the file or the directory in the index only, the messages are kept
+func (d *TelegramDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	if e := d.checkTarget(ctx, from, to, override); e != nil {
+		return nil, e
+	}
+	d.mux.Lock()
+	if d.tree.get(from.Path()) == nil {
+		d.mux.Unlock()
+		return nil, err.NewNotFoundError()
+	}
+	replaced := d.tree.remove(to)
+	d.tree.mkdirAll(utils.PathParent(to), utils.Millisecond(time.Now()))
+	d.tree.move(from.Path(), to)
+	e := d.tree.save(d.ds)
+	d.mux.Unlock()
+	if e != nil {
+		return nil, e
+	}
+	for _, r := range replaced {
+		d.deleteMessages(ctx, r.Parts)
+	}
+	return d.Get(ctx, to)
+}
+
+func (d *TelegramDrive) List(_ context.Context, path string) ([]types.IEntry, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	n := d.tree.get(path)
+	if n == nil {
+		return nil, err.NewNotFoundError()
+	}
+	if !n.Dir {
+		return nil, err.NewNotAllowedError()
+	}
+	children := d.tree.children(path)
+	entries := make([]types.IEntry, 0, len(children))
+	for _, p := range children {
+		entries = append(entries, d.newEntry(p, d.tree.Nodes[p]))
+	}
+	return entries, nil
+}
+
+func (d *TelegramDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	d.mux.Lock()
+	if d.tree.get(path) == nil {
+		d.mux.Unlock()
+		return err.NewNotFoundError()
+	}
+	removed := d.tree.remove(path)
+	e := d.tree.save(d.ds)
+	d.mux.Unlock()
+	if e != nil {
+		return e
+	}
+	ctx.Total(int64(len(removed)), true)
+	for _, r := range removed {
+		if ctx.Err() != nil {
+			return task.ErrorCanceled
+		}
+		d.deleteMessages(ctx, r.Parts)
+		ctx.Progress(1, false)
+	}
+	return nil
+}
+
+func (d *TelegramDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+// fileURL returns the URL to download the document, the URL contains the token
+func (d *TelegramDrive) fileURL(ctx context.Context, fileID string) (string, error) {
+	resp, e := d.c.Post(ctx, d.methodURL("getFile"), nil, req.NewURLEncodedBody(types.SM{"file_id": fileID}))
+	if e != nil {
+		return "", e
+	}
+	r := fileResponse{}
+	if e := resp.Json(&r); e != nil {
+		return "", e
+	}
+	return d.apiURL + "/file/bot" + d.token + "/" + strings.TrimPrefix(r.Result.FilePath, "/"), nil
+}
+
+// openPart opens the part from the offset, the length is -1 to read to the end
+func (d *TelegramDrive) openPart(ctx context.Context, p part, offset, length int64) (io.ReadCloser, error) {
+	u, e := d.fileURL(ctx, p.FileID)
+	if e != nil {
+		return nil, e
+	}
+	headers := types.SM{}
+	if offset > 0 || length >= 0 {
+		end := ""
+		if length >= 0 {
+			end = strconv.FormatInt(offset+length-1, 10)
+		}
+		headers["Range"] = "bytes=" + strconv.FormatInt(offset, 10) + "-" + end
+	}
+	resp, e := d.c.Get(ctx, u, headers)
+	if e != nil {
+		return nil, e
+	}
+	body := resp.Response().Body
+	if resp.Status() == http.StatusPartialContent {
+		return body, nil
+	}
+	// the range is ignored by the server
+	if _, e := io.CopyN(ioutil.Discard, body, offset); e != nil {
+		_ = body.Close()
+		return nil, e
+	}
+	if length < 0 {
+		return body, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(body, length), body}, nil
+}
+
+type tgEntry struct {
+	d       *TelegramDrive
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+	parts   []part
+}
+
+func (e *tgEntry) Path() string {
+	return e.path
+}
+
+func (e *tgEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *tgEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *tgEntry) Meta() types.EntryMeta {
+	meta := types.EntryMeta{CanRead: true, CanWrite: true}
+	if !e.isDir {
+		ids := make([]string, len(e.parts))
+		for i, p := range e.parts {
+			ids[i] = strconv.FormatInt(p.MessageID, 10)
+		}
+		meta.Props = types.M{"message_ids": strings.Join(ids, ",")}
+	}
+	return meta
+}
+
+func (e *tgEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *tgEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *tgEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *tgEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+// GetRangeReader reads the parts overlapping the range one by one
+func (e *tgEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	if length < 0 || offset+length > e.size {
+		length = e.size - offset
+	}
+	if length <= 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	return &partsReader{ctx: ctx, e: e, offset: offset, remaining: length}, nil
+}
+
+// GetURL is unsupported, as the URLs of the documents contain the token
+func (e *tgEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+// partsReader reads the parts lazily, the next part is opened when the current one is drained
+type partsReader struct {
+	ctx       context.Context
+	e         *tgEntry
+	offset    int64
+	remaining int64
+	current   io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+		if r.current == nil {
+			rc, e := r.openNext()
+			if e != nil {
+				return 0, e
+			}
+			r.current = rc
+		}
+		if int64(len(p)) > r.remaining {
+			p = p[:r.remaining]
+		}
+		n, e := r.current.Read(p)
+		r.offset += int64(n)
+		r.remaining -= int64(n)
+		if e == io.EOF {
+			_ = r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, e
+	}
+}
+
+// openNext opens the part containing the offset
+func (r *partsReader) openNext() (io.ReadCloser, error) {
+	start := int64(0)
+	for _, p := range r.e.parts {
+		if r.offset < start+p.Size {
+			length := p.Size - (r.offset - start)
+			if length > r.remaining {
+				length = r.remaining
+			}
+			return r.e.d.openPart(r.ctx, p, r.offset-start, length)
+		}
+		start += p.Size
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (r *partsReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package telegram
+
+import (
+	"encoding/json"
+	"go-drive/common/drive_util"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// indexChunkSize is the max length of the values in the data store
+const indexChunkSize = 4000
+
+const (
+	keyIndexChunks = "index.chunks"
+	keyIndexPrefix = "index."
+)
+
+// part is a document message in the chat
+type part struct {
+	MessageID int64  `json:"m"`
+	FileID    string `json:"f"`
+	Size      int64  `json:"s"`
+}
+
+// node is a file or a directory, the directories are only in the index
+type node struct {
+	Dir     bool   `json:"d,omitempty"`
+	Size    int64  `json:"s,omitempty"`
+	ModTime int64  `json:"t,omitempty"`
+	Parts   []part `json:"p,omitempty"`
+}
+
+// tree is the index of the paths to the messages, the root is not in the nodes
+type tree struct {
+	Chat  string           `json:"chat"`
+	Nodes map[string]*node `json:"nodes"`
+}
+
+// loadTree loads the index from the data store, an empty index is returned if it's of another chat
+func loadTree(ds drive_util.DriveDataStore, chat string) (*tree, error) {
+	t := &tree{Chat: chat, Nodes: make(map[string]*node)}
+	dat, e := ds.Load(keyIndexChunks)
+	if e != nil {
+		return nil, e
+	}
+	n := int(utils.ToInt64(dat[keyIndexChunks], 0))
+	if n <= 0 {
+		return t, nil
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = keyIndexPrefix + strconv.Itoa(i)
+	}
+	if dat, e = ds.Load(keys...); e != nil {
+		return nil, e
+	}
+	sb := strings.Builder{}
+	for _, k := range keys {
+		sb.WriteString(dat[k])
+	}
+	loaded := &tree{}
+	if e := json.Unmarshal([]byte(sb.String()), loaded); e != nil {
+		return nil, e
+	}
+	if loaded.Chat != chat || loaded.Nodes == nil {
+		return t, nil
+	}
+	return loaded, nil
+}
+
+// save saves the index in chunks, as the size of the values in the data store is limited
+func (t *tree) save(ds drive_util.DriveDataStore) error {
+	dat, e := ds.Load(keyIndexChunks)
+	if e != nil {
+		return e
+	}
+	old := int(utils.ToInt64(dat[keyIndexChunks], 0))
+	b, e := json.Marshal(t)
+	if e != nil {
+		return e
+	}
+	s := string(b)
+	values := types.SM{}
+	n := 0
+	for ; len(s) > 0; n++ {
+		l := indexChunkSize
+		if l > len(s) {
+			l = len(s)
+		}
+		values[keyIndexPrefix+strconv.Itoa(n)] = s[:l]
+		s = s[l:]
+	}
+	// the empty values remove the stale chunks
+	for i := n; i < old; i++ {
+		values[keyIndexPrefix+strconv.Itoa(i)] = ""
+	}
+	values[keyIndexChunks] = strconv.Itoa(n)
+	return ds.Save(values)
+}
+
+func (t *tree) get(path string) *node {
+	if utils.IsRootPath(path) {
+		return &node{Dir: true}
+	}
+	return t.Nodes[path]
+}
+
+// children returns the paths of the direct children of the directory, sorted by name
+func (t *tree) children(path string) []string {
+	r := make([]string, 0)
+	for p := range t.Nodes {
+		if utils.PathParent(p) == path {
+			r = append(r, p)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// descendants returns the path and the paths of all its descendants
+func (t *tree) descendants(path string) []string {
+	r := []string{path}
+	prefix := path + "/"
+	for p := range t.Nodes {
+		if strings.HasPrefix(p, prefix) {
+			r = append(r, p)
+		}
+	}
+	return r
+}
+
+// mkdirAll adds the directory and its missing parents
+func (t *tree) mkdirAll(path string, modTime int64) {
+	for !utils.IsRootPath(path) {
+		if _, ok := t.Nodes[path]; ok {
+			return
+		}
+		t.Nodes[path] = &node{Dir: true, ModTime: modTime}
+		path = utils.PathParent(path)
+	}
+}
+
+// remove removes the path and its descendants, the removed files are returned
+func (t *tree) remove(path string) []*node {
+	removed := make([]*node, 0)
+	for _, p := range t.descendants(path) {
+		if n, ok := t.Nodes[p]; ok {
+			if !n.Dir {
+				removed = append(removed, n)
+			}
+			delete(t.Nodes, p)
+		}
+	}
+	return removed
+}
+
+// move moves the path and its descendants to the new path
+func (t *tree) move(from, to string) {
+	for _, p := range t.descendants(from) {
+		if n, ok := t.Nodes[p]; ok {
+			delete(t.Nodes, p)
+			t.Nodes[to+p[len(from):]] = n
+		}
+	}
+}
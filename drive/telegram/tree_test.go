@@ -0,0 +1,92 @@
+package telegram
+
+import (
+	"go-drive/common/types"
+	"strings"
+	"testing"
+)
+
+type memDataStore struct {
+	data types.SM
+}
+
+func (m *memDataStore) Save(values types.SM) error {
+	for k, v := range values {
+		if v == "" {
+			delete(m.data, k)
+		} else {
+			m.data[k] = v
+		}
+	}
+	return nil
+}
+
+func (m *memDataStore) Load(keys ...string) (types.SM, error) {
+	r := types.SM{}
+	for _, k := range keys {
+		if v, ok := m.data[k]; ok {
+			r[k] = v
+		}
+	}
+	return r, nil
+}
+
+func TestTelegramTreeSaveLoad(t *testing.T) {
+	ds := &memDataStore{data: types.SM{}}
+	tr, e := loadTree(ds, "-100")
+	if e != nil || len(tr.Nodes) != 0 {
+		t.Fatalf("unexpected tree: %v, %v", tr, e)
+	}
+	fileID := strings.Repeat("x", 100)
+	for i := 0; i < 100; i++ {
+		tr.Nodes["dir/f"+strings.Repeat("0", i)] = &node{Size: 1, Parts: []part{{MessageID: int64(i), FileID: fileID, Size: 1}}}
+	}
+	tr.mkdirAll("dir/sub", 1)
+	if e := tr.save(ds); e != nil {
+		t.Fatal(e)
+	}
+	chunks := len(ds.data)
+	if chunks < 3 {
+		t.Fatalf("expect the index to be split, got %d", chunks)
+	}
+
+	loaded, e := loadTree(ds, "-100")
+	if e != nil || len(loaded.Nodes) != 102 {
+		t.Fatalf("unexpected tree: %d, %v", len(loaded.Nodes), e)
+	}
+	if n := loaded.get("dir/f000"); n == nil || n.Parts[0].MessageID != 3 || n.Parts[0].FileID != fileID {
+		t.Errorf("unexpected node: %+v", n)
+	}
+	if other, _ := loadTree(ds, "-200"); len(other.Nodes) != 0 {
+		t.Error("expect the empty index of another chat")
+	}
+
+	removed := loaded.remove("dir")
+	if len(removed) != 100 || len(loaded.Nodes) != 0 {
+		t.Errorf("unexpected removing: %d, %d", len(removed), len(loaded.Nodes))
+	}
+	if e := loaded.save(ds); e != nil {
+		t.Fatal(e)
+	}
+	if len(ds.data) >= chunks {
+		t.Errorf("expect the stale chunks to be removed, got %d", len(ds.data))
+	}
+}
+
+func TestTelegramTreeMove(t *testing.T) {
+	tr := &tree{Nodes: map[string]*node{}}
+	tr.mkdirAll("a/b", 1)
+	tr.Nodes["a/b/c.txt"] = &node{Size: 3}
+	tr.Nodes["ab.txt"] = &node{Size: 2}
+	tr.move("a", "x/y")
+	tr.mkdirAll("x", 1)
+	if tr.get("x/y/b/c.txt") == nil || tr.get("x/y/b") == nil || tr.get("a") != nil || tr.get("ab.txt") == nil {
+		t.Errorf("unexpected tree: %v", tr.Nodes)
+	}
+	if c := tr.children(""); len(c) != 2 || c[0] != "ab.txt" || c[1] != "x" {
+		t.Errorf("unexpected children: %v", c)
+	}
+	if c := tr.children("x/y/b"); len(c) != 1 || c[0] != "x/y/b/c.txt" {
+		t.Errorf("unexpected children: %v", c)
+	}
+}
@@ -0,0 +1,286 @@
+package throttle
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	path2 "path"
+	"strings"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "throttle",
+		DisplayName: i18n.T("drive.throttle.name"),
+		README:      i18n.T("drive.throttle.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "path", Label: i18n.T("drive.throttle.form.path.label"), Type: "text", Required: true, Description: i18n.T("drive.throttle.form.path.description")},
+			{Field: "upload_limit", Label: i18n.T("drive.throttle.form.upload_limit.label"), Type: "text", Description: i18n.T("drive.throttle.form.upload_limit.description")},
+			{Field: "download_limit", Label: i18n.T("drive.throttle.form.download_limit.label"), Type: "text", Description: i18n.T("drive.throttle.form.download_limit.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewThrottleDrive},
+	})
+}
+
+// ThrottleDrive limits the speed of the transfers of a directory of another mount.
+// The limits are shared fairly by all the transfers of the drive.
+type ThrottleDrive struct {
+	root func() types.IDrive
+	path string
+	// up limits writing to the directory, down limits reading from it
+	up   *drive_util.FairScheduler
+	down *drive_util.FairScheduler
+	// guard stops the drive being accessed through itself
+	guard *drive_util.RecursionGuard
+}
+
+func NewThrottleDrive(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	if driveUtils.Root == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	up, e := parseLimit(config["upload_limit"])
+	if e != nil {
+		return nil, e
+	}
+	down, e := parseLimit(config["download_limit"])
+	if e != nil {
+		return nil, e
+	}
+	return &ThrottleDrive{
+		root:  driveUtils.Root,
+		path:  utils.CleanPath(config["path"]),
+		up:    drive_util.NewFairScheduler(up),
+		down:  drive_util.NewFairScheduler(down),
+		guard: drive_util.NewRecursionGuard(i18n.T("drive.throttle.recursive")),
+	}, nil
+}
+
+// parseLimit parses the bytes per second like '512K', empty means unlimited
+func parseLimit(s string) (int64, error) {
+	if strings.TrimSpace(s) == "" {
+		return 0, nil
+	}
+	v, e := utils.ParseBytes(s)
+	if e != nil {
+		return 0, err.NewBadRequestError(i18n.T("drive.throttle.invalid_limit", s))
+	}
+	return int64(v), nil
+}
+
+// realPath returns the path of the entry in the root drive
+func (d *ThrottleDrive) realPath(path string) string {
+	return path2.Join(d.path, path)
+}
+
+func (d *ThrottleDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *ThrottleDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Get(ctx, d.realPath(path))
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *ThrottleDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return nil, err.NewNotAllowedError()
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	stream := d.up.Open(path)
+	defer stream.Close()
+	entry, e := d.root().Save(ctx, d.realPath(path), size, override, stream.Reader(ctx, reader))
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *ThrottleDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().MakeDir(ctx, d.realPath(path))
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(path, entry), nil
+}
+
+func (d *ThrottleDrive) isSelf(e types.IEntry) bool {
+	if te, ok := e.(*throttleEntry); ok {
+		return te.d == d
+	}
+	return false
+}
+
+// Copy is not supported, so that the contents are copied by reading and saving through the drive, which are limited
+func (d *ThrottleDrive) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+// Move moves the entry in the root drive, which is usually renaming without transferring the content
+func (d *ThrottleDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entry, e := d.root().Move(ctx, from.(*throttleEntry).entry, d.realPath(to), override)
+	if e != nil {
+		return nil, e
+	}
+	return d.newEntry(to, entry), nil
+}
+
+func (d *ThrottleDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	entries, e := d.root().List(ctx, d.realPath(path))
+	if e != nil {
+		return nil, e
+	}
+	r := make([]types.IEntry, 0, len(entries))
+	for _, entry := range entries {
+		r = append(r, d.newEntry(path2.Join(path, utils.PathBase(entry.Path())), entry))
+	}
+	return r, nil
+}
+
+func (d *ThrottleDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return e
+	}
+	return d.root().Delete(ctx, d.realPath(path))
+}
+
+// Upload always uploads to the server, to limit the speed of saving
+func (d *ThrottleDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (d *ThrottleDrive) Space(ctx context.Context, path string) (int64, int64, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return 0, 0, e
+	}
+	sr, ok := d.root().(types.ISpaceReporter)
+	if !ok {
+		return 0, 0, err.NewUnsupportedError()
+	}
+	return sr.Space(ctx, d.realPath(path))
+}
+
+func (d *ThrottleDrive) newEntry(path string, entry types.IEntry) *throttleEntry {
+	return &throttleEntry{d: d, path: strings.TrimPrefix(path, "/"), entry: entry}
+}
+
+type throttleEntry struct {
+	d     *ThrottleDrive
+	path  string
+	entry types.IEntry
+}
+
+func (e *throttleEntry) Path() string {
+	return e.path
+}
+
+func (e *throttleEntry) Type() types.EntryType {
+	return e.entry.Type()
+}
+
+func (e *throttleEntry) Size() int64 {
+	return e.entry.Size()
+}
+
+func (e *throttleEntry) Meta() types.EntryMeta {
+	return e.entry.Meta()
+}
+
+func (e *throttleEntry) ModTime() int64 {
+	return e.entry.ModTime()
+}
+
+func (e *throttleEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *throttleEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *throttleEntry) GetIEntry() types.IEntry {
+	return e.entry
+}
+
+func (e *throttleEntry) content() (types.IContent, error) {
+	c, ok := e.entry.(types.IContent)
+	if !ok || !e.Type().IsFile() {
+		return nil, err.NewNotAllowedError()
+	}
+	return c, nil
+}
+
+func (e *throttleEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return e.GetRangeReader(ctx, 0, -1)
+}
+
+func (e *throttleEntry) GetRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	c, ee := e.content()
+	if ee != nil {
+		return nil, ee
+	}
+	r, ee := drive_util.GetIContentRangeReader(ctx, c, offset, length)
+	if ee != nil {
+		return nil, ee
+	}
+	stream := e.d.down.Open(e.path)
+	return &readCloser{ReadCloser: stream.ReadCloser(ctx, r), stream: stream}, nil
+}
+
+// GetURL is not supported, so that the content is read through the drive, which is limited
+func (e *throttleEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+// readCloser closes the stream of the scheduler with the reader
+type readCloser struct {
+	io.ReadCloser
+	stream *drive_util.FairStream
+}
+
+func (r *readCloser) Close() error {
+	r.stream.Close()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,96 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/drive/memory"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestThrottle(t *testing.T, config drive_util.DriveConfig) (*ThrottleDrive, *memory.MemoryDrive) {
+	root := memory.New(16 * 1024 * 1024)
+	if _, e := root.MakeDir(context.Background(), "data"); e != nil {
+		t.Fatal(e)
+	}
+	d, e := NewThrottleDrive(context.Background(), config, drive_util.DriveUtils{Root: func() types.IDrive { return root }})
+	if e != nil {
+		t.Fatal(e)
+	}
+	return d.(*ThrottleDrive), root
+}
+
+func TestThrottleLimits(t *testing.T) {
+	d, root := newTestThrottle(t, drive_util.DriveConfig{"path": "data", "upload_limit": "100K", "download_limit": "200K"})
+	data := make([]byte, 60*1024)
+
+	start := time.Now()
+	if _, e := d.Save(task.DummyContext(), "a.bin", int64(len(data)), true, bytes.NewReader(data)); e != nil {
+		t.Fatal(e)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("expect the saving limited, but it takes %v", elapsed)
+	}
+	if saved, e := root.Get(context.Background(), "data/a.bin"); e != nil || saved.Size() != int64(len(data)) {
+		t.Errorf("expect the file saved in the directory, %v", e)
+	}
+
+	entry, e := d.Get(context.Background(), "a.bin")
+	if e != nil {
+		t.Fatal(e)
+	}
+	start = time.Now()
+	reader, e := entry.(types.IContent).GetReader(context.Background())
+	if e != nil {
+		t.Fatal(e)
+	}
+	read, e := ioutil.ReadAll(reader)
+	_ = reader.Close()
+	if e != nil || len(read) != len(data) {
+		t.Fatalf("unexpected content: %d, %v", len(read), e)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("expect the reading limited by the download limit, but it takes %v", elapsed)
+	}
+}
+
+func TestThrottleConfig(t *testing.T) {
+	d, _ := newTestThrottle(t, drive_util.DriveConfig{"path": "data"})
+	start := time.Now()
+	if _, e := d.Save(task.DummyContext(), "a.bin", -1, true, bytes.NewReader(make([]byte, 1024*1024))); e != nil {
+		t.Fatal(e)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expect no limit, but it takes %v", elapsed)
+	}
+
+	_, e := NewThrottleDrive(context.Background(), drive_util.DriveConfig{"upload_limit": "fast"},
+		drive_util.DriveUtils{Root: func() types.IDrive { return nil }})
+	if _, ok := e.(err.BadRequestError); !ok {
+		t.Errorf("expect BadRequestError of the invalid limit, but it's %v", e)
+	}
+}
+
+func TestThrottleRecursive(t *testing.T) {
+	d, _ := newTestThrottle(t, drive_util.DriveConfig{"path": "data"})
+	// ctx is accessing the directory through the drive, like the drive mounted in the directory
+	ctx, e := d.guard.TaskContext(task.DummyContext())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := d.Get(ctx, ""); !err.IsNotAllowedError(e) {
+		t.Errorf("expect NotAllowedError of the recursive directory, but it's %v", e)
+	}
+	if _, e := d.Save(ctx, "a.txt", 1, true, strings.NewReader("x")); !err.IsNotAllowedError(e) {
+		t.Errorf("expect NotAllowedError of saving through the drive, but it's %v", e)
+	}
+	if _, e := d.Save(task.DummyContext(), "", 1, true, strings.NewReader("x")); !err.IsNotAllowedError(e) {
+		t.Errorf("expect NotAllowedError of saving to the root, but it's %v", e)
+	}
+}
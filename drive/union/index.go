@@ -0,0 +1,475 @@
+package union
+
+import (
+	"bufio"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	path2 "path"
+	"strings"
+)
+
+// the policies of selecting the branch to create new entries
+const (
+	createFirstWritable = "first_writable"
+	createMostFreeSpace = "most_free_space"
+)
+
+// the policies of resolving the entries of the same path in several branches
+const (
+	conflictFirst  = "first"
+	conflictNewest = "newest"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "union",
+		DisplayName: i18n.T("drive.union.name"),
+		README:      i18n.T("drive.union.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "branches", Label: i18n.T("drive.union.form.branches.label"), Type: "textarea", Required: true, Description: i18n.T("drive.union.form.branches.description")},
+			{Field: "create_policy", Label: i18n.T("drive.union.form.create_policy.label"), Type: "select", Description: i18n.T("drive.union.form.create_policy.description"),
+				Options: []types.FormItemOption{
+					{Name: i18n.T("drive.union.form.create_policy.first_writable"), Value: createFirstWritable},
+					{Name: i18n.T("drive.union.form.create_policy.most_free_space"), Value: createMostFreeSpace},
+				}, DefaultValue: createFirstWritable},
+			{Field: "conflict", Label: i18n.T("drive.union.form.conflict.label"), Type: "select", Description: i18n.T("drive.union.form.conflict.description"),
+				Options: []types.FormItemOption{
+					{Name: i18n.T("drive.union.form.conflict.first"), Value: conflictFirst},
+					{Name: i18n.T("drive.union.form.conflict.newest"), Value: conflictNewest},
+				}, DefaultValue: conflictFirst},
+		},
+		Factory: drive_util.DriveFactory{Create: NewUnionDrive},
+	})
+}
+
+// UnionDrive overlays the directories of other mounts, which are the branches, into one tree.
+// The directories of the same path are merged, and one of the files of the same path is shown by the conflict policy.
+type UnionDrive struct {
+	root     func() types.IDrive
+	branches []string
+
+	createPolicy string
+	conflict     string
+	// guard stops the drive being accessed through itself
+	guard *drive_util.RecursionGuard
+}
+
+func NewUnionDrive(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	if driveUtils.Root == nil {
+		return nil, err.NewUnsupportedError()
+	}
+	d := &UnionDrive{
+		root:         driveUtils.Root,
+		createPolicy: config["create_policy"],
+		conflict:     config["conflict"],
+		guard:        drive_util.NewRecursionGuard(i18n.T("drive.union.recursive")),
+	}
+	scanner := bufio.NewScanner(strings.NewReader(config["branches"]))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			d.branches = append(d.branches, utils.CleanPath(line))
+		}
+	}
+	if len(d.branches) == 0 {
+		return nil, err.NewBadRequestError(i18n.T("drive.union.no_branches"))
+	}
+	switch d.createPolicy {
+	case "":
+		d.createPolicy = createFirstWritable
+	case createFirstWritable, createMostFreeSpace:
+	default:
+		return nil, err.NewBadRequestError(i18n.T("drive.union.invalid_policy", d.createPolicy))
+	}
+	switch d.conflict {
+	case "":
+		d.conflict = conflictFirst
+	case conflictFirst, conflictNewest:
+	default:
+		return nil, err.NewBadRequestError(i18n.T("drive.union.invalid_policy", d.conflict))
+	}
+	return d, nil
+}
+
+func (d *UnionDrive) branchPath(branch int, path string) string {
+	return path2.Join(d.branches[branch], path)
+}
+
+// find gets the entries of the path in the branches, the branches without the path are skipped
+func (d *UnionDrive) find(ctx context.Context, path string) ([]branchEntry, error) {
+	found := make([]branchEntry, 0, len(d.branches))
+	for i := range d.branches {
+		entry, e := d.root().Get(ctx, d.branchPath(i, path))
+		if e != nil {
+			if err.IsNotFoundError(e) {
+				continue
+			}
+			return nil, e
+		}
+		found = append(found, branchEntry{branch: i, entry: entry})
+	}
+	return found, nil
+}
+
+func (d *UnionDrive) get(ctx context.Context, path string) (*unionEntry, error) {
+	found, e := d.find(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if len(found) == 0 {
+		return nil, err.NewNotFoundError()
+	}
+	return &unionEntry{d: d, path: path, b: resolve(found, d.conflict)}, nil
+}
+
+// selectBranch selects the branch to create the path by the create policy,
+// the writable branches which have the parent directory are preferred.
+func (d *UnionDrive) selectBranch(ctx context.Context, path string) (int, error) {
+	parent := utils.PathParent(path)
+	var writable, withParent []int
+	for i := range d.branches {
+		root, e := d.root().Get(ctx, d.branchPath(i, ""))
+		if e != nil {
+			if err.IsNotFoundError(e) {
+				continue
+			}
+			return -1, e
+		}
+		if !root.Type().IsDir() || !root.Meta().CanWrite {
+			continue
+		}
+		writable = append(writable, i)
+		if parent == "" {
+			withParent = append(withParent, i)
+			continue
+		}
+		p, e := d.root().Get(ctx, d.branchPath(i, parent))
+		if e == nil && p.Type().IsDir() {
+			withParent = append(withParent, i)
+		} else if e != nil && !err.IsNotFoundError(e) {
+			return -1, e
+		}
+	}
+	candidates := withParent
+	if len(candidates) == 0 {
+		candidates = writable
+	}
+	if len(candidates) == 0 {
+		return -1, err.NewNotAllowedMessageError(i18n.T("drive.union.no_writable_branch"))
+	}
+	if d.createPolicy == createMostFreeSpace {
+		return d.mostFreeSpace(ctx, candidates), nil
+	}
+	return candidates[0], nil
+}
+
+// mostFreeSpace returns the candidate with the most free space, the first one if the space is unknown
+func (d *UnionDrive) mostFreeSpace(ctx context.Context, candidates []int) int {
+	selected := candidates[0]
+	reporter, ok := d.root().(types.ISpaceReporter)
+	if !ok {
+		return selected
+	}
+	max := int64(-1)
+	for _, i := range candidates {
+		free, _, e := reporter.Space(ctx, d.branchPath(i, ""))
+		if e == nil && free > max {
+			selected, max = i, free
+		}
+	}
+	return selected
+}
+
+// makeParents makes the missing parent directories of the path in the branch
+func (d *UnionDrive) makeParents(ctx context.Context, branch int, path string) error {
+	dirs := utils.PathParentTree(utils.PathParent(path))
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if dirs[i] == "" {
+			continue
+		}
+		p := d.branchPath(branch, dirs[i])
+		if _, e := d.root().Get(ctx, p); e == nil {
+			continue
+		} else if !err.IsNotFoundError(e) {
+			return e
+		}
+		if _, e := d.root().MakeDir(ctx, p); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// targetBranch returns the branch to write the path, which is the branch of the existing entry.
+// The new entry is created in the branch selected by the create policy.
+func (d *UnionDrive) targetBranch(ctx context.Context, path string, override bool) (int, error) {
+	found, e := d.find(ctx, path)
+	if e != nil {
+		return -1, e
+	}
+	if len(found) > 0 {
+		w := resolve(found, d.conflict)
+		if !override || w.entry.Type().IsDir() {
+			return -1, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		if !w.entry.Meta().CanWrite {
+			return -1, err.NewNotAllowedError()
+		}
+		return w.branch, nil
+	}
+	branch, e := d.selectBranch(ctx, path)
+	if e != nil {
+		return -1, e
+	}
+	return branch, d.makeParents(ctx, branch, path)
+}
+
+func (d *UnionDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (d *UnionDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	return d.get(ctx, path)
+}
+
+func (d *UnionDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return nil, err.NewNotAllowedError()
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	branch, e := d.targetBranch(ctx, path, override)
+	if e != nil {
+		return nil, e
+	}
+	if _, e := d.root().Save(ctx, d.branchPath(branch, path), size, override, reader); e != nil {
+		return nil, e
+	}
+	return d.get(ctx, path)
+}
+
+func (d *UnionDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	if dir, e := d.get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	} else if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	branch, e := d.selectBranch(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if e := d.makeParents(ctx, branch, path); e != nil {
+		return nil, e
+	}
+	if _, e := d.root().MakeDir(ctx, d.branchPath(branch, path)); e != nil {
+		return nil, e
+	}
+	return d.get(ctx, path)
+}
+
+func (d *UnionDrive) isSelf(e types.IEntry) bool {
+	if ue, ok := e.(*unionEntry); ok {
+		return ue.d == d
+	}
+	return false
+}
+
+// Copy is not supported, the entries are copied by reading
+func (d *UnionDrive) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+// Move moves the entries of the path in every branch to the target path of the same branch
+func (d *UnionDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, d.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) || utils.IsRootPath(to) {
+		return nil, err.NewUnsupportedError()
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	if _, e := d.get(ctx, to); e == nil {
+		if !override {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		if e := d.delete(ctx, to); e != nil {
+			return nil, e
+		}
+	} else if !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	found, e := d.find(ctx, from.Path())
+	if e != nil {
+		return nil, e
+	}
+	for _, f := range found {
+		if e := d.makeParents(ctx, f.branch, to); e != nil {
+			return nil, e
+		}
+		if _, e := d.root().Move(ctx, f.entry, d.branchPath(f.branch, to), override); e != nil {
+			return nil, e
+		}
+	}
+	return d.get(ctx, to)
+}
+
+func (d *UnionDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	ctx, e := d.guard.Context(ctx)
+	if e != nil {
+		return nil, e
+	}
+	found, e := d.find(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if len(found) == 0 {
+		return nil, err.NewNotFoundError()
+	}
+	if !resolve(found, d.conflict).entry.Type().IsDir() {
+		return nil, err.NewNotAllowedError()
+	}
+	var children []branchEntry
+	for _, f := range found {
+		if !f.entry.Type().IsDir() {
+			continue
+		}
+		entries, e := d.root().List(ctx, d.branchPath(f.branch, path))
+		if e != nil {
+			return nil, e
+		}
+		for _, entry := range entries {
+			children = append(children, branchEntry{branch: f.branch, entry: entry})
+		}
+	}
+	merged := mergeChildren(children, d.conflict)
+	entries := make([]types.IEntry, 0, len(merged))
+	for _, c := range merged {
+		entries = append(entries, &unionEntry{d: d, path: path2.Join(path, c.name()), b: c})
+	}
+	return entries, nil
+}
+
+// delete deletes the entries of the path in every branch, so that the hidden ones are not shown after deleting
+func (d *UnionDrive) delete(ctx types.TaskCtx, path string) error {
+	found, e := d.find(ctx, path)
+	if e != nil {
+		return e
+	}
+	if len(found) == 0 {
+		return err.NewNotFoundError()
+	}
+	for _, f := range found {
+		if e := d.root().Delete(ctx, d.branchPath(f.branch, path)); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (d *UnionDrive) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	ctx, e := d.guard.TaskContext(ctx)
+	if e != nil {
+		return e
+	}
+	return d.delete(ctx, path)
+}
+
+func (d *UnionDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, d, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+// unionEntry is the entry shown of a path, b is the entry in the branch
+type unionEntry struct {
+	d    *UnionDrive
+	path string
+	b    branchEntry
+}
+
+func (e *unionEntry) Path() string {
+	return e.path
+}
+
+func (e *unionEntry) Type() types.EntryType {
+	return e.b.entry.Type()
+}
+
+func (e *unionEntry) Size() int64 {
+	return e.b.entry.Size()
+}
+
+func (e *unionEntry) Meta() types.EntryMeta {
+	if e.Type().IsDir() {
+		return types.EntryMeta{CanRead: true, CanWrite: true}
+	}
+	m := e.b.entry.Meta()
+	return types.EntryMeta{CanRead: m.CanRead, CanWrite: m.CanWrite}
+}
+
+func (e *unionEntry) ModTime() int64 {
+	return e.b.entry.ModTime()
+}
+
+func (e *unionEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *unionEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *unionEntry) content() (types.IContent, error) {
+	c, ok := e.b.entry.(types.IContent)
+	if !ok || !e.Type().IsFile() {
+		return nil, err.NewNotAllowedError()
+	}
+	return c, nil
+}
+
+func (e *unionEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	c, ee := e.content()
+	if ee != nil {
+		return nil, ee
+	}
+	return c.GetReader(ctx)
+}
+
+func (e *unionEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	c, ee := e.content()
+	if ee != nil {
+		return nil, ee
+	}
+	return c.GetURL(ctx)
+}
+
+func (e *unionEntry) GetIEntry() types.IEntry {
+	return e.b.entry
+}
@@ -0,0 +1,262 @@
+package union
+
+import (
+	"bytes"
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// memDrive is the root drive of the tests, which keeps the entries in memory
+type memDrive struct {
+	entries  map[string]*memEntry
+	readonly map[string]bool
+	free     map[string]int64
+}
+
+type memEntry struct {
+	d       *memDrive
+	path    string
+	isDir   bool
+	data    []byte
+	modTime int64
+}
+
+func newMemDrive(paths ...string) *memDrive {
+	m := &memDrive{entries: map[string]*memEntry{}, readonly: map[string]bool{}, free: map[string]int64{}}
+	m.entries[""] = &memEntry{d: m, isDir: true}
+	for _, p := range paths {
+		if strings.HasSuffix(p, "/") {
+			_, _ = m.MakeDir(context.Background(), strings.TrimSuffix(p, "/"))
+		} else {
+			_, _ = m.Save(task.DummyContext(), p, -1, true, strings.NewReader(p))
+		}
+	}
+	return m
+}
+
+func (m *memDrive) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (m *memDrive) Get(_ context.Context, path string) (types.IEntry, error) {
+	if e, ok := m.entries[path]; ok {
+		return e, nil
+	}
+	return nil, err.NewNotFoundError()
+}
+
+func (m *memDrive) Save(_ types.TaskCtx, path string, _ int64, _ bool, reader io.Reader) (types.IEntry, error) {
+	if _, ok := m.entries[utils.PathParent(path)]; !ok {
+		return nil, err.NewNotFoundError()
+	}
+	data, _ := ioutil.ReadAll(reader)
+	e := &memEntry{d: m, path: path, data: data, modTime: int64(len(m.entries))}
+	m.entries[path] = e
+	return e, nil
+}
+
+func (m *memDrive) MakeDir(_ context.Context, path string) (types.IEntry, error) {
+	for _, p := range utils.PathParentTree(path) {
+		if _, ok := m.entries[p]; !ok {
+			m.entries[p] = &memEntry{d: m, path: p, isDir: true}
+		}
+	}
+	return m.entries[path], nil
+}
+
+func (m *memDrive) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (m *memDrive) Move(_ types.TaskCtx, from types.IEntry, to string, _ bool) (types.IEntry, error) {
+	for p, e := range m.entries {
+		if p == from.Path() || strings.HasPrefix(p, from.Path()+"/") {
+			delete(m.entries, p)
+			e.path = to + p[len(from.Path()):]
+			m.entries[e.path] = e
+		}
+	}
+	return m.entries[to], nil
+}
+
+func (m *memDrive) List(_ context.Context, path string) ([]types.IEntry, error) {
+	var names []string
+	for p := range m.entries {
+		if p != "" && utils.PathParent(p) == path {
+			names = append(names, p)
+		}
+	}
+	sort.Strings(names)
+	entries := make([]types.IEntry, 0, len(names))
+	for _, p := range names {
+		entries = append(entries, m.entries[p])
+	}
+	return entries, nil
+}
+
+func (m *memDrive) Delete(_ types.TaskCtx, path string) error {
+	for p := range m.entries {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(m.entries, p)
+		}
+	}
+	return nil
+}
+
+func (m *memDrive) Upload(context.Context, string, int64, bool, types.SM) (*types.DriveUploadConfig, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (m *memDrive) Space(_ context.Context, path string) (int64, int64, error) {
+	return m.free[path], 0, nil
+}
+
+func (e *memEntry) Path() string { return e.path }
+func (e *memEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+func (e *memEntry) Size() int64 { return int64(len(e.data)) }
+func (e *memEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: !e.d.readonly[strings.SplitN(e.path, "/", 2)[0]]}
+}
+func (e *memEntry) ModTime() int64      { return e.modTime }
+func (e *memEntry) Drive() types.IDrive { return e.d }
+func (e *memEntry) Name() string        { return utils.PathBase(e.path) }
+func (e *memEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+func (e *memEntry) GetReader(context.Context) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(e.data)), nil
+}
+
+func newTestUnion(t *testing.T, root types.IDrive, config drive_util.DriveConfig) *UnionDrive {
+	d, e := NewUnionDrive(context.Background(), config, drive_util.DriveUtils{Root: func() types.IDrive { return root }})
+	if e != nil {
+		t.Fatal(e)
+	}
+	return d.(*UnionDrive)
+}
+
+func names(entries []types.IEntry) string {
+	r := make([]string, 0, len(entries))
+	for _, e := range entries {
+		r = append(r, e.Path())
+	}
+	return strings.Join(r, ",")
+}
+
+func TestUnionList(t *testing.T) {
+	root := newMemDrive("a/", "b/", "a/dir/", "b/dir/", "a/x.txt", "a/dir/1.txt", "b/dir/2.txt", "b/x.txt", "b/y.txt")
+	d := newTestUnion(t, root, drive_util.DriveConfig{"branches": "a\n\n b \n"})
+	ctx := context.Background()
+
+	entries, e := d.List(ctx, "")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if names(entries) != "dir,x.txt,y.txt" {
+		t.Errorf("unexpected entries: %s", names(entries))
+	}
+	if x := entries[1].(*unionEntry).b; x.branch != 0 {
+		t.Errorf("expect the file of the first branch, but it's %d", x.branch)
+	}
+	entries, e = d.List(ctx, "dir")
+	if e != nil || names(entries) != "dir/1.txt,dir/2.txt" {
+		t.Errorf("expect the directories merged: %s, %v", names(entries), e)
+	}
+
+	d.conflict = conflictNewest
+	x, e := d.Get(ctx, "x.txt")
+	if e != nil || x.(*unionEntry).b.branch != 1 {
+		t.Errorf("expect the newest file: %v, %v", x, e)
+	}
+
+	if _, e := d.Get(ctx, "z.txt"); !err.IsNotFoundError(e) {
+		t.Errorf("expect NotFoundError, but it's %v", e)
+	}
+	if _, e := d.List(ctx, "x.txt"); e == nil {
+		t.Error("expect error of listing a file")
+	}
+
+	if e := d.Delete(task.DummyContext(), "x.txt"); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := d.Get(ctx, "x.txt"); !err.IsNotFoundError(e) {
+		t.Errorf("expect the file deleted in all branches, but it's %v", e)
+	}
+}
+
+func TestUnionCreate(t *testing.T) {
+	root := newMemDrive("a/", "b/", "c/", "c/sub/", "a/x.txt")
+	root.readonly["a"] = true
+	root.free["b"] = 10
+	root.free["c"] = 20
+	d := newTestUnion(t, root, drive_util.DriveConfig{"branches": "a\nb\nc"})
+	ctx := task.DummyContext()
+
+	if _, e := d.Save(ctx, "x.txt", 1, true, strings.NewReader("x")); !err.IsNotAllowedError(e) {
+		t.Errorf("expect NotAllowedError of the read-only branch, but it's %v", e)
+	}
+	if _, e := d.Save(ctx, "new/y.txt", 1, false, strings.NewReader("y")); e != nil {
+		t.Fatal(e)
+	}
+	if _, ok := root.entries["b/new/y.txt"]; !ok {
+		t.Error("expect the file created in the first writable branch")
+	}
+	if _, e := d.Save(ctx, "sub/z.txt", 1, false, strings.NewReader("z")); e != nil {
+		t.Fatal(e)
+	}
+	if _, ok := root.entries["c/sub/z.txt"]; !ok {
+		t.Error("expect the file created in the branch having the parent")
+	}
+
+	d.createPolicy = createMostFreeSpace
+	if _, e := d.MakeDir(ctx, "m"); e != nil {
+		t.Fatal(e)
+	}
+	if _, ok := root.entries["c/m"]; !ok {
+		t.Error("expect the directory created in the branch with the most free space")
+	}
+
+	entry, e := d.Get(ctx, "new/y.txt")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := d.Move(ctx, entry, "m/y.txt", false); e != nil {
+		t.Fatal(e)
+	}
+	if _, ok := root.entries["b/m/y.txt"]; !ok {
+		t.Error("expect the file moved in its branch")
+	}
+}
+
+func TestUnionRecursive(t *testing.T) {
+	root := &recursiveRoot{}
+	d := newTestUnion(t, root, drive_util.DriveConfig{"branches": "self"})
+	root.d = d
+	if _, e := d.Get(context.Background(), ""); !err.IsNotAllowedError(e) {
+		t.Errorf("expect NotAllowedError of the recursive branch, but it's %v", e)
+	}
+}
+
+// recursiveRoot routes the requests to the union drive itself
+type recursiveRoot struct {
+	memDrive
+	d *UnionDrive
+}
+
+func (r *recursiveRoot) Get(ctx context.Context, path string) (types.IEntry, error) {
+	return r.d.Get(ctx, strings.TrimPrefix(strings.TrimPrefix(path, "self"), "/"))
+}
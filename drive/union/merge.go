@@ -0,0 +1,49 @@
+package union
+
+import (
+	"go-drive/common/types"
+	"go-drive/common/utils"
+)
+
+// branchEntry is an entry found in a branch
+type branchEntry struct {
+	branch int
+	entry  types.IEntry
+}
+
+func (b branchEntry) name() string {
+	return utils.PathBase(b.entry.Path())
+}
+
+// resolve picks the entry shown of the entries of the same path, which are in the order of the branches.
+// The first one wins, or the one modified most recently with the conflict policy 'newest'.
+func resolve(found []branchEntry, conflict string) branchEntry {
+	w := found[0]
+	if conflict == conflictNewest {
+		for _, f := range found[1:] {
+			if f.entry.ModTime() > w.entry.ModTime() {
+				w = f
+			}
+		}
+	}
+	return w
+}
+
+// mergeChildren groups the children of the branches by name, and resolves each group.
+// The names are in the order they are first found.
+func mergeChildren(children []branchEntry, conflict string) []branchEntry {
+	groups := make(map[string][]branchEntry)
+	names := make([]string, 0, len(children))
+	for _, c := range children {
+		name := c.name()
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], c)
+	}
+	merged := make([]branchEntry, 0, len(names))
+	for _, name := range names {
+		merged = append(merged, resolve(groups[name], conflict))
+	}
+	return merged
+}
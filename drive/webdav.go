@@ -104,6 +104,9 @@ func (w *WebDAVDrive) Get(ctx context.Context, path string) (types.IEntry, error
 	if e := resp.XML(&res); e != nil {
 		return nil, e
 	}
+	if len(res.Response) == 0 {
+		return nil, err.NewNotFoundError()
+	}
 	entry := w.newEntry(res.Response[0])
 	_ = w.cache.PutEntry(entry, w.cacheTTL)
 	return entry, nil
@@ -0,0 +1,72 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"go-drive/common/drive_util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const largeFileSize = 8 * 1024 * 1024 * 1024
+
+func newTestWebDAVServer(t *testing.T, methods *sync.Map) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := methods.LoadOrStore(r.Method, new(int))
+		*n.(*int)++
+		if r.Method != "PROPFIND" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		sb := strings.Builder{}
+		sb.WriteString(`<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:">`)
+		sb.WriteString(`<d:response><d:href>/dav/</d:href><d:propstat><d:prop>` +
+			`<d:resourcetype><d:collection/></d:resourcetype></d:prop></d:propstat></d:response>`)
+		if r.Header.Get("Depth") == "1" {
+			for i := 0; i < 3; i++ {
+				sb.WriteString(fmt.Sprintf(`<d:response><d:href>/dav/file%d.bin</d:href><d:propstat><d:prop>`+
+					`<d:getcontentlength>%d</d:getcontentlength>`+
+					`<d:getlastmodified>Mon, 12 Oct 2020 08:00:00 GMT</d:getlastmodified>`+
+					`<d:resourcetype/></d:prop></d:propstat></d:response>`, i, int64(largeFileSize)))
+			}
+		}
+		sb.WriteString(`</d:multistatus>`)
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusMultiStatus)
+		_, _ = w.Write([]byte(sb.String()))
+	}))
+}
+
+func TestWebDAVListDoesNotReadContent(t *testing.T) {
+	methods := &sync.Map{}
+	server := newTestWebDAVServer(t, methods)
+	defer server.Close()
+
+	d, e := NewWebDAVDrive(context.Background(),
+		drive_util.DriveConfig{"url": server.URL + "/dav"}, drive_util.DriveUtils{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	entries, e := d.List(context.Background(), "")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expect 3 entries, but it's %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Size() != largeFileSize {
+			t.Errorf("'%s': expect size %d, but it's %d", entry.Path(), int64(largeFileSize), entry.Size())
+		}
+		if entry.ModTime() <= 0 {
+			t.Errorf("'%s': modTime is not populated", entry.Path())
+		}
+	}
+	if _, ok := methods.Load(http.MethodGet); ok {
+		t.Errorf("GET request should not be issued when listing")
+	}
+}
@@ -0,0 +1,51 @@
+package yandex
+
+import "fmt"
+
+const apiURL = "https://cloud-api.yandex.net/v1/disk"
+
+// resource is a file or a directory
+type resource struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Size       int64  `json:"size"`
+	Modified   string `json:"modified"`
+	Md5        string `json:"md5"`
+	ResourceId string `json:"resource_id"`
+
+	Embedded *resourceList `json:"_embedded"`
+}
+
+type resourceList struct {
+	Items  []resource `json:"items"`
+	Total  int        `json:"total"`
+	Limit  int        `json:"limit"`
+	Offset int        `json:"offset"`
+}
+
+// link is the URL of the download, the upload, or the status of an operation
+type link struct {
+	Href   string `json:"href"`
+	Method string `json:"method"`
+}
+
+type operationStatus struct {
+	Status string `json:"status"`
+}
+
+type diskInfo struct {
+	User struct {
+		Login       string `json:"login"`
+		DisplayName string `json:"display_name"`
+	} `json:"user"`
+}
+
+type apiError struct {
+	Code        string `json:"error"`
+	Description string `json:"description"`
+	status      int
+}
+
+func (a apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", a.status, a.Code, a.Description)
+}
@@ -0,0 +1,375 @@
+package yandex
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"net/url"
+	path2 "path"
+	"strconv"
+	"time"
+)
+
+func init() {
+	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
+		Type:        "yandex",
+		DisplayName: i18n.T("drive.yandex.name"),
+		README:      i18n.T("drive.yandex.readme"),
+		ConfigForm: []types.FormItem{
+			{Field: "client_id", Label: i18n.T("drive.yandex.form.client_id.label"), Type: "text", Required: true},
+			{Field: "client_secret", Label: i18n.T("drive.yandex.form.client_secret.label"), Type: "password", Required: true},
+			{Field: "proxy_download", Label: i18n.T("drive.yandex.form.proxy_out.label"), Type: "checkbox", Description: i18n.T("drive.yandex.form.proxy_out.description")},
+			{Field: "cache_ttl", Label: i18n.T("drive.yandex.form.cache_ttl.label"), Type: "text", Description: i18n.T("drive.yandex.form.cache_ttl.description")},
+		},
+		Factory: drive_util.DriveFactory{Create: NewYandex, InitConfig: InitConfig, Init: Init},
+	})
+}
+
+// listLimit is the limit of the embedded items of the resources API, the items are paged by offset
+const listLimit = 1000
+
+type Yandex struct {
+	// c calls the API, content uploads the files to the upload links
+	c       *req.Client
+	content *req.Client
+
+	cacheTTL time.Duration
+	cache    drive_util.DriveCache
+
+	downloadProxy bool
+}
+
+func NewYandex(_ context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (types.IDrive, error) {
+	resp, e := drive_util.OAuthGet(*oauthReq(driveUtils.Config), config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+
+	cacheTtl, e := time.ParseDuration(config["cache_ttl"])
+	if e != nil {
+		cacheTtl = -1
+	}
+	y := &Yandex{
+		cacheTTL:      cacheTtl,
+		downloadProxy: config["proxy_download"] != "",
+	}
+	if cacheTtl <= 0 {
+		y.cache = drive_util.DummyCache()
+	} else {
+		y.cache = driveUtils.CreateCache(y.deserializeEntry, nil)
+	}
+
+	if y.c, e = newApiClient(resp); e != nil {
+		return nil, e
+	}
+	if y.content, e = req.NewClient("", nil, ifApiCallError, nil); e != nil {
+		return nil, e
+	}
+	return y, nil
+}
+
+func (y *Yandex) Meta(context.Context) types.DriveMeta {
+	return types.DriveMeta{CanWrite: true}
+}
+
+func (y *Yandex) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if utils.IsRootPath(path) {
+		return &yandexEntry{d: y, path: path, isDir: true, modTime: -1}, nil
+	}
+	if cached, _ := y.cache.GetEntry(path); cached != nil {
+		return cached, nil
+	}
+	resp, e := y.c.Get(ctx, "/resources?"+url.Values{
+		"path": {apiPath(path)}, "limit": {"0"},
+	}.Encode(), nil)
+	if e != nil {
+		return nil, e
+	}
+	r := resource{}
+	if e := resp.Json(&r); e != nil {
+		return nil, e
+	}
+	entry := y.newEntry(utils.PathParent(path), r)
+	_ = y.cache.PutEntry(entry, y.cacheTTL)
+	return entry, nil
+}
+
+// Save requests the upload link of the file, and uploads the content to it
+func (y *Yandex) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, y, path); e != nil {
+			return nil, e
+		}
+	}
+	resp, e := y.c.Get(ctx, "/resources/upload?"+url.Values{
+		"path": {apiPath(path)}, "overwrite": {strconv.FormatBool(override)},
+	}.Encode(), nil)
+	if e != nil {
+		return nil, e
+	}
+	l := link{}
+	if e := resp.Json(&l); e != nil {
+		return nil, e
+	}
+	ctx.Total(size, true)
+	resp, e = y.content.Request(ctx, "PUT", l.Href, nil,
+		req.NewReaderBody(drive_util.ProgressReader(reader, ctx), size))
+	_ = y.cache.Evict(path, false)
+	_ = y.cache.Evict(utils.PathParent(path), false)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	return y.Get(ctx, path)
+}
+
+func (y *Yandex) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if dir, e := y.Get(ctx, path); e == nil {
+		if !dir.Type().IsDir() {
+			return nil, err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+		}
+		return dir, nil
+	}
+	resp, e := y.c.Request(ctx, "PUT", "/resources?"+url.Values{"path": {apiPath(path)}}.Encode(), nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	_ = resp.Dispose()
+	_ = y.cache.Evict(utils.PathParent(path), false)
+	return y.Get(ctx, path)
+}
+
+func (y *Yandex) isSelf(e types.IEntry) bool {
+	if ye, ok := e.(*yandexEntry); ok {
+		return ye.d == y
+	}
+	return false
+}
+
+// startOperation calls the API which may run asynchronously, the returned operation polls the status of it
+func (y *Yandex) startOperation(ctx context.Context, method, requestUrl, to string) (*operation, error) {
+	resp, e := y.c.Request(ctx, method, requestUrl, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	op := &operation{y: y, to: to}
+	if resp.Status() == 202 {
+		l := link{}
+		if e := resp.Json(&l); e != nil {
+			return nil, e
+		}
+		op.href = l.Href
+	} else {
+		_ = resp.Dispose()
+	}
+	return op, nil
+}
+
+func (y *Yandex) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	return drive_util.NativeCopy(ctx, y, from, to, override)
+}
+
+// CopyAsync starts copying on Yandex Disk, directories are copied asynchronously
+func (y *Yandex) CopyAsync(ctx context.Context, from types.IEntry, to string, override bool) (types.IAsyncOp, error) {
+	from = drive_util.GetIEntry(from, y.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, y, to); e != nil {
+			return nil, e
+		}
+	}
+	return y.startOperation(ctx, "POST", "/resources/copy?"+url.Values{
+		"from": {apiPath(from.Path())}, "path": {apiPath(to)}, "overwrite": {strconv.FormatBool(override)},
+	}.Encode(), to)
+}
+
+func (y *Yandex) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	from = drive_util.GetIEntry(from, y.isSelf)
+	if from == nil || utils.IsRootPath(from.Path()) {
+		return nil, err.NewUnsupportedError()
+	}
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, y, to); e != nil {
+			return nil, e
+		}
+	}
+	op, e := y.startOperation(ctx, "POST", "/resources/move?"+url.Values{
+		"from": {apiPath(from.Path())}, "path": {apiPath(to)}, "overwrite": {strconv.FormatBool(override)},
+	}.Encode(), to)
+	if e != nil {
+		return nil, e
+	}
+	ctx.Total(from.Size(), false)
+	entry, e := drive_util.WaitAsyncOp(ctx, op, from.Size())
+	_ = y.cache.Evict(from.Path(), true)
+	_ = y.cache.Evict(utils.PathParent(from.Path()), false)
+	return entry, e
+}
+
+// List lists the directory page by page
+func (y *Yandex) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if cached, _ := y.cache.GetChildren(path); cached != nil {
+		return cached, nil
+	}
+	entries := make([]types.IEntry, 0)
+	for offset := 0; ; offset += listLimit {
+		resp, e := y.c.Get(ctx, "/resources?"+url.Values{
+			"path":   {apiPath(path)},
+			"limit":  {strconv.Itoa(listLimit)},
+			"offset": {strconv.Itoa(offset)},
+		}.Encode(), nil)
+		if e != nil {
+			return nil, e
+		}
+		r := resource{}
+		if e := resp.Json(&r); e != nil {
+			return nil, e
+		}
+		if r.Type != "dir" || r.Embedded == nil {
+			return nil, err.NewNotAllowedError()
+		}
+		for _, item := range r.Embedded.Items {
+			entries = append(entries, y.newEntry(path, item))
+		}
+		if len(r.Embedded.Items) < listLimit || offset+listLimit >= r.Embedded.Total {
+			break
+		}
+	}
+	_ = y.cache.PutChildren(path, entries, y.cacheTTL)
+	return entries, nil
+}
+
+// Delete deletes the file or the directory permanently, deleting directories may be asynchronous
+func (y *Yandex) Delete(ctx types.TaskCtx, path string) error {
+	if utils.IsRootPath(path) {
+		return err.NewNotAllowedError()
+	}
+	op, e := y.startOperation(ctx, "DELETE", "/resources?"+url.Values{
+		"path": {apiPath(path)}, "permanently": {"true"},
+	}.Encode(), "")
+	if e != nil {
+		return e
+	}
+	_, e = drive_util.WaitAsyncOp(ctx, op, 0)
+	_ = y.cache.Evict(path, true)
+	_ = y.cache.Evict(utils.PathParent(path), false)
+	return e
+}
+
+func (y *Yandex) Upload(ctx context.Context, path string, size int64,
+	override bool, _ types.SM) (*types.DriveUploadConfig, error) {
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(ctx, y, path); e != nil {
+			return nil, e
+		}
+	}
+	return types.UseLocalProvider(size), nil
+}
+
+func (y *Yandex) newEntry(parent string, r resource) *yandexEntry {
+	modTime := int64(-1)
+	if t, e := time.Parse(time.RFC3339, r.Modified); e == nil {
+		modTime = utils.Millisecond(t)
+	}
+	return &yandexEntry{
+		d:       y,
+		id:      r.ResourceId,
+		path:    path2.Join(parent, r.Name),
+		isDir:   r.Type == "dir",
+		size:    r.Size,
+		modTime: modTime,
+		md5:     r.Md5,
+	}
+}
+
+type yandexEntry struct {
+	d       *Yandex
+	id      string
+	path    string
+	isDir   bool
+	size    int64
+	modTime int64
+	md5     string
+}
+
+func (e *yandexEntry) Path() string {
+	return e.path
+}
+
+func (e *yandexEntry) Type() types.EntryType {
+	if e.isDir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (e *yandexEntry) Size() int64 {
+	if e.isDir {
+		return -1
+	}
+	return e.size
+}
+
+func (e *yandexEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{CanRead: true, CanWrite: true}
+}
+
+func (e *yandexEntry) ModTime() int64 {
+	return e.modTime
+}
+
+func (e *yandexEntry) Drive() types.IDrive {
+	return e.d
+}
+
+func (e *yandexEntry) Name() string {
+	return utils.PathBase(e.path)
+}
+
+func (e *yandexEntry) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	u, ee := e.GetURL(ctx)
+	if ee != nil {
+		return nil, ee
+	}
+	return drive_util.GetURL(ctx, u.URL, nil)
+}
+
+// GetURL returns the download link of the file, so the downloads are redirected to Yandex Disk
+func (e *yandexEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if e.isDir {
+		return nil, err.NewNotAllowedError()
+	}
+	resp, ee := e.d.c.Get(ctx, "/resources/download?"+url.Values{"path": {apiPath(e.path)}}.Encode(), nil)
+	if ee != nil {
+		return nil, ee
+	}
+	l := link{}
+	if ee := resp.Json(&l); ee != nil {
+		return nil, ee
+	}
+	return &types.ContentURL{URL: l.Href, Proxy: e.d.downloadProxy}, nil
+}
+
+func (e *yandexEntry) EntryData() types.SM {
+	return types.SM{"id": e.id, "md5": e.md5}
+}
+
+func (e *yandexEntry) StableID() string {
+	return e.id
+}
+
+func (e *yandexEntry) ContentHash(context.Context) (string, string, error) {
+	if e.md5 == "" {
+		return "", "", err.NewUnsupportedError()
+	}
+	return "md5", e.md5, nil
+}
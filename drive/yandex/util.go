@@ -0,0 +1,151 @@
+package yandex
+
+import (
+	"context"
+	"errors"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"golang.org/x/oauth2"
+	"net/http"
+	"strings"
+)
+
+func oauthReq(c common.Config) *drive_util.OAuthRequest {
+	return &drive_util.OAuthRequest{
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   "https://oauth.yandex.com/authorize",
+			TokenURL:  "https://oauth.yandex.com/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+		RedirectURL: c.OAuthRedirectURI,
+		Text:        i18n.T("drive.yandex.oauth_text"),
+	}
+}
+
+// newApiClient returns the client of the API, the token is sent in the form of 'OAuth <token>'
+func newApiClient(resp *drive_util.OAuthResponse) (*req.Client, error) {
+	ts := resp.TokenSource(nil)
+	return req.NewClient(apiURL, func(r *http.Request) error {
+		t, e := ts.Token()
+		if e != nil {
+			return e
+		}
+		r.Header.Set("Authorization", "OAuth "+t.AccessToken)
+		return nil
+	}, ifApiCallError, nil)
+}
+
+func InitConfig(ctx context.Context, config drive_util.DriveConfig,
+	driveUtils drive_util.DriveUtils) (*drive_util.DriveInitConfig, error) {
+	initConfig, resp, e := drive_util.OAuthInitConfig(*oauthReq(driveUtils.Config), config, driveUtils.Data)
+	if e != nil {
+		return nil, e
+	}
+	if resp == nil || resp.Token == nil {
+		return initConfig, nil
+	}
+	c, e := newApiClient(resp)
+	if e != nil {
+		return nil, e
+	}
+
+	// get user
+	disk := diskInfo{}
+	r, e := c.Get(ctx, "/", nil)
+	if e == nil {
+		e = r.Json(&disk)
+	}
+	initConfig.Configured = e == nil
+	if e == nil {
+		initConfig.OAuth.Principal = disk.User.DisplayName + " <" + disk.User.Login + ">"
+	}
+	return initConfig, nil
+}
+
+func Init(ctx context.Context, data types.SM, config drive_util.DriveConfig, utils drive_util.DriveUtils) error {
+	_, e := drive_util.OAuthInit(ctx, *oauthReq(utils.Config), data, config, utils.Data)
+	return e
+}
+
+// apiPath returns the path in the API, which is prefixed with 'disk:/'
+func apiPath(path string) string {
+	return "disk:/" + path
+}
+
+func ifApiCallError(resp req.Response) error {
+	if resp.Status() >= 200 && resp.Status() < 300 {
+		return nil
+	}
+	ae := apiError{status: resp.Status()}
+	if e := resp.Json(&ae); e != nil {
+		ae.Description = resp.Response().Status
+	}
+	switch {
+	case resp.Status() == http.StatusNotFound:
+		return err.NewNotFoundError()
+	case resp.Status() == http.StatusConflict && ae.Code == "DiskResourceAlreadyExistsError":
+		return err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+	case resp.Status() == http.StatusUnauthorized:
+		return err.NewUnauthorizedError(ae.Description)
+	}
+	return err.NewRemoteApiError(500, i18n.T("drive.yandex.remote_error", ae.Error()))
+}
+
+func (y *Yandex) deserializeEntry(dat string) (types.IEntry, error) {
+	ec, e := drive_util.DeserializeEntry(dat)
+	if e != nil {
+		return nil, e
+	}
+	ed := ec.Data
+	if ed == nil || (ed["id"] == "" && !utils.IsRootPath(ec.Path)) {
+		return nil, errors.New("invalid cache")
+	}
+	return &yandexEntry{
+		d: y, id: ed["id"], md5: ed["md5"],
+		path: ec.Path, size: ec.Size, modTime: ec.ModTime, isDir: ec.Type.IsDir(),
+	}, nil
+}
+
+// operation is the handle of an operation running on Yandex Disk
+type operation struct {
+	y    *Yandex
+	to   string
+	href string
+}
+
+func (o *operation) Poll(ctx context.Context) (bool, float64, error) {
+	if o.href == "" {
+		return true, 1, nil
+	}
+	// the href is the absolute URL of the API
+	resp, e := o.y.c.Get(ctx, strings.TrimPrefix(o.href, apiURL), nil)
+	if e != nil {
+		return false, 0, e
+	}
+	s := operationStatus{}
+	if e := resp.Json(&s); e != nil {
+		return false, 0, e
+	}
+	switch s.Status {
+	case "success":
+		o.href = ""
+		return true, 1, nil
+	case "failed":
+		return false, 0, err.NewRemoteApiError(500, i18n.T("drive.yandex.operation_failed"))
+	}
+	return false, 0, nil
+}
+
+func (o *operation) Result(ctx context.Context) (types.IEntry, error) {
+	if o.to == "" {
+		return nil, nil
+	}
+	_ = o.y.cache.Evict(o.to, true)
+	_ = o.y.cache.Evict(utils.PathParent(o.to), false)
+	return o.y.Get(ctx, o.to)
+}
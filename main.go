@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"github.com/gin-gonic/gin"
 	"go-drive/common"
 	"go-drive/common/registry"
+	"go-drive/server"
 	"log"
 	"math/rand"
 	"net/http"
@@ -14,13 +16,28 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+// App is the servers of go-drive, the optional ones are nil if they are not enabled
+type App struct {
+	Engine *gin.Engine
+	// FTP serves the drives over FTP(S), for the devices which can only push files over FTP
+	FTP *server.FTPServer
+	// SFTP serves the drives over SFTP, authenticated by the passwords or the public keys of the users
+	SFTP *server.SFTPServer
+}
+
 func main() {
 	ch := registry.NewComponentHolder()
 
-	engine, e := Initialize(context.Background(), ch)
+	app, e := Initialize(context.Background(), ch)
 	if e != nil {
 		log.Fatalln(e)
 	}
 
-	log.Fatalln(http.ListenAndServe(ch.Get("config").(common.Config).Listen, engine))
+	if app.FTP != nil {
+		go app.FTP.Serve()
+	}
+	if app.SFTP != nil {
+		go app.SFTP.Serve()
+	}
+	log.Fatalln(http.ListenAndServe(ch.Get("config").(common.Config).Listen, app.Engine))
 }
@@ -167,6 +167,21 @@ func InitAdminRoutes(r gin.IRouter,
 		SetResult(c, drive_util.GetRegisteredDrives())
 	})
 
+	// get the next step of the guided drive configuration
+	r.POST("/drive-factory/:type/config-step", func(c *gin.Context) {
+		config := make(types.SM)
+		if e := c.Bind(&config); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		step, e := rootDrive.DriveConfigStep(c.Request.Context(), c.Param("type"), c.Query("name"), config)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		SetResult(c, step)
+	})
+
 	// get drives
 	r.GET("/drives", func(c *gin.Context) {
 		drives, e := driveDAO.GetDrives()
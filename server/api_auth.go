@@ -6,7 +6,6 @@ import (
 	"go-drive/common/i18n"
 	"go-drive/common/types"
 	"go-drive/storage"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -49,15 +48,11 @@ func (a *authRoute) login(c *gin.Context) {
 		_ = c.Error(e)
 		return
 	}
-	getUser, e := a.userDAO.GetUser(user.Username)
+	getUser, e := a.userDAO.Authenticate(user.Username, user.Password)
 	if e != nil {
 		_ = c.Error(e)
 		return
 	}
-	if e := bcrypt.CompareHashAndPassword([]byte(getUser.Password), []byte(user.Password)); e != nil {
-		_ = c.Error(err.NewBadRequestError(i18n.T("api.auth.invalid_username_or_password")))
-		return
-	}
 	e = UpdateSessionUser(c, a.tokenStore, getUser)
 	if e != nil {
 		_ = c.Error(e)
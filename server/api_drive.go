@@ -12,8 +12,12 @@ import (
 	"go-drive/common/utils"
 	"go-drive/drive"
 	"go-drive/storage"
+	"io"
+	"log"
+	"mime"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -24,10 +28,18 @@ func InitDriveRoutes(router gin.IRouter,
 	rootDrive *drive.RootDrive,
 	permissionDAO *storage.PathPermissionDAO,
 	thumbnail *Thumbnail,
+	hls *HLS,
+	mediaInfo *MediaInfo,
+	search *Search,
 	signer *utils.Signer,
 	chunkUploader *ChunkUploader,
+	deleteCheckpoints *DeleteCheckpoints,
 	runner task.Runner,
-	tokenStore types.TokenStore) {
+	tokenStore types.TokenStore,
+	idempotencyStore types.IdempotencyStore,
+	auditSink types.AuditSink,
+	userDAO *storage.UserDAO,
+	shareDAO *storage.ShareDAO) {
 
 	dr := driveRoute{
 		config:        config,
@@ -35,41 +47,105 @@ func InitDriveRoutes(router gin.IRouter,
 		permissionDAO: permissionDAO,
 		chunkUploader: chunkUploader,
 		thumbnail:     thumbnail,
+		deleteCP:      deleteCheckpoints,
 		runner:        runner,
 		signer:        signer,
+		auditSink:     auditSink,
 	}
 
 	// get file content
 	router.HEAD("/content/*path", dr.getContent)
 	router.GET("/content/*path", dr.getContent)
 	router.GET("/thumbnail/*path", dr.getThumbnail)
+	// image resized on demand by ?w=&h=&q=&format=
+	router.GET("/image/*path", dr.getImage)
+	// get content of an archive member
+	router.GET("/archive-content/*path", dr.getArchiveMember)
+	// recently modified files as RSS/Atom feed
+	router.GET("/feed/*path", dr.getFeed)
+
+	// drives as a WebDAV endpoint, authenticated by HTTP basic authentication
+	if config.WebDAVPrefix != "" {
+		initWebDAVRoutes(router, &dr, config.WebDAVPrefix, userDAO)
+	}
 
 	r := router.Group("/", Auth(tokenStore))
+	idempotent := Idempotent(idempotencyStore)
 
 	// list entries/drives
 	r.GET("/entries/*path", dr.list)
 	// get entry info
 	r.GET("/entry/*path", dr.get)
+	// get entry info by its stable id
+	r.GET("/resolve-id/:id", dr.resolveID)
+	// read the last lines of a file, and follow it
+	r.GET("/tail/*path", dr.tail)
+	// find entries by prop
+	r.GET("/find/*path", dr.findByProp)
+	// find duplicated files
+	r.GET("/duplicates/*path", dr.findDuplicates)
+	// find entries modified after ?since, in milliseconds
+	r.GET("/changed/*path", dr.listChangedSince)
+	// list members of an archive
+	r.GET("/archive/*path", dr.listArchive)
+	// download a directory as an archive made on the fly, ?format=tar.gz or ?store=1 to not compress the zip
+	r.GET("/zip/*path", dr.downloadZip)
+	// download the selected entries as an archive, ?path=a&path=b/c&format=tar.gz
+	r.GET("/download", dr.downloadSelection)
 	// mkdir
-	r.POST("/mkdir/*path", dr.makeDir)
+	r.POST("/mkdir/*path", idempotent, dr.makeDir)
 	// copy file
-	r.POST("/copy", dr.copyEntry)
-	// move file
-	r.POST("/move", dr.move)
-	// deleteEntry entry
-	r.DELETE("/entry/*path", dr.deleteEntry)
+	r.POST("/copy", idempotent, dr.copyEntry)
+	// move file, the moves across devices or drives are verified by the content hashes with ?verify=hash
+	r.POST("/move", idempotent, dr.move)
+	// replace a directory with a staged one
+	r.POST("/publish", idempotent, dr.publish)
+	// create a public link of the entry on the backend, with optional ?password and ?expires_at in milliseconds
+	r.POST("/share/*path", idempotent, dr.share)
+	// deleteEntry entry, ?checkpoint=1 to delete resumably, ?resume=<task id> to resume it
+	r.DELETE("/entry/*path", idempotent, dr.deleteEntry)
 	// get upload config
 	r.POST("/upload/*path", dr.upload)
 	// write file
-	r.PUT("/content/*path", dr.writeContent)
+	r.PUT("/content/*path", idempotent, dr.writeContent)
 	// chunk upload request
 	r.POST("/chunk", dr.chunkUploadRequest)
-	// chunk upload
+	// chunk upload, ?checksum=alg:hex to verify the chunk
 	r.PUT("/chunk/:id/:seq", dr.chunkUpload)
-	// chunk upload complete
-	r.POST("/chunk-content/*path", dr.chunkUploadComplete)
+	// chunk upload complete, ?checksum=alg:hex to verify the assembled file
+	r.POST("/chunk-content/*path", idempotent, dr.chunkUploadComplete)
 	// delete chunk upload
 	r.DELETE("/chunk/:id", dr.deleteChunkUpload)
+	// tus resumable upload, saved to the path in Upload-Metadata when finished
+	initTusRoutes(router, r, &dr)
+	// share links with optional password, expiration and download limit, browsed without logging in
+	initShareRoutes(router, r, idempotent, &dr, shareDAO, userDAO)
+	downloadTokens, e := newDownloadTokens(config)
+	if e != nil {
+		log.Fatalln("failed to init the download tokens", e)
+	}
+	// signed direct download URLs for the clients which can't send the Authorization header
+	initDownloadURLRoutes(router, r, &dr, downloadTokens, userDAO)
+	// editing the office documents in the OnlyOffice Document Server
+	if config.OnlyOfficeURL != "" {
+		initOnlyOfficeRoutes(router, r, &dr, downloadTokens, userDAO)
+	}
+	// the metadata of the media files
+	initMediaInfoRoutes(r, &dr, mediaInfo)
+	// the directories of audio files as playlists for the standard players
+	initPlaylistRoutes(router, r, &dr, downloadTokens, userDAO)
+	// playing the videos by HLS, which are transcoded on demand
+	if config.FFmpegPath != "" && config.FFprobePath != "" {
+		initHLSRoutes(router, r, &dr, hls, downloadTokens, userDAO)
+	}
+	// the WOPI host for editing the office documents in Collabora Online or Office Online
+	if config.WOPIClientURL != "" {
+		initWOPIRoutes(router, r, &dr, downloadTokens, userDAO)
+	}
+	// searching the entries of the indexed paths
+	if config.SearchPaths != "" {
+		initSearchRoutes(r, &dr, search)
+	}
 	// get task
 	r.GET("/task/:id", func(c *gin.Context) {
 		t, e := dr.runner.GetTask(c.Param("id"))
@@ -83,6 +159,35 @@ func InitDriveRoutes(router gin.IRouter,
 		SetResult(c, t)
 	})
 
+	// pause task
+	r.POST("/task/:id/pause", func(c *gin.Context) {
+		t, e := dr.runner.PauseTask(c.Param("id"))
+		if e != nil && e == task.ErrorNotFound {
+			e = err.NewNotFoundMessageError(e.Error())
+		}
+		if e != nil && e == task.ErrorFinished {
+			e = err.NewNotAllowedMessageError(i18n.T("api.drive.task_finished"))
+		}
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		SetResult(c, t)
+	})
+
+	// resume task
+	r.POST("/task/:id/resume", func(c *gin.Context) {
+		t, e := dr.runner.ResumeTask(c.Param("id"))
+		if e != nil && e == task.ErrorNotFound {
+			e = err.NewNotFoundMessageError(e.Error())
+		}
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		SetResult(c, t)
+	})
+
 	// cancel and delete task
 	r.DELETE("/task/:id", func(c *gin.Context) {
 		_, e := dr.runner.StopTask(c.Param("id"))
@@ -100,28 +205,52 @@ type driveRoute struct {
 	rootDrive     *drive.RootDrive
 	permissionDAO *storage.PathPermissionDAO
 	chunkUploader *ChunkUploader
+	deleteCP      *DeleteCheckpoints
 	thumbnail     *Thumbnail
 	runner        task.Runner
 	signer        *utils.Signer
+	auditSink     types.AuditSink
 }
 
 func (dr *driveRoute) getDrive(c *gin.Context) types.IDrive {
-	session := GetSession(c)
-	return NewPermissionWrapperDrive(
-		c.Request, session,
+	return dr.sessionDrive(c.Request, GetSession(c))
+}
+
+// sessionDrive returns the drive of the session, the permissions are checked and the mutations are audited
+func (dr *driveRoute) sessionDrive(request *http.Request, session types.Session) types.IDrive {
+	d := NewPermissionWrapperDrive(
+		request, session,
 		dr.rootDrive.Get(),
 		dr.permissionDAO,
 		dr.signer,
 	)
+	if dr.config.AuditLog == "" {
+		return d
+	}
+	// the permission denied mutations are audited as well
+	return drive.NewAuditDrive(d, dr.auditSink, session.User.Username)
 }
 
+const (
+	previewWorkers = 8
+	previewTimeout = 5 * time.Second
+)
+
 func (dr *driveRoute) list(c *gin.Context) {
 	path := utils.CleanPath(c.Param("path"))
+	if c.Query("previews") != "" {
+		dr.listWithPreviews(c, path)
+		return
+	}
 	entries, e := dr.getDrive(c).List(c.Request.Context(), path)
 	if e != nil {
 		_ = c.Error(e)
 		return
 	}
+	if e := drive_util.SortEntries(entries, c.Query("sort")); e != nil {
+		_ = c.Error(e)
+		return
+	}
 	res := make([]entryJson, 0, len(entries))
 	for _, v := range entries {
 		res = append(res, *newEntryJson(v))
@@ -129,6 +258,103 @@ func (dr *driveRoute) list(c *gin.Context) {
 	SetResult(c, res)
 }
 
+func (dr *driveRoute) findByProp(c *gin.Context) {
+	drive_ := dr.getDrive(c)
+	path := utils.CleanPath(c.Param("path"))
+	key, value := c.Query("key"), c.Query("value")
+	if key == "" {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.drive.invalid_prop_key")))
+		return
+	}
+	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		res := make([]entryJson, 0)
+		e := drive_util.FindByProp(ctx, drive_, path, key, value, func(entry types.IEntry) error {
+			res = append(res, *newEntryJson(entry))
+			return nil
+		})
+		return res, e
+	}, 2*time.Second)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
+func (dr *driveRoute) findDuplicates(c *gin.Context) {
+	drive_ := dr.getDrive(c)
+	path := utils.CleanPath(c.Param("path"))
+	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		res := make([][]entryJson, 0)
+		e := drive_util.FindDuplicates(ctx, drive_, path, func(entries []types.IEntry) error {
+			group := make([]entryJson, 0, len(entries))
+			for _, entry := range entries {
+				group = append(group, *newEntryJson(entry))
+			}
+			res = append(res, group)
+			return nil
+		})
+		return res, e
+	}, 2*time.Second)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
+func (dr *driveRoute) listChangedSince(c *gin.Context) {
+	drive_ := dr.getDrive(c)
+	path := utils.CleanPath(c.Param("path"))
+	since, e := strconv.ParseInt(c.Query("since"), 10, 64)
+	if e != nil || since < 0 {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.drive.invalid_since", c.Query("since"))))
+		return
+	}
+	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		res := make([]entryJson, 0)
+		e := drive_util.ListChangedSince(ctx, drive_, path, since, func(entry types.IEntry) error {
+			res = append(res, *newEntryJson(entry))
+			return nil
+		})
+		return res, e
+	}, 2*time.Second)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
+func (dr *driveRoute) listWithPreviews(c *gin.Context, path string) {
+	entries, e := drive_util.ListWithPreviews(c.Request.Context(), dr.getDrive(c), path,
+		previewWorkers, previewTimeout)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if mode := c.Query("sort"); mode != "" {
+		compare, e := drive_util.EntryComparator(mode)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return compare(entries[i].Entry, entries[j].Entry) < 0 })
+	}
+	res := make([]entryJson, 0, len(entries))
+	for _, v := range entries {
+		ej := newEntryJson(v.Entry)
+		ej.Preview = &previewJson{
+			FirstLine: v.Preview.FirstLine,
+			Width:     v.Preview.Width,
+			Height:    v.Preview.Height,
+			Duration:  v.Preview.Duration,
+		}
+		res = append(res, *ej)
+	}
+	SetResult(c, res)
+}
+
 func (dr *driveRoute) get(c *gin.Context) {
 	path := utils.CleanPath(c.Param("path"))
 	entry, e := dr.getDrive(c).Get(c.Request.Context(), path)
@@ -139,6 +365,20 @@ func (dr *driveRoute) get(c *gin.Context) {
 	SetResult(c, newEntryJson(entry))
 }
 
+func (dr *driveRoute) resolveID(c *gin.Context) {
+	resolver, ok := dr.getDrive(c).(types.IIDResolver)
+	if !ok {
+		_ = c.Error(err.NewNotFoundError())
+		return
+	}
+	entry, e := resolver.ResolveID(c.Request.Context(), c.Param("id"))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, newEntryJson(entry))
+}
+
 func (dr *driveRoute) makeDir(c *gin.Context) {
 	path := utils.CleanPath(c.Param("path"))
 	entry, e := dr.getDrive(c).MakeDir(c.Request.Context(), path)
@@ -163,7 +403,28 @@ func (dr *driveRoute) copyEntry(c *gin.Context) {
 		return
 	}
 	override := c.Query("override")
+	compress := c.Query("compress")
+	filter, e := copyFilter(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	opts := drive_util.CopyOptions{Override: override != "", Filter: filter}
+	if compareBy := c.Query("update"); compareBy != "" {
+		if compress != "" {
+			_ = c.Error(err.NewBadRequestError(i18n.T("api.drive.compress_update_conflict")))
+			return
+		}
+		opts.Update, e = drive_util.NewCopyUpdate(compareBy)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+	}
 	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		if compress != "" || !filter.IsEmpty() || opts.Update != nil {
+			return dr.copyAll(ctx, drive_, fromEntry, to, compress != "", opts)
+		}
 		r, e := drive_.Copy(ctx, fromEntry, to, override != "")
 		if e != nil {
 			return nil, e
@@ -178,6 +439,74 @@ func (dr *driveRoute) copyEntry(c *gin.Context) {
 	SetResult(c, t)
 }
 
+// copyFilter creates the filter of copying from the query
+func copyFilter(c *gin.Context) (*drive_util.CopyFilter, error) {
+	filter, e := drive_util.NewCopyFilter(c.QueryArray("include"), c.QueryArray("exclude"), c.Query("prune_empty") != "")
+	if e != nil {
+		return nil, e
+	}
+	for _, limit := range []struct {
+		key   string
+		value *int64
+	}{{"min_size", &filter.MinSize}, {"max_size", &filter.MaxSize}} {
+		s := c.Query(limit.key)
+		if s == "" {
+			continue
+		}
+		v, e := utils.ParseBytes(s)
+		if e != nil {
+			return nil, err.NewBadRequestError(i18n.T("api.drive.invalid_size_limit", s))
+		}
+		*limit.value = int64(v)
+	}
+	filter.SkipUnknownSize = c.Query("unknown_size") == "skip"
+	return filter, nil
+}
+
+type copyAllResult struct {
+	*entryJson
+	// Saved is the total size saved by compressing
+	Saved int64 `json:"saved"`
+	// Skipped is the number of files skipped by the size limits
+	Skipped int `json:"skipped"`
+	// Update is the counts of the files of the update mode
+	Update *drive_util.CopyUpdate `json:"update,omitempty"`
+}
+
+// copyAll copies the entries selected by filter to 'to',
+// compressible files are gzipped if compress is true, see drive_util.CompressCopy
+func (dr *driveRoute) copyAll(ctx types.TaskCtx, drive_ types.IDrive, from types.IEntry,
+	to string, compress bool, opts drive_util.CopyOptions) (interface{}, error) {
+	saved := int64(0)
+	skipped := 0
+	if opts.Filter != nil {
+		opts.Filter.Skipped = func(types.IEntry) { skipped++ }
+	}
+	doCopy := func(from types.IEntry, driveTo types.IDrive, to string, ctx types.TaskCtx) error {
+		_, e := driveTo.Copy(task.NewCtxWrapper(ctx, true, false), from, to, true)
+		return e
+	}
+	if compress {
+		doCopy = drive_util.CompressCopy(dr.config.TempDir, opts.Override, doCopy,
+			func(_ types.IEntry, _ string, size, compressed int64) {
+				saved += size - compressed
+			},
+		)
+	}
+	if e := drive_util.CopyAllWithOptions(ctx, from, drive_, to, opts, doCopy, nil); e != nil {
+		return nil, e
+	}
+	entryTo := to
+	if compress && from.Type().IsFile() && drive_util.IsCompressible(from.Path()) {
+		entryTo += drive_util.CompressedSuffix
+	}
+	r, e := drive_.Get(ctx, entryTo)
+	if e != nil {
+		return nil, e
+	}
+	return copyAllResult{entryJson: newEntryJson(r), Saved: saved, Skipped: skipped, Update: opts.Update}, nil
+}
+
 func (dr *driveRoute) move(c *gin.Context) {
 	drive_ := dr.getDrive(c)
 	from := utils.CleanPath(c.Query("from"))
@@ -192,7 +521,11 @@ func (dr *driveRoute) move(c *gin.Context) {
 		return
 	}
 	override := c.Query("override")
+	verifyHash := c.Query("verify") == "hash"
 	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		if verifyHash {
+			ctx = drive_util.WithMoveVerifyHash(ctx)
+		}
 		r, e := drive_.Move(ctx, fromEntry, to, override != "")
 		if e != nil {
 			return nil, e
@@ -207,6 +540,52 @@ func (dr *driveRoute) move(c *gin.Context) {
 	SetResult(c, t)
 }
 
+func (dr *driveRoute) publish(c *gin.Context) {
+	drive_, ok := dr.getDrive(c).(types.IPublisher)
+	if !ok {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	from := utils.CleanPath(c.Query("from"))
+	to := utils.CleanPath(c.Query("to"))
+	if e := checkCopyOrMove(from, to); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		r, e := drive_.Publish(ctx, from, to)
+		if e != nil {
+			return nil, e
+		}
+		return newEntryJson(r), nil
+	}, 2*time.Second)
+
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
+func (dr *driveRoute) share(c *gin.Context) {
+	sharer, ok := dr.getDrive(c).(types.ISharer)
+	if !ok {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	path := utils.CleanPath(c.Param("path"))
+	options := types.ShareOptions{
+		Password:  c.Query("password"),
+		ExpiresAt: utils.ToInt64(c.Query("expires_at"), 0),
+	}
+	link, e := sharer.Share(c.Request.Context(), path, options)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, link)
+}
+
 func checkCopyOrMove(from, to string) error {
 	if from == to {
 		return err.NewNotAllowedMessageError(i18n.T("api.drive.copy_to_same_path_not_allowed"))
@@ -219,6 +598,10 @@ func checkCopyOrMove(from, to string) error {
 
 func (dr *driveRoute) deleteEntry(c *gin.Context) {
 	path := utils.CleanPath(c.Param("path"))
+	if resume := c.Query("resume"); resume != "" || c.Query("checkpoint") != "" {
+		dr.deleteResumable(c, path, resume)
+		return
+	}
 	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
 		return nil, dr.getDrive(c).Delete(ctx, path)
 	}, 2*time.Second)
@@ -229,6 +612,43 @@ func (dr *driveRoute) deleteEntry(c *gin.Context) {
 	SetResult(c, t)
 }
 
+// deleteResumable deletes the path with checkpoints keyed by the task id,
+// an interrupted deletion can be resumed with the id of the first task as 'resume'
+func (dr *driveRoute) deleteResumable(c *gin.Context, path, resume string) {
+	var cp *drive_util.DeleteCheckpoint
+	if resume != "" {
+		var e error
+		cp, e = dr.deleteCP.Load(resume)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if cp.Path != path {
+			_ = c.Error(err.NewBadRequestError(i18n.T("api.delete_checkpoints.path_mismatch", cp.Path)))
+			return
+		}
+	}
+	drive_ := dr.getDrive(c)
+	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		key := resume
+		if key == "" {
+			key = task.ID(ctx)
+			cp = &drive_util.DeleteCheckpoint{Path: path}
+		}
+		if e := drive_util.DeleteResumable(ctx, drive_, cp, func(cp *drive_util.DeleteCheckpoint) error {
+			return dr.deleteCP.Save(key, cp)
+		}); e != nil {
+			return nil, e
+		}
+		return nil, dr.deleteCP.Remove(key)
+	}, 2*time.Second)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
 func (dr *driveRoute) upload(c *gin.Context) {
 	path := utils.CleanPath(c.Param("path"))
 	override := c.Query("override")
@@ -244,6 +664,11 @@ func (dr *driveRoute) upload(c *gin.Context) {
 		return
 	}
 	if config != nil {
+		if dr.config.UploadRateLimit > 0 &&
+			(config.Provider == types.LocalProvider || config.Provider == types.LocalChunkProvider) {
+			config.Config = utils.CopySM(config.Config)
+			config.Config["rate_limit"] = strconv.FormatInt(dr.config.UploadRateLimit, 10)
+		}
 		SetResult(c, uploadConfig{config.Provider, config.Config})
 	}
 }
@@ -260,7 +685,8 @@ func (dr *driveRoute) getContent(c *gin.Context) {
 		if dr.config.ProxyMaxSize > 0 && file.Size() > dr.config.ProxyMaxSize {
 			useProxy = ""
 		}
-		if e := drive_util.DownloadIContent(c.Request.Context(), content, c.Writer, c.Request, useProxy != ""); e != nil {
+		if e := drive_util.DownloadIContent(c.Request.Context(), content, c.Writer, c.Request,
+			useProxy != "", dr.config.DownloadRateLimit); e != nil {
 			_ = c.Error(e)
 			return
 		}
@@ -269,6 +695,174 @@ func (dr *driveRoute) getContent(c *gin.Context) {
 	_ = c.Error(err.NewNotAllowedError())
 }
 
+func (dr *driveRoute) getArchive(c *gin.Context) (types.IArchive, error) {
+	path := utils.CleanPath(c.Param("path"))
+	entry, e := dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		return nil, e
+	}
+	content, ok := entry.(types.IContent)
+	if !ok || !entry.Type().IsFile() {
+		return nil, err.NewNotAllowedError()
+	}
+	return drive_util.GetArchive(content, dr.config.TempDir)
+}
+
+func (dr *driveRoute) listArchive(c *gin.Context) {
+	archive, e := dr.getArchive(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	members, e := archive.ListMembers(c.Request.Context())
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	res := make([]archiveMemberJson, 0, len(members))
+	for _, m := range members {
+		t := types.EntryType(types.TypeFile)
+		if m.IsDir {
+			t = types.TypeDir
+		}
+		res = append(res, archiveMemberJson{Name: m.Name, Type: t, Size: m.Size, ModTime: m.ModTime})
+	}
+	SetResult(c, res)
+}
+
+func (dr *driveRoute) getArchiveMember(c *gin.Context) {
+	archive, e := dr.getArchive(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	member := utils.CleanPath(c.Query("member"))
+	reader, e := archive.OpenMember(c.Request.Context(), member)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	defer func() { _ = reader.Close() }()
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", types.SM{"filename": utils.PathBase(member)}))
+	c.Status(http.StatusOK)
+	_, _ = drive_util.CopyBuffered(c.Request.Context(),
+		drive_util.ThrottledResponseWriter(c.Writer, dr.config.DownloadRateLimit), reader)
+}
+
+// downloadZip streams the directory as an archive, whose entries are relative to the directory
+func (dr *driveRoute) downloadZip(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	root, e := dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if !root.Type().IsDir() {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	name := utils.PathBase(path)
+	if name == "" {
+		name = "go-drive"
+	}
+	dr.streamArchive(c, name, func(ctx types.TaskCtx) (drive_util.EntryNode, error) {
+		return drive_util.BuildEntriesTree(ctx, root, true)
+	})
+}
+
+// downloadSelection streams the entries of the 'path' query parameters as an archive,
+// which contains exactly the selected files and directories
+func (dr *driveRoute) downloadSelection(c *gin.Context) {
+	paths := c.QueryArray("path")
+	if len(paths) == 0 {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.drive.empty_selection")))
+		return
+	}
+	drive_ := dr.getDrive(c)
+	entries := make([]types.IEntry, 0, len(paths))
+	names := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		p = utils.CleanPath(p)
+		entry, e := drive_.Get(c.Request.Context(), p)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		base := utils.PathBase(p)
+		if base == "" || names[base] {
+			_ = c.Error(err.NewBadRequestError(i18n.T("api.drive.duplicate_selection", base)))
+			return
+		}
+		names[base] = true
+		entries = append(entries, entry)
+	}
+	name := "go-drive"
+	if len(entries) == 1 {
+		name = utils.PathBase(entries[0].Path())
+	}
+	dr.streamArchive(c, name, func(ctx types.TaskCtx) (drive_util.EntryNode, error) {
+		return drive_util.BuildSelectionTree(ctx, entries, true)
+	})
+}
+
+// streamArchive streams the tree built by build as an archive, which is written by a task,
+// so its progress can be got by the task id in the X-Task-Id header, and stopping the task stops the download.
+// The format is 'zip'(by default) or 'tar.gz', and the files in zip are not compressed if 'store' is set.
+func (dr *driveRoute) streamArchive(c *gin.Context, name string,
+	build func(ctx types.TaskCtx) (drive_util.EntryNode, error)) {
+	var write func(ctx types.TaskCtx, w io.Writer, root drive_util.EntryNode) error
+	contentType := ""
+	switch format := c.Query("format"); format {
+	case "", "zip":
+		deflate := c.Query("store") == ""
+		write = func(ctx types.TaskCtx, w io.Writer, root drive_util.EntryNode) error {
+			return drive_util.WriteZip(ctx, w, root, deflate)
+		}
+		name += ".zip"
+		contentType = "application/zip"
+	case "tar.gz":
+		write = func(ctx types.TaskCtx, w io.Writer, root drive_util.EntryNode) error {
+			return drive_util.WriteTarGz(ctx, w, root, dr.config.TempDir)
+		}
+		name += ".tar.gz"
+		contentType = "application/gzip"
+	default:
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.drive.unsupported_archive_format", format)))
+		return
+	}
+	// the task starts writing after the headers are set
+	start := make(chan struct{})
+	done := make(chan error, 1)
+	t, e := dr.runner.Execute(func(ctx types.TaskCtx) (interface{}, error) {
+		<-start
+		tree, e := build(ctx)
+		if e == nil {
+			e = write(ctx, drive_util.ThrottledResponseWriter(c.Writer, dr.config.DownloadRateLimit), tree)
+		}
+		done <- e
+		return nil, e
+	})
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	c.Header("X-Task-Id", t.Id)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", types.SM{"filename": name}))
+	close(start)
+	select {
+	case e = <-done:
+	case <-c.Request.Context().Done():
+		_, _ = dr.runner.StopTask(t.Id)
+		e = <-done
+	}
+	// the error can be responded only if nothing has been written
+	if e != nil && !c.Writer.Written() {
+		c.Header("Content-Disposition", "")
+		_ = c.Error(e)
+	}
+}
+
 func (dr *driveRoute) getThumbnail(c *gin.Context) {
 	path := utils.CleanPath(c.Param("path"))
 	if !checkSignature(dr.signer, c.Request, path) {
@@ -299,12 +893,51 @@ func (dr *driveRoute) getThumbnail(c *gin.Context) {
 	http.ServeContent(c.Writer, c.Request, "thumbnail.jpg", stat.ModTime(), file)
 }
 
+// getImage serves the image resized by the query, the other files are served unchanged
+func (dr *driveRoute) getImage(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	if !checkSignature(dr.signer, c.Request, path) {
+		_ = c.Error(err.NewNotFoundError())
+		return
+	}
+	opts, e := ParseImageOptions(c.Query("w"), c.Query("h"), c.Query("q"), c.Query("format"))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	entry, e := dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if !IsImage(entry) {
+		dr.getContent(c)
+		return
+	}
+	file, contentType, e := dr.thumbnail.Resize(entry, opts)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	defer func() { _ = file.Close() }()
+	stat, e := file.Stat()
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", fmt.Sprintf("max-age=%d", int(dr.config.ThumbnailCacheTTl.Seconds())))
+	http.ServeContent(c.Writer, c.Request, "", stat.ModTime(), file)
+}
+
 func (dr *driveRoute) writeContent(c *gin.Context) {
 	path := utils.CleanPath(c.Param("path"))
 	override := c.Query("override")
 	size := utils.ToInt64(c.GetHeader("Content-Length"), -1)
 	defer func() { _ = c.Request.Body.Close() }()
-	file, e := drive_util.CopyReaderToTempFile(task.DummyContext(), c.Request.Body, dr.config.TempDir)
+	drive_util.SetBandwidthLimitHeader(c.Writer.Header(), dr.config.UploadRateLimit)
+	file, e := drive_util.CopyReaderToTempFile(task.DummyContext(),
+		drive_util.ThrottledReader(c.Request.Body, dr.config.UploadRateLimit), dr.config.TempDir)
 	if e != nil {
 		_ = c.Error(e)
 		return
@@ -358,7 +991,9 @@ func (dr *driveRoute) chunkUpload(c *gin.Context) {
 		_ = c.Error(e)
 		return
 	}
-	if e := dr.chunkUploader.ChunkUpload(id, seq, c.Request.Body); e != nil {
+	drive_util.SetBandwidthLimitHeader(c.Writer.Header(), dr.config.UploadRateLimit)
+	if e := dr.chunkUploader.ChunkUpload(id, seq, c.Query("checksum"),
+		drive_util.ThrottledReader(c.Request.Body, dr.config.UploadRateLimit)); e != nil {
 		_ = c.Error(e)
 	}
 }
@@ -366,8 +1001,9 @@ func (dr *driveRoute) chunkUpload(c *gin.Context) {
 func (dr *driveRoute) chunkUploadComplete(c *gin.Context) {
 	path := utils.CleanPath(c.Param("path"))
 	id := c.Query("id")
+	checksum := c.Query("checksum")
 	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
-		file, e := dr.chunkUploader.CompleteUpload(id, ctx)
+		file, e := dr.chunkUploader.CompleteUpload(id, checksum, ctx)
 		if e != nil {
 			return nil, e
 		}
@@ -407,6 +1043,16 @@ type entryJson struct {
 	Size    int64           `json:"size"`
 	Meta    types.M         `json:"meta"`
 	ModTime int64           `json:"mod_time"`
+	Preview *previewJson    `json:"preview,omitempty"`
+	// ID is the stable id of the entry, which survives renames and moves
+	ID string `json:"id,omitempty"`
+}
+
+type previewJson struct {
+	FirstLine string  `json:"first_line,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
 }
 
 func newEntryJson(e types.IEntry) *entryJson {
@@ -416,6 +1062,13 @@ func newEntryJson(e types.IEntry) *entryJson {
 	if entryMeta.Thumbnail != "" {
 		meta["thumbnail"] = entryMeta.Thumbnail
 	}
+	id := ""
+	if s := drive_util.GetIEntry(e, func(i types.IEntry) bool {
+		_, ok := i.(types.IStableID)
+		return ok
+	}); s != nil {
+		id = s.(types.IStableID).StableID()
+	}
 	return &entryJson{
 		Path:    e.Path(),
 		Name:    utils.PathBase(e.Path()),
@@ -423,9 +1076,17 @@ func newEntryJson(e types.IEntry) *entryJson {
 		Size:    e.Size(),
 		Meta:    meta,
 		ModTime: e.ModTime(),
+		ID:      id,
 	}
 }
 
+type archiveMemberJson struct {
+	Name    string          `json:"name"`
+	Type    types.EntryType `json:"type"`
+	Size    int64           `json:"size"`
+	ModTime int64           `json:"mod_time"`
+}
+
 type uploadConfig struct {
 	Provider string      `json:"provider"`
 	Config   interface{} `json:"config"`
@@ -1,35 +1,56 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	"go-drive/common"
+	"go-drive/common/drive_util"
 	"go-drive/common/errors"
 	"go-drive/common/i18n"
+	"go-drive/common/registry"
 	"go-drive/common/task"
 	"go-drive/common/types"
 	"go-drive/common/utils"
+	"hash"
 	"io"
 	"io/ioutil"
+	"log"
 	"math"
 	"os"
 	path2 "path"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const minChunkSize = 5 * 1024 * 1024
 
+// appendUploadTTL is how long an append upload is kept since it's appended the last time
+const appendUploadTTL = 24 * time.Hour
+
+// appendUploadPrefix is the prefix of the ids of the append uploads, which have no '_' unlike the chunk uploads
+const appendUploadPrefix = "append-"
+
 type ChunkUploader struct {
 	dir string
+
+	mux sync.Mutex
+	// busy is the append uploads being appended or saved
+	busy        map[string]bool
+	stopCleaner func()
 }
 
-func NewChunkUploader(config common.Config) (*ChunkUploader, error) {
+func NewChunkUploader(config common.Config, ch *registry.ComponentsHolder) (*ChunkUploader, error) {
 	dir, e := config.GetDir("upload_temp", true)
 	if e != nil {
 		return nil, e
 	}
-	return &ChunkUploader{dir}, nil
+	c := &ChunkUploader{dir: dir, busy: make(map[string]bool)}
+	c.stopCleaner = utils.TimeTick(c.clean, 1*time.Hour)
+	ch.Add("chunkUploader", c)
+	return c, nil
 }
 
 func (c *ChunkUploader) CreateUpload(size, chunkSize int64) (ChunkUpload, error) {
@@ -59,7 +80,14 @@ func (c *ChunkUploader) CreateUpload(size, chunkSize int64) (ChunkUpload, error)
 	return *upload, nil
 }
 
-func (c *ChunkUploader) ChunkUpload(id string, seq int, reader io.Reader) error {
+// ChunkUpload saves the chunk of seq.
+// If checksum is not empty, the chunk is rejected if its content does not match,
+// and is verified again by CompleteUpload before the file is assembled.
+func (c *ChunkUploader) ChunkUpload(id string, seq int, checksum string, reader io.Reader) error {
+	sum, e := drive_util.ParseChecksum(checksum)
+	if e != nil {
+		return e
+	}
 	upload, e := c.getUpload(id)
 
 	defer func() {
@@ -89,7 +117,15 @@ func (c *ChunkUploader) ChunkUpload(id string, seq int, reader io.Reader) error
 			_ = os.Remove(chunk.Name())
 		}
 	}()
-	written, e := io.Copy(chunk, reader)
+	// the checksum of the previous upload of this chunk is stale
+	_ = os.Remove(c.getChunkSum(upload, seq))
+	var w io.Writer = chunk
+	var h hash.Hash
+	if sum != nil {
+		h = sum.NewHash()
+		w = io.MultiWriter(chunk, h)
+	}
+	written, e := io.Copy(w, reader)
 	if e != nil {
 		return e
 	}
@@ -97,12 +133,28 @@ func (c *ChunkUploader) ChunkUpload(id string, seq int, reader io.Reader) error
 		return err.NewBadRequestError(i18n.T("api.chunk_uploader.expected__bytes_but__bytes",
 			strconv.FormatInt(chunkSize, 10), strconv.FormatInt(written, 10)))
 	}
+	if sum != nil {
+		if !sum.Match(h) {
+			return chunkChecksumMismatch(seq)
+		}
+		if e := ioutil.WriteFile(c.getChunkSum(upload, seq), []byte(sum.String()), 0644); e != nil {
+			return e
+		}
+	}
 	success = true
 	return nil
 }
 
-func (c *ChunkUploader) CompleteUpload(id string, ctx types.TaskCtx) (*os.File, error) {
+// CompleteUpload assembles the chunks into a file.
+// The chunks uploaded with checksums are verified again, a mismatched chunk is removed to be uploaded again.
+// If checksum is not empty, the assembled file is verified before it's returned.
+func (c *ChunkUploader) CompleteUpload(id string, checksum string, ctx types.TaskCtx) (*os.File, error) {
+	sum, e := drive_util.ParseChecksum(checksum)
+	if e != nil {
+		return nil, e
+	}
 	upload, e := c.getUpload(id)
+
 	if e != nil {
 		return nil, e
 	}
@@ -112,7 +164,7 @@ func (c *ChunkUploader) CompleteUpload(id string, ctx types.TaskCtx) (*os.File,
 			return nil, e
 		}
 		if !exists {
-			return nil, err.NewNotAllowedMessageError(i18n.T("missing_chunks"))
+			return nil, err.NewNotAllowedMessageError(i18n.T("api.chunk_uploader.missing_chunks"))
 		}
 	}
 	file, e := os.OpenFile(c.getFile(upload), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
@@ -129,16 +181,32 @@ func (c *ChunkUploader) CompleteUpload(id string, ctx types.TaskCtx) (*os.File,
 			_ = c.DeleteUpload(upload.Id)
 		}
 	}()
+	var fileWriter io.Writer = file
+	var fileHash hash.Hash
+	if sum != nil {
+		fileHash = sum.NewHash()
+		fileWriter = io.MultiWriter(file, fileHash)
+	}
 	ctx.Total(upload.Size, true)
 	for seq := 0; seq < upload.Chunks; seq++ {
-		if ctx.Canceled() {
-			return nil, task.ErrorCanceled
+		if e := ctx.WaitIfPaused(); e != nil {
+			return nil, e
+		}
+		chunkSum, e := c.getChunkChecksum(upload, seq)
+		if e != nil {
+			return nil, e
+		}
+		writer := fileWriter
+		var chunkHash hash.Hash
+		if chunkSum != nil {
+			chunkHash = chunkSum.NewHash()
+			writer = io.MultiWriter(fileWriter, chunkHash)
 		}
 		chunk, e := os.Open(c.getChunk(upload, seq))
 		if e != nil {
 			return nil, e
 		}
-		w, e := io.Copy(file, chunk)
+		w, e := io.Copy(writer, chunk)
 		_ = chunk.Close()
 		if c.isMarkedDelete(upload) {
 			return nil, task.ErrorCanceled
@@ -146,8 +214,16 @@ func (c *ChunkUploader) CompleteUpload(id string, ctx types.TaskCtx) (*os.File,
 		if e != nil {
 			return nil, e
 		}
+		if chunkSum != nil && !chunkSum.Match(chunkHash) {
+			_ = os.Remove(c.getChunk(upload, seq))
+			_ = os.Remove(c.getChunkSum(upload, seq))
+			return nil, chunkChecksumMismatch(seq)
+		}
 		ctx.Progress(w, false)
 	}
+	if sum != nil && !sum.Match(fileHash) {
+		return nil, err.NewChecksumMismatchError(i18n.T("api.chunk_uploader.file_checksum_mismatch"), nil)
+	}
 	allSuccess = true
 	_ = file.Close()
 	if !allSuccess {
@@ -170,7 +246,7 @@ func (c *ChunkUploader) DeleteUpload(id string) error {
 	return nil
 }
 
-func (c ChunkUploader) generateUploadId(size, chunkSize int64) string {
+func (c *ChunkUploader) generateUploadId(size, chunkSize int64) string {
 	return fmt.Sprintf("%s_%d_%d", uuid.New().String(), size, chunkSize)
 }
 
@@ -223,6 +299,27 @@ func (c *ChunkUploader) getChunk(upload *ChunkUpload, seq int) string {
 	return path2.Join(c.getDir(upload.Id), strconv.Itoa(seq))
 }
 
+func (c *ChunkUploader) getChunkSum(upload *ChunkUpload, seq int) string {
+	return c.getChunk(upload, seq) + ".sum"
+}
+
+// getChunkChecksum returns the checksum the chunk was uploaded with, or nil if it was uploaded without one
+func (c *ChunkUploader) getChunkChecksum(upload *ChunkUpload, seq int) (*drive_util.Checksum, error) {
+	b, e := ioutil.ReadFile(c.getChunkSum(upload, seq))
+	if e != nil {
+		if os.IsNotExist(e) {
+			return nil, nil
+		}
+		return nil, e
+	}
+	return drive_util.ParseChecksum(string(b))
+}
+
+func chunkChecksumMismatch(seq int) error {
+	return err.NewChecksumMismatchError(
+		i18n.T("api.chunk_uploader.chunk_checksum_mismatch", strconv.Itoa(seq)), types.M{"seq": seq})
+}
+
 func (c *ChunkUploader) getDeleteMark(upload *ChunkUpload) string {
 	return path2.Join(c.getDir(upload.Id), "deleted")
 }
@@ -231,6 +328,188 @@ func (c *ChunkUploader) getDir(id string) string {
 	return path2.Join(c.dir, id)
 }
 
+// AppendUpload is the upload whose content is appended in requests of any size, like the uploads of tus.
+// The content is appended to a file, so the offset to resume from is the size of the file.
+type AppendUpload struct {
+	Id   string `json:"id"`
+	Size int64  `json:"size"`
+	// Path is the path the file is saved to, and Override is whether to replace the existing file
+	Path     string `json:"path"`
+	Override bool   `json:"override"`
+	// Owner is the username of the creator, the upload is only accessible to the owner
+	Owner string `json:"owner"`
+	// ExpiresAt is the time in milliseconds when the unfinished upload is deleted, it's extended by appending
+	ExpiresAt int64 `json:"-"`
+	Offset    int64 `json:"-"`
+}
+
+func (c *ChunkUploader) CreateAppendUpload(size int64, path string, override bool, owner string) (AppendUpload, error) {
+	if size < 0 {
+		return AppendUpload{}, err.NewBadRequestError(i18n.T("api.chunk_uploader.invalid_file_size"))
+	}
+	upload := AppendUpload{
+		Id:        appendUploadPrefix + uuid.New().String(),
+		Size:      size,
+		Path:      path,
+		Override:  override,
+		Owner:     owner,
+		ExpiresAt: utils.Millisecond(time.Now().Add(appendUploadTTL)),
+	}
+	b, e := json.Marshal(upload)
+	if e != nil {
+		return AppendUpload{}, e
+	}
+	dir := c.getDir(upload.Id)
+	if e := os.Mkdir(dir, 0755); e != nil {
+		return AppendUpload{}, e
+	}
+	if e := ioutil.WriteFile(path2.Join(dir, "file"), nil, 0644); e != nil {
+		_ = os.RemoveAll(dir)
+		return AppendUpload{}, e
+	}
+	if e := ioutil.WriteFile(path2.Join(dir, "upload.json"), b, 0644); e != nil {
+		_ = os.RemoveAll(dir)
+		return AppendUpload{}, e
+	}
+	return upload, nil
+}
+
+// GetAppendUpload returns the upload with the current offset, the expired uploads are not found
+func (c *ChunkUploader) GetAppendUpload(id string) (*AppendUpload, error) {
+	if !strings.HasPrefix(id, appendUploadPrefix) || strings.ContainsAny(id, "/\\.") {
+		return nil, err.NewBadRequestError(i18n.T("api.chunk_uploader.invalid_upload_id"))
+	}
+	dir := c.getDir(id)
+	b, e := ioutil.ReadFile(path2.Join(dir, "upload.json"))
+	if e != nil {
+		if os.IsNotExist(e) {
+			return nil, err.NewNotFoundError()
+		}
+		return nil, e
+	}
+	upload := &AppendUpload{}
+	if e := json.Unmarshal(b, upload); e != nil {
+		return nil, e
+	}
+	stat, e := os.Stat(path2.Join(dir, "file"))
+	if e != nil {
+		return nil, e
+	}
+	upload.ExpiresAt = utils.Millisecond(stat.ModTime().Add(appendUploadTTL))
+	if upload.ExpiresAt < utils.Millisecond(time.Now()) {
+		return nil, err.NewNotFoundError()
+	}
+	upload.Offset = stat.Size()
+	return upload, nil
+}
+
+// LockAppendUpload marks the upload busy until release is called,
+// so it's not appended or saved by the concurrent requests, which get ConflictError
+func (c *ChunkUploader) LockAppendUpload(id string) (release func(), e error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.busy[id] {
+		return nil, err.NewConflictError(i18n.T("api.chunk_uploader.upload_in_progress"))
+	}
+	c.busy[id] = true
+	return func() {
+		c.mux.Lock()
+		delete(c.busy, id)
+		c.mux.Unlock()
+	}, nil
+}
+
+// Append appends the content from offset, which must be the current offset of the upload.
+// The bytes received before an interruption are kept, the content over the size is rejected.
+func (c *ChunkUploader) Append(id string, offset int64, reader io.Reader) (*AppendUpload, error) {
+	release, e := c.LockAppendUpload(id)
+	if e != nil {
+		return nil, e
+	}
+	defer release()
+
+	upload, e := c.GetAppendUpload(id)
+	if e != nil {
+		return nil, e
+	}
+	if offset != upload.Offset {
+		return nil, err.NewBadRequestError(i18n.T("api.chunk_uploader.offset_mismatch",
+			strconv.FormatInt(upload.Offset, 10)))
+	}
+	file, e := os.OpenFile(path2.Join(c.getDir(id), "file"), os.O_WRONLY|os.O_APPEND, 0644)
+	if e != nil {
+		return nil, e
+	}
+	written, e := io.Copy(file, io.LimitReader(reader, upload.Size-upload.Offset+1))
+	upload.Offset += written
+	if upload.Offset > upload.Size {
+		upload.Offset = upload.Size
+		e = file.Truncate(upload.Size)
+		if e == nil {
+			e = err.NewBadRequestError(i18n.T("api.chunk_uploader.invalid_file_size"))
+		}
+	}
+	if ce := file.Close(); e == nil {
+		e = ce
+	}
+	return upload, e
+}
+
+// OpenAppendUpload opens the file of the finished upload
+func (c *ChunkUploader) OpenAppendUpload(id string) (*os.File, error) {
+	upload, e := c.GetAppendUpload(id)
+	if e != nil {
+		return nil, e
+	}
+	if upload.Offset != upload.Size {
+		return nil, err.NewNotAllowedMessageError(i18n.T("api.chunk_uploader.upload_unfinished"))
+	}
+	return os.Open(path2.Join(c.getDir(id), "file"))
+}
+
+func (c *ChunkUploader) DeleteAppendUpload(id string) error {
+	if _, e := c.GetAppendUpload(id); e != nil {
+		return e
+	}
+	return os.RemoveAll(c.getDir(id))
+}
+
+// clean deletes the expired append uploads, which have not been appended for appendUploadTTL
+func (c *ChunkUploader) clean() {
+	files, e := ioutil.ReadDir(c.dir)
+	if e != nil {
+		log.Println("error when cleaning append uploads", e)
+		return
+	}
+	n := 0
+	notBefore := time.Now().Add(-appendUploadTTL)
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), appendUploadPrefix) {
+			continue
+		}
+		// the directory is not modified by appending, but the file is
+		modTime := f.ModTime()
+		if stat, e := os.Stat(path2.Join(c.getDir(f.Name()), "file")); e == nil {
+			modTime = stat.ModTime()
+		}
+		if modTime.Before(notBefore) {
+			if e := os.RemoveAll(c.getDir(f.Name())); e != nil {
+				log.Println("failed to delete file", e)
+				continue
+			}
+			n++
+		}
+	}
+	if n > 0 {
+		log.Println(fmt.Sprintf("%d expired append uploads cleaned", n))
+	}
+}
+
+func (c *ChunkUploader) Dispose() error {
+	c.stopCleaner()
+	return nil
+}
+
 type ChunkUpload struct {
 	Id        string `json:"id"`
 	Size      int64  `json:"size"`
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// deleteCheckpointTTL is how long the checkpoint of an interrupted deletion is kept
+const deleteCheckpointTTL = 7 * 24 * time.Hour
+
+// DeleteCheckpoints keeps the checkpoints of the resumable deletions in files, keyed by the id of the first task
+type DeleteCheckpoints struct {
+	dir         string
+	stopCleaner func()
+}
+
+func NewDeleteCheckpoints(config common.Config, ch *registry.ComponentsHolder) (*DeleteCheckpoints, error) {
+	dir, e := config.GetDir("delete_checkpoints", true)
+	if e != nil {
+		return nil, e
+	}
+	d := &DeleteCheckpoints{dir: dir}
+	d.stopCleaner = utils.TimeTick(d.clean, 1*time.Hour)
+	ch.Add("deleteCheckpoints", d)
+	return d, nil
+}
+
+func (d *DeleteCheckpoints) Load(key string) (*drive_util.DeleteCheckpoint, error) {
+	file, e := d.getFile(key)
+	if e != nil {
+		return nil, e
+	}
+	b, e := ioutil.ReadFile(file)
+	if e != nil {
+		if os.IsNotExist(e) {
+			return nil, err.NewNotFoundMessageError(i18n.T("api.delete_checkpoints.not_found", key))
+		}
+		return nil, e
+	}
+	cp := &drive_util.DeleteCheckpoint{}
+	if e := json.Unmarshal(b, cp); e != nil {
+		return nil, e
+	}
+	return cp, nil
+}
+
+// Save replaces the checkpoint atomically, so an interruption leaves the previous one
+func (d *DeleteCheckpoints) Save(key string, cp *drive_util.DeleteCheckpoint) error {
+	file, e := d.getFile(key)
+	if e != nil {
+		return e
+	}
+	b, e := json.Marshal(cp)
+	if e != nil {
+		return e
+	}
+	if e := ioutil.WriteFile(file+".tmp", b, 0644); e != nil {
+		return e
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+func (d *DeleteCheckpoints) Remove(key string) error {
+	file, e := d.getFile(key)
+	if e != nil {
+		return e
+	}
+	if e := os.Remove(file); e != nil && !os.IsNotExist(e) {
+		return e
+	}
+	return nil
+}
+
+func (d *DeleteCheckpoints) getFile(key string) (string, error) {
+	if _, e := uuid.Parse(key); e != nil {
+		return "", err.NewBadRequestError(i18n.T("api.delete_checkpoints.invalid_key", key))
+	}
+	return filepath.Join(d.dir, key), nil
+}
+
+func (d *DeleteCheckpoints) clean() {
+	files, e := ioutil.ReadDir(d.dir)
+	if e != nil {
+		log.Println("error when cleaning delete checkpoints", e)
+		return
+	}
+	n := 0
+	notBefore := time.Now().Add(-deleteCheckpointTTL)
+	for _, f := range files {
+		if f.ModTime().Before(notBefore) {
+			if e := os.Remove(filepath.Join(d.dir, f.Name())); e != nil {
+				log.Println("failed to delete file", e)
+				continue
+			}
+			n++
+		}
+	}
+	if n > 0 {
+		log.Println(fmt.Sprintf("%d expired delete checkpoints cleaned", n))
+	}
+}
+
+func (d *DeleteCheckpoints) Status() (string, types.SM, error) {
+	files, e := ioutil.ReadDir(d.dir)
+	if e != nil {
+		return "", nil, e
+	}
+	return "Delete Checkpoints", types.SM{
+		"Total": fmt.Sprintf("%d", len(files)),
+	}, nil
+}
+
+func (d *DeleteCheckpoints) Dispose() error {
+	d.stopCleaner()
+	return nil
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	downloadTokenDefaultTTL = 1 * time.Hour
+	downloadTokenMaxTTL     = 7 * 24 * time.Hour
+	downloadTokenKeySize    = 32
+
+	// the kinds of the tokens, so the tokens of one kind can't be used as another
+	tokenKindDownload = "download"
+)
+
+// downloadTokens mints and validates the HMAC signed tokens of the direct download URLs,
+// which are accepted without the Authorization header, so they can be used by wget or media players.
+// The token carries the kind, the path, the user and the expiration time, and the permissions of the user
+// are checked again when the token is used. The key is kept in the data dir, so the URLs survive restarts.
+type downloadTokens struct {
+	key []byte
+}
+
+func newDownloadTokens(config common.Config) (*downloadTokens, error) {
+	dir, e := config.GetDir("download", true)
+	if e != nil {
+		return nil, e
+	}
+	key, e := loadDownloadTokenKey(filepath.Join(dir, "token_key"))
+	if e != nil {
+		return nil, e
+	}
+	return &downloadTokens{key: key}, nil
+}
+
+// loadDownloadTokenKey loads the key, a random key is generated if the file does not exist
+func loadDownloadTokenKey(path string) ([]byte, error) {
+	key, e := ioutil.ReadFile(path)
+	if os.IsNotExist(e) {
+		key = make([]byte, downloadTokenKeySize)
+		if _, e := rand.Read(key); e != nil {
+			return nil, e
+		}
+		if e := ioutil.WriteFile(path, key, 0600); e != nil {
+			return nil, e
+		}
+		return key, nil
+	}
+	return key, e
+}
+
+func (d *downloadTokens) mac(payload string) []byte {
+	h := hmac.New(sha256.New, d.key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// Mint returns the token of kind of the path for the user, which expires at expiresAt
+func (d *downloadTokens) Mint(kind, username, path string, expiresAt time.Time) string {
+	payload := kind + "\n" + strconv.FormatInt(expiresAt.Unix(), 10) + "\n" + username + "\n" + path
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(d.mac(payload))
+}
+
+// Validate returns the user and the path of the token, if it's of kind, signed by us and not expired
+func (d *downloadTokens) Validate(kind, token string) (username, path string, ok bool) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return "", "", false
+	}
+	payload, e := base64.RawURLEncoding.DecodeString(token[:i])
+	if e != nil {
+		return "", "", false
+	}
+	mac, e := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if e != nil || !hmac.Equal(mac, d.mac(string(payload))) {
+		return "", "", false
+	}
+	parts := strings.SplitN(string(payload), "\n", 4)
+	if len(parts) != 4 || parts[0] != kind {
+		return "", "", false
+	}
+	expiresAt, e := strconv.ParseInt(parts[1], 10, 64)
+	if e != nil || time.Now().Unix() >= expiresAt {
+		return "", "", false
+	}
+	return parts[2], parts[3], true
+}
+
+// DownloadURL returns the download URL relative to the API root of the path for the user
+func (d *downloadTokens) DownloadURL(username, path string, expiresAt time.Time) string {
+	return "/dl/" + d.Mint(tokenKindDownload, username, path, expiresAt) + "/" + url.PathEscape(utils.PathBase(path))
+}
+
+// tokenSession returns the session of the user of a token, the user is anonymous if username is empty
+func tokenSession(userDAO *storage.UserDAO, username string) (types.Session, error) {
+	session := types.Session{}
+	if username != "" {
+		user, e := userDAO.GetUser(username)
+		if e != nil {
+			return session, e
+		}
+		session.User = user
+	}
+	return session, nil
+}
+
+type downloadURLRoute struct {
+	dr      *driveRoute
+	tokens  *downloadTokens
+	userDAO *storage.UserDAO
+}
+
+func initDownloadURLRoutes(router gin.IRouter, r gin.IRouter, dr *driveRoute,
+	tokens *downloadTokens, userDAO *storage.UserDAO) {
+	d := &downloadURLRoute{dr: dr, tokens: tokens, userDAO: userDAO}
+	// mint a direct download URL of the file, valid for ?expires_in seconds(1 hour by default, 7 days at most)
+	r.POST("/download-url/*path", d.createURL)
+	// download by the token, the name is only for the clients saving the file by the last segment of the URL
+	router.HEAD("/dl/:token/:name", d.download)
+	router.GET("/dl/:token/:name", d.download)
+}
+
+type downloadURLJson struct {
+	// URL is relative to the API root
+	URL       string `json:"url"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func (d *downloadURLRoute) createURL(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	ttl := downloadTokenDefaultTTL
+	if s := c.Query("expires_in"); s != "" {
+		seconds := utils.ToInt64(s, -1)
+		if seconds <= 0 || time.Duration(seconds)*time.Second > downloadTokenMaxTTL {
+			_ = c.Error(err.NewBadRequestError(i18n.T("api.download_url.invalid_expires_in", s)))
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	entry, e := d.dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if _, ok := entry.(types.IContent); !ok || !entry.Type().IsFile() {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	expiresAt := time.Now().Add(ttl)
+	SetResult(c, downloadURLJson{
+		URL:       d.tokens.DownloadURL(GetSession(c).User.Username, path, expiresAt),
+		ExpiresAt: utils.Millisecond(expiresAt),
+	})
+}
+
+func (d *downloadURLRoute) download(c *gin.Context) {
+	username, path, ok := d.tokens.Validate(tokenKindDownload, c.Param("token"))
+	if !ok {
+		_ = c.Error(err.NewNotFoundError())
+		return
+	}
+	session, e := tokenSession(d.userDAO, username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	entry, e := d.dr.sessionDrive(c.Request, session).Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	content, ok := entry.(types.IContent)
+	if !ok {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	if e := drive_util.DownloadIContent(c.Request.Context(), content, c.Writer, c.Request,
+		false, d.dr.config.DownloadRateLimit); e != nil {
+		_ = c.Error(e)
+	}
+}
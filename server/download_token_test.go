@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/base64"
+	"go-drive/common"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadTokens(t *testing.T) {
+	s := newTestServer(t, common.Config{})
+	defer s.close()
+	tokens, e := newDownloadTokens(common.Config{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	token := tokens.Mint(tokenKindDownload, "admin", "a/b.txt", time.Now().Add(time.Hour))
+	if username, path, ok := tokens.Validate(tokenKindDownload, token); !ok || username != "admin" || path != "a/b.txt" {
+		t.Errorf("unexpected validation result: %s %s %v", username, path, ok)
+	}
+	if _, _, ok := tokens.Validate("other", token); ok {
+		t.Error("the token of another kind should be invalid")
+	}
+	forged := base64.RawURLEncoding.EncodeToString([]byte(tokenKindDownload+"\nother\na/b.txt")) +
+		token[strings.IndexByte(token, '.'):]
+	if _, _, ok := tokens.Validate(tokenKindDownload, forged); ok {
+		t.Error("the tampered token should be invalid")
+	}
+	expired := tokens.Mint(tokenKindDownload, "admin", "a/b.txt", time.Now().Add(-time.Second))
+	if _, _, ok := tokens.Validate(tokenKindDownload, expired); ok {
+		t.Error("the expired token should be invalid")
+	}
+
+	// the key is kept in the data dir
+	reloaded, e := newDownloadTokens(common.Config{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, _, ok := reloaded.Validate(tokenKindDownload, token); !ok {
+		t.Error("the token should be valid after restarting")
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/xml"
+	"github.com/gin-gonic/gin"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultFeedItems = 20
+	maxFeedItems     = 200
+	maxFeedDepth     = 5
+)
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title     string       `xml:"title"`
+	Link      string       `xml:"link"`
+	GUID      rssGUID      `xml:"guid"`
+	PubDate   string       `xml:"pubDate"`
+	Enclosure rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type feedItem struct {
+	title   string
+	url     string
+	id      string
+	modTime time.Time
+	size    int64
+	mime    string
+}
+
+// getFeed renders the recently modified files under the path as an RSS(default) or Atom feed.
+//
+// Feed readers can not authenticate, so only the files readable by anonymous users are included.
+//
+// query:
+//
+// - format: 'rss' or 'atom'
+//
+// - limit: maximum items of the feed
+//
+// - depth: levels of subdirectories to include, 0 means the directory itself only
+func (dr *driveRoute) getFeed(c *gin.Context) {
+	dirPath := utils.CleanPath(c.Param("path"))
+	format := c.DefaultQuery("format", "rss")
+	if format != "rss" && format != "atom" {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.feed.invalid_format", format)))
+		return
+	}
+	limit := int(utils.ToInt64(c.Query("limit"), defaultFeedItems))
+	if limit <= 0 || limit > maxFeedItems {
+		limit = maxFeedItems
+	}
+	depth := int(utils.ToInt64(c.Query("depth"), 0))
+	if depth < 0 || depth > maxFeedDepth {
+		depth = maxFeedDepth
+	}
+
+	drive_ := dr.getDrive(c)
+	entries, e := drive_util.RecentFiles(c.Request.Context(), drive_, dirPath, depth, limit)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+
+	base := apiBaseURL(c, "/feed")
+	items := make([]feedItem, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Meta().CanRead {
+			continue
+		}
+		items = append(items, newFeedItem(base, entry))
+	}
+
+	title := utils.PathBase(dirPath)
+	if title == "" {
+		title = "go-drive"
+	}
+	link := base + utils.BuildURL("/entries/{}", dirPath)
+	updated := time.Now()
+	if len(items) > 0 {
+		updated = items[0].modTime
+	}
+
+	var v interface{}
+	contentType := "application/rss+xml; charset=utf-8"
+	if format == "atom" {
+		contentType = "application/atom+xml; charset=utf-8"
+		v = newAtomFeed(title, link, updated, items)
+	} else {
+		v = newRssFeed(title, link, updated, items)
+	}
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	_, _ = c.Writer.WriteString(xml.Header)
+	if e := xml.NewEncoder(c.Writer).Encode(v); e != nil {
+		_ = c.Error(e)
+	}
+}
+
+func newFeedItem(base string, entry types.IEntry) feedItem {
+	name := utils.PathBase(entry.Path())
+	mimeType := mime.TypeByExtension(path.Ext(name))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	modTime := time.Unix(0, entry.ModTime()*int64(time.Millisecond))
+	return feedItem{
+		title:   name,
+		url:     base + utils.BuildURL("/content/{}", entry.Path()),
+		id:      entry.Path() + "@" + strconv.FormatInt(entry.ModTime(), 10),
+		modTime: modTime,
+		size:    entry.Size(),
+		mime:    mimeType,
+	}
+}
+
+func newRssFeed(title, link string, updated time.Time, items []feedItem) rssFeed {
+	rssItems := make([]rssItem, 0, len(items))
+	for _, item := range items {
+		rssItems = append(rssItems, rssItem{
+			Title:     item.title,
+			Link:      item.url,
+			GUID:      rssGUID{Value: item.id},
+			PubDate:   item.modTime.UTC().Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{URL: item.url, Length: item.size, Type: item.mime},
+		})
+	}
+	return rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         title,
+			Link:          link,
+			Description:   title,
+			LastBuildDate: updated.UTC().Format(time.RFC1123Z),
+			Items:         rssItems,
+		},
+	}
+}
+
+func newAtomFeed(title, link string, updated time.Time, items []feedItem) atomFeed {
+	entries := make([]atomEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, atomEntry{
+			ID:      "urn:go-drive:" + item.id,
+			Title:   item.title,
+			Updated: item.modTime.UTC().Format(time.RFC3339),
+			Links: []atomLink{
+				{Href: item.url},
+				{Href: item.url, Rel: "enclosure", Type: item.mime, Length: item.size},
+			},
+		})
+	}
+	return atomFeed{
+		ID:      link,
+		Title:   title,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: link},
+		Entries: entries,
+	}
+}
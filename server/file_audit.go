@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"go-drive/common"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"os"
+	"sync"
+)
+
+// FileAuditSink appends the audit records to a file as JSON lines,
+// the file is synced after each record is written.
+type FileAuditSink struct {
+	file *os.File
+	mux  *sync.Mutex
+}
+
+// NewFileAuditSink creates a FileAuditSink of config.AuditLog, nothing is written if it's empty
+func NewFileAuditSink(config common.Config, ch *registry.ComponentsHolder) (*FileAuditSink, error) {
+	s := &FileAuditSink{mux: &sync.Mutex{}}
+	if config.AuditLog == "" {
+		return s, nil
+	}
+	file, e := os.OpenFile(config.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if e != nil {
+		return nil, e
+	}
+	s.file = file
+	ch.Add("auditSink", s)
+	return s, nil
+}
+
+func (s *FileAuditSink) Write(r types.AuditRecord) error {
+	if s.file == nil {
+		return nil
+	}
+	line, e := json.Marshal(r)
+	if e != nil {
+		return e
+	}
+	line = append(line, '\n')
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, e := s.file.Write(line); e != nil {
+		return e
+	}
+	return s.file.Sync()
+}
+
+func (s *FileAuditSink) Dispose() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	e := s.file.Close()
+	s.file = nil
+	return e
+}
@@ -0,0 +1,928 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/registry"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"go-drive/storage"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ftpIdleTimeout = 5 * time.Minute
+	// ftpDataTimeout is how long to wait for the data connection to be established
+	ftpDataTimeout   = 30 * time.Second
+	ftpMaxLineSize   = 4096
+	ftpMaxLoginFails = 3
+)
+
+// FTPServer serves the drives over FTP, for the scanners, cameras and legacy appliances
+// which can only push files over FTP. Explicit FTPS(AUTH TLS) is supported if the certificate is configured.
+// Users log in with their go-drive accounts, or as 'anonymous' with the permissions of the anonymous,
+// and the commands are served by the same permission wrapped drive as the API.
+type FTPServer struct {
+	listener net.Listener
+	// login returns the drive of the user, or an error if the password is wrong
+	login     func(username, password string, remoteAddr string) (types.IDrive, error)
+	tlsConfig *tls.Config
+	// publicIP is the IPv4 address in the replies of PASV, the address of the control connection if nil
+	publicIP net.IP
+	// portMin and portMax is the range of the passive ports, any free port if portMin is 0
+	portMin, portMax int
+
+	tempDir           string
+	uploadRateLimit   int64
+	downloadRateLimit int64
+}
+
+// NewFTPServer listens on the FTP address of config, nil is returned if FTP is not enabled.
+// The connections are accepted by Serve, until it's disposed
+func NewFTPServer(config common.Config, rootDrive *drive.RootDrive, permissionDAO *storage.PathPermissionDAO,
+	signer *utils.Signer, auditSink types.AuditSink, userDAO *storage.UserDAO,
+	ch *registry.ComponentsHolder) (*FTPServer, error) {
+	if config.FTPListen == "" {
+		return nil, nil
+	}
+	dr := &driveRoute{
+		config:        config,
+		rootDrive:     rootDrive,
+		permissionDAO: permissionDAO,
+		signer:        signer,
+		auditSink:     auditSink,
+	}
+	s := &FTPServer{
+		tempDir:           config.TempDir,
+		uploadRateLimit:   config.UploadRateLimit,
+		downloadRateLimit: config.DownloadRateLimit,
+	}
+	s.login = func(username, password string, remoteAddr string) (types.IDrive, error) {
+		session := types.Session{}
+		if username != "anonymous" {
+			user, e := userDAO.Authenticate(username, password)
+			if e != nil {
+				return nil, e
+			}
+			session.User = user
+		}
+		// the signatures of the download links are not used by FTP
+		request := &http.Request{URL: &url.URL{Path: "/"}, Header: http.Header{}, RemoteAddr: remoteAddr}
+		return dr.sessionDrive(request, session), nil
+	}
+	if config.FTPPassivePorts != "" {
+		var e error
+		if s.portMin, s.portMax, e = parsePortRange(config.FTPPassivePorts); e != nil {
+			return nil, e
+		}
+	}
+	if config.FTPPublicIP != "" {
+		if s.publicIP = net.ParseIP(config.FTPPublicIP).To4(); s.publicIP == nil {
+			return nil, fmt.Errorf("invalid FTP public IP '%s'", config.FTPPublicIP)
+		}
+	}
+	if config.FTPTLSCert != "" || config.FTPTLSKey != "" {
+		cert, e := tls.LoadX509KeyPair(config.FTPTLSCert, config.FTPTLSKey)
+		if e != nil {
+			return nil, e
+		}
+		s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	listener, e := net.Listen("tcp", config.FTPListen)
+	if e != nil {
+		return nil, e
+	}
+	s.listener = listener
+	ch.Add("ftpServer", s)
+	return s, nil
+}
+
+func parsePortRange(s string) (int, int, error) {
+	i := strings.IndexByte(s, '-')
+	if i < 0 {
+		i = len(s)
+		s += "-" + s
+	}
+	min, e1 := strconv.Atoi(strings.TrimSpace(s[:i]))
+	max, e2 := strconv.Atoi(strings.TrimSpace(s[i+1:]))
+	if e1 != nil || e2 != nil || min <= 0 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range '%s'", s)
+	}
+	return min, max, nil
+}
+
+// Serve accepts the connections until the server is disposed
+func (s *FTPServer) Serve() {
+	for {
+		conn, e := s.listener.Accept()
+		if e != nil {
+			if ne, ok := e.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			log.Println("FTP server stopped", e)
+			return
+		}
+		go newFTPSession(s, conn).serve()
+	}
+}
+
+func (s *FTPServer) Dispose() error {
+	return s.listener.Close()
+}
+
+// listenPassive listens on a free port in the passive port range
+func (s *FTPServer) listenPassive() (*net.TCPListener, error) {
+	if s.portMin == 0 {
+		l, e := net.Listen("tcp", ":0")
+		if e != nil {
+			return nil, e
+		}
+		return l.(*net.TCPListener), nil
+	}
+	n := s.portMax - s.portMin + 1
+	start := rand.Intn(n)
+	for i := 0; i < n; i++ {
+		l, e := net.Listen("tcp", ":"+strconv.Itoa(s.portMin+(start+i)%n))
+		if e == nil {
+			return l.(*net.TCPListener), nil
+		}
+	}
+	return nil, errors.New("no free passive port")
+}
+
+type ftpSession struct {
+	s      *FTPServer
+	conn   net.Conn
+	r      *bufio.Reader
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	secure bool
+	// protected is whether the data connections are protected by TLS, set by PROT P
+	protected  bool
+	username   string
+	loginFails int
+	// drive is nil before the user logs in
+	drive types.IDrive
+	// cwd is the current directory, "" is the root
+	cwd string
+
+	passive    *net.TCPListener
+	activeAddr string
+	restOffset int64
+	renameFrom string
+}
+
+func newFTPSession(s *FTPServer, conn net.Conn) *ftpSession {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ftpSession{s: s, conn: conn, r: bufio.NewReaderSize(conn, ftpMaxLineSize), ctx: ctx, cancel: cancel}
+}
+
+func (c *ftpSession) serve() {
+	defer func() {
+		c.cancel()
+		c.closePassive()
+		_ = c.conn.Close()
+	}()
+	c.reply(220, "go-drive FTP server ready.")
+	for {
+		_ = c.conn.SetDeadline(time.Now().Add(ftpIdleTimeout))
+		line, isPrefix, e := c.r.ReadLine()
+		if e != nil {
+			return
+		}
+		if isPrefix {
+			c.reply(500, "Command line too long.")
+			return
+		}
+		cmd, arg := string(line), ""
+		if i := strings.IndexByte(cmd, ' '); i >= 0 {
+			cmd, arg = cmd[:i], cmd[i+1:]
+		}
+		if !c.handle(strings.ToUpper(cmd), arg) {
+			return
+		}
+	}
+}
+
+func (c *ftpSession) reply(code int, message string) {
+	_, _ = fmt.Fprintf(c.conn, "%d %s\r\n", code, message)
+}
+
+// replyLines sends a multi-line reply, the lines between the first and the last are indented
+func (c *ftpSession) replyLines(code int, first string, lines []string, last string) {
+	b := strings.Builder{}
+	b.WriteString(fmt.Sprintf("%d-%s\r\n", code, first))
+	for _, l := range lines {
+		b.WriteString(" " + l + "\r\n")
+	}
+	b.WriteString(fmt.Sprintf("%d %s\r\n", code, last))
+	_, _ = c.conn.Write([]byte(b.String()))
+}
+
+func (c *ftpSession) replyError(e error) {
+	switch {
+	case err.IsNotFoundError(e):
+		c.reply(550, "No such file or directory.")
+	case err.IsQuotaExceededError(e):
+		c.reply(552, "Quota exceeded.")
+	case err.IsUnsupportedError(e):
+		c.reply(504, "Not supported by the drive.")
+	default:
+		if re, ok := e.(err.RequestError); ok && re.Code() < 500 {
+			c.reply(550, "Permission denied.")
+			return
+		}
+		c.reply(451, "Local error in processing.")
+	}
+}
+
+// handle handles the command, false is returned if the connection should be closed
+func (c *ftpSession) handle(cmd, arg string) bool {
+	switch cmd {
+	case "USER":
+		c.username, c.drive = arg, nil
+		c.reply(331, "Password required.")
+		return true
+	case "PASS":
+		return c.pass(arg)
+	case "AUTH":
+		return c.auth(arg)
+	case "PBSZ":
+		if !c.secure {
+			c.reply(503, "PBSZ requires AUTH.")
+		} else {
+			c.reply(200, "PBSZ=0")
+		}
+		return true
+	case "PROT":
+		c.prot(arg)
+		return true
+	case "FEAT":
+		c.feat()
+		return true
+	case "SYST":
+		c.reply(215, "UNIX Type: L8")
+		return true
+	case "OPTS":
+		switch strings.ToUpper(strings.TrimSpace(arg)) {
+		case "UTF8 ON", "UTF8":
+			c.reply(200, "Always in UTF8 mode.")
+		default:
+			c.reply(501, "Option not understood.")
+		}
+		return true
+	case "NOOP":
+		c.reply(200, "OK.")
+		return true
+	case "QUIT":
+		c.reply(221, "Goodbye.")
+		return false
+	}
+	if c.drive == nil {
+		c.reply(530, "Please login with USER and PASS.")
+		return true
+	}
+	c.handleCommand(cmd, arg)
+	return true
+}
+
+func (c *ftpSession) handleCommand(cmd, arg string) {
+	switch cmd {
+	case "PWD", "XPWD":
+		c.reply(257, ftpQuote(c.abs(c.cwd))+" is the current directory.")
+	case "CWD", "XCWD":
+		c.cd(c.path(arg))
+	case "CDUP", "XCUP":
+		c.cd(utils.PathParent(c.cwd))
+	case "TYPE":
+		switch strings.ToUpper(strings.TrimSpace(arg)) {
+		// the content is always transferred as it is
+		case "A", "A N", "I", "L 8":
+			c.reply(200, "Type set to "+arg+".")
+		default:
+			c.reply(504, "Type not supported.")
+		}
+	case "MODE":
+		c.replyOption(arg, "S")
+	case "STRU":
+		c.replyOption(arg, "F")
+	case "ALLO":
+		c.reply(202, "No storage allocation necessary.")
+	case "PASV":
+		c.pasv()
+	case "EPSV":
+		c.epsv(arg)
+	case "PORT":
+		c.port(arg)
+	case "EPRT":
+		c.eprt(arg)
+	case "LIST", "NLST", "MLSD":
+		c.list(cmd, arg)
+	case "MLST":
+		c.mlst(arg)
+	case "SIZE", "MDTM":
+		c.stat(cmd, c.path(arg))
+	case "REST":
+		offset, e := strconv.ParseInt(strings.TrimSpace(arg), 10, 64)
+		if e != nil || offset < 0 {
+			c.reply(501, "Invalid offset.")
+			return
+		}
+		c.restOffset = offset
+		c.reply(350, fmt.Sprintf("Restarting at %d.", offset))
+	case "RETR":
+		c.retr(c.path(arg))
+	case "STOR":
+		c.stor(c.path(arg))
+	case "DELE":
+		c.dele(c.path(arg), false)
+	case "RMD", "XRMD":
+		c.dele(c.path(arg), true)
+	case "MKD", "XMKD":
+		c.mkd(c.path(arg))
+	case "RNFR":
+		c.rnfr(c.path(arg))
+	case "RNTO":
+		c.rnto(c.path(arg))
+	case "ABOR":
+		// the commands are handled one by one, so there is no transfer in progress
+		c.reply(226, "No transfer to abort.")
+	default:
+		c.reply(502, "Command not implemented.")
+	}
+}
+
+func (c *ftpSession) replyOption(arg, supported string) {
+	if strings.ToUpper(strings.TrimSpace(arg)) == supported {
+		c.reply(200, "OK.")
+	} else {
+		c.reply(504, "Not supported.")
+	}
+}
+
+func (c *ftpSession) pass(password string) bool {
+	if c.username == "" {
+		c.reply(503, "Login with USER first.")
+		return true
+	}
+	drive, e := c.s.login(c.username, password, c.conn.RemoteAddr().String())
+	if e != nil {
+		c.loginFails++
+		c.reply(530, "Login incorrect.")
+		return c.loginFails < ftpMaxLoginFails
+	}
+	c.drive, c.cwd = drive, ""
+	c.reply(230, "Logged in.")
+	return true
+}
+
+func (c *ftpSession) auth(arg string) bool {
+	if c.s.tlsConfig == nil {
+		c.reply(502, "TLS is not configured.")
+		return true
+	}
+	if t := strings.ToUpper(strings.TrimSpace(arg)); t != "TLS" && t != "SSL" && t != "TLS-C" {
+		c.reply(504, "Unknown mechanism.")
+		return true
+	}
+	if c.secure {
+		c.reply(503, "Already secured.")
+		return true
+	}
+	c.reply(234, "AUTH "+arg+" successful.")
+	conn := tls.Server(c.conn, c.s.tlsConfig)
+	if e := conn.Handshake(); e != nil {
+		return false
+	}
+	c.conn, c.r, c.secure = conn, bufio.NewReaderSize(conn, ftpMaxLineSize), true
+	return true
+}
+
+func (c *ftpSession) prot(arg string) {
+	if !c.secure {
+		c.reply(503, "PROT requires AUTH.")
+		return
+	}
+	switch strings.ToUpper(strings.TrimSpace(arg)) {
+	case "C":
+		c.protected = false
+	case "P":
+		c.protected = true
+	default:
+		c.reply(536, "Protection level not supported.")
+		return
+	}
+	c.reply(200, "Protection level set to "+arg+".")
+}
+
+func (c *ftpSession) feat() {
+	features := []string{"UTF8", "SIZE", "MDTM", "REST STREAM", "EPSV", "EPRT", "MLST type*;size*;modify*;"}
+	if c.s.tlsConfig != nil {
+		features = append(features, "AUTH TLS", "PBSZ", "PROT")
+	}
+	c.replyLines(211, "Features:", features, "End")
+}
+
+// abs returns the absolute FTP path of the drive path
+func (c *ftpSession) abs(path string) string {
+	return "/" + path
+}
+
+// path returns the drive path of the argument, which is relative to the current directory
+func (c *ftpSession) path(arg string) string {
+	if !strings.HasPrefix(arg, "/") {
+		arg = c.abs(c.cwd) + "/" + arg
+	}
+	return utils.CleanPath(arg)
+}
+
+func (c *ftpSession) cd(path string) {
+	if !utils.IsRootPath(path) {
+		entry, e := c.drive.Get(c.ctx, path)
+		if e != nil {
+			c.replyError(e)
+			return
+		}
+		if !entry.Type().IsDir() {
+			c.reply(550, "Not a directory.")
+			return
+		}
+	}
+	c.cwd = path
+	c.reply(250, "Directory changed to "+c.abs(path)+".")
+}
+
+func (c *ftpSession) closePassive() {
+	if c.passive != nil {
+		_ = c.passive.Close()
+		c.passive = nil
+	}
+}
+
+func (c *ftpSession) pasv() {
+	ip := c.s.publicIP
+	if ip == nil {
+		ip = c.conn.LocalAddr().(*net.TCPAddr).IP.To4()
+	}
+	if ip == nil {
+		c.reply(425, "Use EPSV with IPv6.")
+		return
+	}
+	port, ok := c.listenPassive()
+	if !ok {
+		return
+	}
+	c.reply(227, fmt.Sprintf("Entering Passive Mode (%d,%d,%d,%d,%d,%d).",
+		ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff))
+}
+
+func (c *ftpSession) epsv(arg string) {
+	if strings.ToUpper(strings.TrimSpace(arg)) == "ALL" {
+		c.reply(200, "EPSV ALL ok.")
+		return
+	}
+	port, ok := c.listenPassive()
+	if !ok {
+		return
+	}
+	c.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|).", port))
+}
+
+func (c *ftpSession) listenPassive() (int, bool) {
+	c.closePassive()
+	c.activeAddr = ""
+	l, e := c.s.listenPassive()
+	if e != nil {
+		c.reply(425, "Can't open passive connection.")
+		return 0, false
+	}
+	c.passive = l
+	return l.Addr().(*net.TCPAddr).Port, true
+}
+
+// port sets the address of the active data connection, which must be of the client to avoid the bounce attacks
+func (c *ftpSession) port(arg string) {
+	parts := strings.Split(strings.TrimSpace(arg), ",")
+	if len(parts) != 6 {
+		c.reply(501, "Invalid PORT.")
+		return
+	}
+	nums := make([]int, 6)
+	for i, p := range parts {
+		n, e := strconv.Atoi(p)
+		if e != nil || n < 0 || n > 255 {
+			c.reply(501, "Invalid PORT.")
+			return
+		}
+		nums[i] = n
+	}
+	c.setActive(net.IPv4(byte(nums[0]), byte(nums[1]), byte(nums[2]), byte(nums[3])), nums[4]<<8|nums[5])
+}
+
+// eprt parses the address like '|1|132.235.1.2|6275|' or '|2|1080::8:800:200C:417A|5282|'
+func (c *ftpSession) eprt(arg string) {
+	arg = strings.TrimSpace(arg)
+	if len(arg) < 2 {
+		c.reply(501, "Invalid EPRT.")
+		return
+	}
+	parts := strings.Split(arg[1:len(arg)-1], arg[:1])
+	if len(parts) != 3 {
+		c.reply(501, "Invalid EPRT.")
+		return
+	}
+	ip := net.ParseIP(parts[1])
+	port, e := strconv.Atoi(parts[2])
+	if ip == nil || e != nil {
+		c.reply(501, "Invalid EPRT.")
+		return
+	}
+	c.setActive(ip, port)
+}
+
+func (c *ftpSession) setActive(ip net.IP, port int) {
+	if !ip.Equal(c.conn.RemoteAddr().(*net.TCPAddr).IP) || port <= 0 || port > 65535 {
+		c.reply(500, "Illegal address.")
+		return
+	}
+	c.closePassive()
+	c.activeAddr = net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	c.reply(200, "Command successful.")
+}
+
+// openData opens the data connection set by PASV, EPSV, PORT or EPRT
+func (c *ftpSession) openData() (net.Conn, bool) {
+	var conn net.Conn
+	var e error
+	if c.passive != nil {
+		l := c.passive
+		c.passive = nil
+		_ = l.SetDeadline(time.Now().Add(ftpDataTimeout))
+		conn, e = l.Accept()
+		_ = l.Close()
+		if e == nil && !conn.RemoteAddr().(*net.TCPAddr).IP.Equal(c.conn.RemoteAddr().(*net.TCPAddr).IP) {
+			_ = conn.Close()
+			e = errors.New("data connection from another address")
+		}
+	} else if c.activeAddr != "" {
+		conn, e = net.DialTimeout("tcp", c.activeAddr, ftpDataTimeout)
+		c.activeAddr = ""
+	} else {
+		c.reply(425, "Use PASV or PORT first.")
+		return nil, false
+	}
+	if e != nil {
+		c.reply(425, "Can't open data connection.")
+		return nil, false
+	}
+	if c.protected {
+		tc := tls.Server(conn, c.s.tlsConfig)
+		_ = tc.SetDeadline(time.Now().Add(ftpDataTimeout))
+		if e := tc.Handshake(); e != nil {
+			_ = conn.Close()
+			c.reply(425, "TLS handshake of the data connection failed.")
+			return nil, false
+		}
+		conn = tc
+	}
+	return &ftpDataConn{conn}, true
+}
+
+// transfer opens the data connection and calls fn with it,
+// the control connection does not time out during the transfer
+func (c *ftpSession) transfer(fn func(conn net.Conn) error) {
+	c.reply(150, "Opening data connection.")
+	conn, ok := c.openData()
+	if !ok {
+		return
+	}
+	_ = c.conn.SetDeadline(time.Time{})
+	e := fn(conn)
+	ce := conn.Close()
+	if e == nil {
+		e = ce
+	}
+	if e != nil {
+		if _, ok := e.(net.Error); ok {
+			c.reply(426, "Connection closed, transfer aborted.")
+		} else {
+			c.replyError(e)
+		}
+		return
+	}
+	c.reply(226, "Transfer complete.")
+}
+
+// listArg strips the options of ls like '-la' from the argument of LIST
+func listArg(arg string) string {
+	for strings.HasPrefix(arg, "-") {
+		i := strings.IndexByte(arg, ' ')
+		if i < 0 {
+			return ""
+		}
+		arg = strings.TrimLeft(arg[i:], " ")
+	}
+	return arg
+}
+
+func (c *ftpSession) list(cmd, arg string) {
+	path := c.path(listArg(arg))
+	var entries []types.IEntry
+	if !utils.IsRootPath(path) {
+		entry, e := c.drive.Get(c.ctx, path)
+		if e != nil {
+			c.replyError(e)
+			return
+		}
+		if !entry.Type().IsDir() {
+			if cmd == "MLSD" {
+				c.reply(501, "Not a directory.")
+				return
+			}
+			entries = []types.IEntry{entry}
+		}
+	}
+	if entries == nil {
+		var e error
+		if entries, e = c.drive.List(c.ctx, path); e != nil {
+			c.replyError(e)
+			return
+		}
+	}
+	now := time.Now()
+	b := strings.Builder{}
+	for _, entry := range entries {
+		switch cmd {
+		case "LIST":
+			b.WriteString(listLine(entry, now))
+		case "NLST":
+			b.WriteString(utils.PathBase(entry.Path()))
+		case "MLSD":
+			b.WriteString(ftpFacts(entry) + " " + utils.PathBase(entry.Path()))
+		}
+		b.WriteString("\r\n")
+	}
+	c.transfer(func(conn net.Conn) error {
+		_, e := conn.Write([]byte(b.String()))
+		return e
+	})
+}
+
+func (c *ftpSession) mlst(arg string) {
+	path := c.path(arg)
+	var facts string
+	if utils.IsRootPath(path) {
+		facts = "type=dir;"
+	} else {
+		entry, e := c.drive.Get(c.ctx, path)
+		if e != nil {
+			c.replyError(e)
+			return
+		}
+		facts = ftpFacts(entry)
+	}
+	c.replyLines(250, "Listing "+c.abs(path), []string{facts + " " + c.abs(path)}, "End")
+}
+
+func (c *ftpSession) stat(cmd, path string) {
+	if utils.IsRootPath(path) {
+		c.reply(550, "Not a file.")
+		return
+	}
+	entry, e := c.drive.Get(c.ctx, path)
+	if e != nil {
+		c.replyError(e)
+		return
+	}
+	if !entry.Type().IsFile() {
+		c.reply(550, "Not a file.")
+		return
+	}
+	if cmd == "SIZE" {
+		c.reply(213, strconv.FormatInt(entry.Size(), 10))
+	} else {
+		c.reply(213, ftpTime(entry.ModTime()))
+	}
+}
+
+func (c *ftpSession) retr(path string) {
+	offset := c.restOffset
+	c.restOffset = 0
+	entry, e := c.drive.Get(c.ctx, path)
+	if e != nil {
+		c.replyError(e)
+		return
+	}
+	content, ok := entry.(types.IContent)
+	if !ok || !entry.Type().IsFile() {
+		c.reply(550, "Not a file.")
+		return
+	}
+	reader, e := drive_util.GetIContentRangeReader(c.ctx, content, offset, -1)
+	if e != nil {
+		c.replyError(e)
+		return
+	}
+	defer func() { _ = reader.Close() }()
+	c.transfer(func(conn net.Conn) error {
+		_, e := drive_util.Copy(task.DummyContext(), conn,
+			drive_util.ThrottledReader(reader, c.s.downloadRateLimit))
+		return e
+	})
+}
+
+// stor saves the uploaded file, which is spooled to a temp file first as the size is unknown
+func (c *ftpSession) stor(path string) {
+	offset := c.restOffset
+	c.restOffset = 0
+	if offset > 0 {
+		c.reply(504, "Resuming uploads is not supported.")
+		return
+	}
+	if utils.IsRootPath(path) {
+		c.reply(553, "File name not allowed.")
+		return
+	}
+	c.transfer(func(conn net.Conn) error {
+		file, e := drive_util.CopyReaderToTempFile(task.DummyContext(),
+			drive_util.ThrottledReader(conn, c.s.uploadRateLimit), c.s.tempDir)
+		if e != nil {
+			return e
+		}
+		defer func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}()
+		stat, e := file.Stat()
+		if e != nil {
+			return e
+		}
+		_, e = c.drive.Save(task.DummyContext(), path, stat.Size(), true, file)
+		return e
+	})
+}
+
+func (c *ftpSession) dele(path string, dir bool) {
+	if utils.IsRootPath(path) {
+		c.reply(550, "Permission denied.")
+		return
+	}
+	entry, e := c.drive.Get(c.ctx, path)
+	if e != nil {
+		c.replyError(e)
+		return
+	}
+	if entry.Type().IsDir() != dir {
+		if dir {
+			c.reply(550, "Not a directory.")
+		} else {
+			c.reply(550, "Not a file.")
+		}
+		return
+	}
+	if dir {
+		// RMD removes empty directories only, as the clients remove the children first
+		children, e := c.drive.List(c.ctx, path)
+		if e != nil {
+			c.replyError(e)
+			return
+		}
+		if len(children) > 0 {
+			c.reply(550, "Directory not empty.")
+			return
+		}
+	}
+	if e := c.drive.Delete(task.DummyContext(), path); e != nil {
+		c.replyError(e)
+		return
+	}
+	c.reply(250, "Deleted.")
+}
+
+func (c *ftpSession) mkd(path string) {
+	if utils.IsRootPath(path) {
+		c.reply(550, "Directory exists.")
+		return
+	}
+	if _, e := c.drive.MakeDir(c.ctx, path); e != nil {
+		c.replyError(e)
+		return
+	}
+	c.reply(257, ftpQuote(c.abs(path))+" created.")
+}
+
+func (c *ftpSession) rnfr(path string) {
+	if utils.IsRootPath(path) {
+		c.reply(550, "Permission denied.")
+		return
+	}
+	if _, e := c.drive.Get(c.ctx, path); e != nil {
+		c.replyError(e)
+		return
+	}
+	c.renameFrom = path
+	c.reply(350, "Ready for RNTO.")
+}
+
+func (c *ftpSession) rnto(path string) {
+	from := c.renameFrom
+	c.renameFrom = ""
+	if from == "" {
+		c.reply(503, "Use RNFR first.")
+		return
+	}
+	if utils.IsRootPath(path) {
+		c.reply(553, "File name not allowed.")
+		return
+	}
+	entry, e := c.drive.Get(c.ctx, from)
+	if e != nil {
+		c.replyError(e)
+		return
+	}
+	if _, e := c.drive.Move(task.DummyContext(), entry, path, true); e != nil {
+		c.replyError(e)
+		return
+	}
+	c.reply(250, "Renamed.")
+}
+
+// ftpDataConn is the data connection, which times out if idle
+type ftpDataConn struct {
+	net.Conn
+}
+
+func (d *ftpDataConn) Read(b []byte) (int, error) {
+	_ = d.Conn.SetDeadline(time.Now().Add(ftpIdleTimeout))
+	return d.Conn.Read(b)
+}
+
+func (d *ftpDataConn) Write(b []byte) (int, error) {
+	_ = d.Conn.SetDeadline(time.Now().Add(ftpIdleTimeout))
+	return d.Conn.Write(b)
+}
+
+// ftpQuote quotes the path in the replies of PWD and MKD, the quotes in it are doubled
+func ftpQuote(path string) string {
+	return `"` + strings.ReplaceAll(path, `"`, `""`) + `"`
+}
+
+func ftpTime(modTime int64) string {
+	if modTime <= 0 {
+		modTime = 0
+	}
+	return utils.Time(modTime).UTC().Format("20060102150405")
+}
+
+// listLine formats the entry like 'ls -l'
+func listLine(entry types.IEntry, now time.Time) string {
+	mode, size := "-rw-r--r--", entry.Size()
+	if entry.Type().IsDir() {
+		mode, size = "drwxr-xr-x", 0
+	}
+	if !entry.Meta().CanWrite {
+		mode = strings.ReplaceAll(mode, "w", "-")
+	}
+	if size < 0 {
+		size = 0
+	}
+	t := now
+	if entry.ModTime() > 0 {
+		t = utils.Time(entry.ModTime())
+	}
+	layout := "Jan _2 15:04"
+	if now.Sub(t) > 180*24*time.Hour || t.Sub(now) > 24*time.Hour {
+		layout = "Jan _2  2006"
+	}
+	return fmt.Sprintf("%s 1 owner group %12d %s %s", mode, size, t.Format(layout), utils.PathBase(entry.Path()))
+}
+
+// ftpFacts returns the facts of the entry in the replies of MLSD and MLST
+func ftpFacts(entry types.IEntry) string {
+	facts := "type=file;size=" + strconv.FormatInt(entry.Size(), 10) + ";"
+	if entry.Type().IsDir() {
+		facts = "type=dir;"
+	}
+	if entry.ModTime() > 0 {
+		facts += "modify=" + ftpTime(entry.ModTime()) + ";"
+	}
+	return facts
+}
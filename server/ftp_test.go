@@ -0,0 +1,166 @@
+package server
+
+import (
+	"go-drive/common"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// ftpTestClient sends the commands over the control connection, and transfers over the passive data connections
+type ftpTestClient struct {
+	t    *testing.T
+	conn *textproto.Conn
+	host string
+}
+
+func dialFTP(t *testing.T, addr string) *ftpTestClient {
+	conn, e := textproto.Dial("tcp", addr)
+	if e != nil {
+		t.Fatal(e)
+	}
+	c := &ftpTestClient{t: t, conn: conn, host: addr[:strings.LastIndexByte(addr, ':')]}
+	c.expect(220)
+	return c
+}
+
+// cmd sends the command and returns the code and the message of the reply
+func (c *ftpTestClient) cmd(format string, args ...interface{}) (int, string) {
+	if _, e := c.conn.Cmd(format, args...); e != nil {
+		c.t.Fatal(e)
+	}
+	return c.read()
+}
+
+func (c *ftpTestClient) read() (int, string) {
+	code, message, e := c.conn.ReadResponse(0)
+	if e != nil {
+		c.t.Fatal(e)
+	}
+	return code, message
+}
+
+func (c *ftpTestClient) expect(code int) string {
+	got, message := c.read()
+	if got != code {
+		c.t.Fatalf("expect %d, but got %d %s", code, got, message)
+	}
+	return message
+}
+
+// data enters the passive mode and opens the data connection
+func (c *ftpTestClient) data() net.Conn {
+	code, message := c.cmd("EPSV")
+	if code != 229 {
+		c.t.Fatalf("EPSV: %d %s", code, message)
+	}
+	port := message[strings.Index(message, "|||")+3 : strings.LastIndexByte(message, '|')]
+	conn, e := net.Dial("tcp", net.JoinHostPort(c.host, port))
+	if e != nil {
+		c.t.Fatal(e)
+	}
+	return conn
+}
+
+func (c *ftpTestClient) stor(path, content string) (int, string) {
+	conn := c.data()
+	if code, message := c.cmd("STOR %s", path); code != 150 {
+		_ = conn.Close()
+		return code, message
+	}
+	_, _ = conn.Write([]byte(content))
+	_ = conn.Close()
+	return c.read()
+}
+
+func (c *ftpTestClient) retr(cmd string) string {
+	conn := c.data()
+	defer func() { _ = conn.Close() }()
+	if code, message := c.cmd(cmd); code != 150 {
+		c.t.Fatalf("%s: %d %s", cmd, code, message)
+	}
+	dat, e := ioutil.ReadAll(conn)
+	if e != nil {
+		c.t.Fatal(e)
+	}
+	c.expect(226)
+	return string(dat)
+}
+
+func TestFTPServer(t *testing.T) {
+	s := newTestServer(t, common.Config{FTPListen: "127.0.0.1:0"})
+	defer s.close()
+	server, e := NewFTPServer(s.dr.config, s.dr.rootDrive, s.dr.permissionDAO, s.dr.signer,
+		s.dr.auditSink, s.userDAO, s.ch)
+	if e != nil {
+		t.Fatal(e)
+	}
+	go server.Serve()
+	addr := server.listener.Addr().String()
+
+	c := dialFTP(t, addr)
+	if code, _ := c.cmd("CWD /b"); code != 530 {
+		t.Errorf("the commands should not be served before logging in: %d", code)
+	}
+	c.cmd("USER admin")
+	if code, _ := c.cmd("PASS wrong"); code != 530 {
+		t.Errorf("the wrong password should be refused: %d", code)
+	}
+	c.cmd("USER admin")
+	if code, message := c.cmd("PASS 123456"); code != 230 {
+		t.Fatalf("login: %d %s", code, message)
+	}
+	c.cmd("TYPE I")
+
+	if code, message := c.stor("/b/a.txt", "hello"); code != 226 {
+		t.Fatalf("STOR: %d %s", code, message)
+	}
+	if code, message := c.cmd("SIZE /b/a.txt"); code != 213 || message != "5" {
+		t.Errorf("SIZE: %d %s", code, message)
+	}
+	if code, message := c.cmd("CWD /b"); code != 250 {
+		t.Fatalf("CWD: %d %s", code, message)
+	}
+	if got := c.retr("RETR a.txt"); got != "hello" {
+		t.Errorf("unexpected content '%s'", got)
+	}
+	c.cmd("REST 2")
+	if got := c.retr("RETR a.txt"); got != "llo" {
+		t.Errorf("unexpected content '%s' from the offset", got)
+	}
+	if got := c.retr("NLST"); got != "a.txt\r\n" {
+		t.Errorf("unexpected names '%s'", got)
+	}
+	if code, _ := c.cmd("RNFR a.txt"); code != 350 {
+		t.Fatalf("RNFR: %d", code)
+	}
+	if code, _ := c.cmd("RNTO c.txt"); code != 250 {
+		t.Fatalf("RNTO: %d", code)
+	}
+	if code, _ := c.cmd("DELE c.txt"); code != 250 {
+		t.Fatalf("DELE: %d", code)
+	}
+	if code, _ := c.cmd("SIZE c.txt"); code != 550 {
+		t.Errorf("expect the deleted file not found: %d", code)
+	}
+	if code, _ := c.cmd("QUIT"); code != 221 {
+		t.Errorf("QUIT: %d", code)
+	}
+	_ = c.conn.Close()
+
+	// the anonymous can read but can not write
+	c = dialFTP(t, addr)
+	defer func() { _ = c.conn.Close() }()
+	c.cmd("USER anonymous")
+	if code, _ := c.cmd("PASS guest"); code != 230 {
+		t.Fatalf("anonymous login: %d", code)
+	}
+	if code, _ := c.stor("/b/x.txt", "x"); code != 550 {
+		t.Errorf("the anonymous should not write: %d", code)
+	}
+	if code, _ := c.cmd("SIZE /b/x.txt"); code != 550 {
+		t.Errorf("the file should not be written: %d", code)
+	}
+}
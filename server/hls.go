@@ -0,0 +1,511 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"go-drive/storage"
+	"io/ioutil"
+	"log"
+	"mime"
+	"os"
+	"os/exec"
+	path2 "path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tokenKindHLS = "hls"
+	// hlsTokenTTL is the validity of the playlist URL, the segments are requested by the same token
+	hlsTokenTTL = 12 * time.Hour
+
+	hlsSegmentSeconds = 6
+	hlsProbeTimeout   = 30 * time.Second
+	// hlsWaitTimeout is the maximum time waiting for the playlist or a segment being transcoded
+	hlsWaitTimeout  = 30 * time.Second
+	hlsPollInterval = 200 * time.Millisecond
+
+	hlsPlaylist  = "index.m3u8"
+	hlsProbeFile = "probe.json"
+)
+
+var hlsSegmentRegexp = regexp.MustCompile(`^seg\d{5}\.ts$`)
+
+// hlsHWAccel is the ffmpeg arguments of a hardware acceleration
+type hlsHWAccel struct {
+	input      []string
+	deviceFlag string
+	encoder    string
+}
+
+var hlsHWAccels = map[string]hlsHWAccel{
+	"vaapi":        {[]string{"-hwaccel", "vaapi", "-hwaccel_output_format", "vaapi"}, "-vaapi_device", "h264_vaapi"},
+	"cuda":         {[]string{"-hwaccel", "cuda", "-hwaccel_output_format", "cuda"}, "-hwaccel_device", "h264_nvenc"},
+	"qsv":          {[]string{"-hwaccel", "qsv", "-hwaccel_output_format", "qsv"}, "-qsv_device", "h264_qsv"},
+	"videotoolbox": {[]string{"-hwaccel", "videotoolbox"}, "", "h264_videotoolbox"},
+}
+
+// the containers and the codecs the browsers can play directly
+var (
+	hlsDirectContainers  = map[string]bool{".mp4": true, ".m4v": true, ".mov": true, ".webm": true}
+	hlsDirectVideoCodecs = map[string]bool{"h264": true, "vp8": true, "vp9": true, "av1": true}
+	hlsDirectAudioCodecs = map[string]bool{"": true, "aac": true, "mp3": true, "opus": true, "vorbis": true, "flac": true}
+	// the codecs can be copied to the MPEG-TS segments without re-encoding
+	hlsCopyVideoCodecs = map[string]bool{"h264": true}
+	hlsCopyAudioCodecs = map[string]bool{"aac": true, "mp3": true}
+)
+
+// hlsProbe is the output of ffprobe
+type hlsProbe struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+	} `json:"streams"`
+	Format struct {
+		BitRate string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// codec returns the codec of the first stream of codecType, empty if there's no such stream
+func (p *hlsProbe) codec(codecType string) string {
+	for _, s := range p.Streams {
+		if s.CodecType == codecType {
+			return s.CodecName
+		}
+	}
+	return ""
+}
+
+func (p *hlsProbe) bitrate() int64 {
+	return utils.ToInt64(p.Format.BitRate, 0)
+}
+
+// HLS transcodes the videos to HLS by ffmpeg on demand, for the videos the browsers can't play.
+// The videos without browser playable codecs, or above the maximum bitrate are re-encoded to H.264/AAC,
+// otherwise the streams are copied to the segments.
+// Each version of a video is transcoded once, the segments are cached until they are expired,
+// and they are served while the transcoding is in progress.
+type HLS struct {
+	ffmpeg        string
+	ffprobe       string
+	hwaccel       string
+	hwaccelDevice string
+	maxBitrate    int64
+
+	cacheDir string
+	validity time.Duration
+
+	// sem limits the concurrent transcoding
+	sem  chan struct{}
+	mux  sync.Mutex
+	jobs map[string]*hlsJob
+
+	stopCleaner func()
+}
+
+type hlsJob struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	e      error
+}
+
+func NewHLS(config common.Config, rootDrive *drive.RootDrive, ch *registry.ComponentsHolder) (*HLS, error) {
+	if _, ok := hlsHWAccels[config.HLSHWAccel]; config.HLSHWAccel != "" && !ok {
+		return nil, fmt.Errorf("unsupported HLS hardware acceleration '%s'", config.HLSHWAccel)
+	}
+	dir, e := config.GetDir("hls", true)
+	if e != nil {
+		return nil, e
+	}
+	concurrent := config.HLSConcurrent
+	if concurrent <= 0 {
+		concurrent = 1
+	}
+	h := &HLS{
+		ffmpeg:        config.FFmpegPath,
+		ffprobe:       config.FFprobePath,
+		hwaccel:       config.HLSHWAccel,
+		hwaccelDevice: config.HLSHWAccelDevice,
+		maxBitrate:    config.HLSMaxBitrate,
+		cacheDir:      dir,
+		validity:      config.HLSCacheTTL,
+		sem:           make(chan struct{}, concurrent),
+		jobs:          make(map[string]*hlsJob),
+	}
+	h.stopCleaner = utils.TimeTick(h.clean, 1*time.Hour)
+	rootDrive.AddChangeListener(h.invalidate)
+	ch.Add("hls", h)
+	return h, nil
+}
+
+// IsVideo tells whether the entry is a video can be transcoded
+func IsVideo(entry types.IEntry) bool {
+	if _, ok := entry.(types.IContent); !ok || !entry.Type().IsFile() {
+		return false
+	}
+	return strings.HasPrefix(mime.TypeByExtension(strings.ToLower(path2.Ext(entry.Path()))), "video/")
+}
+
+func (h *HLS) getDirPrefix(path string) string {
+	key := md5.Sum([]byte(path))
+	return filepath.Join(h.cacheDir, fmt.Sprintf("%x-", key))
+}
+
+// getDir returns the cache dir of the version of the video
+func (h *HLS) getDir(entry types.IEntry) string {
+	return h.getDirPrefix(entry.Path()) + strconv.FormatInt(entry.ModTime(), 10) + "-" +
+		strconv.FormatInt(entry.Size(), 10)
+}
+
+// Probe returns the codecs of the video, which are cached with the segments
+func (h *HLS) Probe(ctx context.Context, entry types.IEntry) (*hlsProbe, error) {
+	dir := h.getDir(entry)
+	probe := &hlsProbe{}
+	if data, e := ioutil.ReadFile(filepath.Join(dir, hlsProbeFile)); e == nil {
+		if json.Unmarshal(data, probe) == nil {
+			return probe, nil
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, hlsProbeTimeout)
+	defer cancel()
+	out, e := runFFprobe(ctx, h.ffprobe, entry.(types.IContent),
+		"-show_entries", "format=bit_rate:stream=codec_type,codec_name")
+	if e != nil {
+		return nil, e
+	}
+	if e := json.Unmarshal(out, probe); e != nil {
+		return nil, e
+	}
+	if e := os.MkdirAll(dir, 0755); e != nil {
+		return nil, e
+	}
+	if e := ioutil.WriteFile(filepath.Join(dir, hlsProbeFile), out, 0644); e != nil {
+		return nil, e
+	}
+	return probe, nil
+}
+
+// NeedsTranscoding tells whether the video can't be played by the browsers directly
+func (h *HLS) NeedsTranscoding(entry types.IEntry, probe *hlsProbe) bool {
+	return !hlsDirectContainers[strings.ToLower(path2.Ext(entry.Path()))] ||
+		!hlsDirectVideoCodecs[probe.codec("video")] ||
+		!hlsDirectAudioCodecs[probe.codec("audio")] ||
+		h.exceedsBitrate(probe)
+}
+
+func (h *HLS) exceedsBitrate(probe *hlsProbe) bool {
+	return h.maxBitrate > 0 && probe.bitrate() > h.maxBitrate
+}
+
+// ffmpegArgs returns the arguments transcoding source to the HLS playlist and segments in dir
+func (h *HLS) ffmpegArgs(probe *hlsProbe, source, dir string) []string {
+	args := []string{"-y", "-v", "error"}
+	encodeVideo := !hlsCopyVideoCodecs[probe.codec("video")] || h.exceedsBitrate(probe)
+	accel, hw := hlsHWAccels[h.hwaccel]
+	hw = hw && encodeVideo
+	if hw {
+		args = append(args, accel.input...)
+		if h.hwaccelDevice != "" && accel.deviceFlag != "" {
+			args = append(args, accel.deviceFlag, h.hwaccelDevice)
+		}
+	}
+	args = append(args, "-i", source, "-map", "0:v:0", "-map", "0:a:0?")
+	if encodeVideo {
+		if hw {
+			args = append(args, "-c:v", accel.encoder)
+		} else {
+			args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p")
+		}
+		if h.maxBitrate > 0 {
+			b := strconv.FormatInt(h.maxBitrate, 10)
+			args = append(args, "-b:v", b, "-maxrate", b, "-bufsize", strconv.FormatInt(2*h.maxBitrate, 10))
+		}
+		// the segments are cut at the key frames
+		args = append(args, "-force_key_frames", "expr:gte(t,n_forced*"+strconv.Itoa(hlsSegmentSeconds)+")")
+	} else {
+		args = append(args, "-c:v", "copy")
+	}
+	if hlsCopyAudioCodecs[probe.codec("audio")] {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-ac", "2")
+	}
+	return append(args, "-f", "hls", "-hls_time", strconv.Itoa(hlsSegmentSeconds),
+		"-hls_playlist_type", "event", "-hls_segment_filename", filepath.Join(dir, "seg%05d.ts"),
+		filepath.Join(dir, hlsPlaylist))
+}
+
+// isComplete tells whether the transcoding of dir was finished
+func isHLSComplete(dir string) bool {
+	data, e := ioutil.ReadFile(filepath.Join(dir, hlsPlaylist))
+	return e == nil && bytes.Contains(data, []byte("#EXT-X-ENDLIST"))
+}
+
+// ensureJob starts the transcoding of the video if it's not transcoded or being transcoded,
+// returns nil if it's complete
+func (h *HLS) ensureJob(entry types.IEntry, probe *hlsProbe) *hlsJob {
+	dir := h.getDir(entry)
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if job, ok := h.jobs[dir]; ok {
+		return job
+	}
+	if isHLSComplete(dir) {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &hlsJob{done: make(chan struct{}), cancel: cancel}
+	h.jobs[dir] = job
+	go func() {
+		job.e = h.transcode(ctx, entry.(types.IContent), probe, dir)
+		if job.e != nil && ctx.Err() == nil {
+			log.Printf("error transcoding '%s' to HLS: %v", entry.Path(), job.e)
+		}
+		cancel()
+		h.mux.Lock()
+		delete(h.jobs, dir)
+		h.mux.Unlock()
+		close(job.done)
+	}()
+	return job
+}
+
+func (h *HLS) transcode(ctx context.Context, content types.IContent, probe *hlsProbe, dir string) error {
+	select {
+	case h.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-h.sem }()
+	if e := os.MkdirAll(dir, 0755); e != nil {
+		return e
+	}
+	// the segments of the incomplete transcoding are discarded
+	segments, _ := filepath.Glob(filepath.Join(dir, "seg*.ts"))
+	for _, s := range append(segments, filepath.Join(dir, hlsPlaylist)) {
+		_ = os.Remove(s)
+	}
+	reader, e := drive_util.GetIContentReader(ctx, content)
+	if e != nil {
+		return e
+	}
+	source, e := drive_util.CopyReaderToTempFile(task.DummyContext(), reader, dir)
+	_ = reader.Close()
+	if e != nil {
+		return e
+	}
+	_ = source.Close()
+	defer func() { _ = os.Remove(source.Name()) }()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.ffmpeg, h.ffmpegArgs(probe, source.Name(), dir)...)
+	cmd.Stderr = &stderr
+	if e := cmd.Run(); e != nil {
+		return fmt.Errorf("ffmpeg: %v %s", e, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// ServeFile serves the playlist or a segment of the video, it waits for a while if it's being transcoded
+func (h *HLS) ServeFile(c *gin.Context, entry types.IEntry, probe *hlsProbe, name string) error {
+	if name != hlsPlaylist && !hlsSegmentRegexp.MatchString(name) {
+		return err.NewNotFoundError()
+	}
+	dir := h.getDir(entry)
+	file := filepath.Join(dir, name)
+	job := h.ensureJob(entry, probe)
+	timeout := time.After(hlsWaitTimeout)
+	for {
+		if _, e := os.Stat(file); e == nil {
+			break
+		}
+		if job == nil {
+			return err.NewNotFoundError()
+		}
+		select {
+		case <-job.done:
+			if job.e != nil {
+				return job.e
+			}
+			job = nil
+		case <-timeout:
+			return err.NewTimeoutError(i18n.T("api.hls.not_ready"))
+		case <-c.Request.Context().Done():
+			return c.Request.Context().Err()
+		case <-time.After(hlsPollInterval):
+		}
+	}
+	if name == hlsPlaylist {
+		// the cache is kept while the video is being played
+		now := time.Now()
+		_ = os.Chtimes(dir, now, now)
+		c.Header("Content-Type", "application/vnd.apple.mpegurl")
+		c.Header("Cache-Control", "no-cache")
+	} else {
+		c.Header("Content-Type", "video/mp2t")
+		c.Header("Cache-Control", fmt.Sprintf("max-age=%d", int(h.validity.Seconds())))
+	}
+	c.File(file)
+	return nil
+}
+
+// cancelJobs cancels the transcoding of the dirs with prefix
+func (h *HLS) cancelJobs(prefix string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for dir, job := range h.jobs {
+		if strings.HasPrefix(dir, prefix) {
+			job.cancel()
+		}
+	}
+}
+
+// invalidate is called when the entry at path was modified,
+// the cache of the descendants of a directory are keyed by the version, so they are never served stale
+func (h *HLS) invalidate(path string) {
+	prefix := h.getDirPrefix(path)
+	h.cancelJobs(prefix)
+	dirs, e := filepath.Glob(prefix + "*")
+	if e != nil {
+		return
+	}
+	for _, d := range dirs {
+		if e := os.RemoveAll(d); e != nil {
+			log.Printf("error when removing HLS cache of '%s': %v", path, e)
+		}
+	}
+}
+
+func (h *HLS) clean() {
+	files, e := ioutil.ReadDir(h.cacheDir)
+	if e != nil {
+		log.Println("error when cleaning expired HLS cache", e)
+		return
+	}
+	notBefore := time.Now().Add(-h.validity)
+	n := 0
+	for _, f := range files {
+		if !f.IsDir() || !f.ModTime().Before(notBefore) {
+			continue
+		}
+		dir := filepath.Join(h.cacheDir, f.Name())
+		h.mux.Lock()
+		_, running := h.jobs[dir]
+		h.mux.Unlock()
+		if running {
+			continue
+		}
+		if e := os.RemoveAll(dir); e != nil {
+			log.Println("failed to delete HLS cache", e)
+		}
+		n++
+	}
+	if n > 0 {
+		log.Println(fmt.Sprintf("%d expired HLS cache cleaned", n))
+	}
+}
+
+func (h *HLS) Dispose() error {
+	h.stopCleaner()
+	h.cancelJobs("")
+	return nil
+}
+
+type hlsRoute struct {
+	dr      *driveRoute
+	hls     *HLS
+	tokens  *downloadTokens
+	userDAO *storage.UserDAO
+}
+
+func initHLSRoutes(router gin.IRouter, r gin.IRouter, dr *driveRoute, hls *HLS,
+	tokens *downloadTokens, userDAO *storage.UserDAO) {
+	h := &hlsRoute{dr: dr, hls: hls, tokens: tokens, userDAO: userDAO}
+	// whether the video needs transcoding, and the URL of its HLS playlist
+	r.GET("/hls-info/*path", h.getInfo)
+	// the playlist and the segments, the segments are relative to the playlist
+	router.GET("/hls/:token/:name", h.getFile)
+}
+
+// getVideo returns the video at path, which drive has HLS enabled
+func (h *hlsRoute) getVideo(c *gin.Context, session types.Session, path string) (types.IEntry, error) {
+	entry, e := h.dr.sessionDrive(c.Request, session).Get(c.Request.Context(), path)
+	if e != nil {
+		return nil, e
+	}
+	if !IsVideo(entry) {
+		return nil, err.NewNotAllowedMessageError(i18n.T("api.hls.not_video", utils.PathBase(path)))
+	}
+	if !h.dr.rootDrive.DriveOptions(path).HLS {
+		return nil, err.NewNotAllowedMessageError(i18n.T("api.hls.not_enabled", utils.PathBase(path)))
+	}
+	return entry, nil
+}
+
+func (h *hlsRoute) getInfo(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	entry, e := h.getVideo(c, GetSession(c), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	probe, e := h.hls.Probe(c.Request.Context(), entry)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	expiresAt := time.Now().Add(hlsTokenTTL)
+	SetResult(c, types.M{
+		"transcode":   h.hls.NeedsTranscoding(entry, probe),
+		"video_codec": probe.codec("video"),
+		"audio_codec": probe.codec("audio"),
+		"bit_rate":    probe.bitrate(),
+		// the playlist URL is relative to the API root
+		"playlist": "/hls/" + h.tokens.Mint(tokenKindHLS, GetSession(c).User.Username, path, expiresAt) +
+			"/" + hlsPlaylist,
+		"expires_at": utils.Millisecond(expiresAt),
+	})
+}
+
+func (h *hlsRoute) getFile(c *gin.Context) {
+	username, path, ok := h.tokens.Validate(tokenKindHLS, c.Param("token"))
+	if !ok {
+		_ = c.Error(err.NewNotFoundError())
+		return
+	}
+	session, e := tokenSession(h.userDAO, username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	entry, e := h.getVideo(c, session, path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	probe, e := h.hls.Probe(c.Request.Context(), entry)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if e := h.hls.ServeFile(c, entry, probe, c.Param("name")); e != nil {
+		_ = c.Error(e)
+	}
+}
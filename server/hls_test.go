@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"go-drive/common"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testHLSProbe(t *testing.T, s string) *hlsProbe {
+	probe := &hlsProbe{}
+	if e := json.Unmarshal([]byte(s), probe); e != nil {
+		t.Fatal(e)
+	}
+	return probe
+}
+
+func TestHLSTranscodingArgs(t *testing.T) {
+	h := &HLS{maxBitrate: 1000}
+	hevc := testHLSProbe(t, `{"streams":[{"codec_type":"video","codec_name":"hevc"},
+		{"codec_type":"audio","codec_name":"ac3"}],"format":{"bit_rate":"500"}}`)
+	h264 := testHLSProbe(t, `{"streams":[{"codec_type":"video","codec_name":"h264"},
+		{"codec_type":"audio","codec_name":"aac"}],"format":{"bit_rate":"500"}}`)
+	h264High := testHLSProbe(t, `{"streams":[{"codec_type":"video","codec_name":"h264"}],
+		"format":{"bit_rate":"2000"}}`)
+
+	for _, c := range []struct {
+		path     string
+		probe    *hlsProbe
+		expected bool
+	}{
+		{"a/v.mp4", h264, false},
+		{"a/v.MOV", h264, false},
+		{"a/v.mkv", h264, true},
+		{"a/v.mp4", hevc, true},
+		{"a/v.mp4", h264High, true},
+	} {
+		entry := &testEntry{path: c.path}
+		if got := h.NeedsTranscoding(entry, c.probe); got != c.expected {
+			t.Errorf("NeedsTranscoding of %s: expect %v", c.path, c.expected)
+		}
+	}
+
+	args := func(probe *hlsProbe) string {
+		return strings.Join(h.ffmpegArgs(probe, "source", "dir"), " ")
+	}
+	if a := args(h264); !strings.Contains(a, "-c:v copy") || !strings.Contains(a, "-c:a copy") {
+		t.Errorf("the streams should be copied: %s", a)
+	}
+	if a := args(hevc); !strings.Contains(a, "-c:v libx264") || !strings.Contains(a, "-c:a aac") {
+		t.Errorf("the streams should be re-encoded: %s", a)
+	}
+	if a := args(h264High); !strings.Contains(a, "-c:v libx264") || !strings.Contains(a, "-b:v 1000") {
+		t.Errorf("the video should be re-encoded to the maximum bitrate: %s", a)
+	}
+	h.hwaccel, h.hwaccelDevice = "vaapi", "/dev/dri/renderD128"
+	if a := args(hevc); !strings.Contains(a, "-vaapi_device /dev/dri/renderD128") ||
+		!strings.Contains(a, "-c:v h264_vaapi") {
+		t.Errorf("the video should be encoded by the hardware: %s", a)
+	}
+	if a := args(h264); strings.Contains(a, "vaapi") {
+		t.Errorf("the hardware should not be used for copying: %s", a)
+	}
+}
+
+func TestHLSServeFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+	s := newTestServer(t, common.Config{HLSCacheTTL: time.Hour})
+	defer s.close()
+	h, e := NewHLS(s.dr.config, s.dr.rootDrive, s.ch)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// the fake ffprobe and ffmpeg, ffmpeg writes the playlist(the last argument) and a segment, and logs the runs
+	runs := filepath.Join(s.dir, "ffmpeg.log")
+	h.ffprobe = filepath.Join(s.dir, "ffprobe.sh")
+	h.ffmpeg = filepath.Join(s.dir, "ffmpeg.sh")
+	for file, script := range map[string]string{
+		h.ffprobe: `cat > /dev/null
+echo '{"streams":[{"codec_type":"video","codec_name":"hevc"}],"format":{"bit_rate":"500"}}'`,
+		h.ffmpeg: `for a; do last="$a"; done
+echo run >> '` + runs + `'
+printf segment > "$(dirname "$last")/seg00000.ts"
+printf '#EXTM3U\n#EXTINF:6,\nseg00000.ts\n#EXT-X-ENDLIST\n' > "$last"`,
+	} {
+		if e := ioutil.WriteFile(file, []byte("#!/bin/sh\n"+script+"\n"), 0755); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	root := s.dr.rootDrive.Get()
+	if _, e := root.Save(task.DummyContext(), "b/v.mkv", 5, false, strings.NewReader("video")); e != nil {
+		t.Fatal(e)
+	}
+	entry, e := root.Get(task.DummyContext(), "b/v.mkv")
+	if e != nil {
+		t.Fatal(e)
+	}
+	probe, e := h.Probe(task.DummyContext(), entry)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if probe.codec("video") != "hevc" || !h.NeedsTranscoding(entry, probe) {
+		t.Errorf("unexpected probe %+v", probe)
+	}
+
+	serve := func(name string) (*httptest.ResponseRecorder, error) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/hls/token/"+name, nil)
+		e := h.ServeFile(c, entry, probe, name)
+		return w, e
+	}
+	for _, name := range []string{hlsPlaylist, "seg00000.ts", hlsPlaylist} {
+		w, e := serve(name)
+		if e != nil {
+			t.Fatalf("%s: %v", name, e)
+		}
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: %d", name, w.Code)
+		}
+	}
+	if w, _ := serve("seg00000.ts"); w.Body.String() != "segment" {
+		t.Errorf("unexpected segment '%s'", w.Body.String())
+	}
+	if _, e := serve("probe.json"); !err.IsNotFoundError(e) {
+		t.Errorf("the files other than the playlist and the segments should not be served: %v", e)
+	}
+	if dat, _ := ioutil.ReadFile(runs); string(dat) != "run\n" {
+		t.Errorf("the video should be transcoded once: '%s'", string(dat))
+	}
+
+	// the cache is removed when the video is modified
+	dir := h.getDir(entry)
+	if _, e := root.Save(task.DummyContext(), "b/v.mkv", 6, true, strings.NewReader("video2")); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := os.Stat(dir); !os.IsNotExist(e) {
+		t.Errorf("the cache should be removed: %v", e)
+	}
+}
+
+// testEntry is the entry of the path only
+type testEntry struct {
+	types.IEntry
+	path string
+}
+
+func (e *testEntry) Path() string { return e.path }
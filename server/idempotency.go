@@ -0,0 +1,66 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const headerIdempotencyKey = "Idempotency-Key"
+
+// Idempotent makes the mutating drive operation replayable by the Idempotency-Key header.
+//
+// Keys are scoped to the user and the drive being written, the drive is the first segment
+// of the `path` param or of the `to` query for copy/move.
+// A key is bound to the operation(method, route, path and query) that first used it,
+// only successful results are recorded, failed operations can be retried with the same key.
+func Idempotent(store types.IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader(headerIdempotencyKey))
+		if key == "" {
+			c.Next()
+			return
+		}
+		key = idempotencyScope(c) + "\n" + key
+		fingerprint := c.Request.Method + " " + c.FullPath() + " " + c.Request.URL.RequestURI()
+
+		result, done, e := store.Begin(key, fingerprint)
+		if e != nil {
+			_ = c.Error(e)
+			c.Abort()
+			return
+		}
+		if done {
+			SetResult(c, result)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+
+		result, _ = GetResult(c)
+		if len(c.Errors) > 0 || c.Writer.Status() >= http.StatusBadRequest {
+			e = store.Abort(key)
+		} else {
+			e = store.Complete(key, result)
+		}
+		if e != nil {
+			log.Printf("error recording idempotency key: %v", e)
+		}
+	}
+}
+
+func idempotencyScope(c *gin.Context) string {
+	p := c.Param("path")
+	if p == "" {
+		p = c.Query("to")
+	}
+	p = utils.CleanPath(p)
+	if i := strings.Index(p, "/"); i >= 0 {
+		p = p[:i]
+	}
+	return GetSession(c).User.Username + "\n" + p
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"image"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"os"
+	"os/exec"
+	path2 "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mediaInfoTimeout = 30 * time.Second
+	// mediaInfoHeaderSize is the bytes read from the beginning of the images, which contains the EXIF data
+	mediaInfoHeaderSize = 256 * 1024
+)
+
+// runFFprobe runs ffprobe on the content and returns the JSON output.
+// The URL of the content is passed to ffprobe if it's accessible directly,
+// otherwise the content is piped, then the mp4 files with the index at the end may fail.
+func runFFprobe(ctx context.Context, ffprobe string, content types.IContent, args ...string) ([]byte, error) {
+	args = append([]string{"-v", "error", "-print_format", "json"}, args...)
+	var stdin io.Reader
+	if u, e := content.GetURL(ctx); e == nil && !u.Proxy && len(u.Header) == 0 &&
+		(strings.HasPrefix(u.URL, "http://") || strings.HasPrefix(u.URL, "https://")) {
+		args = append(args, "-i", u.URL)
+	} else {
+		reader, e := content.GetReader(ctx)
+		if e != nil {
+			return nil, e
+		}
+		defer func() { _ = reader.Close() }()
+		stdin = reader
+		args = append(args, "-i", "pipe:0")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffprobe, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if e := cmd.Run(); e != nil {
+		return nil, fmt.Errorf("ffprobe: %v %s", e, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// mediaProbe is the output of ffprobe for the media info
+type mediaProbe struct {
+	Streams []struct {
+		CodecType  string `json:"codec_type"`
+		CodecName  string `json:"codec_name"`
+		Width      int    `json:"width"`
+		Height     int    `json:"height"`
+		FrameRate  string `json:"r_frame_rate"`
+		SampleRate string `json:"sample_rate"`
+		Channels   int    `json:"channels"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+type mediaInfoJson struct {
+	// Type is one of image, video and audio
+	Type     string  `json:"type"`
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+	Format   string  `json:"format,omitempty"`
+	BitRate  int64   `json:"bit_rate,omitempty"`
+
+	VideoCodec string  `json:"video_codec,omitempty"`
+	FrameRate  float64 `json:"frame_rate,omitempty"`
+	AudioCodec string  `json:"audio_codec,omitempty"`
+	SampleRate int     `json:"sample_rate,omitempty"`
+	Channels   int     `json:"channels,omitempty"`
+
+	Exif types.M        `json:"exif,omitempty"`
+	Tags *audioTagsJson `json:"tags,omitempty"`
+}
+
+// MediaInfo extracts the metadata of the media files, the EXIF data of the images,
+// the streams of the videos and the audios by ffprobe, and the tags of the audios.
+// The results are cached by the path and the version of the files.
+type MediaInfo struct {
+	ffprobe  string
+	cacheDir string
+	validity time.Duration
+
+	stopCleaner func()
+}
+
+func NewMediaInfo(config common.Config, rootDrive *drive.RootDrive, ch *registry.ComponentsHolder) (*MediaInfo, error) {
+	dir, e := config.GetDir("media_info", true)
+	if e != nil {
+		return nil, e
+	}
+	m := &MediaInfo{
+		ffprobe:  config.FFprobePath,
+		cacheDir: dir,
+		validity: config.MediaInfoCacheTTL,
+	}
+	m.stopCleaner = utils.TimeTick(m.clean, 12*time.Hour)
+	rootDrive.AddChangeListener(m.invalidate)
+	ch.Add("mediaInfo", m)
+	return m, nil
+}
+
+// mediaType returns the type of the media file, empty if it's not supported
+func mediaType(entry types.IEntry) string {
+	if _, ok := entry.(types.IContent); !ok || !entry.Type().IsFile() {
+		return ""
+	}
+	t := mime.TypeByExtension(strings.ToLower(path2.Ext(entry.Path())))
+	for _, prefix := range []string{"image", "video", "audio"} {
+		if strings.HasPrefix(t, prefix+"/") {
+			return prefix
+		}
+	}
+	return ""
+}
+
+func (m *MediaInfo) getFilePrefix(path string) string {
+	key := md5.Sum([]byte(path))
+	return filepath.Join(m.cacheDir, fmt.Sprintf("%x-", key))
+}
+
+func (m *MediaInfo) getFile(entry types.IEntry) string {
+	return m.getFilePrefix(entry.Path()) + strconv.FormatInt(entry.ModTime(), 10) + "-" +
+		strconv.FormatInt(entry.Size(), 10) + ".json"
+}
+
+// Get returns the media info of the entry
+func (m *MediaInfo) Get(ctx context.Context, entry types.IEntry) (*mediaInfoJson, error) {
+	t := mediaType(entry)
+	if t == "" {
+		return nil, err.NewNotAllowedMessageError(i18n.T("api.media_info.unsupported_file", utils.PathBase(entry.Path())))
+	}
+	file := m.getFile(entry)
+	info := &mediaInfoJson{}
+	if stat, e := os.Stat(file); e == nil && !stat.ModTime().Before(time.Now().Add(-m.validity)) {
+		if data, e := ioutil.ReadFile(file); e == nil && json.Unmarshal(data, info) == nil {
+			return info, nil
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, mediaInfoTimeout)
+	defer cancel()
+	info = &mediaInfoJson{Type: t}
+	content := entry.(types.IContent)
+	var e error
+	switch t {
+	case "image":
+		e = m.extractImage(ctx, content, info)
+	case "video":
+		e = m.extractStreams(ctx, content, info)
+	case "audio":
+		// the tags are enough if ffprobe is not available or failed
+		tags, _ := drive_util.ReadIContentAudioTags(ctx, content)
+		info.Tags = &audioTagsJson{Title: tags.Title, Artist: tags.Artist, Album: tags.Album,
+			Track: tags.Track, Year: tags.Year, Duration: tags.Duration}
+		info.Duration = tags.Duration
+		_ = m.extractStreams(ctx, content, info)
+	}
+	if e != nil {
+		return nil, e
+	}
+	if e := m.save(file, info); e != nil {
+		log.Printf("error when caching media info of '%s': %v", entry.Path(), e)
+	}
+	return info, nil
+}
+
+func (m *MediaInfo) extractImage(ctx context.Context, content types.IContent, info *mediaInfoJson) error {
+	reader, e := drive_util.GetIContentRangeReader(ctx, content, 0, mediaInfoHeaderSize)
+	if e != nil {
+		return e
+	}
+	header, e := ioutil.ReadAll(reader)
+	_ = reader.Close()
+	if e != nil {
+		return e
+	}
+	if conf, format, e := image.DecodeConfig(bytes.NewReader(header)); e == nil {
+		info.Width, info.Height, info.Format = conf.Width, conf.Height, format
+	}
+	if x, e := drive_util.ReadExif(bytes.NewReader(header)); e == nil {
+		info.Exif = exifToM(x)
+		if info.Width == 0 {
+			info.Width, info.Height = x.Width, x.Height
+		}
+	}
+	return nil
+}
+
+// exifToM converts the EXIF data to a map without the empty fields
+func exifToM(x *drive_util.Exif) types.M {
+	m := types.M{}
+	for k, v := range map[string]string{
+		"make": x.Make, "model": x.Model, "lens_model": x.LensModel, "software": x.Software,
+		"date_time": x.DateTime, "date_time_original": x.DateTimeOriginal, "exposure_time": x.ExposureTime,
+	} {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	for k, v := range map[string]float64{
+		"orientation": float64(x.Orientation), "f_number": x.FNumber,
+		"iso": float64(x.ISO), "focal_length": x.FocalLength,
+	} {
+		if v != 0 {
+			m[k] = v
+		}
+	}
+	if x.Flash {
+		m["flash"] = true
+	}
+	if x.HasGPS {
+		m["latitude"] = x.Latitude
+		m["longitude"] = x.Longitude
+		m["altitude"] = x.Altitude
+	}
+	return m
+}
+
+// extractStreams fills the format and the streams of info by ffprobe,
+// only the duration of the mp4 or wav files is known without ffprobe
+func (m *MediaInfo) extractStreams(ctx context.Context, content types.IContent, info *mediaInfoJson) error {
+	if m.ffprobe == "" {
+		if info.Duration == 0 {
+			info.Duration = drive_util.CreatePreview(ctx, content).Duration
+		}
+		return nil
+	}
+	out, e := runFFprobe(ctx, m.ffprobe, content, "-show_entries",
+		"format=format_name,duration,bit_rate:stream=codec_type,codec_name,width,height,r_frame_rate,sample_rate,channels")
+	if e != nil {
+		return e
+	}
+	probe := mediaProbe{}
+	if e := json.Unmarshal(out, &probe); e != nil {
+		return e
+	}
+	info.Format = probe.Format.FormatName
+	info.BitRate = utils.ToInt64(probe.Format.BitRate, 0)
+	if d, e := strconv.ParseFloat(probe.Format.Duration, 64); e == nil && d > 0 {
+		info.Duration = d
+	}
+	for _, s := range probe.Streams {
+		switch {
+		case s.CodecType == "video" && info.VideoCodec == "":
+			info.VideoCodec = s.CodecName
+			info.Width, info.Height = s.Width, s.Height
+			info.FrameRate = parseFrameRate(s.FrameRate)
+		case s.CodecType == "audio" && info.AudioCodec == "":
+			info.AudioCodec = s.CodecName
+			info.SampleRate = int(utils.ToInt64(s.SampleRate, 0))
+			info.Channels = s.Channels
+		}
+	}
+	return nil
+}
+
+// parseFrameRate parses the frame rate like '30000/1001'
+func parseFrameRate(s string) float64 {
+	parts := strings.SplitN(s, "/", 2)
+	num, e := strconv.ParseFloat(parts[0], 64)
+	if e != nil {
+		return 0
+	}
+	if len(parts) == 1 {
+		return num
+	}
+	den, e := strconv.ParseFloat(parts[1], 64)
+	if e != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// save writes the info to file atomically
+func (m *MediaInfo) save(file string, info *mediaInfoJson) error {
+	data, e := json.Marshal(info)
+	if e != nil {
+		return e
+	}
+	temp, e := ioutil.TempFile(m.cacheDir, "temp-")
+	if e != nil {
+		return e
+	}
+	_, e = temp.Write(data)
+	_ = temp.Close()
+	if e == nil {
+		e = os.Rename(temp.Name(), file)
+	}
+	if e != nil {
+		_ = os.Remove(temp.Name())
+	}
+	return e
+}
+
+// invalidate is called when the entry at path was modified
+func (m *MediaInfo) invalidate(path string) {
+	files, e := filepath.Glob(m.getFilePrefix(path) + "*")
+	if e != nil {
+		return
+	}
+	for _, f := range files {
+		if e := os.Remove(f); e != nil && !os.IsNotExist(e) {
+			log.Printf("error when removing media info of '%s': %v", path, e)
+		}
+	}
+}
+
+func (m *MediaInfo) clean() {
+	files, e := ioutil.ReadDir(m.cacheDir)
+	if e != nil {
+		log.Println("error when cleaning expired media info", e)
+		return
+	}
+	notBefore := time.Now().Add(-m.validity)
+	n := 0
+	for _, f := range files {
+		if f.ModTime().Before(notBefore) {
+			if e := os.Remove(filepath.Join(m.cacheDir, f.Name())); e != nil {
+				log.Println("failed to delete file", e)
+			}
+			n++
+		}
+	}
+	if n > 0 {
+		log.Println(fmt.Sprintf("%d expired media info cleaned", n))
+	}
+}
+
+func (m *MediaInfo) Dispose() error {
+	m.stopCleaner()
+	return nil
+}
+
+func initMediaInfoRoutes(r gin.IRouter, dr *driveRoute, mediaInfo *MediaInfo) {
+	// the metadata of an image, a video or an audio file
+	r.GET("/media-info/*path", func(c *gin.Context) {
+		entry, e := dr.getDrive(c).Get(c.Request.Context(), utils.CleanPath(c.Param("path")))
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		info, e := mediaInfo.Get(c.Request.Context(), entry)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		SetResult(c, info)
+	})
+}
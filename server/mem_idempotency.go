@@ -0,0 +1,109 @@
+package server
+
+import (
+	"go-drive/common"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/utils"
+	"log"
+	"sync"
+	"time"
+)
+
+type idempotencyRecord struct {
+	fingerprint string
+	done        bool
+	result      interface{}
+	expiresAt   time.Time
+}
+
+// MemIdempotencyStore is an in-memory types.IdempotencyStore,
+// records are kept for ttl after the operation completed
+type MemIdempotencyStore struct {
+	records map[string]*idempotencyRecord
+	ttl     time.Duration
+	mux     *sync.Mutex
+
+	tickerStop func()
+}
+
+// NewMemIdempotencyStore creates a MemIdempotencyStore
+func NewMemIdempotencyStore(config common.Config, ch *registry.ComponentsHolder) *MemIdempotencyStore {
+	s := &MemIdempotencyStore{
+		records: make(map[string]*idempotencyRecord),
+		ttl:     config.IdempotencyKeyTTL,
+		mux:     &sync.Mutex{},
+	}
+	if s.ttl > 0 {
+		s.tickerStop = utils.TimeTick(s.clean, s.ttl)
+	}
+	ch.Add("idempotencyStore", s)
+	return s
+}
+
+func (m *MemIdempotencyStore) Begin(key, fingerprint string) (interface{}, bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	r, ok := m.records[key]
+	if ok && r.done && time.Now().After(r.expiresAt) {
+		delete(m.records, key)
+		ok = false
+	}
+	if !ok {
+		m.records[key] = &idempotencyRecord{fingerprint: fingerprint}
+		return nil, false, nil
+	}
+	if r.fingerprint != fingerprint {
+		return nil, false, err.NewBadRequestError(i18n.T("api.idempotency.key_reused"))
+	}
+	if !r.done {
+		return nil, false, err.NewNotAllowedMessageError(i18n.T("api.idempotency.in_progress"))
+	}
+	return r.result, true, nil
+}
+
+func (m *MemIdempotencyStore) Complete(key string, result interface{}) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	r, ok := m.records[key]
+	if !ok {
+		return nil
+	}
+	r.done = true
+	r.result = result
+	r.expiresAt = time.Now().Add(m.ttl)
+	return nil
+}
+
+func (m *MemIdempotencyStore) Abort(key string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if r, ok := m.records[key]; ok && !r.done {
+		delete(m.records, key)
+	}
+	return nil
+}
+
+func (m *MemIdempotencyStore) clean() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	now := time.Now()
+	n := 0
+	for key, r := range m.records {
+		if r.done && now.After(r.expiresAt) {
+			delete(m.records, key)
+			n++
+		}
+	}
+	if n > 0 {
+		log.Printf("%d expired idempotency keys cleaned", n)
+	}
+}
+
+func (m *MemIdempotencyStore) Dispose() error {
+	if m.tickerStop != nil {
+		m.tickerStop()
+	}
+	return nil
+}
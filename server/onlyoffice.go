@@ -0,0 +1,280 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"log"
+	"net/url"
+	"os"
+	path2 "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tokenKindOnlyOfficeCallback = "onlyoffice-callback"
+	// onlyOfficeTokenTTL is the validity of the document URL and the callback URL,
+	// the callback comes some seconds after all the editors are closed
+	onlyOfficeTokenTTL = 24 * time.Hour
+
+	// the statuses of the callback in which the document should be saved
+	onlyOfficeStatusMustSave  = 2
+	onlyOfficeStatusForceSave = 6
+)
+
+// onlyOfficeDocumentTypes are the document types of the extensions which can be opened by the Document Server
+var onlyOfficeDocumentTypes = map[string]string{
+	"doc": "word", "docx": "word", "docm": "word", "dot": "word", "dotx": "word", "odt": "word",
+	"rtf": "word", "txt": "word", "pdf": "word", "epub": "word",
+	"xls": "cell", "xlsx": "cell", "xlsm": "cell", "xlt": "cell", "xltx": "cell", "ods": "cell", "csv": "cell",
+	"ppt": "slide", "pptx": "slide", "pptm": "slide", "pot": "slide", "potx": "slide", "odp": "slide",
+}
+
+// onlyOfficeEditable are the extensions which can be edited and saved back in place
+var onlyOfficeEditable = map[string]bool{"docx": true, "xlsx": true, "pptx": true}
+
+// onlyOfficeRoute integrates the OnlyOffice Document Server(https://api.onlyoffice.com/editors/basic).
+// The browser gets the editor config, in which the Document Server is told to download the document
+// by a download URL, and to post the callback to save the edited document.
+// Both URLs are signed tokens of the user, so the Document Server needs no session.
+// The document key is derived from the path, the modification time and the size,
+// so the editors of the same version share a session, and it's changed after being saved.
+type onlyOfficeRoute struct {
+	dr      *driveRoute
+	tokens  *downloadTokens
+	userDAO *storage.UserDAO
+}
+
+func initOnlyOfficeRoutes(router gin.IRouter, r gin.IRouter, dr *driveRoute,
+	tokens *downloadTokens, userDAO *storage.UserDAO) {
+	o := &onlyOfficeRoute{dr: dr, tokens: tokens, userDAO: userDAO}
+	// get the editor config of the document, ?mode=view to open it read-only
+	r.GET("/onlyoffice/config/*path", o.getConfig)
+	// the callback of the Document Server
+	router.POST("/onlyoffice/callback/:token", o.callback)
+}
+
+// onlyOfficeDocumentKey returns the key of the version of the document, which is at most 128 chars of [0-9a-zA-Z_-]
+func onlyOfficeDocumentKey(entry types.IEntry) string {
+	sum := sha256.Sum256([]byte(entry.Path() + "\n" +
+		strconv.FormatInt(entry.ModTime(), 10) + "\n" + strconv.FormatInt(entry.Size(), 10)))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (o *onlyOfficeRoute) baseURL(c *gin.Context) string {
+	if o.dr.config.OnlyOfficeCallbackURL != "" {
+		return strings.TrimSuffix(o.dr.config.OnlyOfficeCallbackURL, "/")
+	}
+	return apiBaseURL(c, "/onlyoffice/config")
+}
+
+func (o *onlyOfficeRoute) getConfig(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	entry, e := o.dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	name := utils.PathBase(path)
+	ext := strings.ToLower(strings.TrimPrefix(path2.Ext(name), "."))
+	documentType := onlyOfficeDocumentTypes[ext]
+	if _, ok := entry.(types.IContent); !ok || !entry.Type().IsFile() || documentType == "" {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.onlyoffice.unsupported_file", name)))
+		return
+	}
+	edit := entry.Meta().CanWrite && onlyOfficeEditable[ext] && c.Query("mode") != "view"
+	mode := "view"
+	if edit {
+		mode = "edit"
+	}
+	user := GetSession(c).User
+	userId, userName := user.Username, user.Username
+	if userId == "" {
+		userId, userName = "anonymous", "Anonymous"
+	}
+	expiresAt := time.Now().Add(onlyOfficeTokenTTL)
+	base := o.baseURL(c)
+	editorConfig := types.M{"mode": mode, "user": types.M{"id": userId, "name": userName}}
+	if edit {
+		// the callback saves the document, so it's only given to the editors who can write it
+		editorConfig["callbackUrl"] = base + "/onlyoffice/callback/" +
+			o.tokens.Mint(tokenKindOnlyOfficeCallback, user.Username, path, expiresAt)
+	}
+	config := types.M{
+		"document": types.M{
+			"fileType":    ext,
+			"key":         onlyOfficeDocumentKey(entry),
+			"title":       name,
+			"url":         base + o.tokens.DownloadURL(user.Username, path, expiresAt),
+			"permissions": types.M{"edit": edit, "download": true},
+		},
+		"documentType": documentType,
+		"editorConfig": editorConfig,
+	}
+	if secret := o.dr.config.OnlyOfficeSecret; secret != "" {
+		token, e := signJWT(secret, config)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		config["token"] = token
+	}
+	SetResult(c, types.M{"document_server_url": o.dr.config.OnlyOfficeURL, "config": config})
+}
+
+type onlyOfficeCallback struct {
+	Key    string `json:"key"`
+	Status int    `json:"status"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+}
+
+// callback responds {"error": 0} if the callback is handled, the Document Server tells the editors otherwise
+func (o *onlyOfficeRoute) callback(c *gin.Context) {
+	if e := o.handleCallback(c); e != nil {
+		log.Printf("error handling the OnlyOffice callback: %v", e)
+		SetResult(c, types.M{"error": 1})
+		return
+	}
+	SetResult(c, types.M{"error": 0})
+}
+
+func (o *onlyOfficeRoute) handleCallback(c *gin.Context) error {
+	username, path, ok := o.tokens.Validate(tokenKindOnlyOfficeCallback, c.Param("token"))
+	if !ok {
+		return errors.New("invalid callback token")
+	}
+	cb := onlyOfficeCallback{}
+	if e := json.NewDecoder(c.Request.Body).Decode(&cb); e != nil {
+		return e
+	}
+	if secret := o.dr.config.OnlyOfficeSecret; secret != "" {
+		// the callback is in the token of the body, or in the payload of the token in the Authorization header
+		if cb.Token != "" {
+			if e := parseJWT(secret, cb.Token, &cb); e != nil {
+				return e
+			}
+		} else {
+			wrapped := struct {
+				Payload *onlyOfficeCallback `json:"payload"`
+			}{&cb}
+			if e := parseJWT(secret, strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "), &wrapped); e != nil {
+				return e
+			}
+		}
+	}
+	if cb.Status != onlyOfficeStatusMustSave && cb.Status != onlyOfficeStatusForceSave {
+		return nil
+	}
+	session, e := tokenSession(o.userDAO, username)
+	if e != nil {
+		return e
+	}
+	d := o.dr.sessionDrive(c.Request, session)
+	// the user may have lost the permission, or the document may have been changed since the editor was opened
+	entry, e := d.Get(c.Request.Context(), path)
+	if e != nil {
+		return e
+	}
+	if !entry.Meta().CanWrite {
+		return errors.New("the document is not writable by " + username)
+	}
+	if cb.Key != onlyOfficeDocumentKey(entry) {
+		return errors.New("the document has been changed since it was opened")
+	}
+	if !sameOrigin(cb.URL, o.dr.config.OnlyOfficeURL) {
+		return errors.New("the edited document is not on the Document Server: " + cb.URL)
+	}
+	reader, e := drive_util.GetURL(c.Request.Context(), cb.URL, nil)
+	if e != nil {
+		return e
+	}
+	defer func() { _ = reader.Close() }()
+	file, e := drive_util.CopyReaderToTempFile(task.DummyContext(), reader, o.dr.config.TempDir)
+	if e != nil {
+		return e
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	stat, e := file.Stat()
+	if e != nil {
+		return e
+	}
+	_, e = d.Save(task.DummyContext(), path, stat.Size(), true, file)
+	return e
+}
+
+// sameOrigin returns true if u is of the scheme and the host of base
+func sameOrigin(u, base string) bool {
+	a, e := url.Parse(u)
+	if e != nil {
+		return false
+	}
+	b, e := url.Parse(base)
+	if e != nil {
+		return false
+	}
+	return a.Host != "" && strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host)
+}
+
+const jwtHeader = `{"alg":"HS256","typ":"JWT"}`
+
+// signJWT returns the HS256 JWT of the claims
+func signJWT(secret string, claims interface{}) (string, error) {
+	payload, e := json.Marshal(claims)
+	if e != nil {
+		return "", e
+	}
+	s := base64.RawURLEncoding.EncodeToString([]byte(jwtHeader)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return s + "." + base64.RawURLEncoding.EncodeToString(jwtMAC(secret, s)), nil
+}
+
+// parseJWT verifies the HS256 JWT, and unmarshals its claims to v
+func parseJWT(secret, token string, v interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed JWT")
+	}
+	header, e := base64.RawURLEncoding.DecodeString(parts[0])
+	if e != nil {
+		return e
+	}
+	alg := struct {
+		Alg string `json:"alg"`
+	}{}
+	if e := json.Unmarshal(header, &alg); e != nil {
+		return e
+	}
+	if alg.Alg != "HS256" {
+		return errors.New("unsupported JWT algorithm " + alg.Alg)
+	}
+	mac, e := base64.RawURLEncoding.DecodeString(parts[2])
+	if e != nil || !hmac.Equal(mac, jwtMAC(secret, parts[0]+"."+parts[1])) {
+		return errors.New("invalid JWT signature")
+	}
+	payload, e := base64.RawURLEncoding.DecodeString(parts[1])
+	if e != nil {
+		return e
+	}
+	return json.Unmarshal(payload, v)
+}
+
+func jwtMAC(secret, s string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
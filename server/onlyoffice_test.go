@@ -0,0 +1,110 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-drive/common"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOnlyOfficeCallback(t *testing.T) {
+	// the Document Server serving the edited document
+	var fetched int32
+	ds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		_, _ = w.Write([]byte("edited"))
+	}))
+	defer ds.Close()
+
+	s := newTestServer(t, common.Config{OnlyOfficeURL: ds.URL})
+	defer s.close()
+	root := s.dr.rootDrive.Get()
+	if _, e := root.Save(task.DummyContext(), "b/doc.docx", 5, false, strings.NewReader("hello")); e != nil {
+		t.Fatal(e)
+	}
+	tokens, e := newDownloadTokens(s.dr.config)
+	if e != nil {
+		t.Fatal(e)
+	}
+	h := s.engine()
+	// the session is of the user in X-User, or anonymous
+	r := h.Group("/", func(c *gin.Context) {
+		session := types.Session{}
+		if username := c.GetHeader("X-User"); username != "" {
+			user, e := s.userDAO.GetUser(username)
+			if e != nil {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+			session.User = user
+		}
+		SetSession(c, session)
+	})
+	initOnlyOfficeRoutes(h, r, s.dr, tokens, s.userDAO)
+
+	getConfig := func(user string) string {
+		req := httptest.NewRequest("GET", "/onlyoffice/config/b/doc.docx", nil)
+		req.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("config of '%s': %d %s", user, w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+	if body := getConfig(""); strings.Contains(body, "callbackUrl") {
+		t.Errorf("the read-only user should not get the callback: %s", body)
+	}
+	if body := getConfig("admin"); !strings.Contains(body, "callbackUrl") {
+		t.Errorf("the editor should get the callback: %s", body)
+	}
+
+	entry, e := root.Get(task.DummyContext(), "b/doc.docx")
+	if e != nil {
+		t.Fatal(e)
+	}
+	key := onlyOfficeDocumentKey(entry)
+	callback := func(user, key, u string) bool {
+		token := tokens.Mint(tokenKindOnlyOfficeCallback, user, "b/doc.docx", time.Now().Add(time.Hour))
+		body := `{"key":"` + key + `","status":` + strconv.Itoa(onlyOfficeStatusMustSave) + `,"url":"` + u + `"}`
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/onlyoffice/callback/"+token, strings.NewReader(body)))
+		return strings.Contains(w.Body.String(), `"error":0`)
+	}
+	if callback("", key, ds.URL+"/doc") {
+		t.Error("the callback of the read-only user should be refused")
+	}
+	if callback("admin", "stale", ds.URL+"/doc") {
+		t.Error("the callback of another version should be refused")
+	}
+	if callback("admin", key, "http://127.0.0.1:1/doc") {
+		t.Error("the document out of the Document Server should be refused")
+	}
+	if n := atomic.LoadInt32(&fetched); n != 0 {
+		t.Errorf("nothing should be fetched by the refused callbacks, but %d", n)
+	}
+	if !callback("admin", key, ds.URL+"/doc") {
+		t.Fatal("the callback should be handled")
+	}
+	entry, e = root.Get(task.DummyContext(), "b/doc.docx")
+	if e != nil {
+		t.Fatal(e)
+	}
+	reader, e := entry.(types.IContent).GetReader(task.DummyContext())
+	if e != nil {
+		t.Fatal(e)
+	}
+	dat, _ := ioutil.ReadAll(reader)
+	_ = reader.Close()
+	if string(dat) != "edited" {
+		t.Errorf("unexpected content '%s'", dat)
+	}
+}
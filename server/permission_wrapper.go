@@ -144,6 +144,31 @@ func (p *PermissionWrapperDrive) Move(ctx types.TaskCtx, from types.IEntry, to s
 	return &permissionWrapperEntry{p: p, entry: entry, permission: toPermission}, nil
 }
 
+func (p *PermissionWrapperDrive) Publish(ctx types.TaskCtx, staging, target string) (types.IEntry, error) {
+	publisher, ok := p.drive.(types.IPublisher)
+	if !ok {
+		return nil, err.NewUnsupportedError()
+	}
+	toPermission, e := p.requirePathAndParentWritable(target)
+	if e != nil {
+		return nil, e
+	}
+	if _, e := p.requirePathAndParentWritable(staging); e != nil {
+		return nil, e
+	}
+	if e := p.requireDescendantPermission(staging, types.PermissionReadWrite); e != nil {
+		return nil, e
+	}
+	if e := p.requireDescendantPermission(target, types.PermissionReadWrite); e != nil {
+		return nil, e
+	}
+	entry, e := publisher.Publish(ctx, staging, target)
+	if e != nil {
+		return nil, e
+	}
+	return &permissionWrapperEntry{p: p, entry: entry, permission: toPermission}, nil
+}
+
 func (p *PermissionWrapperDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
 	permission, e := p.permissionStorage.ResolvePathPermission(p.subjects, path)
 	if e != nil {
@@ -207,6 +232,73 @@ func (p *PermissionWrapperDrive) Upload(ctx context.Context, path string, size i
 	return p.drive.Upload(ctx, path, size, override, config)
 }
 
+// ResolveID finds the entry by its stable id, the entry is then read by its path,
+// so that it's not found if it's not readable.
+func (p *PermissionWrapperDrive) ResolveID(ctx context.Context, id string) (types.IEntry, error) {
+	resolver, ok := p.drive.(types.IIDResolver)
+	if !ok {
+		return nil, err.NewNotFoundError()
+	}
+	entry, e := resolver.ResolveID(ctx, id)
+	if e != nil {
+		return nil, e
+	}
+	return p.Get(ctx, entry.Path())
+}
+
+// Share requires the write permission, as the entry is exposed to the public
+func (p *PermissionWrapperDrive) Share(ctx context.Context, path string,
+	options types.ShareOptions) (*types.ShareLink, error) {
+	sharer, ok := p.drive.(types.ISharer)
+	if !ok {
+		return nil, err.NewUnsupportedError()
+	}
+	if _, e := p.requirePermission(path, types.PermissionReadWrite); e != nil {
+		return nil, e
+	}
+	return sharer.Share(ctx, path, options)
+}
+
+func (p *PermissionWrapperDrive) Space(ctx context.Context, path string) (int64, int64, error) {
+	reporter, ok := p.drive.(types.ISpaceReporter)
+	if !ok {
+		return 0, 0, err.NewUnsupportedError()
+	}
+	if _, e := p.requirePermission(path, types.PermissionRead); e != nil {
+		return 0, 0, e
+	}
+	return reporter.Space(ctx, path)
+}
+
+func (p *PermissionWrapperDrive) GetTail(ctx context.Context, path string, n int) ([]byte, int64, error) {
+	tailer, ok := p.drive.(types.ITailer)
+	if !ok {
+		return nil, 0, err.NewUnsupportedError()
+	}
+	if _, e := p.requirePermission(path, types.PermissionRead); e != nil {
+		return nil, 0, e
+	}
+	return tailer.GetTail(ctx, path, n)
+}
+
+func (p *PermissionWrapperDrive) Follow(ctx context.Context, path string,
+	offset int64, fn func([]byte, bool) error) error {
+	tailer, ok := p.drive.(types.ITailer)
+	if !ok {
+		return err.NewUnsupportedError()
+	}
+	if _, e := p.requirePermission(path, types.PermissionRead); e != nil {
+		return e
+	}
+	return tailer.Follow(ctx, path, offset, fn)
+}
+
+// CanRead tells whether the path is readable, the entry is not checked
+func (p *PermissionWrapperDrive) CanRead(path string) bool {
+	_, e := p.requirePermission(path, types.PermissionRead)
+	return e == nil
+}
+
 func (p *PermissionWrapperDrive) requirePathAndParentWritable(path string) (types.Permission, error) {
 	if !utils.IsRootPath(path) {
 		perm, e := p.requirePermission(utils.PathParent(path), types.PermissionReadWrite)
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"mime"
+	"net/http"
+	"net/url"
+	path2 "path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tokenKindPlaylist = "playlist"
+	// playlistTokenTTL is the default validity of the playlist URLs and the URLs of the tracks in it
+	playlistTokenTTL = 7 * 24 * time.Hour
+	playlistMaxTTL   = 30 * 24 * time.Hour
+
+	playlistTagWorkers = 8
+	playlistTagTimeout = 10 * time.Second
+)
+
+// playlistRoute serves the directories of audio files as m3u playlists, which can be opened by the standard players.
+// The tracks are the signed download URLs, which support Range requests for seeking and gapless playback,
+// and they are ordered by the album and the track number of the tags, then by the natural order of the names.
+type playlistRoute struct {
+	dr      *driveRoute
+	tokens  *downloadTokens
+	userDAO *storage.UserDAO
+}
+
+func initPlaylistRoutes(router gin.IRouter, r gin.IRouter, dr *driveRoute,
+	tokens *downloadTokens, userDAO *storage.UserDAO) {
+	p := &playlistRoute{dr: dr, tokens: tokens, userDAO: userDAO}
+	// the playlist of the audio files in the directory, ?format=m3u|m3u8
+	r.GET("/playlist/*path", p.getPlaylist)
+	// mint a playlist URL for the players, valid for ?expires_in seconds(7 days by default, 30 days at most)
+	r.POST("/playlist-url/*path", p.createURL)
+	// the playlist by the token, the name is for the players recognizing the playlist by the extension
+	router.GET("/pl/:token/:name", p.getPlaylistByToken)
+	// the tags of an audio file
+	r.GET("/audio-tags/*path", p.getTags)
+}
+
+// IsAudio tells whether the entry is an audio file
+func IsAudio(entry types.IEntry) bool {
+	if _, ok := entry.(types.IContent); !ok || !entry.Type().IsFile() {
+		return false
+	}
+	return strings.HasPrefix(mime.TypeByExtension(strings.ToLower(path2.Ext(entry.Path()))), "audio/")
+}
+
+type audioTagsJson struct {
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist"`
+	Album    string  `json:"album"`
+	Track    string  `json:"track"`
+	Year     string  `json:"year"`
+	Duration float64 `json:"duration"`
+}
+
+func (p *playlistRoute) getTags(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	entry, e := p.dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if !IsAudio(entry) {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.playlist.not_audio", utils.PathBase(path))))
+		return
+	}
+	tags, _ := drive_util.ReadIContentAudioTags(c.Request.Context(), entry.(types.IContent))
+	SetResult(c, audioTagsJson{
+		Title:    tags.Title,
+		Artist:   tags.Artist,
+		Album:    tags.Album,
+		Track:    tags.Track,
+		Year:     tags.Year,
+		Duration: tags.Duration,
+	})
+}
+
+func (p *playlistRoute) createURL(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	ttl := playlistTokenTTL
+	if s := c.Query("expires_in"); s != "" {
+		seconds := utils.ToInt64(s, -1)
+		if seconds <= 0 || time.Duration(seconds)*time.Second > playlistMaxTTL {
+			_ = c.Error(err.NewBadRequestError(i18n.T("api.playlist.invalid_expires_in", s)))
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	entry, e := p.dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if entry.Type().IsFile() {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	expiresAt := time.Now().Add(ttl)
+	name := utils.PathBase(path)
+	if name == "" {
+		name = "playlist"
+	}
+	SetResult(c, downloadURLJson{
+		URL: "/pl/" + p.tokens.Mint(tokenKindPlaylist, GetSession(c).User.Username, path, expiresAt) +
+			"/" + url.PathEscape(name+".m3u8"),
+		ExpiresAt: utils.Millisecond(expiresAt),
+	})
+}
+
+func (p *playlistRoute) getPlaylist(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	format := c.DefaultQuery("format", "m3u8")
+	if format != "m3u" && format != "m3u8" {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.playlist.invalid_format", format)))
+		return
+	}
+	name := utils.PathBase(path)
+	if name == "" {
+		name = "playlist"
+	}
+	p.writePlaylist(c, GetSession(c), path, apiBaseURL(c, "/playlist"), name+"."+format)
+}
+
+func (p *playlistRoute) getPlaylistByToken(c *gin.Context) {
+	username, path, ok := p.tokens.Validate(tokenKindPlaylist, c.Param("token"))
+	if !ok {
+		_ = c.Error(err.NewNotFoundError())
+		return
+	}
+	session, e := tokenSession(p.userDAO, username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	base := apiBaseURL(c, "/pl/"+c.Param("token")+"/"+c.Param("name"))
+	p.writePlaylist(c, session, path, base, "")
+}
+
+type playlistTrack struct {
+	entry types.IEntry
+	tags  drive_util.AudioTags
+}
+
+// writePlaylist writes the extended m3u playlist of the audio files in the directory,
+// the tracks are the download URLs relative to base. It's sent as an attachment if filename is not empty
+func (p *playlistRoute) writePlaylist(c *gin.Context, session types.Session, path, base, filename string) {
+	drive := p.dr.sessionDrive(c.Request, session)
+	entries, e := drive.List(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	tracks := make([]playlistTrack, 0)
+	for _, entry := range entries {
+		if IsAudio(entry) {
+			tracks = append(tracks, playlistTrack{entry: entry})
+		}
+	}
+	readTracksTags(c.Request.Context(), tracks)
+	sort.SliceStable(tracks, func(i, j int) bool {
+		a, b := tracks[i], tracks[j]
+		if a.tags.Album != b.tags.Album {
+			return drive_util.NaturalLess(a.tags.Album, b.tags.Album)
+		}
+		if na, nb := a.tags.TrackNumber(), b.tags.TrackNumber(); na != nb && na > 0 && nb > 0 {
+			return na < nb
+		}
+		return drive_util.NaturalLess(utils.PathBase(a.entry.Path()), utils.PathBase(b.entry.Path()))
+	})
+
+	expiresAt := time.Now().Add(playlistTokenTTL)
+	username := session.User.Username
+	sb := strings.Builder{}
+	sb.WriteString("#EXTM3U\n")
+	if name := utils.PathBase(path); name != "" {
+		sb.WriteString("#PLAYLIST:" + m3uText(name) + "\n")
+	}
+	for _, t := range tracks {
+		duration := -1
+		if t.tags.Duration > 0 {
+			duration = int(t.tags.Duration + 0.5)
+		}
+		title := t.tags.Title
+		if title == "" {
+			title = strings.TrimSuffix(utils.PathBase(t.entry.Path()), path2.Ext(t.entry.Path()))
+		}
+		if t.tags.Artist != "" {
+			title = t.tags.Artist + " - " + title
+		}
+		sb.WriteString("#EXTINF:" + strconv.Itoa(duration) + "," + m3uText(title) + "\n")
+		if t.tags.Album != "" {
+			sb.WriteString("#EXTALB:" + m3uText(t.tags.Album) + "\n")
+		}
+		sb.WriteString(base + p.tokens.DownloadURL(username, t.entry.Path(), expiresAt) + "\n")
+	}
+	if filename != "" {
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", types.SM{"filename": filename}))
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "audio/x-mpegurl; charset=utf-8", []byte(sb.String()))
+}
+
+// readTracksTags reads the tags of the tracks concurrently, the tags are empty if failed or timed out
+func readTracksTags(ctx context.Context, tracks []playlistTrack) {
+	sem := make(chan struct{}, playlistTagWorkers)
+	wg := sync.WaitGroup{}
+	for i := range tracks {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(t *playlistTrack) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			tCtx, cancel := context.WithTimeout(ctx, playlistTagTimeout)
+			defer cancel()
+			t.tags, _ = drive_util.ReadIContentAudioTags(tCtx, t.entry.(types.IContent))
+		}(&tracks[i])
+	}
+	wg.Wait()
+}
+
+// m3uText removes the line breaks, which can't be in the lines of a playlist
+func m3uText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
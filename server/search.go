@@ -0,0 +1,330 @@
+package server
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"go-drive/storage"
+	"log"
+	path2 "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	searchDefaultLimit = 50
+	searchMaxLimit     = 200
+	// searchMaxPages is the maximum number of the pages read from the index for a request,
+	// the hits which are not readable by the user are skipped
+	searchMaxPages = 10
+
+	// searchChangesDelay is the delay of indexing the changed paths, to merge the changes in a burst
+	searchChangesDelay   = 5 * time.Second
+	searchContentTimeout = 30 * time.Second
+)
+
+// Search maintains the search index of the entries under the configured roots.
+// The roots are reindexed periodically, and the changed paths are reindexed when the drives notify.
+// Each directory is reconciled with its indexed children, so the contents of the unchanged files are not read again
+type Search struct {
+	roots          []string
+	contentMaxSize int64
+	interval       time.Duration
+	rootDrive      *drive.RootDrive
+	dao            *storage.SearchIndexDAO
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mux     sync.Mutex
+	changes map[string]struct{}
+	notify  chan struct{}
+}
+
+func NewSearch(config common.Config, rootDrive *drive.RootDrive,
+	dao *storage.SearchIndexDAO, ch *registry.ComponentsHolder) (*Search, error) {
+	roots := make([]string, 0)
+	for _, p := range strings.Split(config.SearchPaths, ",") {
+		if strings.TrimSpace(p) != "" {
+			roots = append(roots, utils.CleanPath(p))
+		}
+	}
+	// the entries of the roots no longer configured are removed
+	if e := dao.Retain(roots); e != nil {
+		return nil, e
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Search{
+		roots:          roots,
+		contentMaxSize: config.SearchContentMaxSize,
+		interval:       config.SearchReindexInterval,
+		rootDrive:      rootDrive,
+		dao:            dao,
+		ctx:            ctx,
+		cancel:         cancel,
+		changes:        make(map[string]struct{}),
+		notify:         make(chan struct{}, 1),
+	}
+	if len(roots) > 0 {
+		rootDrive.AddChangeListener(s.onChange)
+		go s.run()
+	}
+	ch.Add("search", s)
+	return s, nil
+}
+
+func (s *Search) run() {
+	var tick <-chan time.Time
+	if s.interval > 0 {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	s.reindexAll()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-tick:
+			s.reindexAll()
+		case <-s.notify:
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(searchChangesDelay):
+			}
+			s.mux.Lock()
+			changes := s.changes
+			s.changes = make(map[string]struct{})
+			s.mux.Unlock()
+			for path := range changes {
+				s.reindex(path)
+			}
+		}
+	}
+}
+
+// onChange queues the changed path to be reindexed. If it's an ancestor of the roots, the roots are reindexed
+func (s *Search) onChange(path string) {
+	paths := make([]string, 0, 1)
+	for _, root := range s.roots {
+		if isDescendantOrSelf(path, root) {
+			paths = []string{path}
+			break
+		}
+		if isDescendantOrSelf(root, path) {
+			paths = append(paths, root)
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+	s.mux.Lock()
+	for _, p := range paths {
+		s.changes[p] = struct{}{}
+	}
+	s.mux.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Search) reindexAll() {
+	start := time.Now()
+	for _, root := range s.roots {
+		s.reindex(root)
+	}
+	if utils.IsDebugOn() {
+		log.Printf("search index of %v updated in %v", s.roots, time.Since(start))
+	}
+}
+
+// reindex updates the entry at path and its descendants, it's removed from the index if it no longer exists
+func (s *Search) reindex(path string) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	if utils.IsRootPath(path) {
+		s.indexDir("")
+		return
+	}
+	entry, e := s.rootDrive.Get().Get(s.ctx, path)
+	if err.IsNotFoundError(e) {
+		if e := s.dao.Delete(path); e != nil {
+			log.Printf("error when removing '%s' from the search index: %v", path, e)
+		}
+		return
+	}
+	if e != nil {
+		log.Printf("error when indexing '%s': %v", path, e)
+		return
+	}
+	s.put(entry)
+	if entry.Type().IsDir() {
+		s.indexDir(path)
+	}
+}
+
+// indexDir reconciles the indexed descendants of the directory with the ones in the drive
+func (s *Search) indexDir(dir string) {
+	root := s.rootDrive.Get()
+	queue := []string{dir}
+	for len(queue) > 0 && s.ctx.Err() == nil {
+		dir := queue[0]
+		queue = queue[1:]
+		entries, e := root.List(s.ctx, dir)
+		if e != nil {
+			// the indexed children are kept if the directory is not available for now
+			log.Printf("error when indexing '%s': %v", dir, e)
+			continue
+		}
+		indexedEntries, e := s.dao.GetChildren(dir)
+		if e != nil {
+			log.Printf("error when indexing '%s': %v", dir, e)
+			continue
+		}
+		indexed := make(map[string]types.SearchEntry, len(indexedEntries))
+		for _, ie := range indexedEntries {
+			indexed[ie.Path] = ie
+		}
+		for _, entry := range entries {
+			if entry.Type().IsDir() {
+				queue = append(queue, entry.Path())
+			}
+			ie, ok := indexed[entry.Path()]
+			delete(indexed, entry.Path())
+			if ok && ie.Type == entry.Type() && ie.Size == entry.Size() && ie.ModTime == entry.ModTime() {
+				continue
+			}
+			s.put(entry)
+		}
+		for path := range indexed {
+			if e := s.dao.Delete(path); e != nil {
+				log.Printf("error when removing '%s' from the search index: %v", path, e)
+			}
+		}
+	}
+}
+
+// put indexes the entry, the text content is indexed if it's small enough
+func (s *Search) put(entry types.IEntry) {
+	name := utils.PathBase(entry.Path())
+	ext := ""
+	content := ""
+	if entry.Type().IsFile() {
+		ext = strings.TrimPrefix(strings.ToLower(path2.Ext(name)), ".")
+		if c, ok := entry.(types.IContent); ok && s.contentMaxSize > 0 &&
+			entry.Size() <= s.contentMaxSize && drive_util.MaybeText(name) {
+			ctx, cancel := context.WithTimeout(s.ctx, searchContentTimeout)
+			content, _ = drive_util.ReadIContentText(ctx, c, s.contentMaxSize)
+			cancel()
+		}
+	}
+	e := s.dao.Put(types.SearchEntry{
+		Path:    entry.Path(),
+		Name:    name,
+		Type:    entry.Type(),
+		Ext:     ext,
+		Size:    entry.Size(),
+		ModTime: entry.ModTime(),
+	}, content)
+	if e != nil {
+		log.Printf("error when indexing '%s': %v", entry.Path(), e)
+	}
+}
+
+func (s *Search) Dispose() error {
+	s.cancel()
+	return nil
+}
+
+// isDescendantOrSelf tells whether path is root or in root
+func isDescendantOrSelf(path, root string) bool {
+	return utils.IsRootPath(root) || path == root || strings.HasPrefix(path, root+"/")
+}
+
+type searchJson struct {
+	Entries []types.SearchEntry `json:"entries"`
+	// NextOffset is the offset of the next page, 0 if there are no more results
+	NextOffset int `json:"next_offset,omitempty"`
+}
+
+func initSearchRoutes(r gin.IRouter, dr *driveRoute, search *Search) {
+	// search the entries by the names, ?q=words&path=dir&type=file|dir&ext=pdf,docx&content=1&offset=&limit=
+	r.GET("/search", func(c *gin.Context) {
+		q := storage.SearchQuery{
+			Text:    strings.TrimSpace(c.Query("q")),
+			Root:    utils.CleanPath(c.Query("path")),
+			Type:    types.EntryType(c.Query("type")),
+			Content: c.Query("content") != "",
+		}
+		if q.Text == "" {
+			_ = c.Error(err.NewBadRequestError(i18n.T("api.search.empty_query")))
+			return
+		}
+		if q.Type != "" && !q.Type.IsFile() && !q.Type.IsDir() {
+			_ = c.Error(err.NewBadRequestError(i18n.T("api.search.invalid_type", string(q.Type))))
+			return
+		}
+		for _, ext := range strings.Split(c.Query("ext"), ",") {
+			ext = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+			if ext != "" {
+				q.Exts = append(q.Exts, ext)
+			}
+		}
+		limit := int(utils.ToInt64(c.Query("limit"), searchDefaultLimit))
+		if limit <= 0 {
+			limit = searchDefaultLimit
+		}
+		if limit > searchMaxLimit {
+			limit = searchMaxLimit
+		}
+		q.Offset = int(utils.ToInt64(c.Query("offset"), 0))
+		if q.Offset < 0 {
+			q.Offset = 0
+		}
+		q.Limit = limit
+		res, e := search.search(dr, c, q)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		SetResult(c, res)
+	})
+}
+
+// search reads the index page by page, and drops the hits which are not readable by the user
+func (s *Search) search(dr *driveRoute, c *gin.Context, q storage.SearchQuery) (searchJson, error) {
+	p := NewPermissionWrapperDrive(c.Request, GetSession(c), dr.rootDrive.Get(), dr.permissionDAO, dr.signer)
+	res := searchJson{Entries: make([]types.SearchEntry, 0, q.Limit)}
+	limit := q.Limit
+	for page := 0; page < searchMaxPages; page++ {
+		entries, e := s.dao.Search(q)
+		if e != nil {
+			return res, e
+		}
+		for i, entry := range entries {
+			if len(res.Entries) == limit {
+				res.NextOffset = q.Offset + i
+				return res, nil
+			}
+			if p.CanRead(entry.Path) {
+				res.Entries = append(res.Entries, entry)
+			}
+		}
+		if len(entries) < q.Limit {
+			return res, nil
+		}
+		q.Offset += len(entries)
+	}
+	res.NextOffset = q.Offset
+	return res, nil
+}
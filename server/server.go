@@ -22,9 +22,15 @@ func InitServer(config common.Config,
 	ch *registry.ComponentsHolder,
 	rootDrive *drive.RootDrive,
 	tokenStore types.TokenStore,
+	idempotencyStore types.IdempotencyStore,
+	auditSink types.AuditSink,
 	thumbnail *Thumbnail,
+	hls *HLS,
+	mediaInfo *MediaInfo,
+	search *Search,
 	signer *utils.Signer,
 	chunkUploader *ChunkUploader,
+	deleteCheckpoints *DeleteCheckpoints,
 	runner task.Runner,
 	userDAO *storage.UserDAO,
 	groupDAO *storage.GroupDAO,
@@ -33,6 +39,7 @@ func InitServer(config common.Config,
 	driveDataDAO *storage.DriveDataDAO,
 	permissionDAO *storage.PathPermissionDAO,
 	pathMountDAO *storage.PathMountDAO,
+	shareDAO *storage.ShareDAO,
 	messageSource i18n.MessageSource) *gin.Engine {
 
 	if utils.IsDebugOn() {
@@ -52,8 +59,8 @@ func InitServer(config common.Config,
 	InitAdminRoutes(engine, ch, rootDrive, tokenStore, userDAO, groupDAO,
 		driveDAO, driveCacheDAO, driveDataDAO, permissionDAO, pathMountDAO)
 
-	InitDriveRoutes(engine, config, rootDrive, permissionDAO, thumbnail,
-		signer, chunkUploader, runner, tokenStore)
+	InitDriveRoutes(engine, config, rootDrive, permissionDAO, thumbnail, hls, mediaInfo, search,
+		signer, chunkUploader, deleteCheckpoints, runner, tokenStore, idempotencyStore, auditSink, userDAO, shareDAO)
 
 	if config.GetResDir() != "" {
 		engine.NoRoute(Static("/", config.GetResDir()))
@@ -81,7 +88,7 @@ func apiResultHandler(ms i18n.MessageSource) func(*gin.Context) {
 		if re, ok := e.Err.(err.RequestError); ok {
 			code = re.Code()
 		}
-		if red, ok := e.Err.(err.RequestErrorWithData); ok {
+		if red, ok := e.Err.(err.RequestErrorWithData); ok && red.Data() != nil {
 			result["data"] = red.Data()
 		}
 		writeJSON(c, ms, code, result)
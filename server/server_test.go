@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"go-drive/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// testServer is the server components over the memory drive 'a' and the local drive 'b'.
+// The database and the files are in a temp dir, which is the working dir as the data dir of the config is the default one
+type testServer struct {
+	dr      *driveRoute
+	db      *storage.DB
+	userDAO *storage.UserDAO
+	ch      *registry.ComponentsHolder
+	dir     string
+	wd      string
+}
+
+func newTestServer(t *testing.T, config common.Config) *testServer {
+	gin.SetMode(gin.TestMode)
+	dir, e := ioutil.TempDir("", "go-drive-server-test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	wd, e := os.Getwd()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := os.MkdirAll(filepath.Join(dir, common.LocalFsDir, "b"), 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Chdir(dir); e != nil {
+		t.Fatal(e)
+	}
+	s := &testServer{dir: dir, wd: wd, ch: registry.NewComponentHolder()}
+	config.TempDir = dir
+	s.db, e = storage.NewDB(config, s.ch)
+	if e != nil {
+		s.close()
+		t.Fatal(e)
+	}
+	driveDAO := storage.NewDriveDAO(s.db)
+	for _, d := range []types.Drive{
+		{Name: "a", Enabled: true, Type: "memory", Config: `{"max_size":"1M"}`},
+		{Name: "b", Enabled: true, Type: "fs", Config: `{"path":"b"}`},
+	} {
+		if _, e := driveDAO.AddDrive(d); e != nil {
+			s.close()
+			t.Fatal(e)
+		}
+	}
+	rootDrive, e := drive.NewRootDrive(context.Background(), config, driveDAO, storage.NewPathMountDAO(s.db),
+		storage.NewDriveDataDAO(s.db), storage.NewDriveCacheDAO(s.db, s.ch), drive_util.NewMemLocker(), s.ch)
+	if e != nil {
+		s.close()
+		t.Fatal(e)
+	}
+	s.userDAO = storage.NewUserDAO(s.db)
+	s.dr = &driveRoute{
+		config:        config,
+		rootDrive:     rootDrive,
+		permissionDAO: storage.NewPathPermissionDAO(s.db),
+		signer:        utils.NewSigner(),
+		auditSink:     &testAuditSink{},
+	}
+	return s
+}
+
+// engine returns a gin engine rendering the results like the server, the messages are not translated
+func (s *testServer) engine() *gin.Engine {
+	r := gin.New()
+	r.Use(apiResultHandler(testMessageSource{}))
+	return r
+}
+
+func (s *testServer) close() {
+	for _, c := range s.ch.Gets(nil) {
+		if d, ok := c.(types.IDisposable); ok {
+			_ = d.Dispose()
+		}
+	}
+	_ = os.Chdir(s.wd)
+	_ = os.RemoveAll(s.dir)
+}
+
+// testAuditSink keeps the records in memory
+type testAuditSink struct {
+	mux     sync.Mutex
+	records []types.AuditRecord
+}
+
+func (s *testAuditSink) Write(r types.AuditRecord) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+type testMessageSource struct{}
+
+func (testMessageSource) Translate(_, key string, _ ...string) string {
+	return key
+}
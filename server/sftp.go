@@ -0,0 +1,969 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/registry"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"go-drive/storage"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sftpVersion = 3
+	// sftpMaxPacket is the max length of the packets, the clients write 32KB at most
+	sftpMaxPacket = 256 * 1024
+	sftpMaxRead   = 64 * 1024
+	sftpDirBatch  = 100
+	// sftpMaxSkip is the max bytes skipped instead of reopening the content when the client reads forward
+	sftpMaxSkip = 1024 * 1024
+)
+
+// packet types of SFTP version 3, see draft-ietf-secsh-filexfer-02
+const (
+	sftpFxpInit          = 1
+	sftpFxpVersion       = 2
+	sftpFxpOpen          = 3
+	sftpFxpClose         = 4
+	sftpFxpRead          = 5
+	sftpFxpWrite         = 6
+	sftpFxpLstat         = 7
+	sftpFxpFstat         = 8
+	sftpFxpSetstat       = 9
+	sftpFxpFsetstat      = 10
+	sftpFxpOpendir       = 11
+	sftpFxpReaddir       = 12
+	sftpFxpRemove        = 13
+	sftpFxpMkdir         = 14
+	sftpFxpRmdir         = 15
+	sftpFxpRealpath      = 16
+	sftpFxpStat          = 17
+	sftpFxpRename        = 18
+	sftpFxpStatus        = 101
+	sftpFxpHandle        = 102
+	sftpFxpData          = 103
+	sftpFxpName          = 104
+	sftpFxpAttrs         = 105
+	sftpFxpExtended      = 200
+	sftpFxpExtendedReply = 201
+)
+
+const (
+	sftpFxOK               = 0
+	sftpFxEOF              = 1
+	sftpFxNoSuchFile       = 2
+	sftpFxPermissionDenied = 3
+	sftpFxFailure          = 4
+	sftpFxBadMessage       = 5
+	sftpFxOpUnsupported    = 8
+)
+
+const (
+	sftpFxfWrite  = 0x02
+	sftpFxfAppend = 0x04
+	sftpFxfCreat  = 0x08
+	sftpFxfTrunc  = 0x10
+	sftpFxfExcl   = 0x20
+
+	sftpAttrSize        = 0x01
+	sftpAttrPermissions = 0x04
+	sftpAttrACModTime   = 0x08
+)
+
+var errSFTPBadMessage = errors.New("bad message")
+
+// SFTPServer serves the drives over SFTP, users are authenticated by their go-drive passwords,
+// or by the public keys in the 'authorized_keys/<username>' files of the SFTP dir.
+// The host key is generated in the SFTP dir if it does not exist.
+// Only the SFTP subsystem is served, the commands like rsync, or scp without SFTP, are refused as there is no shell.
+type SFTPServer struct {
+	dr       *driveRoute
+	userDAO  *storage.UserDAO
+	config   *ssh.ServerConfig
+	keysDir  string
+	listener net.Listener
+
+	tempDir           string
+	uploadRateLimit   int64
+	downloadRateLimit int64
+}
+
+// NewSFTPServer listens on the SFTP address of config, nil is returned if SFTP is not enabled.
+// The connections are accepted by Serve, until it's disposed
+func NewSFTPServer(config common.Config, rootDrive *drive.RootDrive, permissionDAO *storage.PathPermissionDAO,
+	signer *utils.Signer, auditSink types.AuditSink, userDAO *storage.UserDAO,
+	ch *registry.ComponentsHolder) (*SFTPServer, error) {
+	if config.SFTPListen == "" {
+		return nil, nil
+	}
+	dir, e := config.GetDir("sftp", true)
+	if e != nil {
+		return nil, e
+	}
+	hostKey, e := loadHostKey(filepath.Join(dir, "host_key"))
+	if e != nil {
+		return nil, e
+	}
+	s := &SFTPServer{
+		dr: &driveRoute{
+			config:        config,
+			rootDrive:     rootDrive,
+			permissionDAO: permissionDAO,
+			signer:        signer,
+			auditSink:     auditSink,
+		},
+		userDAO:           userDAO,
+		keysDir:           filepath.Join(dir, "authorized_keys"),
+		tempDir:           config.TempDir,
+		uploadRateLimit:   config.UploadRateLimit,
+		downloadRateLimit: config.DownloadRateLimit,
+	}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback:  s.passwordCallback,
+		PublicKeyCallback: s.publicKeyCallback,
+	}
+	s.config.AddHostKey(hostKey)
+	listener, e := net.Listen("tcp", config.SFTPListen)
+	if e != nil {
+		return nil, e
+	}
+	s.listener = listener
+	ch.Add("sftpServer", s)
+	return s, nil
+}
+
+// loadHostKey loads the host key, an ed25519 key is generated if the file does not exist
+func loadHostKey(path string) (ssh.Signer, error) {
+	dat, e := ioutil.ReadFile(path)
+	if os.IsNotExist(e) {
+		_, key, e := ed25519.GenerateKey(rand.Reader)
+		if e != nil {
+			return nil, e
+		}
+		der, e := x509.MarshalPKCS8PrivateKey(key)
+		if e != nil {
+			return nil, e
+		}
+		dat = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if e := ioutil.WriteFile(path, dat, 0600); e != nil {
+			return nil, e
+		}
+	} else if e != nil {
+		return nil, e
+	}
+	return ssh.ParsePrivateKey(dat)
+}
+
+func (s *SFTPServer) passwordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	if _, e := s.userDAO.Authenticate(conn.User(), string(password)); e != nil {
+		return nil, e
+	}
+	return &ssh.Permissions{}, nil
+}
+
+// publicKeyCallback accepts the keys in the authorized_keys file of the user
+func (s *SFTPServer) publicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	username := conn.User()
+	if username == "" || strings.ContainsAny(username, `/\`) || strings.HasPrefix(username, ".") {
+		return nil, errors.New("invalid username")
+	}
+	dat, e := ioutil.ReadFile(filepath.Join(s.keysDir, username))
+	if e != nil {
+		return nil, errors.New("no authorized keys")
+	}
+	marshaled := key.Marshal()
+	for len(dat) > 0 {
+		authorized, _, _, rest, e := ssh.ParseAuthorizedKey(dat)
+		if e != nil {
+			break
+		}
+		if string(authorized.Marshal()) == string(marshaled) {
+			return &ssh.Permissions{}, nil
+		}
+		dat = rest
+	}
+	return nil, errors.New("unauthorized key")
+}
+
+// Serve accepts the connections until the server is disposed
+func (s *SFTPServer) Serve() {
+	for {
+		conn, e := s.listener.Accept()
+		if e != nil {
+			if ne, ok := e.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			log.Println("SFTP server stopped", e)
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *SFTPServer) Dispose() error {
+	return s.listener.Close()
+}
+
+func (s *SFTPServer) handleConn(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	sc, channels, requests, e := ssh.NewServerConn(conn, s.config)
+	if e != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	user, e := s.userDAO.GetUser(sc.User())
+	if e != nil {
+		return
+	}
+	// the signatures of the download links are not used by SFTP
+	request := &http.Request{URL: &url.URL{Path: "/"}, Header: http.Header{}, RemoteAddr: conn.RemoteAddr().String()}
+	drive := s.dr.sessionDrive(request, types.Session{User: user})
+	for nc := range channels {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, e := nc.Accept()
+		if e != nil {
+			continue
+		}
+		go s.handleChannel(drive, ch, requests)
+	}
+}
+
+func (s *SFTPServer) handleChannel(drive types.IDrive, ch ssh.Channel, requests <-chan *ssh.Request) {
+	started := false
+	for r := range requests {
+		switch r.Type {
+		case "subsystem":
+			name := struct{ Name string }{}
+			ok := !started && ssh.Unmarshal(r.Payload, &name) == nil && name.Name == "sftp"
+			_ = r.Reply(ok, nil)
+			if ok {
+				started = true
+				go func() {
+					newSFTPSession(s, drive, ch).serve()
+					_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+					_ = ch.Close()
+				}()
+			}
+		case "exec", "shell":
+			_ = r.Reply(!started, nil)
+			if !started {
+				started = true
+				_, _ = ch.Stderr().Write([]byte("Only SFTP is supported, use sftp, or scp with SFTP.\r\n"))
+				_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+				_ = ch.Close()
+			}
+		case "env":
+			_ = r.Reply(true, nil)
+		default:
+			_ = r.Reply(false, nil)
+		}
+	}
+}
+
+type sftpSession struct {
+	s      *SFTPServer
+	drive  types.IDrive
+	ch     ssh.Channel
+	r      io.Reader
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	handles    map[string]sftpHandle
+	nextHandle int
+}
+
+type sftpHandle interface {
+	close() error
+}
+
+func newSFTPSession(s *SFTPServer, drive types.IDrive, ch ssh.Channel) *sftpSession {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &sftpSession{
+		s: s, drive: drive, ch: ch, ctx: ctx, cancel: cancel,
+		// the uploaded content is throttled with the requests
+		r:       drive_util.ThrottledReader(ch, s.uploadRateLimit),
+		handles: make(map[string]sftpHandle),
+	}
+}
+
+func (c *sftpSession) serve() {
+	defer func() {
+		c.cancel()
+		for _, h := range c.handles {
+			_ = h.close()
+		}
+	}()
+	head := make([]byte, 4)
+	for {
+		if _, e := io.ReadFull(c.r, head); e != nil {
+			return
+		}
+		length := binary.BigEndian.Uint32(head)
+		if length < 1 || length > sftpMaxPacket {
+			return
+		}
+		packet := make([]byte, length)
+		if _, e := io.ReadFull(c.r, packet); e != nil {
+			return
+		}
+		if e := c.handle(packet[0], &sftpBuffer{b: packet[1:]}); e != nil {
+			return
+		}
+	}
+}
+
+func (c *sftpSession) send(packetType byte, payload []byte) error {
+	dat := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(dat, uint32(len(payload)+1))
+	dat[4] = packetType
+	_, e := c.ch.Write(append(dat, payload...))
+	return e
+}
+
+func (c *sftpSession) sendStatus(id uint32, code uint32, message string) error {
+	b := appendU32(nil, id)
+	b = appendU32(b, code)
+	b = appendString(b, message)
+	b = appendString(b, "")
+	return c.send(sftpFxpStatus, b)
+}
+
+func (c *sftpSession) sendError(id uint32, e error) error {
+	switch {
+	case e == errSFTPBadMessage:
+		return c.sendStatus(id, sftpFxBadMessage, "Bad message")
+	case err.IsNotFoundError(e):
+		return c.sendStatus(id, sftpFxNoSuchFile, "No such file")
+	case err.IsUnsupportedError(e):
+		return c.sendStatus(id, sftpFxOpUnsupported, "Operation unsupported")
+	}
+	if re, ok := e.(err.RequestError); ok && re.Code() < 500 {
+		return c.sendStatus(id, sftpFxPermissionDenied, "Permission denied")
+	}
+	return c.sendStatus(id, sftpFxFailure, "Failure")
+}
+
+// handle handles the packet, an error is returned only if the connection is broken
+func (c *sftpSession) handle(packetType byte, b *sftpBuffer) error {
+	if packetType == sftpFxpInit {
+		return c.send(sftpFxpVersion, appendU32(nil, sftpVersion))
+	}
+	id, ok := b.u32()
+	if !ok {
+		return errSFTPBadMessage
+	}
+	var e error
+	switch packetType {
+	case sftpFxpOpen:
+		e = c.open(id, b)
+	case sftpFxpClose:
+		e = c.closeHandle(id, b)
+	case sftpFxpRead:
+		e = c.read(id, b)
+	case sftpFxpWrite:
+		e = c.write(id, b)
+	case sftpFxpStat, sftpFxpLstat:
+		e = c.stat(id, b)
+	case sftpFxpFstat:
+		e = c.fstat(id, b)
+	case sftpFxpSetstat, sftpFxpFsetstat:
+		// the attributes are not kept by the drives, but the clients fail if they can't be set
+		e = c.sendStatus(id, sftpFxOK, "")
+	case sftpFxpOpendir:
+		e = c.opendir(id, b)
+	case sftpFxpReaddir:
+		e = c.readdir(id, b)
+	case sftpFxpRemove:
+		e = c.remove(id, b, false)
+	case sftpFxpRmdir:
+		e = c.remove(id, b, true)
+	case sftpFxpMkdir:
+		e = c.mkdir(id, b)
+	case sftpFxpRealpath:
+		e = c.realpath(id, b)
+	case sftpFxpRename:
+		e = c.rename(id, b, false)
+	case sftpFxpExtended:
+		name, _ := b.string()
+		if name == "posix-rename@openssh.com" {
+			e = c.rename(id, b, true)
+		} else {
+			e = c.sendStatus(id, sftpFxOpUnsupported, "Operation unsupported")
+		}
+	default:
+		e = c.sendStatus(id, sftpFxOpUnsupported, "Operation unsupported")
+	}
+	return e
+}
+
+// result sends the error status of e if it's not nil, otherwise the result of fn
+func (c *sftpSession) result(id uint32, e error, fn func() error) error {
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	return fn()
+}
+
+func (c *sftpSession) path(b *sftpBuffer) (string, error) {
+	p, ok := b.string()
+	if !ok {
+		return "", errSFTPBadMessage
+	}
+	return utils.CleanPath(p), nil
+}
+
+func (c *sftpSession) addHandle(h sftpHandle) string {
+	c.nextHandle++
+	name := strconv.Itoa(c.nextHandle)
+	c.handles[name] = h
+	return name
+}
+
+func (c *sftpSession) sendHandle(id uint32, h sftpHandle) error {
+	return c.send(sftpFxpHandle, appendString(appendU32(nil, id), c.addHandle(h)))
+}
+
+func (c *sftpSession) getHandle(b *sftpBuffer) (string, sftpHandle, error) {
+	name, ok := b.string()
+	if !ok {
+		return "", nil, errSFTPBadMessage
+	}
+	h, ok := c.handles[name]
+	if !ok {
+		return "", nil, err.NewNotFoundError()
+	}
+	return name, h, nil
+}
+
+func (c *sftpSession) open(id uint32, b *sftpBuffer) error {
+	path, e := c.path(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	flags, ok := b.u32()
+	if !ok {
+		return c.sendError(id, errSFTPBadMessage)
+	}
+	if flags&sftpFxfWrite == 0 {
+		entry, e := c.drive.Get(c.ctx, path)
+		if e != nil {
+			return c.sendError(id, e)
+		}
+		content, ok := entry.(types.IContent)
+		if !ok || !entry.Type().IsFile() {
+			return c.sendStatus(id, sftpFxFailure, "Not a file")
+		}
+		return c.sendHandle(id, &sftpFileHandle{c: c, path: path, entry: entry, content: content})
+	}
+	h, e := c.openWrite(path, flags)
+	return c.result(id, e, func() error { return c.sendHandle(id, h) })
+}
+
+// openWrite opens the file for writing, the content is written to a temp file and saved when the handle is closed.
+// The content of the existing file is kept if it's not truncated, it's loaded on the first read or write,
+// so the clients opening the files without writing, like to check the permissions, don't download them
+func (c *sftpSession) openWrite(path string, flags uint32) (*sftpFileHandle, error) {
+	if utils.IsRootPath(path) {
+		return nil, err.NewNotAllowedError()
+	}
+	old, e := c.drive.Get(c.ctx, path)
+	if e != nil && !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	if old != nil {
+		if !old.Type().IsFile() {
+			return nil, err.NewNotAllowedError()
+		}
+		if flags&(sftpFxfCreat|sftpFxfExcl) == sftpFxfCreat|sftpFxfExcl {
+			return nil, errors.New("file exists")
+		}
+		if !old.Meta().CanWrite {
+			return nil, err.NewNotAllowedError()
+		}
+	} else {
+		if flags&sftpFxfCreat == 0 {
+			return nil, err.NewNotFoundError()
+		}
+		// the permission is checked before the content is uploaded
+		if parent := utils.PathParent(path); !utils.IsRootPath(parent) {
+			dir, e := c.drive.Get(c.ctx, parent)
+			if e != nil {
+				return nil, e
+			}
+			if !dir.Type().IsDir() || !dir.Meta().CanWrite {
+				return nil, err.NewNotAllowedError()
+			}
+		}
+	}
+	file, e := ioutil.TempFile(c.s.tempDir, "sftp-upload")
+	if e != nil {
+		return nil, e
+	}
+	h := &sftpFileHandle{c: c, path: path, file: file, append: flags&sftpFxfAppend != 0,
+		dirty: old == nil || flags&sftpFxfTrunc != 0}
+	if old != nil && flags&sftpFxfTrunc == 0 {
+		// the content is kept for the clients resuming the upload
+		h.old = old
+	}
+	return h, nil
+}
+
+func (c *sftpSession) closeHandle(id uint32, b *sftpBuffer) error {
+	name, h, e := c.getHandle(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	delete(c.handles, name)
+	e = h.close()
+	return c.result(id, e, func() error { return c.sendStatus(id, sftpFxOK, "") })
+}
+
+func (c *sftpSession) fileHandle(b *sftpBuffer) (*sftpFileHandle, error) {
+	_, h, e := c.getHandle(b)
+	if e != nil {
+		return nil, e
+	}
+	fh, ok := h.(*sftpFileHandle)
+	if !ok {
+		return nil, errSFTPBadMessage
+	}
+	return fh, nil
+}
+
+func (c *sftpSession) read(id uint32, b *sftpBuffer) error {
+	h, e := c.fileHandle(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	offset, ok1 := b.u64()
+	length, ok2 := b.u32()
+	if !ok1 || !ok2 {
+		return c.sendError(id, errSFTPBadMessage)
+	}
+	if length > sftpMaxRead {
+		length = sftpMaxRead
+	}
+	buf := make([]byte, length)
+	n, e := h.readAt(buf, int64(offset))
+	if n == 0 && e == io.EOF {
+		return c.sendStatus(id, sftpFxEOF, "EOF")
+	}
+	if e != nil && e != io.EOF {
+		return c.sendError(id, e)
+	}
+	return c.send(sftpFxpData, appendString(appendU32(nil, id), string(buf[:n])))
+}
+
+func (c *sftpSession) write(id uint32, b *sftpBuffer) error {
+	h, e := c.fileHandle(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	offset, ok1 := b.u64()
+	dat, ok2 := b.string()
+	if !ok1 || !ok2 {
+		return c.sendError(id, errSFTPBadMessage)
+	}
+	if h.file == nil {
+		return c.sendStatus(id, sftpFxPermissionDenied, "Not opened for writing")
+	}
+	e = h.writeAt([]byte(dat), int64(offset))
+	return c.result(id, e, func() error { return c.sendStatus(id, sftpFxOK, "") })
+}
+
+func (c *sftpSession) stat(id uint32, b *sftpBuffer) error {
+	path, e := c.path(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	if utils.IsRootPath(path) {
+		return c.send(sftpFxpAttrs, appendU32(appendU32(appendU32(nil, id), sftpAttrPermissions), 0040755))
+	}
+	entry, e := c.drive.Get(c.ctx, path)
+	return c.result(id, e, func() error {
+		return c.send(sftpFxpAttrs, append(appendU32(nil, id), sftpAttrs(entry)...))
+	})
+}
+
+func (c *sftpSession) fstat(id uint32, b *sftpBuffer) error {
+	h, e := c.fileHandle(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	attrs := []byte(nil)
+	if h.file != nil {
+		stat, e := h.file.Stat()
+		if e != nil {
+			return c.sendError(id, e)
+		}
+		size := stat.Size()
+		if h.old != nil {
+			size = h.old.Size()
+		}
+		attrs = appendU32(nil, sftpAttrSize|sftpAttrPermissions)
+		attrs = appendU64(attrs, uint64(size))
+		attrs = appendU32(attrs, 0100644)
+	} else {
+		attrs = sftpAttrs(h.entry)
+	}
+	return c.send(sftpFxpAttrs, append(appendU32(nil, id), attrs...))
+}
+
+func (c *sftpSession) opendir(id uint32, b *sftpBuffer) error {
+	path, e := c.path(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	if !utils.IsRootPath(path) {
+		entry, e := c.drive.Get(c.ctx, path)
+		if e != nil {
+			return c.sendError(id, e)
+		}
+		if !entry.Type().IsDir() {
+			return c.sendStatus(id, sftpFxFailure, "Not a directory")
+		}
+	}
+	entries, e := c.drive.List(c.ctx, path)
+	return c.result(id, e, func() error { return c.sendHandle(id, &sftpDirHandle{entries: entries}) })
+}
+
+func (c *sftpSession) readdir(id uint32, b *sftpBuffer) error {
+	_, h, e := c.getHandle(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	dh, ok := h.(*sftpDirHandle)
+	if !ok {
+		return c.sendError(id, errSFTPBadMessage)
+	}
+	if len(dh.entries) == 0 {
+		return c.sendStatus(id, sftpFxEOF, "EOF")
+	}
+	n := len(dh.entries)
+	if n > sftpDirBatch {
+		n = sftpDirBatch
+	}
+	now := time.Now()
+	dat := appendU32(appendU32(nil, id), uint32(n))
+	for _, entry := range dh.entries[:n] {
+		dat = appendString(dat, utils.PathBase(entry.Path()))
+		dat = appendString(dat, listLine(entry, now))
+		dat = append(dat, sftpAttrs(entry)...)
+	}
+	dh.entries = dh.entries[n:]
+	return c.send(sftpFxpName, dat)
+}
+
+func (c *sftpSession) remove(id uint32, b *sftpBuffer, dir bool) error {
+	path, e := c.path(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	if utils.IsRootPath(path) {
+		return c.sendStatus(id, sftpFxPermissionDenied, "Permission denied")
+	}
+	entry, e := c.drive.Get(c.ctx, path)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	if entry.Type().IsDir() != dir {
+		return c.sendStatus(id, sftpFxFailure, "Type mismatch")
+	}
+	if dir {
+		// RMDIR removes empty directories only, as the clients remove the children first
+		children, e := c.drive.List(c.ctx, path)
+		if e != nil {
+			return c.sendError(id, e)
+		}
+		if len(children) > 0 {
+			return c.sendStatus(id, sftpFxFailure, "Directory not empty")
+		}
+	}
+	e = c.drive.Delete(task.DummyContext(), path)
+	return c.result(id, e, func() error { return c.sendStatus(id, sftpFxOK, "") })
+}
+
+func (c *sftpSession) mkdir(id uint32, b *sftpBuffer) error {
+	path, e := c.path(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	if utils.IsRootPath(path) {
+		return c.sendStatus(id, sftpFxFailure, "Directory exists")
+	}
+	_, e = c.drive.MakeDir(c.ctx, path)
+	return c.result(id, e, func() error { return c.sendStatus(id, sftpFxOK, "") })
+}
+
+// realpath returns the absolute path, the home directory of the users is the root
+func (c *sftpSession) realpath(id uint32, b *sftpBuffer) error {
+	path, e := c.path(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	dat := appendU32(appendU32(nil, id), 1)
+	dat = appendString(dat, "/"+path)
+	dat = appendString(dat, "/"+path)
+	dat = appendU32(dat, 0)
+	return c.send(sftpFxpName, dat)
+}
+
+// rename moves the entry, the target is replaced only by posix-rename
+func (c *sftpSession) rename(id uint32, b *sftpBuffer, override bool) error {
+	from, e := c.path(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	to, e := c.path(b)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	if utils.IsRootPath(from) || utils.IsRootPath(to) {
+		return c.sendStatus(id, sftpFxPermissionDenied, "Permission denied")
+	}
+	entry, e := c.drive.Get(c.ctx, from)
+	if e != nil {
+		return c.sendError(id, e)
+	}
+	if !override {
+		if _, e := c.drive.Get(c.ctx, to); e == nil {
+			return c.sendStatus(id, sftpFxFailure, "Target exists")
+		} else if !err.IsNotFoundError(e) {
+			return c.sendError(id, e)
+		}
+	}
+	_, e = c.drive.Move(task.DummyContext(), entry, to, true)
+	return c.result(id, e, func() error { return c.sendStatus(id, sftpFxOK, "") })
+}
+
+type sftpDirHandle struct {
+	// entries are the entries not read yet
+	entries []types.IEntry
+}
+
+func (h *sftpDirHandle) close() error {
+	return nil
+}
+
+// sftpFileHandle is the handle of the file opened for reading, or writing if the file is not nil
+type sftpFileHandle struct {
+	c    *sftpSession
+	path string
+
+	entry   types.IEntry
+	content types.IContent
+	reader  io.ReadCloser
+	// pos is the offset of reader
+	pos int64
+
+	file *os.File
+	// old is the existing file whose content is not loaded into file yet
+	old    types.IEntry
+	append bool
+	// dirty is whether the file should be saved when closed
+	dirty bool
+}
+
+// loadOld loads the content of the existing file into file before it's first read or written
+func (h *sftpFileHandle) loadOld() error {
+	if h.old == nil {
+		return nil
+	}
+	content, ok := h.old.(types.IContent)
+	if !ok {
+		return err.NewNotAllowedError()
+	}
+	reader, e := drive_util.GetIContentReader(h.c.ctx, content)
+	if e != nil {
+		return e
+	}
+	defer func() { _ = reader.Close() }()
+	// the content copied partially by a failed load is dropped
+	if e := h.file.Truncate(0); e != nil {
+		return e
+	}
+	if _, e := h.file.Seek(0, io.SeekStart); e != nil {
+		return e
+	}
+	if _, e := drive_util.Copy(task.DummyContext(), h.file, reader); e != nil {
+		return e
+	}
+	h.old = nil
+	return nil
+}
+
+func (h *sftpFileHandle) readAt(buf []byte, offset int64) (int, error) {
+	if h.file != nil {
+		if e := h.loadOld(); e != nil {
+			return 0, e
+		}
+		return h.file.ReadAt(buf, offset)
+	}
+	if offset >= h.entry.Size() {
+		return 0, io.EOF
+	}
+	if h.reader != nil && offset > h.pos && offset-h.pos <= sftpMaxSkip {
+		if _, e := io.CopyN(ioutil.Discard, h.reader, offset-h.pos); e != nil {
+			return 0, e
+		}
+		h.pos = offset
+	}
+	if h.reader == nil || offset != h.pos {
+		if h.reader != nil {
+			_ = h.reader.Close()
+			h.reader = nil
+		}
+		reader, e := drive_util.GetIContentRangeReader(h.c.ctx, h.content, offset, -1)
+		if e != nil {
+			return 0, e
+		}
+		h.reader, h.pos = reader, offset
+	}
+	n, e := io.ReadFull(drive_util.ThrottledReader(h.reader, h.c.s.downloadRateLimit), buf)
+	h.pos += int64(n)
+	if e == io.ErrUnexpectedEOF {
+		e = nil
+	}
+	return n, e
+}
+
+func (h *sftpFileHandle) writeAt(dat []byte, offset int64) error {
+	if e := h.loadOld(); e != nil {
+		return e
+	}
+	if h.append {
+		stat, e := h.file.Stat()
+		if e != nil {
+			return e
+		}
+		offset = stat.Size()
+	}
+	if _, e := h.file.WriteAt(dat, offset); e != nil {
+		return e
+	}
+	h.dirty = true
+	return nil
+}
+
+// close saves the written file
+func (h *sftpFileHandle) close() error {
+	if h.reader != nil {
+		_ = h.reader.Close()
+		h.reader = nil
+	}
+	if h.file == nil {
+		return nil
+	}
+	defer func() {
+		_ = h.file.Close()
+		_ = os.Remove(h.file.Name())
+		h.file = nil
+	}()
+	if !h.dirty {
+		return nil
+	}
+	stat, e := h.file.Stat()
+	if e != nil {
+		return e
+	}
+	if _, e := h.file.Seek(0, io.SeekStart); e != nil {
+		return e
+	}
+	_, e = h.c.drive.Save(task.DummyContext(), h.path, stat.Size(), true, h.file)
+	return e
+}
+
+// sftpAttrs returns the attributes of the entry, the permissions are like 'ls -l' of listLine
+func sftpAttrs(entry types.IEntry) []byte {
+	mode := uint32(0100644)
+	if entry.Type().IsDir() {
+		mode = 0040755
+	}
+	if !entry.Meta().CanWrite {
+		mode &^= 0222
+	}
+	size := entry.Size()
+	if size < 0 {
+		size = 0
+	}
+	modTime := uint32(0)
+	if entry.ModTime() > 0 {
+		modTime = uint32(entry.ModTime() / 1000)
+	}
+	b := appendU32(nil, sftpAttrSize|sftpAttrPermissions|sftpAttrACModTime)
+	b = appendU64(b, uint64(size))
+	b = appendU32(b, mode)
+	b = appendU32(b, modTime)
+	return appendU32(b, modTime)
+}
+
+// sftpBuffer decodes the fields of the packets
+type sftpBuffer struct {
+	b []byte
+}
+
+func (b *sftpBuffer) u32() (uint32, bool) {
+	if len(b.b) < 4 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint32(b.b)
+	b.b = b.b[4:]
+	return v, true
+}
+
+func (b *sftpBuffer) u64() (uint64, bool) {
+	if len(b.b) < 8 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint64(b.b)
+	b.b = b.b[8:]
+	return v, true
+}
+
+func (b *sftpBuffer) string() (string, bool) {
+	n, ok := b.u32()
+	if !ok || uint32(len(b.b)) < n {
+		return "", false
+	}
+	v := string(b.b[:n])
+	b.b = b.b[n:]
+	return v, true
+}
+
+func appendU32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendU64(b []byte, v uint64) []byte {
+	return appendU32(appendU32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendU32(b, uint32(len(s))), s...)
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSFTPOpenWrite(t *testing.T) {
+	s := newTestServer(t, common.Config{})
+	defer s.close()
+	root := s.dr.rootDrive.Get()
+	if _, e := root.Save(task.DummyContext(), "b/file.txt", 5, false, strings.NewReader("hello")); e != nil {
+		t.Fatal(e)
+	}
+	c := &sftpSession{s: &SFTPServer{tempDir: s.dir}, drive: root, ctx: context.Background()}
+	read := func() string {
+		entry, e := root.Get(c.ctx, "b/file.txt")
+		if e != nil {
+			t.Fatal(e)
+		}
+		reader, e := drive_util.GetIContentReader(c.ctx, entry.(types.IContent))
+		if e != nil {
+			t.Fatal(e)
+		}
+		defer func() { _ = reader.Close() }()
+		dat, e := ioutil.ReadAll(reader)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return string(dat)
+	}
+
+	// the content is not loaded if nothing is written
+	h, e := c.openWrite("b/file.txt", sftpFxfWrite)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if h.old == nil {
+		t.Error("the content should be loaded lazily")
+	}
+	if e := h.close(); e != nil {
+		t.Fatal(e)
+	}
+	if got := read(); got != "hello" {
+		t.Errorf("unexpected content '%s' after closing without writing", got)
+	}
+
+	h, e = c.openWrite("b/file.txt", sftpFxfWrite)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := h.writeAt([]byte("!"), 5); e != nil {
+		t.Fatal(e)
+	}
+	if e := h.close(); e != nil {
+		t.Fatal(e)
+	}
+	if got := read(); got != "hello!" {
+		t.Errorf("unexpected content '%s' after writing without truncating", got)
+	}
+
+	h, e = c.openWrite("b/file.txt", sftpFxfWrite|sftpFxfTrunc)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := h.writeAt([]byte("hi"), 0); e != nil {
+		t.Fatal(e)
+	}
+	if e := h.close(); e != nil {
+		t.Fatal(e)
+	}
+	if got := read(); got != "hi" {
+		t.Errorf("unexpected content '%s' after truncating", got)
+	}
+}
+
+// testChannel is the SSH channel over one end of a pipe
+type testChannel struct {
+	ssh.Channel
+	conn net.Conn
+}
+
+func (c *testChannel) Read(p []byte) (int, error)  { return c.conn.Read(p) }
+func (c *testChannel) Write(p []byte) (int, error) { return c.conn.Write(p) }
+func (c *testChannel) Close() error                { return c.conn.Close() }
+
+// sftpTestClient sends the requests of SFTP and reads the responses
+type sftpTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	id   uint32
+}
+
+func (c *sftpTestClient) send(packetType byte, payload []byte) {
+	dat := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(dat, uint32(len(payload)+1))
+	dat[4] = packetType
+	if _, e := c.conn.Write(append(dat, payload...)); e != nil {
+		c.t.Fatal(e)
+	}
+}
+
+func (c *sftpTestClient) receive() (byte, *sftpBuffer) {
+	head := make([]byte, 4)
+	if _, e := io.ReadFull(c.conn, head); e != nil {
+		c.t.Fatal(e)
+	}
+	packet := make([]byte, binary.BigEndian.Uint32(head))
+	if _, e := io.ReadFull(c.conn, packet); e != nil {
+		c.t.Fatal(e)
+	}
+	return packet[0], &sftpBuffer{b: packet[1:]}
+}
+
+// request sends the request with a new id, and returns the response of the id
+func (c *sftpTestClient) request(packetType byte, payload []byte) (byte, *sftpBuffer) {
+	c.id++
+	c.send(packetType, append(appendU32(nil, c.id), payload...))
+	typ, b := c.receive()
+	if id, _ := b.u32(); id != c.id {
+		c.t.Fatalf("unexpected id %d of the response of %d", id, c.id)
+	}
+	return typ, b
+}
+
+// status returns the status code of the response, it fails if the response is not a status
+func (c *sftpTestClient) status(packetType byte, payload []byte) uint32 {
+	typ, b := c.request(packetType, payload)
+	if typ != sftpFxpStatus {
+		c.t.Fatalf("expect the status of %d, but it's %d", packetType, typ)
+	}
+	code, _ := b.u32()
+	return code
+}
+
+func (c *sftpTestClient) handle(packetType byte, payload []byte) string {
+	typ, b := c.request(packetType, payload)
+	if typ != sftpFxpHandle {
+		code, _ := b.u32()
+		c.t.Fatalf("expect the handle of %d, but it's %d of %d", packetType, typ, code)
+	}
+	h, _ := b.string()
+	return h
+}
+
+func TestSFTPProtocol(t *testing.T) {
+	s := newTestServer(t, common.Config{})
+	defer s.close()
+	server, client := net.Pipe()
+	session := newSFTPSession(&SFTPServer{tempDir: s.dir}, s.dr.rootDrive.Get(), &testChannel{conn: server})
+	done := make(chan struct{})
+	go func() {
+		session.serve()
+		close(done)
+	}()
+	defer func() {
+		_ = client.Close()
+		<-done
+	}()
+	c := &sftpTestClient{t: t, conn: client}
+
+	c.send(sftpFxpInit, appendU32(nil, sftpVersion))
+	if typ, b := c.receive(); typ != sftpFxpVersion {
+		t.Fatalf("expect the version, but it's %d", typ)
+	} else if v, _ := b.u32(); v != sftpVersion {
+		t.Fatalf("unexpected version %d", v)
+	}
+
+	// write a file
+	h := c.handle(sftpFxpOpen, appendU32(appendU32(appendString(nil, "/b/a.txt"),
+		sftpFxfWrite|sftpFxfCreat|sftpFxfTrunc), 0))
+	if code := c.status(sftpFxpWrite, appendString(appendU64(appendString(nil, h), 0), "hello")); code != sftpFxOK {
+		t.Fatalf("write: %d", code)
+	}
+	if code := c.status(sftpFxpClose, appendString(nil, h)); code != sftpFxOK {
+		t.Fatalf("close: %d", code)
+	}
+	typ, b := c.request(sftpFxpStat, appendString(nil, "/b/a.txt"))
+	if typ != sftpFxpAttrs {
+		t.Fatalf("expect the attributes, but it's %d", typ)
+	}
+	if flags, _ := b.u32(); flags&sftpAttrSize == 0 {
+		t.Fatal("expect the size in the attributes")
+	}
+	if size, _ := b.u64(); size != 5 {
+		t.Errorf("unexpected size %d", size)
+	}
+
+	// read it in two parts, then EOF
+	h = c.handle(sftpFxpOpen, appendU32(appendU32(appendString(nil, "/b/a.txt"), 0), 0))
+	read := func(offset uint64, length uint32) string {
+		typ, b := c.request(sftpFxpRead, appendU32(appendU64(appendString(nil, h), offset), length))
+		if typ == sftpFxpStatus {
+			if code, _ := b.u32(); code == sftpFxEOF {
+				return "EOF"
+			}
+		}
+		if typ != sftpFxpData {
+			t.Fatalf("expect the data, but it's %d", typ)
+		}
+		dat, _ := b.string()
+		return dat
+	}
+	if got := read(0, 3) + read(3, 100); got != "hello" {
+		t.Errorf("unexpected content '%s'", got)
+	}
+	if got := read(5, 100); got != "EOF" {
+		t.Errorf("expect EOF, but it's '%s'", got)
+	}
+	if code := c.status(sftpFxpWrite, appendString(appendU64(appendString(nil, h), 0), "x")); code != sftpFxPermissionDenied {
+		t.Errorf("the file opened for reading should not be written: %d", code)
+	}
+	if code := c.status(sftpFxpClose, appendString(nil, h)); code != sftpFxOK {
+		t.Fatalf("close: %d", code)
+	}
+
+	// list the directory
+	h = c.handle(sftpFxpOpendir, appendString(nil, "/b"))
+	typ, b = c.request(sftpFxpReaddir, appendString(nil, h))
+	if typ != sftpFxpName {
+		t.Fatalf("expect the names, but it's %d", typ)
+	}
+	if n, _ := b.u32(); n != 1 {
+		t.Errorf("unexpected count %d", n)
+	}
+	if name, _ := b.string(); name != "a.txt" {
+		t.Errorf("unexpected name '%s'", name)
+	}
+	if code := c.status(sftpFxpReaddir, appendString(nil, h)); code != sftpFxEOF {
+		t.Errorf("expect EOF after the entries, but it's %d", code)
+	}
+	if code := c.status(sftpFxpClose, appendString(nil, h)); code != sftpFxOK {
+		t.Fatalf("close: %d", code)
+	}
+
+	// rename, remove and the errors
+	if code := c.status(sftpFxpRename, appendString(appendString(nil, "/b/a.txt"), "/b/c.txt")); code != sftpFxOK {
+		t.Fatalf("rename: %d", code)
+	}
+	if code := c.status(sftpFxpRemove, appendString(nil, "/b/c.txt")); code != sftpFxOK {
+		t.Fatalf("remove: %d", code)
+	}
+	if code := c.status(sftpFxpStat, appendString(nil, "/b/c.txt")); code != sftpFxNoSuchFile {
+		t.Errorf("expect no such file, but it's %d", code)
+	}
+	if code := c.status(sftpFxpRead, appendU32(appendU64(appendString(nil, "unknown"), 0), 1)); code != sftpFxNoSuchFile {
+		t.Errorf("expect no such file of the unknown handle, but it's %d", code)
+	}
+	if code := c.status(sftpFxpRead, nil); code != sftpFxBadMessage {
+		t.Errorf("expect bad message of the truncated request, but it's %d", code)
+	}
+	if code := c.status(sftpFxpExtended, appendString(nil, "statvfs@openssh.com")); code != sftpFxOpUnsupported {
+		t.Errorf("expect the unsupported extension, but it's %d", code)
+	}
+}
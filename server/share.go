@@ -0,0 +1,431 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"net/http"
+	"os"
+	path2 "path"
+	"strings"
+	"time"
+)
+
+const headerSharePassword = "X-Share-Password"
+
+// shareRoute serves the share links, which are stored in the database and point to the entries of their owners.
+// The shared entries are browsed and downloaded read-only by anyone with the link(and the password),
+// with the permissions of the owner.
+// The file requests are the shares of directories, to which the visitors can only upload files.
+type shareRoute struct {
+	dr       *driveRoute
+	shareDAO *storage.ShareDAO
+	userDAO  *storage.UserDAO
+}
+
+func initShareRoutes(router gin.IRouter, r gin.IRouter, idempotent gin.HandlerFunc,
+	dr *driveRoute, shareDAO *storage.ShareDAO, userDAO *storage.UserDAO) {
+	s := &shareRoute{dr: dr, shareDAO: shareDAO, userDAO: userDAO}
+
+	// the public endpoints, the password is in the X-Share-Password header or ?password
+	router.GET("/s/:id", s.getShare)
+	router.GET("/s/:id/entries/*path", s.list)
+	router.HEAD("/s/:id/content/*path", s.getContent)
+	router.GET("/s/:id/content/*path", s.getContent)
+	// upload a file to the file request, the file is renamed if the name exists
+	router.PUT("/s/:id/upload/:name", s.upload)
+
+	// the shares of the current user
+	r.GET("/shares", s.listShares)
+	// share an entry, with optional ?password, ?expires_at in milliseconds and ?max_downloads,
+	// or create a file request of the directory by ?upload=1, with optional ?max_file_size and ?extensions=pdf,docx
+	r.POST("/shares/*path", idempotent, s.createShare)
+	r.DELETE("/shares/:id", s.deleteShare)
+}
+
+type shareJson struct {
+	types.Share
+	HasPassword bool `json:"has_password"`
+}
+
+// publicShareJson is the share seen by the visitors, the path of the owner is hidden
+type publicShareJson struct {
+	Id           string `json:"id"`
+	Name         string `json:"name"`
+	ExpiresAt    int64  `json:"expires_at"`
+	MaxDownloads int64  `json:"max_downloads"`
+	Downloads    int64  `json:"downloads"`
+	Upload       bool   `json:"upload"`
+	MaxFileSize  int64  `json:"max_file_size"`
+	Extensions   string `json:"extensions"`
+	// Entry is the shared entry, which is absent for the file requests
+	Entry *entryJson `json:"entry,omitempty"`
+}
+
+func (s *shareRoute) listShares(c *gin.Context) {
+	shares, e := s.shareDAO.ListShares(GetSession(c).User.Username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	res := make([]shareJson, 0, len(shares))
+	for _, share := range shares {
+		res = append(res, shareJson{Share: share, HasPassword: share.HasPassword()})
+	}
+	SetResult(c, res)
+}
+
+func (s *shareRoute) createShare(c *gin.Context) {
+	session := GetSession(c)
+	if session.IsAnonymous() {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	path := utils.CleanPath(c.Param("path"))
+	// the entry must be readable by the owner
+	entry, e := s.dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	upload := c.Query("upload") != ""
+	// and writable for the file requests
+	if upload && (!entry.Type().IsDir() || !entry.Meta().CanWrite) {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	expiresAt := utils.ToInt64(c.Query("expires_at"), 0)
+	maxDownloads := utils.ToInt64(c.Query("max_downloads"), 0)
+	maxFileSize := utils.ToInt64(c.Query("max_file_size"), 0)
+	if expiresAt < 0 || maxDownloads < 0 || maxFileSize < 0 {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.shares.invalid_options")))
+		return
+	}
+	share, e := s.shareDAO.AddShare(types.Share{
+		Path:         path,
+		Owner:        session.User.Username,
+		Password:     c.Query("password"),
+		ExpiresAt:    expiresAt,
+		MaxDownloads: maxDownloads,
+		Upload:       upload,
+		MaxFileSize:  maxFileSize,
+		Extensions:   c.Query("extensions"),
+	})
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, shareJson{Share: share, HasPassword: share.HasPassword()})
+}
+
+func (s *shareRoute) deleteShare(c *gin.Context) {
+	share, e := s.shareDAO.GetShare(c.Param("id"))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if share.Owner != GetSession(c).User.Username {
+		_ = c.Error(err.NewNotFoundError())
+		return
+	}
+	if e := s.shareDAO.DeleteShare(share.Id); e != nil {
+		_ = c.Error(e)
+	}
+}
+
+// open validates the share of the request, and returns the drive with the permissions of the owner
+func (s *shareRoute) open(c *gin.Context) (types.Share, types.IDrive, error) {
+	share, e := s.shareDAO.GetShare(c.Param("id"))
+	if e != nil {
+		return share, nil, e
+	}
+	if share.IsExpired(utils.Millisecond(time.Now())) {
+		return share, nil, err.NewNotFoundMessageError(i18n.T("api.shares.expired"))
+	}
+	if share.HasPassword() {
+		password := c.GetHeader(headerSharePassword)
+		if password == "" {
+			password = c.Query("password")
+		}
+		if password == "" || bcrypt.CompareHashAndPassword([]byte(share.Password), []byte(password)) != nil {
+			return share, nil, err.NewUnauthorizedError(i18n.T("api.shares.invalid_password"))
+		}
+	}
+	owner, e := s.userDAO.GetUser(share.Owner)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			// the owner has been deleted
+			return share, nil, err.NewNotFoundMessageError(i18n.T("storage.shares.share_not_exists", share.Id))
+		}
+		return share, nil, e
+	}
+	return share, s.dr.sessionDrive(c.Request, types.Session{User: owner}), nil
+}
+
+// openReadable opens the share, which must not be a file request
+func (s *shareRoute) openReadable(c *gin.Context) (types.Share, types.IDrive, error) {
+	share, d, e := s.open(c)
+	if e == nil && share.Upload {
+		e = err.NewNotAllowedError()
+	}
+	return share, d, e
+}
+
+// sharedPath returns the path of the owner of the path in the shared entry
+func sharedPath(share types.Share, c *gin.Context) string {
+	return utils.CleanPath(path2.Join(share.Path, utils.CleanPath(c.Param("path"))))
+}
+
+// newSharedEntryJson returns the entry json with the path relative to the shared entry,
+// the links, ids and access keys which are only meaningful to the owner are removed
+func newSharedEntryJson(share types.Share, entry types.IEntry) *entryJson {
+	j := newEntryJson(entry)
+	j.Path = strings.TrimPrefix(strings.TrimPrefix(entry.Path(), share.Path), "/")
+	j.Meta["can_write"] = false
+	delete(j.Meta, "thumbnail")
+	// the access key signs the path of the owner, which would download it without the share
+	delete(j.Meta, "access_key")
+	j.ID = ""
+	return j
+}
+
+func (s *shareRoute) getShare(c *gin.Context) {
+	share, d, e := s.open(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	res := publicShareJson{
+		Id:           share.Id,
+		Name:         utils.PathBase(share.Path),
+		ExpiresAt:    share.ExpiresAt,
+		MaxDownloads: share.MaxDownloads,
+		Downloads:    share.Downloads,
+		Upload:       share.Upload,
+		MaxFileSize:  share.MaxFileSize,
+		Extensions:   share.Extensions,
+	}
+	if !share.Upload {
+		entry, e := d.Get(c.Request.Context(), share.Path)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		res.Entry = newSharedEntryJson(share, entry)
+		res.Entry.Name = res.Name
+	}
+	SetResult(c, res)
+}
+
+func (s *shareRoute) list(c *gin.Context) {
+	share, d, e := s.openReadable(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	entries, e := d.List(c.Request.Context(), sharedPath(share, c))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if e := drive_util.SortEntries(entries, c.Query("sort")); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	res := make([]entryJson, 0, len(entries))
+	for _, entry := range entries {
+		res = append(res, *newSharedEntryJson(share, entry))
+	}
+	SetResult(c, res)
+}
+
+func (s *shareRoute) getContent(c *gin.Context) {
+	share, d, e := s.openReadable(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	entry, e := d.Get(c.Request.Context(), sharedPath(share, c))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	content, ok := entry.(types.IContent)
+	if !ok || !entry.Type().IsFile() {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	if c.Request.Method == http.MethodHead {
+		// nothing is downloaded, but the exhausted shares are not revealed either
+		if share.DownloadLimitReached() {
+			_ = c.Error(err.NewNotAllowedMessageError(i18n.T("storage.shares.download_limit_reached")))
+			return
+		}
+		if e := drive_util.DownloadIContent(c.Request.Context(), content, c.Writer, c.Request,
+			share.MaxDownloads > 0, s.dr.config.DownloadRateLimit); e != nil {
+			_ = c.Error(e)
+		}
+		return
+	}
+	w := &shareDownloadWriter{ResponseWriter: c.Writer, count: func() error {
+		return s.shareDAO.CountDownload(share.Id)
+	}}
+	// the limited downloads are proxied, the redirected ones could not be counted
+	e = drive_util.DownloadIContent(c.Request.Context(), content, w, c.Request,
+		share.MaxDownloads > 0, s.dr.config.DownloadRateLimit)
+	if w.e != nil {
+		e = w.e
+	}
+	if e != nil {
+		_ = c.Error(e)
+	}
+}
+
+// shareDownloadWriter counts the download when the response serves the first byte of the content,
+// whatever the Range of the request is, as the drives not supporting ranges serve the whole content.
+// The later parts, like the seeking of videos and the resumption of downloads, are not counted.
+// If the download limit is reached, the response is dropped and the error is kept in e
+type shareDownloadWriter struct {
+	http.ResponseWriter
+	count   func() error
+	counted bool
+	e       error
+}
+
+func (w *shareDownloadWriter) WriteHeader(code int) {
+	if !w.counted {
+		w.counted = true
+		if servesFirstByte(code, w.Header()) {
+			if e := w.count(); e != nil {
+				w.e = e
+				for _, k := range []string{"Content-Type", "Content-Length", "Content-Range", "Content-Encoding"} {
+					w.Header().Del(k)
+				}
+				return
+			}
+		}
+	}
+	if w.e == nil {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *shareDownloadWriter) Write(p []byte) (int, error) {
+	if !w.counted {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.e != nil {
+		return 0, w.e
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *shareDownloadWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.e == nil {
+		f.Flush()
+	}
+}
+
+// servesFirstByte tells whether the response starts with the first byte of the content,
+// the multipart ranges are taken as they do
+func servesFirstByte(code int, header http.Header) bool {
+	switch code {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		return strings.HasPrefix(header.Get("Content-Range"), "bytes 0-") ||
+			strings.HasPrefix(header.Get("Content-Type"), "multipart/byteranges")
+	}
+	return false
+}
+
+func (s *shareRoute) upload(c *gin.Context) {
+	defer func() { _ = c.Request.Body.Close() }()
+	share, d, e := s.open(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if !share.Upload {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	// the file is always in the shared directory, the name is not a path
+	name := c.Param("name")
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.shares.invalid_file_name", name)))
+		return
+	}
+	size := utils.ToInt64(c.GetHeader("Content-Length"), -1)
+	if size < 0 {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.drive.invalid_file_size")))
+		return
+	}
+	if !share.AllowsFile(name, size) {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.shares.file_not_allowed", name)))
+		return
+	}
+	drive_util.SetBandwidthLimitHeader(c.Writer.Header(), s.dr.config.UploadRateLimit)
+	// the body can't be longer than the checked Content-Length
+	file, e := drive_util.CopyReaderToTempFile(task.DummyContext(), drive_util.ThrottledReader(
+		io.LimitReader(c.Request.Body, size+1), s.dr.config.UploadRateLimit), s.dr.config.TempDir)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	stat, e := file.Stat()
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if stat.Size() != size {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.drive.invalid_file_size")))
+		return
+	}
+	// the visitors can't see the files, so the existing ones are never replaced or revealed
+	path, e := availablePath(c.Request.Context(), d, share.Path, name)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if _, e := d.Save(task.DummyContext(), path, size, false, file); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, types.M{"name": utils.PathBase(path)})
+}
+
+const maxAvailableNameTries = 100
+
+// availablePath returns the path of name in dir which doesn't exist, like 'a (1).txt' if 'a.txt' exists
+func availablePath(ctx context.Context, d types.IDrive, dir, name string) (string, error) {
+	ext := path2.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < maxAvailableNameTries; i++ {
+		p := path2.Join(dir, name)
+		if i > 0 {
+			p = path2.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+		}
+		_, e := d.Get(ctx, p)
+		if err.IsNotFoundError(e) {
+			return p, nil
+		}
+		if e != nil {
+			return "", e
+		}
+	}
+	return "", err.NewNotAllowedMessageError(i18n.T("drive.file_exists"))
+}
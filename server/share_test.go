@@ -0,0 +1,131 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-drive/common"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestShares(t *testing.T) (*testServer, *storage.ShareDAO, http.Handler) {
+	s := newTestServer(t, common.Config{})
+	shareDAO := storage.NewShareDAO(s.db)
+	r := s.engine()
+	initShareRoutes(r, r, func(c *gin.Context) { c.Next() }, s.dr, shareDAO, s.userDAO)
+	root := s.dr.rootDrive.Get()
+	if _, e := root.MakeDir(task.DummyContext(), "b/dir"); e != nil {
+		s.close()
+		t.Fatal(e)
+	}
+	for _, path := range []string{"b/dir/1.txt", "b/dir/2.txt"} {
+		if _, e := root.Save(task.DummyContext(), path, 5, false, strings.NewReader("hello")); e != nil {
+			s.close()
+			t.Fatal(e)
+		}
+	}
+	return s, shareDAO, r
+}
+
+func shareRequest(h http.Handler, method, path, rangeHeader string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestSharedEntriesAccessKey(t *testing.T) {
+	s, shareDAO, h := newTestShares(t)
+	defer s.close()
+
+	dir, e := shareDAO.AddShare(types.Share{Path: "b/dir", Owner: "admin"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	file, e := shareDAO.AddShare(types.Share{Path: "b/dir/1.txt", Owner: "admin"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	for u, name := range map[string]string{"/s/" + dir.Id: "dir", "/s/" + dir.Id + "/entries/": "1.txt",
+		"/s/" + file.Id: "1.txt"} {
+		w := shareRequest(h, "GET", u, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", u, w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), `"`+name+`"`) {
+			t.Errorf("%s: the shared entry is missing: %s", u, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), "access_key") {
+			t.Errorf("%s: the shared entries should not have the access keys: %s", u, w.Body.String())
+		}
+	}
+}
+
+func TestShareDownloadLimit(t *testing.T) {
+	s, shareDAO, h := newTestShares(t)
+	defer s.close()
+
+	share, e := shareDAO.AddShare(types.Share{Path: "b/dir/1.txt", Owner: "admin", MaxDownloads: 1})
+	if e != nil {
+		t.Fatal(e)
+	}
+	u := "/s/" + share.Id + "/content/"
+	if w := shareRequest(h, "HEAD", u, ""); w.Code != http.StatusOK {
+		t.Fatalf("HEAD: %d", w.Code)
+	}
+	if w := shareRequest(h, "GET", u, "bytes=2-"); w.Code != http.StatusPartialContent {
+		t.Fatalf("the later part: %d", w.Code)
+	}
+	if w := shareRequest(h, "GET", u, "bytes=00-0"); w.Code != http.StatusPartialContent {
+		t.Fatalf("the first byte: %d", w.Code)
+	}
+	for _, r := range []string{"", "bytes=0-", "bytes= 0-", "bytes=1-,0-0"} {
+		if w := shareRequest(h, "GET", u, r); w.Code != http.StatusForbidden {
+			t.Errorf("'%s' should be refused after the limit is reached: %d", r, w.Code)
+		}
+	}
+	if w := shareRequest(h, "HEAD", u, ""); w.Code != http.StatusForbidden {
+		t.Errorf("HEAD should be refused after the limit is reached: %d", w.Code)
+	}
+	if w := shareRequest(h, "GET", u, "bytes=1-"); w.Code != http.StatusPartialContent {
+		t.Errorf("the later part should be served after the limit is reached: %d", w.Code)
+	}
+}
+
+func TestShareUploadName(t *testing.T) {
+	s, shareDAO, h := newTestShares(t)
+	defer s.close()
+
+	share, e := shareDAO.AddShare(types.Share{Path: "b/dir", Owner: "admin", Upload: true})
+	if e != nil {
+		t.Fatal(e)
+	}
+	upload := func(name string) int {
+		req := httptest.NewRequest("PUT", "/s/"+share.Id+"/upload/"+name, strings.NewReader("x"))
+		req.Header.Set("Content-Length", "1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	for _, name := range []string{"..", ".", "%2E%2E", "..%5C3.txt"} {
+		if code := upload(name); code != http.StatusBadRequest {
+			t.Errorf("'%s' should be refused: %d", name, code)
+		}
+	}
+	if _, e := s.dr.rootDrive.Get().Get(task.DummyContext(), "b/3.txt"); e == nil {
+		t.Error("nothing should be saved out of the shared directory")
+	}
+	if code := upload("3.txt"); code != http.StatusOK {
+		t.Fatalf("upload: %d", code)
+	}
+	if _, e := s.dr.rootDrive.Get().Get(task.DummyContext(), "b/dir/3.txt"); e != nil {
+		t.Error(e)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"go-drive/common/errors"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultTailLines = 10
+	maxTailLines     = 10000
+)
+
+type tailJson struct {
+	Lines []string `json:"lines"`
+	// Offset is where to follow the file from
+	Offset int64 `json:"offset"`
+}
+
+// tail returns the last lines of the file.
+// With 'follow', the lines are streamed as server-sent events, and so are the appended lines,
+// an event of 'reset' is sent when the file is truncated or replaced.
+func (dr *driveRoute) tail(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	n := int(utils.ToInt64(c.Query("lines"), defaultTailLines))
+	if n < 0 {
+		n = defaultTailLines
+	}
+	if n > maxTailLines {
+		n = maxTailLines
+	}
+	tailer, ok := dr.getDrive(c).(types.ITailer)
+	if !ok {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	ctx := c.Request.Context()
+	data, offset, e := tailer.GetTail(ctx, path, n)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if c.Query("follow") == "" {
+		lines, rest := splitLines(data)
+		if len(rest) > 0 {
+			lines = append(lines, string(rest))
+		}
+		SetResult(c, tailJson{Lines: lines, Offset: offset})
+		return
+	}
+
+	w := c.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	var pending []byte
+	send := func(data []byte, reset bool) error {
+		if reset {
+			pending = nil
+			if _, e := w.WriteString("event: reset\ndata:\n\n"); e != nil {
+				return e
+			}
+		}
+		lines, rest := splitLines(append(pending, data...))
+		pending = append([]byte(nil), rest...)
+		for _, line := range lines {
+			if _, e := w.WriteString("data: " + line + "\n\n"); e != nil {
+				return e
+			}
+		}
+		w.Flush()
+		return nil
+	}
+	if e := send(data, false); e != nil {
+		return
+	}
+	if e := tailer.Follow(ctx, path, offset, send); e != nil {
+		_, _ = w.WriteString("event: error\ndata: " + strings.ReplaceAll(e.Error(), "\n", " ") + "\n\n")
+		w.Flush()
+	}
+}
+
+// splitLines splits data into the complete lines and the remaining incomplete line
+func splitLines(data []byte) ([]string, []byte) {
+	lines := make([]string, 0)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return lines, data
+		}
+		lines = append(lines, string(bytes.TrimSuffix(data[:i], []byte("\r"))))
+		data = data[i+1:]
+	}
+}
@@ -13,15 +13,19 @@ import (
 	"go-drive/common/task"
 	"go-drive/common/types"
 	"go-drive/common/utils"
+	"go-drive/drive"
 	"image"
-	_ "image/gif"
+	"image/gif"
 	"image/jpeg"
-	_ "image/png"
+	"image/png"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	path2 "path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -30,8 +34,19 @@ const (
 	thumbnailSize    = 220
 	thumbnailQuality = 50
 	thumbnailTimeout = 30 * time.Second
+
+	// imageMaxDimension is the maximum width or height of the resized images
+	imageMaxDimension   = 4096
+	imageDefaultQuality = 85
 )
 
+// imageContentTypes are the formats the resized images can be encoded to
+var imageContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
 var supportedExtensions = make(map[string]bool)
 
 func init() {
@@ -51,7 +66,7 @@ type Thumbnail struct {
 	stopCleaner func()
 }
 
-func NewThumbnail(config common.Config, ch *registry.ComponentsHolder) (*Thumbnail, error) {
+func NewThumbnail(config common.Config, rootDrive *drive.RootDrive, ch *registry.ComponentsHolder) (*Thumbnail, error) {
 	dir, e := config.GetDir("thumbnails", true)
 	if e != nil {
 		return nil, e
@@ -64,15 +79,108 @@ func NewThumbnail(config common.Config, ch *registry.ComponentsHolder) (*Thumbna
 	}
 	t.pool = tunny.NewFunc(config.ThumbnailConcurrent, t.createThumbnail_)
 	t.stopCleaner = utils.TimeTick(t.clean, 12*time.Hour)
+	rootDrive.AddChangeListener(t.invalidate)
 	ch.Add("thumbnail", t)
 	return t, nil
 }
 
+// ImageOptions are the parameters of resizing an image
+type ImageOptions struct {
+	// Width and Height are the bounds of the resized image, 0 means unbounded.
+	// The aspect ratio is preserved, and the image is never enlarged.
+	Width  uint
+	Height uint
+	// Quality is the JPEG quality, 1-100
+	Quality int
+	// Format is one of the keys of imageContentTypes, the source format is kept if it's empty
+	Format string
+}
+
+// ParseImageOptions parses the query parameters of the image resizing
+func ParseImageOptions(w, h, quality, format string) (ImageOptions, error) {
+	o := ImageOptions{Quality: imageDefaultQuality, Format: strings.ToLower(format)}
+	for _, d := range []struct {
+		s string
+		v *uint
+	}{{w, &o.Width}, {h, &o.Height}} {
+		if d.s == "" {
+			continue
+		}
+		v, e := strconv.ParseUint(d.s, 10, 32)
+		if e != nil || v == 0 {
+			return o, err.NewBadRequestError(i18n.T("api.image.invalid_size", d.s))
+		}
+		if v > imageMaxDimension {
+			return o, err.NewBadRequestError(i18n.T("api.image.size_too_large", strconv.Itoa(imageMaxDimension)))
+		}
+		*d.v = uint(v)
+	}
+	if quality != "" {
+		q, e := strconv.Atoi(quality)
+		if e != nil || q < 1 || q > 100 {
+			return o, err.NewBadRequestError(i18n.T("api.image.invalid_quality", quality))
+		}
+		o.Quality = q
+	}
+	if o.Format == "jpg" {
+		o.Format = "jpeg"
+	}
+	if _, ok := imageContentTypes[o.Format]; o.Format != "" && !ok {
+		return o, err.NewBadRequestError(i18n.T("api.image.unsupported_format", format))
+	}
+	return o, nil
+}
+
+func (o ImageOptions) key() string {
+	return fmt.Sprintf("w%d-h%d-q%d-%s", o.Width, o.Height, o.Quality, o.Format)
+}
+
+// IsImage tells whether the entry is an image can be resized
+func IsImage(entry types.IEntry) bool {
+	return entry.Type().IsFile() && supportedExtensions[strings.ToLower(path2.Ext(entry.Path()))]
+}
+
+// Resize returns the image resized by opts, and its content type.
+// The results are cached by the path, modification time and opts.
+func (t *Thumbnail) Resize(entry types.IEntry, opts ImageOptions) (*os.File, string, error) {
+	if !IsImage(entry) {
+		return nil, "", err.NewNotFoundError()
+	}
+	if opts.Format == "" {
+		opts.Format = strings.TrimPrefix(strings.ToLower(path2.Ext(entry.Path())), ".")
+		if opts.Format == "jpg" {
+			opts.Format = "jpeg"
+		}
+	}
+	contentType := imageContentTypes[opts.Format]
+	filePath := t.getFile(entry.Path(), entry.ModTime()) + "-" + opts.key()
+	file, e := t.getCache(filePath)
+	if e != nil {
+		return nil, "", e
+	}
+	if file != nil {
+		return file, contentType, nil
+	}
+	content, ok := entry.(types.IContent)
+	if !ok {
+		return nil, "", err.NewNotAllowedError()
+	}
+	r, e := t.pool.ProcessTimed(thumbnailTask{path: filePath, content: content, opts: &opts}, thumbnailTimeout)
+	if e == tunny.ErrJobTimedOut {
+		return nil, "", err.NewTimeoutError("timeout")
+	}
+	if r != nil {
+		return nil, "", r.(error)
+	}
+	file, e = os.Open(filePath)
+	return file, contentType, e
+}
+
 func (t *Thumbnail) Create(entry types.IEntry) (*os.File, error) {
 	if !supportedExtensions[path2.Ext(entry.Path())] {
 		return nil, err.NewNotFoundError()
 	}
-	filePath := t.getFile(entry.Path())
+	filePath := t.getFile(entry.Path(), entry.ModTime())
 	file, e := t.getCache(filePath)
 	if e != nil {
 		return nil, e
@@ -94,27 +202,45 @@ func (t *Thumbnail) Create(entry types.IEntry) (*os.File, error) {
 	return os.Open(filePath)
 }
 
+// Remove removes the thumbnails of all versions of the path
 func (t *Thumbnail) Remove(path string) error {
-	filePath := t.getFile(path)
-	e := os.Remove(filePath)
-	if os.IsNotExist(e) {
-		return nil
+	files, e := filepath.Glob(t.getFilePrefix(path) + "*")
+	if e != nil {
+		return e
+	}
+	for _, f := range files {
+		if e := os.Remove(f); e != nil && !os.IsNotExist(e) {
+			return e
+		}
+	}
+	return nil
+}
+
+// invalidate is called when the entry at path was modified.
+// Thumbnails of the descendants of a directory are keyed by modTime, so they are never served stale
+// and will be cleaned when expired.
+func (t *Thumbnail) invalidate(path string) {
+	if e := t.Remove(path); e != nil {
+		log.Printf("error when removing thumbnail of '%s': %v", path, e)
 	}
-	return e
 }
 
 func (t *Thumbnail) createThumbnail_(payload interface{}) interface{} {
 	tTask := payload.(thumbnailTask)
+	if tTask.opts != nil {
+		return t.resizeImage(tTask.content, tTask.path, *tTask.opts)
+	}
 	return t.createThumbnail(tTask.content, tTask.path)
 }
 
-func (t *Thumbnail) createThumbnail(content types.IContent, filePath string) error {
+// decodeImage reads the image of content, the too large images are refused
+func (t *Thumbnail) decodeImage(content types.IContent) (image.Image, error) {
 	if content.Size() > t.maxSize {
-		return err.NewNotFoundMessageError(i18n.T("api.thumbnail.file_too_large"))
+		return nil, err.NewNotFoundMessageError(i18n.T("api.thumbnail.file_too_large"))
 	}
 	tempFile, e := drive_util.CopyIContentToTempFile(task.DummyContext(), content, t.cacheDir)
 	if e != nil {
-		return e
+		return nil, e
 	}
 	defer func() {
 		_ = tempFile.Close()
@@ -122,25 +248,64 @@ func (t *Thumbnail) createThumbnail(content types.IContent, filePath string) err
 	}()
 	imgConf, _, e := image.DecodeConfig(tempFile)
 	if e != nil {
-		return e
+		return nil, e
 	}
 	if imgConf.Width*imgConf.Height > t.maxPixels {
-		return err.NewNotFoundMessageError(i18n.T("api.thumbnail.image_too_large"))
+		return nil, err.NewNotFoundMessageError(i18n.T("api.thumbnail.image_too_large"))
 	}
 	_, e = tempFile.Seek(0, 0)
 	if e != nil {
-		return e
+		return nil, e
 	}
 	img, _, e := image.Decode(tempFile)
+	return img, e
+}
+
+func (t *Thumbnail) createThumbnail(content types.IContent, filePath string) error {
+	img, e := t.decodeImage(content)
 	if e != nil {
 		return e
 	}
 	resizedImg := resize.Thumbnail(thumbnailSize, thumbnailSize, img, resize.NearestNeighbor)
+	return t.saveImage(filePath, func(w io.Writer) error {
+		return jpeg.Encode(w, resizedImg, &jpeg.Options{Quality: thumbnailQuality})
+	})
+}
+
+func (t *Thumbnail) resizeImage(content types.IContent, filePath string, opts ImageOptions) error {
+	img, e := t.decodeImage(content)
+	if e != nil {
+		return e
+	}
+	if opts.Width > 0 || opts.Height > 0 {
+		maxWidth, maxHeight := opts.Width, opts.Height
+		if maxWidth == 0 {
+			maxWidth = math.MaxUint32
+		}
+		if maxHeight == 0 {
+			maxHeight = math.MaxUint32
+		}
+		img = resize.Thumbnail(maxWidth, maxHeight, img, resize.Bilinear)
+	}
+	return t.saveImage(filePath, func(w io.Writer) error {
+		switch opts.Format {
+		case "png":
+			return png.Encode(w, img)
+		case "gif":
+			return gif.Encode(w, img, nil)
+		default:
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.Quality})
+		}
+	})
+}
+
+// saveImage writes the image by encode to filePath atomically
+func (t *Thumbnail) saveImage(filePath string, encode func(w io.Writer) error) error {
 	dstFile, e := ioutil.TempFile(t.cacheDir, "temp-")
 	if e != nil {
 		return e
 	}
-	if e := jpeg.Encode(dstFile, resizedImg, &jpeg.Options{Quality: thumbnailQuality}); e != nil {
+	if e := encode(dstFile); e != nil {
 		_ = dstFile.Close()
 		_ = os.Remove(dstFile.Name())
 		return e
@@ -170,16 +335,20 @@ func (t *Thumbnail) isExpired(modTime time.Time) bool {
 	return modTime.Before(time.Now().Add(-t.validity))
 }
 
-func (t *Thumbnail) getFile(path string) string {
+func (t *Thumbnail) getFilePrefix(path string) string {
 	key := md5.Sum([]byte(path))
-	return filepath.Join(t.cacheDir, fmt.Sprintf("%x", key))
+	return filepath.Join(t.cacheDir, fmt.Sprintf("%x-", key))
+}
+
+func (t *Thumbnail) getFile(path string, modTime int64) string {
+	return t.getFilePrefix(path) + strconv.FormatInt(modTime, 10)
 }
 
 func (t *Thumbnail) clean() {
 	n := 0
 	notBefore := time.Now().Add(-t.validity)
 	e := filepath.Walk(t.cacheDir, func(path string, info os.FileInfo, e error) error {
-		if e != nil || info.IsDir() || !strings.HasPrefix(filepath.Base(path), sessionPrefix) {
+		if e != nil || info.IsDir() {
 			return nil
 		}
 		if info.ModTime().Before(notBefore) {
@@ -207,4 +376,6 @@ func (t *Thumbnail) Dispose() error {
 type thumbnailTask struct {
 	path    string
 	content types.IContent
+	// opts is not nil if it's resizing an image
+	opts *ImageOptions
 }
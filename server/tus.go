@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+)
+
+// tusRoute serves the tus resumable upload protocol(https://tus.io/protocols/resumable-upload) 1.0.0
+// with the creation, expiration and termination extensions.
+// The uploads are the append uploads of ChunkUploader, and the file is saved to the drive when all bytes are received.
+// The path to save to is the 'path' in Upload-Metadata, the existing file is replaced if 'override' is not empty.
+// The uploads are only accessible to their creators, and saved with the permissions of them.
+type tusRoute struct {
+	dr *driveRoute
+}
+
+func initTusRoutes(router gin.IRouter, r gin.IRouter, dr *driveRoute) {
+	t := &tusRoute{dr: dr}
+	// the CORS preflight requests carry no token
+	router.OPTIONS("/tus", t.options)
+	router.OPTIONS("/tus/:id", t.options)
+	r.POST("/tus", t.resumable, t.create)
+	r.HEAD("/tus/:id", t.resumable, t.head)
+	r.PATCH("/tus/:id", t.resumable, t.patch)
+	r.DELETE("/tus/:id", t.resumable, t.delete)
+}
+
+func (t *tusRoute) options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Status(http.StatusNoContent)
+}
+
+// resumable rejects the requests of other versions of the protocol
+func (t *tusRoute) resumable(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+	}
+}
+
+// getUpload returns the upload of the id, the uploads of other users are not found
+func (t *tusRoute) getUpload(c *gin.Context, id string) (*AppendUpload, error) {
+	upload, e := t.dr.chunkUploader.GetAppendUpload(id)
+	if e != nil {
+		return nil, e
+	}
+	if !t.owns(c, upload) {
+		return nil, err.NewNotFoundError()
+	}
+	return upload, nil
+}
+
+func (t *tusRoute) owns(c *gin.Context, upload *AppendUpload) bool {
+	return upload.Owner == GetSession(c).User.Username
+}
+
+func (t *tusRoute) setUploadHeaders(c *gin.Context, upload *AppendUpload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", utils.Time(upload.ExpiresAt).UTC().Format(http.TimeFormat))
+}
+
+func (t *tusRoute) create(c *gin.Context) {
+	size, e := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if e != nil || size < 0 {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.tus.invalid_upload_length")))
+		return
+	}
+	meta := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	path := utils.CleanPath(meta["path"])
+	if path == "" {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.tus.missing_path")))
+		return
+	}
+	override := meta["override"] != ""
+	// fail early rather than after the content is uploaded
+	if !override {
+		if _, e := drive_util.RequireFileNotExists(c.Request.Context(), t.dr.getDrive(c), path); e != nil {
+			_ = c.Error(e)
+			return
+		}
+	}
+	upload, e := t.dr.chunkUploader.CreateAppendUpload(size, path, override, GetSession(c).User.Username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	t.setUploadHeaders(c, &upload)
+	// relative to the URL of this request
+	c.Header("Location", "tus/"+upload.Id)
+	c.Status(http.StatusCreated)
+}
+
+func (t *tusRoute) head(c *gin.Context) {
+	upload, e := t.getUpload(c, c.Param("id"))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	t.setUploadHeaders(c, upload)
+	c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+func (t *tusRoute) patch(c *gin.Context) {
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, e := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if e != nil || offset < 0 {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.tus.invalid_upload_offset")))
+		return
+	}
+	id := c.Param("id")
+	upload, e := t.getUpload(c, id)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if offset != upload.Offset {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+	drive_util.SetBandwidthLimitHeader(c.Writer.Header(), t.dr.config.UploadRateLimit)
+	upload, e = t.dr.chunkUploader.Append(id, offset,
+		drive_util.ThrottledReader(c.Request.Body, t.dr.config.UploadRateLimit))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if upload.Offset == upload.Size {
+		if e := t.save(c, upload); e != nil {
+			_ = c.Error(e)
+			return
+		}
+	}
+	t.setUploadHeaders(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+// save saves the finished upload to the drive of its owner, the upload is kept if it fails,
+// so it's saved again by the retry of the last PATCH.
+// The upload is locked while saving, the concurrent PATCHes finishing it are refused or find it saved
+func (t *tusRoute) save(c *gin.Context, upload *AppendUpload) error {
+	release, e := t.dr.chunkUploader.LockAppendUpload(upload.Id)
+	if e != nil {
+		return e
+	}
+	defer release()
+	upload, e = t.getUpload(c, upload.Id)
+	if e != nil {
+		return e
+	}
+	file, e := t.dr.chunkUploader.OpenAppendUpload(upload.Id)
+	if e != nil {
+		return e
+	}
+	defer func() { _ = file.Close() }()
+	if _, e := t.dr.getDrive(c).Save(task.DummyContext(), upload.Path, upload.Size, upload.Override, file); e != nil {
+		return e
+	}
+	if e := t.dr.chunkUploader.DeleteAppendUpload(upload.Id); e != nil {
+		log.Printf("error deleting the finished upload %s: %v", upload.Id, e)
+	}
+	return nil
+}
+
+func (t *tusRoute) delete(c *gin.Context) {
+	if _, e := t.getUpload(c, c.Param("id")); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if e := t.dr.chunkUploader.DeleteAppendUpload(c.Param("id")); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// parseTusMetadata parses Upload-Metadata like 'path L2EvYi50eHQ=,override MQ==', the values are base64 encoded
+func parseTusMetadata(header string) map[string]string {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value := pair, ""
+		if i := strings.IndexByte(pair, ' '); i >= 0 {
+			key = pair[:i]
+			b, e := base64.StdEncoding.DecodeString(strings.TrimSpace(pair[i+1:]))
+			if e != nil {
+				continue
+			}
+			value = string(b)
+		}
+		meta[key] = value
+	}
+	return meta
+}
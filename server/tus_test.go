@@ -0,0 +1,126 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-drive/common"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	path2 "path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tusRequest(h http.Handler, method, path, user string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestTusOwner(t *testing.T) {
+	s := newTestServer(t, common.Config{})
+	defer s.close()
+	var e error
+	s.dr.chunkUploader, e = NewChunkUploader(common.Config{}, s.ch)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := s.userDAO.AddUser(types.User{Username: "other", Password: "other"}); e != nil {
+		t.Fatal(e)
+	}
+	h := s.engine()
+	// the session is of the user in X-User, the requests without it are refused like without a token
+	r := h.Group("/", func(c *gin.Context) {
+		user, e := s.userDAO.GetUser(c.GetHeader("X-User"))
+		if e != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		SetSession(c, types.Session{User: user})
+	})
+	initTusRoutes(h, r, s.dr)
+
+	if w := tusRequest(h, "OPTIONS", "/tus", "", nil, ""); w.Code != http.StatusNoContent {
+		t.Errorf("OPTIONS without a token: %d", w.Code)
+	}
+	w := tusRequest(h, "POST", "/tus", "admin", map[string]string{
+		"Upload-Length":   "5",
+		"Upload-Metadata": "path Yi90dXMudHh0", // b/tus.txt
+	}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", w.Code, w.Body.String())
+	}
+	u := "/" + w.Header().Get("Location")
+
+	patch := map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}
+	for _, method := range []string{"HEAD", "PATCH", "DELETE"} {
+		if w := tusRequest(h, method, u, "other", patch, "hello"); w.Code != http.StatusNotFound {
+			t.Errorf("%s by another user: %d", method, w.Code)
+		}
+	}
+	if w := tusRequest(h, "HEAD", u, "admin", nil, ""); w.Code != http.StatusOK ||
+		w.Header().Get("Upload-Offset") != "0" {
+		t.Fatalf("HEAD by the owner: %d %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if w := tusRequest(h, "PATCH", u, "admin", patch, "hello"); w.Code != http.StatusNoContent {
+		t.Fatalf("PATCH by the owner: %d %s", w.Code, w.Body.String())
+	}
+	entry, e := s.dr.rootDrive.Get().Get(task.DummyContext(), "b/tus.txt")
+	if e != nil || entry.Size() != 5 {
+		t.Errorf("the upload should be saved: %v %v", entry, e)
+	}
+}
+
+func TestAppendUploadExpiry(t *testing.T) {
+	s := newTestServer(t, common.Config{})
+	defer s.close()
+	c, e := NewChunkUploader(common.Config{}, s.ch)
+	if e != nil {
+		t.Fatal(e)
+	}
+	upload, e := c.CreateAppendUpload(10, "b/a.txt", false, "admin")
+	if e != nil {
+		t.Fatal(e)
+	}
+	// the upload was created long ago, but it's still being appended
+	old := time.Now().Add(-2 * appendUploadTTL)
+	if e := os.Chtimes(c.getDir(upload.Id), old, old); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := c.Append(upload.Id, 0, strings.NewReader("hello")); e != nil {
+		t.Fatal(e)
+	}
+	c.clean()
+	if _, e := c.GetAppendUpload(upload.Id); e != nil {
+		t.Fatalf("the upload being appended should be kept: %v", e)
+	}
+
+	release, e := c.LockAppendUpload(upload.Id)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := c.Append(upload.Id, 5, strings.NewReader("world")); e == nil || e.(err.RequestError).Code() != http.StatusConflict {
+		t.Errorf("the busy upload should not be appended: %v", e)
+	}
+	release()
+
+	if e := os.Chtimes(path2.Join(c.getDir(upload.Id), "file"), old, old); e != nil {
+		t.Fatal(e)
+	}
+	c.clean()
+	if _, e := os.Stat(c.getDir(upload.Id)); !os.IsNotExist(e) {
+		t.Errorf("the upload not appended for a long time should be deleted: %v", e)
+	}
+}
@@ -72,3 +72,21 @@ func TranslateV(c *gin.Context, ms i18n.MessageSource, v interface{}) interface{
 	}
 	return i18n.TranslateV(lang, ms, v)
 }
+
+// apiBaseURL returns the absolute URL of the API root, derived from the URL of the request to route/*path
+func apiBaseURL(c *gin.Context, route string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if p := c.GetHeader("X-Forwarded-Proto"); p != "" {
+		scheme = p
+	}
+	host := c.Request.Host
+	if h := c.GetHeader("X-Forwarded-Host"); h != "" {
+		host = h
+	}
+	prefix := strings.TrimSuffix(c.Request.URL.Path, c.Param("path"))
+	prefix = strings.TrimSuffix(prefix, route)
+	return scheme + "://" + host + prefix
+}
@@ -0,0 +1,512 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	path2 "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	webDAVRealm = "go-drive"
+	// webDAVAuthTTL is how long a verified credential is remembered,
+	// as the clients send it with every request and bcrypt is slow
+	webDAVAuthTTL  = 5 * time.Minute
+	webDAVMaxAuths = 1000
+	webDAVLockTTL  = 3600
+)
+
+var webDAVMethods = []string{"OPTIONS", "PROPFIND", "PROPPATCH", "GET", "HEAD", "PUT",
+	"MKCOL", "DELETE", "COPY", "MOVE", "LOCK", "UNLOCK"}
+
+// webDAVRoute serves the drives as a WebDAV(class 1 and 2) endpoint.
+// Users are authenticated by HTTP basic authentication, and the requests are served
+// by the same permission wrapped drive as the API, so the path permissions are respected.
+// Locks are not enforced, LOCK always succeeds with a new token, as some clients refuse to write without locks.
+// Locking an unmapped path creates an empty file, as the clients creating files by LOCK before PUT expect.
+type webDAVRoute struct {
+	dr      *driveRoute
+	prefix  string
+	userDAO *storage.UserDAO
+
+	authMux sync.Mutex
+	// auths is the sha256 of the verified credentials to the time they expire
+	auths map[string]time.Time
+}
+
+func initWebDAVRoutes(router gin.IRouter, dr *driveRoute, prefix string, userDAO *storage.UserDAO) {
+	prefix = "/" + strings.Trim(prefix, "/")
+	w := &webDAVRoute{dr: dr, prefix: prefix, userDAO: userDAO, auths: make(map[string]time.Time)}
+	for _, method := range webDAVMethods {
+		router.Handle(method, prefix+"/*path", w.auth, w.serve)
+	}
+}
+
+func (w *webDAVRoute) auth(c *gin.Context) {
+	username, password, ok := c.Request.BasicAuth()
+	if ok {
+		if user, e := w.authenticate(username, password); e == nil {
+			SetSession(c, types.Session{User: user})
+			c.Next()
+			return
+		}
+	}
+	c.Header("WWW-Authenticate", `Basic realm="`+webDAVRealm+`"`)
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+func (w *webDAVRoute) authenticate(username, password string) (types.User, error) {
+	user, e := w.userDAO.GetUser(username)
+	if e != nil {
+		return user, e
+	}
+	key := fmt.Sprintf("%x", sha256.Sum256([]byte(username+"\n"+user.Password+"\n"+password)))
+	now := time.Now()
+	w.authMux.Lock()
+	expiresAt, ok := w.auths[key]
+	w.authMux.Unlock()
+	if ok && now.Before(expiresAt) {
+		return user, nil
+	}
+	user, e = w.userDAO.Authenticate(username, password)
+	if e != nil {
+		return user, e
+	}
+	w.authMux.Lock()
+	if len(w.auths) >= webDAVMaxAuths {
+		w.auths = make(map[string]time.Time)
+	}
+	w.auths[key] = now.Add(webDAVAuthTTL)
+	w.authMux.Unlock()
+	return user, nil
+}
+
+func (w *webDAVRoute) serve(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	var status int
+	var e error
+	switch c.Request.Method {
+	case "OPTIONS":
+		status, e = w.options(c)
+	case "PROPFIND":
+		status, e = w.propfind(c, path)
+	case "PROPPATCH":
+		status, e = w.proppatch(c, path)
+	case "GET", "HEAD":
+		status, e = w.get(c, path)
+	case "PUT":
+		status, e = w.put(c, path)
+	case "MKCOL":
+		status, e = w.mkcol(c, path)
+	case "DELETE":
+		status, e = w.delete(c, path)
+	case "COPY", "MOVE":
+		status, e = w.copyOrMove(c, path, c.Request.Method == "MOVE")
+	case "LOCK":
+		status, e = w.lock(c, path)
+	case "UNLOCK":
+		status = http.StatusNoContent
+	}
+	if e != nil {
+		status = webDAVStatus(e)
+	}
+	if status != 0 {
+		c.Status(status)
+		if status >= 400 {
+			_, _ = c.Writer.WriteString(http.StatusText(status))
+		}
+	}
+}
+
+// webDAVStatus maps the errors to the status codes, the codes of the RequestErrors fit WebDAV already
+func webDAVStatus(e error) int {
+	if re, ok := e.(err.RequestError); ok {
+		return re.Code()
+	}
+	return http.StatusInternalServerError
+}
+
+func (w *webDAVRoute) options(c *gin.Context) (int, error) {
+	c.Header("Allow", strings.Join(webDAVMethods, ", "))
+	c.Header("DAV", "1, 2")
+	c.Header("MS-Author-Via", "DAV")
+	return http.StatusOK, nil
+}
+
+// exists tells whether the path exists, nil is returned if it does not
+func (w *webDAVRoute) exists(c *gin.Context, drive types.IDrive, path string) (types.IEntry, error) {
+	entry, e := drive.Get(c.Request.Context(), path)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return nil, nil
+		}
+		return nil, e
+	}
+	return entry, nil
+}
+
+// checkParent returns 409 Conflict if the parent collection of path does not exist
+func (w *webDAVRoute) checkParent(c *gin.Context, drive types.IDrive, path string) (int, error) {
+	parent, e := w.exists(c, drive, utils.PathParent(path))
+	if e != nil {
+		return 0, e
+	}
+	if parent == nil || !parent.Type().IsDir() {
+		return http.StatusConflict, nil
+	}
+	return 0, nil
+}
+
+func (w *webDAVRoute) propfind(c *gin.Context, path string) (int, error) {
+	drive := w.dr.getDrive(c)
+	depth := c.GetHeader("Depth")
+	if strings.EqualFold(depth, "infinity") {
+		// infinite depth is not supported, see RFC 4918 9.1
+		c.Header("Content-Type", "application/xml; charset=utf-8")
+		c.Status(http.StatusForbidden)
+		_, e := c.Writer.WriteString(`<?xml version="1.0" encoding="utf-8"?>` +
+			`<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`)
+		return 0, e
+	}
+	if depth == "" {
+		// the clients omitting Depth expect the children rather than an error
+		depth = "1"
+	}
+	entry, e := drive.Get(c.Request.Context(), path)
+	if e != nil {
+		return 0, e
+	}
+	entries := []types.IEntry{entry}
+	if depth != "0" && entry.Type().IsDir() {
+		children, e := drive.List(c.Request.Context(), path)
+		if e != nil {
+			return 0, e
+		}
+		entries = append(entries, children...)
+	}
+	ms := davMultistatus{XmlnsD: "DAV:"}
+	for i, entry := range entries {
+		prop := davEntryProp(entry)
+		// the quota of the requested collection only, as the space of the children may be slow to get
+		if i == 0 && entry.Type().IsDir() {
+			if reporter, ok := drive.(types.ISpaceReporter); ok {
+				if free, total, e := reporter.Space(c.Request.Context(), path); e == nil && total >= free {
+					used := total - free
+					prop.QuotaAvailable, prop.QuotaUsed = &free, &used
+				}
+			}
+		}
+		ms.Responses = append(ms.Responses, davResponse{
+			Href:     w.href(entry.Path(), entry.Type().IsDir()),
+			Propstat: []davPropstat{{Prop: prop, Status: davStatus(http.StatusOK)}},
+		})
+	}
+	return 0, writeXML(c, http.StatusMultiStatus, ms)
+}
+
+// proppatch refuses to set any property, as the drives have no place to keep them
+func (w *webDAVRoute) proppatch(c *gin.Context, path string) (int, error) {
+	entry, e := w.dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		return 0, e
+	}
+	update := davPropertyUpdate{}
+	if e := xml.NewDecoder(c.Request.Body).Decode(&update); e != nil {
+		return http.StatusBadRequest, nil
+	}
+	names := make([]davAnyProp, 0)
+	for _, list := range append(update.Set, update.Remove...) {
+		for _, p := range list.Prop.Props {
+			names = append(names, davAnyProp{XMLName: p.XMLName})
+		}
+	}
+	ms := davMultistatus{XmlnsD: "DAV:", Responses: []davResponse{{
+		Href: w.href(path, entry.Type().IsDir()),
+		Propstat: []davPropstat{{
+			Prop:   davProp{Any: names},
+			Status: davStatus(http.StatusForbidden),
+		}},
+	}}}
+	return 0, writeXML(c, http.StatusMultiStatus, ms)
+}
+
+func (w *webDAVRoute) get(c *gin.Context, path string) (int, error) {
+	entry, e := w.dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		return 0, e
+	}
+	content, ok := entry.(types.IContent)
+	if !ok || !entry.Type().IsFile() {
+		return http.StatusMethodNotAllowed, nil
+	}
+	maxProxySize := w.dr.config.ProxyMaxSize
+	return 0, drive_util.DownloadIContent(c.Request.Context(), content, c.Writer, c.Request,
+		maxProxySize <= 0 || entry.Size() <= maxProxySize, w.dr.config.DownloadRateLimit)
+}
+
+func (w *webDAVRoute) put(c *gin.Context, path string) (int, error) {
+	drive := w.dr.getDrive(c)
+	defer func() { _ = c.Request.Body.Close() }()
+	if status, e := w.checkParent(c, drive, path); status != 0 || e != nil {
+		return status, e
+	}
+	old, e := w.exists(c, drive, path)
+	if e != nil {
+		return 0, e
+	}
+	if old != nil && old.Type().IsDir() {
+		return http.StatusMethodNotAllowed, nil
+	}
+	// the clients may send the content chunked, whose size is unknown
+	file, e := drive_util.CopyReaderToTempFile(task.DummyContext(),
+		drive_util.ThrottledReader(c.Request.Body, w.dr.config.UploadRateLimit), w.dr.config.TempDir)
+	if e != nil {
+		return 0, e
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	stat, e := file.Stat()
+	if e != nil {
+		return 0, e
+	}
+	if _, e := drive.Save(task.DummyContext(), path, stat.Size(), true, file); e != nil {
+		return 0, e
+	}
+	if old != nil {
+		return http.StatusNoContent, nil
+	}
+	return http.StatusCreated, nil
+}
+
+func (w *webDAVRoute) mkcol(c *gin.Context, path string) (int, error) {
+	if c.Request.ContentLength > 0 {
+		return http.StatusUnsupportedMediaType, nil
+	}
+	drive := w.dr.getDrive(c)
+	old, e := w.exists(c, drive, path)
+	if e != nil {
+		return 0, e
+	}
+	if old != nil {
+		return http.StatusMethodNotAllowed, nil
+	}
+	if status, e := w.checkParent(c, drive, path); status != 0 || e != nil {
+		return status, e
+	}
+	if _, e := drive.MakeDir(c.Request.Context(), path); e != nil {
+		return 0, e
+	}
+	return http.StatusCreated, nil
+}
+
+func (w *webDAVRoute) delete(c *gin.Context, path string) (int, error) {
+	if e := w.dr.getDrive(c).Delete(task.DummyContext(), path); e != nil {
+		return 0, e
+	}
+	return http.StatusNoContent, nil
+}
+
+func (w *webDAVRoute) copyOrMove(c *gin.Context, from string, move bool) (int, error) {
+	to, ok := w.destination(c)
+	if !ok {
+		return http.StatusBadGateway, nil
+	}
+	if e := checkCopyOrMove(from, to); e != nil {
+		return 0, e
+	}
+	override := c.GetHeader("Overwrite") != "F"
+	drive := w.dr.getDrive(c)
+	fromEntry, e := drive.Get(c.Request.Context(), from)
+	if e != nil {
+		return 0, e
+	}
+	old, e := w.exists(c, drive, to)
+	if e != nil {
+		return 0, e
+	}
+	if old != nil && !override {
+		return http.StatusPreconditionFailed, nil
+	}
+	if status, e := w.checkParent(c, drive, to); status != 0 || e != nil {
+		return status, e
+	}
+	if move {
+		_, e = drive.Move(task.DummyContext(), fromEntry, to, override)
+	} else {
+		_, e = drive.Copy(task.DummyContext(), fromEntry, to, override)
+	}
+	if e != nil {
+		return 0, e
+	}
+	if old != nil {
+		return http.StatusNoContent, nil
+	}
+	return http.StatusCreated, nil
+}
+
+// destination returns the path of the Destination header, false if it's not on this endpoint
+func (w *webDAVRoute) destination(c *gin.Context) (string, bool) {
+	u, e := url.Parse(c.GetHeader("Destination"))
+	if e != nil || (u.Host != "" && !strings.EqualFold(u.Host, c.Request.Host)) {
+		return "", false
+	}
+	if u.Path != w.prefix && !strings.HasPrefix(u.Path, w.prefix+"/") {
+		return "", false
+	}
+	return utils.CleanPath(strings.TrimPrefix(u.Path, w.prefix)), true
+}
+
+func (w *webDAVRoute) lock(c *gin.Context, path string) (int, error) {
+	drive := w.dr.getDrive(c)
+	entry, e := w.exists(c, drive, path)
+	if e != nil {
+		return 0, e
+	}
+	status := http.StatusOK
+	if entry == nil {
+		// locking an unmapped path creates an empty resource, see RFC 4918 9.10.4
+		if status, e := w.checkParent(c, drive, path); status != 0 || e != nil {
+			return status, e
+		}
+		if _, e := drive.Save(task.DummyContext(), path, 0, false, strings.NewReader("")); e != nil {
+			return 0, e
+		}
+		status = http.StatusCreated
+	}
+	token := "opaquelocktoken:" + uuid.New().String()
+	if ifHeader := c.GetHeader("If"); ifHeader != "" && c.Request.ContentLength <= 0 {
+		// refreshing a lock, keep its token
+		if i, j := strings.Index(ifHeader, "(<"), strings.Index(ifHeader, ">)"); i >= 0 && j > i {
+			token = ifHeader[i+2 : j]
+		}
+	}
+	depth := "infinity"
+	if c.GetHeader("Depth") == "0" {
+		depth = "0"
+	}
+	c.Header("Lock-Token", "<"+token+">")
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	c.Status(status)
+	_, e = fmt.Fprintf(c.Writer, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`+
+		`<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>`+
+		`<D:depth>%s</D:depth><D:timeout>Second-%d</D:timeout>`+
+		`<D:locktoken><D:href>%s</D:href></D:locktoken>`+
+		`<D:lockroot><D:href>%s</D:href></D:lockroot>`+
+		`</D:activelock></D:lockdiscovery></D:prop>`,
+		depth, webDAVLockTTL, xmlEscape(token), xmlEscape(w.href(path, entry != nil && entry.Type().IsDir())))
+	return 0, e
+}
+
+func (w *webDAVRoute) href(path string, dir bool) string {
+	p := path2.Join(w.prefix, path)
+	if dir && !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+func writeXML(c *gin.Context, status int, v interface{}) error {
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	c.Status(status)
+	if _, e := io.WriteString(c.Writer, xml.Header); e != nil {
+		return e
+	}
+	return xml.NewEncoder(c.Writer).Encode(v)
+}
+
+func xmlEscape(s string) string {
+	buf := strings.Builder{}
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func davStatus(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
+
+func davEntryProp(entry types.IEntry) davProp {
+	p := davProp{DisplayName: utils.PathBase(entry.Path()), ResourceType: &davResourceType{}}
+	if entry.Type().IsDir() {
+		p.ResourceType.Collection = &struct{}{}
+		return p
+	}
+	size := entry.Size()
+	if size >= 0 {
+		p.ContentLength = &size
+	}
+	if content, ok := entry.(types.IContent); ok {
+		p.LastModified, p.ETag = drive_util.ContentValidators(content)
+	}
+	p.ContentType = mime.TypeByExtension(path2.Ext(entry.Path()))
+	if p.ContentType == "" {
+		p.ContentType = "application/octet-stream"
+	}
+	return p
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	XmlnsD    string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string        `xml:"D:href"`
+	Propstat []davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	DisplayName   string           `xml:"D:displayname,omitempty"`
+	ResourceType  *davResourceType `xml:"D:resourcetype,omitempty"`
+	ContentLength *int64           `xml:"D:getcontentlength,omitempty"`
+	LastModified  string           `xml:"D:getlastmodified,omitempty"`
+	ETag          string           `xml:"D:getetag,omitempty"`
+	ContentType   string           `xml:"D:getcontenttype,omitempty"`
+	// the quota of the collections, see RFC 4331
+	QuotaAvailable *int64 `xml:"D:quota-available-bytes,omitempty"`
+	QuotaUsed      *int64 `xml:"D:quota-used-bytes,omitempty"`
+	Any            []davAnyProp
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+type davAnyProp struct {
+	XMLName xml.Name
+}
+
+type davPropertyUpdate struct {
+	Set    []davPropList `xml:"set"`
+	Remove []davPropList `xml:"remove"`
+}
+
+type davPropList struct {
+	Prop struct {
+		Props []davAnyProp `xml:",any"`
+	} `xml:"prop"`
+}
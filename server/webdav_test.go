@@ -0,0 +1,117 @@
+package server
+
+import (
+	"go-drive/common"
+	"go-drive/common/task"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestWebDAV(t *testing.T, config common.Config) (*testServer, http.Handler) {
+	s := newTestServer(t, config)
+	r := s.engine()
+	initWebDAVRoutes(r, s.dr, "/dav", s.userDAO)
+	return s, r
+}
+
+func davRequest(h http.Handler, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://example.com"+path, nil)
+	req.SetBasicAuth("admin", "123456")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestWebDAVPropfindDepth(t *testing.T) {
+	s, h := newTestWebDAV(t, common.Config{})
+	defer s.close()
+
+	w := davRequest(h, "PROPFIND", "/dav/", nil)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("missing Depth: %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "/dav/a/") || !strings.Contains(w.Body.String(), "/dav/b/") {
+		t.Errorf("missing Depth should list the children: %s", w.Body.String())
+	}
+	w = davRequest(h, "PROPFIND", "/dav/", map[string]string{"Depth": "0"})
+	if w.Code != http.StatusMultiStatus || strings.Contains(w.Body.String(), "/dav/a/") {
+		t.Errorf("Depth 0: %d %s", w.Code, w.Body.String())
+	}
+	w = davRequest(h, "PROPFIND", "/dav/", map[string]string{"Depth": "infinity"})
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "propfind-finite-depth") {
+		t.Errorf("Depth infinity: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWebDAVLock(t *testing.T) {
+	s, h := newTestWebDAV(t, common.Config{})
+	defer s.close()
+
+	if w := davRequest(h, "LOCK", "/dav/a/new.txt", nil); w.Code != http.StatusCreated {
+		t.Fatalf("LOCK unmapped: %d", w.Code)
+	}
+	entry, e := s.dr.rootDrive.Get().Get(task.DummyContext(), "a/new.txt")
+	if e != nil || !entry.Type().IsFile() || entry.Size() != 0 {
+		t.Fatalf("LOCK should create an empty file: %v %v", entry, e)
+	}
+	if w := davRequest(h, "LOCK", "/dav/a/new.txt", nil); w.Code != http.StatusOK {
+		t.Errorf("LOCK existing: %d", w.Code)
+	}
+	if w := davRequest(h, "LOCK", "/dav/a/missing/new.txt", nil); w.Code != http.StatusConflict {
+		t.Errorf("LOCK without parent: %d", w.Code)
+	}
+}
+
+func TestWebDAVDestination(t *testing.T) {
+	s, h := newTestWebDAV(t, common.Config{})
+	defer s.close()
+
+	if w := davRequest(h, "PUT", "/dav/a/file.txt", nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT: %d", w.Code)
+	}
+	w := davRequest(h, "MOVE", "/dav/a/file.txt",
+		map[string]string{"Destination": "http://other.example.com/dav/b/file.txt"})
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("MOVE to another host: %d", w.Code)
+	}
+	w = davRequest(h, "MOVE", "/dav/a/file.txt",
+		map[string]string{"Destination": "http://other.example.com/other/b/file.txt"})
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("MOVE to another endpoint: %d", w.Code)
+	}
+	// moving across the drives on this endpoint, by the absolute URL and the path
+	w = davRequest(h, "MOVE", "/dav/a/file.txt",
+		map[string]string{"Destination": "http://EXAMPLE.com/dav/b/file.txt"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("MOVE across the drives: %d", w.Code)
+	}
+	w = davRequest(h, "MOVE", "/dav/b/file.txt", map[string]string{"Destination": "/dav/a/moved.txt"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("MOVE by the path: %d", w.Code)
+	}
+	root := s.dr.rootDrive.Get()
+	if _, e := root.Get(task.DummyContext(), "a/moved.txt"); e != nil {
+		t.Error(e)
+	}
+	if _, e := root.Get(task.DummyContext(), "b/file.txt"); e == nil {
+		t.Error("the source should be moved")
+	}
+}
+
+func TestWebDAVQuotaAudited(t *testing.T) {
+	s, h := newTestWebDAV(t, common.Config{AuditLog: "audit.log"})
+	defer s.close()
+
+	w := davRequest(h, "PROPFIND", "/dav/a/", map[string]string{"Depth": "0"})
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND: %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "quota-available-bytes>1048576<") {
+		t.Errorf("the quota should be reported with the audit log on: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,397 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"github.com/gin-gonic/gin"
+	"go-drive/common/drive_util"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"net/http"
+	"net/url"
+	"os"
+	path2 "path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tokenKindWOPI = "wopi"
+	// wopiTokenTTL is the validity of the access tokens, which are not renewed while editing
+	wopiTokenTTL = 10 * time.Hour
+	// wopiLockTTL is the expiration of the locks, which are refreshed by the client while editing
+	wopiLockTTL          = 30 * time.Minute
+	wopiDiscoveryTTL     = 1 * time.Hour
+	wopiMaxLockLength    = 1024
+	headerWOPIOverride   = "X-WOPI-Override"
+	headerWOPILock       = "X-WOPI-Lock"
+	headerWOPIOldLock    = "X-WOPI-OldLock"
+	headerWOPIVersion    = "X-WOPI-ItemVersion"
+	headerWOPILockReason = "X-WOPI-LockFailureReason"
+)
+
+// wopiPlaceholder matches the placeholders like '<ui=UI_LLCC&>' in the urlsrc of the discovery
+var wopiPlaceholder = regexp.MustCompile(`<[^>]*>`)
+
+// wopiRoute is the WOPI host(https://docs.microsoft.com/en-us/microsoft-365/cloud-storage-partner-program/rest/)
+// of the drives, for the WOPI clients like Collabora Online to edit the office documents.
+// It implements CheckFileInfo, GetFile, PutFile and the locks, the files are identified by their paths,
+// and the access tokens are the signed tokens of the user and the path.
+// The locks are kept in memory, they expire if not refreshed by the client.
+type wopiRoute struct {
+	dr      *driveRoute
+	tokens  *downloadTokens
+	userDAO *storage.UserDAO
+
+	locksMux sync.Mutex
+	locks    map[string]wopiLock
+
+	discoveryMux       sync.Mutex
+	discovery          *wopiDiscovery
+	discoveryExpiresAt time.Time
+}
+
+type wopiLock struct {
+	id        string
+	expiresAt time.Time
+}
+
+type wopiDiscovery struct {
+	Apps []struct {
+		Actions []struct {
+			Name   string `xml:"name,attr"`
+			Ext    string `xml:"ext,attr"`
+			URLSrc string `xml:"urlsrc,attr"`
+		} `xml:"action"`
+	} `xml:"net-zone>app"`
+}
+
+func initWOPIRoutes(router gin.IRouter, r gin.IRouter, dr *driveRoute,
+	tokens *downloadTokens, userDAO *storage.UserDAO) {
+	w := &wopiRoute{dr: dr, tokens: tokens, userDAO: userDAO, locks: make(map[string]wopiLock)}
+	// get the URL of the WOPI client and the access token to open the document, ?mode=view to open it read-only
+	r.GET("/wopi/launch/*path", w.launch)
+	// the WOPI host endpoints, authenticated by ?access_token
+	router.GET("/wopi/files/:id", w.auth, w.checkFileInfo)
+	router.POST("/wopi/files/:id", w.auth, w.lockOperation)
+	router.GET("/wopi/files/:id/contents", w.auth, w.getFile)
+	router.POST("/wopi/files/:id/contents", w.auth, w.putFile)
+}
+
+func wopiFileId(path string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(path))
+}
+
+func (w *wopiRoute) hostURL(c *gin.Context) string {
+	if w.dr.config.WOPIHostURL != "" {
+		return strings.TrimSuffix(w.dr.config.WOPIHostURL, "/")
+	}
+	return apiBaseURL(c, "/wopi/launch")
+}
+
+// getDiscovery returns the discovery of the WOPI client, which is cached for a while
+func (w *wopiRoute) getDiscovery(c *gin.Context) (*wopiDiscovery, error) {
+	w.discoveryMux.Lock()
+	defer w.discoveryMux.Unlock()
+	if w.discovery != nil && time.Now().Before(w.discoveryExpiresAt) {
+		return w.discovery, nil
+	}
+	reader, e := drive_util.GetURL(c.Request.Context(),
+		strings.TrimSuffix(w.dr.config.WOPIClientURL, "/")+"/hosting/discovery", nil)
+	if e != nil {
+		return nil, e
+	}
+	defer func() { _ = reader.Close() }()
+	discovery := &wopiDiscovery{}
+	if e := xml.NewDecoder(reader).Decode(discovery); e != nil {
+		return nil, e
+	}
+	w.discovery = discovery
+	w.discoveryExpiresAt = time.Now().Add(wopiDiscoveryTTL)
+	return discovery, nil
+}
+
+// actionURL returns the urlsrc of the action of ext without the placeholders, empty if it's not found
+func (d *wopiDiscovery) actionURL(action, ext string) string {
+	for _, app := range d.Apps {
+		for _, a := range app.Actions {
+			if a.Name == action && strings.EqualFold(a.Ext, ext) {
+				return wopiPlaceholder.ReplaceAllString(a.URLSrc, "")
+			}
+		}
+	}
+	return ""
+}
+
+func (w *wopiRoute) launch(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	entry, e := w.dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	discovery, e := w.getDiscovery(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	ext := strings.TrimPrefix(path2.Ext(path), ".")
+	actionURL := ""
+	if entry.Meta().CanWrite && c.Query("mode") != "view" {
+		actionURL = discovery.actionURL("edit", ext)
+	}
+	if actionURL == "" {
+		actionURL = discovery.actionURL("view", ext)
+	}
+	if _, ok := entry.(types.IContent); !ok || !entry.Type().IsFile() || actionURL == "" {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.wopi.unsupported_file", utils.PathBase(path))))
+		return
+	}
+	if !strings.HasSuffix(actionURL, "?") && !strings.HasSuffix(actionURL, "&") {
+		if strings.Contains(actionURL, "?") {
+			actionURL += "&"
+		} else {
+			actionURL += "?"
+		}
+	}
+	expiresAt := time.Now().Add(wopiTokenTTL)
+	wopiSrc := w.hostURL(c) + "/wopi/files/" + wopiFileId(path)
+	SetResult(c, types.M{
+		// the access token and its ttl are posted to the url by a form
+		"url":              actionURL + "WOPISrc=" + url.QueryEscape(wopiSrc),
+		"access_token":     w.tokens.Mint(tokenKindWOPI, GetSession(c).User.Username, path, expiresAt),
+		"access_token_ttl": utils.Millisecond(expiresAt),
+	})
+}
+
+// auth validates the access token of the file, and sets the session of the user of the token
+func (w *wopiRoute) auth(c *gin.Context) {
+	token := c.Query("access_token")
+	if token == "" {
+		token = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+	username, path, ok := w.tokens.Validate(tokenKindWOPI, token)
+	if !ok || wopiFileId(path) != c.Param("id") {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	session, e := tokenSession(w.userDAO, username)
+	if e != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	SetSession(c, session)
+	c.Set("wopiPath", path)
+}
+
+func (w *wopiRoute) getEntry(c *gin.Context) (string, types.IEntry, error) {
+	path := c.GetString("wopiPath")
+	entry, e := w.dr.getDrive(c).Get(c.Request.Context(), path)
+	if e != nil {
+		return path, nil, e
+	}
+	if _, ok := entry.(types.IContent); !ok || !entry.Type().IsFile() {
+		return path, nil, err.NewNotFoundError()
+	}
+	return path, entry, nil
+}
+
+func wopiVersion(entry types.IEntry) string {
+	return strconv.FormatInt(entry.ModTime(), 10) + "-" + strconv.FormatInt(entry.Size(), 10)
+}
+
+type wopiFileInfo struct {
+	BaseFileName            string `json:"BaseFileName"`
+	OwnerId                 string `json:"OwnerId"`
+	Size                    int64  `json:"Size"`
+	UserId                  string `json:"UserId"`
+	UserFriendlyName        string `json:"UserFriendlyName"`
+	Version                 string `json:"Version"`
+	LastModifiedTime        string `json:"LastModifiedTime,omitempty"`
+	ReadOnly                bool   `json:"ReadOnly"`
+	UserCanWrite            bool   `json:"UserCanWrite"`
+	UserCanNotWriteRelative bool   `json:"UserCanNotWriteRelative"`
+	SupportsLocks           bool   `json:"SupportsLocks"`
+	SupportsGetLock         bool   `json:"SupportsGetLock"`
+	SupportsUpdate          bool   `json:"SupportsUpdate"`
+}
+
+func (w *wopiRoute) checkFileInfo(c *gin.Context) {
+	_, entry, e := w.getEntry(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	userId := GetSession(c).User.Username
+	if userId == "" {
+		userId = "anonymous"
+	}
+	info := wopiFileInfo{
+		BaseFileName:            utils.PathBase(entry.Path()),
+		OwnerId:                 userId,
+		Size:                    entry.Size(),
+		UserId:                  userId,
+		UserFriendlyName:        userId,
+		Version:                 wopiVersion(entry),
+		ReadOnly:                !entry.Meta().CanWrite,
+		UserCanWrite:            entry.Meta().CanWrite,
+		UserCanNotWriteRelative: true,
+		SupportsLocks:           true,
+		SupportsGetLock:         true,
+		SupportsUpdate:          true,
+	}
+	if entry.ModTime() > 0 {
+		info.LastModifiedTime = utils.Time(entry.ModTime()).UTC().Format(time.RFC3339)
+	}
+	SetResult(c, info)
+}
+
+func (w *wopiRoute) getFile(c *gin.Context) {
+	_, entry, e := w.getEntry(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	c.Header(headerWOPIVersion, wopiVersion(entry))
+	// the WOPI clients may not follow the redirects
+	if e := drive_util.DownloadIContent(c.Request.Context(), entry.(types.IContent), c.Writer, c.Request,
+		true, w.dr.config.DownloadRateLimit); e != nil {
+		_ = c.Error(e)
+	}
+}
+
+// currentLock returns the lock id of path, empty if it's not locked
+func (w *wopiRoute) currentLock(path string) string {
+	lock, ok := w.locks[path]
+	if !ok || time.Now().After(lock.expiresAt) {
+		delete(w.locks, path)
+		return ""
+	}
+	return lock.id
+}
+
+// lockConflict responds 409 with the current lock
+func lockConflict(c *gin.Context, current, reason string) {
+	c.Header(headerWOPILock, current)
+	c.Header(headerWOPILockReason, reason)
+	c.Status(http.StatusConflict)
+}
+
+func (w *wopiRoute) lockOperation(c *gin.Context) {
+	path, entry, e := w.getEntry(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	lock := c.GetHeader(headerWOPILock)
+	override := c.GetHeader(headerWOPIOverride)
+	if override != "GET_LOCK" && (lock == "" || len(lock) > wopiMaxLockLength) {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	w.locksMux.Lock()
+	defer w.locksMux.Unlock()
+	current := w.currentLock(path)
+	switch override {
+	case "LOCK":
+		if oldLock := c.GetHeader(headerWOPIOldLock); oldLock != "" {
+			// UnlockAndRelock
+			if current != oldLock {
+				lockConflict(c, current, "Lock mismatch")
+				return
+			}
+		} else if current != "" && current != lock {
+			lockConflict(c, current, "Locked by another client")
+			return
+		}
+		w.locks[path] = wopiLock{id: lock, expiresAt: time.Now().Add(wopiLockTTL)}
+	case "GET_LOCK":
+		c.Header(headerWOPILock, current)
+	case "REFRESH_LOCK":
+		if current != lock {
+			lockConflict(c, current, "Lock mismatch")
+			return
+		}
+		w.locks[path] = wopiLock{id: lock, expiresAt: time.Now().Add(wopiLockTTL)}
+	case "UNLOCK":
+		if current != lock {
+			lockConflict(c, current, "Lock mismatch")
+			return
+		}
+		delete(w.locks, path)
+	default:
+		c.Status(http.StatusNotImplemented)
+		return
+	}
+	c.Header(headerWOPIVersion, wopiVersion(entry))
+	c.Status(http.StatusOK)
+}
+
+func (w *wopiRoute) putFile(c *gin.Context) {
+	defer func() { _ = c.Request.Body.Close() }()
+	if c.GetHeader(headerWOPIOverride) != "PUT" {
+		c.Status(http.StatusNotImplemented)
+		return
+	}
+	path, entry, e := w.getEntry(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if !entry.Meta().CanWrite {
+		_ = c.Error(err.NewNotAllowedError())
+		return
+	}
+	lock := c.GetHeader(headerWOPILock)
+	// fails fast before receiving the file, the lock is checked again while saving
+	w.locksMux.Lock()
+	current := w.currentLock(path)
+	w.locksMux.Unlock()
+	if !wopiCanPut(entry, current, lock) {
+		lockConflict(c, current, "Lock mismatch")
+		return
+	}
+	drive_util.SetBandwidthLimitHeader(c.Writer.Header(), w.dr.config.UploadRateLimit)
+	file, e := drive_util.CopyReaderToTempFile(task.DummyContext(),
+		drive_util.ThrottledReader(c.Request.Body, w.dr.config.UploadRateLimit), w.dr.config.TempDir)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	stat, e := file.Stat()
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	// the locks are held while saving, so the lock can not be released or taken over by others in the meantime
+	w.locksMux.Lock()
+	defer w.locksMux.Unlock()
+	if current := w.currentLock(path); !wopiCanPut(entry, current, lock) {
+		lockConflict(c, current, "Lock mismatch")
+		return
+	}
+	saved, e := w.dr.getDrive(c).Save(task.DummyContext(), path, stat.Size(), true, file)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	c.Header(headerWOPIVersion, wopiVersion(saved))
+	c.Status(http.StatusOK)
+}
+
+// wopiCanPut returns true if the file can be written with lock,
+// the unlocked file can only be written if it's empty
+func wopiCanPut(entry types.IEntry, current, lock string) bool {
+	return current == lock && (current != "" || entry.Size() == 0)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"go-drive/common"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWOPILocks(t *testing.T) {
+	s := newTestServer(t, common.Config{})
+	defer s.close()
+	root := s.dr.rootDrive.Get()
+	if _, e := root.Save(task.DummyContext(), "b/doc.docx", 5, false, strings.NewReader("hello")); e != nil {
+		t.Fatal(e)
+	}
+	tokens, e := newDownloadTokens(s.dr.config)
+	if e != nil {
+		t.Fatal(e)
+	}
+	h := s.engine()
+	initWOPIRoutes(h, h.Group("/"), s.dr, tokens, s.userDAO)
+
+	token := tokens.Mint(tokenKindWOPI, "admin", "b/doc.docx", time.Now().Add(time.Hour))
+	fileURL := "/wopi/files/" + wopiFileId("b/doc.docx")
+	request := func(method, u, override, lock, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, u+"?access_token="+token, strings.NewReader(body))
+		req.Header.Set(headerWOPIOverride, override)
+		if lock != "" {
+			req.Header.Set(headerWOPILock, lock)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	content := func() string {
+		entry, e := root.Get(task.DummyContext(), "b/doc.docx")
+		if e != nil {
+			t.Fatal(e)
+		}
+		reader, e := entry.(types.IContent).GetReader(task.DummyContext())
+		if e != nil {
+			t.Fatal(e)
+		}
+		defer func() { _ = reader.Close() }()
+		dat, _ := ioutil.ReadAll(reader)
+		return string(dat)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/wopi/files/"+wopiFileId("b/other.docx")+"?access_token="+token, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("the token of another file should be refused: %d", w.Code)
+	}
+
+	if w := request("POST", fileURL+"/contents", "PUT", "", "changed"); w.Code != http.StatusConflict {
+		t.Errorf("the unlocked non-empty file should not be written: %d", w.Code)
+	}
+	if w := request("POST", fileURL, "LOCK", "L1", ""); w.Code != http.StatusOK {
+		t.Fatalf("lock: %d", w.Code)
+	}
+	if w := request("POST", fileURL, "LOCK", "L2", ""); w.Code != http.StatusConflict ||
+		w.Header().Get(headerWOPILock) != "L1" {
+		t.Errorf("expect the conflict with L1: %d '%s'", w.Code, w.Header().Get(headerWOPILock))
+	}
+	if w := request("POST", fileURL, "GET_LOCK", "", ""); w.Header().Get(headerWOPILock) != "L1" {
+		t.Errorf("unexpected lock '%s'", w.Header().Get(headerWOPILock))
+	}
+	if w := request("POST", fileURL+"/contents", "PUT", "L2", "changed"); w.Code != http.StatusConflict {
+		t.Errorf("the file should not be written with another lock: %d", w.Code)
+	}
+	if w := request("POST", fileURL+"/contents", "PUT", "L1", "changed"); w.Code != http.StatusOK {
+		t.Fatalf("put: %d %s", w.Code, w.Body.String())
+	}
+	if got := content(); got != "changed" {
+		t.Errorf("unexpected content '%s'", got)
+	}
+	if w := request("POST", fileURL, "UNLOCK", "L2", ""); w.Code != http.StatusConflict {
+		t.Errorf("the file should not be unlocked with another lock: %d", w.Code)
+	}
+	if w := request("POST", fileURL, "UNLOCK", "L1", ""); w.Code != http.StatusOK {
+		t.Fatalf("unlock: %d", w.Code)
+	}
+	if w := request("POST", fileURL+"/contents", "PUT", "L1", "again"); w.Code != http.StatusConflict {
+		t.Errorf("the file should not be written with the released lock: %d", w.Code)
+	}
+	if got := content(); got != "changed" {
+		t.Errorf("unexpected content '%s'", got)
+	}
+}
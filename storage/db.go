@@ -38,6 +38,11 @@ func NewDB(config common.Config, ch *registry.ComponentsHolder) (*DB, error) {
 		&types.PathMount{},
 		&types.DriveData{},
 		&types.DriveCache{},
+		&types.DriveFile{},
+		&types.DriveFileChunk{},
+		&types.PathLock{},
+		&types.Share{},
+		&types.SearchEntry{},
 	).Error; e != nil {
 		_ = db.Close()
 		return nil, e
@@ -19,7 +19,12 @@ func (d *DriveDataDAO) GetDataStore(ns string) drive_util.DriveDataStore {
 }
 
 func (d *DriveDataDAO) Remove(ns string) error {
-	return d.db.C().Delete(&types.DriveData{}, "drive = ?", ns).Error
+	return d.db.C().Transaction(func(tx *gorm.DB) error {
+		if e := removeFiles(tx, ns); e != nil {
+			return e
+		}
+		return tx.Delete(&types.DriveData{}, "drive = ?", ns).Error
+	})
 }
 
 type dbDriveNamespacedDataStore struct {
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"go-drive/common/drive_util"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"io/ioutil"
+	"unicode/utf8"
+)
+
+// fileChunkSize is the max size of a row of the file contents
+const fileChunkSize = 256 * 1024
+
+func (d *DriveDataDAO) GetFileStore(ns string) drive_util.DriveFileStore {
+	return &dbDriveNamespacedFileStore{db: d.db, ns: ns}
+}
+
+func removeFiles(db *gorm.DB, ns string) error {
+	if e := db.Delete(&types.DriveFileChunk{}, "drive = ?", ns).Error; e != nil {
+		return e
+	}
+	return db.Delete(&types.DriveFile{}, "drive = ?", ns).Error
+}
+
+type dbDriveNamespacedFileStore struct {
+	ns string
+	db *DB
+}
+
+// descendants returns the condition of the descendants of path,
+// '0' is the next character of '/', so that the index of the path can be used
+func descendants(path string) (string, string, string) {
+	return "path > ? AND path < ?", path + "/", path + "0"
+}
+
+func (d *dbDriveNamespacedFileStore) get(db *gorm.DB, path string) (*types.DriveFile, error) {
+	f := types.DriveFile{}
+	e := db.Find(&f, "drive = ? AND path = ?", d.ns, path).Error
+	if gorm.IsRecordNotFoundError(e) {
+		return nil, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	return &f, nil
+}
+
+func (d *dbDriveNamespacedFileStore) Get(path string) (*types.DriveFile, error) {
+	return d.get(d.db.C(), path)
+}
+
+func (d *dbDriveNamespacedFileStore) List(path string) ([]types.DriveFile, error) {
+	files := make([]types.DriveFile, 0)
+	e := d.db.C().Where("drive = ? AND parent = ?", d.ns, path).Order("path").Find(&files).Error
+	return files, e
+}
+
+func (d *dbDriveNamespacedFileStore) MakeDir(path string, modTime int64) (*types.DriveFile, error) {
+	f := &types.DriveFile{
+		Drive: d.ns, Path: path, Parent: utils.PathParent(path),
+		Dir: true, Size: -1, ModTime: modTime,
+	}
+	if e := d.db.C().Create(f).Error; e != nil {
+		return nil, e
+	}
+	return f, nil
+}
+
+func (d *dbDriveNamespacedFileStore) Write(path string, modTime int64, reader io.Reader) (*types.DriveFile, error) {
+	blobId := uuid.New().String()
+	size, e := d.writeChunks(blobId, reader)
+	if e != nil {
+		_ = d.db.C().Delete(&types.DriveFileChunk{}, "drive = ? AND blob_id = ?", d.ns, blobId).Error
+		return nil, e
+	}
+	f := &types.DriveFile{
+		Drive: d.ns, Path: path, Parent: utils.PathParent(path),
+		Size: size, ModTime: modTime, BlobId: blobId,
+	}
+	e = d.db.C().Transaction(func(tx *gorm.DB) error {
+		old, e := d.get(tx, path)
+		if e != nil {
+			return e
+		}
+		if old == nil {
+			return tx.Create(f).Error
+		}
+		if e := tx.Delete(&types.DriveFileChunk{}, "drive = ? AND blob_id = ?", d.ns, old.BlobId).Error; e != nil {
+			return e
+		}
+		return tx.Save(f).Error
+	})
+	if e != nil {
+		_ = d.db.C().Delete(&types.DriveFileChunk{}, "drive = ? AND blob_id = ?", d.ns, blobId).Error
+		return nil, e
+	}
+	return f, nil
+}
+
+// writeChunks writes the content to the rows of the chunks outside of a transaction,
+// so that the database is not locked while the content is being read
+func (d *dbDriveNamespacedFileStore) writeChunks(blobId string, reader io.Reader) (int64, error) {
+	buf := make([]byte, fileChunkSize)
+	size := int64(0)
+	for seq := 0; ; seq++ {
+		n, e := io.ReadFull(reader, buf)
+		if n > 0 {
+			seq := seq
+			chunk := &types.DriveFileChunk{Drive: d.ns, BlobId: blobId, Seq: &seq, Data: buf[:n]}
+			if e := d.db.C().Create(chunk).Error; e != nil {
+				return 0, e
+			}
+			size += int64(n)
+		}
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			return size, nil
+		}
+		if e != nil {
+			return 0, e
+		}
+	}
+}
+
+func (d *dbDriveNamespacedFileStore) Read(file types.DriveFile) (io.ReadCloser, error) {
+	return ioutil.NopCloser(&fileChunksReader{d: d, file: file}), nil
+}
+
+func (d *dbDriveNamespacedFileStore) Move(from, to string) error {
+	cond, lower, upper := descendants(from)
+	// the offset of substr is counted in characters
+	offset := utf8.RuneCountInString(from) + 1
+	return d.db.C().Transaction(func(tx *gorm.DB) error {
+		e := tx.Model(&types.DriveFile{}).Where("drive = ? AND path = ?", d.ns, from).
+			Updates(map[string]interface{}{"path": to, "parent": utils.PathParent(to)}).Error
+		if e != nil {
+			return e
+		}
+		return tx.Exec("UPDATE drive_files SET path = ? || substr(path, ?), parent = ? || substr(parent, ?) "+
+			"WHERE drive = ? AND "+cond, to, offset, to, offset, d.ns, lower, upper).Error
+	})
+}
+
+func (d *dbDriveNamespacedFileStore) Delete(path string) error {
+	cond, lower, upper := descendants(path)
+	return d.db.C().Transaction(func(tx *gorm.DB) error {
+		e := tx.Exec("DELETE FROM drive_file_chunks WHERE drive = ? AND blob_id IN "+
+			"(SELECT blob_id FROM drive_files WHERE drive = ? AND (path = ? OR "+cond+"))",
+			d.ns, d.ns, path, lower, upper).Error
+		if e != nil {
+			return e
+		}
+		return tx.Delete(&types.DriveFile{}, "drive = ? AND (path = ? OR "+cond+")", d.ns, path, lower, upper).Error
+	})
+}
+
+// fileChunksReader reads the chunks of the file one by one
+type fileChunksReader struct {
+	d    *dbDriveNamespacedFileStore
+	file types.DriveFile
+	seq  int
+	read int64
+	buf  []byte
+}
+
+func (r *fileChunksReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.read >= r.file.Size {
+			return 0, io.EOF
+		}
+		chunk := types.DriveFileChunk{}
+		e := r.d.db.C().Find(&chunk, "drive = ? AND blob_id = ? AND seq = ?", r.d.ns, r.file.BlobId, r.seq).Error
+		if gorm.IsRecordNotFoundError(e) {
+			// the content has been replaced or deleted
+			return 0, io.ErrUnexpectedEOF
+		}
+		if e != nil {
+			return 0, e
+		}
+		r.seq++
+		r.buf = chunk.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.read += int64(n)
+	return n, nil
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	LockerMemory = "memory"
+	LockerDB     = "db"
+
+	lockPollInterval = 200 * time.Millisecond
+)
+
+// NewPathLocker creates the PathLocker specified by the configuration.
+// The 'db' locker stores locks in the database, so that instances sharing the database are coordinated.
+// The database is the SQLite file in the data dir, so the instances must share the data dir,
+// and the file locking of SQLite is not reliable on most network file systems, like NFS.
+func NewPathLocker(config common.Config, db *DB, ch *registry.ComponentsHolder) drive_util.PathLocker {
+	if config.Locker == LockerDB {
+		l := &DBPathLocker{db: db, owner: uuid.New().String(), ttl: config.LockTTL}
+		l.timerStop = utils.TimeTick(l.cleanExpired, 60*time.Second)
+		ch.Add("pathLocker", l)
+		return l
+	}
+	return drive_util.NewMemLocker()
+}
+
+// DBPathLocker is a PathLocker backed by rows of the database.
+// A lock is held until it's released or expired,
+// the holder keeps renewing the lock, so crashed instances release their locks after ttl.
+type DBPathLocker struct {
+	db        *DB
+	owner     string
+	ttl       time.Duration
+	timerStop func()
+}
+
+// conflictCond returns the condition of the locks of key, its ancestors and its descendants
+func conflictCond(key string) (string, []interface{}) {
+	keys := append(drive_util.LockKeyAncestors(key), key)
+	return "lock_key IN (?) OR substr(lock_key, 1, length(?)) = ? OR substr(lock_key, 1, length(?)) = ?",
+		[]interface{}{keys, key + "/", key + "/", key + "\\", key + "\\"}
+}
+
+// tryLock returns false if the lock is held by others, or the database is locked by another instance
+func (d *DBPathLocker) tryLock(key string) (bool, error) {
+	ok, e := d.insertLock(key)
+	if isBusy(e) {
+		return false, nil
+	}
+	return ok, e
+}
+
+func (d *DBPathLocker) insertLock(key string) (bool, error) {
+	now := time.Now()
+	tx := d.db.C().Begin()
+	if e := tx.Error; e != nil {
+		return false, e
+	}
+	cond, args := conflictCond(key)
+	if e := tx.Where(cond, args...).Where("expires_at < ?", utils.Millisecond(now)).
+		Delete(&types.PathLock{}).Error; e != nil {
+		tx.Rollback()
+		return false, e
+	}
+	n := 0
+	if e := tx.Model(&types.PathLock{}).Where(cond, args...).Count(&n).Error; e != nil {
+		tx.Rollback()
+		return false, e
+	}
+	if n > 0 {
+		tx.Rollback()
+		return false, nil
+	}
+	e := tx.Create(&types.PathLock{Key: key, Owner: d.owner, ExpiresAt: utils.Millisecond(now.Add(d.ttl))}).Error
+	if e != nil {
+		tx.Rollback()
+		// another instance may have just inserted it
+		return false, nil
+	}
+	return true, tx.Commit().Error
+}
+
+// isBusy tells whether e is caused by the database being locked by another connection
+func isBusy(e error) bool {
+	if se, ok := e.(sqlite3.Error); ok {
+		return se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+func (d *DBPathLocker) renew(key string, stop chan struct{}) {
+	ticker := time.NewTicker(d.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			e := d.db.C().Model(&types.PathLock{}).
+				Where("lock_key = ? AND owner = ?", key, d.owner).
+				Update("expires_at", utils.Millisecond(time.Now().Add(d.ttl))).Error
+			if e != nil {
+				log.Printf("error when renewing lock '%s': %v", key, e)
+			}
+		}
+	}
+}
+
+func (d *DBPathLocker) Lock(ctx context.Context, key string) (func(), error) {
+	for {
+		ok, e := d.tryLock(key)
+		if e != nil {
+			return nil, e
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+	stopRenew := make(chan struct{})
+	go d.renew(key, stopRenew)
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			close(stopRenew)
+			if e := d.db.C().Delete(&types.PathLock{},
+				"lock_key = ? AND owner = ?", key, d.owner).Error; e != nil {
+				log.Printf("error when releasing lock '%s': %v", key, e)
+			}
+		})
+	}, nil
+}
+
+func (d *DBPathLocker) cleanExpired() {
+	rows := d.db.C().Delete(&types.PathLock{}, "expires_at < ?", utils.Millisecond(time.Now())).RowsAffected
+	if utils.IsDebugOn() && rows > 0 {
+		log.Printf("%d expired locks cleaned", rows)
+	}
+}
+
+func (d *DBPathLocker) Dispose() error {
+	d.timerStop()
+	return d.db.C().Delete(&types.PathLock{}, "owner = ?", d.owner).Error
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"go-drive/common"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestDB creates the database in a temp dir, which is the working dir as the data dir of the config is the default one
+func newTestDB(t *testing.T) (*DB, func()) {
+	dir, e := ioutil.TempDir("", "go-drive-storage-test")
+	if e != nil {
+		t.Fatal(e)
+	}
+	wd, e := os.Getwd()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Chdir(dir); e != nil {
+		t.Fatal(e)
+	}
+	ch := registry.NewComponentHolder()
+	closeDB := func() {
+		for _, c := range ch.Gets(nil) {
+			if d, ok := c.(types.IDisposable); ok {
+				_ = d.Dispose()
+			}
+		}
+		_ = os.Chdir(wd)
+		_ = os.RemoveAll(dir)
+	}
+	db, e := NewDB(common.Config{}, ch)
+	if e != nil {
+		closeDB()
+		t.Fatal(e)
+	}
+	return db, closeDB
+}
+
+func TestDBPathLocker(t *testing.T) {
+	db, closeDB := newTestDB(t)
+	defer closeDB()
+	config := common.Config{Locker: LockerDB, LockTTL: time.Minute}
+	a := NewPathLocker(config, db, registry.NewComponentHolder()).(*DBPathLocker)
+	b := NewPathLocker(config, db, registry.NewComponentHolder()).(*DBPathLocker)
+	defer func() {
+		_ = a.Dispose()
+		_ = b.Dispose()
+	}()
+
+	unlock, e := a.Lock(context.Background(), "a/b")
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, key := range []string{"a/b", "a", "a/b/c"} {
+		if ok, e := b.tryLock(key); ok || e != nil {
+			t.Errorf("'%s' should be held by another instance: %v %v", key, ok, e)
+		}
+	}
+	if ok, e := b.tryLock("a/bc"); !ok || e != nil {
+		t.Errorf("the sibling should be locked: %v %v", ok, e)
+	}
+	unlock()
+	if ok, e := b.tryLock("a/b"); !ok || e != nil {
+		t.Errorf("the released lock should be acquired: %v %v", ok, e)
+	}
+
+	// the database is locked by another process, the lock is retried after the busy timeout of SQLite
+	other, e := sql.Open(common.DbType, common.DbFilename+"?_busy_timeout=10")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() { _ = other.Close() }()
+	tx, e := other.Begin()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := tx.Exec("DELETE FROM path_locks WHERE lock_key = 'x'"); e != nil {
+		t.Fatal(e)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, e := a.Lock(ctx, "c"); e != context.DeadlineExceeded {
+		t.Errorf("expect retrying until the deadline, but it's %v", e)
+	}
+	_ = tx.Rollback()
+	if ok, e := a.tryLock("c"); !ok || e != nil {
+		t.Errorf("the lock should be acquired after the database is unlocked: %v %v", ok, e)
+	}
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"github.com/jinzhu/gorm"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"strings"
+)
+
+// the names and the text contents are indexed in the FTS4 table by the ids of search_entries.
+// FTS5 is not used, which requires building go-sqlite3 with the sqlite_fts5 tag
+const createSearchFTS = "CREATE VIRTUAL TABLE IF NOT EXISTS search_fts USING fts4(name, content, tokenize=unicode61)"
+
+// SearchQuery is the query of the search index
+type SearchQuery struct {
+	// Text is the words to be searched, the entries containing all the words(prefixes) are matched
+	Text string
+	// Root limits the results to the descendants of it, '' for all
+	Root string
+	// Type limits the results to the files or the directories, '' for both
+	Type types.EntryType
+	// Exts limits the results to the files of the extensions, empty for any
+	Exts []string
+	// Content matches the text contents as well as the names
+	Content bool
+	Offset  int
+	Limit   int
+}
+
+// SearchIndexDAO is the persistent full-text index of the entries
+type SearchIndexDAO struct {
+	db *DB
+}
+
+func NewSearchIndexDAO(db *DB) (*SearchIndexDAO, error) {
+	if e := db.C().Exec(createSearchFTS).Error; e != nil {
+		return nil, e
+	}
+	return &SearchIndexDAO{db}, nil
+}
+
+// GetChildren returns the indexed children of the directory
+func (s *SearchIndexDAO) GetChildren(parent string) ([]types.SearchEntry, error) {
+	entries := make([]types.SearchEntry, 0)
+	e := s.db.C().Where("parent = ?", parent).Find(&entries).Error
+	return entries, e
+}
+
+// Put adds the entry to the index or updates it, the text content is replaced with content
+func (s *SearchIndexDAO) Put(entry types.SearchEntry, content string) error {
+	entry.Parent = utils.PathParent(entry.Path)
+	return s.db.C().Transaction(func(tx *gorm.DB) error {
+		old := types.SearchEntry{}
+		e := tx.Take(&old, "path = ?", entry.Path).Error
+		if e != nil && !gorm.IsRecordNotFoundError(e) {
+			return e
+		}
+		if e == nil {
+			entry.Id = old.Id
+			if e := tx.Save(&entry).Error; e != nil {
+				return e
+			}
+			return tx.Exec("UPDATE search_fts SET name = ?, content = ? WHERE docid = ?",
+				entry.Name, content, entry.Id).Error
+		}
+		if e := tx.Create(&entry).Error; e != nil {
+			return e
+		}
+		return tx.Exec("INSERT INTO search_fts(docid, name, content) VALUES (?, ?, ?)",
+			entry.Id, entry.Name, content).Error
+	})
+}
+
+// Delete removes the entry and its descendants from the index
+func (s *SearchIndexDAO) Delete(path string) error {
+	cond, args := descendantCond("path", path)
+	return s.deleteWhere(cond, args...)
+}
+
+// Retain removes the entries which are not the descendants of the roots from the index
+func (s *SearchIndexDAO) Retain(roots []string) error {
+	conds := make([]string, 0, len(roots))
+	args := make([]interface{}, 0, len(roots)*3)
+	for _, root := range roots {
+		cond, a := descendantCond("path", root)
+		conds = append(conds, cond)
+		args = append(args, a...)
+	}
+	if len(conds) == 0 {
+		return s.deleteWhere("1 = 1")
+	}
+	return s.deleteWhere("NOT ("+strings.Join(conds, " OR ")+")", args...)
+}
+
+func (s *SearchIndexDAO) deleteWhere(cond string, args ...interface{}) error {
+	return s.db.C().Transaction(func(tx *gorm.DB) error {
+		if e := tx.Exec("DELETE FROM search_fts WHERE docid IN (SELECT id FROM search_entries WHERE "+cond+")",
+			args...).Error; e != nil {
+			return e
+		}
+		return tx.Where(cond, args...).Delete(&types.SearchEntry{}).Error
+	})
+}
+
+// Search returns the matched entries, the ones with the name equal to the text come first, then the recently modified ones
+func (s *SearchIndexDAO) Search(q SearchQuery) ([]types.SearchEntry, error) {
+	entries := make([]types.SearchEntry, 0)
+	match := matchExpr(q.Text)
+	if match == "" {
+		return entries, nil
+	}
+	column := "search_fts.name"
+	if q.Content {
+		column = "search_fts"
+	}
+	where := []string{column + " MATCH ?"}
+	args := []interface{}{match}
+	if !utils.IsRootPath(q.Root) {
+		cond, a := descendantCond("e.path", q.Root)
+		where = append(where, cond)
+		args = append(args, a...)
+	}
+	if q.Type != "" {
+		where = append(where, "e.type = ?")
+		args = append(args, q.Type)
+	}
+	if len(q.Exts) > 0 {
+		where = append(where, "e.ext IN (?)")
+		args = append(args, q.Exts)
+	}
+	args = append(args, strings.TrimSpace(q.Text), q.Limit, q.Offset)
+	e := s.db.C().Raw("SELECT e.* FROM search_fts JOIN search_entries e ON e.id = search_fts.docid WHERE "+
+		strings.Join(where, " AND ")+
+		" ORDER BY e.name = ? COLLATE NOCASE DESC, e.mod_time DESC LIMIT ? OFFSET ?", args...).
+		Scan(&entries).Error
+	return entries, e
+}
+
+// descendantCond returns the condition of the column matching path and its descendants.
+// The prefix is compared by substr instead of LIKE, '%' and '_' are common in the names
+func descendantCond(column, path string) (string, []interface{}) {
+	if utils.IsRootPath(path) {
+		return "1 = 1", nil
+	}
+	return "(" + column + " = ? OR substr(" + column + ", 1, length(?)) = ?)",
+		[]interface{}{path, path + "/", path + "/"}
+}
+
+// matchExpr makes the MATCH expression of the words, each word is a prefix phrase,
+// so that the punctuations in it are tokenized like the indexed names.
+// The CJK characters are not segmented by the tokenizer, they are matched by the prefixes only
+func matchExpr(text string) string {
+	words := strings.Fields(text)
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		w = strings.ReplaceAll(w, "\"", "")
+		if w == "" {
+			continue
+		}
+		terms = append(terms, "\""+w+"*\"")
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"reflect"
+	"testing"
+)
+
+func TestSearchIndex(t *testing.T) {
+	db, closeDB := newTestDB(t)
+	defer closeDB()
+	s, e := NewSearchIndexDAO(db)
+	if e != nil {
+		t.Fatal(e)
+	}
+	put := func(path string, typ types.EntryType, ext string, modTime int64, content string) {
+		entry := types.SearchEntry{Path: path, Name: utils.PathBase(path),
+			Type: typ, Ext: ext, ModTime: modTime}
+		if e := s.Put(entry, content); e != nil {
+			t.Fatal(e)
+		}
+	}
+	search := func(q SearchQuery) []string {
+		if q.Limit == 0 {
+			q.Limit = 100
+		}
+		entries, e := s.Search(q)
+		if e != nil {
+			t.Fatal(e)
+		}
+		paths := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			paths = append(paths, entry.Path)
+		}
+		return paths
+	}
+	expect := func(q SearchQuery, paths ...string) {
+		t.Helper()
+		if paths == nil {
+			paths = []string{}
+		}
+		if got := search(q); !reflect.DeepEqual(got, paths) {
+			t.Errorf("search %+v: expect %v, but got %v", q, paths, got)
+		}
+	}
+
+	put("a/report", types.TypeDir, "", 1, "")
+	put("a/report/2020_report.md", types.TypeFile, "md", 2, "quarterly revenue")
+	put("a/report/notes.txt", types.TypeFile, "txt", 3, "meeting about the report")
+	put("a/report_50%.txt", types.TypeFile, "txt", 4, "")
+	put("b/report", types.TypeFile, "", 5, "")
+
+	// the equal name first, then the recently modified ones
+	expect(SearchQuery{Text: "report"},
+		"b/report", "a/report", "a/report_50%.txt", "a/report/2020_report.md")
+	expect(SearchQuery{Text: "rep 2020"}, "a/report/2020_report.md")
+	expect(SearchQuery{Text: "report", Content: true},
+		"b/report", "a/report", "a/report_50%.txt", "a/report/notes.txt", "a/report/2020_report.md")
+	expect(SearchQuery{Text: "revenue", Content: true}, "a/report/2020_report.md")
+	expect(SearchQuery{Text: "revenue"})
+	expect(SearchQuery{Text: "report", Root: "a/report"}, "a/report", "a/report/2020_report.md")
+	expect(SearchQuery{Text: "report", Type: types.TypeDir}, "a/report")
+	expect(SearchQuery{Text: "report", Exts: []string{"txt", "md"}}, "a/report_50%.txt", "a/report/2020_report.md")
+	expect(SearchQuery{Text: "report", Limit: 1, Offset: 1}, "a/report")
+	expect(SearchQuery{Text: "  \" "})
+
+	children, e := s.GetChildren("a/report")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(children) != 2 {
+		t.Errorf("unexpected children %v", children)
+	}
+
+	// updating replaces the name and the content
+	put("a/report/notes.txt", types.TypeFile, "txt", 6, "budget")
+	expect(SearchQuery{Text: "meeting", Content: true})
+	expect(SearchQuery{Text: "budget", Content: true}, "a/report/notes.txt")
+
+	// deleting removes the descendants, but not the siblings with the same prefix
+	if e := s.Delete("a/report"); e != nil {
+		t.Fatal(e)
+	}
+	expect(SearchQuery{Text: "report", Content: true}, "b/report", "a/report_50%.txt")
+	expect(SearchQuery{Text: "budget", Content: true})
+
+	if e := s.Retain([]string{"b"}); e != nil {
+		t.Fatal(e)
+	}
+	expect(SearchQuery{Text: "report"}, "b/report")
+	if e := s.Retain(nil); e != nil {
+		t.Fatal(e)
+	}
+	expect(SearchQuery{Text: "report"})
+}
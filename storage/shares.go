@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+type ShareDAO struct {
+	db *DB
+}
+
+func NewShareDAO(db *DB) *ShareDAO {
+	return &ShareDAO{db}
+}
+
+func (s *ShareDAO) GetShare(id string) (types.Share, error) {
+	share := types.Share{}
+	e := s.db.C().First(&share, "id = ?", id).Error
+	if gorm.IsRecordNotFoundError(e) {
+		return share, err.NewNotFoundMessageError(i18n.T("storage.shares.share_not_exists", id))
+	}
+	return share, e
+}
+
+// ListShares returns the shares of owner, the newest first
+func (s *ShareDAO) ListShares(owner string) ([]types.Share, error) {
+	shares := make([]types.Share, 0)
+	e := s.db.C().Where("owner = ?", owner).Order("created_at DESC").Find(&shares).Error
+	return shares, e
+}
+
+// AddShare creates the share with a new id, the password of share is the plain text, which is stored hashed
+func (s *ShareDAO) AddShare(share types.Share) (types.Share, error) {
+	if share.Password != "" {
+		encoded, e := bcrypt.GenerateFromPassword([]byte(share.Password), bcrypt.DefaultCost)
+		if e != nil {
+			return types.Share{}, e
+		}
+		share.Password = string(encoded)
+	}
+	share.Id = uuid.New().String()
+	share.Downloads = 0
+	share.CreatedAt = utils.Millisecond(time.Now())
+	e := s.db.C().Create(&share).Error
+	return share, e
+}
+
+func (s *ShareDAO) DeleteShare(id string) error {
+	return s.db.C().Delete(&types.Share{}, "id = ?", id).Error
+}
+
+// CountDownload increases the downloads of the share, it fails if the download limit is reached
+func (s *ShareDAO) CountDownload(id string) error {
+	r := s.db.C().Model(&types.Share{}).
+		Where("id = ? AND (max_downloads = 0 OR downloads < max_downloads)", id).
+		Update("downloads", gorm.Expr("downloads + 1"))
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.RowsAffected == 0 {
+		return err.NewNotAllowedMessageError(i18n.T("storage.shares.download_limit_reached"))
+	}
+	return nil
+}
@@ -25,6 +25,18 @@ func (u *UserDAO) GetUser(username string) (types.User, error) {
 	return user, e
 }
 
+// Authenticate returns the user if the password matches
+func (u *UserDAO) Authenticate(username, password string) (types.User, error) {
+	user, e := u.GetUser(username)
+	if e != nil {
+		return user, e
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return types.User{}, err.NewBadRequestError(i18n.T("storage.users.invalid_username_or_password"))
+	}
+	return user, nil
+}
+
 func (u *UserDAO) AddUser(user types.User) (types.User, error) {
 	e := u.db.C().Where("username = ?", user.Username).Find(&types.User{}).Error
 	if e == nil {
@@ -4,6 +4,7 @@ import LocalUploadTask from './local'
 import LocalChunkUploadTask from './local-chunk'
 import S3UploadTask from './s3'
 import OneDriveUploadTask from './onedrive'
+import QiniuUploadTask from './qiniu'
 
 /**
  * @type {Object.<string, typeof UploadTask>}
@@ -12,7 +13,8 @@ const TASK_PROVIDERS = {
   local: LocalUploadTask,
   localChunk: LocalChunkUploadTask,
   s3: S3UploadTask,
-  onedrive: OneDriveUploadTask
+  onedrive: OneDriveUploadTask,
+  qiniu: QiniuUploadTask
 }
 
 class DispatcherUploadTask extends UploadTask {
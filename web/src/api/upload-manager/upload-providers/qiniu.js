@@ -0,0 +1,104 @@
+import Axios from 'axios'
+import axios from '@/api/axios'
+import ChunkUploadTask from '../chunk-task'
+import { STATUS_COMPLETED } from '../task'
+
+export default class QiniuUploadTask extends ChunkUploadTask {
+  /**
+   * @type {{url: string, token: string, partSize: string}}
+   */
+  _config
+
+  /**
+   * @type {string} the url of the resumable upload, with the upload id
+   */
+  _uploadUrl
+
+  /**
+   * etag of uploaded parts
+   * @type {Array.<string>}
+   */
+  _uploadedParts
+
+  /**
+   * @type {number}
+   */
+  _partSize
+
+  /**
+   * @param {number} id task id
+   * @param {TaskChangeListener} changeListener task changed listener
+   * @param {TaskDef} task task definition
+   * @param {any} [config] task specified config
+   */
+  constructor (id, changeListener, task, config) {
+    super(id, changeListener, task, config)
+    this._config = config
+    this._partSize = +config.partSize
+  }
+
+  async _prepare () {
+    const r = await this._request({
+      method: 'POST',
+      url: this._config.url,
+      headers: { Authorization: this._config.token }
+    })
+    if (!r.data || !r.data.uploadId) throw new Error('invalid response from qiniu')
+    this._uploadUrl = `${this._config.url}/${r.data.uploadId}`
+
+    const parts = Math.ceil(this._task.size / this._partSize)
+    this._uploadedParts = []
+    for (let i = 0; i < parts; i++) {
+      this._uploadedParts.push('_EMPTY_')
+    }
+    return parts
+  }
+
+  /**
+   * @param {number} seq seq, start from 0
+   * @param {Blob} blob  chunk
+   * @param {Function} onProgress progress
+   */
+  async _chunkUpload (seq, blob, onProgress) {
+    const resp = await this._request({
+      method: 'PUT',
+      url: `${this._uploadUrl}/${seq + 1}`,
+      data: blob,
+      headers: { 'Content-Type': 'application/octet-stream', Authorization: this._config.token },
+      transformRequest: null,
+      onUploadProgress: (e) => onProgress({ loaded: e.loaded, total: e.total })
+    })
+    this._uploadedParts[seq] = resp.data.etag
+  }
+
+  /**
+   * @returns {Promise.<any>} upload result
+   */
+  async _completeUpload () {
+    await this._request({
+      method: 'POST',
+      url: this._uploadUrl,
+      data: { parts: this._uploadedParts.map((etag, i) => ({ partNumber: i + 1, etag })) },
+      headers: { Authorization: this._config.token }
+    })
+    return axios.post(`/upload/${this._task.path}`, { action: 'CompleteUpload' })
+  }
+
+  /**
+   * @param {number} seq chunk seq
+   * @returns {Blob} chunk
+   */
+  _getChunk (seq) {
+    return this._task.file.slice(seq * this._partSize, (seq + 1) * this._partSize)
+  }
+
+  _cleanup () {
+    super._cleanup()
+    if (!this.isStatus(STATUS_COMPLETED) && this._uploadUrl) {
+      Axios.delete(this._uploadUrl, {
+        headers: { Authorization: this._config.token }
+      }).catch(() => { })
+    }
+    this._uploadedParts = undefined
+  }
+}
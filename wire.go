@@ -4,7 +4,6 @@ package main
 
 import (
 	"context"
-	"github.com/gin-gonic/gin"
 	"github.com/google/wire"
 	"go-drive/common"
 	"go-drive/common/i18n"
@@ -17,7 +16,7 @@ import (
 	"go-drive/storage"
 )
 
-func Initialize(ctx context.Context, ch *registry.ComponentsHolder) (*gin.Engine, error) {
+func Initialize(ctx context.Context, ch *registry.ComponentsHolder) (*App, error) {
 	wire.Build(
 		common.InitConfig,
 		storage.NewDB,
@@ -28,17 +27,31 @@ func Initialize(ctx context.Context, ch *registry.ComponentsHolder) (*gin.Engine
 		storage.NewPathMountDAO,
 		storage.NewDriveDAO,
 		storage.NewDriveDataDAO,
+		storage.NewPathLocker,
+		storage.NewShareDAO,
+		storage.NewSearchIndexDAO,
 		wire.Bind(new(task.Runner), new(*task.TunnyRunner)),
 		task.NewTunnyRunner,
 		utils.NewSigner,
 		wire.Bind(new(types.TokenStore), new(*server.FileTokenStore)),
 		server.NewFileTokenStore,
+		wire.Bind(new(types.IdempotencyStore), new(*server.MemIdempotencyStore)),
+		server.NewMemIdempotencyStore,
+		wire.Bind(new(types.AuditSink), new(*server.FileAuditSink)),
+		server.NewFileAuditSink,
 		server.NewChunkUploader,
+		server.NewDeleteCheckpoints,
 		server.NewThumbnail,
+		server.NewHLS,
+		server.NewMediaInfo,
+		server.NewSearch,
 		drive.NewRootDrive,
 		wire.Bind(new(i18n.MessageSource), new(*i18n.FileMessageSource)),
 		i18n.NewFileMessageSource,
 		server.InitServer,
+		server.NewFTPServer,
+		server.NewSFTPServer,
+		wire.Struct(new(App), "*"),
 	)
-	return &gin.Engine{}, nil
+	return &App{}, nil
 }
@@ -7,7 +7,6 @@ package main
 
 import (
 	"context"
-	"github.com/gin-gonic/gin"
 	"go-drive/common"
 	"go-drive/common/i18n"
 	"go-drive/common/registry"
@@ -20,7 +19,7 @@ import (
 
 // Injectors from wire.go:
 
-func Initialize(ctx context.Context, ch *registry.ComponentsHolder) (*gin.Engine, error) {
+func Initialize(ctx context.Context, ch *registry.ComponentsHolder) (*App, error) {
 	config, err := common.InitConfig(ch)
 	if err != nil {
 		return nil, err
@@ -33,7 +32,8 @@ func Initialize(ctx context.Context, ch *registry.ComponentsHolder) (*gin.Engine
 	pathMountDAO := storage.NewPathMountDAO(db)
 	driveDataDAO := storage.NewDriveDataDAO(db)
 	driveCacheDAO := storage.NewDriveCacheDAO(db, ch)
-	rootDrive, err := drive.NewRootDrive(ctx, config, driveDAO, pathMountDAO, driveDataDAO, driveCacheDAO)
+	pathLocker := storage.NewPathLocker(config, db, ch)
+	rootDrive, err := drive.NewRootDrive(ctx, config, driveDAO, pathMountDAO, driveDataDAO, driveCacheDAO, pathLocker, ch)
 	if err != nil {
 		return nil, err
 	}
@@ -41,12 +41,37 @@ func Initialize(ctx context.Context, ch *registry.ComponentsHolder) (*gin.Engine
 	if err != nil {
 		return nil, err
 	}
-	thumbnail, err := server.NewThumbnail(config, ch)
+	memIdempotencyStore := server.NewMemIdempotencyStore(config, ch)
+	fileAuditSink, err := server.NewFileAuditSink(config, ch)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail, err := server.NewThumbnail(config, rootDrive, ch)
+	if err != nil {
+		return nil, err
+	}
+	hls, err := server.NewHLS(config, rootDrive, ch)
+	if err != nil {
+		return nil, err
+	}
+	mediaInfo, err := server.NewMediaInfo(config, rootDrive, ch)
+	if err != nil {
+		return nil, err
+	}
+	searchIndexDAO, err := storage.NewSearchIndexDAO(db)
+	if err != nil {
+		return nil, err
+	}
+	search, err := server.NewSearch(config, rootDrive, searchIndexDAO, ch)
 	if err != nil {
 		return nil, err
 	}
 	signer := utils.NewSigner()
-	chunkUploader, err := server.NewChunkUploader(config)
+	chunkUploader, err := server.NewChunkUploader(config, ch)
+	if err != nil {
+		return nil, err
+	}
+	deleteCheckpoints, err := server.NewDeleteCheckpoints(config, ch)
 	if err != nil {
 		return nil, err
 	}
@@ -54,10 +79,24 @@ func Initialize(ctx context.Context, ch *registry.ComponentsHolder) (*gin.Engine
 	userDAO := storage.NewUserDAO(db)
 	groupDAO := storage.NewGroupDAO(db)
 	pathPermissionDAO := storage.NewPathPermissionDAO(db)
+	shareDAO := storage.NewShareDAO(db)
 	fileMessageSource, err := i18n.NewFileMessageSource(config)
 	if err != nil {
 		return nil, err
 	}
-	engine := server.InitServer(config, ch, rootDrive, fileTokenStore, thumbnail, signer, chunkUploader, tunnyRunner, userDAO, groupDAO, driveDAO, driveCacheDAO, driveDataDAO, pathPermissionDAO, pathMountDAO, fileMessageSource)
-	return engine, nil
+	engine := server.InitServer(config, ch, rootDrive, fileTokenStore, memIdempotencyStore, fileAuditSink, thumbnail, hls, mediaInfo, search, signer, chunkUploader, deleteCheckpoints, tunnyRunner, userDAO, groupDAO, driveDAO, driveCacheDAO, driveDataDAO, pathPermissionDAO, pathMountDAO, shareDAO, fileMessageSource)
+	ftpServer, err := server.NewFTPServer(config, rootDrive, pathPermissionDAO, signer, fileAuditSink, userDAO, ch)
+	if err != nil {
+		return nil, err
+	}
+	sftpServer, err := server.NewSFTPServer(config, rootDrive, pathPermissionDAO, signer, fileAuditSink, userDAO, ch)
+	if err != nil {
+		return nil, err
+	}
+	app := &App{
+		Engine: engine,
+		FTP:    ftpServer,
+		SFTP:   sftpServer,
+	}
+	return app, nil
 }